@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/config"
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// number of pings used to measure the latency to the relay server.
+const BENCH_PINGS = 5
+
+// ------------------------------------------------------- Bench -------------------------------------------------------
+
+func Bench() *cobra.Command {
+	benchCmd := &cobra.Command{
+		Use:   "bench [password]",
+		Short: "Benchmark the throughput between a sender and a receiver",
+		Long: "The bench command transfers synthetic data to measure the throughput of the path between a sender and a receiver. " +
+			"Run without a password to send, and with the password on the receiving end. " +
+			"The data is generated on the fly and discarded on receipt, isolating the network and relay from disk I/O.",
+		Args: cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("relay", cmd.Flags().Lookup("relay")); err != nil {
+				return fmt.Errorf("binding relay flag: %w", err)
+			}
+			if err := viper.BindPFlag("dns_server", cmd.Flags().Lookup("dns-server")); err != nil {
+				return fmt.Errorf("binding dns-server flag: %w", err)
+			}
+			if err := viper.BindPFlag("proxy", cmd.Flags().Lookup("proxy")); err != nil {
+				return fmt.Errorf("binding proxy flag: %w", err)
+			}
+			if err := validateProxy(); err != nil {
+				return err
+			}
+			if err := bindRelayTLSFlags(cmd); err != nil {
+				return err
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveRelayFromViper(cmd.Context()); err != nil {
+				return err
+			}
+			relayAddr := viper.GetString("relay")
+			if len(args) == 1 {
+				result, err := benchReceive(cmd.Context(), relayAddr, args[0], dialOptionsFromViper()...)
+				if err != nil {
+					return fmt.Errorf("running benchmark: %w", err)
+				}
+				fmt.Printf("received %s\n", result)
+				return nil
+			}
+			sizeFlag, _ := cmd.Flags().GetString("size")
+			size, err := parseSize(sizeFlag)
+			if err != nil {
+				return err
+			}
+			result, err := benchSend(cmd.Context(), relayAddr, size, func(password string) {
+				fmt.Fprintf(os.Stderr, "on the receiving end run: %s\n", benchReceiverCommand(password))
+			}, dialOptionsFromViper()...)
+			if err != nil {
+				return fmt.Errorf("running benchmark: %w", err)
+			}
+			fmt.Printf("sent %s\n", result)
+			return nil
+		},
+	}
+	benchCmd.Flags().StringP("relay", "r", "", relayFlagDesc)
+	benchCmd.Flags().String("dns-server", "", dnsServerFlagDesc)
+	benchCmd.Flags().String("proxy", "", proxyFlagDesc)
+	registerRelayTLSFlags(benchCmd)
+	benchCmd.Flags().String("size", "100MB", "Size of the synthetic data sent (e.g. 500kB, 100MB, 1GiB)")
+	registerRelayCompletion(benchCmd)
+	return benchCmd
+}
+
+// ---------------------------------------------------- Benchmarks -----------------------------------------------------
+
+// benchResult describes the measured performance of a benchmark transfer.
+type benchResult struct {
+	bytes    int64
+	elapsed  time.Duration
+	latency  time.Duration // median round trip time to the relay server
+	transfer transfer.Type
+}
+
+// throughput returns the sustained throughput in bytes per second.
+func (r benchResult) throughput() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.bytes) / r.elapsed.Seconds()
+}
+
+func (r benchResult) String() string {
+	path := "relayed"
+	if r.transfer == transfer.Direct {
+		path = "direct"
+	}
+	return fmt.Sprintf("%s in %s (%s/s) over a %s connection, relay latency %s",
+		tui.ByteCountSI(r.bytes), r.elapsed.Round(time.Millisecond), tui.ByteCountSI(int64(r.throughput())),
+		path, r.latency.Round(time.Microsecond))
+}
+
+// benchSend sends size bytes of synthetic data, calling onPassword with the password of the transfer
+// once connected to the relay server.
+func benchSend(ctx context.Context, relayAddr string, size int64, onPassword func(string), opts ...conn.DialOption) (benchResult, error) {
+	latency, err := relayLatency(ctx, conn.HTTPClient(opts...), relayAddr)
+	if err != nil {
+		return benchResult{}, err
+	}
+	rc, password, err := sender.ConnectRendezvous(ctx, relayAddr, opts...)
+	if err != nil {
+		return benchResult{}, fmt.Errorf("connecting to relay: %w", err)
+	}
+	onPassword(password)
+	tc, err := sender.SecureConnection(ctx, rc, password)
+	if err != nil {
+		return benchResult{}, fmt.Errorf("performing handshake: %w", err)
+	}
+
+	result := benchResult{bytes: size, latency: latency}
+	var start time.Time
+	msgs := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			switch msg := msg.(type) {
+			case transfer.Type:
+				result.transfer = msg
+			case transfer.MsgType:
+				if msg == transfer.ReceiverRequestPayload {
+					start = time.Now()
+				}
+			}
+		}
+	}()
+	payload := io.LimitReader(rand.New(rand.NewSource(time.Now().UnixNano())), size)
+	err = sender.Transfer(ctx, tc, payload, size, sender.WithMessages(msgs))
+	close(msgs)
+	<-done
+	if err != nil {
+		return benchResult{}, err
+	}
+	result.elapsed = time.Since(start)
+	return result, nil
+}
+
+// benchReceive receives the synthetic data of a benchmark, discarding it.
+func benchReceive(ctx context.Context, relayAddr, password string, opts ...conn.DialOption) (benchResult, error) {
+	latency, err := relayLatency(ctx, conn.HTTPClient(opts...), relayAddr)
+	if err != nil {
+		return benchResult{}, err
+	}
+	rc, err := receiver.ConnectRendezvous(relayAddr, opts...)
+	if err != nil {
+		return benchResult{}, fmt.Errorf("connecting to relay: %w", err)
+	}
+	tc, err := receiver.SecureConnection(ctx, rc, password)
+	if err != nil {
+		return benchResult{}, fmt.Errorf("performing handshake: %w", err)
+	}
+
+	result := benchResult{latency: latency}
+	var start time.Time
+	msgs := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			if t, ok := msg.(transfer.Type); ok {
+				result.transfer = t
+				start = time.Now()
+			}
+		}
+	}()
+	dst := &countingWriter{}
+	err = receiver.Receive(ctx, tc, dst, receiver.WithMessages(msgs))
+	close(msgs)
+	<-done
+	if err != nil {
+		return benchResult{}, err
+	}
+	result.bytes = dst.n
+	result.elapsed = time.Since(start)
+	return result, nil
+}
+
+// relayLatency returns the median round trip time of pinging the relay server.
+func relayLatency(ctx context.Context, client *http.Client, relayAddr string) (time.Duration, error) {
+	rtts := make([]time.Duration, 0, BENCH_PINGS)
+	for i := 0; i < BENCH_PINGS; i++ {
+		start := time.Now()
+		if err := pingRelay(ctx, client, relayAddr); err != nil {
+			return 0, fmt.Errorf("measuring relay latency: %w", err)
+		}
+		rtts = append(rtts, time.Since(start))
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	return rtts[len(rtts)/2], nil
+}
+
+// benchReceiverCommand returns the command used to receive the benchmark with the provided password.
+func benchReceiverCommand(password string) string {
+	var b strings.Builder
+	b.WriteString("portal bench ")
+	b.WriteString(password)
+	if !config.IsDefault("relay") {
+		b.WriteString(" --relay ")
+		b.WriteString(viper.GetString("relay"))
+	}
+	return b.String()
+}
+
+// countingWriter discards the bytes written to it, counting them.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return len(b), nil
+}
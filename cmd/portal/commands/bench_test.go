@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	const size = 1 << 20
+	passwords := make(chan string, 1)
+	type sent struct {
+		result benchResult
+		err    error
+	}
+	sentC := make(chan sent, 1)
+	go func() {
+		result, err := benchSend(ctx, addr, size, func(password string) { passwords <- password })
+		sentC <- sent{result, err}
+	}()
+
+	var password string
+	select {
+	case password = <-passwords:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the benchmark password")
+	}
+	received, err := benchReceive(ctx, addr, password)
+	require.NoError(t, err)
+	s := <-sentC
+	require.NoError(t, s.err)
+
+	for _, result := range []benchResult{s.result, received} {
+		assert.Equal(t, int64(size), result.bytes)
+		assert.Greater(t, result.throughput(), 0.0)
+		assert.Greater(t, result.latency, time.Duration(0))
+		assert.Contains(t, []transfer.Type{transfer.Direct, transfer.Relay}, result.transfer)
+	}
+	assert.Equal(t, s.result.transfer, received.transfer)
+}
@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// completionShell describes how the completion script of a shell is generated and installed.
+type completionShell struct {
+	name    string
+	install string // instructions for loading the completion script
+	gen     func(root *cobra.Command, out io.Writer) error
+}
+
+// shells for which completion scripts are generated.
+var completionShells = []completionShell{
+	{
+		name: "bash",
+		install: `To load completions in the current session, run:
+  source <(portal completion bash)
+
+To load completions for every new session, run once:
+  Linux: portal completion bash > /etc/bash_completion.d/portal
+  macOS: portal completion bash > $(brew --prefix)/etc/bash_completion.d/portal
+
+Requires the bash-completion package.`,
+		gen: func(root *cobra.Command, out io.Writer) error { return root.GenBashCompletionV2(out, true) },
+	},
+	{
+		name: "zsh",
+		install: `If shell completion is not already enabled, enable it once with:
+  echo "autoload -U compinit; compinit" >> ~/.zshrc
+
+To load completions in the current session, run:
+  source <(portal completion zsh)
+
+To load completions for every new session, run once:
+  portal completion zsh > "${fpath[1]}/_portal"`,
+		gen: func(root *cobra.Command, out io.Writer) error { return root.GenZshCompletion(out) },
+	},
+	{
+		name: "fish",
+		install: `To load completions in the current session, run:
+  portal completion fish | source
+
+To load completions for every new session, run once:
+  portal completion fish > ~/.config/fish/completions/portal.fish`,
+		gen: func(root *cobra.Command, out io.Writer) error { return root.GenFishCompletion(out, true) },
+	},
+	{
+		name: "powershell",
+		install: `To load completions in the current session, run:
+  portal completion powershell | Out-String | Invoke-Expression
+
+To load completions for every new session, add the output of the above command to your powershell profile.`,
+		gen: func(root *cobra.Command, out io.Writer) error { return root.GenPowerShellCompletionWithDesc(out) },
+	},
+}
+
+// ----------------------------------------------------- Completion ----------------------------------------------------
+
+func Completion() *cobra.Command {
+	names := make([]string, 0, len(completionShells))
+	for _, shell := range completionShells {
+		names = append(names, shell.name)
+	}
+	completionCmd := &cobra.Command{
+		Use:   fmt.Sprintf("completion [%s]", strings.Join(names, "|")),
+		Short: "Generate the shell completion script",
+		Long:  "The completion command writes the completion script of the provided shell to stdout, see the help of each shell for how to load it.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+	for _, shell := range completionShells {
+		shell := shell
+		completionCmd.AddCommand(&cobra.Command{
+			Use:                   shell.name,
+			Short:                 fmt.Sprintf("Generate the completion script for %s", shell.name),
+			Long:                  fmt.Sprintf("Generate the completion script for %s.\n\n%s", shell.name, shell.install),
+			Args:                  cobra.NoArgs,
+			DisableFlagsInUseLine: true,
+			ValidArgsFunction:     cobra.NoFileCompletions,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return shell.gen(cmd.Root(), cmd.OutOrStdout())
+			},
+		})
+	}
+	return completionCmd
+}
+
+// relayCompletion completes the relay flag with the relays of the config file, both the configured
+// list of relays and each of its addresses.
+func relayCompletion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	relay := viper.GetString("relay")
+	relays := conn.SplitAddrs(relay)
+	if len(relays) > 1 {
+		relays = append([]string{relay}, relays...)
+	}
+	return filterPrefix(relays, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// registerRelayCompletion completes the relay flag of the command with the relays of the config file.
+func registerRelayCompletion(cmd *cobra.Command) {
+	cmd.RegisterFlagCompletionFunc("relay", relayCompletion) //nolint:errcheck
+}
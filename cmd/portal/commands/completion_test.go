@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range completionShells {
+		t.Run(shell.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "portal"}
+			root.AddCommand(Send("v1.0.0"), Receive("v1.0.0"), Completion())
+			var out bytes.Buffer
+			root.SetOut(&out)
+			root.SetArgs([]string{"completion", shell.name})
+			require.NoError(t, root.Execute())
+			assert.NotEmpty(t, out.String())
+			assert.Contains(t, out.String(), "portal")
+		})
+	}
+	t.Run("unknown shell", func(t *testing.T) {
+		root := &cobra.Command{Use: "portal"}
+		root.AddCommand(Completion())
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs([]string{"completion", "tcsh"})
+		assert.Error(t, root.Execute())
+	})
+}
+
+func TestRelayCompletion(t *testing.T) {
+	t.Cleanup(func() { viper.Set("relay", nil) })
+
+	viper.Set("relay", "myrelay.io")
+	relays, directive := relayCompletion(nil, nil, "")
+	assert.Equal(t, []string{"myrelay.io"}, relays)
+	assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+
+	viper.Set("relay", "myrelay.io,backup.myrelay.io:8080")
+	relays, _ = relayCompletion(nil, nil, "")
+	assert.Equal(t, []string{"myrelay.io,backup.myrelay.io:8080", "myrelay.io", "backup.myrelay.io:8080"}, relays)
+	relays, _ = relayCompletion(nil, nil, "back")
+	assert.Equal(t, []string{"backup.myrelay.io:8080"}, relays)
+}
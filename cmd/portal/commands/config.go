@@ -75,11 +75,33 @@ func Config() *cobra.Command {
 			return nil
 		},
 	}
+	validateCmd := &cobra.Command{
+		Use:   "validate [path]",
+		Short: "Validate the configuration file without running any command",
+		Long: "The validate command loads the configuration file, or the file at the provided path, and validates the " +
+			"configured options, exiting with a non-zero status and a report of every problem found.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configPath := viper.ConfigFileUsed()
+			if len(args) == 1 {
+				configPath = args[0]
+			}
+			errs := config.Validate(configPath)
+			if len(errs) == 0 {
+				fmt.Printf("config file (%s) is valid\n", configPath)
+				return nil
+			}
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, "- %v\n", err)
+			}
+			return fmt.Errorf("config file (%s) has %d problem(s)", configPath, len(errs))
+		},
+	}
 	configCmd := &cobra.Command{
 		Use:       "config",
 		Short:     "View and configure options",
 		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
-		ValidArgs: []string{pathCmd.Name(), viewCmd.Name(), editCmd.Name(), resetCmd.Name()},
+		ValidArgs: []string{pathCmd.Name(), viewCmd.Name(), editCmd.Name(), resetCmd.Name(), validateCmd.Name()},
 		Run:       func(cmd *cobra.Command, args []string) {},
 	}
 
@@ -87,6 +109,7 @@ func Config() *cobra.Command {
 	configCmd.AddCommand(viewCmd)
 	configCmd.AddCommand(editCmd)
 	configCmd.AddCommand(resetCmd)
+	configCmd.AddCommand(validateCmd)
 
 	return configCmd
 }
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+)
+
+// ------------------------------------------------------- Events ------------------------------------------------------
+
+const jsonFlagDesc = "Disable the TUI and report the transfer as line-delimited JSON events on stdout, e.g. for scripts and CI"
+
+// Types of the events reported with --json.
+const (
+	EVENT_PASSWORD  = "password"  // the sender claimed its password
+	EVENT_CONNECTED = "connected" // the connection to the peer is secured
+	EVENT_PROGRESS  = "progress"  // bytes of the payload were transferred
+	EVENT_TEXT      = "text"      // the receiver received a text message
+	EVENT_COMPLETED = "completed" // the transfer completed
+	EVENT_ERROR     = "error"     // the command failed
+)
+
+// event is a single line of the JSON output, fields not applying to the type of the event are omitted.
+type event struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	Password    string    `json:"password,omitempty"`    // password as printed by the sender
+	Fingerprint string    `json:"fingerprint,omitempty"` // fingerprint of the connection, shared by both peers
+	Bytes       int64     `json:"bytes,omitempty"`       // bytes transferred
+	Total       int64     `json:"total,omitempty"`       // bytes of the payload, zero if unknown
+	Percent     float64   `json:"percent,omitempty"`     // percentage of the payload transferred, zero if unknown
+	Rate        float64   `json:"rate,omitempty"`        // bytes per second since the previous progress event
+	Text        string    `json:"text,omitempty"`        // received text message
+	Checksum    string    `json:"checksum,omitempty"`    // checksum of the payload as algorithm:sum, e.g. sha256:9f86d0...
+	Error       string    `json:"error,omitempty"`
+	ExitCode    int       `json:"exit_code,omitempty"` // exit code of the command, see the EXIT_ constants
+}
+
+// jsonEvents writes the events of a transfer as line-delimited JSON, progress at most once per interval. All
+// methods are no-ops on nil events, such that the events are only reported if enabled.
+type jsonEvents struct {
+	interval time.Duration
+
+	mu          sync.Mutex
+	enc         *json.Encoder
+	transferred int64
+	total       int64
+	reported    int64     // bytes transferred as of the previous progress event
+	reportedAt  time.Time // time of the previous progress event
+	checksum    checksum.Result
+}
+
+func newJSONEvents(out io.Writer) *jsonEvents {
+	return &jsonEvents{interval: progressReportInterval, enc: json.NewEncoder(out), reportedAt: time.Now()}
+}
+
+// reportOutcome reports the transfer completed, or the command failed with err.
+func reportOutcome(events *jsonEvents, err error) {
+	if err != nil {
+		events.Error(err)
+		return
+	}
+	events.Completed()
+}
+
+// emit writes the event, stamped with the current time.
+func (e *jsonEvents) emit(ev event) {
+	ev.Time = time.Now()
+	e.enc.Encode(ev) //nolint:errcheck
+}
+
+// Password reports the password claimed by the sender.
+func (e *jsonEvents) Password(password string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(event{Event: EVENT_PASSWORD, Password: password})
+}
+
+// Connected reports the fingerprint of the secured connection to the peer.
+func (e *jsonEvents) Connected(fingerprint string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(event{Event: EVENT_CONNECTED, Fingerprint: fingerprint})
+}
+
+// Progress records the bytes transferred, reported once the interval passed or the payload is transferred in full.
+func (e *jsonEvents) Progress(transferred, total int64) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.transferred, e.total = transferred, total
+	if time.Since(e.reportedAt) < e.interval && (total == 0 || transferred < total) {
+		return
+	}
+	e.emitProgress(EVENT_PROGRESS)
+}
+
+// emitProgress writes an event of the provided type carrying the recorded progress.
+func (e *jsonEvents) emitProgress(typ string) {
+	ev := event{Event: typ, Bytes: e.transferred, Total: e.total}
+	if typ == EVENT_COMPLETED && e.checksum.Sum != "" {
+		ev.Checksum = e.checksum.String()
+	}
+	if e.total > 0 {
+		ev.Percent = 100 * float64(e.transferred) / float64(e.total)
+	}
+	ev.Rate = transferRate(e.transferred, e.reported, time.Since(e.reportedAt))
+	e.reported, e.reportedAt = e.transferred, time.Now()
+	e.emit(ev)
+}
+
+// Checksum records the checksum of the payload, reported once the transfer completed.
+func (e *jsonEvents) Checksum(sum checksum.Result) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checksum = sum
+}
+
+// Text reports the text message received.
+func (e *jsonEvents) Text(text string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(event{Event: EVENT_TEXT, Text: text})
+}
+
+// Completed reports the completed transfer, along with the bytes transferred and the checksum of the payload.
+func (e *jsonEvents) Completed() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emitProgress(EVENT_COMPLETED)
+}
+
+// Error reports the failure of the command, along with its exit code.
+func (e *jsonEvents) Error(err error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(event{Event: EVENT_ERROR, Error: err.Error(), ExitCode: ExitCode(err)})
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONEvents(t *testing.T) {
+	decode := func(t *testing.T, out *bytes.Buffer) []event {
+		var events []event
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			var ev event
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev), "each line should be a JSON event")
+			events = append(events, ev)
+		}
+		return events
+	}
+
+	t.Run("transfer", func(t *testing.T) {
+		var out bytes.Buffer
+		events := newJSONEvents(&out)
+		events.interval = time.Hour
+		events.Password("1-foo-bar-baz")
+		events.Connected("3F2A-9C01")
+		for n := int64(100); n <= 1000; n += 100 {
+			events.Progress(n, 1000)
+		}
+		events.Checksum(checksum.Result{Algorithm: "sha256", Sum: "9f86d0"})
+		reportOutcome(events, nil)
+
+		reported := decode(t, &out)
+		require.Len(t, reported, 4, "progress should be throttled, except once transferred in full")
+		assert.Equal(t, EVENT_PASSWORD, reported[0].Event)
+		assert.Equal(t, "1-foo-bar-baz", reported[0].Password)
+		assert.Equal(t, EVENT_CONNECTED, reported[1].Event)
+		assert.Equal(t, "3F2A-9C01", reported[1].Fingerprint)
+		assert.Equal(t, EVENT_PROGRESS, reported[2].Event)
+		assert.Equal(t, int64(1000), reported[2].Bytes)
+		assert.Equal(t, float64(100), reported[2].Percent)
+		assert.Positive(t, reported[2].Rate)
+		assert.Equal(t, EVENT_COMPLETED, reported[3].Event)
+		assert.Equal(t, int64(1000), reported[3].Bytes)
+		assert.Equal(t, "sha256:9f86d0", reported[3].Checksum)
+		assert.Empty(t, reported[2].Checksum)
+		assert.False(t, reported[3].Time.IsZero())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var out bytes.Buffer
+		reportOutcome(newJSONEvents(&out), usageErrorf("invalid password format"))
+		reported := decode(t, &out)
+		require.Len(t, reported, 1)
+		assert.Equal(t, EVENT_ERROR, reported[0].Event)
+		assert.Equal(t, "invalid password format", reported[0].Error)
+		assert.Equal(t, EXIT_USAGE, reported[0].ExitCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var events *jsonEvents
+		reportOutcome(events, errors.New("failed"))
+		events.Progress(1, 1)
+	})
+}
@@ -0,0 +1,145 @@
+// exitcode.go specifies the exit codes of the portal commands, a stable contract that scripts can branch on to tell
+// the causes of a failure apart.
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+	"nhooyr.io/websocket"
+)
+
+// Exit codes of the portal commands. The codes are stable, new causes of failures are given new codes.
+const (
+	EXIT_SUCCESS          = 0 // the command succeeded
+	EXIT_FAILURE          = 1 // the command failed for a cause without an exit code of its own
+	EXIT_USAGE            = 2 // invalid flags, arguments or config
+	EXIT_AUTH             = 3 // the peer or the relay failed to authenticate, e.g. a receiver rejected by the sender
+	EXIT_VERSION_MISMATCH = 4 // the version of the relay is incompatible
+	EXIT_TIMEOUT          = 5 // no peer connected in time, e.g. the code is unknown or expired
+	EXIT_CHECKSUM         = 6 // the received payload does not match the checksum of the sender
+	EXIT_NETWORK          = 7 // the relay or the peer is unreachable, or the connection was lost
+)
+
+// UsageError is an error in the invocation of a command, e.g. an unknown flag or a malformed argument.
+type UsageError struct {
+	Err error
+}
+
+func (e UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e UsageError) Unwrap() error {
+	return e.Err
+}
+
+// usageErrorf formats an error as a UsageError.
+func usageErrorf(format string, a ...any) error {
+	return UsageError{Err: fmt.Errorf(format, a...)}
+}
+
+// timeoutReasons are the close reasons of the rendezvous server for peers that did not connect in time.
+var timeoutReasons = []string{protocol.CODE_EXPIRED, protocol.CODE_UNKNOWN, protocol.HANDSHAKE_TIMED_OUT, protocol.TRANSFER_IDLE, protocol.MAILBOX_EXPIRED}
+
+// ExitCode returns the exit code of the error returned by a command, see the EXIT_ constants.
+func ExitCode(err error) int {
+	var (
+		usageErr UsageError
+		closeErr websocket.CloseError
+		netErr   net.Error
+	)
+	switch {
+	case err == nil:
+		return EXIT_SUCCESS
+	case errors.As(err, &usageErr):
+		return EXIT_USAGE
+	case errors.Is(err, semver.ErrIncompatible):
+		return EXIT_VERSION_MISMATCH
+	case errors.Is(err, checksum.ErrMismatch):
+		return EXIT_CHECKSUM
+	case errors.Is(err, conn.ErrAuthentication),
+		errors.Is(err, conn.ErrUnauthorized),
+		errors.Is(err, sender.ErrReceiverRejected),
+		errors.Is(err, file.ErrSignatureInvalid):
+		return EXIT_AUTH
+	case errors.Is(err, receiver.ErrCodeExpired),
+		errors.Is(err, receiver.ErrCodeUnknown),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &closeErr) && slices.Contains(timeoutReasons, closeErr.Reason),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return EXIT_TIMEOUT
+	case errors.As(err, &netErr),
+		errors.As(err, &closeErr) && closeErr.Reason == protocol.SERVER_CLOSING,
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed):
+		return EXIT_NETWORK
+	default:
+		return EXIT_FAILURE
+	}
+}
+
+// WithUsageErrors reports the errors of parsing the flags and validating the arguments of the command, and of
+// its subcommands, as UsageErrors. Unknown subcommands of commands without arguments are reported likewise.
+func WithUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return UsageError{Err: err}
+	})
+	var wrap func(c *cobra.Command)
+	wrap = func(c *cobra.Command) {
+		args := c.Args
+		if args == nil && c.HasSubCommands() && !c.Runnable() {
+			args = unknownCommand
+			c.RunE = func(cmd *cobra.Command, _ []string) error {
+				return cmd.Help()
+			}
+		}
+		c.Args = func(cmd *cobra.Command, a []string) error {
+			if args != nil {
+				if err := args(cmd, a); err != nil {
+					return UsageError{Err: err}
+				}
+			}
+			// flag groups are validated by cobra after the pre-run hooks, they are validated up front to report
+			// them as usage errors.
+			if err := cmd.ValidateRequiredFlags(); err != nil {
+				return UsageError{Err: err}
+			}
+			if err := cmd.ValidateFlagGroups(); err != nil {
+				return UsageError{Err: err}
+			}
+			return nil
+		}
+		for _, sub := range c.Commands() {
+			wrap(sub)
+		}
+	}
+	wrap(cmd)
+}
+
+// unknownCommand rejects the arguments of commands that only run subcommands, suggesting the closest subcommands.
+func unknownCommand(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("unknown command %q for %q", args[0], cmd.CommandPath())
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %q?", suggestions[0])
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, EXIT_SUCCESS},
+		{"unclassified", errors.New("something went wrong"), EXIT_FAILURE},
+		{"usage", fmt.Errorf("send: %w", usageErrorf("invalid tui style provided")), EXIT_USAGE},
+		{"version mismatch", fmt.Errorf("%w version 1.0.0 -> 2.0.0", semver.ErrIncompatible), EXIT_VERSION_MISMATCH},
+		{"checksum mismatch", fmt.Errorf("verifying payload: %w", checksum.ErrMismatch), EXIT_CHECKSUM},
+		{"decryption", fmt.Errorf("decrypting message: %w", conn.ErrAuthentication), EXIT_AUTH},
+		{"unauthorized", fmt.Errorf("%w: 401", conn.ErrUnauthorized), EXIT_AUTH},
+		{"receiver rejected", sender.ErrReceiverRejected, EXIT_AUTH},
+		{"deadline", fmt.Errorf("waiting for receiver: %w", context.DeadlineExceeded), EXIT_TIMEOUT},
+		{"handshake timeout", websocket.CloseError{Code: websocket.StatusCode(conn.CLOSE_FAILED), Reason: protocol.HANDSHAKE_TIMED_OUT}, EXIT_TIMEOUT},
+		{"dial timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, EXIT_TIMEOUT},
+		{"connection refused", fmt.Errorf("connecting to relay: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), EXIT_NETWORK},
+		{"connection lost", fmt.Errorf("reading message: %w", io.ErrUnexpectedEOF), EXIT_NETWORK},
+		{"relay shutting down", websocket.CloseError{Code: websocket.StatusCode(conn.CLOSE_FAILED), Reason: protocol.SERVER_CLOSING}, EXIT_NETWORK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ExitCode(tc.err))
+		})
+	}
+}
+
+func TestExitCodeUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown flag", []string{"receive", "--frog", "1-foo-bar-baz"}},
+		{"unknown command", []string{"recieve"}},
+		{"missing argument", []string{"receive"}},
+		{"invalid password", []string{"receive", "not-a-password"}},
+		{"invalid tui style", []string{"receive", "--relay", "localhost:1", "--tui-style", "fancy", "1-foo-bar-baz"}},
+		{"invalid subprotocol", []string{"serve", "--subprotocols", "no spaces allowed"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "portal"}
+			root.AddCommand(Receive("v1.0.0"), Serve("v1.0.0"))
+			WithUsageErrors(root)
+			root.SetOut(&bytes.Buffer{})
+			root.SetErr(&bytes.Buffer{})
+			root.SetArgs(tc.args)
+			assert.Equal(t, EXIT_USAGE, ExitCode(root.Execute()))
+		})
+	}
+}
+
+func TestExitCodeVersionMismatch(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(semver.Version{Major: 2}) //nolint:errcheck
+	}))
+	defer relay.Close()
+	addr := strings.TrimPrefix(relay.URL, "http://")
+
+	err := verifyRelayVersion(context.Background(), semver.Version{Major: 1}, addr, false, io.Discard)
+	assert.Equal(t, EXIT_VERSION_MISMATCH, ExitCode(err))
+}
+
+func TestExitCodeNoPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	cnf := portal.Config{RendezvousAddr: fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)}
+
+	err := portal.Receive(ctx, io.Discard, "1-foo-bar-baz", &cnf)
+	assert.Equal(t, EXIT_TIMEOUT, ExitCode(err))
+}
+
+func TestExitCodeNetwork(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	err = portal.Receive(context.Background(), io.Discard, "1-foo-bar-baz", &portal.Config{RendezvousAddr: addr})
+	assert.Equal(t, EXIT_NETWORK, ExitCode(err))
+}
@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	t.Run("rfc3339", func(t *testing.T) {
+		since, err := parseSince("2023-05-31T12:00:00Z", now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(-24*time.Hour), since.UTC())
+	})
+	t.Run("relative", func(t *testing.T) {
+		since, err := parseSince("24h", now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(-24*time.Hour), since)
+	})
+	t.Run("negative duration", func(t *testing.T) {
+		_, err := parseSince("-24h", now)
+		assert.Error(t, err)
+	})
+	t.Run("garbage", func(t *testing.T) {
+		_, err := parseSince("yesterday", now)
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyRelayVersion(t *testing.T) {
+	ver := semver.Version{Major: 1}
+	malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("garbage")) //nolint:errcheck
+	}))
+	defer malformed.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	for _, tc := range []struct {
+		name string
+		addr string
+	}{
+		{"malformed", strings.TrimPrefix(malformed.URL, "http://")},
+		{"unreachable", strings.TrimPrefix(unreachable.URL, "http://")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Run("strict", func(t *testing.T) {
+				out := &bytes.Buffer{}
+				assert.Error(t, verifyRelayVersion(context.Background(), ver, tc.addr, true, out))
+			})
+			t.Run("lenient", func(t *testing.T) {
+				out := &bytes.Buffer{}
+				assert.NoError(t, verifyRelayVersion(context.Background(), ver, tc.addr, false, out))
+				assert.Contains(t, out.String(), "unable to verify relay version")
+			})
+		})
+	}
+}
+
+func TestResolveRelay(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	healthyAddr := strings.TrimPrefix(healthy.URL, "http://")
+	deadAddr := strings.TrimPrefix(dead.URL, "http://")
+
+	t.Run("dead primary", func(t *testing.T) {
+		relay, err := resolveRelay(context.Background(), http.DefaultClient, []string{deadAddr, healthyAddr})
+		assert.NoError(t, err)
+		assert.Equal(t, healthyAddr, relay)
+	})
+	t.Run("healthy primary", func(t *testing.T) {
+		relay, err := resolveRelay(context.Background(), http.DefaultClient, []string{healthyAddr, deadAddr})
+		assert.NoError(t, err)
+		assert.Equal(t, healthyAddr, relay)
+	})
+	t.Run("single relay is not probed", func(t *testing.T) {
+		relay, err := resolveRelay(context.Background(), http.DefaultClient, []string{deadAddr})
+		assert.NoError(t, err)
+		assert.Equal(t, deadAddr, relay)
+	})
+	t.Run("no reachable relay", func(t *testing.T) {
+		_, err := resolveRelay(context.Background(), http.DefaultClient, []string{deadAddr, deadAddr})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		size int64
+	}{
+		{"1048576", 1 << 20},
+		{"500kB", 500e3},
+		{"100MB", 100e6},
+		{"1.5 GB", 1.5e9},
+		{"1GiB", 1 << 30},
+	} {
+		size, err := parseSize(tc.in)
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.size, size, tc.in)
+	}
+	for _, in := range []string{"", "MB", "-1MB", "ten"} {
+		_, err := parseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for in, rate := range map[string]int64{"10MB/s": 10e6, "512KiB/s": 512 << 10, "1MB": 1e6, "4096": 4096} {
+		n, err := parseRate(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, rate, n, in)
+	}
+	for _, in := range []string{"/s", "0MB/s", "fast"} {
+		_, err := parseRate(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestChunkSizeFromViper(t *testing.T) {
+	t.Cleanup(func() { viper.Set("chunk_size", nil) })
+	for in, size := range map[string]int64{"": 0, "auto": transfer.CHUNK_SIZE_ADAPTIVE, "4MB": 4e6, "256kB": transfer.MIN_CHUNK_BYTES} {
+		viper.Set("chunk_size", in)
+		n, err := chunkSizeFromViper()
+		assert.NoError(t, err, in)
+		assert.Equal(t, size, n, in)
+	}
+	for _, in := range []string{"100kB", "16MB", "adaptive"} {
+		viper.Set("chunk_size", in)
+		_, err := chunkSizeFromViper()
+		assert.Error(t, err, in)
+	}
+}
+
+func TestCollisionFromViper(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("on_collision", nil)
+		viper.Set("prompt_overwrite_files", nil)
+	})
+	for _, c := range []struct {
+		collision string
+		prompt    bool
+		expected  file.Collision
+	}{
+		{"", true, file.CollisionPrompt},
+		{"", false, file.CollisionOverwrite},
+		{"prompt", false, file.CollisionOverwrite},
+		{"rename", true, file.CollisionRename},
+		{"skip", false, file.CollisionSkip},
+	} {
+		viper.Set("on_collision", c.collision)
+		viper.Set("prompt_overwrite_files", c.prompt)
+		collision, err := collisionFromViper()
+		assert.NoError(t, err, c.collision)
+		assert.Equal(t, c.expected, collision, c.collision)
+	}
+	viper.Set("on_collision", "clobber")
+	_, err := collisionFromViper()
+	assert.ErrorContains(t, err, "on_collision")
+}
+
+func TestPrintMOTD(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithMOTD("Maintenance tonight \x1b[31mat 22:00\x07"))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	t.Run("displayed", func(t *testing.T) {
+		var out bytes.Buffer
+		printMOTD(ctx, http.DefaultClient, addr, &out)
+		assert.Contains(t, out.String(), "Maintenance tonight [31mat 22:00")
+		assert.NotContains(t, out.String(), "\x1b")
+		assert.NotContains(t, out.String(), "\x07")
+	})
+	t.Run("relay without info", func(t *testing.T) {
+		old := httptest.NewServer(http.NotFoundHandler())
+		defer old.Close()
+		var out bytes.Buffer
+		printMOTD(ctx, http.DefaultClient, strings.TrimPrefix(old.URL, "http://"), &out)
+		assert.Empty(t, out.String())
+	})
+}
@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/history"
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ------------------------------------------------------ History ------------------------------------------------------
+
+func History() *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the past sends and receives of this machine",
+		Long: "The history command lists the transfers recorded in the local history, encrypted at rest in the config directory. " +
+			"The files of a past send are sent again with portal send --again <id>. Disable the history with 'history: false' in the config file.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := history.OpenDefault()
+			if err != nil {
+				return err
+			}
+			if clear, _ := cmd.Flags().GetBool("clear"); clear {
+				return store.Clear()
+			}
+			entries, err := store.List()
+			if errors.Is(err, history.ErrCorrupt) {
+				return fmt.Errorf("%w, it is reset by the next transfer or by portal history --clear", err)
+			}
+			if err != nil {
+				return err
+			}
+			if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && len(entries) > limit {
+				entries = entries[len(entries)-limit:]
+			}
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(entries)
+			}
+			printHistory(cmd.OutOrStdout(), entries)
+			return nil
+		},
+	}
+	historyCmd.Flags().Int("limit", 0, "Only list the provided number of most recent transfers (0 lists every transfer)")
+	historyCmd.Flags().Bool("json", false, "List the transfers as a JSON array")
+	historyCmd.Flags().Bool("clear", false, "Remove the history along with its key")
+	historyCmd.MarkFlagsMutuallyExclusive("clear", "json")
+	historyCmd.MarkFlagsMutuallyExclusive("clear", "limit")
+	return historyCmd
+}
+
+// printHistory prints the entries as a table, oldest first.
+func printHistory(out io.Writer, entries []history.Entry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "no transfers recorded")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED\tDIRECTION\tSIZE\tTRANSPORT\tOUTCOME\tFILES")
+	for _, e := range entries {
+		transport := e.Transport
+		if transport == "" {
+			transport = "-"
+		}
+		outcome := e.Outcome
+		if e.Error != "" {
+			outcome = fmt.Sprintf("%s: %s", e.Outcome, e.Error)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Started.Local().Format(time.DateTime), e.Direction,
+			tui.ByteCountSI(e.Size), transport, outcome, historyFiles(e.Files))
+	}
+	w.Flush()
+}
+
+// historyFiles lists the files of an entry, abbreviated beyond the first few.
+func historyFiles(files []string) string {
+	const shown = 3
+	switch {
+	case len(files) == 0:
+		return "-"
+	case len(files) > shown:
+		return fmt.Sprintf("%s (+%d more)", strings.Join(files[:shown], ", "), len(files)-shown)
+	default:
+		return strings.Join(files, ", ")
+	}
+}
+
+// historyEnabled reports whether transfers are recorded in the history, unless disabled with history in the config
+// file.
+func historyEnabled() bool {
+	return !viper.IsSet("history") || viper.GetBool("history")
+}
+
+// sendAgain returns the absolute paths of the files of the past send of the id in the history.
+func sendAgain(id int) ([]string, error) {
+	store, err := history.OpenDefault()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Direction != history.SEND || len(entry.Files) == 0 {
+		return nil, fmt.Errorf("transfer %d sent no files, only the files of past sends are sent again", id)
+	}
+	return entry.Files, nil
+}
+
+// transferRecord records a transfer in the history once it ended. All methods are no-ops on nil records, such that
+// transfers are only recorded if the history is enabled.
+type transferRecord struct {
+	mu    sync.Mutex
+	entry history.Entry
+}
+
+// newTransferRecord returns the record of a transfer in the provided direction starting now, or nil if the history
+// is disabled.
+func newTransferRecord(direction string) *transferRecord {
+	if !historyEnabled() {
+		return nil
+	}
+	return &transferRecord{entry: history.Entry{Direction: direction, Started: time.Now()}}
+}
+
+// Sent records the paths sent, made absolute such that they are sent again from any directory, and their size.
+func (r *transferRecord) Sent(paths []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		r.entry.Files = append(r.entry.Files, path)
+	}
+	r.entry.Size, _ = totalSize(paths)
+}
+
+// Received records the received files, of the provided size in total.
+func (r *transferRecord) Received(size int64, names ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry.Files = append(r.entry.Files, names...)
+	r.entry.Size += size
+}
+
+// Transfer records the type of the transfer negotiated by the peers.
+func (r *transferRecord) Transfer(t transfer.Type) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry.Transport = transportName(t)
+}
+
+// Finish records the transfer in the history, completed or failed with err. Commands failing with a UsageError
+// transferred nothing and are not recorded. Failing to record the transfer is reported on stderr, rather than
+// failing the command.
+func (r *transferRecord) Finish(err error) {
+	var usageErr UsageError
+	if r == nil || errors.As(err, &usageErr) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry.Duration = time.Since(r.entry.Started)
+	r.entry.Outcome = history.COMPLETED
+	if err != nil {
+		r.entry.Outcome, r.entry.Error = history.FAILED, err.Error()
+	}
+	store, serr := history.OpenDefault()
+	if serr == nil {
+		_, serr = store.Record(r.entry)
+	}
+	switch {
+	case errors.Is(serr, history.ErrReset):
+		fmt.Fprintf(os.Stderr, "warning: the %v, dropping the past transfers\n", serr)
+	case serr != nil:
+		fmt.Fprintf(os.Stderr, "warning: unable to record the transfer in the history: %v\n", serr)
+	}
+}
+
+// transportName returns the name of the type of transfer recorded in the history, empty if unknown.
+func transportName(t transfer.Type) string {
+	switch t {
+	case transfer.Direct:
+		return "direct"
+	case transfer.Relay:
+		return "relay"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintHistory(t *testing.T) {
+	var out bytes.Buffer
+	printHistory(&out, nil)
+	assert.Equal(t, "no transfers recorded\n", out.String())
+
+	out.Reset()
+	started := time.Date(2023, 6, 1, 12, 0, 0, 0, time.Local)
+	printHistory(&out, []history.Entry{
+		{ID: 1, Direction: history.SEND, Files: []string{"/a", "/b", "/c", "/d"}, Size: 1500, Transport: "direct", Started: started, Outcome: history.COMPLETED},
+		{ID: 2, Direction: history.RECEIVE, Started: started, Outcome: history.FAILED, Error: "connection lost"},
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "TRANSPORT")
+	assert.Contains(t, lines[1], "2023-06-01 12:00:00")
+	assert.Contains(t, lines[1], "1.5 kB")
+	assert.Contains(t, lines[1], "/a, /b, /c (+1 more)")
+	assert.Contains(t, lines[2], "failed: connection lost")
+}
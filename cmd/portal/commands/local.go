@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/mdns"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
+)
+
+// LOCAL_MAX_ID bounds the ids of the codes of senders on the local network, drawn at random such that senders on
+// the same network are unlikely to advertise the same id.
+const LOCAL_MAX_ID = 9999
+
+// LOCAL_LOOKUP_TIMEOUT is the time receivers search the local network for the sender, unless waiting for it.
+const LOCAL_LOOKUP_TIMEOUT = 10 * time.Second
+
+// serveLocalRelay serves a relay to the local network until the context is done, advertised over mDNS under the id
+// of the code of the sender, and points the sender at it. The code is generated with a random id unless chosen with
+// --code.
+func serveLocalRelay(ctx context.Context, version string) error {
+	code := viper.GetString("code")
+	if code == "" {
+		passwords, err := passwordsFromViper()
+		if err != nil {
+			return UsageError{Err: err}
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(LOCAL_MAX_ID))
+		if err != nil {
+			return err
+		}
+		if code, err = passwords.Generate(int(n.Int64()) + 1); err != nil {
+			return err
+		}
+		viper.Set("code", code)
+	}
+	id, err := localID(code)
+	if err != nil {
+		return err
+	}
+	// development builds serve the relay unversioned.
+	ver, _ := semver.Parse(version)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return fmt.Errorf("serving local relay: %w", err)
+	}
+	s := rendezvous.NewServer(0, "", ver, rendezvous.WithListener(l), rendezvous.WithLogLevel(zapcore.WarnLevel))
+	go s.Run(ctx) //nolint:errcheck
+	port := l.Addr().(*net.TCPAddr).Port
+	if err := mdns.Advertise(ctx, localInstance(id), port); err != nil {
+		return fmt.Errorf("advertising local relay: %w", err)
+	}
+	viper.Set("relay", fmt.Sprintf("localhost:%d", port))
+	fmt.Fprintln(os.Stderr, "sending on the local network, receivers need --local")
+	return nil
+}
+
+// resolveLocalRelay searches the local network for the relay of the sender of the code over mDNS, and points the
+// receiver at it. The search is bounded by LOCAL_LOOKUP_TIMEOUT unless the receiver waits for the sender.
+func resolveLocalRelay(ctx context.Context, code string) error {
+	id, err := localID(code)
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("wait_for_sender") {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, LOCAL_LOOKUP_TIMEOUT)
+		defer cancel()
+	}
+	addr, err := mdns.Lookup(ctx, localInstance(id))
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no sender of the code found on the local network within %s", LOCAL_LOOKUP_TIMEOUT)
+	}
+	if err != nil {
+		return fmt.Errorf("searching the local network for the sender: %w", err)
+	}
+	viper.Set("relay", addr)
+	return nil
+}
+
+// localID returns the id of the code. Senders are advertised under the id of their code alone, which is not secret,
+// such that observers of the local network cannot guess the words of the code offline.
+func localID(code string) (int, error) {
+	prefix, _, _ := strings.Cut(code, "-")
+	id, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("invalid code %q, expected a number followed by words", code)
+	}
+	return id, nil
+}
+
+func localInstance(id int) string {
+	return fmt.Sprintf("portal-%d", id)
+}
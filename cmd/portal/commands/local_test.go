@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalID(t *testing.T) {
+	id, err := localID("4821-foo-bar-baz")
+	require.NoError(t, err)
+	assert.Equal(t, 4821, id)
+	assert.Equal(t, "portal-4821", localInstance(id))
+
+	_, err = localID("foo-bar-baz")
+	assert.Error(t, err)
+}
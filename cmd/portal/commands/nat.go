@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/nat"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// -------------------------------------------------------- NAT --------------------------------------------------------
+
+func NAT() *cobra.Command {
+	natCmd := &cobra.Command{
+		Use:   "nat",
+		Short: "Classify the NAT of this machine and whether direct transfers are feasible",
+		Long: "The nat command probes the relay server from a single UDP socket to classify the NAT behavior of this machine " +
+			"(no NAT, endpoint-independent or endpoint-dependent), explaining why transfers may be relayed rather than direct. " +
+			"The relay must answer NAT probes, see the --nat-probe-ports flag of portal serve.",
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("relay", cmd.Flags().Lookup("relay")); err != nil {
+				return fmt.Errorf("binding relay flag: %w", err)
+			}
+			if err := viper.BindPFlag("dns_server", cmd.Flags().Lookup("dns-server")); err != nil {
+				return fmt.Errorf("binding dns-server flag: %w", err)
+			}
+			if err := viper.BindPFlag("proxy", cmd.Flags().Lookup("proxy")); err != nil {
+				return fmt.Errorf("binding proxy flag: %w", err)
+			}
+			if err := validateProxy(); err != nil {
+				return err
+			}
+			if err := bindRelayTLSFlags(cmd); err != nil {
+				return err
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveRelayFromViper(cmd.Context()); err != nil {
+				return err
+			}
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			relayAddr := viper.GetString("relay")
+			reflectors, err := natReflectors(cmd.Context(), conn.HTTPClient(dialOptionsFromViper()...), relayAddr)
+			if err != nil {
+				return err
+			}
+			result, err := nat.Probe(cmd.Context(), reflectors, timeout)
+			if err != nil {
+				return fmt.Errorf("probing NAT: %w", err)
+			}
+			printNATResult(cmd.OutOrStdout(), result)
+			return nil
+		},
+	}
+	natCmd.Flags().StringP("relay", "r", "", relayFlagDesc)
+	natCmd.Flags().String("dns-server", "", dnsServerFlagDesc)
+	natCmd.Flags().String("proxy", "", proxyFlagDesc)
+	registerRelayTLSFlags(natCmd)
+	natCmd.Flags().Duration("timeout", nat.DEFAULT_PROBE_TIMEOUT, "Time waited for each probe to be answered")
+	registerRelayCompletion(natCmd)
+	return natCmd
+}
+
+// natReflectors returns the addresses answering NAT probes advertised by the relay server.
+func natReflectors(ctx context.Context, client *http.Client, relay string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, RELAY_PROBE_TIMEOUT)
+	defer cancel()
+	// relays addressed without a port are reached on the default port.
+	host, _ := conn.SplitScheme(relay)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conn.HTTPURL(relay, "/info"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching relay info: %w", err)
+	}
+	defer resp.Body.Close()
+	var info protocol.Info
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&info) != nil {
+		return nil, fmt.Errorf("relay %s does not describe itself, unable to probe NAT", relay)
+	}
+	if len(info.NATProbePorts) == 0 {
+		return nil, errors.New("relay does not answer NAT probes")
+	}
+	reflectors := make([]string, 0, len(info.NATProbePorts))
+	for _, port := range info.NATProbePorts {
+		reflectors = append(reflectors, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return reflectors, nil
+}
+
+// printNATResult prints the NAT classification and whether direct transfers are feasible.
+func printNATResult(out io.Writer, result nat.Result) {
+	fmt.Fprintf(out, "NAT type: %s\n", result.Type)
+	for _, mapped := range result.Mapped {
+		fmt.Fprintf(out, "observed address: %s\n", mapped)
+	}
+	switch {
+	case result.Type.DirectFeasible():
+		fmt.Fprintln(out, "direct transfers: feasible")
+	case result.Type == nat.EndpointDependent:
+		fmt.Fprintln(out, "direct transfers: unlikely, the NAT maps each destination to a new address, transfers are relayed")
+	case result.Type == nat.Blocked:
+		fmt.Fprintln(out, "direct transfers: unlikely, no probe was answered, UDP may be blocked by a firewall")
+	default:
+		fmt.Fprintln(out, "direct transfers: unknown, the relay answered too few probes to classify the NAT")
+	}
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+)
+
+// ------------------------------------------------------ Notify -------------------------------------------------------
+
+const notifyFlagDesc = "Ring the terminal bell and show a desktop notification once the transfer completes"
+
+// DESKTOP_NOTIFY_TIMEOUT is the time the desktop notifier is given to show a notification.
+const DESKTOP_NOTIFY_TIMEOUT = 5 * time.Second
+
+// the terminal bell, rung on completion.
+const bell = '\a'
+
+// completion is the result of a transfer, notified on completion.
+type completion struct {
+	verb    string // sent or received
+	bytes   int64  // zero if unknown
+	elapsed time.Duration
+	err     error
+}
+
+// title returns the title of the notification of the completion.
+func (c completion) title() string {
+	if c.err != nil {
+		return "Portal transfer failed"
+	}
+	return "Portal transfer complete"
+}
+
+// body returns the body of the notification of the completion.
+func (c completion) body() string {
+	elapsed := c.elapsed.Round(time.Second)
+	if c.err != nil {
+		return fmt.Sprintf("failed after %s: %v", elapsed, c.err)
+	}
+	if c.bytes > 0 {
+		return fmt.Sprintf("%s %s in %s", c.verb, tui.ByteCountSI(c.bytes), elapsed)
+	}
+	return fmt.Sprintf("%s in %s", c.verb, elapsed)
+}
+
+// desktopNotifier shows a desktop notification, replaced in tests.
+var desktopNotifier = notifyDesktop
+
+// notifyCompletion rings the terminal bell on out and shows a desktop notification of the completion, if enabled.
+// Desktop notifications are best effort, systems without a notifier only hear the bell.
+func notifyCompletion(out io.Writer, enabled bool, c completion) {
+	if !enabled {
+		return
+	}
+	fmt.Fprintf(out, "%c", bell)
+	desktopNotifier(c.title(), c.body()) //nolint:errcheck
+}
+
+// notifyDesktop shows a desktop notification with the notifier of the platform, if one is installed.
+// Headless Linux systems, without a display to show the notification on, are skipped.
+func notifyDesktop(title, body string) error {
+	var cmd []string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = []string{"osascript", "-e", fmt.Sprintf("display notification %q with title %q", body, title)}
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return nil
+		}
+		cmd = []string{"notify-send", title, body}
+	default:
+		return nil
+	}
+	path, err := exec.LookPath(cmd[0])
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DESKTOP_NOTIFY_TIMEOUT)
+	defer cancel()
+	return exec.CommandContext(ctx, path, cmd[1:]...).Run()
+}
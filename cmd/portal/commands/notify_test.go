@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyCompletion(t *testing.T) {
+	var titles, bodies []string
+	desktopNotifier = func(title, body string) error {
+		titles, bodies = append(titles, title), append(bodies, body)
+		return nil
+	}
+	t.Cleanup(func() { desktopNotifier = notifyDesktop })
+
+	var disabled bytes.Buffer
+	notifyCompletion(&disabled, false, completion{verb: "sent", bytes: 1000, elapsed: time.Second})
+	assert.Empty(t, disabled.String())
+	assert.Empty(t, titles)
+
+	var enabled bytes.Buffer
+	notifyCompletion(&enabled, true, completion{verb: "sent", bytes: 2000, elapsed: 3 * time.Second})
+	assert.Equal(t, "\a", enabled.String())
+	assert.Equal(t, []string{"Portal transfer complete"}, titles)
+	assert.Equal(t, []string{"sent 2.0 kB in 3s"}, bodies)
+
+	var failed bytes.Buffer
+	notifyCompletion(&failed, true, completion{verb: "received", elapsed: time.Second, err: errors.New("connection lost")})
+	assert.Equal(t, "\a", failed.String())
+	assert.Equal(t, "Portal transfer failed", titles[1])
+	assert.Equal(t, "failed after 1s: connection lost", bodies[1])
+}
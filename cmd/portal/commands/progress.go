@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"golang.org/x/term"
+)
+
+const progressReportInterval = time.Second
+
+// isTerminal reports whether the rich tui can run on the terminal: it is drawn on stdout and reads its keys
+// from stdin, so both must be attached to a terminal.
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// transferRate returns the rate in bytes per second the bytes transferred since the bytes reported were transferred
+// at over elapsed. Transferred bytes rewind as interrupted transfers resume, the rate never drops below zero.
+func transferRate(transferred, reported int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 || transferred <= reported {
+		return 0
+	}
+	return float64(transferred-reported) / elapsed.Seconds()
+}
+
+// progressReporter periodically writes plain-text progress lines to the provided writer, if any, and updates
+// the progress webhook, if any. Used in place of the animated progress bar when output is not a terminal.
+// Progress can be reported concurrently, by payloads transferred over parallel streams.
+type progressReporter struct {
+	out      io.Writer
+	verb     string
+	total    int64
+	interval time.Duration
+	webhook  *progressWebhook
+
+	mu          sync.Mutex
+	transferred int64
+	last        time.Time
+}
+
+func newProgressReporter(out io.Writer, verb string, total int64) *progressReporter {
+	return &progressReporter{out: out, verb: verb, total: total, interval: progressReportInterval, last: time.Now()}
+}
+
+func (p *progressReporter) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transferred += int64(n)
+	if p.webhook != nil {
+		p.webhook.update(p.transferred, p.total)
+	}
+	if p.out == nil || time.Since(p.last) < p.interval {
+		return
+	}
+	p.last = time.Now()
+	p.report()
+}
+
+// report writes the current progress to the underlying writer.
+func (p *progressReporter) report() {
+	if p.total > 0 {
+		percentage := 100 * float64(p.transferred) / float64(p.total)
+		fmt.Fprintf(p.out, "%s %s/%s (%.0f%%)\n", p.verb, tui.ByteCountSI(p.transferred), tui.ByteCountSI(p.total), percentage)
+		return
+	}
+	fmt.Fprintf(p.out, "%s %s\n", p.verb, tui.ByteCountSI(p.transferred))
+}
+
+// reportChunkStats returns a callback writing the size of the chunks and the throughput of the link adapted to by
+// the sender to the provided writer, at most once per progressReportInterval.
+func reportChunkStats(out io.Writer) func(transfer.ChunkStats) {
+	var last time.Time
+	return func(stats transfer.ChunkStats) {
+		if time.Since(last) < progressReportInterval {
+			return
+		}
+		last = time.Now()
+		fmt.Fprintf(out, "sending %s chunks at %s/s\n", tui.ByteCountSI(stats.Size), tui.ByteCountSI(int64(stats.BytesPerSecond)))
+	}
+}
+
+// reportCompression returns a callback writing the size of the files of the payload before compression and its
+// codec, as announced by the sender, to the provided writer.
+func reportCompression(out io.Writer) func(transfer.Compression) {
+	return func(c transfer.Compression) {
+		if c.RawSize > 0 {
+			fmt.Fprintf(out, "receiving %s of files compressed with %s\n", tui.ByteCountSI(c.RawSize), c.Codec)
+		}
+	}
+}
+
+// progressReader reports the progress of the bytes read from the underlying reader.
+type progressReader struct {
+	io.Reader
+	progress *progressReporter
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.progress.add(n)
+	return n, err
+}
+
+// progressSeeker is a progressReader over a seekable reader, keeping the payload resumable.
+// Seeking rewinds the reported progress to the new offset.
+type progressSeeker struct {
+	progressReader
+	seeker io.Seeker
+}
+
+func (r progressSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.seeker.Seek(offset, whence)
+	if err == nil {
+		r.progress.mu.Lock()
+		r.progress.transferred = n
+		r.progress.mu.Unlock()
+	}
+	return n, err
+}
+
+// progressReaderAt is a progressSeeker over a reader supporting positioned reads, such that
+// the payload can be split over parallel streams.
+type progressReaderAt struct {
+	progressSeeker
+	readerAt io.ReaderAt
+}
+
+func (r progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.readerAt.ReadAt(b, off)
+	r.progress.add(n)
+	return n, err
+}
+
+// newProgressReader returns a reader reporting the progress of reading from the provided reader,
+// which is seekable and supports positioned reads if the provided reader does.
+func newProgressReader(r io.Reader, progress *progressReporter) io.Reader {
+	pr := progressReader{Reader: r, progress: progress}
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return pr
+	}
+	ps := progressSeeker{progressReader: pr, seeker: s}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return progressReaderAt{progressSeeker: ps, readerAt: ra}
+	}
+	return ps
+}
+
+// progressWriter reports the progress of the bytes written to the underlying writer.
+type progressWriter struct {
+	io.Writer
+	progress *progressReporter
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.progress.add(n)
+	return n, err
+}
+
+// progressWriterAt is a progressWriter over a writer supporting positioned writes, such that
+// a payload split over parallel streams can be received.
+type progressWriterAt struct {
+	progressWriter
+	writerAt io.WriterAt
+}
+
+func (w progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := w.writerAt.WriteAt(b, off)
+	w.progress.add(n)
+	return n, err
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressReporter(t *testing.T) {
+	var out bytes.Buffer
+	payload := strings.Repeat("portal", 1000)
+	progress := newProgressReporter(&out, "sent", int64(len(payload)))
+	progress.interval = 0
+
+	_, err := io.Copy(io.Discard, progressReader{Reader: strings.NewReader(payload), progress: progress})
+	assert.NoError(t, err)
+	progress.report()
+
+	assert.Contains(t, out.String(), "sent 6.0 kB/6.0 kB (100%)")
+	assert.NotContains(t, out.String(), "\x1b", "progress output should not contain ANSI escapes")
+}
+
+func TestTransferRate(t *testing.T) {
+	assert.Equal(t, 512.0, transferRate(1536, 512, 2*time.Second))
+	assert.Zero(t, transferRate(512, 1536, time.Second), "resumed transfers rewind")
+	assert.Zero(t, transferRate(1536, 512, 0))
+}
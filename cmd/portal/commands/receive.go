@@ -3,23 +3,36 @@ package commands
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SpatiumPortae/portal/cmd/portal/config"
+	"github.com/SpatiumPortae/portal/cmd/portal/history"
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
 	receiver_tui "github.com/SpatiumPortae/portal/cmd/portal/tui/receiver"
 	"github.com/SpatiumPortae/portal/data"
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/file"
 	"github.com/SpatiumPortae/portal/internal/password"
 	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
 	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/exp/slices"
+	"golang.org/x/term"
 )
 
 // ------------------------------------------------------ Receive ------------------------------------------------------
@@ -28,7 +41,7 @@ func Receive(version string) *cobra.Command {
 	receiveCmd := &cobra.Command{
 		Use:               "receive",
 		Short:             "Receive files",
-		Long:              "The receive command receives files from the sender with the matching password.",
+		Long:              "The receive command receives files from the sender with the matching password, or portal:// link.",
 		Args:              cobra.ExactArgs(1),
 		ValidArgsFunction: passwordCompletion,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -43,6 +56,57 @@ func Receive(version string) *cobra.Command {
 			if err := viper.BindPFlag("relay_auth_token", cmd.Flags().Lookup("relay-auth")); err != nil {
 				return fmt.Errorf("binding relay-auth token flag: %w", err)
 			}
+			if err := viper.BindPFlag("dns_server", cmd.Flags().Lookup("dns-server")); err != nil {
+				return fmt.Errorf("binding dns-server flag: %w", err)
+			}
+			if err := viper.BindPFlag("proxy", cmd.Flags().Lookup("proxy")); err != nil {
+				return fmt.Errorf("binding proxy flag: %w", err)
+			}
+			if err := validateProxy(); err != nil {
+				return err
+			}
+			if err := bindRelayTLSFlags(cmd); err != nil {
+				return err
+			}
+			if err := viper.BindPFlag("strict", cmd.Flags().Lookup("strict")); err != nil {
+				return fmt.Errorf("binding strict flag: %w", err)
+			}
+			if err := viper.BindPFlag("keep_partial", cmd.Flags().Lookup("keep-partial")); err != nil {
+				return fmt.Errorf("binding keep-partial flag: %w", err)
+			}
+			if err := viper.BindPFlag("preserve_ownership", cmd.Flags().Lookup("preserve-ownership")); err != nil {
+				return fmt.Errorf("binding preserve-ownership flag: %w", err)
+			}
+			if err := viper.BindPFlag("preserve_xattrs", cmd.Flags().Lookup("preserve-xattrs")); err != nil {
+				return fmt.Errorf("binding preserve-xattrs flag: %w", err)
+			}
+			if err := viper.BindPFlag("xattr_namespaces", cmd.Flags().Lookup("xattr-namespaces")); err != nil {
+				return fmt.Errorf("binding xattr-namespaces flag: %w", err)
+			}
+			if err := viper.BindPFlag("verify_only", cmd.Flags().Lookup("verify-only")); err != nil {
+				return fmt.Errorf("binding verify-only flag: %w", err)
+			}
+			if err := viper.BindPFlag("output", cmd.Flags().Lookup("output")); err != nil {
+				return fmt.Errorf("binding output flag: %w", err)
+			}
+			if err := viper.BindPFlag("wait_for_sender", cmd.Flags().Lookup("wait")); err != nil {
+				return fmt.Errorf("binding wait flag: %w", err)
+			}
+			if err := viper.BindPFlag("receive_window", cmd.Flags().Lookup("receive-window")); err != nil {
+				return fmt.Errorf("binding receive-window flag: %w", err)
+			}
+			if err := viper.BindPFlag("rate_limit", cmd.Flags().Lookup("rate-limit")); err != nil {
+				return fmt.Errorf("binding rate-limit flag: %w", err)
+			}
+			if err := viper.BindPFlag("chunk_size", cmd.Flags().Lookup("chunk-size")); err != nil {
+				return fmt.Errorf("binding chunk-size flag: %w", err)
+			}
+			if err := viper.BindPFlag("transport", cmd.Flags().Lookup("transport")); err != nil {
+				return fmt.Errorf("binding transport flag: %w", err)
+			}
+			if err := viper.BindPFlag("local", cmd.Flags().Lookup("local")); err != nil {
+				return fmt.Errorf("binding local flag: %w", err)
+			}
 
 			// Reverse the --yes/-y flag value as it has an inverse relationship
 			// with the configuration value 'prompt_overwrite_files'.
@@ -55,11 +119,23 @@ func Receive(version string) *cobra.Command {
 			if err := viper.BindPFlag("prompt_overwrite_files", overwriteFlag); err != nil {
 				return fmt.Errorf("binding yes flag: %w", err)
 			}
+			// the collision flags take precedence over the collision policy of the configuration.
+			for _, collision := range []string{"overwrite", "rename", "skip"} {
+				if set, _ := cmd.Flags().GetBool(collision); set {
+					viper.Set("on_collision", collision)
+				}
+			}
 			return nil
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 			file.RemoveTemporaryFiles(file.RECEIVE_TEMP_FILE_NAME_PREFIX)
 
+			var events *jsonEvents
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				events = newJSONEvents(os.Stdout)
+				defer func() { reportOutcome(events, runErr) }()
+			}
+
 			logFile, err := setupLoggingFromViper("receive")
 			if err != nil {
 				return err
@@ -67,29 +143,229 @@ func Receive(version string) *cobra.Command {
 			defer logFile.Close()
 
 			pwd := args[0]
-			if !password.IsValid(pwd) {
-				return fmt.Errorf("invalid password format")
+			collect, _ := cmd.Flags().GetBool("drop")
+			if collect {
+				if _, _, err := receiver.ParseDropCode(pwd); err != nil {
+					return UsageError{Err: err}
+				}
+			} else if password.IsURL(pwd) {
+				code, relay, err := password.ParseURL(pwd)
+				if err != nil {
+					return UsageError{Err: err}
+				}
+				// an explicitly provided relay takes precedence over the relay of the link.
+				if relay != "" && !cmd.Flags().Changed("relay") {
+					viper.Set("relay", relay)
+				}
+				pwd = code
+			} else if password.IsEmbedded(pwd) {
+				code, relay, auth, err := password.ParseEmbedded(pwd)
+				if err != nil {
+					return UsageError{Err: err}
+				}
+				// an explicitly provided relay takes precedence over the relay of the code.
+				if !cmd.Flags().Changed("relay") {
+					viper.Set("relay", relay)
+				}
+				if auth && viper.GetString("relay_auth_token") == "" {
+					fmt.Fprintln(os.Stderr, "the relay of the code requires an auth token, provide it with --relay-auth")
+				}
+				pwd = code
+			}
+			if !collect && !password.IsValid(pwd) {
+				return usageErrorf("invalid password format")
 			}
-			switch viper.GetString("tui_style") {
+			if window := viper.GetInt("receive_window"); window < 0 || window > transfer.MAX_WINDOW {
+				return usageErrorf("invalid receive window %d, must be between 0 and %d chunks", window, transfer.MAX_WINDOW)
+			}
+			if viper.GetBool("local") {
+				if err := resolveLocalRelay(cmd.Context(), pwd); err != nil {
+					return err
+				}
+			}
+			if err := resolveRelayFromViper(cmd.Context()); err != nil {
+				return err
+			}
+			printMOTD(cmd.Context(), conn.HTTPClient(dialOptionsFromViper()...), viper.GetString("relay"), os.Stderr)
+
+			if _, err := rateLimitFromViper(); err != nil {
+				return UsageError{Err: err}
+			}
+			if _, err := chunkSizeFromViper(); err != nil {
+				return UsageError{Err: err}
+			}
+			if err := transfer.ValidateTransport(viper.GetString("transport")); err != nil {
+				return UsageError{Err: err}
+			}
+			collision, err := collisionFromViper()
+			if err != nil {
+				return UsageError{Err: err}
+			}
+			toStdout := viper.GetString("output") == "-"
+			if toStdout {
+				for _, flag := range stdoutExclusiveFlags {
+					if cmd.Flags().Changed(flag) {
+						return usageErrorf("--output - writes the payload to stdout as sent, it cannot be combined with --%s", flag)
+					}
+				}
+			}
+			noProgress, _ := cmd.Flags().GetBool("no-progress")
+			// the progress is reported as events rather than on stderr.
+			noProgress = noProgress || events != nil
+			notify, _ := cmd.Flags().GetBool("notify")
+			start := time.Now()
+			defer func() {
+				notifyCompletion(os.Stderr, notify, completion{verb: "received", elapsed: time.Since(start), err: runErr})
+			}()
+			var verifyingKey ed25519.PublicKey
+			if verify, _ := cmd.Flags().GetBool("verify-signature"); verify {
+				path, _ := cmd.Flags().GetString("pubkey")
+				if path == "" {
+					return usageErrorf("--verify-signature verifies the received files against a public key, it requires --pubkey")
+				}
+				if verifyingKey, err = file.ReadPublicKey(path); err != nil {
+					return err
+				}
+			}
+			if viper.GetBool("verify_only") {
+				if err := handleVerifyCommand(version, pwd, !noProgress); err != nil {
+					return fmt.Errorf("running verify receive command: %w", err)
+				}
+				return nil
+			}
+			extract := file.ExtractAuto
+			if yes, _ := cmd.Flags().GetBool("extract"); yes {
+				extract = file.ExtractAlways
+			}
+			if no, _ := cmd.Flags().GetBool("no-extract"); no {
+				extract = file.ExtractNever
+			}
+			// signatures are verified per file, archives sent with --archive are extracted to verify them.
+			if verifyingKey != nil {
+				extract = file.ExtractAlways
+			}
+			record := newTransferRecord(history.RECEIVE)
+			defer func() { record.Finish(runErr) }()
+			if collect {
+				if err := handleCollectDrop(version, pwd, extract, verifyingKey, !noProgress, record); err != nil {
+					return fmt.Errorf("running collect drop command: %w", err)
+				}
+				return nil
+			}
+			selectFiles, err := selectFilesFromFlags(cmd)
+			if err != nil {
+				return UsageError{Err: err}
+			}
+			resume, _ := cmd.Flags().GetBool("resume")
+			resumeToken, _ := cmd.Flags().GetString("resume-token")
+			// transfers resumed by token are recorded like any resumable transfer.
+			resume = resume || resumeToken != ""
+			stream, _ := cmd.Flags().GetBool("stream")
+			browse, _ := cmd.Flags().GetBool("select")
+			style := tuiStyle(noProgress)
+			// the progress of resumable transfers is only tracked, files only selected by pattern, senders only waited
+			// for, chunks only sequenced, skipped extended attributes only reported, payloads only unpacked as they
+			// arrive, chunk sizes only chosen, JSON events only reported and payloads only written to stdout by the raw
+			// receiver.
+			if resume || stream || viper.GetString("chunk_size") != "" || (selectFiles != nil && !browse) || viper.GetBool("wait_for_sender") || viper.GetInt("receive_window") > 0 || viper.GetBool("preserve_xattrs") || events != nil || toStdout {
+				style = config.StyleRaw
+			}
+			switch style {
 			case config.StyleRich:
-				if err := handleReceiveCommand(version, pwd); err != nil {
+				if err := handleReceiveCommand(version, pwd, extract, verifyingKey, browse, collision, record); err != nil {
 					return fmt.Errorf("running rich receive command: %w", err)
 				}
 				return nil
 			case config.StyleRaw:
-				if err := handleReceiveCommandRaw(version, pwd); err != nil {
+				opts := rawReceiveOptions{
+					password:     pwd,
+					extract:      extract,
+					showProgress: !noProgress,
+					resume:       resume,
+					stream:       stream,
+					resumeToken:  resumeToken,
+					verifyingKey: verifyingKey,
+					selectFiles:  selectFiles,
+					events:       events,
+					record:       record,
+				}
+				if err := handleReceiveCommandRaw(version, opts); err != nil {
 					return fmt.Errorf("running raw receive command: %w", err)
 				}
 				return nil
 			default:
-				return errors.New("invalid tui style provided")
+				return usageErrorf("invalid tui style provided")
 			}
 		},
 	}
 	receiveCmd.Flags().StringP("relay", "r", "", relayFlagDesc)
 	receiveCmd.Flags().BoolP("yes", "y", false, "Overwrite existing files without [Y/n] prompts")
+	receiveCmd.Flags().Bool("overwrite", false, "Overwrite existing files without prompting, like --yes")
+	receiveCmd.Flags().Bool("rename", false, "Write received files colliding with existing files under a free name, e.g. 'notes (1).txt'")
+	receiveCmd.Flags().Bool("skip", false, "Keep existing files, skipping the received files colliding with them")
+	receiveCmd.MarkFlagsMutuallyExclusive("overwrite", "rename", "skip")
+	for _, flag := range []string{"overwrite", "rename", "skip"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("yes", flag)
+	}
 	receiveCmd.Flags().StringP("tui-style", "s", "", tuiStyleFlagDesc)
 	receiveCmd.Flags().StringP("relay-auth", "a", "", "relay authentication token")
+	receiveCmd.Flags().Bool("no-progress", false, noProgressFlagDesc)
+	receiveCmd.Flags().Bool("notify", false, notifyFlagDesc)
+	receiveCmd.Flags().Bool("json", false, jsonFlagDesc)
+	receiveCmd.Flags().String("dns-server", "", dnsServerFlagDesc)
+	receiveCmd.Flags().String("proxy", "", proxyFlagDesc)
+	registerRelayTLSFlags(receiveCmd)
+	receiveCmd.Flags().Bool("strict", false, strictFlagDesc)
+	receiveCmd.Flags().Bool("keep-partial", false, "Keep incomplete files, suffixed with "+file.PARTIAL_FILE_SUFFIX+", when writing them fails")
+	receiveCmd.Flags().StringP("output", "o", "", "Directory or file path to write the received files to (defaults to the current directory)")
+	receiveCmd.Flags().Bool("verify-only", false, "Receive and checksum the transfer without writing it to disk")
+	receiveCmd.Flags().Bool("preserve-ownership", false, "Apply the file ownership (uid/gid) of the sender, requires sufficient privileges")
+	receiveCmd.Flags().Bool("preserve-xattrs", false, "Restore the extended attributes and POSIX ACLs sent with send --preserve-xattrs, skipping unsupported ones with a warning")
+	receiveCmd.Flags().StringSlice("xattr-namespaces", file.DEFAULT_XATTR_NAMESPACES, "Prefixes of the names of the extended attributes restored with --preserve-xattrs, e.g. security.selinux")
+	receiveCmd.Flags().Bool("extract", false, "Extract the received files, even if the sender sent them as an archive")
+	receiveCmd.Flags().Bool("no-extract", false, "Save the received files as a single tar archive, rather than extracting them")
+	receiveCmd.Flags().Bool("resume", false, "Record the progress of the transfer in "+file.RESUME_STATE_NAME+", resuming the interrupted transfer recorded there")
+	receiveCmd.MarkFlagsMutuallyExclusive("extract", "no-extract")
+	receiveCmd.Flags().String("resume-token", "", "Resume the interrupted transfer recorded on the relay under the token, e.g. on another machine, implies --resume")
+	for _, flag := range []string{"rename", "skip"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("resume", flag)
+		receiveCmd.MarkFlagsMutuallyExclusive("resume-token", flag)
+	}
+	receiveCmd.MarkFlagsMutuallyExclusive("resume", "no-extract")
+	receiveCmd.Flags().Bool("verify-signature", false, "Verify that each received file is signed by the key of --pubkey, failing closed on unsigned or mismatching files")
+	receiveCmd.Flags().String("pubkey", "", "PEM encoded ed25519 public key the signatures of the received files are verified against")
+	receiveCmd.MarkFlagsMutuallyExclusive("resume-token", "no-extract")
+	receiveCmd.MarkFlagsMutuallyExclusive("verify-signature", "no-extract")
+	receiveCmd.MarkFlagsMutuallyExclusive("verify-signature", "verify-only")
+	receiveCmd.MarkFlagsMutuallyExclusive("json", "verify-only")
+	receiveCmd.Flags().Bool("stream", false, "Unpack the received files as they arrive, rather than once the transfer is received in full into a temporary file")
+	for _, flag := range []string{"resume", "resume-token", "no-extract", "verify-only"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("stream", flag)
+	}
+	receiveCmd.Flags().Bool("wait", false, "Wait on the relay for a sender to claim the code, e.g. chosen with send --code, rather than failing if no sender holds it yet")
+	receiveCmd.Flags().Int("receive-window", 0, fmt.Sprintf("Hold up to the provided number of chunks out of order (at most %d), such that chunks lost by the relay are retransmitted rather than failing the transfer", transfer.MAX_WINDOW))
+	receiveCmd.Flags().String("rate-limit", "", "Receive at most at the provided rate (e.g. 10MB/s), such that the transfer does not saturate the downlink")
+	receiveCmd.Flags().String("chunk-size", "", "Ask the sender for chunks of the provided size (e.g. 4MB), or auto to adapt the chunks to the throughput of the link, rather than a size suited to the round trip time")
+	receiveCmd.Flags().String("transport", transfer.TRANSPORT_AUTO, transportFlagDesc)
+	receiveCmd.Flags().Bool("select", false, "Choose the files to receive from the files of the sender, only the chosen files are sent")
+	receiveCmd.Flags().StringArray("include", nil, "Only receive the files matching the provided glob pattern (e.g. '*.pdf', 'docs/*.md'), can be repeated")
+	for _, flag := range []string{"include", "resume", "resume-token", "verify-only"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("select", flag)
+	}
+	for _, flag := range []string{"resume", "resume-token", "verify-only"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("include", flag)
+	}
+	receiveCmd.Flags().Bool("local", false, "Receive from a sender on the local network sending with send --local, found over mDNS rather than through a rendezvous server")
+	for _, flag := range []string{"relay", "relay-auth", "resume-token"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("local", flag)
+	}
+	receiveCmd.Flags().Bool("drop", false, "Collect the drop of the provided code, left on the relay by send --drop, rather than receiving from a connected sender")
+	for _, flag := range []string{"resume", "resume-token", "stream", "verify-only", "select", "include", "wait", "receive-window", "json", "local", "chunk-size", "transport"} {
+		receiveCmd.MarkFlagsMutuallyExclusive("drop", flag)
+	}
+	receiveCmd.MarkFlagFilename("pubkey", "pem", "pub") //nolint:errcheck
+	receiveCmd.MarkFlagFilename("output")               //nolint:errcheck
+	registerRelayCompletion(receiveCmd)
 
 	return receiveCmd
 }
@@ -97,65 +373,513 @@ func Receive(version string) *cobra.Command {
 // ------------------------------------------------------ Handlers -----------------------------------------------------
 
 // handleReceiveCommand is the receive application.
-func handleReceiveCommand(version string, password string) error {
+func handleReceiveCommand(version string, password string, extract file.Extract, verifyingKey ed25519.PublicKey, selectFiles bool, collision file.Collision, record *transferRecord) error {
 	var opts []receiver_tui.Option
 	ver, err := semver.Parse(version)
 	if err == nil {
 		opts = append(opts, receiver_tui.WithVersion(ver))
 	}
+	opts = append(opts, receiver_tui.WithDialOptions(dialOptionsFromViper()...), receiver_tui.WithExtract(extract),
+		receiver_tui.WithUnpackOptions(file.WithCollision(collision)))
+	if viper.GetBool("strict") {
+		opts = append(opts, receiver_tui.WithStrictVersionCheck())
+	}
+	if verifyingKey != nil {
+		opts = append(opts, receiver_tui.WithUnpackOptions(file.WithSignatureVerification(verifyingKey)))
+	}
+	if selectFiles {
+		opts = append(opts, receiver_tui.WithFileSelection())
+	}
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, receiver_tui.WithTransport(viper.GetString("transport")), receiver_tui.WithRateLimit(rateLimit))
 	receiver := receiver_tui.New(viper.GetString("relay"), password, opts...)
 
-	if _, err := receiver.Run(); err != nil {
+	final, err := receiver.Run()
+	if err != nil {
 		return fmt.Errorf("running receiver tui: %w", err)
 	}
 	fmt.Println("")
-	return nil
+	files, size, t := receiver_tui.Received(final)
+	record.Received(size, files...)
+	record.Transfer(t)
+	return receiver_tui.Err(final)
+}
+
+// rawReceiveOptions configures the raw receiver.
+type rawReceiveOptions struct {
+	password     string
+	extract      file.Extract
+	showProgress bool
+	// resume resumes the transfer from the recorded resume state, or from the resumption of resumeToken if set.
+	resume      bool
+	resumeToken string
+	// stream unpacks the payload as it arrives.
+	stream       bool
+	verifyingKey ed25519.PublicKey
+	selectFiles  receiver.SelectFunc
+	// events reports the transfer as JSON events on stdout if not nil.
+	events *jsonEvents
+	// record records the transfer in the history if not nil.
+	record *transferRecord
 }
 
-func handleReceiveCommandRaw(version string, password string) error {
+// handleReceiveCommandRaw is the raw receiver, receiving the transfer as configured by opts.
+func handleReceiveCommandRaw(version string, opts rawReceiveOptions) error {
 	ctx := context.Background()
 	relayAddr := viper.GetString("relay")
 	ver, err := semver.Parse(version)
 	if err != nil {
 		return fmt.Errorf("parsing version: %w", err)
 	}
-	serverVer, err := semver.GetRendezvousVersion(ctx, relayAddr)
-	if err != nil {
-		return fmt.Errorf("fetching version from relay: %w", err)
+	if err := verifyRelayVersion(ctx, ver, relayAddr, viper.GetBool("strict"), os.Stderr); err != nil {
+		return err
 	}
-	if ver.Compare(serverVer) == semver.CompareOldMajor {
-		return fmt.Errorf("incompatible version %s -> %s", ver, serverVer)
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
 	}
 	cnf := portal.Config{
 		RendezvousAddr: relayAddr,
+		DNSServer:      viper.GetString("dns_server"),
+		Proxy:          viper.GetString("proxy"),
+		TLSConfig:      relayTLSConfigFromViper(),
+		Streams:        transfer.MAX_STREAMS,
+		RateLimit:      rateLimit,
+		OnFingerprint: func(fingerprint string) {
+			fmt.Fprintf(os.Stderr, "connection fingerprint %s\n", fingerprint)
+			opts.events.Connected(fingerprint)
+		},
+		OnChecksum: func(sum checksum.Result) {
+			fmt.Fprintf(os.Stderr, "verified checksum %s\n", sum)
+			opts.events.Checksum(sum)
+		},
+		OnIdle:        warnIdle(os.Stderr),
+		OnClosing:     warnClosing(os.Stderr),
+		OnTransfer:    opts.record.Transfer,
+		Select:        opts.selectFiles,
+		WaitForSender: viper.GetBool("wait_for_sender"),
+		ReceiveWindow: viper.GetInt("receive_window"),
+		Transport:     viper.GetString("transport"),
+	}
+	if cnf.ChunkSize, err = chunkSizeFromViper(); err != nil {
+		return err
+	}
+	if opts.events != nil {
+		cnf.OnProgress = opts.events.Progress
+	}
+	if opts.showProgress {
+		cnf.OnCompression = reportCompression(os.Stderr)
+	}
+	if viper.GetString("output") == "-" {
+		return receiveToStdout(ctx, opts.password, &cnf, opts.showProgress)
+	}
+	// chunks are only sequenced over a single relayed stream.
+	if cnf.ReceiveWindow > 0 {
+		cnf.Streams = 1
+	}
+	var (
+		state  *transfer.Resume
+		target file.OutputTarget
+	)
+	if opts.resume {
+		if target, err = extractTarget(viper.GetString("output")); err != nil {
+			return err
+		}
+		var recorded transfer.Resume
+		if opts.resumeToken != "" {
+			if recorded, err = receiver.LoadResumption(ctx, conn.HTTPClient(dialOptionsFromViper()...), relayAddr, opts.resumeToken); err != nil {
+				return err
+			}
+		} else if recorded, err = file.ReadResumeState(target.Dir); err != nil {
+			return fmt.Errorf("reading resume state: %w", err)
+		}
+		state = &recorded
+		cnf.Resume = state
+		// a payload received over a single stream is received without gaps up to where it was interrupted.
+		cnf.Streams = 1
+	}
+	if opts.stream {
+		if target, err = extractTarget(viper.GetString("output")); err != nil {
+			return err
+		}
+		target.ExtractZips = opts.extract == file.ExtractAlways
+		return receiveStreaming(ctx, opts.password, &cnf, target, opts.verifyingKey, opts.showProgress, opts.record)
 	}
 	temp, err := os.CreateTemp(os.TempDir(), file.RECEIVE_TEMP_FILE_NAME_PREFIX)
 	if err != nil {
 		return fmt.Errorf("creating temp receiver file: %w", err)
 	}
 
-	if err := portal.Receive(ctx, temp, password, &cnf); err != nil {
+	var dst io.Writer = temp
+	if opts.showProgress {
+		pw := progressWriter{Writer: temp, progress: newProgressReporter(os.Stderr, "received", 0)}
+		dst = progressWriterAt{progressWriter: pw, writerAt: temp}
+	}
+	if err := portal.Receive(ctx, dst, opts.password, &cnf); err != nil {
+		if state != nil {
+			if err := saveResumeState(temp, target, state, opts.verifyingKey); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: unable to record the progress of the transfer: %v\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "recorded the progress of the transfer, receive again with --resume to resume it\n")
+			}
+			storeResumption(ctx, relayAddr, *state)
+		}
 		return fmt.Errorf("receiving files: %w", err)
 	}
 
 	if _, err := temp.Seek(0, 0); err != nil {
 		return fmt.Errorf("seeking to start of temp file: %w", err)
 	}
-	unpacker, err := file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), temp)
+	// text messages are displayed rather than written to disk, unless an output path is provided.
+	if state == nil && viper.GetString("output") == "" {
+		var out io.Writer = os.Stdout
+		var text strings.Builder
+		if opts.events != nil {
+			out = &text
+		}
+		displayed, err := displayText(out, temp, opts.verifyingKey)
+		if displayed && err == nil {
+			opts.events.Text(text.String())
+		}
+		if displayed || err != nil {
+			temp.Close()
+			file.RemoveTemporaryFiles(file.RECEIVE_TEMP_FILE_NAME_PREFIX)
+			return err
+		}
+	}
+	if state == nil {
+		if target, err = file.ResolveOutput(temp, viper.GetString("output"), opts.extract); err != nil {
+			return fmt.Errorf("resolving output path: %w", err)
+		}
+	}
+	unpacker, err := file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), temp, unpackOptions(target, state, opts.verifyingKey)...)
 	if err != nil {
 		return fmt.Errorf("creating unpacker: %w", err)
 	}
 	defer unpacker.Close()
 	defer file.RemoveTemporaryFiles(file.RECEIVE_TEMP_FILE_NAME_PREFIX)
 
+	err = unpackFiles(unpacker, opts.record)
+	if state == nil {
+		return err
+	}
+	if err != nil {
+		if serr := file.WriteResumeState(target.Dir, *state); serr != nil {
+			fmt.Fprintf(os.Stderr, "warning: unable to record the progress of the transfer: %v\n", serr)
+		}
+		storeResumption(ctx, relayAddr, *state)
+		return err
+	}
+	if opts.resumeToken != "" {
+		if err := receiver.DeleteResumption(ctx, conn.HTTPClient(dialOptionsFromViper()...), relayAddr, opts.resumeToken); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+	return file.RemoveResumeState(target.Dir)
+}
+
+// handleCollectDrop collects the drop of the code into a temporary file and unpacks it like a received transfer,
+// or writes its payload to stdout as sent if the output is "-".
+func handleCollectDrop(version string, code string, extract file.Extract, verifyingKey ed25519.PublicKey, showProgress bool, record *transferRecord) error {
+	ctx := context.Background()
+	relayAddr := viper.GetString("relay")
+	ver, err := semver.Parse(version)
+	if err != nil {
+		return fmt.Errorf("parsing version: %w", err)
+	}
+	if err := verifyRelayVersion(ctx, ver, relayAddr, viper.GetBool("strict"), os.Stderr); err != nil {
+		return err
+	}
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
+	}
+	cnf := portal.Config{
+		RendezvousAddr: relayAddr,
+		DNSServer:      viper.GetString("dns_server"),
+		Proxy:          viper.GetString("proxy"),
+		TLSConfig:      relayTLSConfigFromViper(),
+		RateLimit:      rateLimit,
+	}
+	if viper.GetString("output") == "-" {
+		var dst io.Writer = os.Stdout
+		if showProgress {
+			dst = progressWriter{Writer: os.Stdout, progress: newProgressReporter(os.Stderr, "collected", 0)}
+		}
+		if err := portal.Collect(ctx, dst, code, &cnf); err != nil {
+			return fmt.Errorf("collecting drop: %w", err)
+		}
+		return nil
+	}
+	temp, err := os.CreateTemp(os.TempDir(), file.RECEIVE_TEMP_FILE_NAME_PREFIX)
+	if err != nil {
+		return fmt.Errorf("creating temp receiver file: %w", err)
+	}
+	defer temp.Close()
+	defer file.RemoveTemporaryFiles(file.RECEIVE_TEMP_FILE_NAME_PREFIX)
+	var dst io.Writer = temp
+	if showProgress {
+		dst = progressWriter{Writer: temp, progress: newProgressReporter(os.Stderr, "collected", 0)}
+	}
+	if err := portal.Collect(ctx, dst, code, &cnf); err != nil {
+		return fmt.Errorf("collecting drop: %w", err)
+	}
+	// drops are held by the relay.
+	record.Transfer(transfer.Relay)
+	if _, err := temp.Seek(0, 0); err != nil {
+		return fmt.Errorf("seeking to start of temp file: %w", err)
+	}
+	if viper.GetString("output") == "" {
+		if displayed, err := displayText(os.Stdout, temp, verifyingKey); displayed || err != nil {
+			return err
+		}
+	}
+	target, err := file.ResolveOutput(temp, viper.GetString("output"), extract)
+	if err != nil {
+		return fmt.Errorf("resolving output path: %w", err)
+	}
+	unpacker, err := file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), temp, unpackOptions(target, nil, verifyingKey)...)
+	if err != nil {
+		return fmt.Errorf("creating unpacker: %w", err)
+	}
+	defer unpacker.Close()
+	return unpackFiles(unpacker, record)
+}
+
+// stdoutExclusiveFlags are the flags writing the received files to disk, which cannot be combined with writing the
+// payload to stdout.
+var stdoutExclusiveFlags = []string{
+	"resume", "resume-token", "stream", "extract", "no-extract", "verify-signature", "preserve-ownership",
+	"preserve-xattrs", "keep-partial", "json",
+}
+
+// receiveToStdout writes the received payload to stdout as sent, without unpacking it or prompting for existing
+// files: a raw stream as piped to the sender, or the archive of the sent files compressed with the codec of the
+// sender. The payload is written as it arrives, before it is verified against the checksum of the sender.
+func receiveToStdout(ctx context.Context, password string, cnf *portal.Config, showProgress bool) error {
+	// pipes do not support the positioned writes of parallel streams, and raw streams are written as sent.
+	cnf.Streams = 1
+	cnf.Raw = true
+	var dst io.Writer = os.Stdout
+	if showProgress {
+		dst = progressWriter{Writer: os.Stdout, progress: newProgressReporter(os.Stderr, "received", 0)}
+	}
+	if err := portal.Receive(ctx, dst, password, cnf); err != nil {
+		return fmt.Errorf("receiving payload: %w", err)
+	}
+	return nil
+}
+
+// receiveStreaming unpacks the received files into the target as the payload arrives, rather than once it is
+// received in full into a temporary file. Files are committed before the checksum of the payload is verified.
+func receiveStreaming(ctx context.Context, password string, cnf *portal.Config, target file.OutputTarget, verifyingKey ed25519.PublicKey, showProgress bool, record *transferRecord) error {
+	pr, pw := io.Pipe()
+	var dst io.Writer = pw
+	if showProgress {
+		dst = progressWriter{Writer: pw, progress: newProgressReporter(os.Stderr, "received", 0)}
+	}
+	errC := make(chan error, 1)
+	go func() {
+		err := portal.Receive(ctx, dst, password, cnf)
+		pw.CloseWithError(err)
+		errC <- err
+	}()
+
+	unpacker, err := file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), pr, unpackOptions(target, nil, verifyingKey)...)
+	if err == nil {
+		err = unpackFiles(unpacker, record)
+		// the payload is drained past the end of the archive, such that the transfer completes.
+		if err == nil {
+			_, err = io.Copy(io.Discard, pr)
+		}
+		unpacker.Close()
+	}
+	pr.CloseWithError(err)
+	// failures to receive the payload surface to the unpacker as failures to read it.
+	if rerr := <-errC; rerr != nil && (err == nil || errors.Is(err, rerr)) {
+		return fmt.Errorf("receiving files: %w", rerr)
+	}
+	return err
+}
+
+// displayText writes the text message of the archive read from r to out, reporting whether the archive held a
+// message. Messages are only displayed once verified against the verifying key, if set. Rewinds r to the start of
+// the archive once read.
+func displayText(out io.Writer, r io.ReadSeeker, verifyingKey ed25519.PublicKey) (bool, error) {
+	var opts []file.UnpackOption
+	if verifyingKey != nil {
+		opts = append(opts, file.WithSignatureVerification(verifyingKey))
+	}
+	text, ok, err := file.ReadText(r, opts...)
+	if err != nil {
+		return false, fmt.Errorf("reading text message: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	text = tui.PrintableText(text)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err = io.WriteString(out, text)
+	return true, err
+}
+
+// selectFilesFromFlags returns the selection of the files to receive specified by the receive command flags,
+// nil to receive every file.
+func selectFilesFromFlags(cmd *cobra.Command) (receiver.SelectFunc, error) {
+	if patterns, _ := cmd.Flags().GetStringArray("include"); len(patterns) > 0 {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+			}
+		}
+		return includeFiles(patterns), nil
+	}
+	if selectFlag, _ := cmd.Flags().GetBool("select"); selectFlag {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, errors.New("--select prompts for the files to receive, use --include in non-interactive sessions")
+		}
+		return promptSelection(os.Stdin, os.Stderr), nil
+	}
+	return nil, nil
+}
+
+// includeFiles selects the files matching any of the glob patterns. Patterns without a slash are matched against
+// the filename, patterns with a slash against the path of the file in the transfer.
+func includeFiles(patterns []string) receiver.SelectFunc {
+	return func(manifest []transfer.ManifestFile) ([]string, error) {
+		var selected []string
+		for _, f := range manifest {
+			for _, pattern := range patterns {
+				name := f.Name
+				if !strings.Contains(pattern, "/") {
+					name = path.Base(f.Name)
+				}
+				if ok, _ := path.Match(pattern, name); ok {
+					selected = append(selected, f.Name)
+					break
+				}
+			}
+		}
+		return selected, nil
+	}
+}
+
+// promptSelection lists the files of the manifest on out, reading the numbers of the files to receive from in.
+func promptSelection(in io.Reader, out io.Writer) receiver.SelectFunc {
+	return func(manifest []transfer.ManifestFile) ([]string, error) {
+		for i, f := range manifest {
+			fmt.Fprintf(out, "%4d  %s (%s)\n", i+1, f.Name, tui.ByteCountSI(f.Size))
+		}
+		fmt.Fprint(out, "files to receive, e.g. 1,3-5 (empty for all): ")
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("reading selection: %w", err)
+		}
+		indices, err := parseSelection(strings.TrimSpace(answer), len(manifest))
+		if err != nil {
+			return nil, err
+		}
+		selected := make([]string, 0, len(indices))
+		for _, i := range indices {
+			selected = append(selected, manifest[i].Name)
+		}
+		return selected, nil
+	}
+}
+
+// parseSelection parses a comma separated list of numbers and ranges of numbers (e.g. 1,3-5) of n files, returning
+// the zero-based indices of the selected files in order. An empty selection selects every file.
+func parseSelection(s string, n int) ([]int, error) {
+	selected := make([]bool, n)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" && s == "" {
+			for i := range selected {
+				selected[i] = true
+			}
+			break
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err != nil || first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("invalid selection %q, expected numbers between 1 and %d", part, n)
+		}
+		for i := first; i <= last; i++ {
+			selected[i-1] = true
+		}
+	}
+	var indices []int
+	for i, ok := range selected {
+		if ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// storeResumption stores the progress of the interrupted transfer on the relay, printing the token to resume it
+// with from any machine holding the received files. Relays that do not store resumption state are skipped.
+func storeResumption(ctx context.Context, relayAddr string, state transfer.Resume) {
+	token, expires, err := receiver.StoreResumption(ctx, conn.HTTPClient(dialOptionsFromViper()...), relayAddr, state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: unable to store the progress of the transfer on the relay: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "to resume from another machine holding the received files, receive again with --resume-token %s (expires %s)\n",
+		token, expires.Local().Format(time.DateTime))
+}
+
+// unpackOptions returns the options unpacking the received files to the target, recording the files
+// committed in the resume state and verifying their signatures against the verifying key, if provided.
+// Resumable transfers resume the files they find under their own names, such that they are not renamed or skipped.
+func unpackOptions(target file.OutputTarget, state *transfer.Resume, verifyingKey ed25519.PublicKey) []file.UnpackOption {
+	opts := []file.UnpackOption{file.WithKeepPartial(viper.GetBool("keep_partial")), file.WithOutput(target)}
+	if collision, err := collisionFromViper(); err == nil && (state == nil || collision == file.CollisionOverwrite) {
+		opts = append(opts, file.WithCollision(collision))
+	}
+	if viper.GetBool("preserve_ownership") {
+		opts = append(opts, file.WithPreserveOwnership(func(err error) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}))
+	}
+	if viper.GetBool("preserve_xattrs") {
+		opts = append(opts, file.WithPreserveXattrs(func(err error) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}, viper.GetStringSlice("xattr_namespaces")...))
+	}
+	if state != nil {
+		opts = append(opts, file.WithResumeState(state))
+	}
+	if verifyingKey != nil {
+		opts = append(opts, file.WithSignatureVerification(verifyingKey))
+	}
+	return opts
+}
+
+// unpackFiles commits the files of the unpacker to disk, prompting before overwriting existing files if configured.
+// The committed files are recorded in the history if record is not nil.
+func unpackFiles(unpacker *file.Unpacker, record *transferRecord) error {
 	input := bufio.NewReader(os.Stdin)
 	for {
 		committer, err := unpacker.Unpack()
 		switch {
 		case errors.Is(err, io.EOF):
 			return nil
+		case errors.Is(err, file.ErrUnpackFileSkipped):
+			fmt.Fprintf(os.Stderr, "skipped %s, the file exists\n", committer.FileName())
+			continue
 		case errors.Is(err, file.ErrUnpackFileExists):
-			fmt.Printf("overwrite %s? [Y/n] ", committer.FileName())
+			// prompted on stderr, such that stdout is left to the output of the command, e.g. JSON events.
+			fmt.Fprintf(os.Stderr, "overwrite %s? [Y/n] ", committer.FileName())
 			response, err := input.ReadString('\n')
 			if err != nil {
 				return fmt.Errorf("unable to read input from stdin: %w", err)
@@ -171,10 +895,144 @@ func handleReceiveCommandRaw(version string, password string) error {
 		case err != nil:
 			return fmt.Errorf("unpacking file: %w", err)
 		}
-		if _, err := committer.Commit(); err != nil {
+		n, err := committer.Commit()
+		if err != nil {
 			return fmt.Errorf("committing file %s to disk: %w", committer.FileName(), err)
 		}
+		record.Received(n, committer.FileName())
+	}
+}
+
+// extractTarget returns the directory resumable and streamed transfers are extracted into, the provided output
+// directory or the current working directory. Resumable transfers are always extracted, such that files can be kept
+// individually, and streamed transfers as they are not held to be inspected before unpacking.
+func extractTarget(output string) (file.OutputTarget, error) {
+	if output == "" {
+		cwd, err := os.Getwd()
+		return file.OutputTarget{Dir: cwd}, err
+	}
+	dir, err := filepath.Abs(output)
+	if err != nil {
+		return file.OutputTarget{}, err
+	}
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		return file.OutputTarget{}, fmt.Errorf("%w: %s, resumable and streamed transfers are extracted into a directory", file.ErrOutputIsFile, output)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return file.OutputTarget{}, fmt.Errorf("creating output directory: %w", err)
+	}
+	return file.OutputTarget{Dir: dir}, nil
+}
+
+// saveResumeState commits the files received before the transfer into temp was interrupted, keeping the file
+// that was being received as a partial file, and records the progress of the transfer in the target directory.
+func saveResumeState(temp *os.File, target file.OutputTarget, state *transfer.Resume, verifyingKey ed25519.PublicKey) error {
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// transfers interrupted before the archive header was received have no files to commit.
+	if unpacker, err := file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), temp, unpackOptions(target, state, verifyingKey)...); err == nil {
+		// the received archive is truncated where the transfer was interrupted, failing the last commit.
+		unpackFiles(unpacker, nil) //nolint:errcheck
+	}
+	return file.WriteResumeState(target.Dir, *state)
+}
+
+// handleVerifyCommand receives the transfer without writing it to disk, reporting its checksum.
+func handleVerifyCommand(version string, password string, showProgress bool) error {
+	ctx := context.Background()
+	relayAddr := viper.GetString("relay")
+	ver, err := semver.Parse(version)
+	if err != nil {
+		return fmt.Errorf("parsing version: %w", err)
+	}
+	if err := verifyRelayVersion(ctx, ver, relayAddr, viper.GetBool("strict"), os.Stderr); err != nil {
+		return err
+	}
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
+	}
+	cnf := portal.Config{
+		RendezvousAddr: relayAddr,
+		DNSServer:      viper.GetString("dns_server"),
+		Proxy:          viper.GetString("proxy"),
+		TLSConfig:      relayTLSConfigFromViper(),
+		RateLimit:      rateLimit,
+		OnFingerprint: func(fingerprint string) {
+			fmt.Fprintf(os.Stderr, "connection fingerprint %s\n", fingerprint)
+		},
+		OnIdle:    warnIdle(os.Stderr),
+		OnClosing: warnClosing(os.Stderr),
+		Transport: viper.GetString("transport"),
+	}
+	var progress *progressReporter
+	if showProgress {
+		progress = newProgressReporter(os.Stderr, "received", 0)
+	}
+	result, err := verifyReceive(ctx, password, &cnf, progress)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// verifyResult describes a transfer verified without writing it to disk.
+type verifyResult struct {
+	bytes   int64  // size of the received payload
+	objects int    // number of objects in the payload
+	size    int64  // decompressed size of the objects
+	digest  string // hex encoded SHA-256 digest of the received payload
+}
+
+func (r verifyResult) String() string {
+	return fmt.Sprintf("verified %d objects (%s, %s decompressed), sha256 %s",
+		r.objects, tui.ByteCountSI(r.bytes), tui.ByteCountSI(r.size), r.digest)
+}
+
+// verifyReceive receives the transfer with the provided password, checksumming the payload and
+// verifying that it unpacks, without writing it to disk. Progress is reported if provided.
+func verifyReceive(ctx context.Context, password string, cnf *portal.Config, progress *progressReporter) (verifyResult, error) {
+	pr, pw := io.Pipe()
+	type verified struct {
+		objects int
+		size    int64
+		err     error
+	}
+	verifiedC := make(chan verified, 1)
+	go func() {
+		objects, size, err := file.VerifyArchive(pr)
+		if err == nil {
+			// consume trailing bytes of the payload, not part of the archive.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err) //nolint:errcheck
+		verifiedC <- verified{objects, size, err}
+	}()
+
+	digest := sha256.New()
+	counter := &countingWriter{}
+	var dst io.Writer = io.MultiWriter(digest, counter, pw)
+	if progress != nil {
+		dst = progressWriter{Writer: dst, progress: progress}
+	}
+	err := portal.Receive(ctx, dst, password, cnf)
+	pw.CloseWithError(err) //nolint:errcheck
+	v := <-verifiedC
+	switch {
+	// a malformed payload fails the transfer writing to it, report the cause rather than the failed write.
+	case v.err != nil && (err == nil || errors.Is(err, v.err)):
+		return verifyResult{}, fmt.Errorf("verifying files: %w", v.err)
+	case err != nil:
+		return verifyResult{}, fmt.Errorf("receiving files: %w", err)
 	}
+	return verifyResult{
+		bytes:   counter.n,
+		objects: v.objects,
+		size:    v.size,
+		digest:  hex.EncodeToString(digest.Sum(nil)),
+	}, nil
 }
 
 // ------------------------------------------------ Password Completion ------------------------------------------------
@@ -182,7 +1040,7 @@ func handleReceiveCommandRaw(version string, password string) error {
 func passwordCompletion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	components := strings.Split(toComplete, "-")
 
-	if len(components) > password.Length+1 || len(components) == 0 {
+	if len(components) > password.MAX_LENGTH+1 || len(components) == 0 {
 		return nil, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
 	}
 	if len(components) == 1 {
@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyReceive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	cnf := portal.Config{RendezvousAddr: fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)}
+
+	src := filepath.Join(t.TempDir(), "frog.txt")
+	require.NoError(t, os.WriteFile(src, []byte("A frog walks into a bank..."), 0644))
+	files, err := file.ReadFiles([]string{src})
+	require.NoError(t, err)
+	packed, _, err := file.PackFiles(files)
+	require.NoError(t, err)
+	defer os.Remove(packed.Name())
+	payload, err := io.ReadAll(packed)
+	require.NoError(t, err)
+	packed.Close()
+
+	// the working and temporary directories are empty, such that any written file is detected.
+	dst := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dst))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+	t.Setenv("TMPDIR", t.TempDir())
+
+	t.Run("valid", func(t *testing.T) {
+		password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &cnf)
+		require.NoError(t, err)
+		result, err := verifyReceive(ctx, password, &cnf, nil)
+		require.NoError(t, err)
+		require.NoError(t, <-errC)
+
+		sum := sha256.Sum256(payload)
+		assert.Equal(t, hex.EncodeToString(sum[:]), result.digest)
+		assert.Equal(t, int64(len(payload)), result.bytes)
+		assert.Equal(t, 1, result.objects)
+		assertEmpty(t, dst)
+		assertEmpty(t, os.TempDir())
+	})
+	t.Run("malformed", func(t *testing.T) {
+		malformed := payload[:len(payload)/2]
+		password, err, _ := portal.Send(ctx, bytes.NewReader(malformed), int64(len(malformed)), &cnf)
+		require.NoError(t, err)
+		_, err = verifyReceive(ctx, password, &cnf, nil)
+		assert.ErrorContains(t, err, "verifying files")
+		assertEmpty(t, dst)
+		assertEmpty(t, os.TempDir())
+	})
+}
+
+// assertEmpty asserts that no files were written to the directory.
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDisplayText(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	cnf := portal.Config{RendezvousAddr: fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)}
+
+	// receive sends the packed payload to a receiver writing it to a temporary file.
+	receive := func(t *testing.T, packed *os.File, size int64) *os.File {
+		defer os.Remove(packed.Name())
+		password, err, errC := portal.Send(ctx, packed, size, &cnf)
+		require.NoError(t, err)
+		temp, err := os.CreateTemp(t.TempDir(), file.RECEIVE_TEMP_FILE_NAME_PREFIX)
+		require.NoError(t, err)
+		t.Cleanup(func() { temp.Close() })
+		require.NoError(t, portal.Receive(ctx, temp, password, &cnf))
+		require.NoError(t, <-errC)
+		_, err = temp.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		return temp
+	}
+
+	t.Run("message", func(t *testing.T) {
+		packed, size, err := file.PackText("echo \x1b[31mhello\x1b[0m\tworld")
+		require.NoError(t, err)
+		var out bytes.Buffer
+		displayed, err := displayText(&out, receive(t, packed, size), nil)
+		require.NoError(t, err)
+		assert.True(t, displayed)
+		// escape sequences are stripped, such that the sender cannot control the terminal of the receiver.
+		assert.Equal(t, "echo [31mhello[0m\tworld\n", out.String())
+	})
+	t.Run("files", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "frog.txt")
+		require.NoError(t, os.WriteFile(src, []byte("A frog walks into a bank..."), 0644))
+		files, err := file.ReadFiles([]string{src})
+		require.NoError(t, err)
+		packed, size, err := file.PackFiles(files)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		displayed, err := displayText(&out, receive(t, packed, size), nil)
+		require.NoError(t, err)
+		assert.False(t, displayed)
+		assert.Empty(t, out.String())
+	})
+	t.Run("unsigned message", func(t *testing.T) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		packed, size, err := file.PackText("trust me")
+		require.NoError(t, err)
+		var out bytes.Buffer
+		displayed, err := displayText(&out, receive(t, packed, size), pub)
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+		assert.False(t, displayed)
+		assert.Empty(t, out.String())
+	})
+}
+
+func TestReceiveStreaming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	cnf := portal.Config{RendezvousAddr: fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)}
+
+	src := filepath.Join(t.TempDir(), "frogs")
+	contents := bytes.Repeat([]byte("A frog walks into a bank..."), 10000)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "pond"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "frog.txt"), contents, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "pond", "toad.txt"), contents[:100], 0644))
+	files, err := file.ReadFiles([]string{src})
+	require.NoError(t, err)
+	// neither side writes the archive to a temporary file.
+	t.Setenv("TMPDIR", t.TempDir())
+	payload, size, err := file.PackFilesStream(files)
+	require.NoError(t, err)
+	defer payload.Close()
+
+	password, err, errC := portal.Send(ctx, payload, size, &cnf)
+	require.NoError(t, err)
+	dst := t.TempDir()
+	require.NoError(t, receiveStreaming(ctx, password, &cnf, file.OutputTarget{Dir: dst}, nil, false, nil))
+	require.NoError(t, <-errC)
+
+	b, err := os.ReadFile(filepath.Join(dst, "frogs", "frog.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, b)
+	b, err = os.ReadFile(filepath.Join(dst, "frogs", "pond", "toad.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, contents[:100], b)
+	assertEmpty(t, os.TempDir())
+}
+
+func TestSelectFiles(t *testing.T) {
+	manifest := []transfer.ManifestFile{{Name: "a.txt", Size: 1}, {Name: "docs/b.pdf", Size: 2}, {Name: "docs/notes/c.md", Size: 3}}
+
+	t.Run("include", func(t *testing.T) {
+		tests := []struct {
+			patterns []string
+			selected []string
+		}{
+			{patterns: []string{"*.pdf"}, selected: []string{"docs/b.pdf"}},
+			{patterns: []string{"*.txt", "*.md"}, selected: []string{"a.txt", "docs/notes/c.md"}},
+			{patterns: []string{"docs/*"}, selected: []string{"docs/b.pdf"}},
+			{patterns: []string{"*.zip"}},
+		}
+		for _, tc := range tests {
+			selected, err := includeFiles(tc.patterns)(manifest)
+			require.NoError(t, err)
+			assert.Equal(t, tc.selected, selected, tc.patterns)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		var out bytes.Buffer
+		selected, err := promptSelection(strings.NewReader("1,3\n"), &out)(manifest)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.txt", "docs/notes/c.md"}, selected)
+		assert.Contains(t, out.String(), "   2  docs/b.pdf (2 B)")
+
+		selected, err = promptSelection(strings.NewReader("\n"), &out)(manifest)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.txt", "docs/b.pdf", "docs/notes/c.md"}, selected)
+
+		_, err = promptSelection(strings.NewReader("4\n"), &out)(manifest)
+		assert.Error(t, err)
+	})
+
+	t.Run("parse selection", func(t *testing.T) {
+		indices, err := parseSelection("5, 1-2,2", 5)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0, 1, 4}, indices)
+		for _, invalid := range []string{"0", "6", "3-1", "a", "1,", "1-"} {
+			_, err := parseSelection(invalid, 5)
+			assert.Error(t, err, invalid)
+		}
+	})
+}
@@ -1,20 +1,40 @@
 package commands
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/SpatiumPortae/portal/cmd/portal/config"
+	"github.com/SpatiumPortae/portal/cmd/portal/history"
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
 	sender_ui "github.com/SpatiumPortae/portal/cmd/portal/tui/sender"
+	"github.com/SpatiumPortae/portal/data"
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/password"
 	"github.com/SpatiumPortae/portal/internal/portal"
 	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/atotto/clipboard"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/exp/maps"
+	"golang.org/x/term"
 )
 
+// total size of the sent files above which the sender is asked to confirm the transfer.
+const DEFAULT_LARGE_TRANSFER_THRESHOLD = "1GiB"
+
 // -------------------------------------------------------- Send -------------------------------------------------------
 
 func Send(version string) *cobra.Command {
@@ -22,7 +42,15 @@ func Send(version string) *cobra.Command {
 		Use:   "send file1 file2...",
 		Short: "Send one or more files",
 		Long:  "The send command adds one or more files to be sent. Files are archived and compressed before sending.",
-		Args:  cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("text") || cmd.Flags().Changed("again") {
+				return cobra.NoArgs(cmd, args)
+			}
+			if cmd.Flags().Changed("files-from") {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if err := viper.BindPFlag("relay", cmd.Flags().Lookup("relay")); err != nil {
 				return fmt.Errorf("binding relay flag: %w", err)
@@ -33,28 +61,272 @@ func Send(version string) *cobra.Command {
 			if err := viper.BindPFlag("relay_auth_token", cmd.Flags().Lookup("relay-auth")); err != nil {
 				return fmt.Errorf("binding relay-auth token flag: %w", err)
 			}
+			if err := viper.BindPFlag("dns_server", cmd.Flags().Lookup("dns-server")); err != nil {
+				return fmt.Errorf("binding dns-server flag: %w", err)
+			}
+			if err := viper.BindPFlag("proxy", cmd.Flags().Lookup("proxy")); err != nil {
+				return fmt.Errorf("binding proxy flag: %w", err)
+			}
+			if err := validateProxy(); err != nil {
+				return err
+			}
+			if err := bindRelayTLSFlags(cmd); err != nil {
+				return err
+			}
+			if err := viper.BindPFlag("strict", cmd.Flags().Lookup("strict")); err != nil {
+				return fmt.Errorf("binding strict flag: %w", err)
+			}
+			if err := viper.BindPFlag("confirm_receiver", cmd.Flags().Lookup("confirm-receiver")); err != nil {
+				return fmt.Errorf("binding confirm-receiver flag: %w", err)
+			}
+			if err := viper.BindPFlag("streams", cmd.Flags().Lookup("streams")); err != nil {
+				return fmt.Errorf("binding streams flag: %w", err)
+			}
+			if err := viper.BindPFlag("expire_after", cmd.Flags().Lookup("expire-after")); err != nil {
+				return fmt.Errorf("binding expire-after flag: %w", err)
+			}
+			if err := viper.BindPFlag("checksum_algorithm", cmd.Flags().Lookup("checksum-algorithm")); err != nil {
+				return fmt.Errorf("binding checksum-algorithm flag: %w", err)
+			}
+			if err := viper.BindPFlag("code", cmd.Flags().Lookup("code")); err != nil {
+				return fmt.Errorf("binding code flag: %w", err)
+			}
+			if err := viper.BindPFlag("wait_for_code", cmd.Flags().Lookup("wait-for-code")); err != nil {
+				return fmt.Errorf("binding wait-for-code flag: %w", err)
+			}
+			if err := viper.BindPFlag("rate_limit", cmd.Flags().Lookup("rate-limit")); err != nil {
+				return fmt.Errorf("binding rate-limit flag: %w", err)
+			}
+			if err := viper.BindPFlag("chunk_size", cmd.Flags().Lookup("chunk-size")); err != nil {
+				return fmt.Errorf("binding chunk-size flag: %w", err)
+			}
+			if err := viper.BindPFlag("transport", cmd.Flags().Lookup("transport")); err != nil {
+				return fmt.Errorf("binding transport flag: %w", err)
+			}
+			if err := viper.BindPFlag("wordlist", cmd.Flags().Lookup("wordlist")); err != nil {
+				return fmt.Errorf("binding wordlist flag: %w", err)
+			}
+			if err := viper.BindPFlag("password_length", cmd.Flags().Lookup("password-length")); err != nil {
+				return fmt.Errorf("binding password-length flag: %w", err)
+			}
+			if err := viper.BindPFlag("digits", cmd.Flags().Lookup("digits")); err != nil {
+				return fmt.Errorf("binding digits flag: %w", err)
+			}
+			if err := viper.BindPFlag("local", cmd.Flags().Lookup("local")); err != nil {
+				return fmt.Errorf("binding local flag: %w", err)
+			}
+			if err := viper.BindPFlag("compress", cmd.Flags().Lookup("compress")); err != nil {
+				return fmt.Errorf("binding compress flag: %w", err)
+			}
+			// --compress-codec predates --compress, and chooses the codec like it.
+			if cmd.Flags().Changed("compress-codec") {
+				codec, _ := cmd.Flags().GetString("compress-codec")
+				viper.Set("compress", codec)
+			}
 			return nil
 
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 			file.RemoveTemporaryFiles(file.SEND_TEMP_FILE_NAME_PREFIX)
 
+			var events *jsonEvents
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				events = newJSONEvents(os.Stdout)
+				defer func() { reportOutcome(events, runErr) }()
+			}
+
 			logFile, err := setupLoggingFromViper("send")
 			if err != nil {
 				return err
 			}
 			defer logFile.Close()
-			switch viper.GetString("tui_style") {
+
+			if cmd.Flags().Changed("again") {
+				id, _ := cmd.Flags().GetInt("again")
+				if args, err = sendAgain(id); err != nil {
+					return err
+				}
+			}
+
+			if viper.GetBool("local") {
+				ctx, cancel := context.WithCancel(cmd.Context())
+				defer cancel()
+				if err := serveLocalRelay(ctx, version); err != nil {
+					return err
+				}
+			}
+			if err := resolveRelayFromViper(cmd.Context()); err != nil {
+				return err
+			}
+			printMOTD(cmd.Context(), conn.HTTPClient(dialOptionsFromViper()...), viper.GetString("relay"), os.Stderr)
+
+			packOpts, err := packOptionsFromFlags(cmd)
+			if err != nil {
+				return UsageError{Err: err}
+			}
+			if streams := viper.GetInt("streams"); streams < 1 || streams > transfer.MAX_STREAMS {
+				return usageErrorf("invalid number of streams %d, must be between 1 and %d", streams, transfer.MAX_STREAMS)
+			}
+			if expireAfter := viper.GetDuration("expire_after"); expireAfter < 0 {
+				return usageErrorf("invalid expiry %s, must not be negative", expireAfter)
+			}
+			if err := transfer.ValidateChecksum(viper.GetString("checksum_algorithm")); err != nil {
+				return UsageError{Err: err}
+			}
+			if _, err := rateLimitFromViper(); err != nil {
+				return UsageError{Err: err}
+			}
+			if _, err := chunkSizeFromViper(); err != nil {
+				return UsageError{Err: err}
+			}
+			if err := transfer.ValidateTransport(viper.GetString("transport")); err != nil {
+				return UsageError{Err: err}
+			}
+			receivers, _ := cmd.Flags().GetInt("receivers")
+			if receivers < 1 || receivers > rendezvous.MAX_RECEIVERS {
+				return usageErrorf("invalid number of receivers %d, must be between 1 and %d", receivers, rendezvous.MAX_RECEIVERS)
+			}
+			if receivers > 1 && viper.GetBool("confirm_receiver") {
+				return usageErrorf("--confirm-receiver asks for approval of a single receiver, it cannot be combined with --receivers")
+			}
+			stdin := sendsStdin(args)
+			if stdin {
+				for _, flag := range stdinExclusiveFlags {
+					if cmd.Flags().Changed(flag) {
+						return usageErrorf("- sends stdin rather than files, it cannot be combined with --%s", flag)
+					}
+				}
+				if viper.GetBool("confirm_receiver") {
+					return usageErrorf("--confirm-receiver reads the approval from stdin, it cannot be combined with sending stdin")
+				}
+			}
+			drop, _ := cmd.Flags().GetBool("drop")
+			if drop && stdin {
+				return usageErrorf("--drop announces the size of the payload up front, it cannot be combined with sending stdin")
+			}
+			if code := viper.GetString("code"); code != "" && !password.IsValid(code) {
+				return usageErrorf("invalid code %q, expected a number followed by words, e.g. 1-foo-bar-baz", code)
+			}
+			if wait := viper.GetDuration("wait_for_code"); wait < 0 || (wait > 0 && viper.GetString("code") == "") {
+				return usageErrorf("--wait-for-code must be positive, and requires --code")
+			}
+			passwords, err := passwordsFromViper()
+			if err != nil {
+				return UsageError{Err: err}
+			}
+			var text string
+			if cmd.Flags().Changed("text") {
+				flag, _ := cmd.Flags().GetString("text")
+				if flag == "-" && viper.GetBool("confirm_receiver") {
+					return usageErrorf("--confirm-receiver reads the approval from stdin, it cannot be combined with --text -")
+				}
+				if text, err = readText(flag, os.Stdin); err != nil {
+					return err
+				}
+			}
+			if filesFrom, _ := cmd.Flags().GetString("files-from"); filesFrom != "" {
+				if filesFrom == "-" && viper.GetBool("confirm_receiver") {
+					return usageErrorf("--confirm-receiver reads the approval from stdin, it cannot be combined with --files-from -")
+				}
+				paths, err := readFileListFrom(filesFrom)
+				if err != nil {
+					return err
+				}
+				args = append(args, paths...)
+				packOpts = append(packOpts, file.WithRelativePaths())
+			}
+			// the files are counted before anything is packed or the relay is connected to.
+			if max, _ := cmd.Flags().GetInt("max-files"); max > 0 && text == "" && !stdin {
+				if err := countFiles(args, packOpts...); err != nil {
+					return err
+				}
+			}
+			if yes, _ := cmd.Flags().GetBool("yes"); !yes && text == "" && !stdin {
+				thresholdFlag, _ := cmd.Flags().GetString("large-transfer-threshold")
+				threshold, err := parseSize(thresholdFlag)
+				if err != nil {
+					return err
+				}
+				size, err := totalSize(args)
+				if err != nil {
+					return err
+				}
+				interactive := term.IsTerminal(int(os.Stdin.Fd()))
+				if err := confirmLargeTransfer(os.Stdin, os.Stderr, interactive, size, threshold); err != nil {
+					return err
+				}
+			}
+			copyToClipboard, _ := cmd.Flags().GetBool("copy")
+			printCommand, _ := cmd.Flags().GetBool("print-command")
+			printURL, _ := cmd.Flags().GetBool("print-url")
+			embedRelay, _ := cmd.Flags().GetBool("embed-relay")
+			noProgress, _ := cmd.Flags().GetBool("no-progress")
+			// the progress is reported as events rather than on stderr.
+			noProgress = noProgress || events != nil
+			notify, _ := cmd.Flags().GetBool("notify")
+			var size int64
+			if notify {
+				// the size of the files is reported, the size of the compressed payload is not known up front.
+				size, _ = totalSize(args)
+				if text != "" {
+					size = int64(len(text))
+				}
+			}
+			start := time.Now()
+			defer func() {
+				notifyCompletion(os.Stderr, notify, completion{verb: "sent", bytes: size, elapsed: time.Since(start), err: runErr})
+			}()
+			var webhook *progressWebhook
+			if url, _ := cmd.Flags().GetString("progress-webhook"); url != "" {
+				if err := validateWebhookURL(url); err != nil {
+					return usageErrorf("invalid progress-webhook: %w", err)
+				}
+				webhook = newProgressWebhook(url, progressReportInterval, os.Stderr)
+				defer webhook.Close()
+			}
+			// text messages and stdin hold no files to send again.
+			var record *transferRecord
+			if text == "" && !stdin {
+				record = newTransferRecord(history.SEND)
+				record.Sent(args)
+				defer func() { record.Finish(runErr) }()
+			}
+			stream, _ := cmd.Flags().GetBool("stream")
+			style := tuiStyle(noProgress)
+			// text messages, stdin, chosen codes, generated passwords other than the default, codes carrying the
+			// relay address, streamed archives, JSON events and drops are only supported by the raw sender.
+			customPasswords := passwords.Words != nil || passwords.Length != password.Length || passwords.Digits
+			if text != "" || stdin || embedRelay || viper.GetString("code") != "" || customPasswords || stream || events != nil || drop {
+				style = config.StyleRaw
+			}
+			switch style {
 			case config.StyleRich:
-				if err := handleSendCommand(version, args); err != nil {
+				if err := handleSendCommand(version, args, copyToClipboard, negotiatesCodec(cmd), receivers, webhook, record, packOpts...); err != nil {
 					return fmt.Errorf("running rich send command: %w", err)
 				}
 			case config.StyleRaw:
-				if err := handleSendCommandRaw(version, args); err != nil {
+				opts := rawSendOptions{
+					filenames:       args,
+					text:            text,
+					receivers:       receivers,
+					stream:          stream,
+					drop:            drop,
+					copyToClipboard: copyToClipboard,
+					printCommand:    printCommand,
+					printURL:        printURL,
+					embedRelay:      embedRelay,
+					negotiate:       negotiatesCodec(cmd),
+					showProgress:    !noProgress,
+					webhook:         webhook,
+					events:          events,
+					record:          record,
+					packOpts:        packOpts,
+				}
+				if err := handleSendCommandRaw(version, opts); err != nil {
 					return fmt.Errorf("running raw send command: %w", err)
 				}
 			default:
-				return errors.New("invalid tui style provided")
+				return usageErrorf("invalid tui style provided")
 			}
 			return nil
 		},
@@ -62,44 +334,412 @@ func Send(version string) *cobra.Command {
 	sendCmd.Flags().StringP("relay", "r", "", relayFlagDesc)
 	sendCmd.Flags().StringP("tui-style", "s", "", tuiStyleFlagDesc)
 	sendCmd.Flags().StringP("relay-auth", "a", "", "relay authentication token")
+	sendCmd.Flags().Bool("no-progress", false, noProgressFlagDesc)
+	sendCmd.Flags().Bool("notify", false, notifyFlagDesc)
+	sendCmd.Flags().Bool("json", false, jsonFlagDesc)
+	sendCmd.Flags().String("progress-webhook", "", progressWebhookFlagDesc)
+	sendCmd.Flags().String("dns-server", "", dnsServerFlagDesc)
+	sendCmd.Flags().String("proxy", "", proxyFlagDesc)
+	registerRelayTLSFlags(sendCmd)
+	sendCmd.Flags().Bool("strict", false, strictFlagDesc)
+	sendCmd.Flags().Bool("copy", false, "Copy the receive command to the clipboard")
+	sendCmd.Flags().Bool("print-command", false, "Print the full receive command, including the relay address, rather than just the code")
+	sendCmd.Flags().Bool("print-url", false, "Print a "+password.URL_SCHEME+":// link carrying the code and the relay address, rather than just the code")
+	sendCmd.Flags().Bool("embed-relay", false, "Print a code carrying the relay address, such that receivers only need the code")
+	sendCmd.Flags().Bool("confirm-receiver", false, "Ask for approval of the receiver, identified by the connection fingerprint, before sending")
+	sendCmd.Flags().String("text", "", "Send the provided text message, displayed on the receiver's terminal, rather than files (- for stdin)")
+	sendCmd.Flags().Int("again", 0, "Send the files of the past send of the provided id again, as listed by portal history")
+	sendCmd.Flags().String("files-from", "", "Read the paths to send from the provided file, one per line (- for stdin)")
+	sendCmd.Flags().String("sign-key", "", "Sign each sent file with the PEM encoded ed25519 private key, verified by receivers with --verify-signature")
+	sendCmd.Flags().StringArray("exclude", nil, "Exclude files matching the provided gitignore-style pattern, can be repeated")
+	sendCmd.Flags().Bool("archive", false, "Ask the receiver to save the files as a single tar archive, rather than extracting them")
+	sendCmd.Flags().Bool("preserve-xattrs", false, "Send the extended attributes and POSIX ACLs of the files, restored by receivers with --preserve-xattrs")
+	sendCmd.Flags().Bool("dirs-as-zip", false, "Send each directory as a single zip file, e.g. for receivers on Windows, saved by the receiver unless extracted with --extract")
+	sendCmd.Flags().Int("max-files", 0, "Refuse to send more than the provided number of files (0 means unlimited)")
+	sendCmd.Flags().String("rename", "", "Send a single file under the provided filename")
+	sendCmd.Flags().String("compress", transfer.CODEC_GZIP, fmt.Sprintf("Compression codec of the sent archive (%s), auto negotiates the codec with the receiver", strings.Join(append([]string{transfer.CODEC_AUTO}, transfer.Codecs...), " | ")))
+	sendCmd.Flags().String("compress-codec", transfer.CODEC_GZIP, "Compression codec of the sent archive")
+	sendCmd.Flags().MarkDeprecated("compress-codec", "use --compress instead") //nolint:errcheck
+	sendCmd.Flags().Float64("compression-threshold", file.DEFAULT_COMPRESSION_THRESHOLD, "Skip compression when the sampled data compresses worse than the provided ratio (1 always compresses)")
+	sendCmd.Flags().String("since", "", "Only send files modified since the provided time (RFC3339 timestamp or duration, e.g. 24h)")
+	sendCmd.Flags().String("newer-than", "", "Only send files modified after the provided reference file")
+	sendCmd.Flags().String("large-transfer-threshold", DEFAULT_LARGE_TRANSFER_THRESHOLD, "Ask for confirmation before sending files larger than the provided size in total (e.g. 500MB, 1GiB)")
+	sendCmd.Flags().BoolP("yes", "y", false, "Send large transfers without asking for confirmation")
+	sendCmd.Flags().Int("streams", 1, fmt.Sprintf("Split the archive over up to the provided number of parallel relay streams (at most %d)", transfer.MAX_STREAMS))
+	sendCmd.Flags().Duration("expire-after", 0, "Expire the code if no receiver connected within the provided duration (e.g. 2m), bound by the relay's wait for receivers")
+	sendCmd.Flags().Int("receivers", 1, fmt.Sprintf("Send the files to up to the provided number of receivers with the same code, each receiving the files in full (at most %d)", rendezvous.MAX_RECEIVERS))
+	sendCmd.Flags().Bool("stream", false, "Stream the archive while it is sent rather than packing it into a temporary file first, uncompressed such that its size is known up front")
+	sendCmd.Flags().Bool("local", false, "Send on the local network, serving the relay and advertising it over mDNS, rather than through a rendezvous server")
+	sendCmd.Flags().Bool("drop", false, "Leave the files on the relay as a drop collected by the receiver later with receive --drop, such that the receiver need not be online now")
+	sendCmd.Flags().String("code", "", "Claim the provided code rather than a generated one, such that receivers knowing it can connect first with --wait")
+	sendCmd.Flags().Duration("wait-for-code", 0, "Keep claiming the code of --code while another sender holds it, for up to the provided duration (e.g. 1m)")
+	sendCmd.Flags().String("wordlist", "", fmt.Sprintf("Generate the code from a bundled word list (%s) or a word list file of one word per line, e.g. an EFF diceware list", strings.Join(bundledWordLists(), " | ")))
+	sendCmd.Flags().Int("password-length", password.Length, fmt.Sprintf("Number of words of the generated code (%d to %d)", password.MIN_LENGTH, password.MAX_LENGTH))
+	sendCmd.Flags().Bool("digits", false, fmt.Sprintf("Generate the code from groups of %d digits rather than words, e.g. for dictation over the phone", password.DIGITS_PER_GROUP))
+	sendCmd.Flags().String("rate-limit", "", "Send at most at the provided rate (e.g. 10MB/s), such that the transfer does not saturate the uplink")
+	sendCmd.Flags().String("chunk-size", "", "Send the files in chunks of the provided size (e.g. 4MB), or auto to adapt the chunks to the throughput of the link, rather than the size proposed by the receiver")
+	sendCmd.Flags().String("checksum-algorithm", transfer.CHECKSUM_SHA256, fmt.Sprintf("Checksum algorithm verifying the integrity of the transfer (%s)", strings.Join(transfer.Checksums, " | ")))
+	sendCmd.Flags().String("transport", transfer.TRANSPORT_AUTO, transportFlagDesc)
+	sendCmd.MarkFlagsMutuallyExclusive("since", "newer-than")
+	sendCmd.MarkFlagsMutuallyExclusive("print-command", "print-url", "embed-relay")
+	sendCmd.MarkFlagsMutuallyExclusive("receivers", "progress-webhook")
+	sendCmd.MarkFlagsMutuallyExclusive("receivers", "json")
+	sendCmd.MarkFlagsMutuallyExclusive("receivers", "wait-for-code")
+	sendCmd.MarkFlagsMutuallyExclusive("wordlist", "digits")
+	sendCmd.MarkFlagsMutuallyExclusive("compress", "compress-codec")
+	for _, flag := range []string{"wordlist", "password-length", "digits"} {
+		sendCmd.MarkFlagsMutuallyExclusive("code", flag)
+	}
+	for _, flag := range []string{"receivers", "dirs-as-zip", "compress", "compress-codec", "compression-threshold"} {
+		sendCmd.MarkFlagsMutuallyExclusive("stream", flag)
+	}
+	for _, flag := range []string{"files-from", "archive", "dirs-as-zip", "rename", "sign-key", "stream"} {
+		sendCmd.MarkFlagsMutuallyExclusive("text", flag)
+	}
+	for _, flag := range []string{"text", "files-from"} {
+		sendCmd.MarkFlagsMutuallyExclusive("again", flag)
+	}
+	for _, flag := range []string{"receivers", "code", "wait-for-code", "wordlist", "password-length", "digits", "confirm-receiver", "print-command", "print-url", "embed-relay", "copy", "streams", "expire-after", "json", "chunk-size", "transport"} {
+		sendCmd.MarkFlagsMutuallyExclusive("drop", flag)
+	}
+	for _, flag := range []string{"relay", "relay-auth", "print-url", "embed-relay", "drop"} {
+		sendCmd.MarkFlagsMutuallyExclusive("local", flag)
+	}
+	sendCmd.MarkFlagFilename("files-from")      //nolint:errcheck
+	sendCmd.MarkFlagFilename("newer-than")      //nolint:errcheck
+	sendCmd.MarkFlagFilename("wordlist")        //nolint:errcheck
+	sendCmd.MarkFlagFilename("sign-key", "pem") //nolint:errcheck
+	registerRelayCompletion(sendCmd)
 	return sendCmd
 }
 
+// stdinExclusiveFlags are the flags sending files, which cannot be combined with sending stdin.
+var stdinExclusiveFlags = []string{
+	"text", "files-from", "receivers", "stream", "archive", "dirs-as-zip", "rename", "sign-key", "exclude",
+	"preserve-xattrs", "max-files", "since", "newer-than", "compress", "compress-codec", "compression-threshold",
+}
+
+// bundledWordLists returns the names of the bundled word lists, sorted.
+func bundledWordLists() []string {
+	names := maps.Keys(data.WordLists)
+	sort.Strings(names)
+	return names
+}
+
+// passwordsFromViper returns the generator of the password of the sender, set by the wordlist, password-length and
+// digits flags or their keys in the config file.
+func passwordsFromViper() (password.Generator, error) {
+	gen := password.Generator{Length: viper.GetInt("password_length"), Digits: viper.GetBool("digits")}
+	if name := viper.GetString("wordlist"); name != "" && !gen.Digits {
+		words, err := password.LoadWordList(name)
+		if err != nil {
+			return password.Generator{}, err
+		}
+		gen.Words = words
+	}
+	if err := gen.Validate(); err != nil {
+		return password.Generator{}, err
+	}
+	return gen, nil
+}
+
+// sendsStdin reports whether the arguments of the send command ask to send stdin, a single "-".
+func sendsStdin(args []string) bool {
+	return len(args) == 1 && args[0] == "-"
+}
+
+// readFileListFrom reads the list of paths to send from the provided file, or stdin if "-".
+func readFileListFrom(name string) ([]string, error) {
+	if name == "-" {
+		return readFileList(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening file list: %w", err)
+	}
+	defer f.Close()
+	return readFileList(f)
+}
+
+// readFileList reads a newline separated list of paths, validating that each path is readable.
+func readFileList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q in file list: %w", path, err)
+		}
+		f.Close()
+		paths = append(paths, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading file list: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no paths in file list")
+	}
+	return paths, nil
+}
+
+// readText reads the text message to send from the flag, or stdin if "-".
+func readText(flag string, stdin io.Reader) (string, error) {
+	text := flag
+	if flag == "-" {
+		b, err := io.ReadAll(io.LimitReader(stdin, file.MAX_TEXT_SIZE+1))
+		if err != nil {
+			return "", fmt.Errorf("reading text from stdin: %w", err)
+		}
+		text = string(b)
+	}
+	switch {
+	case text == "":
+		return "", errors.New("no text to send")
+	case len(text) > file.MAX_TEXT_SIZE:
+		return "", fmt.Errorf("%w, send it as a file instead", file.ErrTextTooLarge)
+	}
+	return text, nil
+}
+
+// totalSize returns the total size in bytes of the provided files and directories.
+func totalSize(paths []string) (int64, error) {
+	var total int64
+	for _, path := range paths {
+		size, err := file.FileSize(path)
+		if err != nil {
+			return 0, fmt.Errorf("reading size of %q: %w", path, err)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// countFiles counts the files of the paths packed with the pack options, failing with a file.ErrTooManyFiles if
+// more files than the limit of file.WithMaxFiles would be packed.
+func countFiles(paths []string, packOpts ...file.PackOption) error {
+	files, err := file.ReadFiles(paths)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	_, err = file.CountFiles(files, packOpts...)
+	return err
+}
+
+// confirmLargeTransfer asks for confirmation on out, reading the answer from in, when the size of the
+// transfer exceeds the threshold. Non-interactive sessions cannot confirm, and fail instead of waiting for an answer.
+func confirmLargeTransfer(in io.Reader, out io.Writer, interactive bool, size, threshold int64) error {
+	if size <= threshold {
+		return nil
+	}
+	if !interactive {
+		return fmt.Errorf("transfer of %s exceeds the large transfer threshold of %s, pass --yes to send it anyway",
+			tui.ByteCountSI(size), tui.ByteCountSI(threshold))
+	}
+	fmt.Fprintf(out, "about to send %s, large transfers consume relay bandwidth, continue? [y/N]: ", tui.ByteCountSI(size))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errors.New("transfer not confirmed")
+	}
+}
+
+// packOptionsFromFlags resolves the file packing options from the send command flags.
+func packOptionsFromFlags(cmd *cobra.Command) ([]file.PackOption, error) {
+	var opts []file.PackOption
+	if excludes, _ := cmd.Flags().GetStringArray("exclude"); len(excludes) > 0 {
+		opts = append(opts, file.WithExcludes(excludes...))
+	}
+	if rename, _ := cmd.Flags().GetString("rename"); rename != "" {
+		if err := file.ValidateName(rename); err != nil {
+			return nil, err
+		}
+		opts = append(opts, file.WithRename(rename))
+	}
+	if archive, _ := cmd.Flags().GetBool("archive"); archive {
+		opts = append(opts, file.WithArchive())
+	}
+	if zip, _ := cmd.Flags().GetBool("dirs-as-zip"); zip {
+		opts = append(opts, file.WithDirsAsZip())
+	}
+	if xattrs, _ := cmd.Flags().GetBool("preserve-xattrs"); xattrs {
+		if !file.XATTRS_SUPPORTED {
+			fmt.Fprintln(os.Stderr, "warning: extended attributes are not supported on this platform, sending the files without them")
+		}
+		opts = append(opts, file.WithXattrs())
+	}
+	if max, _ := cmd.Flags().GetInt("max-files"); max > 0 {
+		opts = append(opts, file.WithMaxFiles(max))
+	}
+	if path, _ := cmd.Flags().GetString("sign-key"); path != "" {
+		key, err := file.ReadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, file.WithSignature(key))
+	}
+	if codec := viper.GetString("compress"); codec != "" {
+		if codec == transfer.CODEC_AUTO {
+			// archives are packed with the preferred codec, and repacked for receivers that cannot decompress it.
+			codec = transfer.AUTO_CODECS[0]
+			if !negotiatesCodec(cmd) {
+				codec = transfer.CODEC_GZIP
+			}
+		}
+		if err := transfer.ValidateCodec(codec); err != nil {
+			return nil, err
+		}
+		opts = append(opts, file.WithCodec(codec))
+	}
+	if cmd.Flags().Changed("compression-threshold") {
+		threshold, _ := cmd.Flags().GetFloat64("compression-threshold")
+		if threshold <= 0 {
+			return nil, fmt.Errorf("invalid compression threshold %v, must be positive", threshold)
+		}
+		opts = append(opts, file.WithCompressionThreshold(threshold))
+	}
+	if since, _ := cmd.Flags().GetString("since"); since != "" {
+		cutoff, err := parseSince(since, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, file.WithModifiedSince(cutoff))
+	}
+	if reference, _ := cmd.Flags().GetString("newer-than"); reference != "" {
+		info, err := os.Stat(reference)
+		if err != nil {
+			return nil, fmt.Errorf("reading reference file %q: %w", reference, err)
+		}
+		opts = append(opts, file.WithModifiedSince(info.ModTime()))
+	}
+	return opts, nil
+}
+
+// negotiatesCodec reports whether the codec of the archive is negotiated with the receiver, set by the compress flag
+// or its key in the config file. Archives shared by several receivers or left as a drop, text messages and streamed
+// archives are not repacked, and compressed with gzip every receiver decompresses.
+func negotiatesCodec(cmd *cobra.Command) bool {
+	receivers, _ := cmd.Flags().GetInt("receivers")
+	drop, _ := cmd.Flags().GetBool("drop")
+	stream, _ := cmd.Flags().GetBool("stream")
+	return viper.GetString("compress") == transfer.CODEC_AUTO && receivers <= 1 && !drop && !stream && !cmd.Flags().Changed("text")
+}
+
 // ------------------------------------------------------ Handlers -----------------------------------------------------
 
-// handleSendCommand is the sender application.
-func handleSendCommand(version string, fileNames []string) error {
+// handleSendCommand is the sender application, negotiating the codec of the archive with the receiver if negotiate
+// is set, sending the files to up to the provided number of receivers and posting the progress to the webhook if not
+// nil.
+func handleSendCommand(version string, fileNames []string, copyToClipboard, negotiate bool, receivers int, webhook *progressWebhook, record *transferRecord, packOpts ...file.PackOption) error {
 	var opts []sender_ui.Option
 	ver, err := semver.Parse(version)
 	// Conditionally add option to sender ui
 	if err == nil {
 		opts = append(opts, sender_ui.WithVersion(ver))
 	}
+	opts = append(opts, sender_ui.WithPackOptions(packOpts...), sender_ui.WithDialOptions(dialOptionsFromViper()...))
+	if viper.GetBool("strict") {
+		opts = append(opts, sender_ui.WithStrictVersionCheck())
+	}
+	if copyToClipboard {
+		opts = append(opts, sender_ui.WithCopyToClipboard())
+	}
+	if viper.GetBool("confirm_receiver") {
+		opts = append(opts, sender_ui.WithConfirmReceiver())
+	}
+	if negotiate {
+		opts = append(opts, sender_ui.WithNegotiatedCodec())
+	}
+	if receivers > 1 {
+		opts = append(opts, sender_ui.WithReceivers(receivers))
+	}
+	if webhook != nil {
+		opts = append(opts, sender_ui.WithProgress(webhook.update))
+	}
+	if streams := viper.GetInt("streams"); streams > 1 {
+		opts = append(opts, sender_ui.WithStreams(streams))
+	}
+	if expireAfter := viper.GetDuration("expire_after"); expireAfter > 0 {
+		opts = append(opts, sender_ui.WithExpireAfter(expireAfter))
+	}
+	opts = append(opts, sender_ui.WithChecksum(viper.GetString("checksum_algorithm")))
+	chunkSize, err := chunkSizeFromViper()
+	if err != nil {
+		return err
+	}
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, sender_ui.WithChunkSize(chunkSize), sender_ui.WithTransport(viper.GetString("transport")), sender_ui.WithRateLimit(rateLimit))
 	relayAddr := viper.GetString("relay")
 	sender := sender_ui.New(fileNames, relayAddr, opts...)
-	if _, err := sender.Run(); err != nil {
+	final, err := sender.Run()
+	if err != nil {
 		return fmt.Errorf("running tui: %w", err)
 	}
 	fmt.Println("")
-	return nil
+	record.Transfer(sender_ui.Transfer(final))
+	return sender_ui.Err(final)
+}
+
+// rawSendOptions configures the raw sender.
+type rawSendOptions struct {
+	filenames []string
+	// text is sent rather than the files if not empty.
+	text string
+	// receivers is the number of receivers the payload is sent to.
+	receivers int
+	// stream streams the archive of the files while it is sent.
+	stream bool
+	// drop leaves the payload on the relay as a drop.
+	drop            bool
+	copyToClipboard bool
+	printCommand    bool
+	printURL        bool
+	embedRelay      bool
+	// negotiate negotiates the codec of the archive with the receiver.
+	negotiate    bool
+	showProgress bool
+	webhook      *progressWebhook
+	// events reports the transfer as JSON events on stdout if not nil.
+	events *jsonEvents
+	// record records the transfer in the history if not nil.
+	record   *transferRecord
+	packOpts []file.PackOption
 }
 
-func handleSendCommandRaw(version string, filenames []string) error {
+// handleSendCommandRaw is the raw sender, sending the payload configured by opts. Stdin is sent as a raw stream of
+// unknown size if the only filename is "-".
+func handleSendCommandRaw(version string, opts rawSendOptions) error {
 	ctx := context.Background()
 	relayAddr := viper.GetString("relay")
 	ver, err := semver.Parse(version)
 	if err != nil {
 		return fmt.Errorf("parsing version: %w", err)
 	}
-	serverVer, err := semver.GetRendezvousVersion(ctx, relayAddr)
-	if err != nil {
-		return fmt.Errorf("fetching version from relay: %w", err)
+	if err := verifyRelayVersion(ctx, ver, relayAddr, viper.GetBool("strict"), os.Stderr); err != nil {
+		return err
 	}
-	if ver.Compare(serverVer) == semver.CompareOldMajor {
-		return fmt.Errorf("incompatible version %s -> %s", ver, serverVer)
+	stdin := sendsStdin(opts.filenames)
+	if stdin {
+		opts.filenames = nil
 	}
-	files := make([]*os.File, 0, len(filenames))
-	for _, name := range filenames {
+	files := make([]*os.File, 0, len(opts.filenames))
+	for _, name := range opts.filenames {
 		f, err := os.Open(name)
 		if err != nil {
 			return fmt.Errorf("unable to open file %q: %w", name, err)
@@ -107,23 +747,257 @@ func handleSendCommandRaw(version string, filenames []string) error {
 		defer f.Close()
 		files = append(files, f)
 	}
-	payload, size, err := file.PackFiles(files)
+	var (
+		compression file.CompressionResult
+		manifest    []transfer.ManifestFile
+		formats     []string
+		payload     io.ReadCloser
+		size        int64
+	)
+	switch {
+	case opts.text != "":
+		payload, size, err = file.PackText(opts.text, append(opts.packOpts, file.WithCompressionResult(&compression))...)
+	case stdin:
+		// buffered such that the payload does not claim the Seek and ReadAt of stdin, which pipes do not support.
+		payload, formats = io.NopCloser(bufio.NewReader(os.Stdin)), []string{transfer.FORMAT_RAW}
+	default:
+		payload, size, err = packFiles(files, opts.stream, append(opts.packOpts, file.WithCompressionResult(&compression), file.WithManifest(&manifest), file.WithFormats(&formats))...)
+	}
 	if err != nil {
 		return fmt.Errorf("error packing files: %w", err)
 	}
+	if compression.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped compression of incompressible data (sampled ratio %.2f)\n", compression.Ratio)
+	}
+	if compression.Stored > 0 {
+		fmt.Fprintf(os.Stderr, "stored %d already compressed files without compressing them again\n", compression.Stored)
+	}
 	defer payload.Close()
 	defer file.RemoveTemporaryFiles(file.SEND_TEMP_FILE_NAME_PREFIX)
+	rateLimit, err := rateLimitFromViper()
+	if err != nil {
+		return err
+	}
+	chunkSize, err := chunkSizeFromViper()
+	if err != nil {
+		return err
+	}
+	passwords, err := passwordsFromViper()
+	if err != nil {
+		return err
+	}
 	cnf := portal.Config{
 		RendezvousAddr: relayAddr,
+		DNSServer:      viper.GetString("dns_server"),
+		Proxy:          viper.GetString("proxy"),
+		TLSConfig:      relayTLSConfigFromViper(),
+		Codec:          compression.Codec,
+		Formats:        formats,
+		Streams:        viper.GetInt("streams"),
+		ExpireAfter:    viper.GetDuration("expire_after"),
+		Password:       viper.GetString("code"),
+		WaitForCode:    viper.GetDuration("wait_for_code"),
+		Passwords:      passwords,
+		Checksum:       viper.GetString("checksum_algorithm"),
+		RateLimit:      rateLimit,
+		ChunkSize:      chunkSize,
+		Transport:      viper.GetString("transport"),
+		OnIdle:         warnIdle(os.Stderr),
+		OnClosing:      warnClosing(os.Stderr),
+		OnChecksum: func(sum checksum.Result) {
+			fmt.Fprintf(os.Stderr, "sent checksum %s\n", sum)
+			opts.events.Checksum(sum)
+		},
+		OnTransfer: opts.record.Transfer,
+	}
+	if viper.GetBool("confirm_receiver") {
+		cnf.ConfirmReceiver = confirmReceiverPrompt(os.Stdin, os.Stderr)
+	}
+	if opts.events != nil {
+		cnf.OnFingerprint = opts.events.Connected
+		cnf.OnProgress = opts.events.Progress
+	}
+	if opts.showProgress {
+		cnf.OnChunkStats = reportChunkStats(os.Stderr)
+	}
+	// text messages are small enough to be sent again in full, text messages and stdin hold no files to select from.
+	if opts.text == "" && !stdin {
+		rawSize, _ := totalSize(opts.filenames)
+		cnf.Compression = &sender.Compression{RawSize: rawSize}
+		if opts.negotiate {
+			// files resumed or selected by the receiver are repacked with the negotiated codec.
+			codec := compression.Codec
+			opts.packOpts = append(opts.packOpts, file.WithCodecOf(&codec))
+			cnf.Compression.Repack = repackedFiles(files, &codec, opts.packOpts, opts.showProgress, opts.webhook)
+		}
+		cnf.OnResume = resumeFiles(files, opts.stream, opts.packOpts, opts.showProgress, opts.webhook)
+		// without a transfer id, transfers are resumed by the digests of the files kept by the receiver alone.
+		if id, err := file.TransferID(files); err == nil {
+			cnf.TransferID = id
+		}
+		cnf.Selection = selectableFiles(files, manifest, opts.stream, opts.packOpts, opts.showProgress, opts.webhook)
+	}
+	if opts.drop {
+		code, expires, err := portal.Drop(ctx, trackProgress(payload, size, opts.showProgress, opts.webhook), size, &cnf)
+		if err != nil {
+			return fmt.Errorf("leaving drop: %w", err)
+		}
+		fmt.Println(code)
+		fmt.Fprintf(os.Stderr, "left drop until %s, on the receiving end run: portal receive --drop %s\n", expires.Local().Format(time.RFC1123), code)
+		return nil
+	}
+	var (
+		password string
+		errC     chan error
+	)
+	if opts.receivers > 1 {
+		// every receiver reads the payload from the start, reporting its progress on its own.
+		ra, ok := payload.(io.ReaderAt)
+		if !ok {
+			return errors.New("sending to several receivers requires a payload supporting positioned reads, such as packed files rather than stdin")
+		}
+		payloads := make([]io.Reader, opts.receivers)
+		for i := range payloads {
+			payloads[i] = trackProgressAs(io.NewSectionReader(ra, 0, size), size, fmt.Sprintf("receiver %d: sent", i+1), opts.showProgress, nil)
+		}
+		password, err, errC = portal.SendMany(ctx, payloads, size, &cnf)
+	} else {
+		password, err, errC = portal.Send(ctx, trackProgress(payload, size, opts.showProgress, opts.webhook), size, &cnf)
 	}
-	password, err, errC := portal.Send(ctx, payload, size, &cnf)
 	if err != nil {
 		return fmt.Errorf("doing initial handshake: %w", err)
 	}
-	fmt.Println(password)
+	if opts.events != nil {
+		var printed strings.Builder
+		if err := printPassword(&printed, password, opts.printCommand, opts.printURL, opts.embedRelay); err != nil {
+			return err
+		}
+		opts.events.Password(strings.TrimSpace(printed.String()))
+	} else if err := printPassword(os.Stdout, password, opts.printCommand, opts.printURL, opts.embedRelay); err != nil {
+		return err
+	}
+	if opts.copyToClipboard {
+		receiveCommand := sender_ui.ReceiverCommand(password)
+		if err := clipboard.WriteAll(receiveCommand); err != nil {
+			fmt.Fprintf(os.Stderr, "clipboard unavailable (%v), on the receiving end run: %s\n", err, receiveCommand)
+		} else {
+			fmt.Fprintln(os.Stderr, "copied receive command to clipboard")
+		}
+	}
+	if opts.receivers > 1 {
+		fmt.Fprintf(os.Stderr, "waiting for %d receivers\n", opts.receivers)
+	}
 	err = <-errC
 	if err != nil {
 		return fmt.Errorf("doing portal transfer: %w", err)
 	}
 	return nil
 }
+
+// packFiles packs the files into a temporary file, or streams their archive while it is read if stream is set.
+func packFiles(files []*os.File, stream bool, packOpts ...file.PackOption) (io.ReadCloser, int64, error) {
+	if stream {
+		return file.PackFilesStream(files, packOpts...)
+	}
+	return file.PackFiles(files, packOpts...)
+}
+
+// resumeFiles returns a sender.ResumeFunc repacking the files without the files the receiver completed in a
+// previous session. The repacked payload is removed along with the other temporary files of the sender.
+func resumeFiles(files []*os.File, stream bool, packOpts []file.PackOption, showProgress bool, webhook *progressWebhook) sender.ResumeFunc {
+	return func(resume transfer.Resume) (io.Reader, int64, error) {
+		payload, size, err := packFiles(files, stream, append(packOpts, file.WithResume(resume))...)
+		if err != nil {
+			return nil, 0, fmt.Errorf("repacking files: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "resuming transfer, the receiver completed %d files\n", len(resume.Completed))
+		return trackProgress(payload, size, showProgress, webhook), size, nil
+	}
+}
+
+// repackedFiles returns a function repacking the files with the codec negotiated with the receiver, set as the codec
+// pointed to by negotiated. The repacked payload is removed along with the other temporary files of the sender.
+func repackedFiles(files []*os.File, negotiated *string, packOpts []file.PackOption, showProgress bool, webhook *progressWebhook) func(codec string) (io.Reader, int64, error) {
+	return func(codec string) (io.Reader, int64, error) {
+		*negotiated = codec
+		payload, size, err := file.PackFiles(files, packOpts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		fmt.Fprintf(os.Stderr, "compressed the files with %s for the receiver (%s)\n", codec, tui.ByteCountSI(size))
+		return trackProgress(payload, size, showProgress, webhook), size, nil
+	}
+}
+
+// selectableFiles returns the selection of the files of the manifest, repacking the files selected by the receiver.
+// The repacked payload is removed along with the other temporary files of the sender.
+func selectableFiles(files []*os.File, manifest []transfer.ManifestFile, stream bool, packOpts []file.PackOption, showProgress bool, webhook *progressWebhook) *sender.Selection {
+	return &sender.Selection{
+		Manifest: manifest,
+		Repack: func(selected []string) (io.Reader, int64, error) {
+			payload, size, err := packFiles(files, stream, append(packOpts, file.WithSelected(selected...))...)
+			if err != nil {
+				return nil, 0, err
+			}
+			fmt.Fprintf(os.Stderr, "the receiver selected %d of %d files\n", len(selected), len(manifest))
+			return trackProgress(payload, size, showProgress, webhook), size, nil
+		},
+	}
+}
+
+// trackProgress returns the payload reporting the progress of sending it on stderr if showProgress is set, and to
+// the progress webhook if any.
+func trackProgress(payload io.Reader, size int64, showProgress bool, webhook *progressWebhook) io.Reader {
+	return trackProgressAs(payload, size, "sent", showProgress, webhook)
+}
+
+// trackProgressAs is trackProgress reporting the progress with the provided verb, e.g. naming the receiver.
+func trackProgressAs(payload io.Reader, size int64, verb string, showProgress bool, webhook *progressWebhook) io.Reader {
+	if !showProgress && webhook == nil {
+		return payload
+	}
+	progress := newProgressReporter(nil, verb, size)
+	if showProgress {
+		progress.out = os.Stderr
+	}
+	progress.webhook = webhook
+	return newProgressReader(payload, progress)
+}
+
+// printPassword prints the password, the full command the receiver should run if printCommand is set,
+// the link to the password on the relay if printURL is set, or the code carrying the relay address if embedRelay is set.
+func printPassword(out io.Writer, pass string, printCommand, printURL, embedRelay bool) error {
+	switch {
+	case printCommand:
+		fmt.Fprintln(out, sender_ui.ReceiverCommand(pass))
+	case printURL:
+		fmt.Fprintln(out, password.URL(pass, viper.GetString("relay")))
+	case embedRelay:
+		code, err := password.Embed(pass, viper.GetString("relay"), viper.GetString("relay_auth_token") != "")
+		if err != nil {
+			return fmt.Errorf("embedding the relay address: %w", err)
+		}
+		fmt.Fprintln(out, code)
+	default:
+		fmt.Fprintln(out, pass)
+	}
+	return nil
+}
+
+// confirmReceiverPrompt returns a confirmation asking for approval of the receiver on out,
+// reading the answer from in. Only an explicit yes approves the receiver.
+func confirmReceiverPrompt(in io.Reader, out io.Writer) func(fingerprint string) (bool, error) {
+	return func(fingerprint string) (bool, error) {
+		fmt.Fprintf(out, "receiver connected with fingerprint %s, send? [y/N]: ", fingerprint)
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, fmt.Errorf("reading confirmation: %w", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFileList(t *testing.T) {
+	dir := t.TempDir()
+	one := filepath.Join(dir, "one.go")
+	two := filepath.Join(dir, "nested", "two.go")
+	require.NoError(t, os.MkdirAll(filepath.Dir(two), 0755))
+	require.NoError(t, os.WriteFile(one, nil, 0644))
+	require.NoError(t, os.WriteFile(two, nil, 0644))
+
+	t.Run("paths", func(t *testing.T) {
+		paths, err := readFileList(strings.NewReader(one + "\n\n  " + two + "  \n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{one, two}, paths)
+	})
+	t.Run("missing path", func(t *testing.T) {
+		_, err := readFileList(strings.NewReader(one + "\n" + filepath.Join(dir, "missing.go")))
+		assert.ErrorContains(t, err, "missing.go")
+	})
+	t.Run("empty list", func(t *testing.T) {
+		_, err := readFileList(strings.NewReader("\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestConfirmLargeTransfer(t *testing.T) {
+	const threshold = 1 << 30
+	t.Run("below threshold", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, confirmLargeTransfer(strings.NewReader(""), &out, true, threshold, threshold))
+		assert.Empty(t, out.String(), "no prompt below the threshold")
+	})
+	for _, tc := range []struct {
+		answer    string
+		confirmed bool
+	}{
+		{"y\n", true},
+		{"yes\n", true},
+		{"n\n", false},
+		{"", false},
+	} {
+		var out bytes.Buffer
+		err := confirmLargeTransfer(strings.NewReader(tc.answer), &out, true, threshold+1, threshold)
+		if tc.confirmed {
+			assert.NoError(t, err, tc.answer)
+		} else {
+			assert.Error(t, err, tc.answer)
+		}
+		assert.Contains(t, out.String(), "continue?")
+	}
+	t.Run("non-interactive", func(t *testing.T) {
+		var out bytes.Buffer
+		err := confirmLargeTransfer(strings.NewReader("y\n"), &out, false, threshold+1, threshold)
+		assert.ErrorContains(t, err, "--yes")
+		assert.Empty(t, out.String(), "no prompt when not interactive")
+	})
+}
+
+func TestConfirmReceiverPrompt(t *testing.T) {
+	for _, tc := range []struct {
+		answer   string
+		approved bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	} {
+		var out bytes.Buffer
+		approved, err := confirmReceiverPrompt(strings.NewReader(tc.answer), &out)("AB12-CD34")
+		require.NoError(t, err)
+		assert.Equal(t, tc.approved, approved, tc.answer)
+		assert.Contains(t, out.String(), "AB12-CD34")
+	}
+}
+
+func TestPrintPassword(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("relay", nil)
+		viper.Set("relay_auth_token", nil)
+	})
+	viper.Set("relay", "myrelay.io:8080")
+	viper.Set("relay_auth_token", "secret")
+
+	var code bytes.Buffer
+	require.NoError(t, printPassword(&code, "1-foo-bar-baz", false, false, false))
+	assert.Equal(t, "1-foo-bar-baz\n", code.String())
+
+	var command bytes.Buffer
+	require.NoError(t, printPassword(&command, "1-foo-bar-baz", true, false, false))
+	assert.Equal(t, "portal receive 1-foo-bar-baz --relay myrelay.io:8080 --relay-auth <token>\n", command.String())
+	assert.NotContains(t, command.String(), "secret")
+
+	var url bytes.Buffer
+	require.NoError(t, printPassword(&url, "1-foo-bar-baz", false, true, false))
+	assert.Equal(t, "portal://myrelay.io:8080/1-foo-bar-baz\n", url.String())
+
+	var embedded bytes.Buffer
+	require.NoError(t, printPassword(&embedded, "1-foo-bar-baz", false, false, true))
+	assert.NotContains(t, embedded.String(), "secret")
+	pass, relay, auth, err := password.ParseEmbedded(strings.TrimSpace(embedded.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "1-foo-bar-baz", pass)
+	assert.Equal(t, "myrelay.io:8080", relay)
+	assert.True(t, auth)
+}
+
+func TestReadText(t *testing.T) {
+	text, err := readText("hello", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", text)
+
+	text, err = readText("-", strings.NewReader("from stdin\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "from stdin\n", text)
+
+	_, err = readText("-", strings.NewReader(""))
+	assert.Error(t, err)
+	_, err = readText("-", strings.NewReader(strings.Repeat("x", file.MAX_TEXT_SIZE+1)))
+	assert.ErrorIs(t, err, file.ErrTextTooLarge)
+}
+
+func TestSendsStdin(t *testing.T) {
+	assert.True(t, sendsStdin([]string{"-"}))
+	assert.False(t, sendsStdin([]string{"-", "frog.txt"}))
+	assert.False(t, sendsStdin([]string{"frog.txt"}))
+	assert.False(t, sendsStdin(nil))
+}
@@ -1,14 +1,29 @@
 package commands
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/SpatiumPortae/portal/cmd/portal/config"
+	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/rendezvous"
 	"github.com/SpatiumPortae/portal/internal/semver"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+// ACME_CACHE_DIR_NAME is the directory of the portal config directory caching the certificates provisioned via ACME.
+const ACME_CACHE_DIR_NAME = "acme"
+
 func Serve(version string) *cobra.Command {
 	serveCmd := &cobra.Command{
 		Use:   "serve",
@@ -29,12 +44,430 @@ func Serve(version string) *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("server requires version to be set: %w", err)
 			}
-			server := rendezvous.NewServer(viper.GetInt("relay_serve_port"), viper.GetString("relay_auth_token"), ver)
+			authToken := viper.GetString("relay_auth_token")
+			if path, _ := cmd.Flags().GetString("auth-token-file"); path != "" {
+				if cmd.Flags().Changed("relay-auth") {
+					return usageErrorf("--relay-auth and --auth-token-file are mutually exclusive, specify the token once")
+				}
+				if authToken, err = readAuthTokenFile(path); err != nil {
+					return err
+				}
+			}
+			var opts []rendezvous.Option
+			var tokenSource rendezvous.TokenSource
+			if path, _ := cmd.Flags().GetString("auth-tokens-file"); path != "" {
+				tokenSource = rendezvous.TokensFromFile(path)
+			} else if os.Getenv(rendezvous.AUTH_TOKENS_ENV) != "" {
+				tokenSource = rendezvous.TokensFromEnv(rendezvous.AUTH_TOKENS_ENV)
+			}
+			if tokenSource != nil {
+				tokens, err := rendezvous.NewTokens(tokenSource)
+				if err != nil {
+					return fmt.Errorf("loading auth tokens: %w", err)
+				}
+				opts = append(opts, rendezvous.WithAuthTokens(tokens))
+			}
+			if noAuthFile, _ := cmd.Flags().GetBool("no-auth-file"); noAuthFile {
+				opts = append(opts, rendezvous.WithoutAuthFile())
+			}
+			if path, _ := cmd.Flags().GetString("auth-file"); path != rendezvous.AUTH_FILE_NAME {
+				opts = append(opts, rendezvous.WithAuthFile(path))
+			}
+			attempts, _ := cmd.Flags().GetInt("auth-file-attempts")
+			backoff, _ := cmd.Flags().GetDuration("auth-file-backoff")
+			if attempts < 1 || backoff < 0 {
+				return usageErrorf("invalid auth file retry, attempts %d must be positive and backoff %s not negative", attempts, backoff)
+			}
+			opts = append(opts, rendezvous.WithAuthFileRetry(attempts, backoff))
+			if initial, _ := cmd.Flags().GetInt("log-sampling-initial"); initial > 0 {
+				thereafter, _ := cmd.Flags().GetInt("log-sampling-thereafter")
+				opts = append(opts, rendezvous.WithLogSampling(initial, thereafter))
+			}
+			if max, _ := cmd.Flags().GetInt("max-mailboxes-per-identity"); max > 0 {
+				opts = append(opts, rendezvous.WithMaxMailboxesPerIdentity(max))
+			}
+			if max, _ := cmd.Flags().GetInt("max-connections-per-identity"); max > 0 {
+				opts = append(opts, rendezvous.WithMaxConnectionsPerIdentity(max))
+			}
+			if perMinute, _ := cmd.Flags().GetInt("registrations-per-minute"); perMinute > 0 {
+				opts = append(opts, rendezvous.WithRegistrationRate(perMinute))
+			}
+			if ban, _ := cmd.Flags().GetDuration("auth-ban"); ban > 0 {
+				max, _ := cmd.Flags().GetDuration("auth-ban-max")
+				opts = append(opts, rendezvous.WithAuthBan(ban, max))
+			}
+			if max, _ := cmd.Flags().GetInt64("max-relay-bytes"); max > 0 {
+				opts = append(opts, rendezvous.WithMaxRelayBytes(max))
+			}
+			if max, _ := cmd.Flags().GetInt("max-relays"); max > 0 {
+				opts = append(opts, rendezvous.WithMaxRelays(max))
+			}
+			quotaBytes, _ := cmd.Flags().GetInt64("transfer-quota")
+			quotaWindow, _ := cmd.Flags().GetDuration("quota-window")
+			quotaDir, _ := cmd.Flags().GetString("quota-store-dir")
+			if quotaBytes > 0 {
+				if quotaWindow <= 0 {
+					return usageErrorf("--quota-window must be positive")
+				}
+				opts = append(opts, rendezvous.WithTransferQuota(quotaBytes, quotaWindow))
+			}
+			if quotaDir != "" {
+				if quotaBytes <= 0 {
+					return usageErrorf("--quota-store-dir requires --transfer-quota")
+				}
+				quotas, err := rendezvous.NewDirQuotas(quotaDir)
+				if err != nil {
+					return fmt.Errorf("opening quota store: %w", err)
+				}
+				opts = append(opts, rendezvous.WithQuotaStore(quotas))
+			}
+			if min, _ := cmd.Flags().GetInt("min-kdf-iterations"); min > 0 {
+				if err := conn.ValidateKDFIterations(min); err != nil {
+					return usageErrorf("invalid min-kdf-iterations: %w", err)
+				}
+				opts = append(opts, rendezvous.WithMinKDFIterations(min))
+			}
+			if timeout, _ := cmd.Flags().GetDuration("handshake-timeout"); timeout > 0 {
+				opts = append(opts, rendezvous.WithHandshakeTimeout(timeout))
+			}
+			if n, _ := cmd.Flags().GetInt("max-header-bytes"); n > 0 {
+				opts = append(opts, rendezvous.WithMaxHeaderBytes(n))
+			}
+			if n, _ := cmd.Flags().GetInt64("max-in-flight-bytes"); n > 0 {
+				opts = append(opts, rendezvous.WithMaxInFlightBytes(n))
+			}
+			goroutines, _ := cmd.Flags().GetInt("shed-goroutines")
+			heapBytes, _ := cmd.Flags().GetUint64("shed-heap-bytes")
+			throughput, _ := cmd.Flags().GetInt64("shed-throughput")
+			if thresholds := (rendezvous.LoadThresholds{Goroutines: goroutines, HeapBytes: heapBytes, Throughput: throughput}); thresholds != (rendezvous.LoadThresholds{}) {
+				retryAfter, _ := cmd.Flags().GetDuration("shed-retry-after")
+				opts = append(opts, rendezvous.WithLoadShedding(thresholds, retryAfter))
+			}
+			if timeout, _ := cmd.Flags().GetDuration("read-header-timeout"); timeout > 0 {
+				opts = append(opts, rendezvous.WithReadHeaderTimeout(timeout))
+			}
+			if timeout, _ := cmd.Flags().GetDuration("idle-timeout"); timeout > 0 {
+				warning, _ := cmd.Flags().GetDuration("idle-warning")
+				opts = append(opts, rendezvous.WithIdleTimeout(timeout, warning))
+			}
+			if timeout, _ := cmd.Flags().GetDuration("drain-timeout"); timeout > 0 {
+				opts = append(opts, rendezvous.WithDrainTimeout(timeout))
+			}
+			if deadline, _ := cmd.Flags().GetDuration("conn-deadline"); deadline > 0 {
+				opts = append(opts, rendezvous.WithConnDeadline(deadline))
+			}
+			if ttl, _ := cmd.Flags().GetDuration("mailbox-ttl"); ttl >= 0 {
+				opts = append(opts, rendezvous.WithMailboxTTL(ttl))
+			}
+			if enabled, _ := cmd.Flags().GetBool("enable-resumptions"); enabled {
+				ttl, _ := cmd.Flags().GetDuration("resumption-ttl")
+				storeSize, _ := cmd.Flags().GetInt64("resumption-store-size")
+				if storeSize <= 0 || ttl <= 0 {
+					return usageErrorf("invalid resumption store, size %d and ttl %s must be positive", storeSize, ttl)
+				}
+				opts = append(opts, rendezvous.WithResumptions(rendezvous.NewResumptions(ttl, storeSize)))
+			}
+			if window, _ := cmd.Flags().GetDuration("success-rate-window"); window > 0 {
+				opts = append(opts, rendezvous.WithOutcomeWindow(window))
+			}
+			if anonymize, _ := cmd.Flags().GetBool("anonymize-ips"); anonymize {
+				opts = append(opts, rendezvous.WithIPAnonymization(true))
+			}
+			if addr, _ := cmd.Flags().GetString("syslog"); addr != "" {
+				network, _ := cmd.Flags().GetString("syslog-network")
+				opts = append(opts, rendezvous.WithSyslog(network, addr))
+			}
+			if path, _ := cmd.Flags().GetString("audit-log"); path != "" {
+				maxSize, _ := cmd.Flags().GetInt64("audit-log-max-size")
+				transferLog, err := rendezvous.NewTransferLog(path, maxSize)
+				if err != nil {
+					return fmt.Errorf("opening audit log: %w", err)
+				}
+				defer transferLog.Close()
+				opts = append(opts, rendezvous.WithTransferLog(transferLog))
+			}
+			if enabled, _ := cmd.Flags().GetBool("enable-drops"); enabled {
+				dir, _ := cmd.Flags().GetString("drop-dir")
+				spoolSize, _ := cmd.Flags().GetInt64("drop-spool-size")
+				ttl, _ := cmd.Flags().GetDuration("drop-ttl")
+				if spoolSize <= 0 || ttl <= 0 {
+					return usageErrorf("invalid drop spool, size %d and ttl %s must be positive", spoolSize, ttl)
+				}
+				drops, err := rendezvous.NewDrops(dir, spoolSize, ttl)
+				if err != nil {
+					return fmt.Errorf("opening drop spool: %w", err)
+				}
+				opts = append(opts, rendezvous.WithDrops(drops))
+			}
+			if enabled, _ := cmd.Flags().GetBool("metrics"); enabled {
+				addr, _ := cmd.Flags().GetString("metrics-addr")
+				opts = append(opts, rendezvous.WithMetrics(addr))
+			}
+			if motd, _ := cmd.Flags().GetString("motd"); motd != "" {
+				opts = append(opts, rendezvous.WithMOTD(motd))
+			}
+			if ports, _ := cmd.Flags().GetIntSlice("nat-probe-ports"); len(ports) > 0 {
+				opts = append(opts, rendezvous.WithNATProbe(ports...))
+			}
+			if subprotocols, _ := cmd.Flags().GetStringSlice("subprotocols"); cmd.Flags().Changed("subprotocols") {
+				if err := validateSubprotocols(subprotocols); err != nil {
+					return UsageError{Err: err}
+				}
+				opts = append(opts, rendezvous.WithSubprotocols(subprotocols...))
+			}
+			peerFlags, _ := cmd.Flags().GetStringArray("peer")
+			for _, flag := range peerFlags {
+				peer, err := rendezvous.ParsePeer(flag)
+				if err != nil {
+					return UsageError{Err: err}
+				}
+				opts = append(opts, rendezvous.WithPeers(peer))
+			}
+			if caFile, _ := cmd.Flags().GetString("peer-ca"); caFile != "" {
+				if len(peerFlags) == 0 {
+					return usageErrorf("--peer-ca verifies the relays of --peer, it requires --peer")
+				}
+				b, err := os.ReadFile(caFile)
+				if err != nil {
+					return fmt.Errorf("reading peer CA file: %w", err)
+				}
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(b) {
+					return usageErrorf("no PEM encoded certificates in peer CA file %s", caFile)
+				}
+				opts = append(opts, rendezvous.WithPeerTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}))
+			}
+			if cmd.Flags().Changed("peer-fanout") {
+				fanout, _ := cmd.Flags().GetInt("peer-fanout")
+				if fanout < 1 {
+					return usageErrorf("--peer-fanout must be positive")
+				}
+				opts = append(opts, rendezvous.WithPeerFanout(fanout))
+			}
+			if dir, _ := cmd.Flags().GetString("id-store-dir"); dir != "" {
+				maxAge, _ := cmd.Flags().GetDuration("id-max-age")
+				ids, err := rendezvous.NewDirIDs(dir, maxAge)
+				if err != nil {
+					return fmt.Errorf("opening id store: %w", err)
+				}
+				opts = append(opts, rendezvous.WithIDStore(ids))
+			}
+			if dir, _ := cmd.Flags().GetString("locator-dir"); dir != "" {
+				if len(peerFlags) == 0 {
+					return usageErrorf("--locator-dir requires --peer")
+				}
+				advertise, _ := cmd.Flags().GetString("advertise-addr")
+				if advertise == "" {
+					return usageErrorf("--locator-dir requires --advertise-addr")
+				}
+				locator, err := rendezvous.NewDirLocator(dir, advertise)
+				if err != nil {
+					return fmt.Errorf("opening locator: %w", err)
+				}
+				opts = append(opts, rendezvous.WithLocator(locator))
+			}
+			certFile, _ := cmd.Flags().GetString("tls-cert")
+			keyFile, _ := cmd.Flags().GetString("tls-key")
+			if (certFile == "") != (keyFile == "") {
+				return usageErrorf("--tls-cert and --tls-key must be provided together")
+			}
+			if certFile != "" {
+				cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				if err != nil {
+					return fmt.Errorf("loading TLS certificate: %w", err)
+				}
+				opts = append(opts, rendezvous.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+			}
+			domains, _ := cmd.Flags().GetStringSlice("domain")
+			if len(domains) > 0 {
+				if certFile != "" {
+					return usageErrorf("--domain provisions the TLS certificate via ACME, it cannot be combined with --tls-cert")
+				}
+				cacheDir, _ := cmd.Flags().GetString("acme-cache-dir")
+				if cacheDir == "" {
+					home, err := homedir.Dir()
+					if err != nil {
+						return fmt.Errorf("locating the ACME cache directory: %w", err)
+					}
+					cacheDir = filepath.Join(home, config.CONFIGS_DIR_NAME, config.PORTAL_CONFIG_DIR_NAME, ACME_CACHE_DIR_NAME)
+				}
+				email, _ := cmd.Flags().GetString("acme-email")
+				manager, err := rendezvous.NewACMEManager(domains, cacheDir, email)
+				if err != nil {
+					return UsageError{Err: err}
+				}
+				challengeAddr, _ := cmd.Flags().GetString("acme-http-addr")
+				opts = append(opts, rendezvous.WithACME(manager, challengeAddr))
+			}
+			servesTLS := certFile != "" || len(domains) > 0
+			if name, _ := cmd.Flags().GetString("tls-min-version"); name != "" {
+				if !servesTLS {
+					return usageErrorf("--tls-min-version pins the TLS version, it requires --tls-cert or --domain")
+				}
+				version, err := rendezvous.ParseTLSVersion(name)
+				if err != nil {
+					return UsageError{Err: err}
+				}
+				opts = append(opts, rendezvous.WithTLSMinVersion(version))
+			}
+			if suites, _ := cmd.Flags().GetStringSlice("tls-cipher-suites"); len(suites) > 0 {
+				if !servesTLS {
+					return usageErrorf("--tls-cipher-suites pins the TLS cipher suites, it requires --tls-cert or --domain")
+				}
+				if name, _ := cmd.Flags().GetString("tls-min-version"); name == "1.3" {
+					return usageErrorf("--tls-cipher-suites pins the cipher suites of TLS 1.2, it cannot be combined with --tls-min-version 1.3")
+				}
+				if _, err := rendezvous.ParseCipherSuites(suites); err != nil {
+					return UsageError{Err: err}
+				}
+				opts = append(opts, rendezvous.WithTLSCipherSuites(suites...))
+			}
+			if caFile, _ := cmd.Flags().GetString("client-ca"); caFile != "" {
+				if !servesTLS {
+					return usageErrorf("--client-ca verifies client certificates over TLS, it requires --tls-cert or --domain")
+				}
+				opts = append(opts, rendezvous.WithClientCA(caFile))
+			}
+			if h2c, _ := cmd.Flags().GetBool("h2c"); h2c {
+				if servesTLS {
+					return usageErrorf("--h2c serves HTTP/2 without TLS, it cannot be combined with --tls-cert or --domain")
+				}
+				opts = append(opts, rendezvous.WithH2C())
+			}
+			if listen, _ := cmd.Flags().GetString("listen"); listen != "" {
+				if cmd.Flags().Changed("port") {
+					return usageErrorf("--listen and --port are mutually exclusive, specify the port in the listen address")
+				}
+				if _, _, err := net.SplitHostPort(listen); err != nil {
+					return usageErrorf("invalid listen address %q, expected host:port, e.g. [::]:8080: %v", listen, err)
+				}
+				opts = append(opts, rendezvous.WithListenAddr(listen))
+			}
+			l, err := rendezvous.ListenerFromEnv()
+			if err != nil {
+				return fmt.Errorf("inheriting listener: %w", err)
+			}
+			if l != nil {
+				opts = append(opts, rendezvous.WithListener(l))
+			}
+			server := rendezvous.NewServer(viper.GetInt("relay_serve_port"), authToken, ver, opts...)
 			server.Start()
 			return nil
 		},
 	}
 	serveCmd.Flags().IntP("port", "p", 0, "port to run the portal relay server on")
+	serveCmd.Flags().String("listen", "", "host:port address to listen on instead of every address of --port, e.g. [::]:8080 or 127.0.0.1:8080")
 	serveCmd.Flags().StringP("relay-auth", "a", "", "relay authentication token")
+	serveCmd.Flags().String("auth-token-file", "", "file to read the relay authentication token from, e.g. a mounted secret (takes precedence over the config file)")
+	serveCmd.Flags().String("auth-tokens-file", "", "file of labeled relay authentication tokens, one label=token per line, reloaded over /admin/reload-tokens (default $"+rendezvous.AUTH_TOKENS_ENV+")")
+	serveCmd.Flags().String("auth-file", rendezvous.AUTH_FILE_NAME, "file the hashed relay authentication tokens are written to")
+	serveCmd.Flags().Bool("no-auth-file", false, "never write the hashed relay authentication tokens to disk, auth stays enabled")
+	serveCmd.Flags().Int("auth-file-attempts", rendezvous.DEFAULT_AUTH_FILE_ATTEMPTS, "attempts at writing the auth file before serving without it, e.g. while a volume is mounted")
+	serveCmd.Flags().Duration("auth-file-backoff", rendezvous.DEFAULT_AUTH_FILE_BACKOFF, "time waited before retrying to write the auth file, doubled after every attempt")
+	serveCmd.Flags().Int("log-sampling-initial", 0, "number of identical log entries per second logged before sampling (0 uses the default sampling)")
+	serveCmd.Flags().Int("log-sampling-thereafter", 100, "log every n-th identical entry once sampling starts (errors are never sampled)")
+	serveCmd.Flags().Int("max-mailboxes-per-identity", 0, "maximum number of concurrent mailboxes a single client can hold (0 means unlimited)")
+	serveCmd.Flags().Int("max-connections-per-identity", 0, "maximum number of concurrent websocket connections a single client can hold (0 means unlimited)")
+	serveCmd.Flags().Int("registrations-per-minute", 0, "maximum number of mailboxes a single client can register per minute (0 means unlimited)")
+	serveCmd.Flags().Duration("auth-ban", 0, "ban clients presenting an invalid auth or peering token for this long, doubled with every further failure (0 disables)")
+	serveCmd.Flags().Duration("auth-ban-max", rendezvous.DEFAULT_AUTH_BAN_MAX, "longest ban of clients presenting invalid tokens")
+	serveCmd.Flags().Int64("max-relay-bytes", 0, "maximum bytes a single transfer can relay in both directions, larger transfers are cut off (0 means unlimited)")
+	serveCmd.Flags().Int("max-relays", 0, "maximum number of transfers relayed concurrently, further receivers are rejected (0 means unlimited)")
+	serveCmd.Flags().Int64("transfer-quota", 0, "maximum bytes the transfers of a single client can relay within the quota window (0 means unlimited)")
+	serveCmd.Flags().Duration("quota-window", 24*time.Hour, "window the transfer quota is enforced over, windows reset at multiples of it since midnight UTC")
+	serveCmd.Flags().String("quota-store-dir", "", "directory persisting the usage of transfer quotas across restarts (in-memory if unset)")
+	serveCmd.Flags().Int("min-kdf-iterations", 0, "minimum number of key derivation iterations accepted from senders (0 accepts the default)")
+	serveCmd.Flags().Duration("handshake-timeout", 0, "time a client has to complete the handshake before it is disconnected (0 means unbounded)")
+	serveCmd.Flags().Int("max-header-bytes", rendezvous.DEFAULT_MAX_HEADER_BYTES, "maximum size in bytes of the request line and headers of a request")
+	serveCmd.Flags().Int64("max-in-flight-bytes", 0, "maximum relayed bytes held in memory across all transfers, reads are paused while exceeded (0 means unbounded)")
+	serveCmd.Flags().Int("shed-goroutines", 0, "reject new transfers while the relay runs more goroutines than this (0 disables)")
+	serveCmd.Flags().Uint64("shed-heap-bytes", 0, "reject new transfers while the relay holds more heap bytes than this (0 disables)")
+	serveCmd.Flags().Int64("shed-throughput", 0, "reject new transfers while the relay relays more bytes per second than this (0 disables)")
+	serveCmd.Flags().Duration("shed-retry-after", rendezvous.DEFAULT_SHED_RETRY_AFTER, "time rejected senders are told to wait before retrying, in the Retry-After header")
+	serveCmd.Flags().Duration("read-header-timeout", rendezvous.DEFAULT_READ_HEADER_TIMEOUT, "time a client has to send the headers of a request")
+	serveCmd.Flags().Duration("idle-timeout", 0, "time a relayed transfer may be idle before it is disconnected (0 means unbounded)")
+	serveCmd.Flags().Duration("idle-warning", rendezvous.DEFAULT_IDLE_WARNING, "time before the idle timeout at which the peers of an idle transfer are warned (0 disables the warning)")
+	serveCmd.Flags().Duration("drain-timeout", 0, "time in-flight relayed transfers may take to complete on shutdown before they are closed (0 closes them right away, unless handed off, in which case they are drained for up to 1h)")
+	serveCmd.Flags().Duration("conn-deadline", 0, "time a relayed connection may neither relay nor answer pings before it is closed as dead (0 means unbounded)")
+	serveCmd.Flags().Duration("mailbox-ttl", rendezvous.DEFAULT_MAILBOX_TTL, "time after which mailboxes that did not start relaying are reaped, closing their sender (0 never reaps them)")
+	serveCmd.Flags().Bool("enable-resumptions", false, "store the progress of interrupted transfers on behalf of receivers, such that they resume them from another machine")
+	serveCmd.Flags().Duration("resumption-ttl", rendezvous.DEFAULT_RESUMPTION_TTL, "time the progress of interrupted transfers stored by receivers is kept for")
+	serveCmd.Flags().Int64("resumption-store-size", rendezvous.DEFAULT_RESUMPTION_STORE_SIZE, "maximum bytes of progress of interrupted transfers held in memory, further progress is rejected until stored progress expires")
+	serveCmd.Flags().Duration("success-rate-window", rendezvous.DEFAULT_OUTCOME_WINDOW, "sliding window the success rate of transfers, reported on the /stats endpoint, is computed over")
+	serveCmd.Flags().Bool("anonymize-ips", false, "mask the last octet of client IPv4 addresses, and all but the first 48 bits of IPv6 addresses, in the logs")
+	serveCmd.Flags().String("syslog", "", "address of a syslog server the audit events, transfer summaries and authorization decisions, are sent to (disabled if unset)")
+	serveCmd.Flags().String("syslog-network", "udp", "network the syslog server is reached over, e.g. udp, tcp or unix")
+	serveCmd.Flags().String("audit-log", "", "file each transfer is appended to as a line of JSON, queried by operators over /api/transfers (disabled if unset)")
+	serveCmd.Flags().Int64("audit-log-max-size", rendezvous.DEFAULT_TRANSFER_LOG_MAX_SIZE, "size in bytes after which the audit log is rotated (0 never rotates it)")
+	serveCmd.Flags().Bool("enable-drops", false, "hold payloads sealed by senders in --drop-dir until receivers collect them, for senders and receivers not online at once")
+	serveCmd.Flags().String("drop-dir", "drops", "directory the drops are spooled to, held across restarts")
+	serveCmd.Flags().Int64("drop-spool-size", rendezvous.DEFAULT_DROP_SPOOL_SIZE, "maximum bytes the drops take up in --drop-dir, further drops are rejected until drops are collected or expire")
+	serveCmd.Flags().Duration("drop-ttl", rendezvous.DEFAULT_DROP_TTL, "time drops are held for, after which they are deleted uncollected")
+	serveCmd.Flags().Bool("metrics", false, "serve Prometheus metrics on /metrics of --metrics-addr")
+	serveCmd.Flags().String("metrics-addr", rendezvous.DEFAULT_METRICS_ADDR, "address the Prometheus metrics are served on, separate from the relay")
+	serveCmd.Flags().String("motd", "", "message of the day shown to clients, e.g. to announce maintenance windows")
+	serveCmd.Flags().StringSlice("subprotocols", protocol.SUBPROTOCOLS, "websocket subprotocols negotiated with clients, in order of preference")
+	serveCmd.Flags().IntSlice("nat-probe-ports", nil, "UDP ports answering the NAT probes of portal nat, at least two to tell cone from symmetric NATs (e.g. 3478,3479)")
+	serveCmd.Flags().StringArray("peer", nil, "federate with the relay served over TLS at addr, authenticated in both directions by a token shared with it (wss://addr=token), can be repeated")
+	serveCmd.Flags().String("peer-ca", "", "PEM encoded CA certificates the relays of --peer are verified against, rather than the system roots")
+	serveCmd.Flags().Int("peer-fanout", rendezvous.DEFAULT_PEER_FANOUT, "number of relays of --peer a receiver presenting an unknown code is relayed to at most, in order")
+	serveCmd.Flags().String("id-store-dir", "", "directory persisting the ids bound to senders across restarts, can be shared by relays (in-memory if unset)")
+	serveCmd.Flags().String("locator-dir", "", "directory shared by a cluster of peered relays, locating the relay holding each mailbox such that receivers are relayed straight to it, requires --peer and --advertise-addr")
+	serveCmd.Flags().String("advertise-addr", "", "address of this relay as listed in --peer by the other relays of the cluster")
+	serveCmd.Flags().Duration("id-max-age", 24*time.Hour, "age after which ids left behind in the id store are freed on startup (0 never frees them)")
+	serveCmd.Flags().String("tls-cert", "", "PEM encoded certificate to serve the relay over TLS with, HTTP/2 is negotiated with clients that support it")
+	serveCmd.Flags().String("tls-key", "", "PEM encoded private key of the TLS certificate")
+	serveCmd.Flags().StringSlice("domain", nil, "domains to provision the TLS certificate of the relay for via ACME (Let's Encrypt), answering HTTP-01 challenges on --acme-http-addr")
+	serveCmd.Flags().String("acme-cache-dir", "", "directory caching the certificates provisioned via ACME across restarts (default $HOME/.config/portal/acme)")
+	serveCmd.Flags().String("acme-email", "", "email registered with the ACME account, notified about problems with the certificates")
+	serveCmd.Flags().String("acme-http-addr", rendezvous.DEFAULT_ACME_CHALLENGE_ADDR, "address HTTP-01 challenges are answered on, other plain HTTP requests are redirected to HTTPS")
+	serveCmd.Flags().String("tls-min-version", "", "minimum TLS version accepted from clients, 1.2 or 1.3 (default 1.2), requires --tls-cert")
+	serveCmd.Flags().StringSlice("tls-cipher-suites", nil, "TLS 1.2 cipher suites accepted from clients (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), requires --tls-cert")
+	serveCmd.Flags().String("client-ca", "", "PEM encoded CA certificates client certificates are required to be signed by, requires --tls-cert")
+	serveCmd.MarkFlagsMutuallyExclusive("no-auth-file", "auth-file")
+	serveCmd.MarkFlagFilename("auth-token-file")         //nolint:errcheck
+	serveCmd.MarkFlagFilename("auth-tokens-file")        //nolint:errcheck
+	serveCmd.MarkFlagFilename("auth-file")               //nolint:errcheck
+	serveCmd.MarkFlagFilename("audit-log")               //nolint:errcheck
+	serveCmd.MarkFlagDirname("id-store-dir")             //nolint:errcheck
+	serveCmd.MarkFlagDirname("drop-dir")                 //nolint:errcheck
+	serveCmd.MarkFlagDirname("locator-dir")              //nolint:errcheck
+	serveCmd.MarkFlagDirname("quota-store-dir")          //nolint:errcheck
+	serveCmd.MarkFlagDirname("acme-cache-dir")           //nolint:errcheck
+	serveCmd.MarkFlagFilename("tls-cert", "pem", "crt")  //nolint:errcheck
+	serveCmd.MarkFlagFilename("tls-key", "pem", "key")   //nolint:errcheck
+	serveCmd.MarkFlagFilename("client-ca", "pem", "crt") //nolint:errcheck
+	serveCmd.MarkFlagFilename("peer-ca", "pem", "crt")   //nolint:errcheck
+	serveCmd.Flags().Bool("h2c", false, "serve HTTP/2 without TLS (h2c), e.g. behind a proxy terminating TLS")
 	return serveCmd
 }
+
+// readAuthTokenFile reads the relay authentication token from the provided file, trimming surrounding whitespace.
+func readAuthTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading auth token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("auth token file %q is empty", path)
+	}
+	return token, nil
+}
+
+// validateSubprotocols rejects subprotocols the relay does not implement, and empty subprotocol lists.
+func validateSubprotocols(subprotocols []string) error {
+	if len(subprotocols) == 0 {
+		return errors.New("at least one subprotocol must be negotiated")
+	}
+outer:
+	for _, subprotocol := range subprotocols {
+		for _, supported := range protocol.SUBPROTOCOLS {
+			if subprotocol == supported {
+				continue outer
+			}
+		}
+		return fmt.Errorf("unsupported subprotocol %q, supported subprotocols are %s", subprotocol, strings.Join(protocol.SUBPROTOCOLS, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAuthTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Run("token", func(t *testing.T) {
+		path := filepath.Join(dir, "token")
+		require.NoError(t, os.WriteFile(path, []byte("s3cr3t\r\n\n"), 0600))
+		token, err := readAuthTokenFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cr3t", token)
+	})
+	t.Run("empty", func(t *testing.T) {
+		path := filepath.Join(dir, "empty")
+		require.NoError(t, os.WriteFile(path, []byte(" \n"), 0600))
+		_, err := readAuthTokenFile(path)
+		assert.ErrorContains(t, err, "empty")
+	})
+	t.Run("missing", func(t *testing.T) {
+		_, err := readAuthTokenFile(filepath.Join(dir, "missing"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
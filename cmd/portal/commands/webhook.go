@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------ Webhook ------------------------------------------------------
+
+const progressWebhookFlagDesc = "POST the progress of the transfer as JSON to the provided URL every second, e.g. to display it in a wrapping service"
+
+// PROGRESS_WEBHOOK_TIMEOUT is the time a single POST to the progress webhook is given to complete.
+const PROGRESS_WEBHOOK_TIMEOUT = 5 * time.Second
+
+// webhookProgress is the progress of a transfer, posted to the progress webhook.
+type webhookProgress struct {
+	Bytes int64   `json:"bytes"`           // bytes transferred
+	Total int64   `json:"total,omitempty"` // bytes of the payload, zero if unknown
+	Rate  float64 `json:"rate"`            // bytes per second since the previous update
+}
+
+// progressWebhook posts the progress of a transfer to a HTTP callback, at most once per interval. Updates are
+// posted in the background, such that a slow or failing callback never holds up the transfer. Failures are
+// warned about once on warnings.
+type progressWebhook struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+	warnings io.Writer
+
+	mu      sync.Mutex
+	latest  webhookProgress
+	pending bool // whether the latest progress was not posted yet
+	warned  bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// validateWebhookURL validates the URL of the progress webhook, an absolute http or https URL.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' is not an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+// newProgressWebhook starts posting the progress updated through the webhook to the url every interval, until
+// closed.
+func newProgressWebhook(url string, interval time.Duration, warnings io.Writer) *progressWebhook {
+	w := &progressWebhook{
+		url:      url,
+		client:   &http.Client{Timeout: PROGRESS_WEBHOOK_TIMEOUT},
+		interval: interval,
+		warnings: warnings,
+		done:     make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go w.run(ctx)
+	return w
+}
+
+// update records the progress, posted once the interval passed if it changed.
+func (w *progressWebhook) update(transferred, total int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.latest.Bytes == transferred && w.latest.Total == total {
+		return
+	}
+	w.latest.Bytes, w.latest.Total, w.pending = transferred, total, true
+}
+
+// Close posts the latest progress if not posted yet, and stops posting.
+func (w *progressWebhook) Close() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *progressWebhook) run(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var (
+		posted   int64
+		postedAt = time.Now()
+	)
+	// posts are bound by the timeout of the client rather than the context, such that the final progress is
+	// posted once closed.
+	post := func() {
+		w.mu.Lock()
+		progress, pending := w.latest, w.pending
+		w.pending = false
+		w.mu.Unlock()
+		if !pending {
+			return
+		}
+		progress.Rate = transferRate(progress.Bytes, posted, time.Since(postedAt))
+		posted, postedAt = progress.Bytes, time.Now()
+		if err := w.post(context.Background(), progress); err != nil {
+			w.warn(err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			post()
+			return
+		case <-ticker.C:
+			post()
+		}
+	}
+}
+
+// post posts the progress to the webhook.
+func (w *progressWebhook) post(ctx context.Context, progress webhookProgress) error {
+	body, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// warn warns about the first failure to post the progress.
+func (w *progressWebhook) warn(err error) {
+	if w.warned {
+		return
+	}
+	w.warned = true
+	fmt.Fprintf(w.warnings, "posting progress to webhook: %v, continuing the transfer\n", err)
+}
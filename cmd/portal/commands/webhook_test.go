@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressWebhook(t *testing.T) {
+	payload := strings.Repeat("portal", 100000)
+
+	t.Run("posts progress", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			updates []webhookProgress
+		)
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			var progress webhookProgress
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&progress))
+			mu.Lock()
+			updates = append(updates, progress)
+			mu.Unlock()
+		}))
+		defer stub.Close()
+
+		var warnings bytes.Buffer
+		webhook := newProgressWebhook(stub.URL, 10*time.Millisecond, &warnings)
+		progress := newProgressReporter(nil, "sent", int64(len(payload)))
+		progress.webhook = webhook
+		r := newProgressReader(strings.NewReader(payload), progress)
+		for {
+			_, err := io.CopyN(io.Discard, r, int64(len(payload)/20))
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			time.Sleep(5 * time.Millisecond)
+		}
+		webhook.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Greater(t, len(updates), 1, "progress should be posted while transferring")
+		assert.Less(t, len(updates), 21, "progress should be throttled")
+		for i, update := range updates {
+			assert.Equal(t, int64(len(payload)), update.Total)
+			if i > 0 {
+				assert.Greater(t, update.Bytes, updates[i-1].Bytes)
+			}
+		}
+		assert.Equal(t, int64(len(payload)), updates[len(updates)-1].Bytes, "final progress should be posted on close")
+		assert.Positive(t, updates[0].Rate)
+		assert.Empty(t, warnings.String())
+	})
+
+	t.Run("failures do not interrupt the transfer", func(t *testing.T) {
+		var posts atomic.Int32
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			posts.Add(1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer stub.Close()
+
+		var warnings bytes.Buffer
+		webhook := newProgressWebhook(stub.URL, 10*time.Millisecond, &warnings)
+		progress := newProgressReporter(nil, "sent", int64(len(payload)))
+		progress.webhook = webhook
+		r := newProgressReader(strings.NewReader(payload), progress)
+		for i := 0; i < 5; i++ {
+			_, err := io.CopyN(io.Discard, r, int64(len(payload)/5))
+			require.NoError(t, err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		webhook.Close()
+
+		assert.Greater(t, posts.Load(), int32(1), "progress should be posted after failures")
+		assert.Equal(t, 1, strings.Count(warnings.String(), "posting progress to webhook"), "failures should be warned about once")
+	})
+
+	t.Run("unreachable webhook", func(t *testing.T) {
+		stub := httptest.NewServer(http.NotFoundHandler())
+		url := stub.URL
+		stub.Close()
+
+		var warnings bytes.Buffer
+		webhook := newProgressWebhook(url, 10*time.Millisecond, &warnings)
+		webhook.update(1, 2)
+		webhook.Close()
+		assert.Contains(t, warnings.String(), "posting progress to webhook")
+	})
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	assert.NoError(t, validateWebhookURL("http://localhost:9000/progress"))
+	assert.NoError(t, validateWebhookURL("https://example.com/hook"))
+	for _, url := range []string{"localhost:9000", "ftp://example.com", "/progress", "http://"} {
+		assert.Error(t, validateWebhookURL(url), url)
+	}
+}
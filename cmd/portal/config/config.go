@@ -1,11 +1,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/fatih/structs"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -104,3 +108,62 @@ func Init() error {
 	}
 	return nil
 }
+
+// Validate loads the config file at the provided path and validates the configured options,
+// returning every problem found. A nil slice means the config file is valid.
+func Validate(path string) []error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType(CONFIG_FILE_EXT)
+	if err := v.ReadInConfig(); err != nil {
+		return []error{fmt.Errorf("reading config file: %w", err)}
+	}
+	config := GetDefault()
+	if err := v.Unmarshal(&config); err != nil {
+		return []error{fmt.Errorf("decoding config file: %w", err)}
+	}
+	return config.Validate()
+}
+
+// Validate validates the configured options, returning every problem found.
+func (config Config) Validate() []error {
+	var errs []error
+	relays := conn.SplitAddrs(config.Relay)
+	if len(relays) == 0 {
+		errs = append(errs, errors.New("relay: no relay address provided"))
+	}
+	for _, relay := range relays {
+		if err := validateAddr(relay); err != nil {
+			errs = append(errs, fmt.Errorf("relay: %w", err))
+		}
+	}
+	if config.RelayServePort < 1 || config.RelayServePort > 65535 {
+		errs = append(errs, fmt.Errorf("relay_serve_port: port %d out of range, must be between 1 and 65535", config.RelayServePort))
+	}
+	if config.TuiStyle != StyleRich && config.TuiStyle != StyleRaw {
+		errs = append(errs, fmt.Errorf("tui_style: unknown style '%s', must be one of [%s %s]", config.TuiStyle, StyleRich, StyleRaw))
+	}
+	return errs
+}
+
+// validateAddr checks that the provided relay address is a host with an optional port (":8080", "myrelay.io:1234", ...),
+// prefixed with wss:// or https:// if the relay is served over TLS.
+func validateAddr(addr string) error {
+	if host, _ := conn.SplitScheme(addr); host != "" {
+		addr = host
+	}
+	if strings.Contains(addr, "/") {
+		return fmt.Errorf("invalid address '%s', must be a host with an optional port, without path or scheme other than wss:// or https://", addr)
+	}
+	if !strings.Contains(addr, ":") {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s': %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid address '%s', port must be between 1 and 65535", addr)
+	}
+	return nil
+}
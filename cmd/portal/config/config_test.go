@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(t *testing.T, contents string) string {
+		t.Helper()
+		path := filepath.Join(dir, t.Name()+".yml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+
+	t.Run("default", func(t *testing.T) {
+		assert.Empty(t, Validate(write(t, string(GetDefault().Yaml()))))
+	})
+	t.Run("partial", func(t *testing.T) {
+		// options missing from the file keep their defaults.
+		assert.Empty(t, Validate(write(t, "relay: myrelay.io:1234,wss://backup.myrelay.io\n")))
+	})
+	t.Run("missing file", func(t *testing.T) {
+		errs := Validate(filepath.Join(dir, "missing.yml"))
+		require.Len(t, errs, 1)
+		assert.ErrorIs(t, errs[0], os.ErrNotExist)
+	})
+	t.Run("malformed yaml", func(t *testing.T) {
+		assert.Len(t, Validate(write(t, "relay: [unterminated\n")), 1)
+	})
+	t.Run("wrong type", func(t *testing.T) {
+		errs := Validate(write(t, "relay_serve_port: eighty\n"))
+		require.Len(t, errs, 1)
+		assert.ErrorContains(t, errs[0], "relay_serve_port")
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		errs := Validate(write(t, "relay: ftp://myrelay.io,myrelay.io:99999\nrelay_serve_port: 70000\ntui_style: fancy\n"))
+		require.Len(t, errs, 4, "every problem is reported")
+		assert.ErrorContains(t, errs[0], "ftp://myrelay.io")
+		assert.ErrorContains(t, errs[1], "myrelay.io:99999")
+		assert.ErrorContains(t, errs[2], "relay_serve_port")
+		assert.ErrorContains(t, errs[3], "tui_style")
+	})
+	t.Run("empty relay", func(t *testing.T) {
+		errs := Validate(write(t, "relay: ''\n"))
+		require.Len(t, errs, 1)
+		assert.ErrorContains(t, errs[0], "relay")
+	})
+}
@@ -0,0 +1,340 @@
+// Package history specifies the local history of the transfers of the client, encrypted at rest such that the
+// names of the transferred files are not left readable on disk.
+//
+// The history is a JSON list of entries sealed with AES-GCM under a random key, kept in the keyring of the OS rather
+// than next to the history. Every write seals the history under a fresh random nonce.
+// Histories that cannot be opened with the key, e.g. as the key was lost, are reset by the next recorded entry.
+package history
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/config"
+	"github.com/mitchellh/go-homedir"
+	"github.com/zalando/go-keyring"
+)
+
+// HISTORY_FILE_NAME is the name of the file of the sealed history.
+const HISTORY_FILE_NAME = "history"
+
+// HISTORY_KEY_FILE_NAME is the name of the file the key of the history was kept in by earlier versions, moved to
+// the keyring once the history is opened.
+const HISTORY_KEY_FILE_NAME = "history.key"
+
+// The service and user the key of the history is kept under in the keyring of the OS.
+const (
+	KEYRING_SERVICE = "portal"
+	KEYRING_USER    = "history"
+)
+
+// MAX_ENTRIES is the number of entries kept in the history, older entries are dropped.
+const MAX_ENTRIES = 1000
+
+const keyBytes = 32
+
+// Directions of transfers.
+const (
+	SEND    = "send"
+	RECEIVE = "receive"
+)
+
+// Outcomes of transfers.
+const (
+	COMPLETED = "completed"
+	FAILED    = "failed"
+)
+
+// ErrNotFound is returned when looking up an id the history holds no entry of.
+var ErrNotFound = errors.New("no entry of the id in the history")
+
+// ErrCorrupt is returned when the history cannot be opened with its key, i.e. it was tampered with or its key lost.
+var ErrCorrupt = errors.New("history cannot be opened with its key")
+
+// ErrReset is returned along with the recorded entry when the history could not be opened with its key and was
+// reset, dropping its past entries.
+var ErrReset = errors.New("history could not be opened with its key and was reset")
+
+// ErrNoKey is returned by keyrings holding no key of the history.
+var ErrNoKey = errors.New("no history key in the keyring")
+
+// Keyring keeps the key of the history.
+type Keyring interface {
+	// Get returns the key, or ErrNoKey.
+	Get() ([]byte, error)
+	Set(key []byte) error
+	// Delete removes the key, if any.
+	Delete() error
+}
+
+// OSKeyring is the keyring of the OS, i.e. the Keychain on macOS, the Credential Manager on Windows and the Secret
+// Service on Linux.
+type OSKeyring struct{}
+
+// Get implements Keyring.
+func (OSKeyring) Get() ([]byte, error) {
+	secret, err := keyring.Get(KEYRING_SERVICE, KEYRING_USER)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrNoKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history key from the keyring: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return key, nil
+}
+
+// Set implements Keyring.
+func (OSKeyring) Set(key []byte) error {
+	if err := keyring.Set(KEYRING_SERVICE, KEYRING_USER, base64.StdEncoding.EncodeToString(key)); err != nil {
+		return fmt.Errorf("writing history key to the keyring: %w", err)
+	}
+	return nil
+}
+
+// Delete implements Keyring.
+func (OSKeyring) Delete() error {
+	if err := keyring.Delete(KEYRING_SERVICE, KEYRING_USER); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("removing history key from the keyring: %w", err)
+	}
+	return nil
+}
+
+// Entry is a transfer recorded in the history.
+type Entry struct {
+	ID        int           `json:"id"`
+	Direction string        `json:"direction"`           // SEND or RECEIVE
+	Files     []string      `json:"files,omitempty"`     // sent paths, absolute, or names of the received files
+	Size      int64         `json:"size"`                // size of the files in bytes
+	Transport string        `json:"transport,omitempty"` // transport negotiated by the peers, e.g. direct, empty if unknown
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	Outcome   string        `json:"outcome"` // COMPLETED or FAILED
+	Error     string        `json:"error,omitempty"`
+}
+
+// Store is the history kept in a directory. Writes of several processes at once are not serialized, the last
+// write wins.
+type Store struct {
+	dir  string
+	keys Keyring
+}
+
+// Open returns the history kept in the provided directory, sealed with the key kept in the keyring, created once
+// an entry is recorded.
+func Open(dir string, keys Keyring) *Store {
+	return &Store{dir: dir, keys: keys}
+}
+
+// OpenDefault returns the history kept in the config directory of portal.
+func OpenDefault() (*Store, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, fmt.Errorf("resolving home dir: %w", err)
+	}
+	return Open(filepath.Join(home, config.CONFIGS_DIR_NAME, config.PORTAL_CONFIG_DIR_NAME), OSKeyring{}), nil
+}
+
+// Record records the entry in the history under the next id, returning the recorded entry. Histories that cannot
+// be opened with their key are reset, returning the recorded entry along with ErrReset.
+func (s *Store) Record(entry Entry) (Entry, error) {
+	entries, err := s.List()
+	reset := errors.Is(err, ErrCorrupt)
+	if reset {
+		if err := s.Clear(); err != nil {
+			return Entry{}, err
+		}
+		entries = nil
+	} else if err != nil {
+		return Entry{}, err
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+	entries = append(entries, entry)
+	if len(entries) > MAX_ENTRIES {
+		entries = entries[len(entries)-MAX_ENTRIES:]
+	}
+	if err := s.write(entries); err != nil {
+		return Entry{}, err
+	}
+	if reset {
+		return entry, ErrReset
+	}
+	return entry, nil
+}
+
+// List returns the entries of the history, oldest first.
+func (s *Store) List() ([]Entry, error) {
+	sealed, err := os.ReadFile(filepath.Join(s.dir, HISTORY_FILE_NAME))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	key, err := s.loadKey()
+	if errors.Is(err, ErrNoKey) {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(sealed, key)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return entries, nil
+}
+
+// Get returns the entry of the id, or ErrNotFound.
+func (s *Store) Get(id int) (Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("%w: %d", ErrNotFound, id)
+}
+
+// Clear removes the history along with its key.
+func (s *Store) Clear() error {
+	for _, name := range []string{HISTORY_FILE_NAME, HISTORY_KEY_FILE_NAME} {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing history: %w", err)
+		}
+	}
+	return s.keys.Delete()
+}
+
+// write seals the entries and replaces the history with them, creating the key of the history if missing.
+func (s *Store) write(entries []Entry) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	key, err := s.key()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(plain, key)
+	if err != nil {
+		return err
+	}
+	// the history is replaced atomically, such that an interrupted write does not lose it.
+	temp, err := os.CreateTemp(s.dir, HISTORY_FILE_NAME)
+	if err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(sealed); err != nil {
+		temp.Close()
+		return fmt.Errorf("writing history: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(s.dir, HISTORY_FILE_NAME)); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return nil
+}
+
+// key returns the key of the history, generating it if no history was written yet.
+func (s *Store) key() ([]byte, error) {
+	key, err := s.loadKey()
+	if !errors.Is(err, ErrNoKey) {
+		return key, err
+	}
+	key = make([]byte, keyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating history key: %w", err)
+	}
+	if err := s.keys.Set(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadKey returns the key of the history from the keyring, or ErrNoKey. Keys kept next to the history by earlier
+// versions are moved to the keyring.
+func (s *Store) loadKey() ([]byte, error) {
+	path := filepath.Join(s.dir, HISTORY_KEY_FILE_NAME)
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.keys.Get()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history key: %w", err)
+	}
+	if err := s.keys.Set(key); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("removing history key: %w", err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keyBytes {
+		return nil, fmt.Errorf("%w: invalid key of %d bytes", ErrCorrupt, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal seals the plaintext with the key under a random nonce, prepended to the sealed plaintext.
+func seal(plain, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open opens the plaintext sealed by seal with the key.
+func open(sealed, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plain, nil
+}
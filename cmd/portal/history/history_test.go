@@ -0,0 +1,128 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memKeyring is a keyring kept in memory.
+type memKeyring struct{ key []byte }
+
+func (k *memKeyring) Get() ([]byte, error) {
+	if k.key == nil {
+		return nil, ErrNoKey
+	}
+	return k.key, nil
+}
+
+func (k *memKeyring) Set(key []byte) error {
+	k.key = key
+	return nil
+}
+
+func (k *memKeyring) Delete() error {
+	k.key = nil
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	keys := &memKeyring{}
+	store := Open(dir, keys)
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no history is written until a transfer is recorded")
+
+	started := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	sent, err := store.Record(Entry{Direction: SEND, Files: []string{"/home/frog/secret-plans.pdf"}, Size: 1024, Transport: "direct", Started: started, Outcome: COMPLETED})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent.ID)
+	received, err := store.Record(Entry{Direction: RECEIVE, Started: started, Outcome: FAILED, Error: "connection lost"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, received.ID)
+
+	t.Run("list", func(t *testing.T) {
+		entries, err := store.List()
+		require.NoError(t, err)
+		assert.Equal(t, []Entry{sent, received}, entries)
+	})
+	t.Run("get", func(t *testing.T) {
+		entry, err := store.Get(1)
+		require.NoError(t, err)
+		assert.Equal(t, sent, entry)
+		_, err = store.Get(3)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("encrypted at rest", func(t *testing.T) {
+		b, err := os.ReadFile(filepath.Join(dir, HISTORY_FILE_NAME))
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "secret-plans")
+		assert.NoFileExists(t, filepath.Join(dir, HISTORY_KEY_FILE_NAME), "the key is kept in the keyring")
+		assert.Len(t, keys.key, keyBytes)
+	})
+	t.Run("tampered", func(t *testing.T) {
+		tampered := t.TempDir()
+		b, err := os.ReadFile(filepath.Join(dir, HISTORY_FILE_NAME))
+		require.NoError(t, err)
+		b[len(b)-1] ^= 1
+		require.NoError(t, os.WriteFile(filepath.Join(tampered, HISTORY_FILE_NAME), b, 0600))
+		_, err = Open(tampered, &memKeyring{key: keys.key}).List()
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+	t.Run("lost key", func(t *testing.T) {
+		lost := t.TempDir()
+		b, err := os.ReadFile(filepath.Join(dir, HISTORY_FILE_NAME))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(lost, HISTORY_FILE_NAME), b, 0600))
+		reset := Open(lost, &memKeyring{})
+		_, err = reset.List()
+		assert.ErrorIs(t, err, ErrCorrupt)
+
+		// the history is reset by the next recorded entry, rather than failing every record.
+		entry, err := reset.Record(Entry{Direction: SEND})
+		assert.ErrorIs(t, err, ErrReset)
+		assert.Equal(t, 1, entry.ID)
+		entries, err := reset.List()
+		require.NoError(t, err)
+		assert.Equal(t, []Entry{entry}, entries)
+		_, err = reset.Record(Entry{Direction: SEND})
+		assert.NoError(t, err)
+	})
+	t.Run("key kept next to the history", func(t *testing.T) {
+		legacy := t.TempDir()
+		b, err := os.ReadFile(filepath.Join(dir, HISTORY_FILE_NAME))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(legacy, HISTORY_FILE_NAME), b, 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(legacy, HISTORY_KEY_FILE_NAME), keys.key, 0600))
+		moved := &memKeyring{}
+		entries, err := Open(legacy, moved).List()
+		require.NoError(t, err)
+		assert.Equal(t, []Entry{sent, received}, entries)
+		assert.Equal(t, keys.key, moved.key)
+		assert.NoFileExists(t, filepath.Join(legacy, HISTORY_KEY_FILE_NAME))
+	})
+	t.Run("trimmed", func(t *testing.T) {
+		trimmed := Open(t.TempDir(), &memKeyring{})
+		for i := 0; i < MAX_ENTRIES+1; i++ {
+			_, err := trimmed.Record(Entry{Direction: SEND})
+			require.NoError(t, err)
+		}
+		entries, err := trimmed.List()
+		require.NoError(t, err)
+		require.Len(t, entries, MAX_ENTRIES)
+		assert.Equal(t, 2, entries[0].ID)
+	})
+	t.Run("clear", func(t *testing.T) {
+		require.NoError(t, store.Clear())
+		entries, err := store.List()
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.Nil(t, keys.key)
+	})
+}
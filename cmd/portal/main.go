@@ -33,8 +33,15 @@ func Root() (*cobra.Command, error) {
 		commands.Send(version),
 		commands.Receive(version),
 		commands.Serve(version),
+		commands.Bench(),
+		commands.NAT(),
 		commands.Version(version),
-		commands.Config())
+		commands.Completion(),
+		commands.Config(),
+		commands.History())
+	// the default completion command is replaced by commands.Completion.
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	commands.WithUsageErrors(rootCmd)
 	return rootCmd, nil
 }
 
@@ -45,9 +52,7 @@ func main() {
 	rootCmd, err := Root()
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
-	}
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(commands.EXIT_USAGE)
 	}
+	os.Exit(commands.ExitCode(rootCmd.Execute()))
 }
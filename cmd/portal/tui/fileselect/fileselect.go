@@ -0,0 +1,313 @@
+// Package fileselect specifies the browser of the files offered by a sender, in which receivers select the files
+// they accept. Files are listed as a tree of their directories, which are collapsed and selected as a whole.
+package fileselect
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+const defaultMaxHeight = 10
+
+var listStyle = tui.BaseStyle.Copy().
+	BorderStyle(lipgloss.RoundedBorder()).
+	BorderForeground(lipgloss.Color(tui.SECONDARY_COLOR)).
+	MarginLeft(tui.MARGIN).
+	Padding(0, tui.PADDING)
+
+var cursorStyle = tui.BaseStyle.Copy().
+	Foreground(lipgloss.Color(tui.DARK_COLOR)).
+	Background(lipgloss.Color(tui.SECONDARY_ELEMENT_COLOR)).
+	Render
+
+// KeyMap are the keys browsing the files.
+type KeyMap struct {
+	Up       key.Binding
+	Down     key.Binding
+	Toggle   key.Binding
+	All      key.Binding
+	Expand   key.Binding
+	Collapse key.Binding
+	Confirm  key.Binding
+}
+
+func (k KeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Toggle, k.All, k.Expand, k.Collapse, k.Confirm}
+}
+
+func (k KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{k.ShortHelp()}
+}
+
+var Keys = KeyMap{
+	Up: key.NewBinding(
+		key.WithKeys("up", "k"),
+		key.WithHelp("(↑/k)", "up"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "j"),
+		key.WithHelp("(↓/j)", "down"),
+	),
+	Toggle: key.NewBinding(
+		key.WithKeys(" ", "x"),
+		key.WithHelp("(space)", "select"),
+	),
+	All: key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("(a)", "select all"),
+	),
+	Expand: key.NewBinding(
+		key.WithKeys("right", "l"),
+		key.WithHelp("(→/l)", "expand"),
+	),
+	Collapse: key.NewBinding(
+		key.WithKeys("left", "h"),
+		key.WithHelp("(←/h)", "collapse"),
+	),
+	Confirm: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("(⏎ )", "receive selected"),
+	),
+}
+
+// DoneMsg announces the names of the files selected once the selection is confirmed.
+type DoneMsg struct {
+	Selected []string
+}
+
+// entry is a file, or a directory of files, of the manifest.
+type entry struct {
+	path  string // path of the file, or of the directory without a trailing slash
+	depth int
+	dir   bool
+	files []int // indices of the files of the manifest the entry holds
+}
+
+type Model struct {
+	Width     int
+	MaxHeight int
+
+	manifest  []transfer.ManifestFile
+	entries   []entry
+	selected  []bool          // by index of the file in the manifest
+	collapsed map[string]bool // by path of the directory
+	cursor    int             // index of the entry under the cursor among the visible entries
+	offset    int             // index of the first visible entry displayed
+	done      bool
+}
+
+// New returns the browser of the files of the manifest, every file selected.
+func New(manifest []transfer.ManifestFile) Model {
+	m := Model{
+		MaxHeight: defaultMaxHeight,
+		manifest:  manifest,
+		selected:  make([]bool, len(manifest)),
+		collapsed: map[string]bool{},
+	}
+	dirs := map[string]int{}
+	for i, f := range manifest {
+		parts := strings.Split(strings.Trim(f.Name, "/"), "/")
+		for depth := range parts[:len(parts)-1] {
+			dir := strings.Join(parts[:depth+1], "/")
+			j, ok := dirs[dir]
+			if !ok {
+				j = len(m.entries)
+				dirs[dir] = j
+				m.entries = append(m.entries, entry{path: dir, depth: depth, dir: true})
+			}
+			m.entries[j].files = append(m.entries[j].files, i)
+		}
+		m.entries = append(m.entries, entry{path: f.Name, depth: len(parts) - 1, files: []int{i}})
+		m.selected[i] = true
+	}
+	return m
+}
+
+// Selected returns the names of the selected files, in the order of the manifest.
+func (m Model) Selected() []string {
+	var selected []string
+	for _, i := range m.selectedIndices() {
+		selected = append(selected, m.manifest[i].Name)
+	}
+	return selected
+}
+
+// Done returns whether the selection was confirmed.
+func (m Model) Done() bool {
+	return m.done
+}
+
+// visible returns the indices of the entries outside of collapsed directories.
+func (m Model) visible() []int {
+	var visible []int
+	var hiddenUnder string
+	for i, e := range m.entries {
+		if hiddenUnder != "" && strings.HasPrefix(e.path, hiddenUnder+"/") {
+			continue
+		}
+		hiddenUnder = ""
+		visible = append(visible, i)
+		if e.dir && m.collapsed[e.path] {
+			hiddenUnder = e.path
+		}
+	}
+	return visible
+}
+
+// state returns how many of the files of the entry are selected, and their total size.
+func (m Model) state(e entry) (int, int64) {
+	var selected int
+	var size int64
+	for _, i := range e.files {
+		if m.selected[i] {
+			selected++
+		}
+		size += m.manifest[i].Size
+	}
+	return selected, size
+}
+
+// setAll selects, or deselects, every file of the entry.
+func (m *Model) setAll(e entry, selected bool) {
+	for _, i := range e.files {
+		m.selected[i] = selected
+	}
+}
+
+func (Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width = msg.Width - 2*tui.MARGIN - 4
+		if m.Width > tui.MAX_WIDTH {
+			m.Width = tui.MAX_WIDTH
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		if m.done || len(m.entries) == 0 {
+			return m, nil
+		}
+		visible := m.visible()
+		current := m.entries[visible[m.cursor]]
+		switch {
+		case key.Matches(msg, Keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case key.Matches(msg, Keys.Down):
+			if m.cursor < len(visible)-1 {
+				m.cursor++
+			}
+		case key.Matches(msg, Keys.Toggle):
+			selected, _ := m.state(current)
+			m.setAll(current, selected < len(current.files))
+		case key.Matches(msg, Keys.All):
+			all := len(m.selectedIndices()) < len(m.manifest)
+			for i := range m.selected {
+				m.selected[i] = all
+			}
+		case key.Matches(msg, Keys.Expand):
+			delete(m.collapsed, current.path)
+		case key.Matches(msg, Keys.Collapse):
+			if current.dir && !m.collapsed[current.path] {
+				m.collapsed[current.path] = true
+				break
+			}
+			// collapsing a file, or a collapsed directory, moves the cursor to its parent directory.
+			for i := m.cursor - 1; i >= 0; i-- {
+				if e := m.entries[visible[i]]; e.dir && e.depth < current.depth {
+					m.cursor = i
+					break
+				}
+			}
+		case key.Matches(msg, Keys.Confirm):
+			selected := m.Selected()
+			if len(selected) == 0 {
+				return m, nil
+			}
+			m.done = true
+			return m, func() tea.Msg { return DoneMsg{Selected: selected} }
+		}
+		m.scroll()
+	}
+	return m, nil
+}
+
+// scroll keeps the cursor within the displayed entries.
+func (m *Model) scroll() {
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.MaxHeight {
+		m.offset = m.cursor - m.MaxHeight + 1
+	}
+}
+
+func (m Model) View() string {
+	if m.done || len(m.entries) == 0 {
+		return ""
+	}
+	width := int(math.Min(tui.MAX_WIDTH-2*tui.MARGIN, float64(m.Width))) - 2*tui.PADDING
+	visible := m.visible()
+	var lines []string
+	for i := m.offset; i < len(visible) && i < m.offset+m.MaxHeight; i++ {
+		e := m.entries[visible[i]]
+		selected, size := m.state(e)
+		box := "[ ]"
+		switch {
+		case selected == len(e.files):
+			box = "[x]"
+		case selected > 0:
+			box = "[-]"
+		}
+		name := e.path[strings.LastIndex(e.path, "/")+1:]
+		if e.dir {
+			marker := "▾ "
+			if m.collapsed[e.path] {
+				marker = "▸ "
+			}
+			name = marker + name + "/"
+		}
+		prefix := fmt.Sprintf("%s %s", box, strings.Repeat("  ", e.depth))
+		suffix := " " + tui.ByteCountSI(size)
+		// truncate overflowing names from the left, keeping the extension visible.
+		if maxName := width - runewidth.StringWidth(prefix) - runewidth.StringWidth(suffix); maxName > 0 && runewidth.StringWidth(name) > maxName {
+			name = runewidth.TruncateLeft(name, runewidth.StringWidth(name)-maxName+1, "…")
+		}
+		line := prefix + name
+		if pad := width - runewidth.StringWidth(line) - runewidth.StringWidth(suffix); pad > 0 {
+			line += strings.Repeat(" ", pad)
+		}
+		line += suffix
+		if i == m.cursor {
+			line = cursorStyle(line)
+		}
+		lines = append(lines, line)
+	}
+	selected, size := m.state(entry{files: m.selectedIndices()})
+	summary := tui.HelpStyle(fmt.Sprintf("%d of %d files selected (%s)", selected, len(m.manifest), tui.ByteCountSI(size)))
+	return listStyle.Render(strings.Join(lines, "\n")) + "\n\n" + tui.PadText + summary + "\n\n"
+}
+
+// selectedIndices returns the indices of the selected files in the manifest.
+func (m Model) selectedIndices() []int {
+	var indices []int
+	for i, ok := range m.selected {
+		if ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
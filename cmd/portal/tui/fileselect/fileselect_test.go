@@ -0,0 +1,78 @@
+package fileselect
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	manifest := []transfer.ManifestFile{
+		{Name: "photos/a.jpg", Size: 1000},
+		{Name: "photos/raw/b.dng", Size: 20000},
+		{Name: "notes.txt", Size: 10},
+	}
+	keys := func(m Model, keys ...string) (Model, tea.Cmd) {
+		var cmd tea.Cmd
+		for _, k := range keys {
+			var msg tea.KeyMsg
+			switch k {
+			case "down":
+				msg = tea.KeyMsg{Type: tea.KeyDown}
+			case "left":
+				msg = tea.KeyMsg{Type: tea.KeyLeft}
+			case "enter":
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			default:
+				msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			}
+			model, c := m.Update(msg)
+			m, cmd = model.(Model), c
+		}
+		return m, cmd
+	}
+
+	t.Run("directories", func(t *testing.T) {
+		m := New(manifest)
+		assert.Equal(t, []string{"photos/a.jpg", "photos/raw/b.dng", "notes.txt"}, m.Selected(), "every file should be selected initially")
+		// the cursor starts on photos/, deselecting the directory deselects its files.
+		m, _ = keys(m, " ")
+		assert.Equal(t, []string{"notes.txt"}, m.Selected())
+		// photos/raw/b.dng, selected alone.
+		m, _ = keys(m, "down", "down", "down", " ")
+		assert.Equal(t, []string{"photos/raw/b.dng", "notes.txt"}, m.Selected())
+		assert.Contains(t, m.View(), "[-] ▾ photos/", "partially selected directories should be marked")
+
+		// collapsing photos/raw/ hides its file, notes.txt follows it.
+		m, _ = keys(m, "left", "left", "down", " ")
+		assert.Equal(t, []string{"photos/raw/b.dng"}, m.Selected())
+		assert.NotContains(t, m.View(), "b.dng")
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		m, cmd := keys(New(manifest), "a", "enter")
+		assert.Nil(t, cmd, "nothing selected should not be confirmed")
+		assert.False(t, m.Done())
+
+		m, cmd = keys(m, "a", "enter")
+		require.NotNil(t, cmd)
+		assert.Equal(t, DoneMsg{Selected: []string{"photos/a.jpg", "photos/raw/b.dng", "notes.txt"}}, cmd())
+		assert.True(t, m.Done())
+	})
+
+	t.Run("fits width", func(t *testing.T) {
+		long := []transfer.ManifestFile{{Name: strings.Repeat("very-long-name-", 10) + ".txt", Size: 10}}
+		for _, width := range []int{40, 80, 120} {
+			model, _ := New(long).Update(tea.WindowSizeMsg{Width: width})
+			for _, line := range strings.Split(model.View(), "\n") {
+				assert.LessOrEqual(t, lipgloss.Width(line), width, "line %q at width %d", line, width)
+			}
+			assert.Contains(t, model.View(), ".txt", "truncated names should keep their extension")
+		}
+	})
+}
@@ -7,13 +7,17 @@ import (
 	"io"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	"github.com/SpatiumPortae/portal/cmd/portal/tui/fileselect"
 	"github.com/SpatiumPortae/portal/cmd/portal/tui/filetable"
 	"github.com/SpatiumPortae/portal/cmd/portal/tui/transferprogress"
+	"github.com/SpatiumPortae/portal/internal/checksum"
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/portal"
 	"github.com/SpatiumPortae/portal/internal/receiver"
 	"github.com/SpatiumPortae/portal/internal/semver"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
@@ -25,6 +29,7 @@ import (
 	"github.com/erikgeiser/promptkit"
 	"github.com/erikgeiser/promptkit/confirmation"
 	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
 )
 
 // ------------------------------------------------------ tui State -----------------------------------------------------
@@ -33,6 +38,7 @@ type tuiState int
 // Flows from the top down.
 const (
 	showEstablishing tuiState = iota
+	showSelecting
 	showReceivingProgress
 	showDecompressing
 	showOverwritePrompt
@@ -48,6 +54,12 @@ type payloadSizeMsg struct {
 	size int64
 }
 
+// selectionMsg asks for the files of the manifest of the sender to receive, answered on selected.
+type selectionMsg struct {
+	manifest []transfer.ManifestFile
+	selected chan []string
+}
+
 type receiveDoneMsg struct {
 	temp *os.File
 }
@@ -71,6 +83,54 @@ func WithVersion(version semver.Version) Option {
 	}
 }
 
+// WithStrictVersionCheck refuses to connect to a server whose version cannot be verified.
+func WithStrictVersionCheck() Option {
+	return func(m *model) {
+		m.strict = true
+	}
+}
+
+func WithDialOptions(opts ...conn.DialOption) Option {
+	return func(m *model) {
+		m.dialOpts = append(m.dialOpts, opts...)
+	}
+}
+
+// WithExtract defines whether the received archive is extracted or saved as a single tar file.
+func WithExtract(extract file.Extract) Option {
+	return func(m *model) {
+		m.extract = extract
+	}
+}
+
+// WithFileSelection browses the files offered by the sender once connected, only the selected files are sent.
+func WithFileSelection() Option {
+	return func(m *model) {
+		m.selectFiles = true
+	}
+}
+
+// WithUnpackOptions applies the provided options when unpacking the received files.
+func WithUnpackOptions(opts ...file.UnpackOption) Option {
+	return func(m *model) {
+		m.unpackOpts = append(m.unpackOpts, opts...)
+	}
+}
+
+// WithTransport connects directly to the sender over the provided transport, see receiver.WithTransport.
+func WithTransport(transport string) Option {
+	return func(m *model) {
+		m.transport = transport
+	}
+}
+
+// WithRateLimit receives the payload at most at the provided rate in bytes per second, see portal.Config.RateLimit.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(m *model) {
+		m.limiter = portal.NewLimiter(bytesPerSecond)
+	}
+}
+
 type model struct {
 	state        tuiState
 	transferType transfer.Type
@@ -80,22 +140,36 @@ type model struct {
 	msgs chan interface{}
 
 	rendezvousAddr string
+	dialOpts       []conn.DialOption
+	strict         bool
+	extract        file.Extract
+	unpackOpts     []file.UnpackOption
+	selectFiles    bool
+	selection      chan []string // answers the selection of the files of the sender while selecting
+	transport      string
+	limiter        *rate.Limiter
 
 	receivedFiles           []string
+	text                    *string // text message received in place of files, displayed rather than written to disk
 	payloadSize             int64
 	decompressedPayloadSize int64
 	version                 *semver.Version
 
 	unpacker *file.Unpacker
 	commiter file.Committer
+	// number of objects whose ownership could not be preserved, counted while unpacking.
+	ownershipSkipped *int
 
 	width            int
 	spinner          spinner.Model
 	transferProgress transferprogress.Model
 	fileTable        filetable.Model
+	fileSelect       fileselect.Model
 	overwritePrompt  confirmation.Model
 	help             help.Model
 	keys             tui.KeyMap
+
+	err error // error the program exited with
 }
 
 // New creates a new receiver program.
@@ -110,6 +184,8 @@ func New(addr string, password string, opts ...Option) *tea.Program {
 		help:             help.New(),
 		keys:             tui.Keys,
 		ctx:              context.Background(),
+		ownershipSkipped: new(int),
+		transport:        transfer.TRANSPORT_AUTO,
 	}
 	for _, opt := range opts {
 		opt(&m)
@@ -119,22 +195,49 @@ func New(addr string, password string, opts ...Option) *tea.Program {
 }
 
 func (m model) Init() tea.Cmd {
+	if m.strict {
+		if m.version == nil {
+			//lint:ignore ST1005 error string displayed in tui
+			return func() tea.Msg {
+				return tui.ErrorMsg(errors.New("Portal version unknown, unable to verify server version in strict mode"))
+			}
+		}
+		// nothing is sent to the server before its version is verified.
+		return tea.Batch(m.spinner.Tick, tui.VersionCmd(m.ctx, m.rendezvousAddr, m.dialOpts...))
+	}
 	var versionCmd tea.Cmd
 	if m.version != nil {
-		versionCmd = tui.VersionCmd(m.ctx, m.rendezvousAddr)
+		versionCmd = tui.VersionCmd(m.ctx, m.rendezvousAddr, m.dialOpts...)
 	}
-	return tea.Sequence(versionCmd, tea.Batch(m.spinner.Tick, connectCmd(m.rendezvousAddr)))
+	return tea.Sequence(versionCmd, tea.Batch(m.spinner.Tick, m.startCmd()))
+}
+
+// startCmd starts the receive sequence.
+func (m model) startCmd() tea.Cmd {
+	return connectCmd(m.rendezvousAddr, m.dialOpts...)
+}
+
+// streams returns the parallel streams accepted by the receiver, the sender decides how many are used.
+func (m model) streams() receiver.Streams {
+	return receiver.Streams{Addr: m.rendezvousAddr, Count: transfer.MAX_STREAMS, Opts: m.dialOpts}
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tui.VersionMsg:
+		if msg.Err != nil {
+			if m.strict {
+				//lint:ignore ST1005 error string displayed in tui
+				return m.fail(fmt.Errorf("Unable to verify server version in strict mode: %w", msg.Err))
+			}
+			return m, tui.TaskCmd(tui.WarningText(fmt.Sprintf("Unable to verify server version: %s", msg.Err)), nil)
+		}
 		var message string
 		switch m.version.Compare(msg.ServerVersion) {
 		case semver.CompareNewMajor,
 			semver.CompareOldMajor:
 			//lint:ignore ST1005 error string displayed in tui
-			return m, tui.ErrorCmd(fmt.Errorf("Portal version (%s) incompatible with server version (%s)", m.version, msg.ServerVersion))
+			return m.fail(fmt.Errorf("Portal version (%s) %w with server version (%s)", m.version, semver.ErrIncompatible, msg.ServerVersion))
 		case semver.CompareNewMinor,
 			semver.CompareNewPatch:
 			message = tui.WarningText(fmt.Sprintf("Portal version (%s) newer than server version (%s)", m.version, msg.ServerVersion))
@@ -144,16 +247,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case semver.CompareEqual:
 			message = tui.SuccessText(fmt.Sprintf("Portal version (%s) compatible with server version (%s)", m.version, msg.ServerVersion))
 		}
-		return m, tui.TaskCmd(message, nil)
+		var next tea.Cmd
+		if m.strict {
+			next = m.startCmd()
+		}
+		return m, tui.TaskCmd(message, next)
 
 	case connectMsg:
 		message := fmt.Sprintf("Connected to Portal server (%s)", m.rendezvousAddr)
-		return m, tui.TaskCmd(message, secureCmd(m.ctx, msg.conn, m.password))
+		return m, tui.TaskCmd(message, secureCmd(m.ctx, msg.conn, m.rendezvousAddr, m.password, m.dialOpts...))
 
 	case tui.SecureMsg:
-		message := "Established encrypted connection to sender"
+		message := fmt.Sprintf("Established encrypted connection to sender (fingerprint %s)", msg.Conn.Fingerprint())
 		return m, tui.TaskCmd(message,
-			tea.Batch(listenReceiveCmd(m.msgs), receiveCmd(m.ctx, msg.Conn, m.msgs)))
+			tea.Batch(listenReceiveCmd(m.msgs), receiveCmd(m.ctx, msg.Conn, m.limiter,
+				receiver.WithStreams(m.streams()), receiver.WithSelect(m.selectFunc()), receiver.WithTransport(m.transport), receiver.WithMessages(m.msgs))))
+
+	case selectionMsg:
+		m.state = showSelecting
+		m.selection = msg.selected
+		width := m.fileSelect.Width
+		m.fileSelect = fileselect.New(msg.manifest)
+		m.fileSelect.Width = width
+		var size int64
+		for _, f := range msg.manifest {
+			size += f.Size
+		}
+		return m, tui.TaskCmd(fmt.Sprintf("Sender offered %d files (%s)", len(msg.manifest), tui.ByteCountSI(size)), nil)
+
+	case fileselect.DoneMsg:
+		m.selection <- msg.Selected
+		m.resetSpinner()
+		return m, tui.TaskCmd(fmt.Sprintf("Selected %d files", len(msg.Selected)), tea.Batch(m.spinner.Tick, listenReceiveCmd(m.msgs)))
 
 	case payloadSizeMsg:
 		m.payloadSize = msg.size
@@ -171,6 +296,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tui.TaskCmd(message, listenReceiveCmd(m.msgs))
 
+	case tui.ChecksumMsg:
+		return m, tui.TaskCmd(fmt.Sprintf("Verified checksum %s", checksum.Result(msg)), listenReceiveCmd(m.msgs))
+
+	case tui.CompressionMsg:
+		m.transferProgress.RawSize = msg.RawSize
+		return m, listenReceiveCmd(m.msgs)
+
 	case tui.ProgressMsg:
 		cmds := []tea.Cmd{listenReceiveCmd(m.msgs)}
 		if m.state != showReceivingProgress {
@@ -196,10 +328,33 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.fileTable.SetMaxHeight(math.MaxInt)
 		m.fileTable = m.fileTable.Finalize().(filetable.Model)
 
-		var err error
-		m.unpacker, err = file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), msg.temp)
+		// text messages are displayed rather than written to disk, unless an output path is provided.
+		if viper.GetString("output") == "" {
+			text, ok, err := file.ReadText(msg.temp, m.unpackOpts...)
+			if err != nil {
+				return m.fail(fmt.Errorf("reading text message: %w", err))
+			}
+			if ok {
+				msg.temp.Close()
+				text = tui.PrintableText(text)
+				m.text = &text
+				m.state = showFinished
+				return m, tui.TaskCmd(message, tui.QuitCmd())
+			}
+		}
+		target, err := file.ResolveOutput(msg.temp, viper.GetString("output"), m.extract)
 		if err != nil {
-			return m, tui.ErrorCmd(err)
+			return m.fail(fmt.Errorf("resolving output path: %w", err))
+		}
+		unpackOpts := []file.UnpackOption{file.WithKeepPartial(viper.GetBool("keep_partial")), file.WithOutput(target)}
+		if viper.GetBool("preserve_ownership") {
+			skipped := m.ownershipSkipped
+			unpackOpts = append(unpackOpts, file.WithPreserveOwnership(func(error) { *skipped++ }))
+		}
+		unpackOpts = append(unpackOpts, m.unpackOpts...)
+		m.unpacker, err = file.NewUnpacker(viper.GetBool("prompt_overwrite_files"), msg.temp, unpackOpts...)
+		if err != nil {
+			return m.fail(err)
 		}
 
 		return m, tui.TaskCmd(message, tea.Batch(m.spinner.Tick, m.unpackCmd()))
@@ -225,7 +380,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, tui.QuitCmd()
 
 	case tui.ErrorMsg:
-		return m, tui.ErrorCmd(errors.New(msg.Error()))
+		return m.fail(msg)
 
 	case tea.KeyMsg:
 		var cmds []tea.Cmd
@@ -233,6 +388,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
 		}
+		if m.state == showSelecting {
+			fileSelectModel, fileSelectCmd := m.fileSelect.Update(msg)
+			m.fileSelect = fileSelectModel.(fileselect.Model)
+			return m, fileSelectCmd
+		}
 
 		fileTableModel, fileTableCmd := m.fileTable.Update(msg)
 		m.fileTable = fileTableModel.(filetable.Model)
@@ -268,6 +428,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		fileTableModel, fileTableCmd := m.fileTable.Update(msg)
 		m.fileTable = fileTableModel.(filetable.Model)
 
+		fileSelectModel, _ := m.fileSelect.Update(msg)
+		m.fileSelect = fileSelectModel.(fileselect.Model)
+
 		m.overwritePrompt.MaxWidth = msg.Width - 2*tui.MARGIN - 4
 		_, promptCmd := m.overwritePrompt.Update(msg)
 
@@ -290,6 +453,17 @@ func (m model) View() string {
 			tui.PadText + tui.InfoStyle(fmt.Sprintf("%s Establishing connection with sender", m.spinner.View())) + "\n\n" +
 			tui.PadText + m.help.View(m.keys) + "\n\n"
 
+	case showSelecting:
+		if m.fileSelect.Done() {
+			return tui.PadText + tui.LogSeparator(m.width) +
+				tui.PadText + tui.InfoStyle(fmt.Sprintf("%s Waiting for sender to pack the selected files", m.spinner.View())) + "\n\n" +
+				tui.PadText + m.help.View(m.keys) + "\n\n"
+		}
+		return tui.PadText + tui.LogSeparator(m.width) +
+			tui.PadText + tui.InfoStyle("Select the files to receive") + "\n\n" +
+			m.fileSelect.View() +
+			tui.PadText + m.help.View(fileselect.Keys) + "\n\n"
+
 	case showReceivingProgress:
 		var transferType string
 		if m.transferType == transfer.Direct {
@@ -322,13 +496,22 @@ func (m model) View() string {
 			tui.PadText + m.help.View(m.keys) + "\n\n"
 
 	case showFinished:
+		if m.text != nil {
+			return tui.PadText + tui.LogSeparator(m.width) +
+				tui.PadText + tui.InfoStyle("Received message:") + "\n\n" +
+				strings.TrimRight(*m.text, "\n") + "\n\n"
+		}
 		oneOrMoreFiles := "object"
 		if len(m.receivedFiles) == 0 || len(m.receivedFiles) > 1 {
 			oneOrMoreFiles += "s"
 		}
 		finishedText := fmt.Sprintf("Received %d %s (%s decompressed)", len(m.receivedFiles), oneOrMoreFiles, tui.ByteCountSI(m.decompressedPayloadSize))
+		var ownershipText string
+		if *m.ownershipSkipped > 0 {
+			ownershipText = tui.PadText + tui.WarningText(fmt.Sprintf("Ownership of %d objects could not be preserved", *m.ownershipSkipped)) + "\n\n"
+		}
 		return tui.PadText + tui.LogSeparator(m.width) +
-			tui.PadText + tui.InfoStyle(finishedText) + "\n\n" +
+			tui.PadText + tui.InfoStyle(finishedText) + "\n\n" + ownershipText +
 			tui.PadText + m.transferProgress.View() + "\n\n" +
 			m.fileTable.View()
 
@@ -339,9 +522,9 @@ func (m model) View() string {
 
 // ------------------------------------------------------ Commands -----------------------------------------------------
 
-func connectCmd(addr string) tea.Cmd {
+func connectCmd(addr string, opts ...conn.DialOption) tea.Cmd {
 	return func() tea.Msg {
-		rc, err := receiver.ConnectRendezvous(addr)
+		rc, err := receiver.ConnectRendezvous(addr, opts...)
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
@@ -349,9 +532,12 @@ func connectCmd(addr string) tea.Cmd {
 	}
 }
 
-func secureCmd(ctx context.Context, rc conn.Rendezvous, password string) tea.Cmd {
+func secureCmd(ctx context.Context, rc conn.Rendezvous, addr, password string, opts ...conn.DialOption) tea.Cmd {
 	return func() tea.Msg {
 		tc, err := receiver.SecureConnection(ctx, rc, password)
+		if receiver.Reconnectable(err) {
+			tc, err = receiver.Reconnect(ctx, addr, password, opts...)
+		}
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
@@ -359,13 +545,14 @@ func secureCmd(ctx context.Context, rc conn.Rendezvous, password string) tea.Cmd
 	}
 }
 
-func receiveCmd(ctx context.Context, tc conn.Transfer, msgs ...chan interface{}) tea.Cmd {
+// receiveCmd command that receives the payload into a temporary file, limited by the limiter.
+func receiveCmd(ctx context.Context, tc conn.Transfer, limiter *rate.Limiter, opts ...receiver.ReceiveOption) tea.Cmd {
 	return func() tea.Msg {
 		temp, err := os.CreateTemp(os.TempDir(), file.RECEIVE_TEMP_FILE_NAME_PREFIX)
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
-		if err := receiver.Receive(ctx, tc, temp, msgs...); err != nil {
+		if err := receiver.Receive(ctx, tc, portal.LimitWriter(ctx, temp, limiter), opts...); err != nil {
 			return tui.ErrorMsg(err)
 		}
 		if _, err := temp.Seek(0, 0); err != nil {
@@ -387,6 +574,12 @@ func listenReceiveCmd(msgs chan interface{}) tea.Cmd {
 			return tui.ProgressMsg(v)
 		case int64:
 			return payloadSizeMsg{size: v}
+		case checksum.Result:
+			return tui.ChecksumMsg(v)
+		case transfer.Compression:
+			return tui.CompressionMsg(v)
+		case selectionMsg:
+			return v
 		default:
 			return nil
 		}
@@ -396,6 +589,10 @@ func listenReceiveCmd(msgs chan interface{}) tea.Cmd {
 func (m *model) unpackCmd() tea.Cmd {
 	return func() tea.Msg {
 		commiter, err := m.unpacker.Unpack()
+		// files colliding with existing files are skipped if configured.
+		for errors.Is(err, file.ErrUnpackFileSkipped) {
+			commiter, err = m.unpacker.Unpack()
+		}
 		switch {
 		case errors.Is(err, io.EOF):
 			return unpackDoneMsg{}
@@ -436,6 +633,42 @@ func (m *model) commitCmd() tea.Cmd {
 
 // ------------------------------------------------------ Helpers ------------------------------------------------------
 
+// selectFunc returns the selection of the files of the sender browsed in the tui, nil to receive every file.
+func (m model) selectFunc() receiver.SelectFunc {
+	if !m.selectFiles {
+		return nil
+	}
+	msgs := m.msgs
+	return func(manifest []transfer.ManifestFile) ([]string, error) {
+		selected := make(chan []string, 1)
+		msgs <- selectionMsg{manifest: manifest, selected: selected}
+		return <-selected, nil
+	}
+}
+
+// fail displays the error and quits, recording the error as the cause of the exit.
+func (m model) fail(err error) (tea.Model, tea.Cmd) {
+	m.err = err
+	return m, tui.ErrorCmd(err)
+}
+
+// Err returns the error the program exited with, nil if the transfer completed.
+func Err(final tea.Model) error {
+	if m, ok := final.(model); ok {
+		return m.err
+	}
+	return nil
+}
+
+// Received returns the names of the files committed by the final model, their size and the type of the transfer,
+// direct or relayed.
+func Received(final tea.Model) ([]string, int64, transfer.Type) {
+	if m, ok := final.(model); ok {
+		return m.receivedFiles, m.decompressedPayloadSize, m.transferType
+	}
+	return nil, 0, transfer.Unknown
+}
+
 func (m *model) newOverwritePrompt(fileName string) tea.Cmd {
 	prompt := confirmation.New(fmt.Sprintf("Overwrite file '%s'?", fileName), confirmation.Yes)
 	m.overwritePrompt = *confirmation.NewModel(prompt)
@@ -2,6 +2,7 @@ package sender
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,8 +13,10 @@ import (
 	"github.com/SpatiumPortae/portal/cmd/portal/tui"
 	"github.com/SpatiumPortae/portal/cmd/portal/tui/filetable"
 	"github.com/SpatiumPortae/portal/cmd/portal/tui/transferprogress"
+	"github.com/SpatiumPortae/portal/internal/checksum"
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/portal"
 	"github.com/SpatiumPortae/portal/internal/semver"
 	"github.com/SpatiumPortae/portal/internal/sender"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
@@ -24,9 +27,11 @@ import (
 	"github.com/charmbracelet/bubbles/timer"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/pkg/errors"
+	"github.com/erikgeiser/promptkit"
+	"github.com/erikgeiser/promptkit/confirmation"
 	"github.com/spf13/viper"
 	"golang.org/x/exp/slices"
+	"golang.org/x/time/rate"
 )
 
 // ------------------------------------------------------ tui State -----------------------------------------------------
@@ -36,6 +41,7 @@ type tuiState int
 // flows from the top down.
 const (
 	showPassword tuiState = iota
+	showReceiverPrompt
 	showSendingProgress
 	showFinished
 )
@@ -45,6 +51,8 @@ const (
 type connectMsg struct {
 	password string
 	conn     conn.Rendezvous
+	// joined are the mailboxes joined for the further receivers, see WithReceivers.
+	joined []conn.Rendezvous
 }
 
 type fileReadMsg struct {
@@ -53,12 +61,33 @@ type fileReadMsg struct {
 }
 
 type compressedMsg struct {
-	payload io.Reader
-	size    int64
+	payload     io.Reader
+	size        int64
+	compression file.CompressionResult
+	formats     []string
+	selection   *sender.Selection
+	repack      func(codec string) (io.Reader, int64, error)
+}
+
+// repackedMsg announces the codec negotiated with the receiver, and the size of the payload repacked with it.
+type repackedMsg struct {
+	codec string
+	size  int64
+}
+
+// payloadSizeMsg announces the size of the payload repacked with the files selected by the receiver.
+type payloadSizeMsg struct {
+	size int64
 }
 
 type transferDoneMsg struct{}
 
+// receiverMsg is a message of the transfer to one of several receivers, see WithReceivers.
+type receiverMsg struct {
+	receiver int
+	msg      tea.Msg
+}
+
 // ------------------------------------------------------- Model -------------------------------------------------------
 
 type Option func(m *model)
@@ -69,22 +98,144 @@ func WithVersion(version semver.Version) Option {
 	}
 }
 
+// WithStrictVersionCheck refuses to connect to a server whose version cannot be verified.
+func WithStrictVersionCheck() Option {
+	return func(m *model) {
+		m.strict = true
+	}
+}
+
+func WithDialOptions(opts ...conn.DialOption) Option {
+	return func(m *model) {
+		m.dialOpts = append(m.dialOpts, opts...)
+	}
+}
+
+// WithCopyToClipboard copies the receive command to the clipboard once a password is acquired.
+func WithCopyToClipboard() Option {
+	return func(m *model) {
+		m.copyOnConnect = true
+	}
+}
+
+// WithConfirmReceiver asks for approval of the receiver, identified by the connection fingerprint, before sending.
+func WithConfirmReceiver() Option {
+	return func(m *model) {
+		m.confirmReceiver = true
+	}
+}
+
+// WithStreams splits the payload over up to the provided number of parallel streams, if the receiver accepts them.
+func WithStreams(streams int) Option {
+	return func(m *model) {
+		m.streams = streams
+	}
+}
+
+// WithExpireAfter expires the password after the provided duration, unless a receiver connected.
+func WithExpireAfter(expireAfter time.Duration) Option {
+	return func(m *model) {
+		m.expireAfter = expireAfter
+	}
+}
+
+// WithChecksum proves the integrity of the payload with a checksum of the provided algorithm, one of transfer.Checksums.
+func WithChecksum(algorithm string) Option {
+	return func(m *model) {
+		m.checksum = algorithm
+	}
+}
+
+// WithChunkSize sends the payload in chunks of the provided size, see sender.TransferChunked.
+func WithChunkSize(chunkSize int64) Option {
+	return func(m *model) {
+		m.chunkSize = chunkSize
+	}
+}
+
+// WithTransport accepts direct connections of the receiver over the provided transport, see sender.WithTransport.
+func WithTransport(transport string) Option {
+	return func(m *model) {
+		m.transport = transport
+	}
+}
+
+// WithReceivers sends the payload to up to the provided number of receivers with the same password, each receiving
+// the payload in full over a transfer of its own, with a progress bar of its own.
+func WithReceivers(receivers int) Option {
+	return func(m *model) {
+		m.receivers = receivers
+	}
+}
+
+// WithProgress reports the bytes sent of the payload and its size to onProgress as the transfer progresses, e.g. to
+// post the progress to a webhook.
+func WithProgress(onProgress func(transferred, total int64)) Option {
+	return func(m *model) {
+		m.onProgress = onProgress
+	}
+}
+
+// WithRateLimit sends the payload at most at the provided rate in bytes per second, shared by the receivers of the
+// transfer, see portal.Config.RateLimit.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(m *model) {
+		m.limiter = portal.NewLimiter(bytesPerSecond)
+	}
+}
+
+// WithNegotiatedCodec repacks the payload with the codec negotiated with the receiver, see sender.WithCompression.
+func WithNegotiatedCodec() Option {
+	return func(m *model) {
+		m.negotiateCodec = true
+	}
+}
+
+func WithPackOptions(opts ...file.PackOption) Option {
+	return func(m *model) {
+		m.packOpts = append(m.packOpts, opts...)
+	}
+}
+
 type model struct {
-	state        tuiState      // defaults to 0 (showPassword)
-	transferType transfer.Type // defaults to 0 (Unknown)
-	readyToSend  bool
-	ctx          context.Context
+	state         tuiState      // defaults to 0 (showPassword)
+	transferType  transfer.Type // defaults to 0 (Unknown)
+	readyToSend   bool
+	copyOnConnect bool
+	ctx           context.Context
+
+	confirmReceiver bool
+	receiverPrompt  confirmation.Model
+	secured         conn.Transfer
 
 	msgs chan interface{}
 
 	rendezvousAddr string
+	dialOpts       []conn.DialOption
+	strict         bool
+	streams        int
+	expireAfter    time.Duration
+	checksum       string
+	chunkSize      int64
+	transport      string
+	negotiateCodec bool
+	receivers      int
+	onProgress     func(transferred, total int64)
+	limiter        *rate.Limiter
+	// transfers are the transfers to several receivers, indexed by receiver, empty for a single receiver.
+	transfers []receiverTransfer
 
 	password         string
 	fileNames        []string
 	uncompressedSize int64
 	payload          io.Reader
 	payloadSize      int64
+	compression      file.CompressionResult
+	formats          []string
+	selection        *sender.Selection
+	repack           func(codec string) (io.Reader, int64, error)
 	version          *semver.Version
+	packOpts         []file.PackOption
 
 	width            int
 	spinner          spinner.Model
@@ -93,6 +244,17 @@ type model struct {
 	help             help.Model
 	keys             tui.KeyMap
 	copyMessageTimer timer.Model
+
+	err error // error the program exited with
+}
+
+// receiverTransfer is the transfer to one of several receivers.
+type receiverTransfer struct {
+	msgs     chan interface{}
+	progress transferprogress.Model
+	sending  bool
+	ended    bool
+	err      error
 }
 
 // New creates a new sender program.
@@ -106,23 +268,47 @@ func New(filenames []string, addr string, opts ...Option) *tea.Program {
 		help:             help.New(),
 		keys:             tui.Keys,
 		copyMessageTimer: timer.NewWithInterval(tui.TEMP_UI_MESSAGE_DURATION, 100*time.Millisecond),
+		receiverPrompt:   *confirmation.NewModel(confirmation.New("", confirmation.Undecided)),
 		ctx:              context.Background(),
+		checksum:         transfer.CHECKSUM_SHA256,
+		transport:        transfer.TRANSPORT_AUTO,
 	}
 	m.keys.FileListUp.SetEnabled(true)
 	m.keys.FileListDown.SetEnabled(true)
 	for _, opt := range opts {
 		opt(&m)
 	}
+	if m.receivers > 1 {
+		m.transfers = make([]receiverTransfer, m.receivers)
+		for i := range m.transfers {
+			m.transfers[i] = receiverTransfer{msgs: make(chan interface{}, 10), progress: transferprogress.New()}
+		}
+	}
 	m.resetSpinner()
 	return tea.NewProgram(m)
 }
 
 func (m model) Init() tea.Cmd {
+	if m.strict {
+		if m.version == nil {
+			//lint:ignore ST1005 error string displayed in tui
+			return func() tea.Msg {
+				return tui.ErrorMsg(errors.New("Portal version unknown, unable to verify server version in strict mode"))
+			}
+		}
+		// nothing is sent to the server before its version is verified.
+		return tea.Batch(m.spinner.Tick, tui.VersionCmd(m.ctx, m.rendezvousAddr, m.dialOpts...))
+	}
 	var versionCmd tea.Cmd
 	if m.version != nil {
-		versionCmd = tui.VersionCmd(m.ctx, m.rendezvousAddr)
+		versionCmd = tui.VersionCmd(m.ctx, m.rendezvousAddr, m.dialOpts...)
 	}
-	return tea.Sequence(versionCmd, tea.Batch(m.spinner.Tick, readFilesCmd(m.fileNames), connectCmd(m.ctx, m.rendezvousAddr)))
+	return tea.Sequence(versionCmd, tea.Batch(m.spinner.Tick, m.startCmd()))
+}
+
+// startCmd starts the send sequence.
+func (m model) startCmd() tea.Cmd {
+	return tea.Batch(readFilesCmd(m.fileNames), connectCmd(m.ctx, m.rendezvousAddr, m.expireAfter, m.receivers, m.dialOpts...))
 }
 
 // ------------------------------------------------------- Update ------------------------------------------------------
@@ -131,12 +317,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
 	case tui.VersionMsg:
+		if msg.Err != nil {
+			if m.strict {
+				//lint:ignore ST1005 error string displayed in tui
+				return m.fail(fmt.Errorf("Unable to verify server version in strict mode: %w", msg.Err))
+			}
+			return m, tui.TaskCmd(tui.WarningText(fmt.Sprintf("Unable to verify server version: %s", msg.Err)), nil)
+		}
 		var message string
 		switch m.version.Compare(msg.ServerVersion) {
 		case semver.CompareNewMajor,
 			semver.CompareOldMajor:
 			//lint:ignore ST1005 error string displayed in tui
-			return m, tui.ErrorCmd(fmt.Errorf("Portal version (%s) incompatible with server version (%s)", m.version, msg.ServerVersion))
+			return m.fail(fmt.Errorf("Portal version (%s) %w with server version (%s)", m.version, semver.ErrIncompatible, msg.ServerVersion))
 		case semver.CompareNewMinor,
 			semver.CompareNewPatch:
 			message = tui.WarningText(fmt.Sprintf("Portal version (%s) newer than server version (%s)", m.version, msg.ServerVersion))
@@ -146,7 +339,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case semver.CompareEqual:
 			message = tui.SuccessText(fmt.Sprintf("Portal version (%s) compatible with server version (%s)", m.version, msg.ServerVersion))
 		}
-		return m, tui.TaskCmd(message, nil)
+		var next tea.Cmd
+		if m.strict {
+			next = m.startCmd()
+		}
+		return m, tui.TaskCmd(message, next)
 
 	case fileReadMsg:
 		m.uncompressedSize = msg.size
@@ -154,25 +351,55 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if len(m.fileNames) == 1 {
 			message = fmt.Sprintf("Read %d object (%s)", len(m.fileNames), tui.ByteCountSI(msg.size))
 		}
-		return m, tui.TaskCmd(message, compressFilesCmd(msg.files))
+		return m, tui.TaskCmd(message, compressFilesCmd(msg.files, m.msgs, m.negotiateCodec, m.packOpts...))
 
 	case compressedMsg:
 		m.payload = msg.payload
 		m.payloadSize = msg.size
+		m.compression = msg.compression
+		m.formats = msg.formats
+		m.selection = msg.selection
+		m.repack = msg.repack
 		m.transferProgress.PayloadSize = msg.size
+		for i := range m.transfers {
+			m.transfers[i].progress.PayloadSize = msg.size
+		}
 		m.readyToSend = true
 		m.resetSpinner()
 		message := fmt.Sprintf("Compressed objects (%s)", tui.ByteCountSI(msg.size))
 		if len(m.fileNames) == 1 {
 			message = fmt.Sprintf("Compressed object (%s)", tui.ByteCountSI(msg.size))
 		}
+		if msg.compression.Skipped {
+			message = fmt.Sprintf("Archived %d object(s) (%s), skipped compression of incompressible data", len(m.fileNames), tui.ByteCountSI(msg.size))
+		}
+		if msg.compression.Stored > 0 {
+			message += fmt.Sprintf(", stored %d already compressed file(s)", msg.compression.Stored)
+		}
 		return m, tui.TaskCmd(message, m.spinner.Tick)
 
 	case connectMsg:
 		m.keys.CopyPassword.SetEnabled(true)
 		m.password = msg.password
 		connectMessage := fmt.Sprintf("Connected to Portal server (%s)", m.rendezvousAddr)
-		return m, tui.TaskCmd(connectMessage, secureCmd(m.ctx, msg.conn, msg.password))
+		cmd := secureCmd(m.ctx, msg.conn, msg.password)
+		if len(m.transfers) > 0 {
+			// every receiver is secured over the mailbox of its own.
+			conns := append([]conn.Rendezvous{msg.conn}, msg.joined...)
+			cmds := make([]tea.Cmd, 0, len(conns))
+			for i, rc := range conns {
+				cmds = append(cmds, receiverCmd(i, secureCmd(m.ctx, rc, msg.password)))
+			}
+			cmd = tea.Batch(cmds...)
+		}
+		if m.copyOnConnect {
+			copyMessage := "Copied receive command to clipboard"
+			if err := clipboard.WriteAll(m.copyReceiverCommand()); err != nil {
+				copyMessage = tui.WarningText("Clipboard unavailable, copy the receive command below manually")
+			}
+			cmd = tui.TaskCmd(copyMessage, cmd)
+		}
+		return m, tui.TaskCmd(connectMessage, cmd)
 
 	case timer.TickMsg:
 		var cmd tea.Cmd
@@ -184,7 +411,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case timer.TimeoutMsg:
 		var cmd tea.Cmd
-		m.state = showPassword
+		if m.state != showReceiverPrompt {
+			m.state = showPassword
+		}
 		m.copyMessageTimer, cmd = m.copyMessageTimer.Update(msg)
 		m.keys.CopyPassword.SetHelp(m.keys.CopyPassword.Help().Key, tui.CopyKeyHelpText)
 		return m, cmd
@@ -207,10 +436,29 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return msg
 			}
 		}
-		cmd := tea.Batch(
-			listenTransferCmd(m.msgs),
-			transferCmd(m.ctx, msg.Conn, m.payload, m.payloadSize, m.msgs))
-		return m, cmd
+		if m.confirmReceiver {
+			m.state = showReceiverPrompt
+			m.secured = msg.Conn
+			m.setPromptKeysEnabled(true)
+			message := fmt.Sprintf("Receiver connected (fingerprint %s), awaiting approval", msg.Conn.Fingerprint())
+			return m, tui.TaskCmd(message, m.newReceiverPrompt(msg.Conn.Fingerprint()))
+		}
+		return m, m.startTransferCmd(msg.Conn)
+
+	case payloadSizeMsg:
+		m.payloadSize = msg.size
+		m.transferProgress.PayloadSize = msg.size
+		return m, tui.TaskCmd(fmt.Sprintf("Receiver selected files (%s)", tui.ByteCountSI(msg.size)), listenTransferCmd(m.msgs))
+
+	case repackedMsg:
+		m.payloadSize = msg.size
+		m.compression.Codec = msg.codec
+		m.transferProgress.PayloadSize = msg.size
+		return m, tui.TaskCmd(fmt.Sprintf("Compressed objects with %s for the receiver (%s)", msg.codec, tui.ByteCountSI(msg.size)), listenTransferCmd(m.msgs))
+
+	case tui.CompressionMsg:
+		m.transferProgress.RawSize = msg.RawSize
+		return m, listenTransferCmd(m.msgs)
 
 	case tui.TransferStateMessage:
 		var message string
@@ -221,6 +469,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tui.TaskCmd(message, listenTransferCmd(m.msgs))
 
+	case tui.ChecksumMsg:
+		return m, tui.TaskCmd(fmt.Sprintf("Sent checksum %s", checksum.Result(msg)), listenTransferCmd(m.msgs))
+
+	case tui.ChunkStatsMsg:
+		transferProgressModel, transferProgressCmd := m.transferProgress.Update(msg)
+		m.transferProgress = transferProgressModel.(transferprogress.Model)
+		return m, tea.Batch(transferProgressCmd, listenTransferCmd(m.msgs))
+
 	case tui.ProgressMsg:
 		cmds := []tea.Cmd{listenTransferCmd(m.msgs)}
 		if m.state != showSendingProgress {
@@ -229,6 +485,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.transferProgress.StartTransfer()
 			cmds = append(cmds, m.spinner.Tick)
 		}
+		if m.onProgress != nil {
+			m.onProgress(int64(msg), m.payloadSize)
+		}
 		transferProgressModel, transferProgressCmd := m.transferProgress.Update(msg)
 		m.transferProgress = transferProgressModel.(transferprogress.Model)
 		cmds = append(cmds, transferProgressCmd)
@@ -240,12 +499,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			time.Since(m.transferProgress.TransferStartTime).Round(time.Millisecond).String(),
 			tui.ByteCountSI(m.transferProgress.TransferSpeedEstimateBps),
 		)
+		if len(m.transfers) > 0 {
+			message = fmt.Sprintf("Transfers to %d receivers completed in %s", len(m.transfers),
+				time.Since(m.transferProgress.TransferStartTime).Round(time.Millisecond).String(),
+			)
+		}
 
 		m.fileTable = m.fileTable.Finalize().(filetable.Model)
 		return m, tui.TaskCmd(message, tui.QuitCmd())
 
+	case receiverMsg:
+		return m.updateReceiver(msg.receiver, msg.msg)
+
 	case tui.ErrorMsg:
-		return m, tui.ErrorCmd(errors.New(msg.Error()))
+		return m.fail(msg)
 
 	case tea.KeyMsg:
 		switch {
@@ -254,7 +521,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.CopyPassword):
 			err := clipboard.WriteAll(m.copyReceiverCommand())
 			if err != nil {
-				return m, tui.ErrorCmd(errors.New("Failed to copy password to clipboard"))
+				return m.fail(errors.New("Failed to copy password to clipboard"))
 			} else {
 				m.copyMessageTimer.Timeout = tui.TEMP_UI_MESSAGE_DURATION
 				cmd := m.copyMessageTimer.Init()
@@ -264,21 +531,151 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		fileTableModel, fileTableCmd := m.fileTable.Update(msg)
 		m.fileTable = fileTableModel.(filetable.Model)
+		cmds := []tea.Cmd{fileTableCmd}
 
-		return m, fileTableCmd
+		_, promptCmd := m.receiverPrompt.Update(msg)
+		if m.state == showReceiverPrompt {
+			switch msg.String() {
+			case "left", "right":
+				cmds = append(cmds, promptCmd)
+			}
+			switch {
+			case key.Matches(msg, m.keys.OverwritePromptYes, m.keys.OverwritePromptNo, m.keys.OverwritePromptConfirm):
+				m.state = showPassword
+				m.setPromptKeysEnabled(false)
+				if approved, _ := m.receiverPrompt.Value(); approved {
+					cmds = append(cmds, tui.TaskCmd("Approved receiver", m.startTransferCmd(m.secured)))
+				} else {
+					cmds = append(cmds, rejectReceiverCmd(m.secured))
+				}
+			}
+		}
+		return m, tea.Batch(cmds...)
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		transferProgressModel, transferProgressCmd := m.transferProgress.Update(msg)
 		m.transferProgress = transferProgressModel.(transferprogress.Model)
+		cmds := []tea.Cmd{transferProgressCmd}
+		for i := range m.transfers {
+			progressModel, progressCmd := m.transfers[i].progress.Update(msg)
+			m.transfers[i].progress = progressModel.(transferprogress.Model)
+			cmds = append(cmds, progressCmd)
+		}
 		fileTableModel, fileTableCmd := m.fileTable.Update(msg)
 		m.fileTable = fileTableModel.(filetable.Model)
-		return m, tea.Batch(transferProgressCmd, fileTableCmd)
+		m.receiverPrompt.MaxWidth = msg.Width - 2*tui.MARGIN - 4
+		_, promptCmd := m.receiverPrompt.Update(msg)
+		return m, tea.Batch(append(cmds, fileTableCmd, promptCmd)...)
 
 	default:
-		var cmd tea.Cmd
-		m.spinner, cmd = m.spinner.Update(msg)
-		return m, cmd
+		var spinnerCmd tea.Cmd
+		m.spinner, spinnerCmd = m.spinner.Update(msg)
+		_, promptCmd := m.receiverPrompt.Update(msg)
+		return m, tea.Batch(spinnerCmd, promptCmd)
+	}
+}
+
+// updateReceiver updates the transfer to the receiver of the index, of several receivers, with a message of its
+// transfer. The program ends once the transfers to all receivers ended, failing with the errors of those that failed.
+func (m model) updateReceiver(i int, msg tea.Msg) (tea.Model, tea.Cmd) {
+	t := &m.transfers[i]
+	listen := listenReceiverCmd(i, t.msgs)
+	switch msg := msg.(type) {
+
+	case tui.SecureMsg:
+		if !m.readyToSend {
+			return m, receiverCmd(i, func() tea.Msg {
+				return msg
+			})
+		}
+		return m, m.startReceiverTransferCmd(i, msg.Conn)
+
+	case tui.TransferTypeMsg:
+		m.transferType = msg.Type
+		var message string
+		switch msg.Type {
+		case transfer.Direct:
+			message = fmt.Sprintf("Using direct connection to receiver %d", i+1)
+		case transfer.Relay:
+			message = fmt.Sprintf("Using relayed connection to receiver %d", i+1)
+		}
+		return m, tui.TaskCmd(message, listen)
+
+	case tui.TransferStateMessage:
+		var message string
+		if msg.State == transfer.ReceiverRequestPayload {
+			message = fmt.Sprintf("Established encrypted connection to receiver %d", i+1)
+		}
+		return m, tui.TaskCmd(message, listen)
+
+	case tui.ChecksumMsg:
+		return m, tui.TaskCmd(fmt.Sprintf("Sent checksum %s to receiver %d", checksum.Result(msg), i+1), listen)
+
+	case payloadSizeMsg:
+		t.progress.PayloadSize = msg.size
+		return m, tui.TaskCmd(fmt.Sprintf("Receiver %d selected files (%s)", i+1, tui.ByteCountSI(msg.size)), listen)
+
+	case tui.CompressionMsg:
+		t.progress.RawSize = msg.RawSize
+		return m, listen
+
+	case tui.ChunkStatsMsg:
+		progressModel, progressCmd := t.progress.Update(msg)
+		t.progress = progressModel.(transferprogress.Model)
+		return m, tea.Batch(progressCmd, listen)
+
+	case tui.ProgressMsg:
+		cmds := []tea.Cmd{listen}
+		if !t.sending {
+			t.sending = true
+			t.progress.StartTransfer()
+		}
+		if m.state != showSendingProgress {
+			m.state = showSendingProgress
+			m.resetSpinner()
+			m.transferProgress.StartTransfer()
+			cmds = append(cmds, m.spinner.Tick)
+		}
+		progressModel, progressCmd := t.progress.Update(msg)
+		t.progress = progressModel.(transferprogress.Model)
+		return m, tea.Batch(append(cmds, progressCmd)...)
+
+	case transferDoneMsg:
+		t.ended = true
+		message := fmt.Sprintf("Transfer to receiver %d completed in %s with average transfer speed %s/s", i+1,
+			time.Since(t.progress.TransferStartTime).Round(time.Millisecond).String(),
+			tui.ByteCountSI(t.progress.TransferSpeedEstimateBps),
+		)
+		return m, tui.TaskCmd(message, m.receiversEndedCmd())
+
+	case tui.ErrorMsg:
+		t.ended = true
+		t.err = fmt.Errorf("receiver %d: %w", i+1, error(msg))
+		return m, tui.TaskCmd(tui.WarningText(fmt.Sprintf("Transfer to receiver %d failed: %s", i+1, error(msg))), m.receiversEndedCmd())
+
+	default:
+		return m, listen
+	}
+}
+
+// receiversEndedCmd ends the program once the transfers to all receivers ended, failing with the errors of the
+// transfers that failed, each transfer fails on its own. Returns nil while a transfer is ongoing.
+func (m model) receiversEndedCmd() tea.Cmd {
+	errs := make([]error, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		if !t.ended {
+			return nil
+		}
+		errs = append(errs, t.err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return func() tea.Msg {
+			return tui.ErrorMsg(err)
+		}
+	}
+	return func() tea.Msg {
+		return transferDoneMsg{}
 	}
 }
 
@@ -325,18 +722,28 @@ func (m model) View() string {
 			m.fileTable.View() +
 			tui.PadText + m.help.View(m.keys) + "\n\n"
 
+	case showReceiverPrompt:
+		return tui.PadText + tui.LogSeparator(m.width) +
+			tui.PadText + tui.InfoStyle(statusText) + "\n\n" +
+			tui.PadText + tui.InfoStyle("Confirm the fingerprint with the receiver before approving.") + "\n\n" +
+			tui.PadText + m.receiverPrompt.View() + "\n\n" +
+			tui.PadText + m.help.View(m.keys) + "\n\n"
+
 	case showSendingProgress:
 		return tui.PadText + tui.LogSeparator(m.width) +
 			tui.PadText + tui.InfoStyle(statusText) + "\n\n" +
-			tui.PadText + m.transferProgress.View() + "\n\n" +
+			tui.PadText + m.progressView() + "\n\n" +
 			m.fileTable.View() +
 			tui.PadText + m.help.View(m.keys) + "\n\n"
 
 	case showFinished:
 		finishedText := fmt.Sprintf("Sent %d object(s) (%s compressed)", len(m.fileNames), tui.ByteCountSI(m.payloadSize))
+		if m.compression.Skipped {
+			finishedText = fmt.Sprintf("Sent %d object(s) (%s, compression skipped)", len(m.fileNames), tui.ByteCountSI(m.payloadSize))
+		}
 		return tui.PadText + tui.LogSeparator(m.width) +
 			tui.PadText + tui.InfoStyle(finishedText) + "\n\n" +
-			tui.PadText + m.transferProgress.View() + "\n\n" +
+			tui.PadText + m.progressView() + "\n\n" +
 			m.fileTable.View()
 
 	default:
@@ -346,14 +753,26 @@ func (m model) View() string {
 
 // ------------------------------------------------------ Commands -----------------------------------------------------
 
-// connectCmd command that connects to the rendezvous server.
-func connectCmd(ctx context.Context, addr string) tea.Cmd {
+// connectCmd command that connects to the rendezvous server, joining a further mailbox with the password for each
+// of several receivers.
+func connectCmd(ctx context.Context, addr string, expireAfter time.Duration, receivers int, opts ...conn.DialOption) tea.Cmd {
 	return func() tea.Msg {
-		rc, password, err := sender.ConnectRendezvous(ctx, addr)
+		rc, password, err := sender.ConnectRendezvousExpiring(ctx, addr, expireAfter, opts...)
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
-		return connectMsg{password: password, conn: rc}
+		var joined []conn.Rendezvous
+		for i := 1; i < receivers; i++ {
+			jc, err := sender.ConnectRendezvousJoining(ctx, addr, password, rc.Token, expireAfter, opts...)
+			if err != nil {
+				for _, c := range append(joined, rc) {
+					c.Conn.Close(conn.CLOSE_FAILED, "") //nolint:errcheck
+				}
+				return tui.ErrorMsg(fmt.Errorf("joining mailbox for receiver %d: %w", i+1, err))
+			}
+			joined = append(joined, jc)
+		}
+		return connectMsg{password: password, conn: rc, joined: joined}
 	}
 }
 
@@ -370,9 +789,9 @@ func secureCmd(ctx context.Context, rc conn.Rendezvous, password string) tea.Cmd
 
 // transferCmd command that does the transfer sequence.
 // The msgs channel is used to provide intermediate messages to the tui.
-func transferCmd(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) tea.Cmd {
+func transferCmd(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, opts ...sender.TransferOption) tea.Cmd {
 	return func() tea.Msg {
-		err := sender.Transfer(ctx, tc, payload, payloadSize, msgs...)
+		err := sender.Transfer(ctx, tc, payload, payloadSize, opts...)
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
@@ -380,6 +799,14 @@ func transferCmd(ctx context.Context, tc conn.Transfer, payload io.Reader, paylo
 	}
 }
 
+// rejectReceiverCmd command that disconnects a rejected receiver.
+func rejectReceiverCmd(tc conn.Transfer) tea.Cmd {
+	return func() tea.Msg {
+		conn.CloseWithError(tc.Conn, sender.ErrReceiverRejected) //nolint:errcheck
+		return tui.ErrorMsg(sender.ErrReceiverRejected)
+	}
+}
+
 // readFilesCmd command that reads the files from the provided paths.
 func readFilesCmd(paths []string) tea.Cmd {
 	return func() tea.Msg {
@@ -402,22 +829,117 @@ func readFilesCmd(paths []string) tea.Cmd {
 }
 
 // compressFilesCmd is a command that compresses and archives the
-// provided files.
-func compressFilesCmd(files []*os.File) tea.Cmd {
+// provided files, repacked with the codec negotiated with the receiver if negotiate is set.
+func compressFilesCmd(files []*os.File, msgs chan interface{}, negotiate bool, opts ...file.PackOption) tea.Cmd {
 	return func() tea.Msg {
 		defer func() {
 			for _, f := range files {
 				f.Close()
 			}
 		}()
-		tar, size, err := file.PackFiles(files)
+		var (
+			compression file.CompressionResult
+			manifest    []transfer.ManifestFile
+			formats     []string
+		)
+		tar, size, err := file.PackFiles(files, append(opts, file.WithCompressionResult(&compression), file.WithManifest(&manifest), file.WithFormats(&formats))...)
 		if err != nil {
 			return tui.ErrorMsg(err)
 		}
-		return compressedMsg{payload: tar, size: size}
+		var repack func(codec string) (io.Reader, int64, error)
+		if negotiate {
+			// files selected by the receiver are repacked with the negotiated codec.
+			codec := compression.Codec
+			opts = append(opts, file.WithCodecOf(&codec))
+			repack = repackedFiles(files, &codec, msgs, opts...)
+		}
+		return compressedMsg{payload: tar, size: size, compression: compression, formats: formats, selection: selectableFiles(files, manifest, opts...), repack: repack}
+	}
+}
+
+// repackedFiles returns a function reopening the packed files to repack them with the codec negotiated with the
+// receiver, set as the codec pointed to by negotiated. The codec and the size of the repacked payload are announced
+// on msgs.
+func repackedFiles(files []*os.File, negotiated *string, msgs chan interface{}, opts ...file.PackOption) func(codec string) (io.Reader, int64, error) {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Name())
+	}
+	return func(codec string) (io.Reader, int64, error) {
+		files, err := file.ReadFiles(paths)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}()
+		*negotiated = codec
+		payload, size, err := file.PackFiles(files, opts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		msgs <- repackedMsg{codec: codec, size: size}
+		return payload, size, nil
+	}
+}
+
+// selectableFiles returns the selection of the files of the manifest, reopening the packed files to repack the
+// files selected by the receiver.
+func selectableFiles(files []*os.File, manifest []transfer.ManifestFile, opts ...file.PackOption) *sender.Selection {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Name())
+	}
+	return &sender.Selection{
+		Manifest: manifest,
+		Repack: func(selected []string) (io.Reader, int64, error) {
+			files, err := file.ReadFiles(paths)
+			if err != nil {
+				return nil, 0, err
+			}
+			defer func() {
+				for _, f := range files {
+					f.Close()
+				}
+			}()
+			return file.PackFiles(files, append(opts, file.WithSelected(selected...))...)
+		},
+	}
+}
+
+// announceSelection returns the selection announcing the size of the payload repacked with the files selected by
+// the receiver on msgs, such that each of several receivers reports the selection on its own.
+func announceSelection(selection *sender.Selection, msgs chan interface{}) *sender.Selection {
+	if selection == nil {
+		return nil
+	}
+	return &sender.Selection{
+		Manifest: selection.Manifest,
+		Repack: func(selected []string) (io.Reader, int64, error) {
+			payload, size, err := selection.Repack(selected)
+			if err != nil {
+				return nil, 0, err
+			}
+			msgs <- size
+			return payload, size, nil
+		},
 	}
 }
 
+// receiverCmd is a command that runs cmd as part of the transfer to the receiver of the index, of several receivers.
+func receiverCmd(receiver int, cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		return receiverMsg{receiver: receiver, msg: cmd()}
+	}
+}
+
+// listenReceiverCmd is listenTransferCmd for the transfer to the receiver of the index, of several receivers.
+func listenReceiverCmd(receiver int, msgs chan interface{}) tea.Cmd {
+	return receiverCmd(receiver, listenTransferCmd(msgs))
+}
+
 // listenTransferCmd is a command that listens to the provided
 // channel and formats messages.
 func listenTransferCmd(msgs chan interface{}) tea.Cmd {
@@ -430,6 +952,16 @@ func listenTransferCmd(msgs chan interface{}) tea.Cmd {
 			return tui.TransferStateMessage{State: v}
 		case int:
 			return tui.ProgressMsg(v)
+		case int64:
+			return payloadSizeMsg{size: v}
+		case checksum.Result:
+			return tui.ChecksumMsg(v)
+		case transfer.ChunkStats:
+			return tui.ChunkStatsMsg(v)
+		case transfer.Compression:
+			return tui.CompressionMsg(v)
+		case repackedMsg:
+			return v
 		default:
 			return nil
 		}
@@ -438,6 +970,28 @@ func listenTransferCmd(msgs chan interface{}) tea.Cmd {
 
 // -------------------------------------------------- Helper Functions -------------------------------------------------
 
+// fail displays the error and quits, recording the error as the cause of the exit.
+func (m model) fail(err error) (tea.Model, tea.Cmd) {
+	m.err = err
+	return m, tui.ErrorCmd(err)
+}
+
+// Err returns the error the program exited with, nil if the transfer completed.
+func Err(final tea.Model) error {
+	if m, ok := final.(model); ok {
+		return m.err
+	}
+	return nil
+}
+
+// Transfer returns the type of the transfer of the final model, direct or relayed, Unknown if none was negotiated.
+func Transfer(final tea.Model) transfer.Type {
+	if m, ok := final.(model); ok {
+		return m.transferType
+	}
+	return transfer.Unknown
+}
+
 func (m *model) resetSpinner() {
 	m.spinner = spinner.New()
 	m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(tui.ELEMENT_COLOR))
@@ -451,18 +1005,114 @@ func (m *model) resetSpinner() {
 	}
 }
 
+// startTransferCmd starts the transfer over the secured connection.
+func (m *model) startTransferCmd(tc conn.Transfer) tea.Cmd {
+	return tea.Batch(
+		listenTransferCmd(m.msgs),
+		m.transferCmd(tc, m.payload, m.msgs))
+}
+
+// startReceiverTransferCmd starts the transfer to the receiver of the index, of several receivers, over the secured
+// connection. Every receiver reads the payload from the start.
+func (m *model) startReceiverTransferCmd(i int, tc conn.Transfer) tea.Cmd {
+	ra, ok := m.payload.(io.ReaderAt)
+	if !ok {
+		return receiverCmd(i, func() tea.Msg {
+			return tui.ErrorMsg(errors.New("sending to several receivers requires a payload supporting positioned reads"))
+		})
+	}
+	msgs := m.transfers[i].msgs
+	return tea.Batch(
+		listenReceiverCmd(i, msgs),
+		receiverCmd(i, m.transferCmd(tc, io.NewSectionReader(ra, 0, m.payloadSize), msgs)))
+}
+
+// transferCmd returns the command transferring the payload over the secured connection, limited by the rate limit
+// and reporting its messages on msgs.
+func (m *model) transferCmd(tc conn.Transfer, payload io.Reader, msgs chan interface{}) tea.Cmd {
+	payload, compression, selection := portal.LimitSender(m.ctx, payload, m.compressionConfig(), announceSelection(m.selection, msgs), m.limiter)
+	return transferCmd(m.ctx, tc, payload, m.payloadSize,
+		sender.WithCodec(m.compression.Codec),
+		sender.WithCompression(compression),
+		sender.WithFormats(m.formats...),
+		sender.WithChecksum(m.checksum),
+		sender.WithStreams(m.streamsConfig()),
+		sender.WithSelection(selection),
+		sender.WithChunkSize(m.chunkSize),
+		sender.WithTransport(m.transport),
+		sender.WithMessages(msgs),
+	)
+}
+
+// progressView renders the progress of the transfer, with a progress bar for each of several receivers.
+func (m model) progressView() string {
+	if len(m.transfers) == 0 {
+		return m.transferProgress.View()
+	}
+	views := make([]string, 0, len(m.transfers))
+	for i, t := range m.transfers {
+		views = append(views, fmt.Sprintf("Receiver %d %s", i+1, t.progress.View()))
+	}
+	return strings.Join(views, "\n\n"+tui.PadText)
+}
+
+// compressionConfig returns the compression of the payload announced to the receiver, repacking the payload with
+// the negotiated codec if negotiating it.
+func (m *model) compressionConfig() *sender.Compression {
+	return &sender.Compression{RawSize: m.uncompressedSize, Repack: m.repack}
+}
+
+// streamsConfig returns the parallel streams the payload is split over.
+func (m *model) streamsConfig() sender.Streams {
+	return sender.Streams{Addr: m.rendezvousAddr, Count: m.streams, Opts: m.dialOpts}
+}
+
+func (m *model) newReceiverPrompt(fingerprint string) tea.Cmd {
+	prompt := confirmation.New(fmt.Sprintf("Send to the receiver with fingerprint %s?", fingerprint), confirmation.No)
+	m.receiverPrompt = *confirmation.NewModel(prompt)
+	m.receiverPrompt.MaxWidth = m.width
+	m.receiverPrompt.WrapMode = promptkit.HardWrap
+	m.receiverPrompt.Template = confirmation.TemplateYN
+	m.receiverPrompt.ResultTemplate = confirmation.ResultTemplateYN
+	m.receiverPrompt.KeyMap.Abort = []string{}
+	m.receiverPrompt.KeyMap.Toggle = []string{}
+	return m.receiverPrompt.Init()
+}
+
+// setPromptKeysEnabled toggles the prompt keys, used for approving the receiver.
+func (m *model) setPromptKeysEnabled(enabled bool) {
+	m.keys.OverwritePromptYes.SetHelp(m.keys.OverwritePromptYes.Help().Key, "approve receiver")
+	m.keys.OverwritePromptNo.SetHelp(m.keys.OverwritePromptNo.Help().Key, "reject receiver")
+	m.keys.OverwritePromptYes.SetEnabled(enabled)
+	m.keys.OverwritePromptNo.SetEnabled(enabled)
+	m.keys.OverwritePromptConfirm.SetEnabled(enabled)
+}
+
 func (m *model) copyReceiverCommand() string {
+	return ReceiverCommand(m.password)
+}
+
+// ReceiverCommand returns the command the receiver should run to receive
+// the files associated with the provided password.
+func ReceiverCommand(password string) string {
 	var btuilder strings.Builder
 	btuilder.WriteString("portal receive ")
-	btuilder.WriteString(m.password)
+	btuilder.WriteString(password)
 
 	relayAddrKey := "relay"
-	if !config.IsDefault(relayAddrKey) {
+	// the relay of senders on the local network is found over mDNS.
+	if viper.GetBool("local") {
+		btuilder.WriteString(" --local")
+	} else if !config.IsDefault(relayAddrKey) {
 		btuilder.WriteRune(' ')
 		btuilder.WriteString(fmt.Sprintf("--%s", relayAddrKey))
 		btuilder.WriteRune(' ')
 		btuilder.WriteString(viper.GetString(relayAddrKey))
 	}
+	// the token is shared out-of-band, only a placeholder hints that it is required.
+	if viper.GetString("relay_auth_token") != "" {
+		btuilder.WriteString(" --relay-auth <token>")
+	}
 
 	return btuilder.String()
 }
@@ -9,19 +9,31 @@ import (
 	"github.com/SpatiumPortae/portal/cmd/portal/tui"
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/pkg/errors"
 )
 
+// widths below which the progress is shown as a percentage only, rather than a progress bar.
+const COMPACT_WIDTH = 40
+
+// minimum number of spaces between the transferred bytes and the estimated remaining time.
+const statsGap = 2
+
 type Option func(*Model)
 
 type Model struct {
 	Width int
 
-	PayloadSize                int64
+	PayloadSize int64
+	// RawSize is the size of the files of the payload before compression, shown along the bytes on the wire
+	// once compression shrinks the payload, zero if unknown.
+	RawSize                    int64
 	bytesTransferred           int64
 	TransferStartTime          time.Time
 	TransferSpeedEstimateBps   int64
 	estimatedRemainingDuration time.Duration
+	// size of the chunks and throughput of the link, reported by senders adapting their chunks to the link.
+	chunkStats tui.ChunkStatsMsg
 
 	progress    float64
 	progressBar progress.Model
@@ -35,6 +47,7 @@ func New(opts ...Option) Model {
 	m := Model{
 		progressBar: tui.NewProgressBar(),
 	}
+	m.Width = m.progressBar.Width
 
 	for _, opt := range opts {
 		opt(&m)
@@ -46,13 +59,29 @@ func (Model) Init() tea.Cmd {
 	return nil
 }
 
+// View renders the progress within Width, showing only the percentage on narrow terminals.
 func (m Model) View() string {
+	if m.Width < COMPACT_WIDTH {
+		return fmt.Sprintf("%.0f%%", m.progress*100)
+	}
+
 	bytesProgress := strings.Builder{}
 	bytesProgress.WriteRune('(')
 	bytesProgress.WriteString(fmt.Sprintf("%s/%s", tui.ByteCountSI(m.bytesTransferred), tui.ByteCountSI(m.PayloadSize)))
-	if m.TransferSpeedEstimateBps > 0 {
+	// the current throughput of adapting senders is shown rather than the average.
+	if m.chunkStats.BytesPerSecond > 0 {
+		bytesProgress.WriteString(fmt.Sprintf(", %s/s", tui.ByteCountSI(int64(m.chunkStats.BytesPerSecond))))
+	} else if m.TransferSpeedEstimateBps > 0 {
 		bytesProgress.WriteString(fmt.Sprintf(", %s/s", tui.ByteCountSI(m.TransferSpeedEstimateBps)))
 	}
+	// the raw bytes, estimated from the share of the payload transferred, and the chunk size are dropped if they do
+	// not fit.
+	if raw := fmt.Sprintf(", %s/%s raw", tui.ByteCountSI(int64(m.progress*float64(m.RawSize))), tui.ByteCountSI(m.RawSize)); m.RawSize > m.PayloadSize && lipgloss.Width(bytesProgress.String()+raw)+1 <= m.Width {
+		bytesProgress.WriteString(raw)
+	}
+	if chunks := fmt.Sprintf(", %s chunks", tui.ByteCountSI(m.chunkStats.Size)); m.chunkStats.Size > 0 && lipgloss.Width(bytesProgress.String()+chunks)+1 <= m.Width {
+		bytesProgress.WriteString(chunks)
+	}
 	bytesProgress.WriteRune(')')
 
 	secondsRemaining := m.estimatedRemainingDuration.Round(time.Second)
@@ -62,7 +91,13 @@ func (m Model) View() string {
 	}
 	progressBar := m.progressBar.ViewAs(m.progress)
 
-	return bytesProgress.String() + "\t\t" + eta + "\n\n" +
+	// right-align the estimated remaining time with the progress bar, dropping it if it does not fit.
+	stats := bytesProgress.String()
+	if gap := m.Width - lipgloss.Width(stats) - lipgloss.Width(eta); eta != "" && gap >= statsGap {
+		stats += strings.Repeat(" ", gap) + eta
+	}
+
+	return stats + "\n\n" +
 		tui.PadText + progressBar
 }
 
@@ -74,6 +109,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.Width > tui.MAX_WIDTH {
 			m.Width = tui.MAX_WIDTH
 		}
+		if m.Width < 0 {
+			m.Width = 0
+		}
 		m.progressBar.Width = m.Width
 		return m, nil
 
@@ -94,6 +132,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.progress = math.Min(1.0, float64(m.bytesTransferred)/float64(m.PayloadSize))
 		return m, nil
 
+	case tui.ChunkStatsMsg:
+		m.chunkStats = msg
+		return m, nil
+
 	default:
 		return m, nil
 	}
@@ -0,0 +1,72 @@
+package transferprogress
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/cmd/portal/tui"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewFitsWidth(t *testing.T) {
+	for _, width := range []int{10, 30, 44, 60, 80, 120, 200} {
+		m := New()
+		m.PayloadSize = 512 * 1000 * 1000
+		m.TransferStartTime = time.Now().Add(-time.Minute)
+		model, _ := m.Update(tea.WindowSizeMsg{Width: width})
+		for _, progress := range []int{1000, 256 * 1000 * 1000} {
+			model, _ = model.Update(tui.ProgressMsg(progress))
+		}
+		m = model.(Model)
+
+		// the view is rendered indented by the parent models.
+		view := tui.PadText + m.View()
+		for _, line := range strings.Split(view, "\n") {
+			assert.LessOrEqual(t, lipgloss.Width(line), width, "line %q at width %d", line, width)
+		}
+		if width-2*tui.MARGIN-4 < COMPACT_WIDTH {
+			assert.Equal(t, tui.PadText+"50%", view, "compact view at width %d", width)
+		} else {
+			assert.Contains(t, view, "remaining", "full view at width %d", width)
+		}
+	}
+}
+
+func TestViewShowsChunkStats(t *testing.T) {
+	m := New()
+	m.PayloadSize = 512 * 1000 * 1000
+	m.TransferStartTime = time.Now().Add(-time.Minute)
+	model, _ := m.Update(tea.WindowSizeMsg{Width: 120})
+	model, _ = model.Update(tui.ProgressMsg(1000))
+	model, _ = model.Update(tui.ChunkStatsMsg{Size: 2 * 1000 * 1000, BytesPerSecond: 40 * 1000 * 1000})
+	view := model.(Model).View()
+	assert.Contains(t, view, "40.0 MB/s")
+	assert.Contains(t, view, "2.0 MB chunks")
+
+	// the chunk size is dropped on narrow terminals.
+	model, _ = model.Update(tea.WindowSizeMsg{Width: 44})
+	view = tui.PadText + model.(Model).View()
+	for _, line := range strings.Split(view, "\n") {
+		assert.LessOrEqual(t, lipgloss.Width(line), 44, "line %q", line)
+	}
+}
+
+func TestViewShowsRawSize(t *testing.T) {
+	m := New()
+	m.PayloadSize = 200 * 1000 * 1000
+	m.RawSize = 500 * 1000 * 1000
+	m.TransferStartTime = time.Now().Add(-time.Minute)
+	model, _ := m.Update(tea.WindowSizeMsg{Width: 120})
+	model, _ = model.Update(tui.ProgressMsg(100 * 1000 * 1000))
+	view := model.(Model).View()
+	assert.Contains(t, view, "100.0 MB/200.0 MB")
+	assert.Contains(t, view, "250.0 MB/500.0 MB raw")
+
+	// the raw size is not shown for payloads compression did not shrink.
+	m = model.(Model)
+	m.RawSize = m.PayloadSize
+	assert.NotContains(t, m.View(), "raw")
+}
@@ -7,7 +7,9 @@ import (
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/SpatiumPortae/portal/internal/checksum"
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/semver"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
@@ -29,12 +31,22 @@ type TransferTypeMsg struct {
 	Type transfer.Type
 }
 
+// ChecksumMsg is the checksum of the payload, sent by the sender or verified by the receiver.
+type ChecksumMsg checksum.Result
+
+// ChunkStatsMsg is the size of the chunks of the payload and the throughput of the link, as adapted by the sender.
+type ChunkStatsMsg transfer.ChunkStats
+
+// CompressionMsg is the compression of the payload, announced by the sender.
+type CompressionMsg transfer.Compression
+
 type TransferStateMessage struct {
 	State transfer.MsgType
 }
 
 type VersionMsg struct {
 	ServerVersion semver.Version
+	Err           error // set if the server version could not be verified
 }
 
 // ------------------------------------------------------ Spinners -----------------------------------------------------
@@ -94,6 +106,17 @@ func TopLevelFilesText(fileNames []string) string {
 	return strings.Join(topLevelFilesText, ", ")
 }
 
+// PrintableText strips control characters other than newlines and tabs from the text, such that text
+// received from a peer cannot inject terminal escape sequences.
+func PrintableText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
 // Credits to (legendary Mr. Nilsson): https://yourbasic.org/golang/formatting-byte-size-to-human-readable-format/
 func ByteCountSI(b int64) string {
 	const unit = 1000
@@ -123,11 +146,11 @@ func QuitCmd() tea.Cmd {
 	}
 }
 
-func VersionCmd(ctx context.Context, rendezvousAddr string) tea.Cmd {
+func VersionCmd(ctx context.Context, rendezvousAddr string, opts ...conn.DialOption) tea.Cmd {
 	return func() tea.Msg {
-		ver, err := semver.GetRendezvousVersion(ctx, rendezvousAddr)
+		ver, err := semver.GetRendezvousVersion(ctx, conn.HTTPClient(opts...), rendezvousAddr)
 		if err != nil {
-			return ErrorMsg(err)
+			return VersionMsg{Err: err}
 		}
 		return VersionMsg{
 			ServerVersion: ver,
@@ -1,3 +1,8 @@
 package data
 
 var SpaceWordList = []string{"solar", "storm", "moon", "planet", "asteroid", "comet", "star", "elliptical", "orbit", "constellation", "cosmos", "crater", "dust", "eclipse", "galaxy", "lunar", "meteor", "gravity", "inertia", "mass", "magnitude", "amplitude", "nebula", "orbit", "lightyear", "parsec", "celestial", "neutron", "supernova", "aurora", "quasar", "cluster", "redshift", "blueshift", "gamma", "flare", "ray", "zenith", "zodiac", "neutrino", "tidal", "matter", "universe", "hydrogen", "helium", "oxygen", "carbon", "iron", "system", "dwarf", "atom", "quark", "exoplanet", "cloud", "astro", "gas", "proton", "electron", "halo", "void", "phase", "infinite", "vacuum", "relative", "dynamic", "static", "alpha", "beta", "delta", "sigma", "lambda", "anti", "macro", "micro", "spin", "wave", "particle", "scatter", "ether", "solid", "liquid", "plasma"}
+
+// WordLists are the bundled word lists passwords can be generated from, by name.
+var WordLists = map[string][]string{
+	"space": SpaceWordList,
+}
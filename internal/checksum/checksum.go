@@ -0,0 +1,103 @@
+// checksum.go specifies the checksums proving the end-to-end integrity of a transferred payload.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// ErrMismatch is returned when the checksum of the received payload does not match the checksum of the sender.
+var ErrMismatch = errors.New("checksum mismatch")
+
+// New returns a hash computing the checksum of the provided algorithm, one of transfer.Checksums.
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case transfer.CHECKSUM_SHA256:
+		return sha256.New(), nil
+	case transfer.CHECKSUM_BLAKE3:
+		return blake3.New(), nil
+	case transfer.CHECKSUM_XXHASH:
+		return xxhash.New(), nil
+	default:
+		return nil, transfer.ValidateChecksum(algorithm)
+	}
+}
+
+// Digest digests a payload in order as it is transferred. Bytes transferred again, when a lost connection
+// resumes from an earlier offset, are only digested once. A nil Digest digests nothing.
+type Digest struct {
+	algorithm string
+	h         hash.Hash
+	digested  int64 // number of payload bytes digested
+}
+
+// NewDigest returns a digest of the provided algorithm, or nil if no algorithm was negotiated.
+func NewDigest(algorithm string) (*Digest, error) {
+	if algorithm == "" {
+		return nil, nil
+	}
+	h, err := New(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{algorithm: algorithm, h: h}, nil
+}
+
+// Write digests the bytes of the payload at the provided offset, skipping the bytes already digested.
+// Bytes past the digested bytes are not expected, payloads are transferred in order.
+func (d *Digest) Write(b []byte, offset int64) {
+	if d == nil || offset+int64(len(b)) <= d.digested {
+		return
+	}
+	if skip := d.digested - offset; skip > 0 {
+		b = b[skip:]
+	}
+	d.h.Write(b)
+	d.digested += int64(len(b))
+}
+
+// Sum returns the hex encoded checksum of the bytes digested so far.
+func (d *Digest) Sum() string {
+	if d == nil {
+		return ""
+	}
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// Result is the checksum of a transferred payload, reported on the messages of the transfer once sent by the
+// sender or verified by the receiver, such that users can compare it out-of-band.
+type Result struct {
+	Algorithm string
+	Sum       string // hex encoded checksum
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s:%s", r.Algorithm, r.Sum)
+}
+
+// Result returns the checksum of the bytes digested so far.
+func (d *Digest) Result() Result {
+	if d == nil {
+		return Result{}
+	}
+	return Result{Algorithm: d.algorithm, Sum: d.Sum()}
+}
+
+// Verify checks that the checksum of the bytes digested so far matches the checksum of the sender,
+// returning a ErrMismatch otherwise.
+func (d *Digest) Verify(expected string) error {
+	if d == nil {
+		return nil
+	}
+	if sum := d.Sum(); sum != expected {
+		return fmt.Errorf("%w: %s %s, expected %s", ErrMismatch, d.algorithm, sum, expected)
+	}
+	return nil
+}
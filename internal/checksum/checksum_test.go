@@ -0,0 +1,45 @@
+package checksum_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	payload := []byte("a frog walks into a bank")
+	for _, algorithm := range transfer.Checksums {
+		t.Run(algorithm, func(t *testing.T) {
+			whole, err := checksum.NewDigest(algorithm)
+			require.NoError(t, err)
+			whole.Write(payload, 0)
+
+			// bytes sent again after resuming from an earlier offset are digested once.
+			resumed, err := checksum.NewDigest(algorithm)
+			require.NoError(t, err)
+			resumed.Write(payload[:10], 0)
+			resumed.Write(payload[:4], 0)
+			resumed.Write(payload[6:], 6)
+			assert.Equal(t, whole.Sum(), resumed.Sum())
+			assert.NoError(t, resumed.Verify(whole.Sum()))
+
+			truncated, err := checksum.NewDigest(algorithm)
+			require.NoError(t, err)
+			truncated.Write(payload[:10], 0)
+			assert.ErrorIs(t, truncated.Verify(whole.Sum()), checksum.ErrMismatch)
+		})
+	}
+	t.Run("not negotiated", func(t *testing.T) {
+		digest, err := checksum.NewDigest("")
+		require.NoError(t, err)
+		digest.Write(payload, 0)
+		assert.NoError(t, digest.Verify(""))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		_, err := checksum.NewDigest("md5")
+		assert.Error(t, err)
+	})
+}
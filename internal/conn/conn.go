@@ -2,8 +2,12 @@ package conn
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"time"
 
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
@@ -13,10 +17,76 @@ import (
 // impose no message size limit
 const MESSAGE_SIZE_LIMIT_BYTES = math.MaxInt64 - 1
 
+// Close codes sent when tearing down a connection, such that the peer can distinguish
+// a completed transfer from a canceled or failed one.
+const (
+	CLOSE_COMPLETED = websocket.StatusNormalClosure
+	CLOSE_CANCELED  = websocket.StatusGoingAway
+	CLOSE_FAILED    = websocket.StatusInternalError
+)
+
+// number of bytes of the key digest shown in a connection fingerprint.
+const FINGERPRINT_BYTES = 4
+
 // Conn is an interface that wraps a network connection.
 type Conn interface {
 	Read(context.Context) ([]byte, error)
 	Write(context.Context, []byte) error
+	Close(code websocket.StatusCode, reason string) error
+}
+
+// Frame is a message read from a connection together with its message type (text or binary).
+// Messages sent as multiple continuation frames are reassembled into a single frame.
+type Frame struct {
+	Type    websocket.MessageType
+	Payload []byte
+}
+
+// FrameConn is implemented by connections that preserve the message type of the messages they carry.
+type FrameConn interface {
+	ReadFrame(context.Context) (Frame, error)
+	WriteFrame(context.Context, Frame) error
+}
+
+// CloseStatus returns the close code and reason describing how a transfer ended with the provided error.
+// Reasons are fixed, such that errors, and the paths and addresses they carry, are not disclosed to the peer.
+func CloseStatus(err error) (websocket.StatusCode, string) {
+	switch {
+	case err == nil:
+		return CLOSE_COMPLETED, rendezvous.TRANSFER_COMPLETED
+	case errors.Is(err, context.Canceled):
+		return CLOSE_CANCELED, rendezvous.TRANSFER_CANCELED
+	default:
+		return CLOSE_FAILED, rendezvous.TRANSFER_FAILED
+	}
+}
+
+// CloseWithError closes the connection with the close code and reason describing how the
+// transfer ended, a nil error signals a completed transfer.
+func CloseWithError(c Conn, err error) error {
+	return c.Close(CloseStatus(err))
+}
+
+// CloseTimeout closes the connection like Close, but waits at most timeout for the peer to acknowledge
+// the close frame, after which the connection is closed without completing the close handshake.
+// A non-positive timeout closes the connection like Close.
+func CloseTimeout(c Conn, code websocket.StatusCode, reason string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return c.Close(code, reason)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Close(code, reason) }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+	if f, ok := c.(interface{ CloseNow() error }); ok {
+		f.CloseNow() //nolint:errcheck
+	}
+	return fmt.Errorf("peer did not acknowledge close frame within %s", timeout)
 }
 
 // ------------------ Conn implementations ------------------
@@ -24,17 +94,139 @@ type Conn interface {
 // WS is a wrapper around a websocket connection.
 type WS struct {
 	Conn *websocket.Conn
+
+	// subprotocol is the negotiated subprotocol, messages are translated to and from JSON text messages if
+	// the JSON-framed subprotocol was negotiated.
+	subprotocol string
+
+	// closeOnCancel sends a close frame with CLOSE_CANCELED when the context of a read is canceled,
+	// instead of the websocket library tearing down the connection with a policy violation.
+	closeOnCancel bool
 }
 
 func (ws *WS) Read(ctx context.Context) ([]byte, error) {
+	frame, err := ws.ReadFrame(ctx)
+	return frame.Payload, err
+}
+
+func (ws *WS) Write(ctx context.Context, payload []byte) error {
+	return ws.WriteFrame(ctx, Frame{Type: websocket.MessageBinary, Payload: payload})
+}
+
+// Subprotocol returns the subprotocol negotiated for the connection, empty if none was negotiated.
+func (ws *WS) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// ReadFrame reads a message from the connection, preserving its message type.
+// Messages of JSON-framed connections are read as the binary messages of the native protocol.
+func (ws *WS) ReadFrame(ctx context.Context) (Frame, error) {
+	frame, err := ws.readFrame(ctx)
+	if err != nil || ws.subprotocol != rendezvous.SUBPROTOCOL_JSON {
+		return frame, err
+	}
+	return decodeJSONFrame(frame)
+}
+
+func (ws *WS) readFrame(ctx context.Context) (Frame, error) {
 	// this limit is per-message and thus needs to be set before each read
 	ws.Conn.SetReadLimit(MESSAGE_SIZE_LIMIT_BYTES)
-	_, payload, err := ws.Conn.Read(ctx)
-	return payload, err
+	if !ws.closeOnCancel {
+		typ, payload, err := ws.Conn.Read(ctx)
+		return Frame{Type: typ, Payload: payload}, err
+	}
+	defer ws.closeOnDone(ctx)()
+	typ, payload, err := ws.Conn.Read(context.Background())
+	if ctx.Err() != nil {
+		return Frame{}, ctx.Err()
+	}
+	return Frame{Type: typ, Payload: payload}, err
 }
 
-func (ws *WS) Write(ctx context.Context, payload []byte) error {
-	return ws.Conn.Write(ctx, websocket.MessageBinary, payload)
+// WriteFrame writes a message to the connection with the message type of the frame.
+// Messages of JSON-framed connections are written as JSON text messages.
+func (ws *WS) WriteFrame(ctx context.Context, frame Frame) error {
+	if ws.subprotocol == rendezvous.SUBPROTOCOL_JSON {
+		var err error
+		if frame, err = encodeJSONFrame(frame); err != nil {
+			return err
+		}
+	}
+	if !ws.closeOnCancel {
+		return ws.Conn.Write(ctx, frame.Type, frame.Payload)
+	}
+	defer ws.closeOnDone(ctx)()
+	err := ws.Conn.Write(context.Background(), frame.Type, frame.Payload)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// closeOnDone closes the connection with a close code describing the context error once the
+// context is done, until the returned function is called.
+func (ws *WS) closeOnDone(ctx context.Context) func() {
+	if ctx.Err() != nil {
+		ws.Conn.Close(CloseStatus(ctx.Err())) //nolint:errcheck
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Conn.Close(CloseStatus(ctx.Err())) //nolint:errcheck
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (ws *WS) Close(code websocket.StatusCode, reason string) error {
+	return ws.Conn.Close(code, reason)
+}
+
+// CloseNow closes the connection without performing the close handshake.
+func (ws *WS) CloseNow() error {
+	return ws.Conn.CloseNow()
+}
+
+// Ping sends a websocket ping and waits for the pong of the peer, answered while the peer reads from the connection.
+func (ws *WS) Ping(ctx context.Context) error {
+	return ws.Conn.Ping(ctx)
+}
+
+// encodeJSONFrame encodes a message as a JSON text message. Rendezvous messages are sent as is, other payloads,
+// i.e. encrypted payloads, as base64 encoded JSON strings.
+func encodeJSONFrame(frame Frame) (Frame, error) {
+	if isJSONObject(frame.Payload) {
+		return Frame{Type: websocket.MessageText, Payload: frame.Payload}, nil
+	}
+	payload, err := json.Marshal(frame.Payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("encoding JSON frame: %w", err)
+	}
+	return Frame{Type: websocket.MessageText, Payload: payload}, nil
+}
+
+// decodeJSONFrame decodes a JSON text message, the inverse of encodeJSONFrame. Rendezvous messages are read
+// as text messages, such that control messages are told apart from encrypted payloads.
+func decodeJSONFrame(frame Frame) (Frame, error) {
+	if frame.Type != websocket.MessageText {
+		return Frame{}, errors.New("JSON-framed connection received a binary message")
+	}
+	if isJSONObject(frame.Payload) {
+		return Frame{Type: websocket.MessageText, Payload: frame.Payload}, nil
+	}
+	var payload []byte
+	if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+		return Frame{}, fmt.Errorf("decoding JSON frame: %w", err)
+	}
+	return Frame{Type: websocket.MessageBinary, Payload: payload}, nil
+}
+
+// isJSONObject reports whether b is a JSON object, such as a rendezvous message.
+func isJSONObject(b []byte) bool {
+	return len(b) > 0 && b[0] == '{' && json.Valid(b)
 }
 
 // ------------------ Rendezvous Conn ------------------------
@@ -42,6 +234,11 @@ func (ws *WS) Write(ctx context.Context, payload []byte) error {
 // Rendezvous specifies a connection to the rendezvous server.
 type Rendezvous struct {
 	Conn Conn
+	// Redial resumes a lost connection to the rendezvous server, nil if the connection cannot be resumed.
+	Redial func(context.Context) (Conn, error)
+	// Token is the session token issued to the sender, empty for receivers and rendezvous servers that predate
+	// resumption.
+	Token string
 }
 
 // ReadRaw reads raw bytes from the underlying connection.
@@ -59,6 +256,27 @@ func (r Rendezvous) WriteRaw(ctx context.Context, b []byte) error {
 	return err
 }
 
+// ReadFrame reads a message from the underlying connection, preserving its message type if supported by the connection.
+// Connections that do not preserve message types read binary messages.
+func (r Rendezvous) ReadFrame(ctx context.Context) (Frame, error) {
+	if fc, ok := r.Conn.(FrameConn); ok {
+		return fc.ReadFrame(ctx)
+	}
+	b, err := r.Conn.Read(ctx)
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Type: websocket.MessageBinary, Payload: b}, nil
+}
+
+// WriteFrame writes a message to the underlying connection, preserving its message type if supported by the connection.
+func (r Rendezvous) WriteFrame(ctx context.Context, frame Frame) error {
+	if fc, ok := r.Conn.(FrameConn); ok {
+		return fc.WriteFrame(ctx, frame)
+	}
+	return r.Conn.Write(ctx, frame.Payload)
+}
+
 // ReadMsg reads a rendezvous message from the underlying connection.
 func (r Rendezvous) ReadMsg(ctx context.Context, expected ...rendezvous.MsgType) (rendezvous.Msg, error) {
 	b, err := r.Conn.Read(ctx)
@@ -69,10 +287,15 @@ func (r Rendezvous) ReadMsg(ctx context.Context, expected ...rendezvous.MsgType)
 	if err := json.Unmarshal(b, &msg); err != nil {
 		return rendezvous.Msg{}, err
 	}
-	if len(expected) != 0 && expected[0] != msg.Type {
-		return rendezvous.Msg{}, rendezvous.Error{Expected: expected, Got: msg.Type}
+	if len(expected) == 0 {
+		return msg, nil
 	}
-	return msg, nil
+	for _, t := range expected {
+		if t == msg.Type {
+			return msg, nil
+		}
+	}
+	return rendezvous.Msg{}, rendezvous.Error{Expected: expected, Got: msg.Type}
 }
 
 // WriteMsg writes a rendezvous message to the underlying connection.
@@ -84,20 +307,38 @@ func (r Rendezvous) WriteMsg(ctx context.Context, msg rendezvous.Msg) error {
 	return r.Conn.Write(ctx, payload)
 }
 
+// WriteControl writes a rendezvous message addressed to the rendezvous server while relaying, enveloped as a
+// control message, see rendezvous.Control.
+func (r Rendezvous) WriteControl(ctx context.Context, msg rendezvous.Msg) error {
+	payload, err := json.Marshal(rendezvous.Control{Control: &msg})
+	if err != nil {
+		return err
+	}
+	return r.WriteFrame(ctx, Frame{Type: websocket.MessageText, Payload: payload})
+}
+
 // ------------------ Transfer Conn ----------------------------
 
 // Transfer specifies a encrypted connection safe to transfer files over.
 type Transfer struct {
-	Conn  Conn
-	crypt crypt
+	Conn Conn
+	// Redial resumes a lost connection to the rendezvous server, nil if the connection cannot be resumed.
+	Redial func(context.Context) (Conn, error)
+	// OnIdle is called with the time until the relay is closed when the rendezvous server warns that the
+	// relay is idle. The warnings are skipped when reading, whether or not OnIdle is set.
+	OnIdle func(disconnectIn time.Duration)
+	// OnClosing is called with the time until the relay is closed when the rendezvous server warns that it is
+	// shutting down. The warnings are skipped when reading, whether or not OnClosing is set.
+	OnClosing func(disconnectIn time.Duration)
+	crypt     crypt
 }
 
 // TransferFromSession returns a secure connection using the provided session key
-// and salt.
-func TransferFromSession(conn Conn, sessionkey, salt []byte) Transfer {
+// and salt, deriving the key using the provided number of iterations.
+func TransferFromSession(conn Conn, sessionkey, salt []byte, iterations int) Transfer {
 	return Transfer{
 		Conn:  conn,
-		crypt: NewCrypt(sessionkey, salt),
+		crypt: NewCrypt(sessionkey, salt, iterations),
 	}
 }
 
@@ -114,13 +355,32 @@ func (tc Transfer) Key() []byte {
 	return tc.crypt.Key
 }
 
+// Fingerprint returns a short fingerprint of the cryptographic key, identical for both peers
+// of the connection, such that they can verify out-of-band that they are connected to each other.
+func (tc Transfer) Fingerprint() string {
+	sum := sha256.Sum256(tc.crypt.Key)
+	half := FINGERPRINT_BYTES / 2
+	return fmt.Sprintf("%X-%X", sum[:half], sum[half:FINGERPRINT_BYTES])
+}
+
 // ReadRaw reads and decrypts raw bytes from the underlying connection.
 func (t Transfer) ReadRaw(ctx context.Context) ([]byte, error) {
-	b, err := t.Conn.Read(ctx)
-	if err != nil {
-		return nil, err
+	for {
+		b, err := t.Conn.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if warning, disconnectIn, ok := relayWarning(b); ok {
+			switch {
+			case warning == rendezvous.RendezvousToPeerIdle && t.OnIdle != nil:
+				t.OnIdle(disconnectIn)
+			case warning == rendezvous.RendezvousToPeerClosing && t.OnClosing != nil:
+				t.OnClosing(disconnectIn)
+			}
+			continue
+		}
+		return t.crypt.Decrypt(b)
 	}
-	return t.crypt.Decrypt(b)
 }
 
 // WriteRaw encrypts and writes the raw bytes to the underlying connection.
@@ -157,3 +417,17 @@ func (t Transfer) WriteMsg(ctx context.Context, msg transfer.Msg) error {
 	}
 	return t.WriteRaw(ctx, b)
 }
+
+// relayWarning returns the type of the warning and the time until the relay is closed, if the message is an
+// unencrypted idle or closing warning of the rendezvous server rather than an encrypted message of the peer.
+func relayWarning(b []byte) (rendezvous.MsgType, time.Duration, bool) {
+	if len(b) == 0 || b[0] != '{' {
+		return 0, 0, false
+	}
+	var msg rendezvous.Msg
+	if err := json.Unmarshal(b, &msg); err != nil ||
+		(msg.Type != rendezvous.RendezvousToPeerIdle && msg.Type != rendezvous.RendezvousToPeerClosing) {
+		return 0, 0, false
+	}
+	return msg.Type, msg.Payload.DisconnectIn, true
+}
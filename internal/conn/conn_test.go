@@ -3,12 +3,15 @@ package conn_test
 import (
 	"context"
 	"crypto/rand"
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
 	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
 )
 
 type mockConn struct {
@@ -24,6 +27,10 @@ func (m mockConn) Read(ctx context.Context) ([]byte, error) {
 	return <-m.conn, nil
 }
 
+func (m mockConn) Close(code websocket.StatusCode, reason string) error {
+	return nil
+}
+
 func TestConn(t *testing.T) {
 	c := make(chan []byte, 2)
 	conn1 := mockConn{conn: c}
@@ -49,8 +56,8 @@ func TestConn(t *testing.T) {
 		assert.NoError(t, err)
 
 		ctx := context.Background()
-		t1 := conn.TransferFromSession(&conn1, sessionkey, salt)
-		t2 := conn.TransferFromSession(&conn2, sessionkey, salt)
+		t1 := conn.TransferFromSession(&conn1, sessionkey, salt, conn.DEFAULT_KDF_ITERATIONS)
+		t2 := conn.TransferFromSession(&conn2, sessionkey, salt, conn.DEFAULT_KDF_ITERATIONS)
 
 		err = t1.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverHandshake})
 		assert.NoError(t, err)
@@ -60,3 +67,30 @@ func TestConn(t *testing.T) {
 		assert.Equal(t, msg.Type, transfer.ReceiverHandshake)
 	})
 }
+
+func TestCloseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   websocket.StatusCode
+		reason string
+	}{
+		{"completed", nil, conn.CLOSE_COMPLETED, rendezvous.TRANSFER_COMPLETED},
+		{"canceled", fmt.Errorf("reading: %w", context.Canceled), conn.CLOSE_CANCELED, rendezvous.TRANSFER_CANCELED},
+		{"failed", fmt.Errorf("opening /home/alice/secret.txt: %w", os.ErrPermission), conn.CLOSE_FAILED, rendezvous.TRANSFER_FAILED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := conn.CloseStatus(tt.err)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestValidateKDFIterations(t *testing.T) {
+	assert.NoError(t, conn.ValidateKDFIterations(conn.DEFAULT_KDF_ITERATIONS))
+	assert.NoError(t, conn.ValidateKDFIterations(conn.MAX_KDF_ITERATIONS))
+	assert.Error(t, conn.ValidateKDFIterations(conn.MIN_KDF_ITERATIONS-1))
+	assert.Error(t, conn.ValidateKDFIterations(conn.MAX_KDF_ITERATIONS+1))
+}
@@ -5,19 +5,39 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// ErrAuthentication is returned when a message fails to authenticate, i.e. it was tampered with or the peers
+// do not share the cryptographic key.
+var ErrAuthentication = errors.New("message authentication failed")
+
 type crypt struct {
 	Key []byte
 }
 
-// NewCrypt returns a new crypt object, with a sha256 cryptographic key derived from specified,
-//  sessionkey and the specified salt.
-func NewCrypt(sessionkey []byte, salt []byte) crypt {
-	key := pbkdf2.Key(sessionkey, salt, 100, 32, sha256.New)
+// Bounds of the number of PBKDF2 iterations used to derive the cryptographic key from the session key.
+const (
+	DEFAULT_KDF_ITERATIONS = 100
+	MIN_KDF_ITERATIONS     = DEFAULT_KDF_ITERATIONS
+	MAX_KDF_ITERATIONS     = 1_000_000
+)
+
+// ValidateKDFIterations checks that the provided number of key derivation iterations is within safe bounds.
+func ValidateKDFIterations(iterations int) error {
+	if iterations < MIN_KDF_ITERATIONS || iterations > MAX_KDF_ITERATIONS {
+		return fmt.Errorf("key derivation iterations %d out of bounds [%d, %d]", iterations, MIN_KDF_ITERATIONS, MAX_KDF_ITERATIONS)
+	}
+	return nil
+}
+
+// NewCrypt returns a new crypt object, with a sha256 cryptographic key derived from specified
+// sessionkey and the specified salt, using the specified number of PBKDF2 iterations.
+func NewCrypt(sessionkey []byte, salt []byte, iterations int) crypt {
+	key := pbkdf2.Key(sessionkey, salt, iterations, 32, sha256.New)
 	crypt := crypt{
 		Key: key,
 	}
@@ -55,9 +75,12 @@ func (s *crypt) Decrypt(encrypted []byte) (decrypted []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
-	decrypted, err = aescgm.Open(nil, encrypted[:12], encrypted[12:], nil)
+	if len(encrypted) < aescgm.NonceSize() {
+		return nil, ErrAuthentication
+	}
+	decrypted, err = aescgm.Open(nil, encrypted[:aescgm.NonceSize()], encrypted[aescgm.NonceSize():], nil)
 	if err != nil {
-		return nil, err
+		return nil, ErrAuthentication
 	}
 	return decrypted, nil
 }
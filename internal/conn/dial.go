@@ -0,0 +1,125 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	DIAL_TIMEOUT    = 30 * time.Second
+	DIAL_KEEP_ALIVE = 30 * time.Second
+	// Delay before racing a fallback connection attempt, as recommended by RFC 8305.
+	HAPPY_EYEBALLS_DELAY = 250 * time.Millisecond
+)
+
+// ErrUnauthorized is returned when the rendezvous server refuses to upgrade the connection of an unauthorized client.
+var ErrUnauthorized = errors.New("unauthorized by the rendezvous server")
+
+// ErrRejected is returned when the rendezvous server rejects the connection of a client exceeding its limits, e.g.
+// its mailbox limit or transfer quota.
+var ErrRejected = errors.New("rejected by the rendezvous server")
+
+// DialOption configures how connections are dialed.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	dialer       *net.Dialer
+	header       http.Header                           // headers of the websocket handshake
+	subprotocols []string                              // subprotocols requested in the websocket handshake, in order of preference
+	proxy        func(*http.Request) (*url.URL, error) // nil if connections are dialed through the proxy of the environment
+	tlsConfig    *tls.Config                           // nil if servers are verified against the system roots
+}
+
+// WithDialer uses the provided dialer to establish network connections.
+func WithDialer(dialer *net.Dialer) DialOption {
+	return func(o *dialOptions) {
+		o.dialer = dialer
+	}
+}
+
+// WithTraceContext propagates the trace context of the span in ctx to the rendezvous server in the headers
+// of the websocket handshake, such that the traces of the server continue the trace of the client.
+func WithTraceContext(ctx context.Context) DialOption {
+	return func(o *dialOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(o.header))
+	}
+}
+
+// WithHeader sets a header of the websocket handshake, e.g. to authenticate to the rendezvous server.
+func WithHeader(key, value string) DialOption {
+	return func(o *dialOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Set(key, value)
+	}
+}
+
+// WithSubprotocols requests the provided subprotocols in the websocket handshake, in order of preference.
+func WithSubprotocols(subprotocols ...string) DialOption {
+	return func(o *dialOptions) {
+		o.subprotocols = subprotocols
+	}
+}
+
+// WithTLSConfig secures the connections to servers reached over TLS with the provided config, e.g. to verify the
+// rendezvous server against custom root CAs, or to present a client certificate.
+func WithTLSConfig(config *tls.Config) DialOption {
+	return func(o *dialOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// NewDialer returns a dialer with Happy Eyeballs (RFC 8305) dual-stack behaviour, racing IPv4
+// against IPv6 connection attempts. If dnsServer is non-empty, names are resolved using the
+// provided DNS server, the port defaults to 53 if omitted.
+func NewDialer(dnsServer string) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:       DIAL_TIMEOUT,
+		KeepAlive:     DIAL_KEEP_ALIVE,
+		FallbackDelay: HAPPY_EYEBALLS_DELAY,
+	}
+	if dnsServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+			dnsServer = net.JoinHostPort(dnsServer, "53")
+		}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+	return dialer
+}
+
+// SplitAddrs splits a comma-separated list of addresses, tried in order as fallbacks.
+func SplitAddrs(addrs string) []string {
+	var split []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			split = append(split, addr)
+		}
+	}
+	return split
+}
+
+func newDialOptions(opts ...DialOption) dialOptions {
+	o := dialOptions{dialer: NewDialer("")}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
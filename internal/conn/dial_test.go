@@ -0,0 +1,96 @@
+package conn_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"nhooyr.io/websocket"
+)
+
+func TestDial(t *testing.T) {
+	resolverAddr := stubResolver(t, net.IPv4(127, 0, 0, 1))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	t.Run("stub resolver", func(t *testing.T) {
+		dialer := conn.NewDialer(resolverAddr)
+		ws, err := conn.Dial(context.Background(), fmt.Sprintf("ws://portal.invalid:%s", port), conn.WithDialer(dialer))
+		require.NoError(t, err)
+		ws.Conn.Close(websocket.StatusNormalClosure, "")
+	})
+
+	t.Run("http client", func(t *testing.T) {
+		client := conn.HTTPClient(conn.WithDialer(conn.NewDialer(resolverAddr)))
+		_, err := client.Get(fmt.Sprintf("http://portal.invalid:%s", port))
+		assert.NoError(t, err)
+	})
+
+	t.Run("http client proxy", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://portal.invalid", nil)
+		require.NoError(t, err)
+		// the proxy of the environment is cached by the standard library on first use, only its presence is checked.
+		assert.NotNil(t, conn.HTTPClient().Transport.(*http.Transport).Proxy)
+		proxy, err := conn.HTTPClient(conn.WithProxy("http://proxy.invalid:3128")).Transport.(*http.Transport).Proxy(req)
+		require.NoError(t, err)
+		assert.Equal(t, "http://proxy.invalid:3128", proxy.String())
+	})
+}
+
+// stubResolver starts a DNS server that resolves every A query to the provided ip.
+func stubResolver(t *testing.T, ip net.IP) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+			builder.EnableCompression()
+			_ = builder.StartQuestions()
+			_ = builder.Question(question)
+			_ = builder.StartAnswers()
+			if question.Type == dnsmessage.TypeA {
+				var a dnsmessage.AResource
+				copy(a.A[:], ip.To4())
+				_ = builder.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}, a)
+			}
+			msg, err := builder.Finish()
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(msg, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
@@ -0,0 +1,26 @@
+//go:build js
+
+package conn
+
+import (
+	"context"
+	"net/http"
+
+	"nhooyr.io/websocket"
+)
+
+// Dial dials a websocket connection to the provided url. The dial options are
+// ignored on the js platform, as dialing is handled by the browser.
+func Dial(ctx context.Context, url string, opts ...DialOption) (*WS, error) {
+	ws, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WS{Conn: ws, closeOnCancel: true}, nil
+}
+
+// HTTPClient returns the default HTTP client, as dialing is handled by the browser
+// on the js platform.
+func HTTPClient(opts ...DialOption) *http.Client {
+	return http.DefaultClient
+}
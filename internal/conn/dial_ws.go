@@ -0,0 +1,91 @@
+//go:build !js
+
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// Dial dials a websocket connection to the provided url.
+func Dial(ctx context.Context, url string, opts ...DialOption) (*WS, error) {
+	o := newDialOptions(opts...)
+	client, err := websocketClient(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ws, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPClient:   client,
+		HTTPHeader:   o.header,
+		Subprotocols: o.subprotocols,
+	})
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		// the server tells the limit exceeded in the body of the rejection.
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			reason, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("%w: %s", ErrRejected, strings.TrimSpace(string(reason)))
+		}
+		return nil, err
+	}
+	return &WS{Conn: ws, subprotocol: ws.Subprotocol(), closeOnCancel: true}, nil
+}
+
+// websocketClient returns the HTTP client the websocket connection to the url is dialed with. Unencrypted
+// websocket connections are tunneled through HTTP proxies with CONNECT, rather than forwarded as plain HTTP
+// requests, as proxies commonly refuse to forward upgrades.
+func websocketClient(rawURL string, opts ...DialOption) (*http.Client, error) {
+	o := newDialOptions(opts...)
+	client := HTTPClient(opts...)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %w", err)
+	}
+	// websocket handshakes upgrade HTTP/1.1 connections, servers reached over TLS must not negotiate HTTP/2.
+	transport := client.Transport.(*http.Transport)
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	// encrypted connections are tunneled with CONNECT by the transport itself.
+	if transport.Proxy == nil || u.Scheme != "ws" && u.Scheme != "http" {
+		return client, nil
+	}
+	proxy, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "http", Host: u.Host}})
+	if err != nil {
+		return nil, fmt.Errorf("resolving proxy: %w", err)
+	}
+	if proxy == nil || proxy.Scheme != "http" && proxy.Scheme != "https" {
+		return client, nil
+	}
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialTunnel(ctx, o.dialer, proxy, addr)
+	}
+	return client, nil
+}
+
+// HTTPClient returns a HTTP client that dials connections using the provided options. Connections are dialed
+// through the proxy of the environment unless a proxy is provided, as by http.DefaultTransport.
+func HTTPClient(opts ...DialOption) *http.Client {
+	o := newDialOptions(opts...)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = o.dialer.DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	if o.proxy != nil {
+		transport.Proxy = o.proxy
+	}
+	if o.tlsConfig != nil {
+		transport.TLSClientConfig = o.tlsConfig.Clone()
+	}
+	return &http.Client{Transport: transport}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
 	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 )
@@ -24,7 +27,11 @@ func FromContext(ctx context.Context) (Conn, error) {
 	return conn, nil
 }
 
-func Middleware() func(http.Handler) http.Handler {
+// Middleware upgrades requests to websocket connections, made available to the handler through the request
+// context. The connection is closed once handled, waiting at most closeTimeout for the close handshake.
+// The first of the provided subprotocols requested by the client is negotiated, clients requesting only
+// unsupported subprotocols are rejected with 400 Bad Request. Clients requesting no subprotocol are accepted.
+func Middleware(closeTimeout time.Duration, subprotocols ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
@@ -32,12 +39,54 @@ func Middleware() func(http.Handler) http.Handler {
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 			}
-			wsConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+			if requested := requestedSubprotocols(r); len(requested) > 0 && !supportsAny(subprotocols, requested) {
+				logger.Warn("rejecting unsupported subprotocols", zap.Strings("subprotocols", requested))
+				http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+				return
+			}
+			wsConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true, Subprotocols: subprotocols})
 			if err != nil {
 				logger.Error("failed to upgrade connection", zap.Error(err))
 				return
 			}
-			next.ServeHTTP(w, r.WithContext(WithConn(r.Context(), &WS{Conn: wsConn})))
+			// close the connection once handled, a no-op if the handler already closed it. Connections of
+			// handlers that panicked are closed as failed, the panic is left to the recovering middleware.
+			ws := &WS{Conn: wsConn, subprotocol: wsConn.Subprotocol()}
+			handled := false
+			defer func() {
+				code, reason := websocket.StatusNormalClosure, ""
+				if !handled {
+					code, reason = CLOSE_FAILED, rendezvous.PANIC_CLOSE_REASON
+				}
+				CloseTimeout(ws, code, reason, closeTimeout) //nolint:errcheck
+			}()
+			next.ServeHTTP(w, r.WithContext(WithConn(r.Context(), ws)))
+			handled = true
 		})
 	}
 }
+
+// requestedSubprotocols returns the subprotocols requested by the client in the Sec-WebSocket-Protocol header.
+func requestedSubprotocols(r *http.Request) []string {
+	var requested []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, subprotocol := range strings.Split(header, ",") {
+			if subprotocol = strings.TrimSpace(subprotocol); subprotocol != "" {
+				requested = append(requested, subprotocol)
+			}
+		}
+	}
+	return requested
+}
+
+// supportsAny reports whether any of the requested subprotocols is supported.
+func supportsAny(supported, requested []string) bool {
+	for _, s := range supported {
+		for _, r := range requested {
+			if strings.EqualFold(s, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package conn_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+func TestMiddlewareSubprotocols(t *testing.T) {
+	// the handler echoes each message, as read in the native binary protocol.
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := conn.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		ws := c.(*conn.WS)
+		for {
+			frame, err := ws.ReadFrame(r.Context())
+			if err != nil {
+				return
+			}
+			if err := ws.WriteFrame(r.Context(), frame); err != nil {
+				return
+			}
+		}
+	})
+	server := httptest.NewServer(logger.Middleware(zap.NewNop())(conn.Middleware(time.Second, rendezvous.SUBPROTOCOLS...)(echo)))
+	defer server.Close()
+	url := strings.Replace(server.URL, "http", "ws", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("selects the preferred subprotocol", func(t *testing.T) {
+		ws, err := conn.Dial(ctx, url, conn.WithSubprotocols("unknown", rendezvous.SUBPROTOCOL_JSON, rendezvous.SUBPROTOCOL_BINARY))
+		require.NoError(t, err)
+		defer ws.CloseNow() //nolint:errcheck
+		assert.Equal(t, rendezvous.SUBPROTOCOL_BINARY, ws.Subprotocol())
+	})
+
+	t.Run("no subprotocol", func(t *testing.T) {
+		ws, err := conn.Dial(ctx, url)
+		require.NoError(t, err)
+		defer ws.CloseNow() //nolint:errcheck
+		assert.Empty(t, ws.Subprotocol())
+		require.NoError(t, ws.Write(ctx, []byte{0, 1, 2}))
+		b, err := ws.Read(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0, 1, 2}, b)
+	})
+
+	t.Run("json framing", func(t *testing.T) {
+		ws, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{Subprotocols: []string{rendezvous.SUBPROTOCOL_JSON}})
+		require.NoError(t, err)
+		defer ws.CloseNow() //nolint:errcheck
+		assert.Equal(t, rendezvous.SUBPROTOCOL_JSON, ws.Subprotocol())
+
+		for _, payload := range []string{`"AAEC"`, `{"type":1}`} {
+			require.NoError(t, ws.Write(ctx, websocket.MessageText, []byte(payload)))
+			typ, b, err := ws.Read(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, websocket.MessageText, typ)
+			assert.JSONEq(t, payload, string(b))
+		}
+
+		// binary messages are not part of the JSON-framed subprotocol.
+		require.NoError(t, ws.Write(ctx, websocket.MessageBinary, []byte{0, 1, 2}))
+		_, _, err = ws.Read(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects unsupported subprotocols", func(t *testing.T) {
+		_, err := conn.Dial(ctx, url, conn.WithSubprotocols("portal.v2"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprint(http.StatusBadRequest))
+	})
+}
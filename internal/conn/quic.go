@@ -0,0 +1,415 @@
+//go:build !js
+
+package conn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/quic-go/quic-go"
+	"nhooyr.io/websocket"
+)
+
+// ALPN protocols of direct QUIC connections to the sender, the counterparts of the websocket endpoints of its server.
+const (
+	QUIC_TRANSFER_PROTOCOL = "portal-transfer"
+	QUIC_MIGRATE_PROTOCOL  = "portal-migrate"
+)
+
+// QUIC_CLOSE_TIMEOUT is the time closing a QUIC connection waits for the peer to acknowledge the close frame.
+const QUIC_CLOSE_TIMEOUT = 5 * time.Second
+
+const (
+	// maximum size of a message read from a QUIC connection, bounding the memory claimed by peers yet to prove
+	// the session key.
+	quicMessageSizeLimit = 2 * transfer.MAX_CHUNK_BYTES
+	// frames are a type byte and the big-endian uint32 size of the payload, ahead of the payload.
+	quicFrameHeaderBytes = 5
+	quicDataFrame        = 0
+	// the payload of close frames is the big-endian uint16 close code ahead of the close reason.
+	quicCloseFrame = 1
+)
+
+var quicConfig = &quic.Config{KeepAlivePeriod: 15 * time.Second}
+
+// QUIC is a direct connection to the sender over a QUIC stream carrying length-prefixed messages. Like websocket
+// connections, QUIC connections are closed with a close frame acknowledged by the peer, such that messages written
+// before it are delivered, and reads of connections closed by the peer fail with a websocket.CloseError.
+type QUIC struct {
+	conn   *quic.Conn
+	stream *quic.Stream
+
+	writeMu  sync.Mutex
+	msgs     chan []byte   // messages read from the stream
+	readDone chan struct{} // closed once the stream is read to its end
+	closing  chan struct{} // closed once the connection is closed by either peer
+	// the close frame of the peer ending the stream, acknowledged once read, such that writes preceding the read
+	// succeed as with websocket connections.
+	peerClose []byte
+
+	mu       sync.Mutex
+	closed   bool
+	closeErr error // the error of reads and writes once closed
+}
+
+// DialQUIC connects directly to the sender at addr over QUIC, negotiating the provided ALPN protocol. The
+// certificate of the sender is not verified: direct connections are authenticated by the session key, which
+// every message of the transfer is encrypted with.
+func DialQUIC(ctx context.Context, addr, protocol string) (*QUIC, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// a socket of its own per connection, dual-stack such that IPv6 candidates can be dialed.
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: udpConn}
+	release := func() {
+		tr.Close()
+		udpConn.Close()
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{protocol}} //nolint:gosec
+	qc, err := tr.Dial(ctx, udpAddr, tlsConfig, quicConfig)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	go func() {
+		<-qc.Context().Done()
+		release()
+	}()
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		qc.CloseWithError(quic.ApplicationErrorCode(CLOSE_FAILED), "opening stream") //nolint:errcheck
+		return nil, err
+	}
+	return newQUIC(qc, stream), nil
+}
+
+// QUICListener listens for direct QUIC connections. Its socket is released once the listener is closed and the
+// connections it accepted ended, such that closing the listener leaves them to complete their close handshake.
+type QUICListener struct {
+	ln      *quic.Listener
+	tr      *quic.Transport
+	udpConn *net.UDPConn
+
+	mu     sync.Mutex
+	conns  int // accepted connections yet to end
+	closed bool
+}
+
+// ListenQUIC listens for direct QUIC connections on addr negotiating one of the provided ALPN protocols, with an
+// ephemeral self-signed certificate, see DialQUIC.
+func ListenQUIC(addr string, protocols ...string) (*QUICListener, error) {
+	cert, err := ephemeralCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("generating certificate: %w", err)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: udpConn}
+	ln, err := tr.Listen(&tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: protocols}, quicConfig)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	return &QUICListener{ln: ln, tr: tr, udpConn: udpConn}, nil
+}
+
+// Addr returns the address the listener listens on.
+func (l *QUICListener) Addr() net.Addr {
+	return l.udpConn.LocalAddr()
+}
+
+// Accept accepts the next connection, see AcceptQUIC.
+func (l *QUICListener) Accept(ctx context.Context) (*quic.Conn, error) {
+	qc, err := l.ln.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.conns++
+	l.mu.Unlock()
+	go func() {
+		<-qc.Context().Done()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.conns--; l.closed && l.conns == 0 {
+			l.release()
+		}
+	}()
+	return qc, nil
+}
+
+// Close stops accepting connections, the connections accepted already are unaffected.
+func (l *QUICListener) Close() error {
+	err := l.ln.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed && l.conns == 0 {
+		l.release()
+	}
+	l.closed = true
+	return err
+}
+
+func (l *QUICListener) release() {
+	l.tr.Close()
+	l.udpConn.Close()
+}
+
+// AcceptQUIC accepts the stream of a connection accepted by a listener of ListenQUIC, opened by the peer once it
+// writes its first message.
+func AcceptQUIC(ctx context.Context, qc *quic.Conn) (*QUIC, error) {
+	stream, err := qc.AcceptStream(ctx)
+	if err != nil {
+		qc.CloseWithError(quic.ApplicationErrorCode(CLOSE_FAILED), "accepting stream") //nolint:errcheck
+		return nil, err
+	}
+	return newQUIC(qc, stream), nil
+}
+
+func newQUIC(qc *quic.Conn, stream *quic.Stream) *QUIC {
+	q := &QUIC{
+		conn:     qc,
+		stream:   stream,
+		msgs:     make(chan []byte),
+		readDone: make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	go q.readLoop()
+	return q
+}
+
+// Protocol returns the ALPN protocol negotiated for the connection.
+func (q *QUIC) Protocol() string {
+	return q.conn.ConnectionState().TLS.NegotiatedProtocol
+}
+
+func (q *QUIC) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case msg := <-q.msgs:
+		return msg, nil
+	case <-q.readDone:
+		if q.peerClose != nil {
+			q.acknowledgeClose(q.peerClose)
+		}
+		return nil, q.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *QUIC) Write(ctx context.Context, payload []byte) error {
+	select {
+	case <-q.closing:
+		return q.err()
+	default:
+	}
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+	defer q.closeOnDone(ctx)()
+	err := q.writeFrame(quicDataFrame, payload)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Close writes a close frame with the provided code and reason, and closes the connection once the peer
+// acknowledged it or QUIC_CLOSE_TIMEOUT elapsed.
+func (q *QUIC) Close(code websocket.StatusCode, reason string) error {
+	if !q.startClosing(net.ErrClosed) {
+		return net.ErrClosed
+	}
+	defer q.conn.CloseWithError(quic.ApplicationErrorCode(code), reason) //nolint:errcheck
+	if err := q.writeClose(code, reason); err != nil {
+		return err
+	}
+	timer := time.NewTimer(QUIC_CLOSE_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case <-q.readDone:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("peer did not acknowledge close frame within %s", QUIC_CLOSE_TIMEOUT)
+	}
+}
+
+// CloseNow closes the connection without performing the close handshake.
+func (q *QUIC) CloseNow() error {
+	q.startClosing(net.ErrClosed)
+	return q.conn.CloseWithError(quic.ApplicationErrorCode(CLOSE_CANCELED), "closed without close handshake")
+}
+
+// readLoop reads the messages of the stream until it ends. Messages read once the connection is closing are
+// discarded, and close frames of the peer are kept to be acknowledged once read, see Read.
+func (q *QUIC) readLoop() {
+	defer close(q.readDone)
+	for {
+		typ, payload, err := q.readFrame()
+		if err != nil {
+			var appErr *quic.ApplicationError
+			if errors.As(err, &appErr) {
+				err = websocket.CloseError{Code: websocket.StatusCode(appErr.ErrorCode), Reason: appErr.ErrorMessage}
+			}
+			q.startClosing(err)
+			q.conn.CloseWithError(quic.ApplicationErrorCode(CLOSE_FAILED), rendezvous.TRANSFER_FAILED) //nolint:errcheck
+			return
+		}
+		if typ == quicCloseFrame {
+			q.peerClose = payload
+			return
+		}
+		select {
+		case q.msgs <- payload:
+		case <-q.closing:
+		}
+	}
+}
+
+// acknowledgeClose acknowledges the close frame of a peer closing the connection, unless closing the connection
+// already. The peer closes the connection once acknowledged, or else it is closed after QUIC_CLOSE_TIMEOUT.
+func (q *QUIC) acknowledgeClose(payload []byte) {
+	closeErr := websocket.CloseError{Code: websocket.StatusNoStatusRcvd}
+	if len(payload) >= 2 {
+		closeErr = websocket.CloseError{Code: websocket.StatusCode(binary.BigEndian.Uint16(payload)), Reason: string(payload[2:])}
+	}
+	if !q.startClosing(closeErr) {
+		return
+	}
+	q.writeClose(closeErr.Code, closeErr.Reason) //nolint:errcheck
+	go func() {
+		timer := time.NewTimer(QUIC_CLOSE_TIMEOUT)
+		defer timer.Stop()
+		select {
+		case <-q.conn.Context().Done():
+		case <-timer.C:
+		}
+		q.conn.CloseWithError(quic.ApplicationErrorCode(closeErr.Code), closeErr.Reason) //nolint:errcheck
+	}()
+}
+
+// startClosing marks the connection closed with err, reporting whether it was not closed already.
+func (q *QUIC) startClosing(err error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed, q.closeErr = true, err
+	close(q.closing)
+	return true
+}
+
+func (q *QUIC) err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closeErr
+}
+
+// writeClose writes a close frame and ends the stream, waiting at most QUIC_CLOSE_TIMEOUT for the peer to read it.
+func (q *QUIC) writeClose(code websocket.StatusCode, reason string) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+	q.stream.SetWriteDeadline(time.Now().Add(QUIC_CLOSE_TIMEOUT)) //nolint:errcheck
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if err := q.writeFrame(quicCloseFrame, append(payload, reason...)); err != nil {
+		return err
+	}
+	return q.stream.Close()
+}
+
+func (q *QUIC) writeFrame(typ byte, payload []byte) error {
+	header := make([]byte, quicFrameHeaderBytes)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := q.stream.Write(header); err != nil {
+		return err
+	}
+	_, err := q.stream.Write(payload)
+	return err
+}
+
+func (q *QUIC) readFrame() (byte, []byte, error) {
+	header := make([]byte, quicFrameHeaderBytes)
+	if _, err := io.ReadFull(q.stream, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > quicMessageSizeLimit {
+		return 0, nil, fmt.Errorf("message of %d bytes exceeds the limit of %d bytes", size, int64(quicMessageSizeLimit))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(q.stream, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// closeOnDone closes the connection with a close code describing the context error once the context is done,
+// until the returned function is called, as an interrupted write leaves a partial message on the stream.
+func (q *QUIC) closeOnDone(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			code, reason := CloseStatus(ctx.Err())
+			q.startClosing(ctx.Err())
+			q.conn.CloseWithError(quic.ApplicationErrorCode(code), reason) //nolint:errcheck
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// ephemeralCertificate returns a self-signed certificate for QUIC listeners, valid for a day.
+func ephemeralCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
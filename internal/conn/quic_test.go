@@ -0,0 +1,77 @@
+//go:build !js
+
+package conn_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestQUIC(t *testing.T) {
+	ln, err := conn.ListenQUIC("127.0.0.1:0", conn.QUIC_TRANSFER_PROTOCOL)
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", ln.Addr().(*net.UDPAddr).Port)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan *conn.QUIC, 1)
+	go func() {
+		qc, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+		q, err := conn.AcceptQUIC(ctx, qc)
+		if err != nil {
+			return
+		}
+		accepted <- q
+	}()
+
+	client, err := conn.DialQUIC(ctx, addr, conn.QUIC_TRANSFER_PROTOCOL)
+	require.NoError(t, err)
+	payload := make([]byte, 1e6)
+	closed := make(chan error, 1)
+	go func() {
+		// messages written before the close frame are delivered.
+		if err := client.Write(ctx, []byte("hello")); err != nil {
+			closed <- err
+			return
+		}
+		if err := client.Write(ctx, payload); err != nil {
+			closed <- err
+			return
+		}
+		closed <- client.Close(conn.CLOSE_COMPLETED, "transfer completed")
+	}()
+	server := <-accepted
+	assert.Equal(t, conn.QUIC_TRANSFER_PROTOCOL, server.Protocol())
+
+	b, err := server.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	b, err = server.Read(ctx)
+	require.NoError(t, err)
+	assert.Len(t, b, len(payload))
+
+	_, err = server.Read(ctx)
+	var closeErr websocket.CloseError
+	require.True(t, errors.As(err, &closeErr), "reads of connections closed by the peer fail with a close error")
+	assert.Equal(t, websocket.CloseError{Code: conn.CLOSE_COMPLETED, Reason: "transfer completed"}, closeErr)
+	assert.Error(t, server.Write(ctx, []byte("late")))
+	assert.NoError(t, <-closed, "the close frame is acknowledged")
+
+	t.Run("wrong protocol", func(t *testing.T) {
+		_, err := conn.DialQUIC(ctx, addr, conn.QUIC_MIGRATE_PROTOCOL)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,142 @@
+package conn
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SEAL_CHUNK_SIZE is the size of the chunks sealed streams are sealed in, every chunk is authenticated on its own.
+const SEAL_CHUNK_SIZE = 64 << 10
+
+// SEAL_KEY_BYTES is the size of the keys streams are sealed with.
+const SEAL_KEY_BYTES = 32
+
+// a frame of a sealed stream is a header of whether it is the final frame and the size of its sealed chunk,
+// followed by the chunk sealed with AES-GCM. The header is authenticated along with the chunk.
+const (
+	sealHeaderSize = 5
+	sealOverhead   = sealHeaderSize + 16
+	sealFinal      = 1
+)
+
+// ErrTruncated is returned when a sealed stream ends before its final frame.
+var ErrTruncated = errors.New("sealed stream truncated")
+
+// SealedSize returns the size of a stream of n bytes once sealed.
+func SealedSize(n int64) int64 {
+	frames := (n + SEAL_CHUNK_SIZE - 1) / SEAL_CHUNK_SIZE
+	if frames == 0 {
+		frames = 1
+	}
+	return n + frames*sealOverhead
+}
+
+// Seal writes src to dst sealed with the provided key, such that the stream is read by Open with the key only,
+// and any modification, reordering or truncation of it is detected. Every key seals a single stream.
+func Seal(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(src, SEAL_CHUNK_SIZE)
+	chunk := make([]byte, SEAL_CHUNK_SIZE)
+	frame := make([]byte, 0, SEAL_CHUNK_SIZE+sealOverhead)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		// a full chunk is final if nothing follows it.
+		final := err != nil
+		if !final {
+			if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+		frame = appendSealHeader(frame[:0], final, n+aead.Overhead())
+		frame = aead.Seal(frame, sealNonce(counter), chunk[:n], frame[:sealHeaderSize])
+		if _, err := dst.Write(frame); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// Open writes the stream sealed by Seal read from src to dst, opened with the provided key. Returns
+// ErrAuthentication if the stream was not sealed with the key or was tampered with, and ErrTruncated if it ends
+// before its final frame. Chunks are written once authenticated, a failed stream leaves dst partially written.
+func Open(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	header := make([]byte, sealHeaderSize)
+	sealed := make([]byte, SEAL_CHUNK_SIZE+aead.Overhead())
+	var chunk []byte
+	for counter := uint64(0); ; counter++ {
+		if _, err := io.ReadFull(r, header); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		} else if err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size < uint32(aead.Overhead()) || size > uint32(len(sealed)) {
+			return ErrAuthentication
+		}
+		if _, err := io.ReadFull(r, sealed[:size]); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		} else if err != nil {
+			return err
+		}
+		if chunk, err = aead.Open(chunk[:0], sealNonce(counter), sealed[:size], header); err != nil {
+			return ErrAuthentication
+		}
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if header[0] == sealFinal {
+			if _, err := r.Peek(1); !errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: data after the final frame", ErrAuthentication)
+			}
+			return nil
+		}
+	}
+}
+
+func newSealAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != SEAL_KEY_BYTES {
+		return nil, fmt.Errorf("invalid key of %d bytes, expected %d", len(key), SEAL_KEY_BYTES)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealNonce returns the nonce of the frame at the provided position in the stream, unique as keys seal a single
+// stream.
+func sealNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func appendSealHeader(b []byte, final bool, size int) []byte {
+	flag := byte(0)
+	if final {
+		flag = sealFinal
+	}
+	b = append(b, flag)
+	return binary.BigEndian.AppendUint32(b, uint32(size))
+}
@@ -0,0 +1,58 @@
+package conn_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeal(t *testing.T) {
+	key := make([]byte, conn.SEAL_KEY_BYTES)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	seal := func(t *testing.T, payload []byte) []byte {
+		var sealed bytes.Buffer
+		require.NoError(t, conn.Seal(&sealed, bytes.NewReader(payload), key))
+		return sealed.Bytes()
+	}
+
+	for _, size := range []int{0, 1, conn.SEAL_CHUNK_SIZE, conn.SEAL_CHUNK_SIZE + 1, 3*conn.SEAL_CHUNK_SIZE - 7} {
+		t.Run(fmt.Sprintf("round trip of %d bytes", size), func(t *testing.T) {
+			payload := make([]byte, size)
+			_, err := rand.Read(payload)
+			require.NoError(t, err)
+			sealed := seal(t, payload)
+			assert.Len(t, sealed, int(conn.SealedSize(int64(size))))
+
+			var opened bytes.Buffer
+			require.NoError(t, conn.Open(&opened, bytes.NewReader(sealed), key))
+			assert.Equal(t, payload, append([]byte{}, opened.Bytes()...))
+		})
+	}
+
+	payload := bytes.Repeat([]byte("sealed "), conn.SEAL_CHUNK_SIZE/3)
+	sealed := seal(t, payload)
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[len(tampered)/2] ^= 1
+		assert.ErrorIs(t, conn.Open(&bytes.Buffer{}, bytes.NewReader(tampered), key), conn.ErrAuthentication)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// cut at the end of the first frame, such that only the final frame is missing.
+		first := 5 + conn.SEAL_CHUNK_SIZE + 16
+		assert.ErrorIs(t, conn.Open(&bytes.Buffer{}, bytes.NewReader(sealed[:first]), key), conn.ErrTruncated)
+		assert.ErrorIs(t, conn.Open(&bytes.Buffer{}, bytes.NewReader(sealed[:len(sealed)-1]), key), conn.ErrTruncated)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other := make([]byte, conn.SEAL_KEY_BYTES)
+		assert.ErrorIs(t, conn.Open(&bytes.Buffer{}, bytes.NewReader(sealed), other), conn.ErrAuthentication)
+	})
+}
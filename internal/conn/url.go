@@ -0,0 +1,47 @@
+package conn
+
+import (
+	"strings"
+)
+
+// Schemes addresses are prefixed with to be reached over TLS, e.g. wss://myrelay.io. Addresses without a scheme,
+// or prefixed with ws:// or http://, are reached without TLS.
+var (
+	tlsSchemes   = []string{"wss://", "https://"}
+	plainSchemes = []string{"ws://", "http://"}
+)
+
+// SplitScheme splits addr into its host, with an optional port, and whether it is reached over TLS.
+func SplitScheme(addr string) (host string, secure bool) {
+	for _, scheme := range tlsSchemes {
+		if len(addr) >= len(scheme) && strings.EqualFold(addr[:len(scheme)], scheme) {
+			return addr[len(scheme):], true
+		}
+	}
+	for _, scheme := range plainSchemes {
+		if len(addr) >= len(scheme) && strings.EqualFold(addr[:len(scheme)], scheme) {
+			return addr[len(scheme):], false
+		}
+	}
+	return addr, false
+}
+
+// WebsocketURL returns the url of the websocket endpoint at path on the server at addr, wss:// if the server is
+// reached over TLS, see SplitScheme.
+func WebsocketURL(addr, path string) string {
+	host, secure := SplitScheme(addr)
+	if secure {
+		return "wss://" + host + path
+	}
+	return "ws://" + host + path
+}
+
+// HTTPURL returns the url of the HTTP endpoint at path on the server at addr, https:// if the server is reached
+// over TLS, see SplitScheme.
+func HTTPURL(addr, path string) string {
+	host, secure := SplitScheme(addr)
+	if secure {
+		return "https://" + host + path
+	}
+	return "http://" + host + path
+}
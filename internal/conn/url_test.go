@@ -0,0 +1,28 @@
+package conn_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		addr      string
+		websocket string
+		http      string
+	}{
+		{addr: "myrelay.io:8080", websocket: "ws://myrelay.io:8080/ping", http: "http://myrelay.io:8080/ping"},
+		{addr: "ws://myrelay.io", websocket: "ws://myrelay.io/ping", http: "http://myrelay.io/ping"},
+		{addr: "http://[::1]:8080", websocket: "ws://[::1]:8080/ping", http: "http://[::1]:8080/ping"},
+		{addr: "wss://myrelay.io", websocket: "wss://myrelay.io/ping", http: "https://myrelay.io/ping"},
+		{addr: "HTTPS://myrelay.io:8443", websocket: "wss://myrelay.io:8443/ping", http: "https://myrelay.io:8443/ping"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			assert.Equal(t, tc.websocket, conn.WebsocketURL(tc.addr, "/ping"))
+			assert.Equal(t, tc.http, conn.HTTPURL(tc.addr, "/ping"))
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// number of bytes of the archive sampled to estimate its compressibility.
+const COMPRESSION_SAMPLE_SIZE = 256 * 1024
+
+// DEFAULT_COMPRESSION_THRESHOLD is the compressed-to-original size ratio of the sample above which
+// compression is skipped for the rest of the archive.
+const DEFAULT_COMPRESSION_THRESHOLD = 0.9
+
+// CompressionResult describes the compression decision made while packing files.
+type CompressionResult struct {
+	// Ratio is the compressed-to-original size ratio of the sampled data.
+	Ratio float64
+	// Skipped reports whether compression was skipped as the sampled data was incompressible.
+	Skipped bool
+	// Codec is the compression codec of the archive.
+	Codec string
+	// Stored is the number of already compressed files stored rather than compressed again, see IsCompressed.
+	Stored int
+}
+
+// compressedExtensions are the extensions of file types that are already compressed, and are not compressed again.
+var compressedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".br": true, ".lz4": true,
+	".7z": true, ".rar": true, ".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".epub": true,
+}
+
+// IsCompressed reports whether the named file is of an already compressed type, by its extension.
+func IsCompressed(name string) bool {
+	return compressedExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// samplingWriter buffers the first chunk of the archive to estimate its compressibility,
+// before creating the compressor with a compression level suitable for the data.
+type samplingWriter struct {
+	w         io.Writer
+	threshold float64
+	result    CompressionResult
+
+	sample  bytes.Buffer
+	gw      io.WriteCloser
+	storing bool // storing defines whether the data is currently stored uncompressed
+}
+
+func newSamplingWriter(w io.Writer, codec string, threshold float64) *samplingWriter {
+	return &samplingWriter{w: w, threshold: threshold, result: CompressionResult{Codec: codec}}
+}
+
+func (s *samplingWriter) Write(b []byte) (int, error) {
+	if s.gw != nil {
+		return s.gw.Write(b)
+	}
+	n := len(b)
+	if room := COMPRESSION_SAMPLE_SIZE - s.sample.Len(); len(b) > room {
+		s.sample.Write(b[:room])
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+		if _, err := s.gw.Write(b[room:]); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	s.sample.Write(b)
+	return n, nil
+}
+
+// Close decides on the compression of archives smaller than the sample, and closes the compressor.
+func (s *samplingWriter) Close() error {
+	if s.gw == nil {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	return s.gw.Close()
+}
+
+// start estimates the compressibility of the sample, creates the compressor and flushes the sample to it.
+func (s *samplingWriter) start() error {
+	// uncompressed archives are not sampled.
+	if s.sample.Len() > 0 && s.result.Codec != transfer.CODEC_NONE {
+		s.result.Ratio = compressionRatio(s.sample.Bytes())
+		if s.threshold < 1 && s.result.Ratio > s.threshold {
+			s.result.Skipped = true
+		}
+	}
+	gw, err := newCompressor(s.w, s.result.Codec, !s.result.Skipped)
+	if err != nil {
+		return err
+	}
+	s.gw = gw
+	_, err = s.gw.Write(s.sample.Bytes())
+	s.sample = bytes.Buffer{}
+	return err
+}
+
+// store stores the data written next uncompressed if set, e.g. the contents of an already compressed file, in a
+// gzip member or zstd frame of its own that receivers decompress as part of the stream. Brotli streams cannot be
+// concatenated, and are compressed as decided by sampling. A threshold of 1 compresses every file.
+func (s *samplingWriter) store(store bool) error {
+	if s == nil || store == s.storing || s.threshold >= 1 ||
+		(s.result.Codec != transfer.CODEC_GZIP && s.result.Codec != transfer.CODEC_ZSTD) {
+		return nil
+	}
+	// the compression of the data sampled so far is decided before storing files.
+	if s.gw == nil {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if err := s.gw.Close(); err != nil {
+		return err
+	}
+	s.storing = store
+	if store {
+		s.result.Stored++
+	}
+	gw, err := newCompressor(s.w, s.result.Codec, !store && !s.result.Skipped)
+	if err != nil {
+		return err
+	}
+	s.gw = gw
+	return nil
+}
+
+// compressionRatio returns the compressed-to-original size ratio of the provided data.
+func compressionRatio(data []byte) float64 {
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	fw.Write(data) //nolint:errcheck
+	fw.Close()
+	return float64(buf.Len()) / float64(len(data))
+}
+
+// newCompressor creates a compressor of the provided codec. When compress is false, the data is stored
+// uncompressed, or compressed at the fastest level for codecs without a store mode.
+func newCompressor(w io.Writer, codec string, compress bool) (io.WriteCloser, error) {
+	switch codec {
+	case transfer.CODEC_GZIP:
+		level := pgzip.DefaultCompression
+		if !compress {
+			level = pgzip.NoCompression
+		}
+		return pgzip.NewWriterLevel(w, level)
+	case transfer.CODEC_ZSTD:
+		level := zstd.SpeedDefault
+		if !compress {
+			level = zstd.SpeedFastest
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case transfer.CODEC_BROTLI:
+		level := brotli.DefaultCompression
+		if !compress {
+			level = brotli.BestSpeed
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	case transfer.CODEC_NONE:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, transfer.ValidateCodec(codec)
+	}
+}
+
+// nopWriteCloser stores the archive uncompressed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// magic numbers of the codecs that are self-describing, brotli streams have none.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// uncompressed archives are detected by the magic of the first tar header, at tarMagicOffset.
+var tarMagic = []byte("ustar")
+
+const tarMagicOffset = 257
+
+// newDecompressor detects the codec of the compressed stream and creates a decompressor for it.
+// Streams without the magic number of gzip, zstd or an uncompressed tar archive are decompressed as brotli.
+func newDecompressor(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("detecting compression codec: %w", err)
+	}
+	switch {
+	case len(magic) > tarMagicOffset && bytes.HasPrefix(magic[tarMagicOffset:], tarMagic):
+		return io.NopCloser(br), nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return pgzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(brotli.NewReader(br)), nil
+	}
+}
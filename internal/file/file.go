@@ -3,19 +3,46 @@ package file
 import (
 	"archive/tar"
 	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/klauspost/pgzip"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"golang.org/x/exp/slices"
 )
 
 const SEND_TEMP_FILE_NAME_PREFIX = "portal-send-temp"
 const RECEIVE_TEMP_FILE_NAME_PREFIX = "portal-receive-temp"
 
+// PARTIAL_FILE_SUFFIX is appended to the name of files while they are being written, such that
+// an incomplete file is never mistaken for a complete one.
+const PARTIAL_FILE_SUFFIX = ".portal-partial"
+
+// ARCHIVE_NAME is the name archives are saved as, rather than extracted, unless they contain a single directory.
+const ARCHIVE_NAME = "archive.tar"
+
+// archivePAXRecord marks the objects of archives the sender asks to be saved by the receiver rather than extracted.
+const archivePAXRecord = "PORTAL.archive"
+
+// TEXT_NAME is the name text messages are written as when the receiver saves them rather than displaying them.
+const TEXT_NAME = "message.txt"
+
+// MAX_TEXT_SIZE is the maximum size in bytes of a text message, larger texts are sent as files.
+const MAX_TEXT_SIZE = 1 << 20
+
+// textPAXRecord marks archives holding a text message, displayed by the receiver rather than written to disk.
+const textPAXRecord = "PORTAL.text"
+
+// ErrTextTooLarge is returned when packing or reading a text message larger than MAX_TEXT_SIZE.
+var ErrTextTooLarge = fmt.Errorf("text message larger than %d bytes", MAX_TEXT_SIZE)
+
 // ----------------------------------------------------- Pack Files ----------------------------------------------------
 
 func ReadFiles(fileNames []string) ([]*os.File, error) {
@@ -30,27 +57,350 @@ func ReadFiles(fileNames []string) ([]*os.File, error) {
 	return files, nil
 }
 
-// PackFiles tars and gzip-compresses files into a temporary file, returning it
-// along with the resulting size
-func PackFiles(files []*os.File) (*os.File, int64, error) {
+// PackOption configures how files are packed by PackFiles.
+type PackOption func(*packOptions)
+
+type packOptions struct {
+	modifiedSince time.Time
+	relativePaths bool
+	excludes      []string
+	rename        string
+	codec         string
+	threshold     float64
+	result        *CompressionResult
+	maxFiles      int
+	archive       bool
+	resume        *transfer.Resume
+	signingKey    ed25519.PrivateKey
+	selected      map[string]bool // names of the files selected by the receiver, nil to pack every file
+	manifest      *[]transfer.ManifestFile
+	dirsAsZip     bool
+	formats       *[]string
+	xattrs        bool
+	zipSymlinks   bool // symlinks within the sent directory are packed as symlinks, for directories packed as zip
+
+	files   int             // number of regular files packed so far
+	sized   *int64          // size of the archive counted rather than written, if set
+	sampler *samplingWriter // compressor of the archive, storing already compressed files
+}
+
+// ErrTooManyFiles is returned when packing more files than the limit set by WithMaxFiles.
+var ErrTooManyFiles = errors.New("too many files")
+
+// WithModifiedSince only packs regular files modified after the provided time.
+// Directories are always packed, such that the structure of the archive is preserved.
+func WithModifiedSince(t time.Time) PackOption {
+	return func(o *packOptions) {
+		o.modifiedSince = t
+	}
+}
+
+// WithRelativePaths packs files with their path relative to the working directory, preserving
+// the directory structure of the provided paths. Paths outside the working directory are rejected.
+func WithRelativePaths() PackOption {
+	return func(o *packOptions) {
+		o.relativePaths = true
+	}
+}
+
+// WithExcludes excludes files and directories matching the provided gitignore-style patterns.
+// Patterns are matched against paths relative to the sent directory, and are evaluated after the
+// patterns of the directory's .portalignore file, taking precedence over them.
+func WithExcludes(patterns ...string) PackOption {
+	return func(o *packOptions) {
+		o.excludes = append(o.excludes, patterns...)
+	}
+}
+
+// WithRename packs a single file under the provided name, instead of its original filename.
+func WithRename(name string) PackOption {
+	return func(o *packOptions) {
+		o.rename = name
+	}
+}
+
+// WithCodec compresses the archive using the provided codec, one of transfer.Codecs. Defaults to transfer.CODEC_GZIP.
+func WithCodec(codec string) PackOption {
+	return func(o *packOptions) {
+		o.codec = codec
+	}
+}
+
+// WithCodecOf compresses the archive using the codec c points to when packing, e.g. the codec negotiated with the
+// receiver for archives repacked during the transfer.
+func WithCodecOf(c *string) PackOption {
+	return func(o *packOptions) {
+		o.codec = *c
+	}
+}
+
+// WithCompressionThreshold skips compression when the first chunk of the archive compresses worse than
+// the provided compressed-to-original size ratio. A threshold of 1 or more always compresses.
+func WithCompressionThreshold(ratio float64) PackOption {
+	return func(o *packOptions) {
+		o.threshold = ratio
+	}
+}
+
+// WithMaxFiles fails packing with ErrTooManyFiles once more than max regular files would be packed,
+// guarding against sending an unexpectedly large directory tree. A non-positive max packs any number of files.
+func WithMaxFiles(max int) PackOption {
+	return func(o *packOptions) {
+		o.maxFiles = max
+	}
+}
+
+// WithArchive marks the archive to be saved as is by the receiver, rather than extracted, see ExtractAuto.
+func WithArchive() PackOption {
+	return func(o *packOptions) {
+		o.archive = true
+	}
+}
+
+// WithSelected only packs the regular files of the provided names, as listed by WithManifest, and the
+// directories holding them. Used to repack the files selected by the receiver.
+func WithSelected(names ...string) PackOption {
+	return func(o *packOptions) {
+		o.selected = make(map[string]bool, len(names))
+		for _, name := range names {
+			o.selected[name] = true
+		}
+	}
+}
+
+// WithManifest stores the regular files packed, and their size, in the provided manifest once the files are packed.
+func WithManifest(m *[]transfer.ManifestFile) PackOption {
+	return func(o *packOptions) {
+		o.manifest = m
+	}
+}
+
+// WithFormats stores the packaging formats of the packed objects besides tar, see transfer.Formats, in the provided
+// formats once the files are packed.
+func WithFormats(f *[]string) PackOption {
+	return func(o *packOptions) {
+		o.formats = f
+	}
+}
+
+// selects reports whether the object of the provided name is packed with the selected files.
+func (o *packOptions) selects(name string, dir bool) bool {
+	if !dir {
+		return o.selected[name]
+	}
+	for selected := range o.selected {
+		if strings.HasPrefix(selected, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WithCompressionResult stores the compression decision in the provided result once the files are packed.
+func WithCompressionResult(r *CompressionResult) PackOption {
+	return func(o *packOptions) {
+		o.result = r
+	}
+}
+
+// ValidateName checks that the provided filename is a plain filename that cannot be used for path traversal.
+func ValidateName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("invalid filename '%s'", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("invalid filename '%s', must not contain path separators", name)
+	}
+	return nil
+}
+
+// PackFiles tars and compresses files into a temporary file, returning it
+// along with the resulting size. Compression is skipped if the data is incompressible.
+func PackFiles(files []*os.File, opts ...PackOption) (*os.File, int64, error) {
+	o := packOptions{codec: transfer.CODEC_GZIP, threshold: DEFAULT_COMPRESSION_THRESHOLD}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := transfer.ValidateCodec(o.codec); err != nil {
+		return nil, 0, err
+	}
+	if o.rename != "" {
+		if err := validateRename(files, o.rename); err != nil {
+			return nil, 0, err
+		}
+	}
+	return packArchive(&o, func(tw *tar.Writer) error {
+		for _, file := range files {
+			if err := addToTarArchive(tw, file, &o); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountFiles counts the regular files PackFiles would pack with the provided options without packing them, failing
+// with ErrTooManyFiles once more files than the limit of WithMaxFiles would be packed, such that the limit is
+// enforced before anything is packed or sent.
+func CountFiles(files []*os.File, opts ...PackOption) (int, error) {
+	var o packOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// the files are only walked, neither read, signed nor zipped.
+	var size int64
+	counting := packOptions{modifiedSince: o.modifiedSince, relativePaths: o.relativePaths, excludes: o.excludes, maxFiles: o.maxFiles, sized: &size}
+	for _, file := range files {
+		if err := addToTarArchive(nil, file, &counting); err != nil {
+			return counting.files, err
+		}
+	}
+	return counting.files, nil
+}
+
+// ErrStreamChanged is returned when the files streamed by PackFilesStream changed since their size was counted.
+var ErrStreamChanged = errors.New("files changed while streaming")
+
+// PackFilesStream tars files like PackFiles, but streams the archive as it is read rather than packing it into a
+// temporary file first. The archive is not compressed, such that its size is counted up front from the headers
+// and file sizes. Directories packed as zip are not streamed, as they are zipped into temporary files.
+func PackFilesStream(files []*os.File, opts ...PackOption) (io.ReadCloser, int64, error) {
+	o := packOptions{threshold: DEFAULT_COMPRESSION_THRESHOLD}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.codec = transfer.CODEC_NONE
+	if o.dirsAsZip {
+		return nil, 0, errors.New("directories packed as zip cannot be streamed")
+	}
+	if o.rename != "" {
+		if err := validateRename(files, o.rename); err != nil {
+			return nil, 0, err
+		}
+	}
+	// the manifest and formats are collected while counting, such that they are known before streaming.
+	var size int64
+	counting := o
+	counting.sized = &size
+	for _, file := range files {
+		if err := addToTarArchive(nil, file, &counting); err != nil {
+			return nil, 0, err
+		}
+	}
+	size += 2 * tarBlockSize
+	if o.result != nil {
+		*o.result = CompressionResult{Codec: o.codec}
+	}
+	o.manifest, o.formats, o.result = nil, nil, nil
+
+	pr, pw := io.Pipe()
+	go func() {
+		cw := &countingWriter{w: pw}
+		tw := tar.NewWriter(cw)
+		err := func() error {
+			for _, file := range files {
+				if err := addToTarArchive(tw, file, &o); err != nil {
+					return err
+				}
+			}
+			return tw.Close()
+		}()
+		if err == nil && cw.n != size {
+			err = fmt.Errorf("%w: counted %d bytes, streamed %d bytes", ErrStreamChanged, size, cw.n)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, size, nil
+}
+
+// tarBlockSize is the size of the blocks of tar archives, the headers and contents of objects are padded to.
+const tarBlockSize = 512
+
+// tarSize counts the size of the header in a tar archive, along with the contents padded to tarBlockSize.
+func tarSize(header *tar.Header) (int64, error) {
+	var cw countingWriter
+	if err := tar.NewWriter(&cw).WriteHeader(header); err != nil {
+		return 0, err
+	}
+	contents := (header.Size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+	return cw.n + contents, nil
+}
+
+// countingWriter counts the bytes written to w, discarding them if w is nil.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.w == nil {
+		c.n += int64(len(b))
+		return len(b), nil
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// PackText packs the text as a message, displayed by the receiver rather than written to disk, into a temporary
+// file, returning it along with the resulting size. Only the codec, compression and signature options apply to
+// messages.
+func PackText(text string, opts ...PackOption) (*os.File, int64, error) {
+	o := packOptions{codec: transfer.CODEC_GZIP, threshold: DEFAULT_COMPRESSION_THRESHOLD}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := transfer.ValidateCodec(o.codec); err != nil {
+		return nil, 0, err
+	}
+	if len(text) > MAX_TEXT_SIZE {
+		return nil, 0, ErrTextTooLarge
+	}
+	return packArchive(&o, func(tw *tar.Writer) error {
+		header := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       TEXT_NAME,
+			Mode:       0644,
+			Size:       int64(len(text)),
+			ModTime:    time.Now(),
+			PAXRecords: map[string]string{textPAXRecord: "1"},
+		}
+		if o.signingKey != nil {
+			digest := sha256.Sum256([]byte(text))
+			header.PAXRecords[signaturePAXRecord] = sign(o.signingKey, TEXT_NAME, digest[:])
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, text)
+		return err
+	})
+}
+
+// packArchive writes the objects written by write to a compressed tar archive in a temporary file, returning it
+// rewound along with the resulting size.
+func packArchive(o *packOptions, write func(tw *tar.Writer) error) (*os.File, int64, error) {
 	// chained writers -> writing to tw writes to gw -> writes to temporary file
 	tempFile, err := os.CreateTemp(os.TempDir(), SEND_TEMP_FILE_NAME_PREFIX)
 	if err != nil {
 		return nil, 0, err
 	}
 	tempFileWriter := bufio.NewWriter(tempFile)
-	gw := pgzip.NewWriter(tempFileWriter)
+	gw := newSamplingWriter(tempFileWriter, o.codec, o.threshold)
+	o.sampler = gw
 	tw := tar.NewWriter(gw)
 
-	for _, file := range files {
-		err := addToTarArchive(tw, file)
-		if err != nil {
-			return nil, 0, err
-		}
+	if err := write(tw); err != nil {
+		return nil, 0, err
 	}
 	tw.Close()
-	gw.Close()
+	if err := gw.Close(); err != nil {
+		return nil, 0, err
+	}
 	tempFileWriter.Flush()
+	if o.result != nil {
+		*o.result = gw.result
+	}
 	fileInfo, err := tempFile.Stat()
 	if err != nil {
 		return nil, 0, err
@@ -69,19 +419,75 @@ var ErrUnpackNoHeader = errors.New("no header in tar archive")
 var ErrUnpackFileExists = errors.New("file exists")
 var ErrUninitialized = errors.New("unpacker is uninitialized")
 
+// ErrOwnershipSkipped is reported when the ownership of a file cannot be preserved, due to
+// lacking privileges or the platform not supporting file ownership.
+var ErrOwnershipSkipped = errors.New("ownership not preserved")
+
+var errOwnershipUnsupported = errors.New("file ownership is not supported on this platform")
+
 // Unpacker defines an encapsulated unit for unpacking a compressed
 // tar archive
 type Unpacker struct {
-	prompt bool // prompt defines whether we should prompt the user to overwrite files
-	cwd    string
+	collision   Collision // collision defines how files colliding with existing files are unpacked
+	keepPartial bool      // keepPartial defines whether incomplete files are kept on failure
+	cwd         string
+	rename      string // rename defines the name the single file of the archive is written as
+	archive     bool   // archive defines whether the archive is saved as a single file named rename
+	saved       bool   // saved defines whether the archive has been resolved for saving
+
+	preserveOwnership bool        // preserveOwnership defines whether the uid/gid of the archive are applied
+	onOwnershipSkip   func(error) // onOwnershipSkip is called when ownership cannot be preserved
+	preserveXattrs    bool        // preserveXattrs defines whether the extended attributes of the archive are restored
+	onXattrSkip       func(error) // onXattrSkip is called when an extended attribute cannot be restored
+	xattrNamespaces   []string    // xattrNamespaces are the prefixes of the names of the restored extended attributes
 
-	gr *pgzip.Reader
+	resume       *transfer.Resume  // resume records the progress of the transfer, if resumable
+	verifyingKey ed25519.PublicKey // verifyingKey verifies the signatures of the received files, if set
+	extractZips  bool              // extractZips defines whether directories packed as zip are extracted
+
+	gr io.ReadCloser
 	tr *tar.Reader
 	r  io.ReadCloser
 }
 
-func NewUnpacker(prompt bool, r io.ReadCloser) (*Unpacker, error) {
-	gr, err := pgzip.NewReader(r)
+// UnpackOption configures an Unpacker.
+type UnpackOption func(*Unpacker)
+
+// WithKeepPartial keeps the partially written file, suffixed with PARTIAL_FILE_SUFFIX, when committing it fails.
+// By default the partial file is removed.
+func WithKeepPartial(keep bool) UnpackOption {
+	return func(u *Unpacker) {
+		u.keepPartial = keep
+	}
+}
+
+// WithOutput unpacks the archive to the provided output target, resolved with ResolveOutput.
+// By default the archive is unpacked into the current working directory.
+func WithOutput(target OutputTarget) UnpackOption {
+	return func(u *Unpacker) {
+		if target.Dir != "" {
+			u.cwd = target.Dir
+		}
+		u.rename = target.Name
+		u.archive = target.Archive
+		u.extractZips = target.ExtractZips
+	}
+}
+
+// WithPreserveOwnership applies the uid/gid carried in the archive to the unpacked files. When ownership
+// cannot be preserved the file is kept with the ownership of the current user, and warn is called with
+// a ErrOwnershipSkipped, if provided.
+func WithPreserveOwnership(warn func(error)) UnpackOption {
+	return func(u *Unpacker) {
+		u.preserveOwnership = true
+		u.onOwnershipSkip = warn
+	}
+}
+
+// NewUnpacker creates an unpacker of the compressed tar archive read from r, detecting its compression codec.
+// Existing files are overwritten once prompted if prompt is set, and without prompting otherwise, see WithCollision.
+func NewUnpacker(prompt bool, r io.ReadCloser, opts ...UnpackOption) (*Unpacker, error) {
+	gr, err := newDecompressor(r)
 	if err != nil {
 		return nil, err
 	}
@@ -91,13 +497,20 @@ func NewUnpacker(prompt bool, r io.ReadCloser) (*Unpacker, error) {
 	}
 	tr := tar.NewReader(gr)
 
-	return &Unpacker{
-		prompt: prompt,
-		cwd:    cwd,
-		gr:     gr,
-		tr:     tr,
-		r:      r,
-	}, nil
+	u := &Unpacker{
+		collision: CollisionOverwrite,
+		cwd:       cwd,
+		gr:        gr,
+		tr:        tr,
+		r:         r,
+	}
+	if prompt {
+		u.collision = CollisionPrompt
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u, nil
 }
 
 // Close closes all underlying readers of the unpacker.
@@ -117,12 +530,16 @@ func (u *Unpacker) Close() error {
 
 // Unpack will decompress and unpack the archive. Resolves a Committer
 // which can be used to write file to disk. If the unpacker is configured to prompt
-// it will return a ErrUnpackFileExists along with the committer. Returns a io.EOF
-// once the archive has been fully consumed.
+// it will return a ErrUnpackFileExists along with the committer, or a ErrUnpackFileSkipped
+// if configured to skip existing files. Names are sanitized with SanitizeName, unsafe names
+// fail with ErrUnsafeName. Returns a io.EOF once the archive has been fully consumed.
 func (u *Unpacker) Unpack() (Committer, error) {
 	if u.tr == nil {
 		return nil, ErrUninitialized
 	}
+	if u.archive {
+		return u.unpackArchive()
+	}
 	header, err := u.tr.Next()
 	switch {
 	case err != nil:
@@ -130,18 +547,81 @@ func (u *Unpacker) Unpack() (Committer, error) {
 	case header == nil:
 		return nil, ErrUnpackNoHeader
 	}
-	path := filepath.Join(u.cwd, header.Name)
+	name, err := SanitizeName(header.Name)
+	if err != nil {
+		return nil, err
+	}
+	var collision error
+	if header.Typeflag == tar.TypeReg {
+		if u.rename != "" {
+			name = u.rename
+		}
+		name, collision = u.resolveCollision(u.cwd, name)
+	}
 	commiter := committer{
-		cwd:    u.cwd,
-		name:   header.Name,
-		tr:     u.tr,
-		header: header,
+		cwd:         u.cwd,
+		name:        name,
+		keepPartial: u.keepPartial,
+		tr:          u.tr,
+		header:      header,
+
+		preserveOwnership: u.preserveOwnership,
+		onOwnershipSkip:   u.onOwnershipSkip,
+		preserveXattrs:    u.preserveXattrs,
+		onXattrSkip:       u.onXattrSkip,
+		xattrNamespaces:   u.xattrNamespaces,
+
+		resume:           u.resume,
+		verifyingKey:     u.verifyingKey,
+		extractZips:      u.extractZips,
+		resolveCollision: u.resolveCollision,
 	}
+	return &commiter, collision
+}
 
-	if u.prompt && header.Typeflag == tar.TypeReg && fileExists(path) {
-		return &commiter, ErrUnpackFileExists
+// unpackArchive resolves a Committer saving the decompressed archive as a single file, rather than
+// extracting its objects. Returns a io.EOF once the archive has been resolved.
+func (u *Unpacker) unpackArchive() (Committer, error) {
+	if u.saved {
+		return nil, io.EOF
+	}
+	if u.verifyingKey != nil {
+		return nil, errors.New("signatures are verified per file, the archive cannot be saved as a single file")
+	}
+	u.saved = true
+	name := u.rename
+	if name == "" {
+		name = ARCHIVE_NAME
+	}
+	name, collision := u.resolveCollision(u.cwd, name)
+	return &archiveCommitter{path: filepath.Join(u.cwd, name), keepPartial: u.keepPartial, r: u.gr}, collision
+}
+
+// VerifyArchive reads the compressed tar archive from r in full without writing it to disk, returning
+// the number of objects in the archive and their decompressed size. Returns an error if the archive is malformed.
+func VerifyArchive(r io.Reader) (int, int64, error) {
+	dr, err := newDecompressor(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	var objects int
+	var size int64
+	for {
+		if _, err := tr.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, size, nil
+			}
+			return objects, size, err
+		}
+		n, err := io.Copy(io.Discard, tr)
+		if err != nil {
+			return objects, size, err
+		}
+		objects++
+		size += n
 	}
-	return &commiter, nil
 }
 
 // Committer defines a unit that can commit a file to disk
@@ -151,10 +631,23 @@ type Committer interface {
 }
 
 type committer struct {
-	cwd    string
-	name   string
-	tr     *tar.Reader
-	header *tar.Header
+	cwd         string
+	name        string
+	keepPartial bool
+	tr          *tar.Reader
+	header      *tar.Header
+
+	preserveOwnership bool
+	onOwnershipSkip   func(error)
+	preserveXattrs    bool
+	onXattrSkip       func(error)
+	xattrNamespaces   []string
+
+	resume       *transfer.Resume
+	verifyingKey ed25519.PublicKey
+	extractZips  bool
+	// resolveCollision resolves the names of the extracted zip entries colliding with existing files.
+	resolveCollision func(dir, name string) (string, error)
 }
 
 func (c *committer) FileName() string {
@@ -170,26 +663,305 @@ func (c *committer) Commit() (int64, error) {
 				return 0, err
 			}
 		}
-		return 0, nil
+		return 0, c.applyMetadata(path)
 	case tar.TypeReg:
-		f, err := os.Create(path)
-		if err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return 0, err
 		}
-		defer f.Close()
-		if _, err := io.Copy(f, c.tr); err != nil {
-			return 0, err
+		if c.resume != nil {
+			return c.commitResumable(path)
+		}
+		if c.extractZips && c.header.PAXRecords[zipPAXRecord] != "" {
+			return c.commitZip()
+		}
+		// the file is written under a partial name, and only renamed once it is complete and verified.
+		partial := path + PARTIAL_FILE_SUFFIX
+		digest := sha256.New()
+		var r io.Reader = c.tr
+		if c.verifyingKey != nil {
+			r = io.TeeReader(c.tr, digest)
+		}
+		n, err := writePartial(partial, r, c.header.Size)
+		if err == nil {
+			if err = c.verifySignature(digest.Sum(nil)); err != nil {
+				os.Remove(partial)
+				return 0, err
+			}
+			err = os.Rename(partial, path)
 		}
-		info, err := f.Stat()
 		if err != nil {
+			if !c.keepPartial {
+				os.Remove(partial)
+			}
 			return 0, err
 		}
-		return info.Size(), nil
+		return n, c.applyMetadata(path)
 	default:
 		return 0, errors.New("unsupported file type")
 	}
 }
 
+// archiveCommitter commits the decompressed archive to disk as a single file.
+type archiveCommitter struct {
+	path        string
+	keepPartial bool
+	r           io.Reader
+}
+
+func (c *archiveCommitter) FileName() string {
+	return filepath.Base(c.path)
+}
+
+func (c *archiveCommitter) Commit() (int64, error) {
+	partial := c.path + PARTIAL_FILE_SUFFIX
+	n, err := writePartial(partial, c.r, -1)
+	if err == nil {
+		err = os.Rename(partial, c.path)
+	}
+	if err != nil {
+		if !c.keepPartial {
+			os.Remove(partial)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// applyMetadata applies the ownership and then the extended attributes of the header to the committed file, as
+// changing the ownership clears attributes such as file capabilities.
+func (c *committer) applyMetadata(path string) error {
+	if err := c.applyOwnership(path); err != nil {
+		return err
+	}
+	return c.applyXattrs(path)
+}
+
+// applyOwnership applies the uid/gid of the header to the committed file, if ownership is preserved.
+// Lacking privileges or platform support is reported as a ErrOwnershipSkipped, rather than failing the commit.
+func (c *committer) applyOwnership(path string) error {
+	if !c.preserveOwnership {
+		return nil
+	}
+	err := chown(path, c.header.Uid, c.header.Gid)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrPermission) && !errors.Is(err, errOwnershipUnsupported) {
+		return err
+	}
+	if c.onOwnershipSkip != nil {
+		c.onOwnershipSkip(fmt.Errorf("%w for %s: %v", ErrOwnershipSkipped, c.name, err))
+	}
+	return nil
+}
+
+// ---------------------------------------------------- Output Path ----------------------------------------------------
+
+var ErrOutputIsFile = errors.New("output path is an existing file")
+var ErrOutputParentMissing = errors.New("parent directory of output path does not exist")
+
+// OutputTarget is where an archive is unpacked.
+type OutputTarget struct {
+	Dir     string // directory the archive is unpacked into
+	Name    string // name the single file of the archive is written as, empty to keep its name
+	Archive bool   // whether the archive is saved as a single tar file named Name, rather than extracted
+	// ExtractZips is whether the directories packed as zip by the sender are extracted, rather than saved.
+	ExtractZips bool
+}
+
+// Extract defines whether a received archive is extracted or saved as a single tar file.
+type Extract int
+
+const (
+	ExtractAuto   Extract = iota // Extract the archive, unless the sender packed it WithArchive
+	ExtractAlways                // Extract the archive, even if the sender packed it WithArchive
+	ExtractNever                 // Save the archive, named after its single directory or ARCHIVE_NAME
+)
+
+// ResolveOutput resolves where the archive read from r is unpacked for the provided output path,
+// rewinding r to the start of the archive once read:
+//   - no output path unpacks into the current working directory.
+//   - an existing directory unpacks into the directory, keeping the original names.
+//   - a single file written to an existing file or a new path is written as that path.
+//   - multiple files or a directory written to a new path unpack into a directory created at that path.
+//   - multiple files or a directory written to an existing file is an error.
+//
+// Archives saved rather than extracted, as decided by extract, are resolved like a single file. Directories packed
+// as zip by the sender are only extracted, and resolved like directories, with ExtractAlways.
+// New output paths require their parent directory to exist.
+func ResolveOutput(r io.ReadSeeker, output string, extract Extract) (OutputTarget, error) {
+	target, err := resolveOutput(r, output, extract)
+	target.ExtractZips = err == nil && extract == ExtractAlways
+	return target, err
+}
+
+// resolveOutput resolves where the archive is unpacked, see ResolveOutput.
+func resolveOutput(r io.ReadSeeker, output string, extract Extract) (OutputTarget, error) {
+	archive := extract == ExtractNever
+	if extract == ExtractAuto {
+		marked, err := markedAsArchive(r)
+		if err != nil {
+			return OutputTarget{}, fmt.Errorf("reading archive: %w", err)
+		}
+		archive = marked
+	}
+	if output == "" && !archive {
+		return OutputTarget{}, nil
+	}
+	shape, top, err := archiveShape(r, extract == ExtractAlways)
+	if err != nil {
+		return OutputTarget{}, fmt.Errorf("reading archive: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return OutputTarget{}, err
+	}
+	var name string
+	if archive {
+		name = ARCHIVE_NAME
+		if shape == shapeDirectory && ValidateName(top) == nil {
+			name = top + ".tar"
+		}
+		shape = shapeSingleFile
+	}
+	if output == "" {
+		return OutputTarget{Name: name, Archive: archive}, nil
+	}
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return OutputTarget{}, err
+	}
+	fi, err := os.Stat(abs)
+	switch {
+	case err == nil && fi.IsDir():
+		return OutputTarget{Dir: abs, Name: name, Archive: archive}, nil
+	case err == nil && shape != shapeSingleFile:
+		return OutputTarget{}, fmt.Errorf("%w: %s, but the transfer contains %s", ErrOutputIsFile, output, shape)
+	case err == nil:
+		return OutputTarget{Dir: filepath.Dir(abs), Name: filepath.Base(abs), Archive: archive}, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return OutputTarget{}, err
+	}
+	if _, err := os.Stat(filepath.Dir(abs)); err != nil {
+		return OutputTarget{}, fmt.Errorf("%w: %s", ErrOutputParentMissing, output)
+	}
+	if shape == shapeSingleFile {
+		return OutputTarget{Dir: filepath.Dir(abs), Name: filepath.Base(abs), Archive: archive}, nil
+	}
+	if err := os.Mkdir(abs, 0755); err != nil {
+		return OutputTarget{}, fmt.Errorf("creating output directory: %w", err)
+	}
+	return OutputTarget{Dir: abs}, nil
+}
+
+// shape describes the objects contained in an archive.
+type shape string
+
+const (
+	shapeSingleFile shape = "a single file"
+	shapeDirectory  shape = "a directory"
+	shapeMultiple   shape = "multiple files"
+)
+
+// markedAsArchive reads the first header of the archive read from r, reporting whether the sender packed
+// the archive WithArchive. Rewinds r to the start of the archive once read.
+func markedAsArchive(r io.ReadSeeker) (bool, error) {
+	dr, err := newDecompressor(r)
+	if err != nil {
+		return false, err
+	}
+	header, err := tar.NewReader(dr).Next()
+	dr.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return header != nil && header.PAXRecords[archivePAXRecord] != "", nil
+}
+
+// ReadText reads the text message of the archive read from r, reporting whether the sender packed a message with
+// PackText rather than files. Messages are verified like files if a signature verification option is provided,
+// see WithSignatureVerification. Rewinds r to the start of the archive once read.
+func ReadText(r io.ReadSeeker, opts ...UnpackOption) (string, bool, error) {
+	var u Unpacker
+	for _, opt := range opts {
+		opt(&u)
+	}
+	dr, err := newDecompressor(r)
+	if err != nil {
+		return "", false, err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	header, err := tr.Next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", false, err
+	}
+	var text []byte
+	marked := header != nil && header.PAXRecords[textPAXRecord] != ""
+	if marked {
+		if text, err = io.ReadAll(io.LimitReader(tr, MAX_TEXT_SIZE+1)); err != nil {
+			return "", false, err
+		}
+		if len(text) > MAX_TEXT_SIZE {
+			return "", false, ErrTextTooLarge
+		}
+		digest := sha256.Sum256(text)
+		c := committer{header: header, verifyingKey: u.verifyingKey}
+		if err := c.verifySignature(digest[:]); err != nil {
+			return "", false, err
+		}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", false, err
+	}
+	return string(text), marked, nil
+}
+
+// archiveShape reads the headers of the archive read from r, returning the shape of its objects
+// and, for a directory, its name. Directories packed as zip are directories if extractZips is set.
+func archiveShape(r io.Reader, extractZips bool) (shape, string, error) {
+	dr, err := newDecompressor(r)
+	if err != nil {
+		return "", "", err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	var objects int
+	var firstRegular bool
+	var top string
+	tops := map[string]bool{} // top-level names of the objects
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		zipped := extractZips && header.PAXRecords[zipPAXRecord] != ""
+		if zipped {
+			name = strings.TrimSuffix(name, ZIP_EXTENSION)
+		}
+		top = strings.SplitN(name, "/", 2)[0]
+		if objects == 0 {
+			firstRegular = header.Typeflag == tar.TypeReg && !zipped
+		}
+		objects++
+		tops[top] = true
+	}
+	switch {
+	case objects == 1 && firstRegular:
+		return shapeSingleFile, "", nil
+	case len(tops) == 1 && !firstRegular:
+		return shapeDirectory, top, nil
+	default:
+		return shapeMultiple, "", nil
+	}
+}
+
 // ----------------------------------------------------- Utilities -----------------------------------------------------
 
 // Traverses a file or directory recursively for total size in bytes.
@@ -228,18 +1000,72 @@ func RemoveTemporaryFiles(prefix string) {
 
 // ------------------------------------------------------- Helper ------------------------------------------------------
 
+// validateRename checks that the files can be packed under the provided name.
+func validateRename(files []*os.File, name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return errors.New("only a single file can be renamed")
+	}
+	fi, err := files[0].Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.New("only a regular file can be renamed")
+	}
+	return nil
+}
+
 // addToTarArchive adds a file/folder to a tar archive.
-// Handles symlinks by replacing them with the files that they point to.
-func addToTarArchive(tw *tar.Writer, file *os.File) error {
+// Handles symlinks by replacing them with the files that they point to, unless packed as symlinks.
+func addToTarArchive(tw *tar.Writer, file *os.File, opts *packOptions) error {
 	var absoluteBase string
 	absPath, err := filepath.Abs(file.Name())
 	if err != nil {
 		return err
 	}
 	absoluteBase = filepath.Dir(absPath)
+	if opts.relativePaths {
+		if absoluteBase, err = os.Getwd(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absoluteBase, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("path '%s' is outside of the working directory", file.Name())
+		}
+	}
+
+	// exclusion patterns are matched against paths relative to the sent directory.
+	root := file.Name()
+	rootInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var patterns []string
+	if rootInfo.IsDir() {
+		if patterns, err = readIgnoreFile(root); err != nil {
+			return fmt.Errorf("reading %s: %w", IGNORE_FILE_NAME, err)
+		}
+	} else {
+		root = filepath.Dir(root)
+	}
+	ignore := newIgnoreMatcher(append(patterns, opts.excludes...)...)
 
 	return filepath.Walk(file.Name(), func(path string, fi os.FileInfo, err error) error {
-		if (fi.Mode() & os.ModeSymlink) == os.ModeSymlink {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != "." && ignore.Match(filepath.ToSlash(rel), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var linkTarget string
+		if (fi.Mode()&os.ModeSymlink) == os.ModeSymlink && opts.zipSymlinks {
+			linkTarget = symlinkWithin(file.Name(), path)
+		}
+		if (fi.Mode()&os.ModeSymlink) == os.ModeSymlink && linkTarget == "" {
 			// read path that the symlink is pointing to
 			var link string
 			if link, err = filepath.EvalSymlinks(path); err != nil {
@@ -253,8 +1079,34 @@ func addToTarArchive(tw *tar.Writer, file *os.File) error {
 			}
 		}
 
-		// tar.FileInfoHeader handles path as pointee if path is a symlink
-		header, e := tar.FileInfoHeader(fi, path)
+		// skip files that have not been modified since the cutoff.
+		if !fi.IsDir() && !opts.modifiedSince.IsZero() && !fi.ModTime().After(opts.modifiedSince) {
+			return nil
+		}
+
+		if !fi.IsDir() {
+			opts.files++
+			if opts.maxFiles > 0 && opts.files > opts.maxFiles {
+				return fmt.Errorf("%w: more than %d files, skip files with --exclude patterns or a %s file",
+					ErrTooManyFiles, opts.maxFiles, IGNORE_FILE_NAME)
+			}
+		}
+
+		// sent directories packed as zip are packed as a single zip file, in place of their objects.
+		contents := path
+		zipped := opts.dirsAsZip && fi.IsDir() && path == file.Name()
+		if zipped {
+			if contents, err = zipDirectory(file, opts); err != nil {
+				return fmt.Errorf("packing %s as zip: %w", file.Name(), err)
+			}
+			defer os.Remove(contents)
+			if fi, err = os.Stat(contents); err != nil {
+				return err
+			}
+		}
+
+		// the symlinks left in place point to their target, others were replaced by their pointee.
+		header, e := tar.FileInfoHeader(fi, linkTarget)
 		if e != nil {
 			return err
 		}
@@ -267,25 +1119,116 @@ func addToTarArchive(tw *tar.Writer, file *os.File) error {
 		// remove the absolute root from the filename, leaving only the desired filename
 		header.Name = filepath.ToSlash(strings.TrimPrefix(targetPath, absoluteBase))
 		header.Name = strings.TrimPrefix(header.Name, string(os.PathSeparator))
+		if zipped {
+			header.Name += ZIP_EXTENSION
+			header.PAXRecords = map[string]string{zipPAXRecord: "1"}
+			if opts.formats != nil && !slices.Contains(*opts.formats, transfer.FORMAT_ZIP) {
+				*opts.formats = append(*opts.formats, transfer.FORMAT_ZIP)
+			}
+		}
+		if opts.rename != "" {
+			header.Name = opts.rename
+		}
+		if opts.selected != nil && !opts.selects(header.Name, fi.IsDir()) {
+			return nil
+		}
+		if !fi.IsDir() && opts.manifest != nil {
+			*opts.manifest = append(*opts.manifest, transfer.ManifestFile{Name: header.Name, Size: fi.Size()})
+		}
+		// the attributes of directories packed as zip are not carried, the zip file is packed in their place.
+		if opts.xattrs && !zipped {
+			if err := addXattrs(header, contents); err != nil {
+				return err
+			}
+		}
+		if opts.archive {
+			if header.PAXRecords == nil {
+				header.PAXRecords = map[string]string{}
+			}
+			header.PAXRecords[archivePAXRecord] = "1"
+		}
+		// the whole file is signed, also when it resumes from an offset.
+		if !fi.IsDir() && opts.signingKey != nil {
+			signature, err := signFile(opts.signingKey, header.Name, contents)
+			if err != nil {
+				return err
+			}
+			if header.PAXRecords == nil {
+				header.PAXRecords = map[string]string{}
+			}
+			header.PAXRecords[signaturePAXRecord] = signature
+		}
+		// files completed by the receiver in a previous session are skipped, and the partial file resumed.
+		var offset int64
+		if !fi.IsDir() && opts.resume != nil {
+			var completed bool
+			if offset, completed, err = resumeOffset(opts.resume, header.Name, contents, fi.Size()); err != nil {
+				return err
+			}
+			if completed {
+				return nil
+			}
+			if offset > 0 {
+				if header.PAXRecords == nil {
+					header.PAXRecords = map[string]string{}
+				}
+				header.PAXRecords[resumeOffsetPAXRecord] = strconv.FormatInt(offset, 10)
+				header.Size -= offset
+			}
+		}
 
+		if opts.sized != nil {
+			n, err := tarSize(header)
+			if err != nil {
+				return err
+			}
+			*opts.sized += n
+			return nil
+		}
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
 
-		if !fi.IsDir() {
-			data, err := os.Open(path)
+		if !fi.IsDir() && linkTarget == "" {
+			data, err := os.Open(contents)
 			if err != nil {
 				return err
 			}
 			defer data.Close()
+			if _, err := data.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			if err := opts.sampler.store(IsCompressed(header.Name)); err != nil {
+				return err
+			}
 			if _, err := io.Copy(tw, data); err != nil {
 				return err
 			}
 		}
+		if zipped {
+			return filepath.SkipDir
+		}
 		return nil
 	})
 }
 
+// writePartial writes the contents of the reader to the named file, verifying that the expected number of bytes
+// is written. A negative size writes the reader until EOF without verification.
+func writePartial(name string, r io.Reader, size int64) (int64, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("incomplete file, wrote %d of %d bytes", n, size)
+	}
+	return n, err
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
@@ -0,0 +1,825 @@
+package file_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/klauspost/pgzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackFiles(t *testing.T) {
+	t.Run("modified since", func(t *testing.T) {
+		cutoff := time.Now().Add(-time.Hour)
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "old.txt"), cutoff.Add(-time.Minute))
+		writeFile(t, filepath.Join(dir, "new.txt"), cutoff.Add(time.Minute))
+		writeFile(t, filepath.Join(dir, "nested", "old.txt"), cutoff.Add(-time.Minute))
+		writeFile(t, filepath.Join(dir, "nested", "new.txt"), cutoff.Add(time.Minute))
+
+		names := packedNames(t, []string{dir}, file.WithModifiedSince(cutoff))
+		base := filepath.Base(dir)
+		assert.ElementsMatch(t, []string{
+			base,
+			base + "/new.txt",
+			base + "/nested",
+			base + "/nested/new.txt",
+		}, names)
+	})
+	t.Run("excludes", func(t *testing.T) {
+		dir := t.TempDir()
+		now := time.Now()
+		writeFile(t, filepath.Join(dir, "main.go"), now)
+		writeFile(t, filepath.Join(dir, "debug.log"), now)
+		writeFile(t, filepath.Join(dir, ".git", "HEAD"), now)
+		writeFile(t, filepath.Join(dir, "web", "node_modules", "left-pad", "index.js"), now)
+		writeFile(t, filepath.Join(dir, "web", "build", "app.js"), now)
+		writeFile(t, filepath.Join(dir, "build", "app.js"), now)
+		writeFile(t, filepath.Join(dir, "logs", "keep.log"), now)
+
+		names := packedNames(t, []string{dir}, file.WithExcludes(".git/", "node_modules", "*.log", "!logs/keep.log", "/build"))
+		base := filepath.Base(dir)
+		assert.ElementsMatch(t, []string{
+			base,
+			base + "/main.go",
+			base + "/web",
+			base + "/web/build",
+			base + "/web/build/app.js",
+			base + "/logs",
+			base + "/logs/keep.log",
+		}, names)
+	})
+	t.Run("portalignore", func(t *testing.T) {
+		dir := t.TempDir()
+		now := time.Now()
+		writeFile(t, filepath.Join(dir, "a", "b", "generated.pb.go"), now)
+		writeFile(t, filepath.Join(dir, "a", "b", "keep.go"), now)
+		writeFile(t, filepath.Join(dir, "a", "b", "important.pb.go"), now)
+		writeFile(t, filepath.Join(dir, "a", "b", "secret.pb.go"), now)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file.IGNORE_FILE_NAME),
+			[]byte("# generated files\n**/*.pb.go\n!important.pb.go\n!secret.pb.go\n"), 0644))
+
+		// exclude flags take precedence over the negations of the ignore file.
+		names := packedNames(t, []string{dir}, file.WithExcludes("a/**/secret.pb.go"))
+		base := filepath.Base(dir)
+		assert.ElementsMatch(t, []string{
+			base,
+			base + "/" + file.IGNORE_FILE_NAME,
+			base + "/a",
+			base + "/a/b",
+			base + "/a/b/keep.go",
+			base + "/a/b/important.pb.go",
+		}, names)
+	})
+	t.Run("rename", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "2023-06-01-final-v3.pdf")
+		writeFile(t, src, time.Now())
+		files, err := file.ReadFiles([]string{src})
+		require.NoError(t, err)
+		payload, _, err := file.PackFiles(files, file.WithRename("report.pdf"))
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+
+		dst := t.TempDir()
+		chdir(t, dst)
+		unpacker, err := file.NewUnpacker(false, payload)
+		require.NoError(t, err)
+		defer unpacker.Close()
+		c, err := unpacker.Unpack()
+		require.NoError(t, err)
+		assert.Equal(t, "report.pdf", c.FileName())
+		_, err = c.Commit()
+		require.NoError(t, err)
+
+		b, err := os.ReadFile(filepath.Join(dst, "report.pdf"))
+		require.NoError(t, err)
+		assert.Equal(t, src, string(b))
+	})
+	t.Run("rename invalid", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.txt"), time.Now())
+		for _, tc := range []struct {
+			name  string
+			paths []string
+			to    string
+		}{
+			{"path traversal", []string{filepath.Join(dir, "a.txt")}, "../a.txt"},
+			{"nested", []string{filepath.Join(dir, "a.txt")}, "b/a.txt"},
+			{"directory", []string{dir}, "b"},
+			{"multiple files", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.txt")}, "b"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				files, err := file.ReadFiles(tc.paths)
+				require.NoError(t, err)
+				_, _, err = file.PackFiles(files, file.WithRename(tc.to))
+				assert.Error(t, err)
+			})
+		}
+	})
+	t.Run("compression", func(t *testing.T) {
+		dir := t.TempDir()
+		random := make([]byte, 2*file.COMPRESSION_SAMPLE_SIZE)
+		_, err := rand.Read(random)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "random.bin"), random, 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), random, 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), bytes.Repeat([]byte("portal "), len(random)/7), 0644))
+
+		for _, tc := range []struct {
+			name      string
+			path      string
+			threshold float64
+			skipped   bool
+			stored    int
+		}{
+			{"incompressible", "random.bin", file.DEFAULT_COMPRESSION_THRESHOLD, true, 0},
+			{"compressible", "notes.txt", file.DEFAULT_COMPRESSION_THRESHOLD, false, 0},
+			{"already compressed", "video.mp4", file.DEFAULT_COMPRESSION_THRESHOLD, false, 1},
+			{"always compress", "video.mp4", 1, false, 0},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				var result file.CompressionResult
+				names := packedNames(t, []string{filepath.Join(dir, tc.path)},
+					file.WithCompressionThreshold(tc.threshold), file.WithCompressionResult(&result))
+				assert.Equal(t, []string{tc.path}, names)
+				assert.Equal(t, tc.skipped, result.Skipped)
+				assert.Equal(t, tc.stored, result.Stored)
+				assert.Greater(t, result.Ratio, 0.0)
+			})
+		}
+	})
+	t.Run("max files", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"one.go", "two.go", filepath.Join("nested", "three.go")} {
+			writeFile(t, filepath.Join(dir, name), time.Now())
+		}
+
+		names := packedNames(t, []string{dir}, file.WithMaxFiles(3))
+		assert.Len(t, names, 5)
+
+		files, err := file.ReadFiles([]string{dir})
+		require.NoError(t, err)
+		_, _, err = file.PackFiles(files, file.WithMaxFiles(2))
+		assert.ErrorIs(t, err, file.ErrTooManyFiles)
+		assert.ErrorContains(t, err, "--exclude")
+
+		// excluded files do not count towards the limit.
+		names = packedNames(t, []string{dir}, file.WithMaxFiles(2), file.WithExcludes("nested/"))
+		assert.Len(t, names, 3)
+
+		// the files are counted up front, before anything is packed.
+		n, err := file.CountFiles(files, file.WithMaxFiles(3))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+		_, err = file.CountFiles(files, file.WithMaxFiles(2))
+		assert.ErrorIs(t, err, file.ErrTooManyFiles)
+		n, err = file.CountFiles(files, file.WithMaxFiles(2), file.WithExcludes("nested/"))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+	t.Run("relative paths", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a", "one.go"), time.Now())
+		writeFile(t, filepath.Join(dir, "a", "b", "two.go"), time.Now())
+		writeFile(t, filepath.Join(dir, "a", "b", "skipped.txt"), time.Now())
+		chdir(t, dir)
+
+		names := packedNames(t, []string{"./a/one.go", "a/b/two.go"}, file.WithRelativePaths())
+		assert.ElementsMatch(t, []string{"a/one.go", "a/b/two.go"}, names)
+	})
+	t.Run("relative paths outside working directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "outside.txt"), time.Now())
+		chdir(t, t.TempDir())
+
+		files, err := file.ReadFiles([]string{filepath.Join(dir, "outside.txt")})
+		require.NoError(t, err)
+		_, _, err = file.PackFiles(files, file.WithRelativePaths())
+		assert.Error(t, err)
+	})
+}
+
+func TestUnpackPartial(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "video.mp4")
+	data := make([]byte, 256*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(src, data, 0644))
+	files, err := file.ReadFiles([]string{src})
+	require.NoError(t, err)
+	payload, _, err := file.PackFiles(files)
+	require.NoError(t, err)
+	defer os.Remove(payload.Name())
+	archive, err := io.ReadAll(payload)
+	require.NoError(t, err)
+	payload.Close()
+
+	// unpack commits the archive, returning the error of committing the file.
+	unpack := func(t *testing.T, archive []byte, opts ...file.UnpackOption) error {
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(archive)), opts...)
+		require.NoError(t, err)
+		defer unpacker.Close()
+		c, err := unpacker.Unpack()
+		require.NoError(t, err)
+		_, err = c.Commit()
+		return err
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		dst := t.TempDir()
+		chdir(t, dst)
+		require.NoError(t, unpack(t, archive))
+		b, err := os.ReadFile(filepath.Join(dst, "video.mp4"))
+		require.NoError(t, err)
+		assert.Equal(t, data, b)
+		assert.NoFileExists(t, filepath.Join(dst, "video.mp4"+file.PARTIAL_FILE_SUFFIX))
+	})
+	t.Run("failed", func(t *testing.T) {
+		dst := t.TempDir()
+		chdir(t, dst)
+		assert.Error(t, unpack(t, archive[:len(archive)/2]))
+		assert.NoFileExists(t, filepath.Join(dst, "video.mp4"))
+		assert.NoFileExists(t, filepath.Join(dst, "video.mp4"+file.PARTIAL_FILE_SUFFIX))
+	})
+	t.Run("failed keep partial", func(t *testing.T) {
+		dst := t.TempDir()
+		chdir(t, dst)
+		assert.Error(t, unpack(t, archive[:len(archive)/2], file.WithKeepPartial(true)))
+		assert.NoFileExists(t, filepath.Join(dst, "video.mp4"))
+		assert.FileExists(t, filepath.Join(dst, "video.mp4"+file.PARTIAL_FILE_SUFFIX))
+	})
+}
+
+func TestCodecs(t *testing.T) {
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("portal transfers files "), 4096)
+	random := make([]byte, 64*1024)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), text, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), random, 0644))
+
+	for _, codec := range transfer.Codecs {
+		t.Run(codec, func(t *testing.T) {
+			files, err := file.ReadFiles([]string{filepath.Join(dir, "notes.txt"), filepath.Join(dir, "video.mp4")})
+			require.NoError(t, err)
+			var result file.CompressionResult
+			payload, size, err := file.PackFiles(files, file.WithCodec(codec), file.WithCompressionResult(&result))
+			require.NoError(t, err)
+			defer os.Remove(payload.Name())
+			assert.Equal(t, codec, result.Codec)
+			if codec != transfer.CODEC_NONE {
+				assert.Less(t, size, int64(len(text)+len(random)))
+			}
+			if codec == transfer.CODEC_GZIP || codec == transfer.CODEC_ZSTD {
+				assert.Equal(t, 1, result.Stored, "already compressed files are stored uncompressed")
+			}
+
+			dst := t.TempDir()
+			chdir(t, dst)
+			unpacker, err := file.NewUnpacker(false, payload)
+			require.NoError(t, err)
+			defer unpacker.Close()
+			for {
+				c, err := unpacker.Unpack()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				_, err = c.Commit()
+				require.NoError(t, err)
+			}
+			b, err := os.ReadFile(filepath.Join(dst, "notes.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, text, b)
+			b, err = os.ReadFile(filepath.Join(dst, "video.mp4"))
+			require.NoError(t, err)
+			assert.Equal(t, random, b)
+		})
+	}
+	t.Run("unknown", func(t *testing.T) {
+		files, err := file.ReadFiles([]string{filepath.Join(dir, "notes.txt")})
+		require.NoError(t, err)
+		_, _, err = file.PackFiles(files, file.WithCodec("lz4"))
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveOutput(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "b.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "c.txt"), time.Now())
+	payloads := map[string][]string{
+		"single file": {"a.txt"},
+		"directory":   {"docs"},
+		"multiple":    {"a.txt", "b.txt"},
+	}
+	// received are the paths, relative to the output path, of the received files of each payload.
+	received := map[string][]string{
+		"single file": {"a.txt"},
+		"directory":   {filepath.Join("docs", "c.txt")},
+		"multiple":    {"a.txt", "b.txt"},
+	}
+
+	tests := []struct {
+		output string
+		// setup prepares the output path in the working directory, returning the output flag.
+		setup func(t *testing.T, wd string) string
+		// expected returns the paths each payload is received as, or the error resolving it.
+		expected func(wd, payload string) ([]string, error)
+	}{
+		{
+			output: "unset",
+			setup:  func(t *testing.T, wd string) string { return "" },
+			expected: func(wd, payload string) ([]string, error) {
+				return joinAll(wd, received[payload]), nil
+			},
+		},
+		{
+			output: "existing directory",
+			setup: func(t *testing.T, wd string) string {
+				require.NoError(t, os.Mkdir(filepath.Join(wd, "out"), 0755))
+				return "out"
+			},
+			expected: func(wd, payload string) ([]string, error) {
+				return joinAll(filepath.Join(wd, "out"), received[payload]), nil
+			},
+		},
+		{
+			output: "existing file",
+			setup: func(t *testing.T, wd string) string {
+				writeFile(t, filepath.Join(wd, "out.txt"), time.Now())
+				return "out.txt"
+			},
+			expected: func(wd, payload string) ([]string, error) {
+				if payload != "single file" {
+					return nil, file.ErrOutputIsFile
+				}
+				return []string{filepath.Join(wd, "out.txt")}, nil
+			},
+		},
+		{
+			output: "new path",
+			setup:  func(t *testing.T, wd string) string { return "out" },
+			expected: func(wd, payload string) ([]string, error) {
+				if payload == "single file" {
+					return []string{filepath.Join(wd, "out")}, nil
+				}
+				return joinAll(filepath.Join(wd, "out"), received[payload]), nil
+			},
+		},
+		{
+			output: "missing parent",
+			setup:  func(t *testing.T, wd string) string { return filepath.Join("missing", "out") },
+			expected: func(wd, payload string) ([]string, error) {
+				return nil, file.ErrOutputParentMissing
+			},
+		},
+	}
+	for _, tc := range tests {
+		for payload, names := range payloads {
+			tc, payload, names := tc, payload, names
+			t.Run(tc.output+"/"+payload, func(t *testing.T) {
+				paths := joinAll(src, names)
+				files, err := file.ReadFiles(paths)
+				require.NoError(t, err)
+				archive, _, err := file.PackFiles(files)
+				require.NoError(t, err)
+				defer os.Remove(archive.Name())
+
+				wd := t.TempDir()
+				chdir(t, wd)
+				output := tc.setup(t, wd)
+				expected, expectedErr := tc.expected(wd, payload)
+
+				target, err := file.ResolveOutput(archive, output, file.ExtractAuto)
+				if expectedErr != nil {
+					assert.ErrorIs(t, err, expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				unpacker, err := file.NewUnpacker(false, archive, file.WithOutput(target))
+				require.NoError(t, err)
+				defer unpacker.Close()
+				for {
+					c, err := unpacker.Unpack()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					require.NoError(t, err)
+					_, err = c.Commit()
+					require.NoError(t, err)
+				}
+				for i, path := range expected {
+					b, err := os.ReadFile(path)
+					require.NoError(t, err)
+					assert.Equal(t, filepath.Join(src, received[payload][i]), string(b))
+				}
+			})
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "c.txt"), time.Now())
+
+	tests := []struct {
+		name    string
+		paths   []string
+		archive bool
+		extract file.Extract
+		// saved is the name the archive is saved as, empty if extracted.
+		saved string
+		// names are the names of the objects of the archive, or the extracted files.
+		names []string
+	}{
+		{name: "sent as archive", paths: []string{"docs"}, archive: true, extract: file.ExtractAuto, saved: "docs.tar", names: []string{"docs", "docs/c.txt"}},
+		{name: "sent as archive extracted", paths: []string{"docs"}, archive: true, extract: file.ExtractAlways, names: []string{"docs/c.txt"}},
+		{name: "extracted by default", paths: []string{"a.txt", "docs"}, extract: file.ExtractAuto, names: []string{"a.txt", "docs/c.txt"}},
+		{name: "saved by receiver", paths: []string{"a.txt", "docs"}, extract: file.ExtractNever, saved: file.ARCHIVE_NAME, names: []string{"a.txt", "docs", "docs/c.txt"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := file.ReadFiles(joinAll(src, tc.paths))
+			require.NoError(t, err)
+			var opts []file.PackOption
+			if tc.archive {
+				opts = append(opts, file.WithArchive())
+			}
+			payload, _, err := file.PackFiles(files, opts...)
+			require.NoError(t, err)
+			defer os.Remove(payload.Name())
+
+			wd := t.TempDir()
+			chdir(t, wd)
+			target, err := file.ResolveOutput(payload, "", tc.extract)
+			require.NoError(t, err)
+			assert.Equal(t, tc.saved != "", target.Archive)
+			unpacker, err := file.NewUnpacker(false, payload, file.WithOutput(target))
+			require.NoError(t, err)
+			defer unpacker.Close()
+			var committed []string
+			for {
+				c, err := unpacker.Unpack()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				_, err = c.Commit()
+				require.NoError(t, err)
+				committed = append(committed, c.FileName())
+			}
+
+			if tc.saved == "" {
+				for _, name := range tc.names {
+					b, err := os.ReadFile(filepath.Join(wd, name))
+					require.NoError(t, err)
+					assert.Equal(t, filepath.Join(src, name), string(b))
+				}
+				return
+			}
+			assert.Equal(t, []string{tc.saved}, committed)
+			saved, err := os.Open(filepath.Join(wd, tc.saved))
+			require.NoError(t, err)
+			defer saved.Close()
+			tr := tar.NewReader(saved)
+			var names []string
+			for {
+				header, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				names = append(names, strings.TrimSuffix(header.Name, "/"))
+			}
+			assert.Equal(t, tc.names, names)
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	const text = "https://example.com/some/very/long/link"
+	t.Run("message", func(t *testing.T) {
+		payload, _, err := file.PackText(text, file.WithCodec(transfer.CODEC_ZSTD))
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		read, ok, err := file.ReadText(payload)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, text, read)
+		offset, err := payload.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Zero(t, offset)
+	})
+	t.Run("files", func(t *testing.T) {
+		src := t.TempDir()
+		writeFile(t, filepath.Join(src, "a.txt"), time.Now())
+		files, err := file.ReadFiles([]string{filepath.Join(src, "a.txt")})
+		require.NoError(t, err)
+		payload, _, err := file.PackFiles(files)
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		_, ok, err := file.ReadText(payload)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("saved to output", func(t *testing.T) {
+		payload, _, err := file.PackText(text)
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		dir := t.TempDir()
+		target, err := file.ResolveOutput(payload, dir, file.ExtractAuto)
+		require.NoError(t, err)
+		unpacker, err := file.NewUnpacker(false, payload, file.WithOutput(target))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		c, err := unpacker.Unpack()
+		require.NoError(t, err)
+		_, err = c.Commit()
+		require.NoError(t, err)
+		b, err := os.ReadFile(filepath.Join(dir, file.TEXT_NAME))
+		require.NoError(t, err)
+		assert.Equal(t, text, string(b))
+	})
+	t.Run("too large", func(t *testing.T) {
+		_, _, err := file.PackText(strings.Repeat("x", file.MAX_TEXT_SIZE+1))
+		assert.ErrorIs(t, err, file.ErrTextTooLarge)
+	})
+}
+
+func TestPackFilesStream(t *testing.T) {
+	src := t.TempDir()
+	now := time.Now()
+	writeFile(t, filepath.Join(src, "docs", "notes.txt"), now)
+	// names longer than the ustar name field are written with extended headers.
+	writeFile(t, filepath.Join(src, "docs", strings.Repeat("long", 40)+".txt"), now)
+	large := make([]byte, 100*1024+3)
+	_, err := rand.Read(large)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "docs", "video.mp4"), large, 0644))
+
+	t.Run("size is counted up front", func(t *testing.T) {
+		files, err := file.ReadFiles([]string{filepath.Join(src, "docs")})
+		require.NoError(t, err)
+		var (
+			result   file.CompressionResult
+			manifest []transfer.ManifestFile
+		)
+		payload, size, err := file.PackFilesStream(files, file.WithCompressionResult(&result), file.WithManifest(&manifest))
+		require.NoError(t, err)
+		defer payload.Close()
+		assert.Equal(t, transfer.CODEC_NONE, result.Codec)
+		assert.Len(t, manifest, 3)
+
+		b, err := io.ReadAll(payload)
+		require.NoError(t, err)
+		assert.Len(t, b, int(size))
+
+		dst := t.TempDir()
+		chdir(t, dst)
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(b)))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			_, err = c.Commit()
+			require.NoError(t, err)
+		}
+		received, err := os.ReadFile(filepath.Join(dst, "docs", "video.mp4"))
+		require.NoError(t, err)
+		assert.Equal(t, large, received)
+	})
+	t.Run("files changed while streaming", func(t *testing.T) {
+		files, err := file.ReadFiles([]string{filepath.Join(src, "docs")})
+		require.NoError(t, err)
+		payload, _, err := file.PackFilesStream(files)
+		require.NoError(t, err)
+		defer payload.Close()
+		writeFile(t, filepath.Join(src, "docs", "added.txt"), now)
+		_, err = io.ReadAll(payload)
+		assert.ErrorIs(t, err, file.ErrStreamChanged)
+	})
+	t.Run("dirs as zip", func(t *testing.T) {
+		files, err := file.ReadFiles([]string{filepath.Join(src, "docs")})
+		require.NoError(t, err)
+		_, _, err = file.PackFilesStream(files, file.WithDirsAsZip())
+		assert.Error(t, err)
+	})
+}
+
+func TestSelected(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "b.pdf"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "c.pdf"), time.Now())
+	paths := joinAll(src, []string{"a.txt", "docs"})
+
+	var manifest []transfer.ManifestFile
+	packedNames(t, paths, file.WithManifest(&manifest))
+	names := make([]string, 0, len(manifest))
+	for _, f := range manifest {
+		assert.Positive(t, f.Size)
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"a.txt", "docs/b.pdf", "docs/c.pdf"}, names)
+
+	// only the selected files, and the directories holding them, are packed.
+	assert.Equal(t, []string{"docs", "docs/c.pdf"}, packedNames(t, paths, file.WithSelected("docs/c.pdf")))
+	assert.Equal(t, []string{"a.txt"}, packedNames(t, paths, file.WithSelected("a.txt")))
+}
+
+func TestDirsAsZip(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "a.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "b.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "notes", "c.txt"), time.Now())
+	writeFile(t, filepath.Join(src, "docs", "debug.log"), time.Now())
+	// symlinks within the directory are zipped as symlinks, others as their pointee.
+	require.NoError(t, os.Symlink("b.txt", filepath.Join(src, "docs", "link.txt")))
+	require.NoError(t, os.Symlink(filepath.Join("..", "a.txt"), filepath.Join(src, "docs", "up.txt")))
+	paths := joinAll(src, []string{"a.txt", "docs"})
+	opts := []file.PackOption{file.WithDirsAsZip(), file.WithExcludes("*.log")}
+
+	var formats []string
+	assert.Equal(t, []string{"a.txt", "docs.zip"}, packedNames(t, paths, append(opts, file.WithFormats(&formats))...))
+	assert.Equal(t, []string{transfer.FORMAT_ZIP}, formats)
+
+	// unpack packs the paths and unpacks them into a new working directory with the provided extraction.
+	unpack := func(t *testing.T, extract file.Extract) string {
+		files, err := file.ReadFiles(paths)
+		require.NoError(t, err)
+		payload, _, err := file.PackFiles(files, opts...)
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		wd := t.TempDir()
+		chdir(t, wd)
+		target, err := file.ResolveOutput(payload, "", extract)
+		require.NoError(t, err)
+		unpacker, err := file.NewUnpacker(false, payload, file.WithOutput(target))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			_, err = c.Commit()
+			require.NoError(t, err)
+		}
+		return wd
+	}
+
+	t.Run("saved", func(t *testing.T) {
+		wd := unpack(t, file.ExtractAuto)
+		zr, err := zip.OpenReader(filepath.Join(wd, "docs.zip"))
+		require.NoError(t, err)
+		defer zr.Close()
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+			assert.Equal(t, f.Name == "docs/link.txt", f.Mode()&os.ModeSymlink != 0, f.Name)
+		}
+		assert.Equal(t, []string{"docs/", "docs/b.txt", "docs/link.txt", "docs/notes/", "docs/notes/c.txt", "docs/up.txt"}, names)
+		assert.NoDirExists(t, filepath.Join(wd, "docs"))
+	})
+
+	t.Run("extracted", func(t *testing.T) {
+		wd := unpack(t, file.ExtractAlways)
+		for _, name := range []string{"a.txt", "docs/b.txt", "docs/notes/c.txt"} {
+			b, err := os.ReadFile(filepath.Join(wd, name))
+			require.NoError(t, err, name)
+			assert.Equal(t, filepath.Join(src, name), string(b))
+		}
+		assert.NoFileExists(t, filepath.Join(wd, "docs.zip"))
+		assert.NoFileExists(t, filepath.Join(wd, "docs", "debug.log"))
+
+		target, err := os.Readlink(filepath.Join(wd, "docs", "link.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "b.txt", target)
+		b, err := os.ReadFile(filepath.Join(wd, "docs", "up.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(src, "a.txt"), string(b), "symlinks out of the directory should be zipped as their pointee")
+	})
+
+	// extractUnsafe extracts a zip file holding the entry into an output directory of a new working directory,
+	// returning the error of the extraction.
+	extractUnsafe := func(t *testing.T, header *zip.FileHeader, content string) (string, error) {
+		var zipped bytes.Buffer
+		zw := zip.NewWriter(&zipped)
+		w, err := zw.CreateHeader(header)
+		require.NoError(t, err)
+		w.Write([]byte(content))
+		require.NoError(t, zw.Close())
+		var archive bytes.Buffer
+		gw := pgzip.NewWriter(&archive)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "docs.zip",
+			Size:       int64(zipped.Len()),
+			PAXRecords: map[string]string{"PORTAL.zip": "1"},
+		}))
+		tw.Write(zipped.Bytes())
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		wd := t.TempDir()
+		out := filepath.Join(wd, "out")
+		require.NoError(t, os.Mkdir(out, 0755))
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(archive.Bytes())), file.WithOutput(file.OutputTarget{Dir: out, ExtractZips: true}))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		c, err := unpacker.Unpack()
+		require.NoError(t, err)
+		_, err = c.Commit()
+		return wd, err
+	}
+
+	t.Run("unsafe entry", func(t *testing.T) {
+		wd, err := extractUnsafe(t, &zip.FileHeader{Name: "../evil.txt"}, "evil")
+		assert.ErrorIs(t, err, file.ErrZipEntryUnsafe)
+		assert.NoFileExists(t, filepath.Join(wd, "evil.txt"))
+	})
+
+	t.Run("unsafe symlink", func(t *testing.T) {
+		header := &zip.FileHeader{Name: "docs/evil.txt"}
+		header.SetMode(os.ModeSymlink | 0777)
+		wd, err := extractUnsafe(t, header, "../../evil.txt")
+		assert.ErrorIs(t, err, file.ErrZipEntryUnsafe)
+		_, err = os.Lstat(filepath.Join(wd, "out", "docs", "evil.txt"))
+		assert.ErrorIs(t, err, os.ErrNotExist, "symlinks out of the output directory should not be extracted")
+	})
+}
+
+// ------------------------------------------------------ Helpers ------------------------------------------------------
+
+func writeFile(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(path), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// joinAll joins each of the provided names onto dir.
+func joinAll(dir string, names []string) []string {
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return paths
+}
+
+// packedNames packs the provided paths and returns the names of the entries in the archive.
+func packedNames(t *testing.T, paths []string, opts ...file.PackOption) []string {
+	t.Helper()
+	files, err := file.ReadFiles(paths)
+	require.NoError(t, err)
+	payload, _, err := file.PackFiles(files, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		payload.Close()
+		os.Remove(payload.Name())
+	})
+
+	gr, err := pgzip.NewReader(payload)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	return names
+}
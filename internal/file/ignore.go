@@ -0,0 +1,118 @@
+// ignore.go specifies the gitignore-style patterns used to exclude files when packing directories.
+package file
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IGNORE_FILE_NAME is the name of the file, at the root of a sent directory, listing patterns to exclude.
+const IGNORE_FILE_NAME = ".portalignore"
+
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreMatcher matches relative paths against gitignore-style patterns, the last matching pattern wins.
+type ignoreMatcher struct {
+	patterns []ignorePattern
+}
+
+// newIgnoreMatcher parses the provided gitignore-style patterns, blank lines and comments are skipped.
+func newIgnoreMatcher(patterns ...string) *ignoreMatcher {
+	m := &ignoreMatcher{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		var ip ignorePattern
+		if strings.HasPrefix(p, "!") {
+			ip.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			ip.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		// patterns containing a slash are matched relative to the root, otherwise at any depth.
+		ip.anchored = strings.Contains(p, "/")
+		p = strings.TrimPrefix(p, "/")
+		if p == "" {
+			continue
+		}
+		ip.segments = strings.Split(p, "/")
+		m.patterns = append(m.patterns, ip)
+	}
+	return m
+}
+
+// readIgnoreFile reads the patterns of the ignore file in the provided directory, if present.
+func readIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, IGNORE_FILE_NAME))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	return patterns, scanner.Err()
+}
+
+// Match reports whether the slash separated relative path should be excluded.
+func (m *ignoreMatcher) Match(rel string, isDir bool) bool {
+	name := strings.Split(rel, "/")
+	excluded := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		var ok bool
+		if p.anchored {
+			ok = matchSegments(p.segments, name)
+		} else {
+			ok = matchSegments(p.segments, name[len(name)-1:])
+		}
+		if ok {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// matchSegments matches path segments against pattern segments, where "**" matches any number of segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
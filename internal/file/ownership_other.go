@@ -0,0 +1,8 @@
+//go:build !unix
+
+package file
+
+// chown reports that file ownership is not supported on this platform.
+func chown(string, int, int) error {
+	return errOwnershipUnsupported
+}
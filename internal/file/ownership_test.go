@@ -0,0 +1,82 @@
+//go:build unix
+
+package file_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/klauspost/pgzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreserveOwnership(t *testing.T) {
+	const uid, gid = 1234, 5678
+	var buf bytes.Buffer
+	gw := pgzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "backup/", Mode: 0755, Uid: uid, Gid: gid}))
+	data := []byte("A frog walks into a bank...")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "backup/frog.txt", Mode: 0644, Size: int64(len(data)), Uid: uid, Gid: gid}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	// unpack commits the archive, returning the warnings of skipped ownership.
+	unpack := func(t *testing.T) []error {
+		var warnings []error
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(buf.Bytes())),
+			file.WithPreserveOwnership(func(err error) { warnings = append(warnings, err) }))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				return warnings
+			}
+			require.NoError(t, err)
+			_, err = c.Commit()
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("applied", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("changing ownership requires root privileges")
+		}
+		dst := t.TempDir()
+		chdir(t, dst)
+		assert.Empty(t, unpack(t))
+		for _, name := range []string{"backup", filepath.Join("backup", "frog.txt")} {
+			fi, err := os.Stat(filepath.Join(dst, name))
+			require.NoError(t, err)
+			stat := fi.Sys().(*syscall.Stat_t)
+			assert.EqualValues(t, uid, stat.Uid, name)
+			assert.EqualValues(t, gid, stat.Gid, name)
+		}
+	})
+	t.Run("skipped without privileges", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("ownership is always applied with root privileges")
+		}
+		dst := t.TempDir()
+		chdir(t, dst)
+		warnings := unpack(t)
+		require.Len(t, warnings, 2)
+		for _, err := range warnings {
+			assert.ErrorIs(t, err, file.ErrOwnershipSkipped)
+		}
+		b, err := os.ReadFile(filepath.Join(dst, "backup", "frog.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, data, b)
+	})
+}
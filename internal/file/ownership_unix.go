@@ -0,0 +1,10 @@
+//go:build unix
+
+package file
+
+import "os"
+
+// chown changes the ownership of the named file, without following symlinks.
+func chown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
@@ -0,0 +1,245 @@
+package file
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// RESUME_STATE_NAME is the name of the file in the output directory recording the progress of a resumable transfer.
+const RESUME_STATE_NAME = ".portal-resume.json"
+
+// transferIDBytes is the number of bytes of the digest of the files making up a transfer id.
+const transferIDBytes = 16
+
+// resumeOffsetPAXRecord marks the objects of archives resuming a partial file, holding the offset the object resumes from.
+const resumeOffsetPAXRecord = "PORTAL.offset"
+
+// ------------------------------------------------------- Resume ------------------------------------------------------
+
+// ReadResumeState reads the progress of the transfer interrupted in dir, returning an empty state if none is recorded.
+func ReadResumeState(dir string) (transfer.Resume, error) {
+	var state transfer.Resume
+	b, err := os.ReadFile(filepath.Join(dir, RESUME_STATE_NAME))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("parsing %s: %w", RESUME_STATE_NAME, err)
+	}
+	return state, nil
+}
+
+// WriteResumeState records the progress of the transfer interrupted in dir.
+func WriteResumeState(dir string, state transfer.Resume) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, RESUME_STATE_NAME), b, 0644)
+}
+
+// RemoveResumeState removes the progress recorded in dir, once the transfer completed.
+func RemoveResumeState(dir string) error {
+	if err := os.Remove(filepath.Join(dir, RESUME_STATE_NAME)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// TransferID returns the id of a transfer of the files, derived from the absolute paths, sizes and modification
+// times of the files and their contents, such that unchanged files are sent under the same id across sessions.
+func TransferID(files []*os.File) (string, error) {
+	digest := sha256.New()
+	for _, f := range files {
+		root, err := filepath.Abs(f.Name())
+		if err != nil {
+			return "", err
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(digest, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("deriving transfer id: %w", err)
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil)[:transferIDBytes]), nil
+}
+
+// WithResume skips the files the receiver completed in a previous session, and packs the partial file from
+// the offset it was interrupted at. Files are only skipped or resumed if their contents match the digest of
+// the receiver, and are packed in full otherwise.
+func WithResume(resume transfer.Resume) PackOption {
+	return func(o *packOptions) {
+		o.resume = &resume
+	}
+}
+
+// WithResumeState records the files committed in the provided state, keeping the partial file when committing
+// a file fails such that a later session resumes it, and appends objects resuming a partial file to the file
+// kept from a previous session.
+func WithResumeState(state *transfer.Resume) UnpackOption {
+	return func(u *Unpacker) {
+		u.resume = state
+	}
+}
+
+// resumeOffset returns the offset the file of the provided name and size is packed from, or whether the
+// receiver completed it and it is skipped.
+func resumeOffset(resume *transfer.Resume, name, path string, size int64) (int64, bool, error) {
+	for _, completed := range resume.Completed {
+		if completed.Name == name && completed.Size == size {
+			matches, err := prefixMatches(path, completed)
+			return 0, matches, err
+		}
+	}
+	if p := resume.Partial; p != nil && p.Name == name && p.Size <= size {
+		matches, err := prefixMatches(path, *p)
+		if err != nil || !matches {
+			return 0, false, err
+		}
+		return p.Size, false, nil
+	}
+	return 0, false, nil
+}
+
+// prefixMatches reports whether the first bytes of the file at path match the digest of the resumed file.
+func prefixMatches(path string, resumed transfer.ResumedFile) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.CopyN(digest, f, resumed.Size); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(digest.Sum(nil)) == resumed.SHA256, nil
+}
+
+// commitResumable commits the regular file like Commit, recording it in the resume state of the unpacker.
+func (c *committer) commitResumable(path string) (int64, error) {
+	partial := path + PARTIAL_FILE_SUFFIX
+	n, received, err := writeResumable(partial, c.tr, c.header)
+	if err == nil {
+		if err = c.verifyResumed(received); err != nil {
+			os.Remove(partial)
+			return 0, err
+		}
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		// the partial file is kept, such that the file resumes from the bytes received.
+		if received.Size > 0 {
+			c.resume.Partial = &received
+		} else {
+			os.Remove(partial)
+		}
+		return 0, err
+	}
+	completed := c.resume.Completed[:0]
+	for _, f := range c.resume.Completed {
+		if f.Name != received.Name {
+			completed = append(completed, f)
+		}
+	}
+	c.resume.Completed = append(completed, received)
+	if p := c.resume.Partial; p != nil && p.Name == received.Name {
+		c.resume.Partial = nil
+	}
+	return n, c.applyOwnership(path)
+}
+
+// verifyResumed verifies the signature of the received file, against the digest of its contents including the
+// bytes kept from a previous session.
+func (c *committer) verifyResumed(received transfer.ResumedFile) error {
+	if c.verifyingKey == nil {
+		return nil
+	}
+	digest, err := hex.DecodeString(received.SHA256)
+	if err != nil {
+		return err
+	}
+	return c.verifySignature(digest)
+}
+
+// writeResumable writes the object of the header to the named partial file like writePartial, appending to
+// the partial file kept from a previous session if the object resumes it. Returns the number of bytes written
+// along with the contents of the file received so far, also when writing fails.
+func writeResumable(name string, r io.Reader, header *tar.Header) (int64, transfer.ResumedFile, error) {
+	received := transfer.ResumedFile{Name: header.Name}
+	var offset int64
+	if record, ok := header.PAXRecords[resumeOffsetPAXRecord]; ok {
+		var err error
+		if offset, err = strconv.ParseInt(record, 10, 64); err != nil || offset < 0 {
+			return 0, received, fmt.Errorf("invalid resume offset %q", record)
+		}
+	}
+	f, err := openPartial(name, offset)
+	if err != nil {
+		return 0, received, err
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(f, 0, offset)); err != nil {
+		f.Close()
+		return 0, received, err
+	}
+	n, err := io.Copy(io.MultiWriter(f, digest), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != header.Size {
+		err = fmt.Errorf("incomplete file, wrote %d of %d bytes", n, header.Size)
+	}
+	received.Size = offset + n
+	received.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	return n, received, err
+}
+
+// openPartial opens the named partial file for writing from the provided offset, truncating what was
+// written past the offset. Files resumed from an offset must have been kept from a previous session.
+func openPartial(name string, offset int64) (*os.File, error) {
+	if offset == 0 {
+		return os.Create(name)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("resuming partial file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.Size() < offset {
+		err = fmt.Errorf("resuming partial file: %d bytes kept, expected %d", fi.Size(), offset)
+	}
+	if err == nil {
+		err = f.Truncate(offset)
+	}
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
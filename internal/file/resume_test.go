@@ -0,0 +1,123 @@
+package file_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResume(t *testing.T) {
+	const fileSize = 256 * 1024
+	src := t.TempDir()
+	contents := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		data := make([]byte, fileSize)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(src, name), data, 0644))
+		contents[name] = data
+	}
+	pack := func(t *testing.T, opts ...file.PackOption) []byte {
+		files, err := file.ReadFiles([]string{src})
+		require.NoError(t, err)
+		payload, _, err := file.PackFiles(files, opts...)
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		defer payload.Close()
+		archive, err := io.ReadAll(payload)
+		require.NoError(t, err)
+		return archive
+	}
+	// unpack commits the files of the archive into dst until committing fails.
+	unpack := func(t *testing.T, archive []byte, dst string, state *transfer.Resume) error {
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(archive)),
+			file.WithOutput(file.OutputTarget{Dir: dst}), file.WithResumeState(state))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := c.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	base := filepath.Base(src)
+
+	// the transfer is interrupted while the second file is received.
+	archive := pack(t)
+	dst := t.TempDir()
+	var state transfer.Resume
+	require.Error(t, unpack(t, archive[:len(archive)/2], dst, &state))
+	require.Len(t, state.Completed, 1)
+	assert.Equal(t, base+"/a.bin", state.Completed[0].Name)
+	require.NotNil(t, state.Partial)
+	assert.Equal(t, base+"/b.bin", state.Partial.Name)
+	assert.Positive(t, state.Partial.Size)
+	assert.Less(t, state.Partial.Size, int64(fileSize))
+	assert.FileExists(t, filepath.Join(dst, base, "b.bin"+file.PARTIAL_FILE_SUFFIX))
+	assert.NoFileExists(t, filepath.Join(dst, base, "b.bin"))
+	assert.NoFileExists(t, filepath.Join(dst, base, "c.bin"))
+
+	require.NoError(t, file.WriteResumeState(dst, state))
+	recorded, err := file.ReadResumeState(dst)
+	require.NoError(t, err)
+	assert.Equal(t, state, recorded)
+
+	// the first file is changed by the sender, and sent again rather than skipped.
+	changed := bytes.Repeat([]byte("changed"), 1024)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.bin"), changed, 0644))
+	contents["a.bin"] = changed
+
+	// the second file resumes from its offset, only the rest of the files are sent.
+	resumed := pack(t, file.WithResume(recorded))
+	assert.Less(t, len(resumed), len(archive)-int(state.Partial.Size))
+	require.NoError(t, unpack(t, resumed, dst, &recorded))
+	for name, data := range contents {
+		b, err := os.ReadFile(filepath.Join(dst, base, name))
+		require.NoError(t, err)
+		assert.Equal(t, data, b, name)
+		assert.NoFileExists(t, filepath.Join(dst, base, name+file.PARTIAL_FILE_SUFFIX))
+	}
+	assert.Nil(t, recorded.Partial)
+	assert.Len(t, recorded.Completed, 3)
+
+	require.NoError(t, file.RemoveResumeState(dst))
+	assert.NoFileExists(t, filepath.Join(dst, file.RESUME_STATE_NAME))
+	recorded, err = file.ReadResumeState(dst)
+	require.NoError(t, err)
+	assert.Equal(t, transfer.Resume{}, recorded)
+}
+
+func TestTransferID(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("frog"), 0644))
+	id := func(t *testing.T) string {
+		files, err := file.ReadFiles([]string{src})
+		require.NoError(t, err)
+		defer files[0].Close()
+		id, err := file.TransferID(files)
+		require.NoError(t, err)
+		return id
+	}
+	first := id(t)
+	assert.Len(t, first, 32)
+	assert.Equal(t, first, id(t), "unchanged files are sent under the same id")
+
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("frogs"), 0644))
+	assert.NotEqual(t, first, id(t))
+}
@@ -0,0 +1,157 @@
+// sanitize.go specifies how the names of received files are sanitized before they are written, and how received
+// files colliding with existing files are unpacked.
+package file
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrUnsafeName is returned when unpacking a file whose name is absolute or traverses out of the output directory.
+var ErrUnsafeName = errors.New("unsafe file name")
+
+// ErrUnpackFileSkipped is returned along with the committer of a file colliding with an existing file, which is not
+// committed, when unpacking with CollisionSkip.
+var ErrUnpackFileSkipped = errors.New("file exists, skipped")
+
+// Collision defines how received files colliding with existing files are unpacked.
+type Collision int
+
+const (
+	CollisionPrompt    Collision = iota // Return ErrUnpackFileExists along with the committer, overwriting once committed
+	CollisionOverwrite                  // Overwrite the existing file
+	CollisionRename                     // Write the file under the first free name suffixed with a counter, e.g. "notes (1).txt"
+	CollisionSkip                       // Keep the existing file, returning ErrUnpackFileSkipped
+)
+
+// Collisions are the names of the collision policies, as parsed by ParseCollision.
+var Collisions = []string{"prompt", "overwrite", "rename", "skip"}
+
+// ParseCollision parses the collision policy of the provided name, one of Collisions.
+func ParseCollision(name string) (Collision, error) {
+	for i, collision := range Collisions {
+		if name == collision {
+			return Collision(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown collision policy '%s', must be one of %v", name, Collisions)
+}
+
+func (c Collision) String() string {
+	if c < 0 || int(c) >= len(Collisions) {
+		return fmt.Sprintf("Collision(%d)", int(c))
+	}
+	return Collisions[c]
+}
+
+// WithCollision unpacks the regular files colliding with existing files according to the provided policy, overriding
+// the prompt of NewUnpacker. Archives saved as a single file are unpacked alike.
+func WithCollision(collision Collision) UnpackOption {
+	return func(u *Unpacker) {
+		u.collision = collision
+	}
+}
+
+// resolveCollision returns the name the regular file of the provided name is written as in the directory, along with
+// a ErrUnpackFileExists or ErrUnpackFileSkipped if the committer is returned for the caller to decide upon.
+func (u *Unpacker) resolveCollision(dir, name string) (string, error) {
+	if !fileExists(filepath.Join(dir, name)) {
+		return name, nil
+	}
+	switch u.collision {
+	case CollisionPrompt:
+		return name, ErrUnpackFileExists
+	case CollisionRename:
+		return freeName(dir, name), nil
+	case CollisionSkip:
+		return name, ErrUnpackFileSkipped
+	default:
+		return name, nil
+	}
+}
+
+// freeName returns the slash separated name suffixed with the first counter not naming an existing file of the
+// directory, before the extension of the name, e.g. "docs/notes (1).txt" for "docs/notes.txt".
+func freeName(dir, name string) string {
+	ext := path.Ext(name)
+	if ext == path.Base(name) {
+		// dotfiles such as .bashrc have no extension.
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if !fileExists(filepath.Join(dir, candidate)) && !fileExists(filepath.Join(dir, candidate+PARTIAL_FILE_SUFFIX)) {
+			return candidate
+		}
+	}
+}
+
+// windowsReserved are the device names reserved by Windows, which cannot name files with any extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName returns the name of a received file as a slash separated path local to the output directory,
+// normalized for the current platform, see sanitizeName.
+func SanitizeName(name string) (string, error) {
+	return sanitizeName(name, runtime.GOOS == "windows")
+}
+
+// sanitizeName returns the slash separated name of a received file as a slash separated path local to the output
+// directory. Absolute names and names traversing out of the directory with ".." are rejected with ErrUnsafeName,
+// empty and "." elements are dropped. On Windows backslashes separate elements like slashes, and elements are made
+// valid names: reserved characters are replaced with '_', trailing dots and spaces are trimmed and reserved device
+// names, e.g. CON or LPT1, are prefixed with '_'.
+func sanitizeName(name string, windows bool) (string, error) {
+	slashed := name
+	if windows {
+		slashed = strings.ReplaceAll(name, `\`, "/")
+	}
+	if strings.HasPrefix(slashed, "/") || (windows && len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: '%s' is absolute", ErrUnsafeName, name)
+	}
+	var elems []string
+	for _, elem := range strings.Split(slashed, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: '%s' is outside of the output directory", ErrUnsafeName, name)
+		}
+		if windows {
+			elem = windowsName(elem)
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) == 0 {
+		return "", fmt.Errorf("%w: '%s' names no file", ErrUnsafeName, name)
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// windowsName returns the path element as a valid name on Windows.
+func windowsName(elem string) string {
+	elem = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, elem)
+	// Windows drops trailing dots and spaces, such that "..." would name the parent directory.
+	elem = strings.TrimRight(elem, ". ")
+	if elem == "" {
+		return "_"
+	}
+	stem, _, _ := strings.Cut(elem, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return "_" + elem
+	}
+	return elem
+}
@@ -0,0 +1,189 @@
+package file_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/klauspost/pgzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"notes.txt":          "notes.txt",
+		"docs/notes.txt":     "docs/notes.txt",
+		"./docs//notes.txt":  "docs/notes.txt",
+		"docs/":              "docs",
+		"docs/./drafts/a.md": "docs/drafts/a.md",
+	} {
+		sanitized, err := file.SanitizeName(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, sanitized, name)
+	}
+	for _, name := range []string{"", ".", "..", "/etc/passwd", "../secret", "docs/../../secret", "docs/.."} {
+		_, err := file.SanitizeName(name)
+		assert.ErrorIs(t, err, file.ErrUnsafeName, name)
+	}
+	t.Run("windows", func(t *testing.T) {
+		if runtime.GOOS != "windows" {
+			t.Skip("names are normalized for windows on windows")
+		}
+		for name, expected := range map[string]string{
+			`docs\notes.txt`: "docs/notes.txt",
+			"what?.txt":      "what_.txt",
+			"a<b>:c|d*.txt":  "a_b__c_d_.txt",
+			"notes.txt. ":    "notes.txt",
+			"CON":            "_CON",
+			"aux.tar.gz":     "_aux.tar.gz",
+			"lpt1 .txt":      "_lpt1 .txt",
+			"console.txt":    "console.txt",
+			"...":            "_",
+		} {
+			sanitized, err := file.SanitizeName(name)
+			assert.NoError(t, err, name)
+			assert.Equal(t, expected, sanitized, name)
+		}
+		for _, name := range []string{`..\secret`, `C:\Windows\system.ini`, "c:secret", `\\server\share`} {
+			_, err := file.SanitizeName(name)
+			assert.ErrorIs(t, err, file.ErrUnsafeName, name)
+		}
+	})
+}
+
+func TestCollision(t *testing.T) {
+	archive := func(t *testing.T, names ...string) []byte {
+		var buf bytes.Buffer
+		gw := pgzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		for _, name := range names {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len("received"))}))
+			_, err := tw.Write([]byte("received"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+		return buf.Bytes()
+	}
+	// unpack commits the files of the archive into dir, returning the names of the files committed.
+	unpack := func(t *testing.T, dir string, b []byte, opts ...file.UnpackOption) ([]string, error) {
+		unpacker, err := file.NewUnpacker(true, io.NopCloser(bytes.NewReader(b)), append(opts, file.WithOutput(file.OutputTarget{Dir: dir}))...)
+		require.NoError(t, err)
+		defer unpacker.Close()
+		var committed []string
+		for {
+			c, err := unpacker.Unpack()
+			switch {
+			case errors.Is(err, io.EOF):
+				return committed, nil
+			case errors.Is(err, file.ErrUnpackFileSkipped):
+				continue
+			case err != nil:
+				return committed, err
+			}
+			_, err = c.Commit()
+			require.NoError(t, err)
+			committed = append(committed, c.FileName())
+		}
+	}
+	existing := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, name := range []string{"notes.txt", ".bashrc", "notes (1).txt"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("existing"), 0644))
+		}
+		return dir
+	}
+	assertContents := func(t *testing.T, path, expected string) {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(b))
+	}
+
+	t.Run("prompt", func(t *testing.T) {
+		_, err := unpack(t, existing(t), archive(t, "notes.txt"))
+		assert.ErrorIs(t, err, file.ErrUnpackFileExists)
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		dir := existing(t)
+		committed, err := unpack(t, dir, archive(t, "notes.txt"), file.WithCollision(file.CollisionOverwrite))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"notes.txt"}, committed)
+		assertContents(t, filepath.Join(dir, "notes.txt"), "received")
+	})
+	t.Run("rename", func(t *testing.T) {
+		dir := existing(t)
+		committed, err := unpack(t, dir, archive(t, "notes.txt", ".bashrc", "new.txt"), file.WithCollision(file.CollisionRename))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"notes (2).txt", ".bashrc (1)", "new.txt"}, committed)
+		assertContents(t, filepath.Join(dir, "notes.txt"), "existing")
+		assertContents(t, filepath.Join(dir, "notes (2).txt"), "received")
+	})
+	t.Run("skip", func(t *testing.T) {
+		dir := existing(t)
+		committed, err := unpack(t, dir, archive(t, "notes.txt", "new.txt"), file.WithCollision(file.CollisionSkip))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"new.txt"}, committed)
+		assertContents(t, filepath.Join(dir, "notes.txt"), "existing")
+	})
+	t.Run("zip", func(t *testing.T) {
+		var zipped bytes.Buffer
+		zw := zip.NewWriter(&zipped)
+		for _, name := range []string{"./notes.txt", "docs//new.txt"} {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte("received"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		var buf bytes.Buffer
+		gw := pgzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "docs.zip",
+			Size:       int64(zipped.Len()),
+			PAXRecords: map[string]string{"PORTAL.zip": "1"},
+		}))
+		_, err := tw.Write(zipped.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		dir := existing(t)
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(&buf), file.WithOutput(file.OutputTarget{Dir: dir, ExtractZips: true}), file.WithCollision(file.CollisionRename))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		c, err := unpacker.Unpack()
+		require.NoError(t, err)
+		_, err = c.Commit()
+		require.NoError(t, err)
+		assertContents(t, filepath.Join(dir, "notes.txt"), "existing")
+		assertContents(t, filepath.Join(dir, "notes (2).txt"), "received")
+		assertContents(t, filepath.Join(dir, "docs", "new.txt"), "received")
+	})
+	t.Run("parse", func(t *testing.T) {
+		for _, name := range file.Collisions {
+			collision, err := file.ParseCollision(name)
+			require.NoError(t, err)
+			assert.Equal(t, name, collision.String())
+		}
+		_, err := file.ParseCollision("clobber")
+		assert.Error(t, err)
+	})
+	t.Run("traversal", func(t *testing.T) {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "out")
+		require.NoError(t, os.Mkdir(dir, 0755))
+		_, err := unpack(t, dir, archive(t, "../escaped.txt"))
+		assert.ErrorIs(t, err, file.ErrUnsafeName)
+		assert.NoFileExists(t, filepath.Join(parent, "escaped.txt"))
+	})
+}
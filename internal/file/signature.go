@@ -0,0 +1,132 @@
+package file
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// signaturePAXRecord holds the base64 encoded ed25519 signature of the contents of a file, see signedMessage.
+const signaturePAXRecord = "PORTAL.signature"
+
+// ErrSignatureInvalid is returned when a received file is not signed by the key it is verified against, or not
+// signed at all. Files failing verification are removed rather than committed.
+var ErrSignatureInvalid = errors.New("invalid signature")
+
+// ------------------------------------------------------ Signing ------------------------------------------------------
+
+// WithSignature signs the contents of each packed file, or of the packed text message, with the provided key, such
+// that receivers can verify the files were sent by the holder of the key, see WithSignatureVerification. Files are
+// read twice, once to sign them before their header is written.
+func WithSignature(key ed25519.PrivateKey) PackOption {
+	return func(o *packOptions) {
+		o.signingKey = key
+	}
+}
+
+// WithSignatureVerification verifies that each received file is signed by the holder of the private key of the
+// provided public key, failing closed: unsigned files and files whose signature does not match are removed, and
+// committing them fails with ErrSignatureInvalid. Signatures are verified per file, saving the archive as a single
+// file is not supported.
+func WithSignatureVerification(key ed25519.PublicKey) UnpackOption {
+	return func(u *Unpacker) {
+		u.verifyingKey = key
+	}
+}
+
+// signedMessage returns the message signed for a file, binding the SHA-256 digest of its contents to its name
+// in the archive, such that signed files cannot be swapped.
+func signedMessage(name string, digest []byte) []byte {
+	return append([]byte("portal-signature-v1\x00"+name+"\x00"), digest...)
+}
+
+// signFile returns the signature of the file at path, packed under the provided name.
+func signFile(key ed25519.PrivateKey, name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return "", fmt.Errorf("signing %s: %w", name, err)
+	}
+	return sign(key, name, digest.Sum(nil)), nil
+}
+
+// sign returns the signature of the contents with the provided SHA-256 digest, packed under the provided name.
+func sign(key ed25519.PrivateKey, name string, digest []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(name, digest)))
+}
+
+// verifySignature verifies the signature of the received file against the SHA-256 digest of its contents.
+// Files are not verified if the committer has no verifying key.
+func (c *committer) verifySignature(digest []byte) error {
+	if c.verifyingKey == nil {
+		return nil
+	}
+	record, ok := c.header.PAXRecords[signaturePAXRecord]
+	if !ok {
+		return fmt.Errorf("%w: %s is not signed", ErrSignatureInvalid, c.header.Name)
+	}
+	signature, err := base64.StdEncoding.DecodeString(record)
+	if err != nil || !ed25519.Verify(c.verifyingKey, signedMessage(c.header.Name, digest), signature) {
+		return fmt.Errorf("%w: %s", ErrSignatureInvalid, c.header.Name)
+	}
+	return nil
+}
+
+// ------------------------------------------------------- Keys --------------------------------------------------------
+
+// ReadPrivateKey reads a PEM encoded PKCS #8 ed25519 private key, e.g. generated with
+// openssl genpkey -algorithm ed25519.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+// ReadPublicKey reads a PEM encoded PKIX ed25519 public key, e.g. extracted with openssl pkey -pubout.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,155 @@
+package file_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	src := filepath.Join(t.TempDir(), "artifact.bin")
+	require.NoError(t, os.WriteFile(src, bytes.Repeat([]byte("signed artifact "), 4096), 0644))
+
+	files, err := file.ReadFiles([]string{src})
+	require.NoError(t, err)
+	payload, _, err := file.PackFiles(files, file.WithSignature(priv))
+	require.NoError(t, err)
+	defer os.Remove(payload.Name())
+	defer payload.Close()
+	archive, err := io.ReadAll(payload)
+	require.NoError(t, err)
+
+	// unpack commits the files of the archive into a new directory, returning it along with the first error.
+	unpack := func(t *testing.T, archive []byte, key ed25519.PublicKey) (string, error) {
+		dst := t.TempDir()
+		unpacker, err := file.NewUnpacker(false, io.NopCloser(bytes.NewReader(archive)),
+			file.WithOutput(file.OutputTarget{Dir: dst}), file.WithSignatureVerification(key))
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				return dst, nil
+			}
+			if err != nil {
+				return dst, err
+			}
+			if _, err := c.Commit(); err != nil {
+				return dst, err
+			}
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		dst, err := unpack(t, archive, pub)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dst, "artifact.bin"))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		// the signed header is kept, the contents are replaced.
+		gr, err := gzip.NewReader(bytes.NewReader(archive))
+		require.NoError(t, err)
+		header, err := tar.NewReader(gr).Next()
+		require.NoError(t, err)
+		tampered := bytes.Repeat([]byte("malicious artifact "), 4096)[:header.Size]
+
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(tampered)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		dst, err := unpack(t, buf.Bytes(), pub)
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+		// files failing verification are not kept, not even as partial files.
+		assert.NoFileExists(t, filepath.Join(dst, "artifact.bin"))
+		assert.NoFileExists(t, filepath.Join(dst, "artifact.bin"+file.PARTIAL_FILE_SUFFIX))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		dst, err := unpack(t, archive, other)
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+		assert.NoFileExists(t, filepath.Join(dst, "artifact.bin"))
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		files, err := file.ReadFiles([]string{src})
+		require.NoError(t, err)
+		unsigned, _, err := file.PackFiles(files)
+		require.NoError(t, err)
+		defer os.Remove(unsigned.Name())
+		defer unsigned.Close()
+		archive, err := io.ReadAll(unsigned)
+		require.NoError(t, err)
+		_, err = unpack(t, archive, pub)
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		const text = "signed message"
+		signed, _, err := file.PackText(text, file.WithSignature(priv))
+		require.NoError(t, err)
+		defer os.Remove(signed.Name())
+		defer signed.Close()
+		read, ok, err := file.ReadText(signed, file.WithSignatureVerification(pub))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, text, read)
+
+		other, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		_, _, err = file.ReadText(signed, file.WithSignatureVerification(other))
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+
+		unsigned, _, err := file.PackText(text)
+		require.NoError(t, err)
+		defer os.Remove(unsigned.Name())
+		defer unsigned.Close()
+		_, _, err = file.ReadText(unsigned, file.WithSignatureVerification(pub))
+		assert.ErrorIs(t, err, file.ErrSignatureInvalid)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		dir := t.TempDir()
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		require.NoError(t, err)
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		privPath, pubPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem")
+		require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600))
+		require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644))
+
+		readPriv, err := file.ReadPrivateKey(privPath)
+		require.NoError(t, err)
+		assert.Equal(t, priv, readPriv)
+		readPub, err := file.ReadPublicKey(pubPath)
+		require.NoError(t, err)
+		assert.Equal(t, pub, readPub)
+
+		_, err = file.ReadPublicKey(privPath)
+		assert.Error(t, err)
+		_, err = file.ReadPrivateKey(src)
+		assert.Error(t, err)
+	})
+}
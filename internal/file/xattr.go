@@ -0,0 +1,99 @@
+package file
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// xattrPAXPrefix prefixes the PAX records holding the extended attributes of a file, as written by GNU tar
+// and bsdtar, such that the archives carry their attributes between these tools too.
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// ErrXattrsSkipped is reported when an extended attribute of a file cannot be restored, due to lacking
+// privileges or the platform or filesystem not supporting it.
+var ErrXattrsSkipped = errors.New("extended attribute not restored")
+
+// DEFAULT_XATTR_NAMESPACES are the namespaces of the extended attributes restored unless others are allowed, see
+// WithPreserveXattrs: the attributes of the user and the POSIX ACLs on Linux.
+var DEFAULT_XATTR_NAMESPACES = []string{"user.", "system.posix_acl_"}
+
+var errXattrsUnsupported = errors.New("extended attributes are not supported on this platform or filesystem")
+
+// WithXattrs carries the extended attributes of the packed files, along with the POSIX ACLs held in the
+// system.posix_acl_* attributes on Linux, see WithPreserveXattrs. Platforms without extended attributes,
+// see XATTRS_SUPPORTED, pack none.
+func WithXattrs() PackOption {
+	return func(o *packOptions) {
+		o.xattrs = true
+	}
+}
+
+// WithPreserveXattrs restores the extended attributes carried in the archive on the unpacked files. Only the
+// attributes whose names start with one of the namespaces are restored, DEFAULT_XATTR_NAMESPACES if none are
+// provided, as attributes such as security.capability grant privileges. When an attribute is not restored the
+// file is kept without it, and warn is called with a ErrXattrsSkipped, if provided.
+func WithPreserveXattrs(warn func(error), namespaces ...string) UnpackOption {
+	if len(namespaces) == 0 {
+		namespaces = DEFAULT_XATTR_NAMESPACES
+	}
+	return func(u *Unpacker) {
+		u.preserveXattrs = true
+		u.onXattrSkip = warn
+		u.xattrNamespaces = namespaces
+	}
+}
+
+// addXattrs adds the extended attributes of the file at path to the header.
+func addXattrs(header *tar.Header, path string) error {
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return fmt.Errorf("reading extended attributes of %s: %w", header.Name, err)
+	}
+	for key, value := range xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[xattrPAXPrefix+key] = string(value)
+	}
+	return nil
+}
+
+// applyXattrs restores the extended attributes of the header on the committed file, if extended attributes are
+// preserved. Lacking privileges or support is reported as a ErrXattrsSkipped, rather than failing the commit.
+func (c *committer) applyXattrs(path string) error {
+	if !c.preserveXattrs {
+		return nil
+	}
+	// attributes are restored in order, such that the reported warnings are deterministic.
+	keys := maps.Keys(c.header.PAXRecords)
+	slices.Sort(keys)
+	for _, record := range keys {
+		key, ok := strings.CutPrefix(record, xattrPAXPrefix)
+		if !ok || key == "" {
+			continue
+		}
+		if !slices.ContainsFunc(c.xattrNamespaces, func(ns string) bool { return strings.HasPrefix(key, ns) }) {
+			if c.onXattrSkip != nil {
+				c.onXattrSkip(fmt.Errorf("%w %s for %s: not in the restored namespaces", ErrXattrsSkipped, key, c.name))
+			}
+			continue
+		}
+		err := setXattr(path, key, []byte(c.header.PAXRecords[record]))
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, os.ErrPermission) && !errors.Is(err, errXattrsUnsupported) {
+			return fmt.Errorf("restoring extended attribute %s of %s: %w", key, c.name, err)
+		}
+		if c.onXattrSkip != nil {
+			c.onXattrSkip(fmt.Errorf("%w %s for %s: %v", ErrXattrsSkipped, key, c.name, err))
+		}
+	}
+	return nil
+}
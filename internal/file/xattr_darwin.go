@@ -0,0 +1,6 @@
+package file
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is returned when getting an extended attribute the file does not have.
+const errNoXattr = unix.ENOATTR
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package file
+
+// XATTRS_SUPPORTED is whether extended attributes are carried and restored on this platform.
+const XATTRS_SUPPORTED = false
+
+// listXattrs lists no extended attributes on this platform.
+func listXattrs(string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// setXattr reports that extended attributes are not supported on this platform.
+func setXattr(string, string, []byte) error {
+	return errXattrsUnsupported
+}
@@ -0,0 +1,139 @@
+//go:build linux
+
+package file_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/klauspost/pgzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPreserveXattrs(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "frog.txt")
+	writeFile(t, path, time.Now())
+	if err := unix.Setxattr(path, "user.portal.origin", []byte("pond\x00lily"), 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("the filesystem of the temporary directory does not support extended attributes")
+	} else {
+		require.NoError(t, err)
+	}
+
+	// unpack packs the file and unpacks it into a new working directory, returning the warnings of skipped attributes.
+	unpack := func(t *testing.T, packOpts []file.PackOption, unpackOpts ...file.UnpackOption) (string, []error) {
+		files, err := file.ReadFiles([]string{path})
+		require.NoError(t, err)
+		payload, _, err := file.PackFiles(files, packOpts...)
+		require.NoError(t, err)
+		defer os.Remove(payload.Name())
+		wd := t.TempDir()
+		chdir(t, wd)
+		var warnings []error
+		unpacker, err := file.NewUnpacker(false, payload, append(unpackOpts,
+			file.WithPreserveXattrs(func(err error) { warnings = append(warnings, err) }))...)
+		require.NoError(t, err)
+		defer unpacker.Close()
+		for {
+			c, err := unpacker.Unpack()
+			if errors.Is(err, io.EOF) {
+				return filepath.Join(wd, "frog.txt"), warnings
+			}
+			require.NoError(t, err)
+			_, err = c.Commit()
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("preserved", func(t *testing.T) {
+		received, warnings := unpack(t, []file.PackOption{file.WithXattrs()})
+		assert.Empty(t, warnings)
+		value := make([]byte, 64)
+		n, err := unix.Getxattr(received, "user.portal.origin", value)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("pond\x00lily"), value[:n])
+	})
+	t.Run("not carried", func(t *testing.T) {
+		received, warnings := unpack(t, nil)
+		assert.Empty(t, warnings)
+		_, err := unix.Getxattr(received, "user.portal.origin", nil)
+		assert.ErrorIs(t, err, unix.ENODATA)
+	})
+}
+
+// unpackXattrs unpacks a file carrying the extended attributes of the PAX records, restoring the attributes of the
+// namespaces, and returns the warnings of skipped attributes.
+func unpackXattrs(t *testing.T, records map[string]string, namespaces ...string) []error {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := pgzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	data := []byte("A frog walks into a bank...")
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       "frog.txt",
+		Mode:       0644,
+		Size:       int64(len(data)),
+		PAXRecords: records,
+	}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	dst := t.TempDir()
+	chdir(t, dst)
+	var warnings []error
+	unpacker, err := file.NewUnpacker(false, io.NopCloser(&buf),
+		file.WithPreserveXattrs(func(err error) { warnings = append(warnings, err) }, namespaces...))
+	require.NoError(t, err)
+	defer unpacker.Close()
+	c, err := unpacker.Unpack()
+	require.NoError(t, err)
+	_, err = c.Commit()
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(dst, "frog.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+	return warnings
+}
+
+func TestPreserveXattrsSkipped(t *testing.T) {
+	// the namespace of the attribute is unknown to Linux, setting it is unsupported on any filesystem.
+	warnings := unpackXattrs(t, map[string]string{"SCHILY.xattr.frog.portal": "lily"}, "frog.")
+	require.Len(t, warnings, 1)
+	assert.ErrorIs(t, warnings[0], file.ErrXattrsSkipped)
+}
+
+func TestPreserveXattrsNamespaces(t *testing.T) {
+	records := map[string]string{
+		"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+		"SCHILY.xattr.trusted.portal":      "lily",
+	}
+	t.Run("default", func(t *testing.T) {
+		// attributes outside the user and POSIX ACL namespaces are not restored, even with privileges.
+		warnings := unpackXattrs(t, records)
+		require.Len(t, warnings, 2)
+		for _, warning := range warnings {
+			assert.ErrorIs(t, warning, file.ErrXattrsSkipped)
+			assert.ErrorContains(t, warning, "not in the restored namespaces")
+		}
+	})
+	t.Run("allowed", func(t *testing.T) {
+		// attributes of the allowed namespaces are restored, unsupported here, others are not.
+		warnings := unpackXattrs(t, map[string]string{"SCHILY.xattr.frog.portal": "lily", "SCHILY.xattr.trusted.portal": "lily"}, "frog.")
+		require.Len(t, warnings, 2)
+		assert.NotContains(t, warnings[0].Error(), "not in the restored namespaces")
+		assert.ErrorContains(t, warnings[1], "trusted.portal for frog.txt: not in the restored namespaces")
+	})
+}
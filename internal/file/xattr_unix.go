@@ -0,0 +1,68 @@
+//go:build linux || darwin
+
+package file
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// XATTRS_SUPPORTED is whether extended attributes are carried and restored on this platform.
+const XATTRS_SUPPORTED = true
+
+// listXattrs returns the extended attributes of the named file, POSIX ACLs included as the system.posix_acl_*
+// attributes on Linux. Filesystems without extended attributes list none.
+func listXattrs(name string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(name, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Listxattr(name, buf); err != nil {
+		return nil, err
+	}
+	xattrs := map[string][]byte{}
+	for _, key := range bytes.Split(buf[:size], []byte{0}) {
+		if len(key) == 0 {
+			continue
+		}
+		value, err := getXattr(name, string(key))
+		// attributes removed while listing are skipped.
+		if errors.Is(err, errNoXattr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(key)] = value
+	}
+	return xattrs, nil
+}
+
+// getXattr returns the value of the extended attribute of the named file.
+func getXattr(name, key string) ([]byte, error) {
+	size, err := unix.Getxattr(name, key, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	if size, err = unix.Getxattr(name, key, value); err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// setXattr sets the extended attribute of the named file. Attributes the filesystem does not support, or that
+// require privileges, are reported as errXattrsUnsupported and os.ErrPermission.
+func setXattr(name, key string, value []byte) error {
+	err := unix.Setxattr(name, key, value, 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return errXattrsUnsupported
+	}
+	return err
+}
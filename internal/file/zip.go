@@ -0,0 +1,236 @@
+// zip.go specifies the packing of sent directories as zip files, which unpack more faithfully than tar archives
+// of Unix metadata on receivers of other platforms, e.g. Windows.
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ZIP_EXTENSION is appended to the name of directories packed WithDirsAsZip.
+const ZIP_EXTENSION = ".zip"
+
+// MAX_SYMLINK_TARGET_BYTES is the maximum length of the target of a symlink extracted from a zip file.
+const MAX_SYMLINK_TARGET_BYTES = 4096
+
+// zipPAXRecord marks the regular files of the archive that are directories packed WithDirsAsZip.
+const zipPAXRecord = "PORTAL.zip"
+
+// ErrZipEntryUnsafe is returned when extracting a zip file holding an entry outside of its output directory.
+var ErrZipEntryUnsafe = errors.New("zip entry outside of the output directory")
+
+// WithDirsAsZip packs each sent directory as a single zip file, named after the directory, rather than as the
+// objects of the directory. Receivers save the zip file, unless extracting it, see ExtractAlways.
+func WithDirsAsZip() PackOption {
+	return func(o *packOptions) {
+		o.dirsAsZip = true
+	}
+}
+
+// zipDirectory packs the directory into a zip file in a temporary file, returning its path. The objects of the
+// directory are walked as when packing a tar archive, such that excludes, modification times and the file limit
+// apply identically, and are converted into zip entries named relative to the parent of the directory.
+func zipDirectory(dir *os.File, opts *packOptions) (string, error) {
+	zipFile, err := os.CreateTemp(os.TempDir(), SEND_TEMP_FILE_NAME_PREFIX)
+	if err != nil {
+		return "", err
+	}
+	defer zipFile.Close()
+
+	walkOpts := packOptions{
+		modifiedSince: opts.modifiedSince,
+		excludes:      opts.excludes,
+		maxFiles:      opts.maxFiles,
+		files:         opts.files,
+		zipSymlinks:   true,
+	}
+	pr, pw := io.Pipe()
+	walked := make(chan error, 1)
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := addToTarArchive(tw, dir, &walkOpts)
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+		walked <- err
+	}()
+
+	bw := bufio.NewWriter(zipFile)
+	err = tarToZip(zip.NewWriter(bw), tar.NewReader(pr))
+	pr.CloseWithError(err)
+	if walkErr := <-walked; walkErr != nil {
+		err = walkErr
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		os.Remove(zipFile.Name())
+		return "", err
+	}
+	opts.files = walkOpts.files
+	return zipFile.Name(), nil
+}
+
+// symlinkWithin returns the target of the symlink at link, with forward slashes, if it is relative and resolves
+// within the directory, such that it is zipped as a symlink rather than as its pointee. Returns "" otherwise.
+func symlinkWithin(dir, link string) string {
+	target, err := os.Readlink(link)
+	if err != nil || filepath.IsAbs(target) {
+		return ""
+	}
+	rel, err := filepath.Rel(dir, filepath.Join(filepath.Dir(link), target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return ""
+	}
+	return filepath.ToSlash(target)
+}
+
+// tarToZip converts the objects of the tar archive into the entries of the zip file, closing the zip file.
+// Symlinks are stored as entries holding their target, as zip tools do.
+func tarToZip(zw *zip.Writer, tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		zh, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return err
+		}
+		zh.Name = header.Name
+		switch {
+		case header.Typeflag == tar.TypeDir:
+			zh.Name += "/"
+		case header.Typeflag == tar.TypeSymlink:
+			zh.Method = zip.Store
+		case !IsCompressed(header.Name):
+			zh.Method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(zh)
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			_, err = io.WriteString(w, header.Linkname)
+		} else {
+			_, err = io.Copy(w, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// commitZip extracts the zip file read from the archive into the working directory of the committer, verifying
+// its signature before anything is extracted. Returns the number of bytes extracted.
+func (c *committer) commitZip() (int64, error) {
+	temp, err := os.CreateTemp(os.TempDir(), RECEIVE_TEMP_FILE_NAME_PREFIX)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, digest), c.tr); err != nil {
+		return 0, err
+	}
+	if err := c.verifySignature(digest.Sum(nil)); err != nil {
+		return 0, err
+	}
+	fi, err := temp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	zr, err := zip.NewReader(temp, fi.Size())
+	if err != nil {
+		return 0, fmt.Errorf("reading zip file %s: %w", c.name, err)
+	}
+	var written int64
+	for _, f := range zr.File {
+		n, err := c.extractZipEntry(f)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// extractZipEntry extracts the entry of a zip file into the working directory of the committer. Entry names are
+// sanitized like the names of the archive, and files colliding with existing files are resolved alike, failing
+// with a ErrUnpackFileExists rather than prompting.
+func (c *committer) extractZipEntry(f *zip.File) (int64, error) {
+	name, err := SanitizeName(f.Name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrZipEntryUnsafe, err)
+	}
+	if f.FileInfo().IsDir() {
+		return 0, os.MkdirAll(filepath.Join(c.cwd, filepath.FromSlash(name)), 0755)
+	}
+	name, err = c.resolveCollision(c.cwd, name)
+	switch {
+	case errors.Is(err, ErrUnpackFileSkipped):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("extracting %s: %w", name, err)
+	}
+	path := filepath.Join(c.cwd, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	if f.Mode()&os.ModeSymlink != 0 {
+		return 0, extractZipSymlink(f, name, path)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	partial := path + PARTIAL_FILE_SUFFIX
+	n, err := writePartial(partial, r, int64(f.UncompressedSize64))
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		if !c.keepPartial {
+			os.Remove(partial)
+		}
+		return 0, err
+	}
+	return n, os.Chtimes(path, f.Modified, f.Modified)
+}
+
+// extractZipSymlink creates the symlink of the zip entry named name at dst. Targets are relative and resolve
+// within the output directory, such that later entries cannot be extracted through the symlink to elsewhere.
+func extractZipSymlink(f *zip.File, name, dst string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, MAX_SYMLINK_TARGET_BYTES+1))
+	if err != nil {
+		return err
+	}
+	target := string(b)
+	resolved := path.Join(path.Dir(name), target)
+	if len(b) > MAX_SYMLINK_TARGET_BYTES || target == "" || path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) ||
+		resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("%w: symlink %s to %s", ErrZipEntryUnsafe, name, target)
+	}
+	return os.Symlink(filepath.FromSlash(target), dst)
+}
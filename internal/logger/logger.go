@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/netip"
+	"time"
 
 	"github.com/tomasen/realip"
 	"go.uber.org/zap"
@@ -24,11 +26,33 @@ func FromContext(ctx context.Context) (*zap.Logger, error) {
 	return logger, nil
 }
 
-func Middleware(baseLogger *zap.Logger) func(http.Handler) http.Handler {
+// MiddlewareOption configures the logging middleware.
+type MiddlewareOption func(*middlewareOptions)
+
+type middlewareOptions struct {
+	anonymizeIPs bool
+}
+
+// WithAnonymizedIPs logs the IP addresses of clients anonymized, see AnonymizeIP.
+func WithAnonymizedIPs(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.anonymizeIPs = enabled
+	}
+}
+
+func Middleware(baseLogger *zap.Logger, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	var o middlewareOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := realip.FromRequest(r)
+			if o.anonymizeIPs {
+				ip = AnonymizeIP(ip)
+			}
 			logger := baseLogger.With(
-				zap.String("request_ip", realip.FromRequest(r)),
+				zap.String("request_ip", ip),
 				zap.String("endpoint", r.URL.Path),
 			)
 			next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), logger)))
@@ -36,9 +60,103 @@ func Middleware(baseLogger *zap.Logger) func(http.Handler) http.Handler {
 	}
 }
 
-func New() *zap.Logger {
+// AnonymizeIP masks the host part of the IP address, zeroing the last octet of IPv4 addresses and all but the
+// first 48 bits of IPv6 addresses. Values that are not IP addresses, e.g. certificate identities, are returned as is.
+func AnonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.Addr().String()
+}
+
+// ------------------------------------------------------ Options ------------------------------------------------------
+
+type options struct {
+	sampling *zap.SamplingConfig
+	level    *zapcore.Level
+}
+
+// Option configures the logger constructed by New.
+type Option func(*options)
+
+// WithSampling samples log entries below error level, see NewSampler.
+func WithSampling(initial, thereafter int) Option {
+	return func(o *options) {
+		o.sampling = &zap.SamplingConfig{Initial: initial, Thereafter: thereafter}
+	}
+}
+
+// WithLevel only logs entries at or above the provided level, rather than at or above info level.
+func WithLevel(level zapcore.Level) Option {
+	return func(o *options) {
+		o.level = &level
+	}
+}
+
+// -------------------------------------------------------- New --------------------------------------------------------
+
+func New(opts ...Option) *zap.Logger {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 	cfg := zap.NewProductionConfig()
 	cfg.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
-	logger, _ := cfg.Build()
+	if o.level != nil {
+		cfg.Level = zap.NewAtomicLevelAt(*o.level)
+	}
+
+	var zapOpts []zap.Option
+	if o.sampling != nil {
+		// Replace the default production sampling, which also samples errors.
+		cfg.Sampling = nil
+		zapOpts = append(zapOpts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return NewSampler(core, o.sampling.Initial, o.sampling.Thereafter)
+		}))
+	}
+	logger, _ := cfg.Build(zapOpts...)
 	return logger
 }
+
+// NewSampler wraps the provided core such that entries below error level are sampled. Every second,
+// the first initial entries with the same level and message are logged, after which every
+// thereafter-th entry is logged. Entries at error level or above are never sampled.
+func NewSampler(core zapcore.Core, initial, thereafter int) zapcore.Core {
+	sampled := zapcore.NewSamplerWithOptions(
+		levelFilterCore{Core: core, enabled: func(l zapcore.Level) bool { return l < zapcore.ErrorLevel }},
+		time.Second, initial, thereafter,
+	)
+	unsampled := levelFilterCore{Core: core, enabled: func(l zapcore.Level) bool { return l >= zapcore.ErrorLevel }}
+	return zapcore.NewTee(sampled, unsampled)
+}
+
+// levelFilterCore is a core that only handles entries of the enabled levels.
+type levelFilterCore struct {
+	zapcore.Core
+	enabled zap.LevelEnablerFunc
+}
+
+func (c levelFilterCore) Enabled(l zapcore.Level) bool {
+	return c.enabled(l) && c.Core.Enabled(l)
+}
+
+func (c levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelFilterCore{Core: c.Core.With(fields), enabled: c.enabled}
+}
+
+func (c levelFilterCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(entry.Level) {
+		return ce
+	}
+	return c.Core.Check(entry, ce)
+}
@@ -0,0 +1,39 @@
+package logger_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSampler(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	lgr := zap.New(logger.NewSampler(core, 2, 10))
+
+	for i := 0; i < 100; i++ {
+		lgr.Info("mailbox allocated")
+		lgr.Error("relay failed")
+	}
+
+	infos := logs.FilterMessage("mailbox allocated").Len()
+	assert.Greater(t, infos, 0)
+	assert.Less(t, infos, 100)
+	assert.Equal(t, 100, logs.FilterMessage("relay failed").Len())
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	for ip, anonymized := range map[string]string{
+		"203.0.113.7":              "203.0.113.0",
+		"::ffff:203.0.113.7":       "203.0.113.0",
+		"2001:db8:abcd:12:1:2:3:4": "2001:db8:abcd::",
+		"fe80::1%eth0":             "fe80::",
+		"cert:client.example.com":  "cert:client.example.com",
+		"":                         "",
+	} {
+		assert.Equal(t, anonymized, logger.AnonymizeIP(ip), ip)
+	}
+}
@@ -0,0 +1,252 @@
+// mdns.go specifies a minimal multicast DNS (RFC 6762) responder and resolver, such that receivers find the relay
+// a sender serves on the local network without a rendezvous server.
+//
+// The sender answers queries for its instance of SERVICE with an SRV record of the port of its relay. Receivers send
+// one-shot queries from an ephemeral port, which are answered by unicast to the querier, and reach the relay on the
+// address the answer was sent from.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SERVICE is the service the relays of senders on the local network are advertised as.
+const SERVICE = "_portal._tcp.local."
+
+// DEFAULT_QUERY_INTERVAL is the time waited for answers before a query is sent again.
+const DEFAULT_QUERY_INTERVAL = time.Second
+
+// MDNS_PORT is the port multicast DNS is served on.
+const MDNS_PORT = 5353
+
+// time in seconds answers are cached for, as recommended for SRV records.
+const recordTTL = 120
+
+// maximum size of a multicast DNS message.
+const maxMessageBytes = 9000
+
+var groups = []*net.UDPAddr{
+	{IP: net.IPv4(224, 0, 0, 251), Port: MDNS_PORT},
+	{IP: net.ParseIP("ff02::fb"), Port: MDNS_PORT},
+}
+
+// InstanceName returns the fully qualified name of the instance of SERVICE.
+func InstanceName(instance string) string {
+	return instance + "." + SERVICE
+}
+
+// Advertise answers queries for the instance of SERVICE with the provided port on the local network until the
+// context is done, over IPv4 and IPv6 if available. Returns once the responder joined the multicast groups.
+func Advertise(ctx context.Context, instance string, port int) error {
+	name, err := dnsmessage.NewName(InstanceName(instance))
+	if err != nil {
+		return fmt.Errorf("invalid instance %q: %w", instance, err)
+	}
+	var (
+		conns []*net.UDPConn
+		errs  []error
+	)
+	for _, group := range groups {
+		c, err := net.ListenMulticastUDP(network(group), nil, group)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		conns = append(conns, c)
+	}
+	if len(conns) == 0 {
+		return fmt.Errorf("joining the multicast DNS groups: %w", errors.Join(errs...))
+	}
+	for i, c := range conns {
+		go respond(c, groups[i], name, uint16(port))
+	}
+	go func() {
+		<-ctx.Done()
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	return nil
+}
+
+// respond answers the queries for the name read from c until c is closed. Queries sent from the multicast DNS port
+// are answered to the group, one-shot queries are answered to the querier.
+func respond(c *net.UDPConn, group *net.UDPAddr, name dnsmessage.Name, port uint16) {
+	b := make([]byte, maxMessageBytes)
+	for {
+		n, src, err := c.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		oneShot := src.Port != MDNS_PORT
+		answer, ok := Answer(b[:n], name, port, oneShot)
+		if !ok {
+			continue
+		}
+		dst := src
+		if !oneShot {
+			dst = group
+		}
+		c.WriteToUDP(answer, dst) //nolint:errcheck
+	}
+}
+
+// Answer returns the answer to the query with the SRV record of the name, or false if the query does not ask for
+// the name. Answers to one-shot queries repeat their id and questions, as expected by unicast DNS resolvers.
+func Answer(query []byte, name dnsmessage.Name, port uint16, oneShot bool) ([]byte, bool) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil || header.Response {
+		return nil, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+	asked := false
+	for _, q := range questions {
+		if strings.EqualFold(q.Name.String(), name.String()) && (q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeALL) {
+			asked = true
+		}
+	}
+	if !asked {
+		return nil, false
+	}
+
+	h := dnsmessage.Header{Response: true, Authoritative: true}
+	if oneShot {
+		h.ID = header.ID
+	}
+	b := dnsmessage.NewBuilder(nil, h)
+	b.EnableCompression()
+	if oneShot {
+		if err := b.StartQuestions(); err != nil {
+			return nil, false
+		}
+		for _, q := range questions {
+			if err := b.Question(q); err != nil {
+				return nil, false
+			}
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, false
+	}
+	target, err := dnsmessage.NewName(strings.TrimSuffix(name.String(), SERVICE) + "local.")
+	if err != nil {
+		return nil, false
+	}
+	rh := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: recordTTL}
+	if err := b.SRVResource(rh, dnsmessage.SRVResource{Port: port, Target: target}); err != nil {
+		return nil, false
+	}
+	answer, err := b.Finish()
+	return answer, err == nil
+}
+
+// Lookup queries the local network for the instance of SERVICE until it is answered or the context is done,
+// returning the address of the relay it advertises.
+func Lookup(ctx context.Context, instance string) (string, error) {
+	name, err := dnsmessage.NewName(InstanceName(instance))
+	if err != nil {
+		return "", fmt.Errorf("invalid instance %q: %w", instance, err)
+	}
+	query, err := Query(name)
+	if err != nil {
+		return "", err
+	}
+	c, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return "", fmt.Errorf("opening query socket: %w", err)
+	}
+	defer c.Close()
+
+	b := make([]byte, maxMessageBytes)
+	for {
+		var errs []error
+		for _, group := range groups {
+			if _, err := c.WriteToUDP(query, group); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		// queries are sent over the address families available.
+		if len(errs) == len(groups) {
+			return "", fmt.Errorf("sending query: %w", errors.Join(errs...))
+		}
+		deadline := time.Now().Add(DEFAULT_QUERY_INTERVAL)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := c.SetReadDeadline(deadline); err != nil {
+			return "", err
+		}
+		for {
+			n, src, err := c.ReadFromUDPAddrPort(b)
+			if err != nil {
+				break
+			}
+			if port, ok := ParseAnswer(b[:n], name); ok {
+				return netip.AddrPortFrom(src.Addr().Unmap(), port).String(), nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("no answer for %s: %w", name, err)
+		}
+	}
+}
+
+// Query returns a query for the SRV record of the name.
+func Query(name dnsmessage.Name) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// ParseAnswer returns the port of the SRV record of the name in the answer, or false if it holds none.
+func ParseAnswer(answer []byte, name dnsmessage.Name) (uint16, bool) {
+	var p dnsmessage.Parser
+	header, err := p.Start(answer)
+	if err != nil || !header.Response {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+	for {
+		rh, err := p.AnswerHeader()
+		if err != nil {
+			return 0, false
+		}
+		if rh.Type != dnsmessage.TypeSRV || !strings.EqualFold(rh.Name.String(), name.String()) {
+			if err := p.SkipAnswer(); err != nil {
+				return 0, false
+			}
+			continue
+		}
+		srv, err := p.SRVResource()
+		if err != nil {
+			return 0, false
+		}
+		return srv.Port, true
+	}
+}
+
+func network(group *net.UDPAddr) string {
+	if group.IP.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
@@ -0,0 +1,52 @@
+package mdns_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/mdns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestAnswer(t *testing.T) {
+	name := dnsmessage.MustNewName(mdns.InstanceName("portal-42"))
+	query, err := mdns.Query(name)
+	require.NoError(t, err)
+
+	answer, ok := mdns.Answer(query, name, 8080, true)
+	require.True(t, ok)
+	port, ok := mdns.ParseAnswer(answer, name)
+	require.True(t, ok)
+	assert.EqualValues(t, 8080, port)
+
+	// queries for other instances, and answers, are not answered.
+	_, ok = mdns.Answer(query, dnsmessage.MustNewName(mdns.InstanceName("portal-7")), 8080, true)
+	assert.False(t, ok)
+	_, ok = mdns.Answer(answer, name, 8080, true)
+	assert.False(t, ok)
+	_, ok = mdns.ParseAnswer(answer, dnsmessage.MustNewName(mdns.InstanceName("portal-7")))
+	assert.False(t, ok)
+}
+
+func TestLookup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("joins the multicast DNS groups of the host")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mdns.Advertise(ctx, "portal-9731", 8080); err != nil {
+		t.Skipf("multicast unavailable: %v", err)
+	}
+	addr, err := mdns.Lookup(ctx, "portal-9731")
+	if err != nil {
+		t.Skipf("multicast not looped back: %v", err)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(8080), port)
+}
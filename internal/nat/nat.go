@@ -0,0 +1,202 @@
+// nat.go specifies a lightweight STUN-like probe classifying the NAT behavior of a client.
+//
+// The client sends a probe datagram from a single UDP socket to several reflector endpoints, each of which
+// replies with the address it observed the probe from. Comparing the observed addresses reveals whether the
+// NAT maps the socket to the same public address regardless of the destination (endpoint-independent, cone)
+// or to a new address per destination (endpoint-dependent, symmetric), which defeats hole punching.
+package nat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// PROBE_REQUEST prefixes the datagram answered by reflectors with the observed address of the sender.
+const PROBE_REQUEST = "portal-nat-probe"
+
+// PROBE_REQUEST_BYTES is the size probes are padded to, at least the size of any reply, such that reflectors never
+// answer with more bytes than they received and cannot amplify probes sent from spoofed addresses.
+const PROBE_REQUEST_BYTES = maxReplyBytes
+
+// DEFAULT_PROBE_TIMEOUT is the time waited for each reflector to answer.
+const DEFAULT_PROBE_TIMEOUT = 2 * time.Second
+
+// maximum size of a probe reply, an address in its textual form.
+const maxReplyBytes = 64
+
+// Type is the classified NAT behavior of a client.
+type Type int
+
+const (
+	Unknown             Type = iota // Behind a NAT of unknown mapping behavior, fewer than two reflectors answered
+	Open                            // Not behind a NAT, the observed address is a local address
+	EndpointIndependent             // Behind a NAT mapping to the same address for every destination (cone)
+	EndpointDependent               // Behind a NAT mapping to a new address for every destination (symmetric)
+	Blocked                         // No reflector answered, UDP is likely blocked by a firewall
+)
+
+func (t Type) String() string {
+	switch t {
+	case Open:
+		return "no NAT"
+	case EndpointIndependent:
+		return "endpoint-independent NAT (cone)"
+	case EndpointDependent:
+		return "endpoint-dependent NAT (symmetric)"
+	case Blocked:
+		return "UDP blocked"
+	default:
+		return "unknown NAT"
+	}
+}
+
+// DirectFeasible reports whether peers behind this type of NAT can be reached directly, rather than relayed.
+func (t Type) DirectFeasible() bool {
+	return t == Open || t == EndpointIndependent
+}
+
+// Result is the outcome of probing the reflectors.
+type Result struct {
+	Type Type
+	// Mapped are the addresses observed by the reflectors that answered, in order of the reflectors.
+	Mapped []netip.AddrPort
+}
+
+// Probe classifies the NAT behavior of the client by probing the provided reflector addresses from a single
+// UDP socket, waiting at most timeout for each reflector. Reflectors that do not answer are skipped.
+func Probe(ctx context.Context, reflectors []string, timeout time.Duration) (Result, error) {
+	if len(reflectors) == 0 {
+		return Result{}, errors.New("no reflectors to probe")
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("opening probe socket: %w", err)
+	}
+	defer pc.Close()
+
+	var mapped []netip.AddrPort
+	for _, reflector := range reflectors {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		addr, err := probe(ctx, pc, reflector, timeout)
+		if err != nil {
+			continue
+		}
+		mapped = append(mapped, addr)
+	}
+	local, err := localAddrs()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Type: Classify(mapped, local), Mapped: mapped}, nil
+}
+
+// probe sends a probe to the reflector, returning the address the reflector observed.
+func probe(ctx context.Context, pc *net.UDPConn, reflector string, timeout time.Duration) (netip.AddrPort, error) {
+	raddr, err := net.ResolveUDPAddr("udp", reflector)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := pc.SetDeadline(deadline); err != nil {
+		return netip.AddrPort{}, err
+	}
+	req := make([]byte, PROBE_REQUEST_BYTES)
+	copy(req, PROBE_REQUEST)
+	if _, err := pc.WriteToUDP(req, raddr); err != nil {
+		return netip.AddrPort{}, err
+	}
+	buf := make([]byte, maxReplyBytes)
+	for {
+		n, from, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		// replies of previously probed reflectors answering late are ignored.
+		if !from.IP.Equal(raddr.IP) || from.Port != raddr.Port {
+			continue
+		}
+		return netip.ParseAddrPort(string(buf[:n]))
+	}
+}
+
+// Classify classifies the NAT behavior from the addresses observed by the reflectors,
+// given the local addresses of the client.
+func Classify(mapped []netip.AddrPort, local []netip.Addr) Type {
+	if len(mapped) == 0 {
+		return Blocked
+	}
+	for _, addr := range local {
+		if addr == mapped[0].Addr().Unmap() {
+			return Open
+		}
+	}
+	if len(mapped) < 2 {
+		return Unknown
+	}
+	for _, addr := range mapped[1:] {
+		if addr != mapped[0] {
+			return EndpointDependent
+		}
+	}
+	return EndpointIndependent
+}
+
+// localAddrs returns the addresses of the network interfaces of the client.
+func localAddrs() ([]netip.Addr, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("reading interface addresses: %w", err)
+	}
+	var local []netip.Addr
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipnet.IP); ok {
+				local = append(local, ip.Unmap())
+			}
+		}
+	}
+	return local, nil
+}
+
+// Reflect answers the probes received on the packet connection with the address each probe was observed
+// from, until the context is done. Probes smaller than PROBE_REQUEST_BYTES are ignored, replies are never larger
+// than the probe they answer. The packet connection is closed once the context is done.
+func Reflect(ctx context.Context, pc net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	buf := make([]byte, PROBE_REQUEST_BYTES)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("reading probe: %w", err)
+		}
+		if n < PROBE_REQUEST_BYTES || !bytes.HasPrefix(buf, []byte(PROBE_REQUEST)) {
+			continue
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		observed := netip.AddrPortFrom(udp.AddrPort().Addr().Unmap(), udp.AddrPort().Port()).String()
+		if len(observed) > n {
+			continue
+		}
+		// undeliverable replies surface as probe timeouts on the client.
+		pc.WriteTo([]byte(observed), from) //nolint:errcheck
+	}
+}
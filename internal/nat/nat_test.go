@@ -0,0 +1,147 @@
+package nat_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubReflector answers probes with a fixed mapped address, returning the address of the reflector.
+func stubReflector(t *testing.T, mapped string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n == nat.PROBE_REQUEST_BYTES && strings.HasPrefix(string(buf[:n]), nat.PROBE_REQUEST) {
+				pc.WriteTo([]byte(mapped), from) //nolint:errcheck
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// silentReflector never answers probes, returning the address of the reflector.
+func silentReflector(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	return pc.LocalAddr().String()
+}
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+	const timeout = 200 * time.Millisecond
+
+	t.Run("endpoint-independent", func(t *testing.T) {
+		result, err := nat.Probe(ctx, []string{
+			stubReflector(t, "203.0.113.7:40000"),
+			stubReflector(t, "203.0.113.7:40000"),
+		}, timeout)
+		require.NoError(t, err)
+		assert.Equal(t, nat.EndpointIndependent, result.Type)
+		assert.True(t, result.Type.DirectFeasible())
+		assert.Equal(t, []netip.AddrPort{
+			netip.MustParseAddrPort("203.0.113.7:40000"),
+			netip.MustParseAddrPort("203.0.113.7:40000"),
+		}, result.Mapped)
+	})
+	t.Run("endpoint-dependent", func(t *testing.T) {
+		result, err := nat.Probe(ctx, []string{
+			stubReflector(t, "203.0.113.7:40000"),
+			stubReflector(t, "203.0.113.7:40001"),
+		}, timeout)
+		require.NoError(t, err)
+		assert.Equal(t, nat.EndpointDependent, result.Type)
+		assert.False(t, result.Type.DirectFeasible())
+	})
+	t.Run("single reflector", func(t *testing.T) {
+		result, err := nat.Probe(ctx, []string{stubReflector(t, "203.0.113.7:40000"), silentReflector(t)}, timeout)
+		require.NoError(t, err)
+		assert.Equal(t, nat.Unknown, result.Type)
+		assert.Len(t, result.Mapped, 1)
+	})
+	t.Run("blocked fails fast", func(t *testing.T) {
+		start := time.Now()
+		result, err := nat.Probe(ctx, []string{silentReflector(t), silentReflector(t)}, timeout)
+		require.NoError(t, err)
+		assert.Equal(t, nat.Blocked, result.Type)
+		assert.Less(t, time.Since(start), 4*timeout)
+	})
+	t.Run("no NAT", func(t *testing.T) {
+		reflectCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var reflectors []string
+		for i := 0; i < 2; i++ {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go nat.Reflect(reflectCtx, pc) //nolint:errcheck
+			reflectors = append(reflectors, pc.LocalAddr().String())
+		}
+		result, err := nat.Probe(ctx, reflectors, timeout)
+		require.NoError(t, err)
+		assert.Equal(t, nat.Open, result.Type)
+		require.Len(t, result.Mapped, 2)
+		assert.Equal(t, result.Mapped[0], result.Mapped[1], "a single socket is observed at the same address")
+	})
+}
+
+func TestReflect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go nat.Reflect(ctx, pc) //nolint:errcheck
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer client.Close()
+	// reflect sends the probe, returning the size of the reply, zero if unanswered.
+	reflect := func(probe []byte) int {
+		_, err := client.WriteTo(probe, pc.LocalAddr())
+		require.NoError(t, err)
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		buf := make([]byte, 2*nat.PROBE_REQUEST_BYTES)
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+
+	padded := make([]byte, nat.PROBE_REQUEST_BYTES)
+	copy(padded, nat.PROBE_REQUEST)
+	n := reflect(padded)
+	assert.NotZero(t, n)
+	assert.LessOrEqual(t, n, len(padded), "replies are never larger than probes")
+	assert.Zero(t, reflect([]byte(nat.PROBE_REQUEST)), "unpadded probes are not answered")
+}
+
+func TestClassify(t *testing.T) {
+	local := []netip.Addr{netip.MustParseAddr("192.168.1.10")}
+	mapped := func(addrs ...string) []netip.AddrPort {
+		var m []netip.AddrPort
+		for _, addr := range addrs {
+			m = append(m, netip.MustParseAddrPort(addr))
+		}
+		return m
+	}
+	assert.Equal(t, nat.Blocked, nat.Classify(nil, local))
+	assert.Equal(t, nat.Open, nat.Classify(mapped("192.168.1.10:5000"), local))
+	assert.Equal(t, nat.Unknown, nat.Classify(mapped("203.0.113.7:5000"), local))
+	assert.Equal(t, nat.EndpointIndependent, nat.Classify(mapped("203.0.113.7:5000", "203.0.113.7:5000"), local))
+	assert.Equal(t, nat.EndpointDependent, nat.Classify(mapped("203.0.113.7:5000", "203.0.113.7:5001"), local))
+	assert.Equal(t, nat.EndpointDependent, nat.Classify(mapped("203.0.113.7:5000", "203.0.113.8:5000"), local))
+}
@@ -0,0 +1,123 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+)
+
+// RELAY_SEPARATOR separates the password from the relay address encoded into a code,
+// e.g. 1-foo-bar-baz@aeaaaadnpfzgk3dbpexgs34hhm (myrelay.io).
+const RELAY_SEPARATOR = "@"
+
+// relayEncodingVersion is the version of the encoding of relay addresses into codes.
+const relayEncodingVersion = 1
+
+// relayChecksumSize is the number of bytes of the checksum validating the encoded relay address.
+const relayChecksumSize = 2
+
+// relayAuthFlag marks a relay requiring an auth token, such that receivers can tell why they are rejected.
+const relayAuthFlag = 1 << 0
+
+// relayTLSFlag marks a relay served over TLS, such that receivers reach it with wss://.
+const relayTLSFlag = 1 << 1
+
+// maxHostLength is the maximum length of an encoded hostname.
+const maxHostLength = 253
+
+var relayEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Embed returns a code carrying the password along with the address of the relay, such that the receiver only
+// needs the code. The auth hint records whether the relay requires an auth token, the token itself is never encoded.
+// Relays served over TLS are prefixed with wss:// or https://, see conn.SplitScheme.
+func Embed(password, relay string, auth bool) (string, error) {
+	if !IsValid(password) {
+		return "", errors.New("invalid password format")
+	}
+	relay, secure := conn.SplitScheme(relay)
+	host, port := relay, 0
+	if h, p, err := net.SplitHostPort(relay); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid relay port %q", p)
+		}
+		host, port = h, n
+	}
+	if host == "" || len(host) > maxHostLength {
+		return "", fmt.Errorf("invalid relay address %q, expected a host with an optional port", relay)
+	}
+	if err := validateRelay(&url.URL{Host: relay}); err != nil {
+		return "", fmt.Errorf("invalid relay address %q: %w", relay, err)
+	}
+	var flags byte
+	if auth {
+		flags |= relayAuthFlag
+	}
+	if secure {
+		flags |= relayTLSFlag
+	}
+	b := []byte{relayEncodingVersion, flags}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	b = append(b, host...)
+	b = append(b, relayChecksum(b)...)
+	return password + RELAY_SEPARATOR + strings.ToLower(relayEncoding.EncodeToString(b)), nil
+}
+
+// IsEmbedded reports whether s is a code carrying the address of its relay rather than a bare password.
+func IsEmbedded(s string) bool {
+	return strings.Contains(s, RELAY_SEPARATOR)
+}
+
+// ParseEmbedded parses a code carrying the address of its relay into its password, relay address, and whether
+// the relay requires an auth token. Bare passwords are returned as is, without a relay. Relays served over TLS are
+// returned prefixed with wss://.
+func ParseEmbedded(s string) (password, relay string, auth bool, err error) {
+	password, encoded, embedded := strings.Cut(strings.TrimSpace(s), RELAY_SEPARATOR)
+	if !IsValid(password) {
+		return "", "", false, errors.New("invalid code, the password is malformed")
+	}
+	if !embedded {
+		return password, "", false, nil
+	}
+	b, err := relayEncoding.DecodeString(strings.ToUpper(encoded))
+	if err != nil || len(b) < 4+relayChecksumSize {
+		return "", "", false, errors.New("invalid code, the relay address is malformed")
+	}
+	payload, checksum := b[:len(b)-relayChecksumSize], b[len(b)-relayChecksumSize:]
+	if !bytes.Equal(checksum, relayChecksum(payload)) {
+		return "", "", false, errors.New("invalid code, the relay address does not match its checksum")
+	}
+	if payload[0] != relayEncodingVersion {
+		return "", "", false, fmt.Errorf("invalid code, unsupported relay encoding version %d", payload[0])
+	}
+	host := string(payload[4:])
+	relay = host
+	if port := binary.BigEndian.Uint16(payload[2:4]); port != 0 {
+		relay = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	if host == "" {
+		return "", "", false, errors.New("invalid code, empty relay host")
+	}
+	if err := validateRelay(&url.URL{Host: relay}); err != nil {
+		return "", "", false, err
+	}
+	if payload[1]&relayTLSFlag != 0 {
+		relay = "wss://" + relay
+	}
+	return password, relay, payload[1]&relayAuthFlag != 0, nil
+}
+
+// relayChecksum returns the checksum of the encoded relay address.
+func relayChecksum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:relayChecksumSize]
+}
@@ -0,0 +1,80 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbed(t *testing.T) {
+	valid := []struct {
+		relay string
+		auth  bool
+	}{
+		{relay: "myrelay.io:8080", auth: true},
+		{relay: "myrelay.io"},
+		{relay: "127.0.0.1:8080"},
+		{relay: "[::1]:8080"},
+		{relay: "wss://myrelay.io:8443"},
+	}
+	for _, tc := range valid {
+		t.Run(tc.relay, func(t *testing.T) {
+			code, err := password.Embed("1-foo-bar-baz", tc.relay, tc.auth)
+			require.NoError(t, err)
+			assert.True(t, password.IsEmbedded(code))
+			assert.True(t, strings.HasPrefix(code, "1-foo-bar-baz"+password.RELAY_SEPARATOR))
+			pass, relay, auth, err := password.ParseEmbedded(code)
+			require.NoError(t, err)
+			assert.Equal(t, "1-foo-bar-baz", pass)
+			assert.Equal(t, tc.relay, relay)
+			assert.Equal(t, tc.auth, auth)
+
+			// codes are case insensitive, e.g. when read aloud.
+			pass, encoded, _ := strings.Cut(code, password.RELAY_SEPARATOR)
+			_, relay, _, err = password.ParseEmbedded(pass + password.RELAY_SEPARATOR + strings.ToUpper(encoded))
+			require.NoError(t, err)
+			assert.Equal(t, tc.relay, relay)
+		})
+	}
+
+	for _, invalid := range []string{"", ":8080", "myrelay.io:0", "my_relay.io", "a.io,b.io"} {
+		_, err := password.Embed("1-foo-bar-baz", invalid, false)
+		assert.Error(t, err, invalid)
+	}
+	_, err := password.Embed("foo-bar-baz", "myrelay.io", false)
+	assert.Error(t, err)
+}
+
+func TestParseEmbedded(t *testing.T) {
+	t.Run("plain code", func(t *testing.T) {
+		assert.False(t, password.IsEmbedded("1-foo-bar-baz"))
+		pass, relay, auth, err := password.ParseEmbedded("1-foo-bar-baz")
+		require.NoError(t, err)
+		assert.Equal(t, "1-foo-bar-baz", pass)
+		assert.Empty(t, relay)
+		assert.False(t, auth)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		code, err := password.Embed("1-foo-bar-baz", "myrelay.io", false)
+		require.NoError(t, err)
+		// a typo in the relay address is caught by its checksum.
+		typo := []byte(code)
+		typo[len(typo)-3] ^= 1
+		malformed := []string{
+			string(typo),
+			"1-foo-bar-baz@",
+			"1-foo-bar-baz@not-base32!",
+			"1-foo-bar-baz@aeaa",
+			"foo-bar-baz@aeaaaadnpfzgk3dbpexgs34hhm",
+			strings.Replace(code, "1-foo", "1-f00", 1),
+		}
+		for _, s := range malformed {
+			_, _, _, err := password.ParseEmbedded(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
@@ -6,28 +6,146 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
 	math_rand "math/rand"
 	"regexp"
+	"strings"
 
 	"github.com/SpatiumPortae/portal/data"
 	"golang.org/x/exp/slices"
 )
 
+// Length is the default number of words of a password.
 const Length = 3
 
-// GeneratePassword generates a random password prefixed with the supplied id.
-func Generate(id int) (string, error) {
-	var words []string
-	hitlistSize := len(data.SpaceWordList)
+// Bounds of the number of words of a password.
+const (
+	MIN_LENGTH = 3
+	MAX_LENGTH = 8
+)
+
+// DIGITS_PER_GROUP is the number of digits of each group of a digits-only password.
+const DIGITS_PER_GROUP = 4
+
+// MIN_WORDLIST_SIZE is the minimum number of unique words of a word list passwords are generated from.
+const MIN_WORDLIST_SIZE = 16
+
+// MIN_ENTROPY is the minimum bits of entropy of passwords of words, about that of the default passwords. Passwords
+// of small word lists are generated of more words than requested to keep it, see Generator.
+const MIN_ENTROPY = 18
+
+// wordPattern matches the words of passwords, words of non-English word lists may contain any lowercase letter.
+const wordPattern = `[\p{Ll}\p{Lo}\p{M}]+`
+
+var wordRe = regexp.MustCompile(`^` + wordPattern + `$`)
+
+// validRe matches passwords of an id followed by MIN_LENGTH to MAX_LENGTH words or groups of digits.
+var validRe = regexp.MustCompile(fmt.Sprintf(`^\d+(-(%s|\d+)){%d,%d}$`, wordPattern, MIN_LENGTH, MAX_LENGTH))
+
+// Generator generates passwords of Length unique words drawn from Words, or of Length groups of DIGITS_PER_GROUP
+// digits if Digits is set, e.g. for dictation over the phone. Passwords of word lists too small for MIN_ENTROPY bits
+// of entropy in Length words are generated of more words, up to MAX_LENGTH. The zero Generator generates passwords
+// of Length words of data.SpaceWordList.
+type Generator struct {
+	Words  []string `json:"Words,omitempty"`
+	Length int      `json:"Length,omitempty"`
+	Digits bool     `json:"Digits,omitempty"`
+}
 
+func (g Generator) words() []string {
+	if g.Words == nil {
+		return data.SpaceWordList
+	}
+	return g.Words
+}
+
+func (g Generator) requestedLength() int {
+	if g.Length == 0 {
+		return Length
+	}
+	return g.Length
+}
+
+// length returns the number of words of the generated passwords, the requested length raised for small word lists.
+func (g Generator) length() int {
+	n := g.requestedLength()
+	if g.Digits {
+		return n
+	}
+	size := uniqueWords(g.words())
+	for n < MAX_LENGTH && wordEntropy(size, n) < MIN_ENTROPY {
+		n++
+	}
+	return n
+}
+
+// Validate returns an error if the generator cannot generate passwords, or generates passwords the receiver
+// rejects as malformed.
+func (g Generator) Validate() error {
+	if n := g.requestedLength(); n < MIN_LENGTH || n > MAX_LENGTH {
+		return fmt.Errorf("password length %d out of range, expected %d to %d words", n, MIN_LENGTH, MAX_LENGTH)
+	}
+	if g.Digits {
+		return nil
+	}
+	unique := make(map[string]struct{})
+	for _, word := range g.words() {
+		if !wordRe.MatchString(word) {
+			return fmt.Errorf("invalid word %q, words must consist of lowercase letters", word)
+		}
+		unique[word] = struct{}{}
+	}
+	if len(unique) < MIN_WORDLIST_SIZE {
+		return fmt.Errorf("word list of %d unique words too small, expected at least %d", len(unique), MIN_WORDLIST_SIZE)
+	}
+	return nil
+}
+
+// Entropy returns the bits of entropy of the generated passwords, not counting the id.
+func (g Generator) Entropy() float64 {
+	if g.Digits {
+		return float64(g.length()*DIGITS_PER_GROUP) * math.Log2(10)
+	}
+	return wordEntropy(uniqueWords(g.words()), g.length())
+}
+
+// uniqueWords returns the number of unique words of the word list.
+func uniqueWords(words []string) int {
+	unique := make(map[string]struct{})
+	for _, word := range words {
+		unique[word] = struct{}{}
+	}
+	return len(unique)
+}
+
+// wordEntropy returns the bits of entropy of passwords of n words drawn from size unique words.
+func wordEntropy(size, n int) float64 {
+	// words are drawn without replacement.
+	bits := 0.0
+	for i := 0; i < n && i < size; i++ {
+		bits += math.Log2(float64(size - i))
+	}
+	return bits
+}
+
+// Generate generates a random password prefixed with the supplied id.
+func (g Generator) Generate(id int) (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
 	rng, err := random()
 	if err != nil {
 		return "", fmt.Errorf("creating rng: %w", err)
 	}
 
-	// generate three unique words
-	for len(words) != Length {
-		candidateWord := data.SpaceWordList[rng.Intn(hitlistSize)]
+	n := g.length()
+	var words []string
+	for len(words) != n {
+		if g.Digits {
+			words = append(words, fmt.Sprintf("%0*d", DIGITS_PER_GROUP, rng.Intn(int(math.Pow10(DIGITS_PER_GROUP)))))
+			continue
+		}
+		candidateWord := g.words()[rng.Intn(len(g.words()))]
 		if !slices.Contains(words, candidateWord) {
 			words = append(words, candidateWord)
 		}
@@ -35,9 +153,13 @@ func Generate(id int) (string, error) {
 	return formatPassword(id, words), nil
 }
 
+// Generate generates a random password of Length words prefixed with the supplied id.
+func Generate(id int) (string, error) {
+	return Generator{}.Generate(id)
+}
+
 func IsValid(passStr string) bool {
-	re := regexp.MustCompile(`^\d+-[a-z]+-[a-z]+-[a-z]+$`)
-	return re.MatchString(passStr)
+	return validRe.MatchString(passStr)
 }
 
 func Hashed(password string) string {
@@ -47,7 +169,7 @@ func Hashed(password string) string {
 }
 
 func formatPassword(prefixIndex int, words []string) string {
-	return fmt.Sprintf("%d-%s-%s-%s", prefixIndex, words[0], words[1], words[2])
+	return fmt.Sprintf("%d-%s", prefixIndex, strings.Join(words, "-"))
 }
 
 func random() (*math_rand.Rand, error) {
@@ -0,0 +1,83 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/data"
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerator(t *testing.T) {
+	words := []string{"äpfel", "birne", "kirsche", "dattel", "feige", "gurke", "himbeere", "ingwer",
+		"johannisbeere", "kiwi", "limette", "mango", "nektarine", "olive", "pflaume", "quitte"}
+	tests := []struct {
+		name  string
+		gen   password.Generator
+		match string
+		parts int
+	}{
+		{name: "default", gen: password.Generator{}, match: `^7(-[a-z]+){3}$`, parts: 4},
+		{name: "length", gen: password.Generator{Length: 5}, match: `^7(-[a-z]+){5}$`, parts: 6},
+		{name: "word list", gen: password.Generator{Words: words}, match: `^7(-\pL+){5}$`, parts: 6},
+		{name: "word list length", gen: password.Generator{Words: words, Length: 6}, match: `^7(-\pL+){6}$`, parts: 7},
+		{name: "digits", gen: password.Generator{Digits: true, Length: 4}, match: `^7(-\d{4}){4}$`, parts: 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pass, err := tc.gen.Generate(7)
+			require.NoError(t, err)
+			assert.Regexp(t, tc.match, pass)
+			assert.Len(t, strings.Split(pass, "-"), tc.parts)
+			assert.True(t, password.IsValid(pass), pass)
+		})
+	}
+
+	for _, gen := range []password.Generator{
+		{Length: password.MIN_LENGTH - 1},
+		{Length: password.MAX_LENGTH + 1},
+		{Words: words[:password.MIN_WORDLIST_SIZE-1]},
+		{Words: append([]string{"Upper"}, words...)},
+	} {
+		_, err := gen.Generate(1)
+		assert.Error(t, err)
+	}
+
+	assert.InDelta(t, 3*4*3.3219, password.Generator{Digits: true}.Entropy(), 0.01)
+	assert.InDelta(t, 18.97, password.Generator{}.Entropy(), 0.01)
+	assert.InDelta(t, 4+3.9069+3.8074+3.7004+3.5850, password.Generator{Words: words}.Entropy(), 0.01)
+	assert.GreaterOrEqual(t, password.Generator{Words: words, Length: password.MIN_LENGTH}.Entropy(), float64(password.MIN_ENTROPY))
+}
+
+func TestIsValid(t *testing.T) {
+	for _, pass := range []string{"1-foo-bar-baz", "12-foo-bar-baz-qux", "3-1234-5678-9012", "4-äpfel-birne-kirsche"} {
+		assert.True(t, password.IsValid(pass), pass)
+	}
+	for _, pass := range []string{"1-foo-bar", "foo-bar-baz", "1-Foo-bar-baz", "1-foo-bar-baz-", "1-foo bar-baz-qux", "1" + strings.Repeat("-foo", password.MAX_LENGTH+1)} {
+		assert.False(t, password.IsValid(pass), pass)
+	}
+}
+
+func TestReadWordList(t *testing.T) {
+	eff := strings.Builder{}
+	eff.WriteString("# EFF style diceware list\n\n")
+	for i, word := range data.SpaceWordList[:20] {
+		eff.WriteString(strings.Repeat("1", 4) + string(rune('1'+i%6)) + "\t" + strings.ToUpper(word) + "\n")
+	}
+	words, err := password.ReadWordList(strings.NewReader(eff.String()))
+	require.NoError(t, err)
+	assert.Equal(t, data.SpaceWordList[:20], words)
+
+	_, err = password.ReadWordList(strings.NewReader(strings.Repeat("solar\n", password.MIN_WORDLIST_SIZE)))
+	assert.Error(t, err, "duplicate words should not count towards the size of the list")
+	_, err = password.ReadWordList(strings.NewReader("solar-storm\n"))
+	assert.Error(t, err)
+
+	words, err = password.LoadWordList("space")
+	require.NoError(t, err)
+	assert.Equal(t, data.SpaceWordList, words)
+	_, err = password.LoadWordList("no-such-list")
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+)
+
+// URL_SCHEME is the scheme of links sharing a password along with the address of the relay server,
+// e.g. portal://myrelay.io:8080/1-foo-bar-baz.
+const URL_SCHEME = "portal"
+
+// URL_RELAY_PARAM is the query parameter of links carrying relay addresses that are not a single host, i.e. lists
+// of relays or relays prefixed with a scheme, e.g. portal:///1-foo-bar-baz?relay=wss%3A%2F%2Fmyrelay.io.
+const URL_RELAY_PARAM = "relay"
+
+// URL returns the link to the password on the provided relay, a relay address as accepted by --relay. Single relays
+// without a scheme are the host of the link, other relay addresses are carried as is in URL_RELAY_PARAM.
+func URL(password, relay string) string {
+	u := &url.URL{Scheme: URL_SCHEME, Path: "/" + password}
+	if relays := conn.SplitAddrs(relay); len(relays) == 1 && !strings.Contains(relays[0], "://") {
+		u.Host = relays[0]
+	} else if len(relays) > 0 {
+		u.RawQuery = url.Values{URL_RELAY_PARAM: {strings.Join(relays, ",")}}.Encode()
+	}
+	return u.String()
+}
+
+// IsURL reports whether s is a link rather than a bare password.
+func IsURL(s string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), URL_SCHEME+"://")
+}
+
+// ParseURL parses a link into its password and relay address, the inverse of URL. The relay is empty if the link
+// does not specify one, e.g. portal:///1-foo-bar-baz. Links carrying anything but a relay and a valid
+// password are rejected, such that shared links cannot smuggle in credentials or options.
+func ParseURL(s string) (password, relay string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", "", fmt.Errorf("parsing link: %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, URL_SCHEME) {
+		return "", "", fmt.Errorf("invalid link scheme %q, expected %s://", u.Scheme, URL_SCHEME)
+	}
+	if u.User != nil || u.Fragment != "" {
+		return "", "", errors.New("invalid link, only a relay address and a password are accepted")
+	}
+	relay = u.Host
+	if u.Host != "" {
+		if err := validateRelay(u); err != nil {
+			return "", "", err
+		}
+	}
+	if u.RawQuery != "" {
+		if relay, err = parseRelayParam(u); err != nil {
+			return "", "", err
+		}
+	}
+	password = strings.TrimPrefix(u.Path, "/")
+	if !IsValid(password) {
+		return "", "", errors.New("invalid link, the password is malformed")
+	}
+	return password, relay, nil
+}
+
+// parseRelayParam returns the relay addresses carried in URL_RELAY_PARAM of the link, each a host with an optional
+// port and scheme, see conn.SplitScheme.
+func parseRelayParam(u *url.URL) (string, error) {
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil || len(query) != 1 || len(query[URL_RELAY_PARAM]) != 1 || u.Host != "" {
+		return "", errors.New("invalid link, only a relay address and a password are accepted")
+	}
+	relays := conn.SplitAddrs(query.Get(URL_RELAY_PARAM))
+	if len(relays) == 0 {
+		return "", errors.New("invalid link, empty relay address")
+	}
+	for _, relay := range relays {
+		host, _ := conn.SplitScheme(relay)
+		if host == "" || strings.Contains(host, "/") {
+			return "", fmt.Errorf("invalid link, malformed relay address %q", relay)
+		}
+		if err := validateRelay(&url.URL{Host: host}); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(relays, ","), nil
+}
+
+var hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// validateRelay validates the relay address of the link, a hostname or IP address with an optional port.
+func validateRelay(u *url.URL) error {
+	if host := u.Hostname(); host != "" && net.ParseIP(host) == nil && !hostnameRe.MatchString(host) {
+		return fmt.Errorf("invalid link, malformed relay host %q", host)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid link, malformed relay port %q", port)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return errors.New("invalid link, empty relay port")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/data"
+)
+
+// ReadWordList reads a word list of one word per line, skipping empty lines, comments starting with # and
+// duplicate words. Lines of several fields are read as their last field, such that diceware lists like the EFF
+// word lists (e.g. "11111	abacus") are read as is. Words are lowercased.
+func ReadWordList(r io.Reader) ([]string, error) {
+	var words []string
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		word := strings.ToLower(fields[len(fields)-1])
+		if !wordRe.MatchString(word) {
+			return nil, fmt.Errorf("line %d: invalid word %q, words must consist of letters", n, word)
+		}
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading word list: %w", err)
+	}
+	if len(words) < MIN_WORDLIST_SIZE {
+		return nil, fmt.Errorf("word list of %d unique words too small, expected at least %d", len(words), MIN_WORDLIST_SIZE)
+	}
+	return words, nil
+}
+
+// LoadWordList returns the bundled word list of the provided name, see data.WordLists, or reads the word list
+// at the provided path otherwise.
+func LoadWordList(nameOrPath string) ([]string, error) {
+	if words, ok := data.WordLists[nameOrPath]; ok {
+		return words, nil
+	}
+	f, err := os.Open(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening word list: %w", err)
+	}
+	defer f.Close()
+	return ReadWordList(f)
+}
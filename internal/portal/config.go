@@ -3,18 +3,167 @@ package portal
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"io"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
 )
 
+// DEFAULT_MAX_BUFFER_SIZE is the default maximum payload size received into memory by ReceiveToBuffer.
+const DEFAULT_MAX_BUFFER_SIZE = 16 << 20
+
 // defaultConfig specifies the default config for the portal module.
 var defaultConfig = Config{
 	RendezvousAddr: "portal.spatiumportae.com",
+	MaxBufferSize:  DEFAULT_MAX_BUFFER_SIZE,
+	Checksum:       transfer.CHECKSUM_SHA256,
+	Transport:      transfer.TRANSPORT_AUTO,
 }
 
 // Config specifes a config for the portal module.
 type Config struct {
+	// RendezvousAddr is the address of the rendezvous server, prefixed with wss:// if served over TLS, or a
+	// comma-separated list of addresses tried in order until a connection succeeds.
 	RendezvousAddr string `json:"RendezvousAddr,omitempty"`
+	DNSServer      string `json:"DNSServer,omitempty"`
+	// Proxy is the url of the proxy connections to the rendezvous server are dialed through, see conn.ParseProxy.
+	// Defaults to the proxy of the environment, see conn.WithProxyFromEnvironment.
+	Proxy string `json:"Proxy,omitempty"`
+	// TLSConfig secures the connections to rendezvous servers served over TLS, whose addresses are prefixed with
+	// wss:// or https://, e.g. to verify them against custom root CAs or present a client certificate. Rendezvous
+	// servers are verified against the system roots if nil.
+	TLSConfig *tls.Config `json:"-"`
+	// MaxBufferSize is the maximum payload size in bytes received into memory by ReceiveToBuffer.
+	MaxBufferSize int64 `json:"MaxBufferSize,omitempty"`
+	// KDFIterations is the number of key derivation iterations used by the sender, raised to the minimum
+	// advertised by the rendezvous server. Defaults to conn.DEFAULT_KDF_ITERATIONS.
+	KDFIterations int `json:"KDFIterations,omitempty"`
+	// Codec is the compression codec of the sent payload, the transfer fails with a sender.ErrUnsupportedCodec
+	// if the receiver cannot decompress it. Left empty for payloads that are not compressed archives.
+	Codec string `json:"Codec,omitempty"`
+	// Compression repacks the sent payload with the codec negotiated with the receiver if it differs from Codec,
+	// see sender.WithCompression. The payload is sent as is if unset.
+	Compression *sender.Compression `json:"-"`
+	// Formats are the packaging formats of the objects of the sent payload besides tar, see transfer.Formats, the
+	// transfer fails with a sender.ErrUnsupportedFormat if the receiver cannot unpack them.
+	Formats []string `json:"Formats,omitempty"`
+	// Checksum is the checksum algorithm proving the integrity of the sent payload, one of transfer.Checksums.
+	// Receivers that cannot verify it are sent a SHA-256 checksum. Defaults to transfer.CHECKSUM_SHA256.
+	Checksum string `json:"Checksum,omitempty"`
+	// Streams is the maximum number of parallel streams a relayed payload is split over by the sender,
+	// and accepted by the receiver. Defaults to a single stream.
+	Streams int `json:"Streams,omitempty"`
+	// ReceiveWindow is the number of chunks of a relayed payload the receiver holds out of order, asking the
+	// sender to sequence the chunks such that chunks lost by the relay are retransmitted. At most
+	// transfer.MAX_WINDOW, defaults to unsequenced chunks. Payloads split over parallel streams are not sequenced.
+	ReceiveWindow int `json:"ReceiveWindow,omitempty"`
+	// RateLimit is the rate in bytes per second the payload is sent or received at at most, shared by the
+	// streams and the receivers of the transfer. Transfers are unlimited if not positive.
+	RateLimit int64 `json:"RateLimit,omitempty"`
+	// ChunkSize is the size in bytes of the chunks the payload is sent in, within the negotiable bounds of
+	// transfer.MIN_CHUNK_BYTES and transfer.MAX_CHUNK_BYTES. Senders send the chunk size they are configured with,
+	// receivers propose it to the sender. transfer.CHUNK_SIZE_ADAPTIVE adapts the chunks to the throughput of the
+	// link, defaults to a chunk size suited to the round trip time of the handshake.
+	ChunkSize int64 `json:"ChunkSize,omitempty"`
+	// Raw accepts raw byte streams of senders, transfer.FORMAT_RAW, for receivers writing the payload as is rather
+	// than unpacking it. Raw streams are refused if unset, see receiver.WithRaw.
+	Raw bool `json:"Raw,omitempty"`
+	// Transport is the transport of direct connections between the sender and the receiver, one of
+	// transfer.Transports, transfers falling back to the relay if the peers cannot connect over it. Defaults to
+	// transfer.TRANSPORT_AUTO, connecting over QUIC and falling back to websocket.
+	Transport string `json:"Transport,omitempty"`
+	// ExpireAfter is the time after which the password of the sender expires unless a receiver connected,
+	// bound by the receiver connect timeout of the rendezvous server. Defaults to that timeout.
+	ExpireAfter time.Duration `json:"ExpireAfter,omitempty"`
+	// Password is the password claimed by the sender rather than generated, such that receivers knowing it can
+	// connect before the sender with WaitForSender. The sender fails with a sender.ErrCodeInUse if claimed already,
+	// unless it frees up within WaitForCode.
+	Password string `json:"Password,omitempty"`
+	// WaitForCode is the time the sender keeps claiming Password again, with a backoff, while another sender holds
+	// it, e.g. a finishing transfer sent with the same password. Fails right away if not positive, and when sending
+	// to several receivers with SendMany.
+	WaitForCode time.Duration `json:"WaitForCode,omitempty"`
+	// Passwords generates the password of the sender unless Password is provided, defaulting to three words of
+	// data.SpaceWordList. Receivers accept passwords of any password.Generator.
+	Passwords password.Generator `json:"Passwords,omitempty"`
+	// WaitForSender holds the receiver on the rendezvous server until a sender claims the password, rather
+	// than failing if no sender holds it yet.
+	WaitForSender bool `json:"WaitForSender,omitempty"`
+	// ConfirmReceiver is called by the sender with the fingerprint of the connection once a receiver
+	// is connected, the receiver is disconnected with a sender.ErrReceiverRejected unless approved.
+	ConfirmReceiver func(fingerprint string) (bool, error) `json:"-"`
+	// OnFingerprint is called with the fingerprint of the connection once it is secured,
+	// the sender and the receiver of a connection observe the same fingerprint.
+	OnFingerprint func(fingerprint string) `json:"-"`
+	// OnIdle is called with the time until the relay is closed when the rendezvous server warns that
+	// the relayed transfer is idle.
+	OnIdle func(disconnectIn time.Duration) `json:"-"`
+	// OnClosing is called with the time until the relay is closed when the rendezvous server warns that
+	// it is shutting down.
+	OnClosing func(disconnectIn time.Duration) `json:"-"`
+	// OnProgress is called with the number of bytes of the payload transferred so far and the size of the
+	// payload as the transfer progresses.
+	OnProgress func(bytes, total int64) `json:"-"`
+	// OnChecksum is called with the checksum of the payload once the sender sent it or the receiver verified it.
+	// Not called if no checksum algorithm was negotiated, or for payloads split over parallel streams, whose
+	// streams are verified on their own.
+	OnChecksum func(sum checksum.Result) `json:"-"`
+	// OnChunkStats is called by the sender with the size of the chunks and the throughput of the link after each
+	// chunk sent, if the chunks adapt to the link.
+	OnChunkStats func(stats transfer.ChunkStats) `json:"-"`
+	// OnTransfer is called with the type of the transfer, direct or relayed, once the peers negotiated it.
+	OnTransfer func(t transfer.Type) `json:"-"`
+	// OnCompression is called with the compression of the payload once the sender announced it, by senders
+	// negotiating the codec and their receivers.
+	OnCompression func(c transfer.Compression) `json:"-"`
+	// Resume describes the files the receiver kept from a transfer interrupted in a previous session,
+	// asking the sender to only send the rest. The transfer id of the sender is recorded in it, starting over
+	// if the files were kept from another transfer.
+	Resume *transfer.Resume `json:"Resume,omitempty"`
+	// OnResume repacks the payload of the sender when the receiver asks to resume a transfer interrupted
+	// in a previous session, returning the repacked payload and its size. The full payload is sent if unset.
+	OnResume sender.ResumeFunc `json:"-"`
+	// TransferID identifies the payload of the sender across sessions, such that receivers only resume transfers of
+	// the same payload. Transfers are resumed by the digests of the kept files alone if unset.
+	TransferID string `json:"TransferID,omitempty"`
+	// Selection lists the files of the payload of the sender receivers can select from, and repacks the payload
+	// with the files selected by the receiver. Receivers selecting files fail the transfer if unset.
+	Selection *sender.Selection `json:"-"`
+	// Select selects the files of the manifest of the sender the receiver accepts, only the selected files are
+	// received. Every file is received if unset.
+	Select receiver.SelectFunc `json:"-"`
+}
+
+// dialOptions returns the dial options specified by the config.
+func (c Config) dialOptions() []conn.DialOption {
+	opts := []conn.DialOption{conn.WithDialer(conn.NewDialer(c.DNSServer)), proxyOption(c.Proxy)}
+	if c.TLSConfig != nil {
+		opts = append(opts, conn.WithTLSConfig(c.TLSConfig))
+	}
+	return opts
+}
+
+// proxyOption returns the dial option dialing through the provided proxy, or the proxy of the environment if empty.
+func proxyOption(proxy string) conn.DialOption {
+	if proxy == "" {
+		return conn.WithProxyFromEnvironment()
+	}
+	return conn.WithProxy(proxy)
+}
+
+// handshakeOptions returns the sender handshake options specified by the config.
+func (c Config) handshakeOptions() []sender.HandshakeOption {
+	if c.KDFIterations == 0 {
+		return nil
+	}
+	return []sender.HandshakeOption{sender.WithKDFIterations(c.KDFIterations)}
 }
 
 // MergeConfigReader merges the config from the reader
@@ -31,5 +180,55 @@ func MergeConfigReader(dst Config, r io.Reader) Config {
 func MergeConfig(dst Config, src *Config) Config {
 	var buf bytes.Buffer
 	json.NewEncoder(&buf).Encode(src)
-	return MergeConfigReader(dst, &buf)
+	merged := MergeConfigReader(dst, &buf)
+	// callbacks and the TLS config are not serialized, and are merged separately.
+	if src != nil {
+		if src.TLSConfig != nil {
+			merged.TLSConfig = src.TLSConfig
+		}
+		if src.ConfirmReceiver != nil {
+			merged.ConfirmReceiver = src.ConfirmReceiver
+		}
+		if src.OnFingerprint != nil {
+			merged.OnFingerprint = src.OnFingerprint
+		}
+		if src.OnIdle != nil {
+			merged.OnIdle = src.OnIdle
+		}
+		if src.OnClosing != nil {
+			merged.OnClosing = src.OnClosing
+		}
+		if src.OnProgress != nil {
+			merged.OnProgress = src.OnProgress
+		}
+		if src.OnChecksum != nil {
+			merged.OnChecksum = src.OnChecksum
+		}
+		if src.OnChunkStats != nil {
+			merged.OnChunkStats = src.OnChunkStats
+		}
+		if src.OnTransfer != nil {
+			merged.OnTransfer = src.OnTransfer
+		}
+		if src.OnCompression != nil {
+			merged.OnCompression = src.OnCompression
+		}
+		if src.Compression != nil {
+			merged.Compression = src.Compression
+		}
+		// the resume state is shared, such that the receiver records the transfer id of the sender in it.
+		if src.Resume != nil {
+			merged.Resume = src.Resume
+		}
+		if src.OnResume != nil {
+			merged.OnResume = src.OnResume
+		}
+		if src.Selection != nil {
+			merged.Selection = src.Selection
+		}
+		if src.Select != nil {
+			merged.Select = src.Select
+		}
+	}
+	return merged
 }
@@ -0,0 +1,48 @@
+package portal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+)
+
+// Drop seals the payload and leaves it on the first rendezvous server as a drop, such that the receiver collects
+// it with Collect once online, whether or not the sender still is. Returns the code to collect the drop with, and
+// the time the drop expires. The provided config will be merged with the default config.
+func Drop(ctx context.Context, payload io.Reader, payloadSize int64, config *Config) (string, time.Time, error) {
+	merged := MergeConfig(defaultConfig, config)
+	addrs := conn.SplitAddrs(merged.RendezvousAddr)
+	if len(addrs) == 0 {
+		return "", time.Time{}, rendezvousErr(merged.RendezvousAddr, nil)
+	}
+	// the payload is read once, the drop cannot be left elsewhere once partially uploaded.
+	payload = limitReader(ctx, payload, NewLimiter(merged.RateLimit))
+	return sender.Drop(ctx, conn.HTTPClient(merged.dialOptions()...), addrs[0], payload, payloadSize)
+}
+
+// Collect collects the drop of the code from the rendezvous servers, writing its payload to dst. Servers are asked
+// in turn until one holds the drop. The provided config will be merged with the default config.
+func Collect(ctx context.Context, dst io.Writer, code string, config *Config) error {
+	merged := MergeConfig(defaultConfig, config)
+	client := conn.HTTPClient(merged.dialOptions()...)
+	dst = limitWriter(ctx, dst, NewLimiter(merged.RateLimit))
+	var errs []error
+	for _, addr := range conn.SplitAddrs(merged.RendezvousAddr) {
+		err := receiver.Collect(ctx, client, addr, code, dst)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("collecting from %s: %w", addr, err))
+		// servers not holding the drop respond before anything is written.
+		if !errors.Is(err, receiver.ErrDropNotFound) {
+			break
+		}
+	}
+	return rendezvousErr(merged.RendezvousAddr, errs)
+}
@@ -1,55 +1,360 @@
 package portal
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/receiver"
 	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"golang.org/x/time/rate"
 )
 
+// Metadata describes a received payload.
+type Metadata struct {
+	Size     int64           // Size is the size of the payload in bytes.
+	Transfer transfer.Type   // Transfer is the type of transfer, direct or relayed.
+	Checksum checksum.Result // Checksum is the verified checksum of the payload, empty if none was negotiated.
+}
+
 // Send executes the portal send sequence. The initial connection with the relay
 // server is performed synchronously, after that the transfer sequence is performed
 // asynchronously. The function returns a portal password, a error from the rendezvous
 // initial rendezvous connection, and a channel on which errors from the transfer sequence
 // can be listened to. The provided config will be merged with the default config.
+// If several rendezvous servers are configured, the first one accepting the connection is used,
+// the receiver must connect to the same server. A payloadSize of 0 sends a payload of unknown size
+// until it is read to EOF, over a single stream.
 func Send(ctx context.Context, payload io.Reader, payloadSize int64, config *Config) (string, error, chan error) {
 	merged := MergeConfig(defaultConfig, config)
-	errC := make(chan error, 1) // buffer channel as to not block send.
-	rc, password, err := sender.ConnectRendezvous(ctx, merged.RendezvousAddr)
+	rc, addr, password, err := connectSender(ctx, merged)
 	if err != nil {
 		return "", err, nil
 	}
+	errC := make(chan error, 1) // buffer channel as to not block send.
 	go func() {
 		defer close(errC)
-		tc, err := sender.SecureConnection(ctx, rc, password)
-		if err != nil {
+		if err := transferTo(ctx, rc, addr, password, payload, payloadSize, merged, NewLimiter(merged.RateLimit)); err != nil {
 			errC <- err
-			return
 		}
-		if err := sender.Transfer(ctx, tc, payload, payloadSize); err != nil {
+	}()
+	return password, nil, errC
+}
+
+// SendMany is Send to several receivers with one password, sending each receiver the full payload over a
+// transfer of its own, in parallel. The i-th receiver to connect is sent the i-th payload, the payloads are
+// expected to hold the same content. The channel reports the errors of the transfers of all receivers once
+// every transfer ended, each transfer fails on its own.
+func SendMany(ctx context.Context, payloads []io.Reader, payloadSize int64, config *Config) (string, error, chan error) {
+	if len(payloads) == 0 || len(payloads) > rendezvous.MAX_RECEIVERS {
+		return "", fmt.Errorf("invalid number of receivers %d, must be between 1 and %d", len(payloads), rendezvous.MAX_RECEIVERS), nil
+	}
+	merged := MergeConfig(defaultConfig, config)
+	// the mailboxes of the further receivers join the mailbox claimed first.
+	merged.WaitForCode = 0
+	rc, addr, password, err := connectSender(ctx, merged)
+	if err != nil {
+		return "", err, nil
+	}
+	rcs := []conn.Rendezvous{rc}
+	for range payloads[1:] {
+		joined, err := sender.ConnectRendezvousJoining(ctx, addr, password, rc.Token, merged.ExpireAfter, merged.dialOptions()...)
+		if err != nil {
+			for _, rc := range rcs {
+				rc.Conn.Close(conn.CLOSE_FAILED, "") //nolint:errcheck
+			}
+			return "", fmt.Errorf("joining mailbox for receiver %d: %w", len(rcs)+1, err), nil
+		}
+		rcs = append(rcs, joined)
+	}
+	// the receivers share the uplink of the sender, and thereby the rate limit.
+	limiter := NewLimiter(merged.RateLimit)
+	errC := make(chan error, 1) // buffer channel as to not block send.
+	go func() {
+		defer close(errC)
+		errs := make([]error, len(rcs))
+		var wg sync.WaitGroup
+		for i, rc := range rcs {
+			wg.Add(1)
+			go func(i int, rc conn.Rendezvous) {
+				defer wg.Done()
+				if err := transferTo(ctx, rc, addr, password, payloads[i], payloadSize, merged, limiter); err != nil {
+					errs[i] = fmt.Errorf("receiver %d: %w", i+1, err)
+				}
+			}(i, rc)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
 			errC <- err
-			return
 		}
 	}()
 	return password, nil, errC
 }
 
+// connectSender connects to the first rendezvous server accepting the sender, claiming the password of the config
+// or acquiring one. Returns the connection, the address of the rendezvous server connected to and the password.
+func connectSender(ctx context.Context, merged Config) (conn.Rendezvous, string, string, error) {
+	var (
+		rc       conn.Rendezvous
+		addr     string
+		password string
+		errs     []error
+	)
+	for _, addr = range conn.SplitAddrs(merged.RendezvousAddr) {
+		var err error
+		if merged.Password != "" {
+			password = merged.Password
+			rc, err = sender.ConnectRendezvousClaiming(ctx, addr, password, merged.ExpireAfter, merged.dialOptions()...)
+		} else {
+			rc, password, err = sender.ConnectRendezvousGenerating(ctx, addr, merged.ExpireAfter, merged.Passwords, merged.dialOptions()...)
+		}
+		if err == nil {
+			errs = nil
+			break
+		}
+		errs = append(errs, fmt.Errorf("connecting to %s: %w", addr, err))
+	}
+	if err := rendezvousErr(merged.RendezvousAddr, errs); err != nil {
+		return conn.Rendezvous{}, "", "", err
+	}
+	return rc, addr, password, nil
+}
+
+// CODE_RETRY_BACKOFF is the initial time waited before claiming a password held by another sender again,
+// doubled after every attempt up to MAX_CODE_RETRY_BACKOFF.
+const CODE_RETRY_BACKOFF = 250 * time.Millisecond
+
+// MAX_CODE_RETRY_BACKOFF is the maximum time waited between the claims of a password held by another sender.
+const MAX_CODE_RETRY_BACKOFF = 5 * time.Second
+
+// secureSender secures the connection of the sender to the rendezvous server once a receiver connected. Passwords
+// held by another sender are claimed again with a backoff until the WaitForCode of the config elapsed.
+func secureSender(ctx context.Context, rc conn.Rendezvous, addr, password string, merged Config) (conn.Transfer, error) {
+	deadline := time.Now().Add(merged.WaitForCode)
+	backoff := CODE_RETRY_BACKOFF
+	for {
+		tc, err := sender.SecureConnection(ctx, rc, password, merged.handshakeOptions()...)
+		if !errors.Is(err, sender.ErrCodeInUse) || merged.WaitForCode <= 0 {
+			return tc, err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return conn.Transfer{}, fmt.Errorf("%w after waiting %s", err, merged.WaitForCode)
+		}
+		if backoff < wait {
+			wait = backoff
+		}
+		select {
+		case <-ctx.Done():
+			return conn.Transfer{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > MAX_CODE_RETRY_BACKOFF {
+			backoff = MAX_CODE_RETRY_BACKOFF
+		}
+		if rc, err = sender.ConnectRendezvousClaiming(ctx, addr, password, merged.ExpireAfter, merged.dialOptions()...); err != nil {
+			return conn.Transfer{}, err
+		}
+	}
+}
+
+// transferTo secures the connection of the sender to the rendezvous server once a receiver connected, and
+// transfers the payload to the receiver, read at the rate of the limiter if not nil.
+func transferTo(ctx context.Context, rc conn.Rendezvous, addr, password string, payload io.Reader, payloadSize int64, merged Config, limiter *rate.Limiter) error {
+	tc, err := secureSender(ctx, rc, addr, password, merged)
+	if err != nil {
+		return err
+	}
+	if merged.OnFingerprint != nil {
+		merged.OnFingerprint(tc.Fingerprint())
+	}
+	tc.OnIdle = merged.OnIdle
+	tc.OnClosing = merged.OnClosing
+	if merged.ConfirmReceiver != nil {
+		if err := sender.ConfirmReceiver(tc, merged.ConfirmReceiver); err != nil {
+			return err
+		}
+	}
+	streams := sender.Streams{Addr: addr, Count: merged.Streams, Opts: merged.dialOptions()}
+	onResume, selection := limitRepacked(ctx, merged.OnResume, merged.Selection, limiter)
+	msgs, stop := progressMsgs(merged, payloadSize)
+	compression := limitCompression(ctx, merged.Compression, limiter)
+	err = sender.Transfer(ctx, tc, limitReader(ctx, payload, limiter), payloadSize,
+		sender.WithCodec(merged.Codec),
+		sender.WithCompression(compression),
+		sender.WithFormats(merged.Formats...),
+		sender.WithStreams(streams),
+		sender.WithResume(onResume),
+		sender.WithTransferID(merged.TransferID),
+		sender.WithChecksum(merged.Checksum),
+		sender.WithSelection(selection),
+		sender.WithChunkSize(merged.ChunkSize),
+		sender.WithTransport(merged.Transport),
+		sender.WithMessages(msgs),
+	)
+	stop()
+	return err
+}
+
 // Receive executes the portal receive sequence. The payload is written
-// to the provided writer. The provided config will be merged with the
-// default config.
+// to the provided writer, payloads split over parallel streams are only
+// accepted by writers implementing io.WriterAt. The provided config will
+// be merged with the default config.
 func Receive(ctx context.Context, dst io.Writer, password string, config *Config) error {
 	merged := MergeConfig(defaultConfig, config)
-	rc, err := receiver.ConnectRendezvous(merged.RendezvousAddr)
+	tc, addr, err := secureReceiver(ctx, password, merged)
 	if err != nil {
 		return err
 	}
-	tc, err := receiver.SecureConnection(ctx, rc, password)
+	streams := receiver.Streams{Addr: addr, Count: merged.Streams, Opts: merged.dialOptions()}
+	dst = limitWriter(ctx, dst, NewLimiter(merged.RateLimit))
+	msgs, stop := progressMsgs(merged, 0)
+	err = receiver.Receive(ctx, tc, dst,
+		receiver.WithStreams(streams),
+		receiver.WithResume(merged.Resume),
+		receiver.WithSelect(merged.Select),
+		receiver.WithWindow(merged.ReceiveWindow),
+		receiver.WithChunkSize(merged.ChunkSize),
+		receiver.WithTransport(merged.Transport),
+		receiver.WithRaw(merged.Raw),
+		receiver.WithMessages(msgs),
+	)
+	stop()
+	return err
+}
+
+// progressMsgs returns a channel of transfer messages reporting the progress of the transfer, its checksum and the
+// stats of its chunks to the callbacks of the config, and a function closing the channel once the transfer returned.
+// The size of the payload is total unless announced by the sender.
+func progressMsgs(config Config, total int64) (chan interface{}, func()) {
+	msgs := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			switch msg := msg.(type) {
+			case int64:
+				total = msg
+			case int:
+				if config.OnProgress != nil {
+					config.OnProgress(int64(msg), total)
+				}
+			case checksum.Result:
+				if config.OnChecksum != nil {
+					config.OnChecksum(msg)
+				}
+			case transfer.ChunkStats:
+				if config.OnChunkStats != nil {
+					config.OnChunkStats(msg)
+				}
+			case transfer.Type:
+				if config.OnTransfer != nil {
+					config.OnTransfer(msg)
+				}
+			case transfer.Compression:
+				if config.OnCompression != nil {
+					config.OnCompression(msg)
+				}
+			}
+		}
+	}()
+	return msgs, func() {
+		close(msgs)
+		<-done
+	}
+}
+
+// ReceiveToBuffer executes the portal receive sequence, returning the payload in memory.
+// Payloads larger than the MaxBufferSize of the config are refused with a receiver.ErrPayloadTooLarge
+// before being received. The provided config will be merged with the default config.
+func ReceiveToBuffer(ctx context.Context, password string, config *Config) ([]byte, Metadata, error) {
+	merged := MergeConfig(defaultConfig, config)
+	tc, _, err := secureReceiver(ctx, password, merged)
 	if err != nil {
-		return err
+		return nil, Metadata{}, err
 	}
-	if err := receiver.Receive(ctx, tc, dst); err != nil {
-		return err
+
+	var meta Metadata
+	msgs := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			switch msg := msg.(type) {
+			case transfer.Type:
+				meta.Transfer = msg
+			case checksum.Result:
+				meta.Checksum = msg
+			}
+		}
+	}()
+	var buf bytes.Buffer
+	err = receiver.Receive(ctx, tc, limitWriter(ctx, &buf, NewLimiter(merged.RateLimit)), receiver.WithMaxSize(merged.MaxBufferSize), receiver.WithTransport(merged.Transport), receiver.WithMessages(msgs))
+	close(msgs)
+	<-done
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	meta.Size = int64(buf.Len())
+	return buf.Bytes(), meta, nil
+}
+
+// secureReceiver connects to the first reachable rendezvous server and performs the cryptographic
+// handshake, reconnecting if the handshake is restarted by the sender. Returns the secured connection
+// and the address of the rendezvous server connected to.
+func secureReceiver(ctx context.Context, password string, config Config) (conn.Transfer, string, error) {
+	var (
+		rc   conn.Rendezvous
+		addr string
+		errs []error
+	)
+	for _, addr = range conn.SplitAddrs(config.RendezvousAddr) {
+		var err error
+		if rc, err = receiver.ConnectRendezvous(addr, config.dialOptions()...); err == nil {
+			errs = nil
+			break
+		}
+		errs = append(errs, fmt.Errorf("connecting to %s: %w", addr, err))
+	}
+	if err := rendezvousErr(config.RendezvousAddr, errs); err != nil {
+		return conn.Transfer{}, "", err
+	}
+	secure := receiver.SecureConnection
+	if config.WaitForSender {
+		secure = receiver.SecureConnectionWaiting
+	}
+	tc, err := secure(ctx, rc, password)
+	if receiver.Reconnectable(err) {
+		tc, err = receiver.Reconnect(ctx, addr, password, config.dialOptions()...)
+	}
+	if err != nil {
+		return conn.Transfer{}, "", err
+	}
+	if config.OnFingerprint != nil {
+		config.OnFingerprint(tc.Fingerprint())
+	}
+	tc.OnIdle = config.OnIdle
+	tc.OnClosing = config.OnClosing
+	return tc, addr, nil
+}
+
+// rendezvousErr returns the errors of connecting to the rendezvous servers, if none could be connected to.
+func rendezvousErr(addrs string, errs []error) error {
+	switch {
+	case len(conn.SplitAddrs(addrs)) == 0:
+		return errors.New("no rendezvous server address provided")
+	case len(errs) == 1:
+		return errors.Unwrap(errs[0])
+	case len(errs) > 1:
+		return fmt.Errorf("unable to connect to any rendezvous server: %w", errors.Join(errs...))
 	}
 	return nil
 }
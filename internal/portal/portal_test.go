@@ -4,13 +4,26 @@ package portal_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
 
 	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -51,6 +64,336 @@ func TestE2E(t *testing.T) {
 	assert.Equal(t, oracle, out.String())
 }
 
+func TestReceiveToBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	t.Run("within cap", func(t *testing.T) {
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{RendezvousAddr: addr, MaxBufferSize: int64(len(oracle))}
+		in := bytes.NewBufferString(oracle)
+
+		password, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		b, meta, err := portal.ReceiveToBuffer(ctx, password, &config)
+		require.NoError(t, err)
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, string(b))
+		assert.Equal(t, int64(len(oracle)), meta.Size)
+		assert.NotEqual(t, transfer.Unknown, meta.Transfer)
+		sum := sha256.Sum256([]byte(oracle))
+		assert.Equal(t, checksum.Result{Algorithm: transfer.CHECKSUM_SHA256, Sum: hex.EncodeToString(sum[:])}, meta.Checksum)
+	})
+
+	t.Run("over cap", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("a"), 1024)
+		config := portal.Config{RendezvousAddr: addr, MaxBufferSize: 512}
+
+		password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+		require.NoError(t, err)
+		b, _, err := portal.ReceiveToBuffer(ctx, password, &config)
+		assert.ErrorIs(t, err, receiver.ErrPayloadTooLarge)
+		assert.Nil(t, b)
+		assert.Error(t, <-errC)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the bucket holds a second of tokens, a payload of two seconds takes at least a second.
+	const limit = 256 << 10
+	payload := bytes.Repeat([]byte("a"), 2*limit)
+	for name, configs := range map[string][2]portal.Config{
+		"sender":   {{RendezvousAddr: addr, RateLimit: limit}, {RendezvousAddr: addr}},
+		"receiver": {{RendezvousAddr: addr}, {RendezvousAddr: addr, RateLimit: limit}},
+		// compressions announced without repacking the payload are limited as is.
+		"compression": {{RendezvousAddr: addr, RateLimit: limit, Compression: &sender.Compression{RawSize: 4 * limit}}, {RendezvousAddr: addr}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &configs[0])
+			require.NoError(t, err)
+			var out bytes.Buffer
+			require.NoError(t, portal.Receive(ctx, &out, password, &configs[1]))
+			require.NoError(t, <-errC)
+			assert.Equal(t, payload, out.Bytes())
+			assert.GreaterOrEqual(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestUnknownSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the payload is read to EOF, as piped to the sender.
+	payload := bytes.Repeat([]byte("portal"), 100000)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(payload) //nolint:errcheck
+		pw.Close()
+	}()
+	config := portal.Config{RendezvousAddr: addr, Formats: []string{transfer.FORMAT_RAW}, Streams: transfer.MAX_STREAMS}
+	password, err, errC := portal.Send(ctx, pr, 0, &config)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, portal.Receive(ctx, &out, password, &portal.Config{RendezvousAddr: addr, Raw: true}))
+	require.NoError(t, <-errC)
+	assert.Equal(t, payload, out.Bytes())
+
+	// receivers unpacking the payload as an archive refuse raw streams.
+	password, err, errC = portal.Send(ctx, bytes.NewReader(payload), 0, &config)
+	require.NoError(t, err)
+	assert.Error(t, portal.Receive(ctx, io.Discard, password, &portal.Config{RendezvousAddr: addr}))
+	assert.ErrorIs(t, <-errC, sender.ErrUnsupportedFormat)
+}
+
+func TestOnChecksum(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := bytes.Repeat([]byte("portal"), 100000)
+	var sent, verified checksum.Result
+	config := portal.Config{RendezvousAddr: addr, Checksum: transfer.CHECKSUM_BLAKE3, OnChecksum: func(sum checksum.Result) { sent = sum }}
+	password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, portal.Receive(ctx, &out, password, &portal.Config{RendezvousAddr: addr, OnChecksum: func(sum checksum.Result) { verified = sum }}))
+	require.NoError(t, <-errC)
+	assert.Equal(t, transfer.CHECKSUM_BLAKE3, verified.Algorithm)
+	assert.NotEmpty(t, verified.Sum)
+	assert.Equal(t, sent, verified)
+}
+
+func TestOnTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := []byte("portal")
+	var sent, received transfer.Type
+	config := portal.Config{RendezvousAddr: addr, OnTransfer: func(t transfer.Type) { sent = t }}
+	password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, portal.Receive(ctx, &out, password, &portal.Config{RendezvousAddr: addr, OnTransfer: func(t transfer.Type) { received = t }}))
+	require.NoError(t, <-errC)
+	// both peers report the type of transfer they negotiated.
+	assert.NotEqual(t, transfer.Unknown, received)
+	assert.Equal(t, received, sent)
+}
+
+func TestAdaptiveChunkSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := bytes.Repeat([]byte("portal"), 1000000)
+	var stats []transfer.ChunkStats
+	config := portal.Config{RendezvousAddr: addr, OnChunkStats: func(s transfer.ChunkStats) { stats = append(stats, s) }}
+	password, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+	require.NoError(t, err)
+	// the receiver asks for chunks adapting to the link.
+	var out bytes.Buffer
+	require.NoError(t, portal.Receive(ctx, &out, password, &portal.Config{RendezvousAddr: addr, ChunkSize: transfer.CHUNK_SIZE_ADAPTIVE}))
+	require.NoError(t, <-errC)
+	assert.Equal(t, payload, out.Bytes())
+	require.NotEmpty(t, stats)
+	for _, s := range stats {
+		assert.GreaterOrEqual(t, s.Size, int64(transfer.MIN_CHUNK_BYTES))
+		assert.LessOrEqual(t, s.Size, int64(transfer.MAX_CHUNK_BYTES))
+		assert.Positive(t, s.BytesPerSecond)
+	}
+}
+
+func TestNegotiatedCodec(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the payload packed with gzip is repacked with zstd, preferred by receivers decompressing it.
+	repacked := []byte("repacked with zstd")
+	var negotiated string
+	config := portal.Config{
+		RendezvousAddr: addr,
+		Codec:          transfer.CODEC_GZIP,
+		Compression: &sender.Compression{RawSize: 1000, Repack: func(codec string) (io.Reader, int64, error) {
+			negotiated = codec
+			return bytes.NewReader(repacked), int64(len(repacked)), nil
+		}},
+	}
+	password, err, errC := portal.Send(ctx, bytes.NewBufferString("packed with gzip"), int64(len("packed with gzip")), &config)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	var announced transfer.Compression
+	require.NoError(t, portal.Receive(ctx, &out, password, &portal.Config{RendezvousAddr: addr, OnCompression: func(c transfer.Compression) { announced = c }}))
+	require.NoError(t, <-errC)
+	assert.Equal(t, transfer.CODEC_ZSTD, negotiated)
+	assert.Equal(t, repacked, out.Bytes())
+	assert.Equal(t, transfer.Compression{Codec: transfer.CODEC_ZSTD, RawSize: 1000}, announced)
+}
+
+func TestRendezvousFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	healthy := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	dead := l.Addr().String()
+	l.Close()
+
+	t.Run("dead primary", func(t *testing.T) {
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{RendezvousAddr: dead + "," + healthy}
+		in := bytes.NewBufferString(oracle)
+		out := &bytes.Buffer{}
+
+		password, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		require.NoError(t, portal.Receive(ctx, out, password, &config))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+	})
+	t.Run("no reachable server", func(t *testing.T) {
+		config := portal.Config{RendezvousAddr: dead + "," + dead}
+		_, err, _ := portal.Send(ctx, bytes.NewBufferString("unused"), 6, &config)
+		assert.Error(t, err)
+	})
+}
+
+func TestWaitForCode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	// waiting returns the creation of the only mailbox of the server if it waits for its receiver, snapshots are
+	// reused for up to a second.
+	waiting := func() time.Time {
+		snapshot := server.Snapshot()
+		if len(snapshot.Mailboxes) != 1 || snapshot.Mailboxes[0].State != rendezvous.MailboxWaiting {
+			return time.Time{}
+		}
+		return snapshot.Mailboxes[0].Created
+	}
+
+	// another sender holds the code, until its transfer finished.
+	const code = "7-alpha-bravo-charlie"
+	first := "sent by the sender holding the code"
+	_, err, holderC := portal.Send(ctx, bytes.NewBufferString(first), int64(len(first)), &portal.Config{RendezvousAddr: addr, Password: code})
+	require.NoError(t, err)
+	var holder time.Time
+	require.Eventually(t, func() bool { holder = waiting(); return !holder.IsZero() }, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("times out", func(t *testing.T) {
+		config := portal.Config{RendezvousAddr: addr, Password: code, WaitForCode: 300 * time.Millisecond}
+		_, err, errC := portal.Send(ctx, bytes.NewBufferString("unused"), 6, &config)
+		require.NoError(t, err)
+		assert.ErrorIs(t, <-errC, sender.ErrCodeInUse)
+	})
+	t.Run("code freed", func(t *testing.T) {
+		second := "sent once the code was freed"
+		config := portal.Config{RendezvousAddr: addr, Password: code, WaitForCode: 10 * time.Second}
+		_, err, errC := portal.Send(ctx, bytes.NewBufferString(second), int64(len(second)), &config)
+		require.NoError(t, err)
+
+		out := &bytes.Buffer{}
+		require.NoError(t, portal.Receive(ctx, out, code, &portal.Config{RendezvousAddr: addr}))
+		require.NoError(t, <-holderC)
+		assert.Equal(t, first, out.String())
+
+		// the code is claimed by the waiting sender once the transfer holding it finished.
+		require.Eventually(t, func() bool { return waiting().After(holder) }, 10*time.Second, 10*time.Millisecond)
+		out.Reset()
+		require.NoError(t, portal.Receive(ctx, out, code, &portal.Config{RendezvousAddr: addr}))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, second, out.String())
+	})
+}
+
+func TestConfirmReceiver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// send sends the oracle, approving the receiver if approve is set, and returns the fingerprints
+	// observed by the sender and the receiver along with the errors of both ends.
+	send := func(t *testing.T, oracle string, approve bool) (string, string, error, error) {
+		var senderFingerprint, receiverFingerprint string
+		sendConfig := portal.Config{
+			RendezvousAddr: addr,
+			ConfirmReceiver: func(fingerprint string) (bool, error) {
+				senderFingerprint = fingerprint
+				return approve, nil
+			},
+		}
+		receiveConfig := portal.Config{
+			RendezvousAddr: addr,
+			OnFingerprint:  func(fingerprint string) { receiverFingerprint = fingerprint },
+		}
+		in := bytes.NewBufferString(oracle)
+		out := &bytes.Buffer{}
+
+		password, err, errC := portal.Send(ctx, in, int64(in.Len()), &sendConfig)
+		require.NoError(t, err)
+		receiveErr := portal.Receive(ctx, out, password, &receiveConfig)
+		sendErr := <-errC
+		if receiveErr == nil {
+			assert.Equal(t, oracle, out.String())
+		}
+		return senderFingerprint, receiverFingerprint, sendErr, receiveErr
+	}
+
+	t.Run("approved", func(t *testing.T) {
+		senderFingerprint, receiverFingerprint, sendErr, receiveErr := send(t, "A frog walks into a bank...", true)
+		require.NoError(t, sendErr)
+		require.NoError(t, receiveErr)
+		assert.NotEmpty(t, senderFingerprint)
+		assert.Equal(t, senderFingerprint, receiverFingerprint)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		senderFingerprint, receiverFingerprint, sendErr, receiveErr := send(t, "A frog walks into a bank...", false)
+		assert.ErrorIs(t, sendErr, sender.ErrReceiverRejected)
+		assert.Error(t, receiveErr)
+		assert.Equal(t, senderFingerprint, receiverFingerprint)
+	})
+}
+
 func setupRendezvous(ctx context.Context) (*rendezvousContainer, error) {
 	req := testcontainers.ContainerRequest{
 		Image:        "rendezvous:latest", // FIXME: ideally we want to run from dockerfile, not from prebuilt image.
@@ -78,3 +421,129 @@ func setupRendezvous(ctx context.Context) (*rendezvousContainer, error) {
 
 	return &rendezvousContainer{Container: container, URI: uri}, nil
 }
+
+func TestResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	resume := transfer.Resume{
+		Completed: []transfer.ResumedFile{{Name: "a.txt", Size: 1, SHA256: "digest"}},
+		Partial:   &transfer.ResumedFile{Name: "b.txt", Size: 1, SHA256: "digest"},
+	}
+	var asked *transfer.Resume
+	sendConfig := portal.Config{
+		RendezvousAddr: addr,
+		TransferID:     "frog",
+		OnResume: func(r transfer.Resume) (io.Reader, int64, error) {
+			asked = &r
+			return bytes.NewBufferString("the rest"), 8, nil
+		},
+	}
+	// receive resumes the transfer from the resume state, returning the received payload.
+	receive := func(t *testing.T, state *transfer.Resume) string {
+		asked = nil
+		password, err, errC := portal.Send(ctx, bytes.NewBufferString("everything"), 10, &sendConfig)
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		require.NoError(t, portal.Receive(ctx, out, password, &portal.Config{RendezvousAddr: addr, Resume: state}))
+		require.NoError(t, <-errC)
+		return out.String()
+	}
+
+	t.Run("resumed", func(t *testing.T) {
+		state := resume
+		assert.Equal(t, "the rest", receive(t, &state))
+		require.NotNil(t, asked)
+		assert.Equal(t, resume, *asked)
+		// the transfer id of the sender is recorded in the resume state.
+		assert.Equal(t, "frog", state.ID)
+	})
+	t.Run("same transfer", func(t *testing.T) {
+		state := resume
+		state.ID = "frog"
+		assert.Equal(t, "the rest", receive(t, &state))
+		require.NotNil(t, asked)
+	})
+	t.Run("another transfer", func(t *testing.T) {
+		state := resume
+		state.ID = "toad"
+		assert.Equal(t, "everything", receive(t, &state))
+		assert.Nil(t, asked, "the payload of another transfer is sent in full")
+		assert.Equal(t, transfer.Resume{ID: "frog"}, state, "the receiver starts over")
+	})
+}
+
+func TestSelect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx)
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	manifest := []transfer.ManifestFile{{Name: "a.txt", Size: 1}, {Name: "docs/b.pdf", Size: 2}, {Name: "docs/c.pdf", Size: 3}}
+	tests := []struct {
+		name     string
+		selected []string
+		repacked []string
+		received string
+	}{
+		{name: "selected files", selected: []string{"docs/b.pdf"}, repacked: []string{"docs/b.pdf"}, received: "selection"},
+		{name: "every file", selected: []string{"a.txt", "docs/c.pdf", "docs/b.pdf"}, received: "everything"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var repacked []string
+			sendConfig := portal.Config{
+				RendezvousAddr: addr,
+				Selection: &sender.Selection{
+					Manifest: manifest,
+					Repack: func(selected []string) (io.Reader, int64, error) {
+						repacked = selected
+						return bytes.NewBufferString("selection"), 9, nil
+					},
+				},
+			}
+			password, err, errC := portal.Send(ctx, bytes.NewBufferString("everything"), 10, &sendConfig)
+			require.NoError(t, err)
+			out := &bytes.Buffer{}
+			require.NoError(t, portal.Receive(ctx, out, password, &portal.Config{
+				RendezvousAddr: addr,
+				Select: func(m []transfer.ManifestFile) ([]string, error) {
+					assert.Equal(t, manifest, m)
+					return tc.selected, nil
+				},
+			}))
+			require.NoError(t, <-errC)
+			assert.Equal(t, tc.repacked, repacked)
+			assert.Equal(t, tc.received, out.String())
+		})
+	}
+
+	t.Run("nothing selected", func(t *testing.T) {
+		password, err, _ := portal.Send(ctx, bytes.NewBufferString("everything"), 10, &portal.Config{
+			RendezvousAddr: addr,
+			Selection:      &sender.Selection{Manifest: manifest},
+		})
+		require.NoError(t, err)
+		err = portal.Receive(ctx, &bytes.Buffer{}, password, &portal.Config{
+			RendezvousAddr: addr,
+			Select:         func([]transfer.ManifestFile) ([]string, error) { return nil, nil },
+		})
+		assert.ErrorIs(t, err, receiver.ErrNothingSelected)
+	})
+
+	t.Run("unsupported by the sender", func(t *testing.T) {
+		password, err, _ := portal.Send(ctx, bytes.NewBufferString("everything"), 10, &portal.Config{RendezvousAddr: addr})
+		require.NoError(t, err)
+		err = portal.Receive(ctx, &bytes.Buffer{}, password, &portal.Config{
+			RendezvousAddr: addr,
+			Select:         func([]transfer.ManifestFile) ([]string, error) { return []string{"a.txt"}, nil },
+		})
+		assert.ErrorIs(t, err, receiver.ErrSelectionUnsupported)
+	})
+}
@@ -0,0 +1,189 @@
+// ratelimit.go specifies the bandwidth limit of transfers, a token bucket applied to the payload as it is read by
+// the sender and written by the receiver, such that every stream and connection of a transfer shares the limit.
+package portal
+
+import (
+	"context"
+	"io"
+
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"golang.org/x/time/rate"
+)
+
+// NewLimiter returns a token bucket refilled with bytesPerSecond tokens per second, holding at most a second of
+// tokens. Returns nil if bytesPerSecond is not positive, such that transfers are unlimited.
+func NewLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// LimitSender returns the payload of a sender, and the payloads repacked by its compression and selection, limited
+// by the limiter, for senders transferring with the sender package rather than Send.
+func LimitSender(ctx context.Context, payload io.Reader, compression *sender.Compression, selection *sender.Selection, l *rate.Limiter) (io.Reader, *sender.Compression, *sender.Selection) {
+	_, selection = limitRepacked(ctx, nil, selection, l)
+	return limitReader(ctx, payload, l), limitCompression(ctx, compression, l), selection
+}
+
+// LimitWriter returns the destination of a receiver limited by the limiter, for receivers receiving with the
+// receiver package rather than Receive.
+func LimitWriter(ctx context.Context, dst io.Writer, l *rate.Limiter) io.Writer {
+	return limitWriter(ctx, dst, l)
+}
+
+// waitN waits until n bytes may be transferred, taking the tokens of at most a burst at a time.
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	for n > 0 {
+		take := n
+		if take > l.Burst() {
+			take = l.Burst()
+		}
+		if err := l.WaitN(ctx, take); err != nil {
+			return err
+		}
+		n -= take
+	}
+	return nil
+}
+
+// limitRepacked returns the resume and selection of the sender, with the payloads they repack limited by the
+// limiter.
+func limitRepacked(ctx context.Context, onResume sender.ResumeFunc, selection *sender.Selection, l *rate.Limiter) (sender.ResumeFunc, *sender.Selection) {
+	if l == nil {
+		return onResume, selection
+	}
+	if onResume != nil {
+		resume := onResume
+		onResume = func(r transfer.Resume) (io.Reader, int64, error) {
+			payload, size, err := resume(r)
+			return limitReader(ctx, payload, l), size, err
+		}
+	}
+	if selection != nil {
+		repack := selection.Repack
+		selection = &sender.Selection{
+			Manifest: selection.Manifest,
+			Repack: func(selected []string) (io.Reader, int64, error) {
+				payload, size, err := repack(selected)
+				return limitReader(ctx, payload, l), size, err
+			},
+		}
+	}
+	return onResume, selection
+}
+
+// limitCompression returns the compression of the sender, with the payload it repacks limited by the limiter.
+func limitCompression(ctx context.Context, compression *sender.Compression, l *rate.Limiter) *sender.Compression {
+	if l == nil || compression == nil || compression.Repack == nil {
+		return compression
+	}
+	repack := compression.Repack
+	return &sender.Compression{
+		RawSize: compression.RawSize,
+		Repack: func(codec string) (io.Reader, int64, error) {
+			payload, size, err := repack(codec)
+			return limitReader(ctx, payload, l), size, err
+		},
+	}
+}
+
+// limitedReader limits the rate bytes are read from the underlying reader.
+type limitedReader struct {
+	io.Reader
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (r limitedReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if werr := waitN(r.ctx, r.limiter, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// limitedSeeker is a limitedReader over a seekable reader, such that interrupted transfers can be resumed.
+type limitedSeeker struct {
+	limitedReader
+	seeker io.Seeker
+}
+
+func (r limitedSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// limitedReaderAt is a limitedSeeker over a reader supporting positioned reads, such that the payload can be
+// split over parallel streams.
+type limitedReaderAt struct {
+	limitedSeeker
+	readerAt io.ReaderAt
+}
+
+func (r limitedReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.readerAt.ReadAt(b, off)
+	if werr := waitN(r.ctx, r.limiter, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// limitReader returns a reader limited by the limiter, which is seekable and supports positioned reads if the
+// provided reader does. Returns the reader as is if the limiter is nil.
+func limitReader(ctx context.Context, r io.Reader, l *rate.Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	lr := limitedReader{Reader: r, ctx: ctx, limiter: l}
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return lr
+	}
+	ls := limitedSeeker{limitedReader: lr, seeker: s}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return limitedReaderAt{limitedSeeker: ls, readerAt: ra}
+	}
+	return ls
+}
+
+// limitedWriter limits the rate bytes are written to the underlying writer.
+type limitedWriter struct {
+	io.Writer
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (w limitedWriter) Write(b []byte) (int, error) {
+	if err := waitN(w.ctx, w.limiter, len(b)); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(b)
+}
+
+// limitedWriterAt is a limitedWriter over a writer supporting positioned writes, such that a payload split over
+// parallel streams can be received.
+type limitedWriterAt struct {
+	limitedWriter
+	writerAt io.WriterAt
+}
+
+func (w limitedWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	if err := waitN(w.ctx, w.limiter, len(b)); err != nil {
+		return 0, err
+	}
+	return w.writerAt.WriteAt(b, off)
+}
+
+// limitWriter returns a writer limited by the limiter, which supports positioned writes if the provided writer
+// does. Returns the writer as is if the limiter is nil.
+func limitWriter(ctx context.Context, w io.Writer, l *rate.Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	lw := limitedWriter{Writer: w, ctx: ctx, limiter: l}
+	if wa, ok := w.(io.WriterAt); ok {
+		return limitedWriterAt{limitedWriter: lw, writerAt: wa}
+	}
+	return lw
+}
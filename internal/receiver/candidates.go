@@ -0,0 +1,81 @@
+//go:build !js
+
+package receiver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"nhooyr.io/websocket"
+)
+
+// CONNECTION_ATTEMPT_DELAY is the delay between starting connection attempts to the candidates of the sender,
+// as recommended by RFC 8305 (Happy Eyeballs).
+const CONNECTION_ATTEMPT_DELAY = 250 * time.Millisecond
+
+// dialCandidates dials the candidate addresses of the sender Happy Eyeballs style: the attempts are started in
+// order, each once the previous one failed or CONNECTION_ATTEMPT_DELAY elapsed, and the first connection
+// established wins. The remaining attempts are canceled, and connections they establish nonetheless are closed.
+func dialCandidates(ctx context.Context, addrs []string, dial func(ctx context.Context, addr string) (conn.Conn, error)) (conn.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no candidate addresses of the sender")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	started, pending := 0, 0
+	start := func() {
+		addr := addrs[started]
+		started++
+		pending++
+		go func() {
+			c, err := dial(ctx, addr)
+			results <- dialResult{c: c, err: err}
+		}()
+	}
+
+	start()
+	var errs []error
+	for pending > 0 {
+		var next <-chan time.Time
+		if started < len(addrs) {
+			next = time.After(CONNECTION_ATTEMPT_DELAY)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go closeLate(results, pending)
+				return r.c, nil
+			}
+			errs = append(errs, r.err)
+			if started < len(addrs) {
+				start()
+			}
+		case <-next:
+			start()
+		case <-ctx.Done():
+			go closeLate(results, pending)
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+type dialResult struct {
+	c   conn.Conn
+	err error
+}
+
+// closeLate closes the connections established by the pending attempts of dialCandidates that lost the race.
+func closeLate(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.err == nil {
+			r.c.Close(websocket.StatusNormalClosure, "another candidate connected") //nolint:errcheck
+		}
+	}
+}
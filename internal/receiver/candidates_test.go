@@ -0,0 +1,79 @@
+//go:build !js
+
+package receiver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestDialCandidates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close(websocket.StatusNormalClosure, "")
+		ws.Read(r.Context()) //nolint:errcheck
+	}))
+	defer server.Close()
+	reachable := strings.TrimPrefix(server.URL, "http://")
+	// blackholed candidates never connect, as if their packets were dropped.
+	const blackholed, refused = "[2001:db8::1]:80", "refused"
+	dial := func(ctx context.Context, addr string) (conn.Conn, error) {
+		switch addr {
+		case blackholed:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case refused:
+			return nil, errors.New("connection refused")
+		}
+		return dialSender("", "", time.Second)(ctx, addr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("blackholed candidate", func(t *testing.T) {
+		start := time.Now()
+		c, err := dialCandidates(ctx, []string{blackholed, reachable}, dial)
+		require.NoError(t, err)
+		c.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+		assert.GreaterOrEqual(t, time.Since(start), CONNECTION_ATTEMPT_DELAY, "the next candidate is attempted after the delay")
+	})
+	t.Run("refused candidate", func(t *testing.T) {
+		start := time.Now()
+		c, err := dialCandidates(ctx, []string{refused, reachable}, dial)
+		require.NoError(t, err)
+		c.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+		assert.Less(t, time.Since(start), CONNECTION_ATTEMPT_DELAY, "the next candidate is attempted once the previous one failed")
+	})
+	t.Run("every candidate fails", func(t *testing.T) {
+		_, err := dialCandidates(ctx, []string{refused, refused}, dial)
+		assert.EqualError(t, err, "connection refused\nconnection refused", "the errors of every attempt are reported")
+		_, err = dialCandidates(ctx, nil, dial)
+		assert.Error(t, err)
+	})
+}
+
+func TestDirectAddrs(t *testing.T) {
+	payload := transfer.Payload{
+		IP: []byte{10, 0, 0, 2}, Port: 8080, Candidates: []string{"[fd00::2]:8080", "10.0.0.2:8080"},
+		QUICCandidates: []string{"10.0.0.2:8443"},
+	}
+	assert.Equal(t, []string{"quic://10.0.0.2:8443", "[fd00::2]:8080", "10.0.0.2:8080"}, directAddrs(payload, transfer.Transports), "QUIC candidates are dialed first")
+	assert.Equal(t, []string{"[fd00::2]:8080", "10.0.0.2:8080"}, directAddrs(payload, []string{transfer.TRANSPORT_WEBSOCKET}))
+	assert.Equal(t, []string{"quic://10.0.0.2:8443"}, directAddrs(payload, []string{transfer.TRANSPORT_QUIC}))
+	assert.Equal(t, []string{"10.0.0.2:8080"}, directAddrs(transfer.Payload{IP: []byte{10, 0, 0, 2}, Port: 8080}, transfer.Transports), "senders predating candidates")
+	assert.Empty(t, directAddrs(transfer.Payload{}, transfer.Transports))
+}
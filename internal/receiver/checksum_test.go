@@ -0,0 +1,100 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksums(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := make([]byte, 2*transfer.MIN_STREAM_BYTES+12345)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	// secure returns the secured connections of a sender and a receiver.
+	secure := func(t *testing.T) (conn.Transfer, conn.Transfer) {
+		rc, password, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, password)
+			senderC <- tc
+		}()
+		receiverRc, err := ConnectRendezvous(addr)
+		require.NoError(t, err)
+		tc, err := SecureConnection(ctx, receiverRc, password)
+		require.NoError(t, err)
+		return <-senderC, tc
+	}
+
+	for _, algorithm := range transfer.Checksums {
+		for _, streams := range []int{1, 2} {
+			t.Run(fmt.Sprintf("%s over %d streams", algorithm, streams), func(t *testing.T) {
+				stc, rtc := secure(t)
+				errC := make(chan error, 1)
+				go func() {
+					errC <- sender.Transfer(ctx, stc, bytes.NewReader(payload), int64(len(payload)),
+						sender.WithStreams(sender.Streams{Addr: addr, Count: streams}), sender.WithChecksum(algorithm))
+				}()
+				f, err := os.Create(filepath.Join(t.TempDir(), "payload"))
+				require.NoError(t, err)
+				defer f.Close()
+				require.NoError(t, Receive(ctx, rtc, f, relay, WithStreams(Streams{Addr: addr, Count: streams})))
+				require.NoError(t, <-errC)
+				received, err := os.ReadFile(f.Name())
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(payload, received), "received payload should match the sent payload")
+			})
+		}
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		stc, rtc := secure(t)
+		// the sender announces the checksum of a different payload.
+		go func() {
+			if _, err := stc.ReadMsg(ctx, transfer.ReceiverHandshake); err != nil {
+				return
+			}
+			stc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderHandshake, Payload: transfer.Payload{ //nolint:errcheck
+				PayloadSize: int64(len(payload)),
+				Checksum:    transfer.CHECKSUM_BLAKE3,
+			}})
+			stc.ReadMsg(ctx, transfer.ReceiverRelayCommunication)          //nolint:errcheck
+			stc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderRelayAck}) //nolint:errcheck
+			stc.ReadMsg(ctx, transfer.ReceiverRequestPayload)              //nolint:errcheck
+			stc.WriteRaw(ctx, payload)                                     //nolint:errcheck
+			digest, _ := checksum.NewDigest(transfer.CHECKSUM_BLAKE3)
+			digest.Write([]byte("a different payload"), 0)
+			stc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderPayloadSent, Payload: transfer.Payload{Digest: digest.Sum()}}) //nolint:errcheck
+		}()
+		err := Receive(ctx, rtc, io.Discard, relay)
+		assert.ErrorIs(t, err, checksum.ErrMismatch)
+	})
+}
@@ -0,0 +1,70 @@
+package receiver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+)
+
+// ErrDropNotFound is returned when the rendezvous server holds no drop for a code, e.g. as the drop expired or
+// was collected already.
+var ErrDropNotFound = errors.New("unknown, expired or collected drop")
+
+// Collect downloads the drop of the code from the rendezvous server and writes its payload to dst, opened with the
+// key carried by the code. The drop is deleted from the rendezvous server once its payload was written in full, best
+// effort as drops expire regardless.
+func Collect(ctx context.Context, client *http.Client, addr, code string, dst io.Writer) error {
+	id, key, err := ParseDropCode(code)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dropURL(addr, id), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading drop: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrDropNotFound
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("rendezvous server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := conn.Open(dst, resp.Body, key); err != nil {
+		return fmt.Errorf("opening drop, the code may be mistyped: %w", err)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodDelete, dropURL(addr, id), nil); err != nil {
+		return nil
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// ParseDropCode splits the code of a drop into the id issued by the rendezvous server and the key sealing the
+// payload.
+func ParseDropCode(code string) (string, []byte, error) {
+	id, encodedKey, ok := strings.Cut(strings.TrimSpace(code), ".")
+	key, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if !ok || id == "" || err != nil || len(key) != conn.SEAL_KEY_BYTES {
+		return "", nil, errors.New("invalid drop code")
+	}
+	return id, key, nil
+}
+
+func dropURL(addr, id string) string {
+	return conn.HTTPURL(addr, "/drops/"+url.PathEscape(id))
+}
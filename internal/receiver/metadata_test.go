@@ -0,0 +1,142 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/file"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// frameRecorder records the frames read from and written to the connection.
+type frameRecorder struct {
+	conn.Conn
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (c *frameRecorder) Read(ctx context.Context) ([]byte, error) {
+	b, err := c.Conn.Read(ctx)
+	if err == nil {
+		c.record(b)
+	}
+	return b, err
+}
+
+func (c *frameRecorder) Write(ctx context.Context, b []byte) error {
+	c.record(b)
+	return c.Conn.Write(ctx, b)
+}
+
+func (c *frameRecorder) ReadFrame(ctx context.Context) (conn.Frame, error) {
+	frame, err := conn.Rendezvous{Conn: c.Conn}.ReadFrame(ctx)
+	if err == nil {
+		c.record(frame.Payload)
+	}
+	return frame, err
+}
+
+func (c *frameRecorder) WriteFrame(ctx context.Context, frame conn.Frame) error {
+	c.record(frame.Payload)
+	return conn.Rendezvous{Conn: c.Conn}.WriteFrame(ctx, frame)
+}
+
+func (c *frameRecorder) record(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, append([]byte(nil), b...))
+}
+
+func TestOpaqueMetadata(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	const name = "quarterly-layoffs-draft.txt"
+	contents := bytes.Repeat([]byte("confidential "), 1024)
+	src := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(src, contents, 0644))
+	files, err := file.ReadFiles([]string{src})
+	require.NoError(t, err)
+	payload, size, err := file.PackFiles(files)
+	require.NoError(t, err)
+	defer os.Remove(payload.Name())
+	archive, err := io.ReadAll(payload)
+	require.NoError(t, err)
+	payload.Close()
+
+	senderRc, password, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	errC := make(chan error, 1)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, senderRc, password)
+		if err != nil {
+			errC <- err
+			return
+		}
+		errC <- sender.Transfer(ctx, tc, bytes.NewReader(archive), size)
+	}()
+
+	// the receiver records every frame exchanged with the relay, i.e. everything the relay observes.
+	rc, err := ConnectRendezvous(addr)
+	require.NoError(t, err)
+	rec := &frameRecorder{Conn: rc.Conn}
+	rc.Conn = rec
+	tc, err := SecureConnection(ctx, rc, password)
+	require.NoError(t, err)
+	handshake := len(rec.frames)
+	received := &bytes.Buffer{}
+	require.NoError(t, Receive(ctx, tc, received, relay))
+	require.NoError(t, <-errC)
+
+	// the receiver decrypts the metadata and names of the files.
+	dst := t.TempDir()
+	unpacker, err := file.NewUnpacker(false, io.NopCloser(received), file.WithOutput(file.OutputTarget{Dir: dst}))
+	require.NoError(t, err)
+	defer unpacker.Close()
+	c, err := unpacker.Unpack()
+	require.NoError(t, err)
+	_, err = c.Commit()
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(dst, name))
+	require.NoError(t, err)
+	assert.Equal(t, contents, b)
+
+	// the frames relayed after the handshake are opaque to the relay, except for the
+	// close message addressed to the relay itself.
+	require.Greater(t, len(rec.frames), handshake+1)
+	for _, frame := range rec.frames[handshake:] {
+		if json.Valid(frame) {
+			var control protocol.Control
+			require.NoError(t, json.Unmarshal(frame, &control))
+			require.NotNil(t, control.Control, "plaintext frame %s", frame)
+			assert.Equal(t, protocol.Msg{Type: protocol.ReceiverToRendezvousClose}, *control.Control, "plaintext frame %s", frame)
+			continue
+		}
+		assert.NotContains(t, string(frame), name)
+		assert.NotContains(t, string(frame), "confidential")
+		assert.NotContains(t, string(frame), "payload_size")
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// relayOnly fails every direct connection to the sender.
+type relayOnly struct{}
+
+func (relayOnly) Probe([]string, []byte) (conn.Transfer, error) {
+	return conn.Transfer{}, errors.New("direct transfers disabled")
+}
+
+func (relayOnly) Migrate(context.Context, []string, []byte) (conn.Transfer, error) {
+	return conn.Transfer{}, errors.New("direct transfers disabled")
+}
+
+// forceRelay returns the option disabling direct transfers and the migration of relayed transfers.
+func forceRelay() ReceiveOption {
+	return withDirectDialer(relayOnly{})
+}
+
+// migrateOnceAvailable relays transfers, migrating them once available is closed.
+type migrateOnceAvailable struct {
+	relayOnly
+	available chan struct{}
+}
+
+func (m migrateOnceAvailable) Migrate(ctx context.Context, addrs []string, key []byte) (conn.Transfer, error) {
+	select {
+	case <-m.available:
+	case <-ctx.Done():
+		return conn.Transfer{}, ctx.Err()
+	}
+	return senderDialer{}.Migrate(ctx, addrs, key)
+}
+
+// slowReader reads at most 32KiB at a time, pausing before each read, such that the transfer outlasts the migration.
+type slowReader struct{ r io.Reader }
+
+func (s slowReader) Read(b []byte) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	if len(b) > 32*1024 {
+		b = b[:32*1024]
+	}
+	return s.r.Read(b)
+}
+
+// notifyingWriter closes first once written to.
+type notifyingWriter struct {
+	bytes.Buffer
+	first sync.Once
+	done  chan struct{}
+}
+
+func (w *notifyingWriter) Write(b []byte) (int, error) {
+	w.first.Do(func() { close(w.done) })
+	return w.Buffer.Write(b)
+}
+
+func TestMigrateToDirect(t *testing.T) {
+	// the direct path becomes available once the first relayed payload is received.
+	available := make(chan struct{})
+	direct := withDirectDialer(migrateOnceAvailable{available: available})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := make([]byte, 4e6)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	rc, password, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	sent := make(chan error, 1)
+	senderMsgs := make(chan interface{}, 1024)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, password)
+		if err != nil {
+			sent <- err
+			return
+		}
+		sent <- sender.Transfer(ctx, tc, slowReader{bytes.NewReader(payload)}, int64(len(payload)), sender.WithMessages(senderMsgs))
+	}()
+
+	receiverRc, err := ConnectRendezvous(addr)
+	require.NoError(t, err)
+	tc, err := SecureConnection(ctx, receiverRc, password)
+	require.NoError(t, err)
+
+	received := &notifyingWriter{done: available}
+	receiverMsgs := make(chan interface{}, 1024)
+	require.NoError(t, Receive(ctx, tc, received, direct, WithMessages(receiverMsgs)))
+	require.NoError(t, <-sent)
+	assert.True(t, bytes.Equal(payload, received.Bytes()), "received payload should match the sent payload")
+
+	// both peers report the relayed transfer, and then the migration to the direct connection.
+	types := func(msgs chan interface{}) []transfer.Type {
+		close(msgs)
+		var types []transfer.Type
+		for msg := range msgs {
+			if typ, ok := msg.(transfer.Type); ok {
+				types = append(types, typ)
+			}
+		}
+		return types
+	}
+	assert.Equal(t, []transfer.Type{transfer.Relay, transfer.Direct}, types(receiverMsgs))
+	assert.Equal(t, []transfer.Type{transfer.Relay, transfer.Direct}, types(senderMsgs))
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
@@ -15,15 +16,20 @@ import (
 	"nhooyr.io/websocket"
 )
 
-// doReceive performs the transfer protocol on the receiving end.
+// doReceive performs the transfer protocol on the receiving end, connecting directly to the sender with direct,
+// or by dialing the server of the sender if direct is nil.
 // This function is built for all platforms except js
-func doReceive(ctx context.Context, relay conn.Transfer, addr string, dst io.Writer, msgs ...chan interface{}) error {
+func doReceive(ctx context.Context, relay conn.Transfer, direct directDialer, addrs []string, chunkSize int64, rtt time.Duration, payloadSize int64, algorithm string, dst io.Writer, streams Streams, window int, msgs ...chan interface{}) error {
 
 	// Retrieve a unencrypted channel to rendezvous.
 	rc := conn.Rendezvous{Conn: relay.Conn}
 	// Determine if we should do direct or relay transfer.
 	var tc conn.Transfer
-	direct, err := probeSender(addr, relay.Key())
+	var migrations <-chan conn.Conn
+	if direct == nil {
+		direct = senderDialer{}
+	}
+	probed, err := direct.Probe(addrs, relay.Key())
 	if err != nil {
 		tc = relay
 		// Communicate to the sender that we are using relay transfer.
@@ -38,28 +44,45 @@ func doReceive(ctx context.Context, relay conn.Transfer, addr string, dst io.Wri
 		if len(msgs) > 0 {
 			msgs[0] <- transfer.Relay
 		}
+
+		// Keep probing the sender, such that the transfer migrates once a direct connection succeeds.
+		probeCtx, stopProbing := context.WithCancel(ctx)
+		migrations = watchDirectPath(probeCtx, direct, addrs, relay.Key())
+		defer func() {
+			stopProbing()
+			if c, ok := <-migrations; ok {
+				c.Close(conn.CLOSE_CANCELED, "transfer not migrated") //nolint:errcheck
+			}
+		}()
 	} else {
-		tc = direct
+		tc = probed
 		// Communicate to the sender that we are doing direct communication.
 		if err := relay.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverDirectCommunication}); err != nil {
 			return err
 		}
 
 		// Tell rendezvous server that we can close the connection.
-		if err := rc.WriteMsg(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
+		if err := rc.WriteControl(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
 			return err
 		}
 
 		if len(msgs) > 0 {
 			msgs[0] <- transfer.Direct
 		}
+		// parallel streams and sequenced chunks are relayed, direct transfers use a single unsequenced stream.
+		streams = Streams{}
+		window = 0
 	}
 
 	// Request the payload and receive it.
-	if tc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverRequestPayload}) != nil {
+	if tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverRequestPayload,
+		Payload: transfer.Payload{ChunkSize: chunkSize, RTT: rtt, MaxStreams: streams.accepted(dst), Window: window},
+	}) != nil {
 		return err
 	}
-	if err := receivePayload(ctx, tc, dst, msgs...); err != nil {
+	received, err := receivePayload(ctx, &tc, dst, payloadSize, algorithm, streams, window, migrations, msgs...)
+	if err != nil {
 		return err
 	}
 
@@ -68,9 +91,7 @@ func doReceive(ctx context.Context, relay conn.Transfer, addr string, dst io.Wri
 		return err
 	}
 
-	_, err = tc.ReadMsg(ctx, transfer.SenderClosing)
-
-	if err != nil {
+	if err := awaitClosing(ctx, tc, received); err != nil {
 		return err
 	}
 
@@ -78,16 +99,40 @@ func doReceive(ctx context.Context, relay conn.Transfer, addr string, dst io.Wri
 		return err
 	}
 
+	// Migrated transfers already closed the relayed connection.
+	if migrations != nil && tc.Conn != relay.Conn {
+		conn.CloseWithError(tc.Conn, nil) //nolint:errcheck
+		return nil
+	}
+
 	// Tell rendezvous to close connection.
-	if err := rc.WriteMsg(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
+	if err := rc.WriteControl(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
 		return err
 	}
 	return nil
 }
 
-// probeSender will try to connect directly to the sender using a linear back off for up to 3 seconds.
-// Returns a transfer connection channel if it succeeds, otherwise it returns an error.
-func probeSender(addr string, key []byte) (conn.Transfer, error) {
+// senderDialer dials the server of the sender.
+type senderDialer struct{}
+
+// Probe implements directDialer.
+func (senderDialer) Probe(addrs []string, key []byte) (conn.Transfer, error) {
+	return probeSender(addrs, key)
+}
+
+// Migrate implements directDialer.
+func (senderDialer) Migrate(ctx context.Context, addrs []string, key []byte) (conn.Transfer, error) {
+	return probeSenderMigration(ctx, addrs, key)
+}
+
+// probeSender will try to connect directly to the sender using a linear back off for up to 3 seconds, racing the
+// candidate addresses of the sender on every try. Returns a transfer connection channel if it succeeds, otherwise
+// it returns an error.
+func probeSender(addrs []string, key []byte) (conn.Transfer, error) {
+	// senders negotiating no transport with the receiver announce no candidates.
+	if len(addrs) == 0 {
+		return conn.Transfer{}, fmt.Errorf("the sender accepts no direct connections")
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // wait at most 3 seconds.
 	defer cancel()
 	d := 250 * time.Millisecond
@@ -97,16 +142,73 @@ func probeSender(addr string, key []byte) (conn.Transfer, error) {
 			return conn.Transfer{}, fmt.Errorf("could not establish a connection to the sender server")
 
 		default:
-			ws, _, err := websocket.Dial(
-				context.Background(), fmt.Sprintf("ws://%s/portal", addr),
-				&websocket.DialOptions{HTTPClient: &http.Client{Timeout: d}},
-			)
+			c, err := dialCandidates(ctx, addrs, dialSender("portal", conn.QUIC_TRANSFER_PROTOCOL, d))
 			if err != nil {
 				time.Sleep(d)
 				d = d * 2
 				continue
 			}
-			return conn.TransferFromKey(&conn.WS{Conn: ws}, key), nil
+			return conn.TransferFromKey(c, key), nil
 		}
 	}
 }
+
+// dialSender returns a dial of the endpoint of the server of the sender at an address, with the provided timeout.
+// Addresses prefixed with quicScheme are dialed over QUIC, negotiating the ALPN protocol of the endpoint.
+func dialSender(endpoint, protocol string, timeout time.Duration) func(context.Context, string) (conn.Conn, error) {
+	return func(ctx context.Context, addr string) (conn.Conn, error) {
+		if host, ok := strings.CutPrefix(addr, quicScheme); ok {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			q, err := conn.DialQUIC(ctx, host, protocol)
+			if err != nil {
+				return nil, err
+			}
+			return q, nil
+		}
+		ws, _, err := websocket.Dial(
+			ctx, conn.WebsocketURL(addr, "/"+endpoint),
+			&websocket.DialOptions{HTTPClient: &http.Client{Timeout: timeout}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &conn.WS{Conn: ws}, nil
+	}
+}
+
+// probeSenderMigration tries to connect directly to the sender every MIGRATION_PROBE_INTERVAL, until it succeeds
+// or the context is done.
+func probeSenderMigration(ctx context.Context, addrs []string, key []byte) (conn.Transfer, error) {
+	for {
+		c, err := dialCandidates(ctx, addrs, dialSender("migrate", conn.QUIC_MIGRATE_PROTOCOL, MIGRATION_DIAL_TIMEOUT))
+		if err == nil {
+			return conn.TransferFromKey(c, key), nil
+		}
+		select {
+		case <-ctx.Done():
+			return conn.Transfer{}, ctx.Err()
+		case <-time.After(MIGRATION_PROBE_INTERVAL):
+		}
+	}
+}
+
+// watchDirectPath probes the sender of a relayed transfer for a direct connection with direct, asking the sender
+// to migrate the transfer once connected. The direct connection is delivered on the returned channel, which is
+// closed once the probing ended.
+func watchDirectPath(ctx context.Context, direct directDialer, addrs []string, key []byte) <-chan conn.Conn {
+	migrations := make(chan conn.Conn, 1)
+	go func() {
+		defer close(migrations)
+		tc, err := direct.Migrate(ctx, addrs, key)
+		if err != nil {
+			return
+		}
+		if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverMigrate}); err != nil {
+			conn.CloseWithError(tc.Conn, err) //nolint:errcheck
+			return
+		}
+		migrations <- tc.Conn
+	}()
+	return migrations
+}
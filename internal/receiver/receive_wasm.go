@@ -5,6 +5,7 @@ package receiver
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
@@ -13,7 +14,7 @@ import (
 
 // doReceive performs the transfer protocol on the receiving end.
 // This function is only built for the js platform.
-func doReceive(ctx context.Context, relayTc conn.Transfer, addr string, dst io.Writer, msgs ...chan interface{}) error {
+func doReceive(ctx context.Context, relayTc conn.Transfer, _ directDialer, addrs []string, chunkSize int64, rtt time.Duration, payloadSize int64, algorithm string, dst io.Writer, streams Streams, window int, msgs ...chan interface{}) error {
 	// Communicate to the sender that we are using relay transfer.
 	if err := relayTc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverRelayCommunication}); err != nil {
 		return err
@@ -28,10 +29,14 @@ func doReceive(ctx context.Context, relayTc conn.Transfer, addr string, dst io.W
 	}
 
 	// Request the payload and receive it.
-	if relayTc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverRequestPayload}) != nil {
+	if relayTc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverRequestPayload,
+		Payload: transfer.Payload{ChunkSize: chunkSize, RTT: rtt, MaxStreams: streams.accepted(dst), Window: window},
+	}) != nil {
 		return err
 	}
-	if err := receivePayload(ctx, relayTc, dst, msgs...); err != nil {
+	received, err := receivePayload(ctx, &relayTc, dst, payloadSize, algorithm, streams, window, nil, msgs...)
+	if err != nil {
 		return err
 	}
 
@@ -40,9 +45,7 @@ func doReceive(ctx context.Context, relayTc conn.Transfer, addr string, dst io.W
 		return err
 	}
 
-	_, err = relayTc.ReadMsg(ctx, transfer.SenderClosing)
-
-	if err != nil {
+	if err := awaitClosing(ctx, relayTc, received); err != nil {
 		return err
 	}
 
@@ -52,7 +55,7 @@ func doReceive(ctx context.Context, relayTc conn.Transfer, addr string, dst io.W
 
 	// Retrieve a unencrypted channel to rendezvous.
 	rc := conn.Rendezvous{Conn: relayTc.Conn}
-	if err := rc.WriteMsg(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
+	if err := rc.WriteControl(ctx, rendezvous.Msg{Type: rendezvous.ReceiverToRendezvousClose}); err != nil {
 		return err
 	}
 	return nil
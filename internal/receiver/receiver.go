@@ -3,9 +3,14 @@ package receiver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"time"
 
+	"github.com/SpatiumPortae/portal/internal/checksum"
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/password"
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
@@ -14,17 +19,59 @@ import (
 	"nhooyr.io/websocket"
 )
 
+// RECONNECT_ATTEMPTS is the number of times a dropped handshake is retried.
+const RECONNECT_ATTEMPTS = 3
+
+// RECONNECT_DELAY is the time waited before each reconnect, giving the rendezvous server time to restart the key exchange.
+const RECONNECT_DELAY = 500 * time.Millisecond
+
+// MIGRATION_PROBE_INTERVAL is the time between the attempts of a relayed receiver to connect directly to the sender,
+// and MIGRATION_DIAL_TIMEOUT the time each attempt waits for the sender.
+const (
+	MIGRATION_PROBE_INTERVAL = 5 * time.Second
+	MIGRATION_DIAL_TIMEOUT   = time.Second
+)
+
+// ErrPayloadTooLarge is returned when the payload exceeds the maximum size the receiver accepts.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// ErrSelectionUnsupported is returned when selecting the files of a sender that cannot send a selection of its files.
+var ErrSelectionUnsupported = errors.New("sender does not support selecting files")
+
+// ErrNothingSelected is returned when none of the files of the sender are selected.
+var ErrNothingSelected = errors.New("no files selected")
+
+// ErrCodeExpired is returned when the code of the sender expired before the receiver connected.
+var ErrCodeExpired = errors.New(rendezvous.CODE_EXPIRED)
+
+// ErrCodeUnknown is returned when no sender holds the code, e.g. if it was mistyped.
+var ErrCodeUnknown = errors.New(rendezvous.CODE_UNKNOWN)
+
+// ErrTooManyRelays is returned when the rendezvous server relays its maximum of concurrent transfers.
+var ErrTooManyRelays = errors.New(rendezvous.TOO_MANY_RELAYS)
+
 // ConnectRendezvous makes the initial connection to the rendezvous server.
-func ConnectRendezvous(addr string) (conn.Rendezvous, error) {
-	ws, _, err := websocket.Dial(context.Background(), fmt.Sprintf("ws://%s/establish-receiver", addr), nil)
+func ConnectRendezvous(addr string, opts ...conn.DialOption) (conn.Rendezvous, error) {
+	ws, err := conn.Dial(context.Background(), conn.WebsocketURL(addr, "/establish-receiver"), opts...)
 	if err != nil {
 		return conn.Rendezvous{}, err
 	}
-	return conn.Rendezvous{Conn: &conn.WS{Conn: ws}}, nil
+	return conn.Rendezvous{Conn: ws}, nil
 }
 
 // SecureConnection performs the cryptographic handshake to resolve a secure connection.
 func SecureConnection(ctx context.Context, rc conn.Rendezvous, pass string) (conn.Transfer, error) {
+	return secureConnection(ctx, rc, pass, false)
+}
+
+// SecureConnectionWaiting is SecureConnection for a password no sender may hold yet, waiting for a sender to
+// claim the password, bound by the receiver connect timeout of the rendezvous server. Rendezvous servers that
+// predate waiting receivers fail the handshake as for an unknown password.
+func SecureConnectionWaiting(ctx context.Context, rc conn.Rendezvous, pass string) (conn.Transfer, error) {
+	return secureConnection(ctx, rc, pass, true)
+}
+
+func secureConnection(ctx context.Context, rc conn.Rendezvous, pass string, wait bool) (conn.Transfer, error) {
 	// Convenience for messaging in this function.
 	type pakeMsg struct {
 		pake *pake.Pake
@@ -42,6 +89,7 @@ func SecureConnection(ctx context.Context, rc conn.Rendezvous, pass string) (con
 		Type: rendezvous.ReceiverToRendezvousEstablish,
 		Payload: rendezvous.Payload{
 			Password: password.Hashed(pass),
+			Wait:     wait,
 		},
 	}); err != nil {
 		return conn.Transfer{}, err
@@ -49,6 +97,17 @@ func SecureConnection(ctx context.Context, rc conn.Rendezvous, pass string) (con
 
 	msg, err := rc.ReadMsg(ctx, rendezvous.RendezvousToReceiverPAKE)
 	if err != nil {
+		var closeErr websocket.CloseError
+		if errors.As(err, &closeErr) {
+			switch closeErr.Reason {
+			case rendezvous.CODE_EXPIRED:
+				return conn.Transfer{}, ErrCodeExpired
+			case rendezvous.CODE_UNKNOWN:
+				return conn.Transfer{}, ErrCodeUnknown
+			case rendezvous.TOO_MANY_RELAYS:
+				return conn.Transfer{}, ErrTooManyRelays
+			}
+		}
 		return conn.Transfer{}, err
 	}
 	pm := <-pakeCh
@@ -81,54 +140,444 @@ func SecureConnection(ctx context.Context, rc conn.Rendezvous, pass string) (con
 	if err != nil {
 		return conn.Transfer{}, err
 	}
+	// senders predating negotiable key derivation parameters do not announce them.
+	iterations := msg.Payload.KDFIterations
+	if iterations == 0 {
+		iterations = conn.DEFAULT_KDF_ITERATIONS
+	}
+	if err := conn.ValidateKDFIterations(iterations); err != nil {
+		return conn.Transfer{}, fmt.Errorf("negotiating key derivation parameters: %w", err)
+	}
 
-	return conn.TransferFromSession(rc.Conn, session, msg.Payload.Salt), nil
+	return conn.TransferFromSession(rc.Conn, session, msg.Payload.Salt, iterations), nil
+}
+
+// Reconnect re-establishes a secure connection after the connection to the rendezvous server
+// dropped during the handshake. The sender restarts the key exchange from scratch, so a new
+// connection is made and the full handshake is performed again. Handshakes failing for another
+// reason than a dropped connection, see Reconnectable, are not retried.
+func Reconnect(ctx context.Context, addr string, pass string, opts ...conn.DialOption) (conn.Transfer, error) {
+	var err error
+	for attempt := 0; attempt < RECONNECT_ATTEMPTS; attempt++ {
+		select {
+		case <-ctx.Done():
+			return conn.Transfer{}, ctx.Err()
+		case <-time.After(RECONNECT_DELAY):
+		}
+		var rc conn.Rendezvous
+		if rc, err = ConnectRendezvous(addr, opts...); err != nil {
+			continue
+		}
+		var tc conn.Transfer
+		if tc, err = SecureConnection(ctx, rc, pass); err == nil {
+			return tc, nil
+		}
+		if !Reconnectable(err) {
+			return conn.Transfer{}, err
+		}
+	}
+	return conn.Transfer{}, fmt.Errorf("reconnecting to rendezvous server: %w", err)
+}
+
+// Reconnectable reports whether the handshake failed with err as the connection to the rendezvous server dropped or
+// was closed, such that it is retried with Reconnect. Handshakes failing for a wrong or expired code, or that the
+// rendezvous server refused, are not.
+func Reconnectable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var closeErr websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Reason {
+		case rendezvous.CODE_EXPIRED, rendezvous.CODE_UNKNOWN, rendezvous.TOO_MANY_RELAYS, rendezvous.TOO_MANY_RECEIVERS:
+			return false
+		}
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// SelectFunc selects the files of the manifest of the sender the receiver accepts, returning their names.
+type SelectFunc func(manifest []transfer.ManifestFile) ([]string, error)
+
+// directDialer connects directly to the sender of a transfer, bypassing the relay.
+type directDialer interface {
+	// Probe connects to the sender before the transfer starts, failing if the sender cannot be reached shortly.
+	Probe(addrs []string, key []byte) (conn.Transfer, error)
+	// Migrate connects to the sender of a relayed transfer, retrying until it succeeds or the context is done.
+	Migrate(ctx context.Context, addrs []string, key []byte) (conn.Transfer, error)
+}
+
+// ReceiveOption configures the receiving performed by Receive.
+type ReceiveOption func(*receiveOptions)
+
+type receiveOptions struct {
+	maxSize     int64
+	streams     Streams
+	resume      *transfer.Resume
+	selectFiles SelectFunc
+	window      int
+	chunkSize   int64
+	transport   string
+	raw         bool
+	msgs        []chan interface{}
+	direct      directDialer
+}
+
+// WithMaxSize refuses payloads larger than maxSize bytes with a ErrPayloadTooLarge, before any of the payload is
+// received. A maxSize of 0 imposes no limit.
+func WithMaxSize(maxSize int64) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.maxSize = maxSize
+	}
+}
+
+// WithStreams accepts a relayed payload split over up to streams.Count parallel streams. Only destinations
+// implementing io.WriterAt accept parallel streams.
+func WithStreams(streams Streams) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.streams = streams
+	}
+}
+
+// WithResume asks the sender to resume the transfer interrupted in a previous session described by resume. Senders
+// that cannot resume the transfer send the full payload.
+func WithResume(resume *transfer.Resume) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.resume = resume
+	}
+}
+
+// WithSelect asks the sender for the manifest of its files, receiving only the files selected by selectFiles.
+// Senders that cannot send a selection of their files fail the transfer with a ErrSelectionUnsupported, and
+// selecting no files fails it with a ErrNothingSelected. A nil selectFiles receives every file.
+func WithSelect(selectFiles SelectFunc) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.selectFiles = selectFiles
+	}
+}
+
+// WithWindow asks relaying senders to sequence the chunks of the payload such that chunks lost by the relay are
+// retransmitted rather than failing the transfer. Up to window chunks, at most transfer.MAX_WINDOW, are held out of
+// order. A window of 0 receives unsequenced chunks.
+func WithWindow(window int) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.window = window
+	}
+}
+
+// WithChunkSize proposes the provided chunk size to the sender rather than one suited to the round trip time of the
+// handshake. A chunk size of transfer.CHUNK_SIZE_ADAPTIVE asks the sender to adapt the chunks to the throughput of
+// the link, and a chunk size of 0 proposes one for the round trip time. Senders configured with a chunk size of
+// their own use it regardless.
+func WithChunkSize(size int64) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.chunkSize = size
+	}
+}
+
+// WithTransport connects directly to the sender over the provided transport, one of transfer.Transports, rather
+// than transfer.TRANSPORT_AUTO. Transfers with senders that cannot be connected to over it are relayed.
+func WithTransport(transport string) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.transport = transport
+	}
+}
+
+// WithRaw accepts raw byte streams, transfer.FORMAT_RAW, from senders if raw is set, for destinations the payload
+// is written to as is rather than unpacked as an archive. Transfers of raw streams to receivers not accepting them
+// fail before the payload is sent.
+func WithRaw(raw bool) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.raw = raw
+	}
+}
+
+// WithMessages communicates information about the receiving process on msgs while running, e.g. the bytes received
+// so far.
+func WithMessages(msgs chan interface{}) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.msgs = []chan interface{}{msgs}
+	}
+}
+
+// withDirectDialer connects directly to the sender with dialer rather than dialing its server, e.g. to force
+// relayed transfers in tests.
+func withDirectDialer(dialer directDialer) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.direct = dialer
+	}
 }
 
 // Receive receives the payload over the transfer connection and writes it into the provided destination.
 // The Transfer can either be direct or using a relay.
-// The msgs channel communicates information about the receiving process while running.
-func Receive(ctx context.Context, tc conn.Transfer, dst io.Writer, msgs ...chan interface{}) error {
-	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverHandshake}); err != nil {
+// The connection is closed with a close code describing how the transfer ended.
+func Receive(ctx context.Context, tc conn.Transfer, dst io.Writer, opts ...ReceiveOption) error {
+	options := receiveOptions{transport: transfer.TRANSPORT_AUTO}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.maxSize > 0 {
+		// guard against senders announcing a smaller payload than they send.
+		dst = &limitedWriter{w: dst, remaining: options.maxSize}
+	}
+	err := receive(ctx, tc, dst, options)
+	conn.CloseWithError(tc.Conn, err) //nolint:errcheck
+	return err
+}
+
+func receive(ctx context.Context, tc conn.Transfer, dst io.Writer, opts receiveOptions) error {
+	msgs := opts.msgs
+	if err := transfer.ValidateTransport(opts.transport); err != nil {
+		return err
+	}
+	transports := transfer.AcceptedTransports(opts.transport)
+	formats := transfer.Formats
+	if opts.raw {
+		formats = append(formats[:len(formats):len(formats)], transfer.FORMAT_RAW)
+	}
+	start := time.Now()
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverHandshake,
+		Payload: transfer.Payload{Codecs: transfer.Codecs, Formats: formats, Checksums: transfer.Checksums, Transports: transports, Resume: opts.resume, Select: opts.selectFiles != nil},
+	}); err != nil {
 		return err
 	}
 
-	msg, err := tc.ReadMsg(ctx, transfer.SenderHandshake)
+	msg, err := tc.ReadMsg(ctx)
 	if err != nil {
 		return err
 	}
-
+	// The handshake round trip is used to propose a chunk size suitable for the link.
+	rtt := time.Since(start)
+	chunkSize := opts.chunkSize
+	if chunkSize == 0 {
+		chunkSize = transfer.ChunkSizeForRTT(rtt)
+	}
+	if opts.selectFiles != nil {
+		if msg, err = selectPayload(ctx, tc, msg, opts.selectFiles); err != nil {
+			return err
+		}
+	}
+	if msg.Type != transfer.SenderHandshake {
+		return transfer.Error{Expected: []transfer.MsgType{transfer.SenderHandshake}, Got: msg.Type}
+	}
+	// the kept files of another transfer are not resumed, the sender sends its payload in full.
+	if opts.resume != nil && msg.Payload.TransferID != "" {
+		if opts.resume.ID != "" && opts.resume.ID != msg.Payload.TransferID {
+			*opts.resume = transfer.Resume{}
+		}
+		opts.resume.ID = msg.Payload.TransferID
+	}
+	if opts.maxSize > 0 && msg.Payload.PayloadSize > opts.maxSize {
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes", ErrPayloadTooLarge, msg.Payload.PayloadSize, opts.maxSize)
+	}
+	// senders predating checksums negotiate no checksum algorithm.
+	if msg.Payload.Checksum != "" {
+		if err := transfer.ValidateChecksum(msg.Payload.Checksum); err != nil {
+			return fmt.Errorf("negotiating checksum: %w", err)
+		}
+	}
 	if len(msgs) > 0 {
 		msgs[0] <- msg.Payload.PayloadSize
+		// senders predating codec negotiation announce no codec.
+		if msg.Payload.Codec != "" {
+			msgs[0] <- transfer.Compression{Codec: msg.Payload.Codec, RawSize: msg.Payload.RawSize}
+		}
+	}
+	return doReceive(ctx, tc, opts.direct, directAddrs(msg.Payload, transports), chunkSize, rtt, msg.Payload.PayloadSize, msg.Payload.Checksum, dst, opts.streams, transfer.NegotiateWindow(opts.window, 0), msgs...)
+}
+
+// quicScheme prefixes the candidate addresses of the sender dialed over QUIC, see directAddrs.
+const quicScheme = "quic://"
+
+// directAddrs returns the addresses the sender of the payload accepts direct connections on over the provided
+// transports, in order of preference: the QUIC candidates, prefixed with quicScheme, ahead of the websocket
+// candidates. Senders predating candidates only announce their IPv4 address.
+func directAddrs(payload transfer.Payload, transports []string) []string {
+	var addrs []string
+	if transfer.SupportsTransport(transports, transfer.TRANSPORT_QUIC) {
+		for _, candidate := range payload.QUICCandidates {
+			addrs = append(addrs, quicScheme+candidate)
+		}
+	}
+	switch {
+	case !transfer.SupportsTransport(transports, transfer.TRANSPORT_WEBSOCKET):
+	case len(payload.Candidates) > 0:
+		addrs = append(addrs, payload.Candidates...)
+	case payload.IP != nil:
+		addrs = append(addrs, net.JoinHostPort(payload.IP.String(), strconv.Itoa(payload.Port)))
+	}
+	return addrs
+}
+
+// selectPayload selects the files of the manifest sent by the sender, announcing the selected files and returning
+// the handshake of the sender that follows. Senders that cannot send a selection of their files send their handshake
+// instead of a manifest.
+func selectPayload(ctx context.Context, tc conn.Transfer, manifest transfer.Msg, selectFiles SelectFunc) (transfer.Msg, error) {
+	switch manifest.Type {
+	case transfer.SenderManifest:
+	case transfer.SenderHandshake:
+		return transfer.Msg{}, ErrSelectionUnsupported
+	default:
+		return transfer.Msg{}, transfer.Error{Expected: []transfer.MsgType{transfer.SenderManifest}, Got: manifest.Type}
+	}
+	selected, err := selectFiles(manifest.Payload.Manifest)
+	if err != nil {
+		return transfer.Msg{}, fmt.Errorf("selecting files: %w", err)
+	}
+	if len(selected) == 0 {
+		return transfer.Msg{}, ErrNothingSelected
+	}
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverSelection,
+		Payload: transfer.Payload{Selected: selected},
+	}); err != nil {
+		return transfer.Msg{}, err
+	}
+	return tc.ReadMsg(ctx, transfer.SenderHandshake)
+}
+
+// reportChecksum reports the verified checksum of the payload on the messages of the transfer, if a checksum
+// algorithm was negotiated.
+func reportChecksum(digest *checksum.Digest, msgs ...chan interface{}) {
+	if digest != nil && len(msgs) > 0 {
+		msgs[0] <- digest.Result()
 	}
-	return doReceive(ctx, tc, fmt.Sprintf("%s:%d", msg.Payload.IP, msg.Payload.Port), dst, msgs...)
 }
 
-// receivePayload receives the payload over the provided connection and writes it into the desired location.
-func receivePayload(ctx context.Context, tc conn.Transfer, dst io.Writer, msgs ...chan interface{}) error {
+// receivePayload receives the payload over the provided connection and writes it into the desired location,
+// returning the number of bytes received. Senders resuming a lost connection are told the number of bytes
+// received so far, such that they can resume sending from there. Payloads split over parallel streams
+// are received over the streams if accepted. The payload is verified against the checksum of the sender,
+// if a checksum algorithm was negotiated, returning a checksum.ErrMismatch if it does not match. Senders migrating
+// the transfer switch the connection to the direct connection received on migrations. Payloads sent in sequenced
+// chunks are received holding up to window chunks out of order, if a window was requested.
+func receivePayload(ctx context.Context, tc *conn.Transfer, dst io.Writer, payloadSize int64, algorithm string, streams Streams, window int, migrations <-chan conn.Conn, msgs ...chan interface{}) (int64, error) {
 	writtenBytes := 0
+	accepted := streams.accepted(dst)
+	digest, err := checksum.NewDigest(algorithm)
+	if err != nil {
+		return 0, err
+	}
 	for {
 		b, err := tc.ReadRaw(ctx)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		msg := transfer.Msg{}
 		err = json.Unmarshal(b, &msg)
 		if err != nil {
 			n, err := dst.Write(b)
 			if err != nil {
-				return err
+				return 0, err
 			}
+			digest.Write(b[:n], int64(writtenBytes))
 			writtenBytes += n
 			if len(msgs) > 0 {
 				msgs[0] <- writtenBytes
 			}
-		} else {
-			if msg.Type != transfer.SenderPayloadSent {
-				return transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: msg.Type}
+			continue
+		}
+		switch msg.Type {
+		case transfer.SenderPayloadSent:
+			if err := digest.Verify(msg.Payload.Digest); err != nil {
+				return 0, err
+			}
+			reportChecksum(digest, msgs...)
+			return int64(writtenBytes), nil
+		case transfer.SenderStreams:
+			if accepted == 0 || writtenBytes > 0 {
+				return 0, transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: msg.Type}
+			}
+			received, err := receiveStreams(ctx, msg.Payload.Streams, payloadSize, accepted, algorithm, streams, dst.(io.WriterAt), msgs...)
+			if err != nil {
+				return 0, err
+			}
+			writtenBytes = int(received)
+			// the streams are verified individually.
+			digest = nil
+		case transfer.SenderSequenced:
+			if window == 0 || writtenBytes > 0 {
+				return 0, transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: msg.Type}
 			}
-			break
+			return receiveSequenced(ctx, *tc, dst, msg.Payload.Window, window, digest, msgs...)
+		case transfer.SenderResume:
+			if err := writeResumeOffset(ctx, *tc, int64(writtenBytes), false); err != nil {
+				return 0, err
+			}
+		case transfer.SenderMigrate:
+			if err := migrate(ctx, tc, migrations); err != nil {
+				return 0, err
+			}
+			if len(msgs) > 0 {
+				msgs[0] <- transfer.Direct
+			}
+		default:
+			return 0, transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: msg.Type}
 		}
 	}
+}
+
+// migrate switches the transfer to the direct connection received on migrations, once the sender announced that no
+// more payload is relayed. The relayed connection is closed, such that the rendezvous server tears down the relay.
+func migrate(ctx context.Context, tc *conn.Transfer, migrations <-chan conn.Conn) error {
+	var direct conn.Conn
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case direct = <-migrations:
+	}
+	// senders only migrate to the direct connection of the receiver.
+	if direct == nil {
+		return transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: transfer.SenderMigrate}
+	}
+	relay := tc.Conn
+	tc.Conn = direct
+	go relay.Close(conn.CLOSE_COMPLETED, transfer.MIGRATED) //nolint:errcheck
 	return nil
 }
+
+// awaitClosing waits for the sender to close the transfer once the payload is acknowledged, telling
+// senders resuming a lost connection that the payload of the provided size has been acknowledged.
+func awaitClosing(ctx context.Context, tc conn.Transfer, received int64) error {
+	for {
+		msg, err := tc.ReadMsg(ctx)
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case transfer.SenderClosing:
+			return nil
+		case transfer.SenderResume:
+			if err := writeResumeOffset(ctx, tc, received, true); err != nil {
+				return err
+			}
+		default:
+			return transfer.Error{Expected: []transfer.MsgType{transfer.SenderClosing}, Got: msg.Type}
+		}
+	}
+}
+
+// writeResumeOffset tells a resuming sender the number of bytes received, and whether the payload has been acknowledged.
+func writeResumeOffset(ctx context.Context, tc conn.Transfer, received int64, acked bool) error {
+	return tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverResumeOffset,
+		Payload: transfer.Payload{Offset: received, PayloadAcked: acked},
+	})
+}
+
+// limitedWriter writes to the underlying writer until the remaining bytes are exhausted.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > l.remaining {
+		return 0, ErrPayloadTooLarge
+	}
+	l.remaining -= int64(len(b))
+	return l.w.Write(b)
+}
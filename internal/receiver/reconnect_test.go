@@ -0,0 +1,56 @@
+//go:build !js
+
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestReconnectable(t *testing.T) {
+	for _, err := range []error{
+		io.EOF,
+		fmt.Errorf("reading: %w", io.ErrUnexpectedEOF),
+		&net.OpError{Op: "dial", Err: errors.New("connection refused")},
+		websocket.CloseError{Code: websocket.StatusGoingAway},
+	} {
+		assert.True(t, Reconnectable(err), err)
+	}
+	for _, err := range []error{
+		nil,
+		ErrCodeUnknown,
+		ErrCodeExpired,
+		context.Canceled,
+		errors.New("pake: invalid point"),
+		websocket.CloseError{Code: websocket.StatusInternalError, Reason: protocol.TOO_MANY_RECEIVERS},
+	} {
+		assert.False(t, Reconnectable(err), err)
+	}
+}
+
+func TestReconnectWrongCode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// a code no sender holds fails right away with the real error, rather than being retried.
+	start := time.Now()
+	_, err := Reconnect(ctx, addr, "1-wrong-code-here")
+	assert.ErrorIs(t, err, ErrCodeUnknown)
+	assert.Less(t, time.Since(start), 2*RECONNECT_DELAY)
+}
@@ -0,0 +1,84 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// killingConn abruptly closes the underlying websocket connection on the nth write.
+type killingConn struct {
+	*conn.WS
+	writes int32
+	killAt int32
+	killed atomic.Bool
+}
+
+func (c *killingConn) Write(ctx context.Context, b []byte) error {
+	if atomic.AddInt32(&c.writes, 1) == c.killAt {
+		c.killed.Store(true)
+		c.WS.Conn.CloseNow() //nolint:errcheck
+		return errors.New("connection killed")
+	}
+	return c.WS.Write(ctx, b)
+}
+
+func TestSenderResume(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := make([]byte, 8e6)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	rc, password, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	sent := make(chan error, 1)
+	var killing *killingConn
+	secured := make(chan struct{})
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, password)
+		if err != nil {
+			close(secured)
+			sent <- err
+			return
+		}
+		// kill the connection after the handshake and the first payload chunks are sent.
+		killing = &killingConn{WS: tc.Conn.(*conn.WS), killAt: 5}
+		tc.Conn = killing
+		close(secured)
+		sent <- sender.Transfer(ctx, tc, bytes.NewReader(payload), int64(len(payload)))
+	}()
+
+	receiverRc, err := ConnectRendezvous(addr)
+	require.NoError(t, err)
+	tc, err := SecureConnection(ctx, receiverRc, password)
+	require.NoError(t, err)
+	<-secured
+
+	var received bytes.Buffer
+	require.NoError(t, Receive(ctx, tc, &received, relay))
+	require.NoError(t, <-sent)
+	assert.True(t, killing.killed.Load(), "sender connection should have been killed")
+	assert.True(t, bytes.Equal(payload, received.Bytes()), "received payload should match the sent payload")
+}
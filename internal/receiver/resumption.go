@@ -0,0 +1,140 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// ErrResumptionNotFound is returned when the rendezvous server holds no state for a resumption token,
+// e.g. as the state expired.
+var ErrResumptionNotFound = errors.New("unknown or expired resumption token")
+
+// number of bytes of the random key sealing the resumption state.
+const resumptionKeyBytes = 32
+
+// StoreResumption seals the resumption state with a random key and stores it on the rendezvous server, returning
+// the token to present to LoadResumption along with the time the state expires. The token carries the key, such
+// that the rendezvous server never learns the names of the files kept by the receiver.
+func StoreResumption(ctx context.Context, client *http.Client, addr string, state transfer.Resume) (string, time.Time, error) {
+	plain, err := json.Marshal(state)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	key := make([]byte, resumptionKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", time.Time{}, err
+	}
+	crypt := conn.NewCrypt(key, nil, conn.DEFAULT_KDF_ITERATIONS)
+	sealed, err := crypt.Encrypt(plain)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sealing resumption state: %w", err)
+	}
+	body, err := json.Marshal(rendezvous.Resumption{State: sealed})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var stored rendezvous.Resumption
+	if err := doResumption(ctx, client, http.MethodPost, conn.HTTPURL(addr, "/resumption"), bytes.NewReader(body), &stored); err != nil {
+		return "", time.Time{}, fmt.Errorf("storing resumption state: %w", err)
+	}
+	// the expiry is told on the clock of the receiver, rendezvous servers not reporting the time left only report
+	// the expiry on their own clock.
+	expires := stored.Expires
+	if stored.ExpiresIn > 0 {
+		expires = time.Now().Add(stored.ExpiresIn)
+	}
+	return stored.Token + "." + base64.RawURLEncoding.EncodeToString(key), expires, nil
+}
+
+// LoadResumption loads the resumption state of the token from the rendezvous server, returns ErrResumptionNotFound
+// if the server holds no state for the token.
+func LoadResumption(ctx context.Context, client *http.Client, addr, token string) (transfer.Resume, error) {
+	id, key, err := parseResumptionToken(token)
+	if err != nil {
+		return transfer.Resume{}, err
+	}
+	var loaded rendezvous.Resumption
+	if err := doResumption(ctx, client, http.MethodGet, resumptionURL(addr, id), nil, &loaded); err != nil {
+		return transfer.Resume{}, fmt.Errorf("loading resumption state: %w", err)
+	}
+	if len(loaded.State) < 12 {
+		return transfer.Resume{}, errors.New("invalid resumption state")
+	}
+	crypt := conn.NewCrypt(key, nil, conn.DEFAULT_KDF_ITERATIONS)
+	plain, err := crypt.Decrypt(loaded.State)
+	if err != nil {
+		return transfer.Resume{}, fmt.Errorf("unsealing resumption state, the token may be mistyped: %w", err)
+	}
+	var state transfer.Resume
+	if err := json.Unmarshal(plain, &state); err != nil {
+		return transfer.Resume{}, fmt.Errorf("parsing resumption state: %w", err)
+	}
+	return state, nil
+}
+
+// DeleteResumption deletes the resumption state of the token from the rendezvous server, once the transfer completed.
+func DeleteResumption(ctx context.Context, client *http.Client, addr, token string) error {
+	id, _, err := parseResumptionToken(token)
+	if err != nil {
+		return err
+	}
+	if err := doResumption(ctx, client, http.MethodDelete, resumptionURL(addr, id), nil, nil); err != nil {
+		return fmt.Errorf("deleting resumption state: %w", err)
+	}
+	return nil
+}
+
+// parseResumptionToken splits the token into the id issued by the rendezvous server and the key sealing the state.
+func parseResumptionToken(token string) (string, []byte, error) {
+	id, encodedKey, ok := strings.Cut(strings.TrimSpace(token), ".")
+	key, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if !ok || id == "" || err != nil || len(key) != resumptionKeyBytes {
+		return "", nil, errors.New("invalid resumption token")
+	}
+	return id, key, nil
+}
+
+func resumptionURL(addr, id string) string {
+	return conn.HTTPURL(addr, "/resumption/"+url.PathEscape(id))
+}
+
+// doResumption sends the request to the resumption endpoint, decoding the response into out if provided.
+func doResumption(ctx context.Context, client *http.Client, method, url string, body io.Reader, out *rendezvous.Resumption) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method != http.MethodPost:
+		return ErrResumptionNotFound
+	case resp.StatusCode >= http.StatusBadRequest:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("rendezvous server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	case out == nil:
+		return nil
+	}
+	// the state is base64 encoded in the response, hence the margin.
+	return json.NewDecoder(io.LimitReader(resp.Body, 2*rendezvous.MAX_RESUMPTION_STATE_BYTES)).Decode(out)
+}
@@ -0,0 +1,105 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumption(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithResumptions(rendezvous.NewResumptions(rendezvous.DEFAULT_RESUMPTION_TTL, rendezvous.DEFAULT_RESUMPTION_STORE_SIZE)))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	state := transfer.Resume{
+		Completed: []transfer.ResumedFile{{Name: "a.txt", Size: 5, SHA256: "digest-a"}},
+		Partial:   &transfer.ResumedFile{Name: "b.txt", Size: 3, SHA256: "digest-b"},
+	}
+	token, expires, err := StoreResumption(ctx, &http.Client{}, addr, state)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(rendezvous.DEFAULT_RESUMPTION_TTL), expires, time.Minute)
+
+	t.Run("fresh client", func(t *testing.T) {
+		// a new client, e.g. on another machine, only holds the token.
+		loaded, err := LoadResumption(ctx, &http.Client{}, addr, token)
+		require.NoError(t, err)
+		require.Equal(t, state, loaded)
+
+		rc, password, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		var asked transfer.Resume
+		sent := make(chan error, 1)
+		go func() {
+			tc, err := sender.SecureConnection(ctx, rc, password)
+			if err != nil {
+				sent <- err
+				return
+			}
+			sent <- sender.Transfer(ctx, tc, strings.NewReader("everything"), 10,
+				sender.WithResume(func(r transfer.Resume) (io.Reader, int64, error) {
+					asked = r
+					return strings.NewReader("the rest"), 8, nil
+				}))
+		}()
+		receiverRc, err := ConnectRendezvous(addr)
+		require.NoError(t, err)
+		tc, err := SecureConnection(ctx, receiverRc, password)
+		require.NoError(t, err)
+		var received bytes.Buffer
+		require.NoError(t, Receive(ctx, tc, &received, relay, WithResume(&loaded)))
+		require.NoError(t, <-sent)
+		assert.Equal(t, state, asked)
+		assert.Equal(t, "the rest", received.String())
+
+		require.NoError(t, DeleteResumption(ctx, &http.Client{}, addr, token))
+		_, err = LoadResumption(ctx, &http.Client{}, addr, token)
+		assert.ErrorIs(t, err, ErrResumptionNotFound)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		token, _, err := StoreResumption(ctx, &http.Client{}, addr, state)
+		require.NoError(t, err)
+		id, _, _ := strings.Cut(token, ".")
+		_, err = LoadResumption(ctx, &http.Client{}, addr, id+".AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrResumptionNotFound)
+
+		for _, invalid := range []string{"", id, id + ".", id + ".short"} {
+			_, err = LoadResumption(ctx, &http.Client{}, addr, invalid)
+			assert.Error(t, err, invalid)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expiring := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithResumptions(rendezvous.NewResumptions(50*time.Millisecond, rendezvous.DEFAULT_RESUMPTION_STORE_SIZE)))
+		go expiring.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return expiring.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		expiringAddr := fmt.Sprintf("localhost:%d", expiring.Addr().(*net.TCPAddr).Port)
+
+		token, _, err := StoreResumption(ctx, &http.Client{}, expiringAddr, state)
+		require.NoError(t, err)
+		time.Sleep(100 * time.Millisecond)
+		_, err = LoadResumption(ctx, &http.Client{}, expiringAddr, token)
+		assert.ErrorIs(t, err, ErrResumptionNotFound)
+	})
+}
@@ -0,0 +1,126 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// errInvalidStreams is returned when the streams announced by the sender do not cover the payload.
+var errInvalidStreams = errors.New("sender announced streams not covering the payload")
+
+// Streams configures the parallel streams the receiver accepts, each relayed through its own mailbox
+// on the rendezvous server at Addr. A Count of at most 1 receives the payload over a single stream.
+type Streams struct {
+	Addr  string
+	Count int
+	Opts  []conn.DialOption
+}
+
+// accepted returns the number of parallel streams accepted by the receiver writing into dst,
+// streams are only accepted by destinations supporting positioned writes.
+func (s Streams) accepted(dst io.Writer) int {
+	if _, ok := dst.(io.WriterAt); !ok || s.Count <= 1 {
+		return 0
+	}
+	if s.Count > transfer.MAX_STREAMS {
+		return transfer.MAX_STREAMS
+	}
+	return s.Count
+}
+
+// receiveStreams receives the payload of the provided size over the parallel streams announced by the sender,
+// writing each range at its offset in dst. Returns the number of bytes received.
+func receiveStreams(ctx context.Context, streams []transfer.Stream, payloadSize int64, accepted int, algorithm string, s Streams, dst io.WriterAt, msgs ...chan interface{}) (int64, error) {
+	if len(streams) > accepted || !transfer.ValidateStreams(streams, payloadSize) {
+		return 0, errInvalidStreams
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := &progress{msgs: msgs}
+	errs := make(chan error, len(streams))
+	for _, stream := range streams {
+		go func(stream transfer.Stream) {
+			errs <- receiveStream(ctx, stream, algorithm, s, dst, p)
+		}(stream)
+	}
+	var err error
+	for range streams {
+		if streamErr := <-errs; streamErr != nil && err == nil {
+			err = fmt.Errorf("receiving stream: %w", streamErr)
+			cancel()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return payloadSize, nil
+}
+
+// receiveStream connects to the stream and writes its range of the payload at its offset in dst,
+// acknowledging the range once received and verified against its checksum, if a checksum algorithm
+// was negotiated. The connection is closed once the stream ends.
+func receiveStream(ctx context.Context, stream transfer.Stream, algorithm string, s Streams, dst io.WriterAt, p *progress) (err error) {
+	rc, err := ConnectRendezvous(s.Addr, s.Opts...)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.CloseWithError(rc.Conn, err) }() //nolint:errcheck
+	tc, err := SecureConnection(ctx, rc, stream.Password)
+	if err != nil {
+		return err
+	}
+	digest, err := checksum.NewDigest(algorithm)
+	if err != nil {
+		return err
+	}
+	var received int64
+	for received < stream.Length {
+		b, err := tc.ReadRaw(ctx)
+		if err != nil {
+			return err
+		}
+		if received+int64(len(b)) > stream.Length {
+			return fmt.Errorf("stream at offset %d exceeds its length of %d bytes", stream.Offset, stream.Length)
+		}
+		if _, err := dst.WriteAt(b, stream.Offset+received); err != nil {
+			return err
+		}
+		digest.Write(b, received)
+		received += int64(len(b))
+		p.add(len(b))
+	}
+	if digest != nil {
+		msg, err := tc.ReadMsg(ctx, transfer.SenderPayloadSent)
+		if err != nil {
+			return err
+		}
+		if err := digest.Verify(msg.Payload.Digest); err != nil {
+			return err
+		}
+	}
+	return tc.WriteMsg(ctx, transfer.Msg{Type: transfer.ReceiverPayloadAck})
+}
+
+// progress reports the total number of payload bytes received over all streams.
+type progress struct {
+	mu       sync.Mutex
+	received int
+	msgs     []chan interface{}
+}
+
+func (p *progress) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received += n
+	if len(p.msgs) > 0 {
+		p.msgs[0] <- p.received
+	}
+}
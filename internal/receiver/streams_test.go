@@ -0,0 +1,128 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// offsetRecorder records the offsets of the positioned writes to the file.
+type offsetRecorder struct {
+	*os.File
+	mu      sync.Mutex
+	offsets map[int64]bool
+}
+
+func (f *offsetRecorder) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	f.offsets[off] = true
+	f.mu.Unlock()
+	return f.File.WriteAt(b, off)
+}
+
+func TestStreams(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the payload does not divide evenly, such that the last stream carries the remainder.
+	payload := make([]byte, 4*transfer.MIN_STREAM_BYTES+12345)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	send := func(streams sender.Streams) (string, chan error) {
+		rc, password, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		errC := make(chan error, 1)
+		go func() {
+			tc, err := sender.SecureConnection(ctx, rc, password)
+			if err != nil {
+				errC <- err
+				return
+			}
+			errC <- sender.Transfer(ctx, tc, bytes.NewReader(payload), int64(len(payload)), sender.WithStreams(streams))
+		}()
+		return password, errC
+	}
+	receive := func(password string, dst io.Writer, streams Streams) (progress []int) {
+		rc, err := ConnectRendezvous(addr)
+		require.NoError(t, err)
+		tc, err := SecureConnection(ctx, rc, password)
+		require.NoError(t, err)
+		msgs := make(chan interface{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for msg := range msgs {
+				if n, ok := msg.(int); ok {
+					progress = append(progress, n)
+				}
+			}
+		}()
+		require.NoError(t, Receive(ctx, tc, dst, relay, WithStreams(streams), WithMessages(msgs)))
+		close(msgs)
+		<-done
+		return progress
+	}
+
+	tests := []struct {
+		name     string
+		sender   int
+		receiver int
+	}{
+		{name: "parallel", sender: 4, receiver: transfer.MAX_STREAMS},
+		{name: "capped by receiver", sender: transfer.MAX_STREAMS, receiver: 3},
+		{name: "single stream", sender: 1, receiver: transfer.MAX_STREAMS},
+		{name: "refused by receiver", sender: 4, receiver: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			password, errC := send(sender.Streams{Addr: addr, Count: tc.sender})
+			f, err := os.Create(filepath.Join(t.TempDir(), "payload"))
+			require.NoError(t, err)
+			defer f.Close()
+			dst := &offsetRecorder{File: f, offsets: map[int64]bool{}}
+			progress := receive(password, dst, Streams{Addr: addr, Count: tc.receiver})
+			require.NoError(t, <-errC)
+
+			// every stream writes the start of its range, a single stream writes sequentially.
+			n := transfer.NegotiateStreams(tc.sender, tc.receiver, int64(len(payload)))
+			if n == 1 {
+				assert.Empty(t, dst.offsets)
+			} else {
+				for _, s := range transfer.SplitStreams(int64(len(payload)), n) {
+					assert.True(t, dst.offsets[s.Offset], "no stream written at offset %d", s.Offset)
+				}
+			}
+
+			received, err := os.ReadFile(f.Name())
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(payload, received), "received payload differs from the sent payload")
+			require.NotEmpty(t, progress)
+			assert.Equal(t, len(payload), progress[len(progress)-1])
+			assert.IsIncreasing(t, progress)
+		})
+	}
+}
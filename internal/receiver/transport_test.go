@@ -0,0 +1,106 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpBlocked dials the sender as if UDP was blocked, such that its QUIC candidates never connect.
+type udpBlocked struct{}
+
+func (udpBlocked) Probe(addrs []string, key []byte) (conn.Transfer, error) {
+	return probeSender(blackholeQUIC(addrs), key)
+}
+
+func (udpBlocked) Migrate(ctx context.Context, addrs []string, key []byte) (conn.Transfer, error) {
+	return probeSenderMigration(ctx, blackholeQUIC(addrs), key)
+}
+
+// blackholeQUIC replaces the QUIC candidates of addrs by an address of TEST-NET-1, whose packets are dropped.
+func blackholeQUIC(addrs []string) []string {
+	blackholed := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, quicScheme) {
+			addr = quicScheme + "192.0.2.1:9"
+		}
+		blackholed = append(blackholed, addr)
+	}
+	return blackholed
+}
+
+func TestTransports(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	payload := make([]byte, 2e6)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	tests := []struct {
+		name     string
+		sender   string
+		receiver string
+		opts     []ReceiveOption
+		expected transfer.Type
+	}{
+		{name: "auto", sender: transfer.TRANSPORT_AUTO, receiver: transfer.TRANSPORT_AUTO, expected: transfer.Direct},
+		{name: "quic", sender: transfer.TRANSPORT_QUIC, receiver: transfer.TRANSPORT_QUIC, expected: transfer.Direct},
+		{name: "websocket", sender: transfer.TRANSPORT_AUTO, receiver: transfer.TRANSPORT_WEBSOCKET, expected: transfer.Direct},
+		{name: "udp blocked", sender: transfer.TRANSPORT_AUTO, receiver: transfer.TRANSPORT_AUTO, opts: []ReceiveOption{withDirectDialer(udpBlocked{})}, expected: transfer.Direct},
+		{name: "no common transport", sender: transfer.TRANSPORT_QUIC, receiver: transfer.TRANSPORT_WEBSOCKET, expected: transfer.Relay},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, password, err := sender.ConnectRendezvous(ctx, addr)
+			require.NoError(t, err)
+			sent := make(chan error, 1)
+			go func() {
+				senderTc, err := sender.SecureConnection(ctx, rc, password)
+				if err != nil {
+					sent <- err
+					return
+				}
+				sent <- sender.Transfer(ctx, senderTc, bytes.NewReader(payload), int64(len(payload)), sender.WithTransport(tc.sender))
+			}()
+
+			receiverRc, err := ConnectRendezvous(addr)
+			require.NoError(t, err)
+			receiverTc, err := SecureConnection(ctx, receiverRc, password)
+			require.NoError(t, err)
+			var received bytes.Buffer
+			msgs := make(chan interface{}, 1024)
+			opts := append([]ReceiveOption{WithTransport(tc.receiver), WithMessages(msgs)}, tc.opts...)
+			require.NoError(t, Receive(ctx, receiverTc, &received, opts...))
+			require.NoError(t, <-sent)
+			assert.True(t, bytes.Equal(payload, received.Bytes()), "received payload should match the sent payload")
+
+			close(msgs)
+			var types []transfer.Type
+			for msg := range msgs {
+				if typ, ok := msg.(transfer.Type); ok {
+					types = append(types, typ)
+				}
+			}
+			assert.Equal(t, []transfer.Type{tc.expected}, types)
+		})
+	}
+}
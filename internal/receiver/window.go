@@ -0,0 +1,162 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// errInvalidWindow is returned when the window announced by the sender exceeds the window of the receiver.
+var errInvalidWindow = errors.New("sender announced a window exceeding the receive window")
+
+// receiveSequenced receives the payload in the sequenced chunks announced by the sender, writing the chunks into
+// dst in order. Up to window chunks are held out of order, missing chunks are NAKed such that the sender
+// retransmits them. The payload is verified against the checksum of the sender, if a checksum algorithm was
+// negotiated. Returns the number of bytes received.
+func receiveSequenced(ctx context.Context, tc conn.Transfer, dst io.Writer, window, accepted int, digest *checksum.Digest, msgs ...chan interface{}) (int64, error) {
+	if window <= 0 || window > accepted {
+		return 0, errInvalidWindow
+	}
+	r := &receiveWindow{
+		tc:       tc,
+		dst:      dst,
+		digest:   digest,
+		window:   uint64(window),
+		held:     make(map[uint64][]byte, window),
+		naked:    make(map[uint64]bool),
+		ackEvery: uint64(window+1) / 2,
+		msgs:     msgs,
+	}
+	var (
+		sent bool   // whether the sender sent the complete payload
+		sum  string // checksum of the payload sent
+	)
+	for !sent || r.next < r.total {
+		b, err := tc.ReadRaw(ctx)
+		if err != nil {
+			return 0, err
+		}
+		msg := transfer.Msg{}
+		if err := json.Unmarshal(b, &msg); err != nil {
+			seq, chunk, err := transfer.DecodeChunk(b)
+			if err != nil {
+				return 0, err
+			}
+			if sent && seq >= r.total {
+				return 0, fmt.Errorf("sequenced chunk %d beyond the %d chunks sent", seq, r.total)
+			}
+			if err := r.receive(ctx, seq, chunk); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if msg.Type != transfer.SenderPayloadSent || sent {
+			return 0, transfer.Error{Expected: []transfer.MsgType{transfer.SenderPayloadSent}, Got: msg.Type}
+		}
+		if msg.Payload.Sequence < r.next {
+			return 0, fmt.Errorf("sender sent %d chunks, received %d", msg.Payload.Sequence, r.next)
+		}
+		sent, sum, r.total = true, msg.Payload.Digest, msg.Payload.Sequence
+		// the chunks lost at the end of the payload are only noticed once the sender sent the payload.
+		if err := r.nak(ctx, r.total, false); err != nil {
+			return 0, err
+		}
+	}
+	if err := digest.Verify(sum); err != nil {
+		return 0, err
+	}
+	reportChecksum(digest, msgs...)
+	return r.written, nil
+}
+
+// receiveWindow holds the sequenced chunks received out of order, until the chunks preceding them are received.
+type receiveWindow struct {
+	tc       conn.Transfer
+	dst      io.Writer
+	digest   *checksum.Digest
+	window   uint64
+	held     map[uint64][]byte
+	naked    map[uint64]bool // sequence numbers of the missing chunks NAKed
+	next     uint64          // sequence number of the next chunk written
+	seen     uint64          // one past the highest sequence number received
+	total    uint64          // number of chunks sent, once the sender sent the payload
+	acked    uint64          // number of chunks acknowledged to the sender
+	ackEvery uint64          // chunks written between acknowledgements
+	written  int64
+	msgs     []chan interface{}
+}
+
+// receive receives the sequenced chunk, writing the chunks held in order and NAKing the chunks preceding it that
+// are missing. Chunks received again tell that the sender retransmitted on a timeout, and are answered with the
+// chunks received and missing.
+func (r *receiveWindow) receive(ctx context.Context, seq uint64, chunk []byte) error {
+	if _, held := r.held[seq]; held || seq < r.next {
+		if err := r.ack(ctx); err != nil {
+			return err
+		}
+		return r.nak(ctx, r.seen, true)
+	}
+	if seq >= r.next+r.window {
+		return fmt.Errorf("sequenced chunk %d beyond the window of %d chunks from %d", seq, r.window, r.next)
+	}
+	r.held[seq] = chunk
+	delete(r.naked, seq)
+	if seq >= r.seen {
+		r.seen = seq + 1
+	}
+	if err := r.nak(ctx, seq, false); err != nil {
+		return err
+	}
+	for chunk, ok := r.held[r.next]; ok; chunk, ok = r.held[r.next] {
+		n, err := r.dst.Write(chunk)
+		if err != nil {
+			return err
+		}
+		r.digest.Write(chunk[:n], r.written)
+		r.written += int64(n)
+		delete(r.held, r.next)
+		r.next++
+		if len(r.msgs) > 0 {
+			r.msgs[0] <- int(r.written)
+		}
+	}
+	if r.next-r.acked >= r.ackEvery {
+		return r.ack(ctx)
+	}
+	return nil
+}
+
+// ack acknowledges the chunks received in order.
+func (r *receiveWindow) ack(ctx context.Context) error {
+	r.acked = r.next
+	return r.tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverChunkAck,
+		Payload: transfer.Payload{Sequence: r.next},
+	})
+}
+
+// nak asks the sender to retransmit the chunks missing before the provided sequence number, only NAKing the chunks
+// NAKed before again if renak is set.
+func (r *receiveWindow) nak(ctx context.Context, before uint64, renak bool) error {
+	var missing []uint64
+	for seq := r.next; seq < before; seq++ {
+		if _, held := r.held[seq]; held || (r.naked[seq] && !renak) {
+			continue
+		}
+		r.naked[seq] = true
+		missing = append(missing, seq)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return r.tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.ReceiverChunkNak,
+		Payload: transfer.Payload{Missing: missing},
+	})
+}
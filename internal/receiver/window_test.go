@@ -0,0 +1,129 @@
+//go:build !js
+
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// frame is a connection reading a single frame, such that frames can be decrypted outside of the transfer.
+type frame []byte
+
+func (f frame) Read(context.Context) ([]byte, error)     { return f, nil }
+func (f frame) Write(context.Context, []byte) error      { return nil }
+func (f frame) Close(websocket.StatusCode, string) error { return nil }
+
+// lossyConn drops the sequenced chunks read from the connection, as many times as listed in drops.
+type lossyConn struct {
+	conn.Conn
+	key      []byte
+	drops    map[uint64]int
+	received map[uint64]int // number of times each sequenced chunk was received, dropped or not
+}
+
+func (c *lossyConn) Read(ctx context.Context) ([]byte, error) {
+	for {
+		b, err := c.Conn.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := conn.TransferFromKey(frame(b), c.key).ReadRaw(ctx)
+		if err != nil || json.Valid(dec) {
+			return b, nil
+		}
+		seq, _, err := transfer.DecodeChunk(dec)
+		if err != nil {
+			return b, nil
+		}
+		c.received[seq]++
+		if c.drops[seq] > 0 {
+			c.drops[seq]--
+			continue
+		}
+		return b, nil
+	}
+}
+
+func TestRetransmission(t *testing.T) {
+	relay := forceRelay()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the relay on localhost negotiates the smallest chunks, a payload of 13 chunks.
+	payload := make([]byte, 12*transfer.MIN_CHUNK_BYTES+123)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	rc, password, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	errC := make(chan error, 1)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, password)
+		if err != nil {
+			errC <- err
+			return
+		}
+		errC <- sender.Transfer(ctx, tc, bytes.NewReader(payload), int64(len(payload)))
+	}()
+
+	rrc, err := ConnectRendezvous(addr)
+	require.NoError(t, err)
+	tc, err := SecureConnection(ctx, rrc, password)
+	require.NoError(t, err)
+	// chunk 3 is dropped again once retransmitted, such that it is only received after the retransmit timeout,
+	// chunk 12 is the last chunk, only NAKed once the sender sent the payload.
+	lossy := &lossyConn{
+		Conn:     tc.Conn,
+		key:      tc.Key(),
+		drops:    map[uint64]int{1: 1, 3: 2, 5: 1, 6: 1, 12: 1},
+		received: map[uint64]int{},
+	}
+	tc.Conn = lossy
+
+	msgs := make(chan interface{})
+	done := make(chan struct{})
+	var progress []int
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			if n, ok := msg.(int); ok {
+				progress = append(progress, n)
+			}
+		}
+	}()
+	var received bytes.Buffer
+	require.NoError(t, Receive(ctx, tc, &received, relay, WithWindow(4), WithMessages(msgs)))
+	close(msgs)
+	<-done
+	require.NoError(t, <-errC)
+
+	assert.Equal(t, payload, received.Bytes())
+	require.NotEmpty(t, progress)
+	assert.IsIncreasing(t, progress)
+	assert.Equal(t, len(payload), progress[len(progress)-1])
+	for seq, n := range map[uint64]int{1: 2, 3: 3, 5: 2, 6: 2, 12: 2} {
+		assert.GreaterOrEqual(t, lossy.received[seq], n, "chunk %d not retransmitted", seq)
+	}
+	assert.Len(t, lossy.received, 13)
+}
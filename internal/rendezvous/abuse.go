@@ -0,0 +1,231 @@
+// abuse.go specifies the abuse protection of the endpoints of the server, such that a single client cannot exhaust
+// a public server: the rate at which a client registers mailboxes, the websocket connections it holds at once, and
+// a ban of clients repeatedly presenting invalid tokens, backing off exponentially with every further failure.
+package rendezvous
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DEFAULT_AUTH_BAN_MAX is the longest clients presenting invalid tokens are banned for.
+const DEFAULT_AUTH_BAN_MAX = time.Hour
+
+// ABUSE_SWEEP_INTERVAL is the interval at which the state kept on clients that no longer need it is forgotten.
+const ABUSE_SWEEP_INTERVAL = time.Minute
+
+// asTime converts a monotonic reading of the clock of the server to the time the rate limiters are driven with,
+// offset from the zero time such that new limiters start out full.
+func asTime(monotonic time.Duration) time.Time {
+	return time.Unix(0, 0).Add(monotonic)
+}
+
+// ---------------------------------------------------- Registrations --------------------------------------------------
+
+// registrations limits the rate at which each client identity registers mailboxes, a token bucket per identity
+// refilled with perMinute tokens per minute and holding at most a minute of tokens. Safe for concurrent use.
+type registrations struct {
+	perMinute int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	swept    time.Duration // monotonic time of the last sweep
+}
+
+func newRegistrations(perMinute int) *registrations {
+	return &registrations{perMinute: perMinute, limiters: make(map[string]*rate.Limiter)}
+}
+
+// Allow registers a mailbox for the identity at the monotonic time now. Returns false, along with the time until
+// the identity may register again, if the identity registered its mailboxes of the minute.
+func (r *registrations) Allow(identity string, now time.Duration) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(now)
+	l, ok := r.limiters[identity]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(float64(r.perMinute)/time.Minute.Seconds()), r.perMinute)
+		r.limiters[identity] = l
+	}
+	reservation := l.ReserveN(asTime(now), 1)
+	if delay := reservation.DelayFrom(asTime(now)); delay > 0 {
+		reservation.CancelAt(asTime(now))
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep forgets the limiters refilled in full, as of at most ABUSE_SWEEP_INTERVAL ago.
+func (r *registrations) sweep(now time.Duration) {
+	if now-r.swept < ABUSE_SWEEP_INTERVAL {
+		return
+	}
+	r.swept = now
+	for identity, l := range r.limiters {
+		if l.TokensAt(asTime(now)) >= float64(l.Burst()) {
+			delete(r.limiters, identity)
+		}
+	}
+}
+
+// limitRegistrations rejects senders registering mailboxes faster than the registration rate of the server with
+// 429 Too Many Requests.
+func (s *Server) limitRegistrations(next http.Handler) http.Handler {
+	if s.registrations == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromRequest(r)
+		if ok, retryAfter := s.registrations.Allow(identity, s.clock.Monotonic()); !ok {
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Warn("identity exceeded registration rate", zap.String("identity", s.loggedIP(identity)))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "too many mailboxes registered, retry later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ----------------------------------------------------- Connections ---------------------------------------------------
+
+// limitConnections rejects clients holding as many websocket connections as the server allows per identity with
+// 429 Too Many Requests, before the connection is upgraded.
+func (s *Server) limitConnections(next http.Handler) http.Handler {
+	if s.connections == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromRequest(r)
+		if !s.connections.Acquire(identity) {
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Warn("identity exceeded connection limit", zap.String("identity", s.loggedIP(identity)))
+			}
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
+		defer s.connections.Release(identity)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ------------------------------------------------------- Auth ban ----------------------------------------------------
+
+// authFailures are the failed authorizations of a client identity.
+type authFailures struct {
+	count       int
+	bannedUntil time.Duration // monotonic time the ban of the identity ends
+}
+
+// authBans bans client identities presenting invalid tokens, for base after the first failure and doubling with
+// every further failure up to max. Failures are forgotten once an identity went max without failing, or was
+// authorized. Safe for concurrent use.
+type authBans struct {
+	base, max time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+	swept    time.Duration // monotonic time of the last sweep
+}
+
+func newAuthBans(base, max time.Duration) *authBans {
+	if max < base {
+		max = base
+	}
+	return &authBans{base: base, max: max, failures: make(map[string]*authFailures)}
+}
+
+// Banned returns the time until the ban of the identity ends at the monotonic time now, zero if not banned.
+func (b *authBans) Banned(identity string, now time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.failures[identity]; ok && f.bannedUntil > now {
+		return f.bannedUntil - now
+	}
+	return 0
+}
+
+// Fail records a failed authorization of the identity at the monotonic time now, returning the ban of the identity.
+func (b *authBans) Fail(identity string, now time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+	f, ok := b.failures[identity]
+	if !ok {
+		f = &authFailures{}
+		b.failures[identity] = f
+	}
+	f.count++
+	ban := b.max
+	// the shift overflows beyond 62 failures, banning for max long before.
+	if f.count <= 62 {
+		if d := b.base << (f.count - 1); d > 0 && d < b.max {
+			ban = d
+		}
+	}
+	f.bannedUntil = now + ban
+	return ban
+}
+
+// Succeed forgets the failed authorizations of the identity.
+func (b *authBans) Succeed(identity string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, identity)
+}
+
+// sweep forgets the failures of the identities that went max without failing, as of at most ABUSE_SWEEP_INTERVAL ago.
+func (b *authBans) sweep(now time.Duration) {
+	if now-b.swept < ABUSE_SWEEP_INTERVAL {
+		return
+	}
+	b.swept = now
+	for identity, f := range b.failures {
+		if now-f.bannedUntil > b.max {
+			delete(b.failures, identity)
+		}
+	}
+}
+
+// rejectBanned rejects the request with 429 Too Many Requests if its client is banned for presenting invalid
+// tokens. Returns whether the request was rejected.
+func (s *Server) rejectBanned(w http.ResponseWriter, r *http.Request) bool {
+	if s.authBans == nil {
+		return false
+	}
+	identity := identityFromRequest(r)
+	banned := s.authBans.Banned(identity, s.clock.Monotonic())
+	if banned == 0 {
+		return false
+	}
+	if logger, err := logger.FromContext(r.Context()); err == nil {
+		logger.Warn("rejecting banned client", zap.String("identity", s.loggedIP(identity)), zap.Duration("banned_for", banned))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(banned.Seconds()))))
+	http.Error(w, "too many failed authorizations, retry later", http.StatusTooManyRequests)
+	return true
+}
+
+// recordAuth records the authorization decision of the request towards the ban of its client.
+func (s *Server) recordAuth(r *http.Request, authorized bool) {
+	if s.authBans == nil {
+		return
+	}
+	identity := identityFromRequest(r)
+	if authorized {
+		s.authBans.Succeed(identity)
+		return
+	}
+	ban := s.authBans.Fail(identity, s.clock.Monotonic())
+	if logger, err := logger.FromContext(r.Context()); err == nil {
+		logger.Warn("banning client presenting invalid token", zap.String("identity", s.loggedIP(identity)), zap.Duration("ban", ban))
+	}
+}
@@ -0,0 +1,105 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrations(t *testing.T) {
+	r := newRegistrations(2)
+	now := time.Duration(0)
+	for i := 0; i < 2; i++ {
+		ok, _ := r.Allow("client", now)
+		require.True(t, ok)
+	}
+	ok, retryAfter := r.Allow("client", now)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, retryAfter)
+	ok, _ = r.Allow("other", now)
+	assert.True(t, ok, "identities should be limited separately")
+
+	now += 30 * time.Second
+	ok, _ = r.Allow("client", now)
+	assert.True(t, ok, "tokens should refill over the minute")
+
+	now += 2 * ABUSE_SWEEP_INTERVAL
+	r.Allow("client", now)
+	assert.Len(t, r.limiters, 1, "refilled limiters should be forgotten")
+}
+
+func TestAuthBans(t *testing.T) {
+	b := newAuthBans(time.Second, 5*time.Second)
+	now := time.Duration(0)
+	assert.Zero(t, b.Banned("client", now))
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, want, b.Fail("client", now))
+		assert.Equal(t, want, b.Banned("client", now))
+	}
+	assert.Zero(t, b.Banned("other", now))
+	assert.Zero(t, b.Banned("client", now+5*time.Second))
+
+	b.Succeed("client")
+	assert.Equal(t, time.Second, b.Fail("client", now), "authorized clients should start over")
+
+	now += time.Second + 5*time.Second + ABUSE_SWEEP_INTERVAL
+	b.Fail("other", now)
+	assert.NotContains(t, b.failures, "client", "clients not failing for a while should be forgiven")
+}
+
+func TestAbuseProtection(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	const token = "admin-token"
+	s := NewServer(0, token, semver.Version{}, WithRegistrationRate(1), WithMaxConnectionsPerIdentity(2), WithAuthBan(time.Minute, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	t.Run("registrations and connections", func(t *testing.T) {
+		_, _, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		_, _, err = sender.ConnectRendezvous(ctx, addr)
+		assert.ErrorIs(t, err, conn.ErrRejected, "the second registration of the minute should be rejected")
+
+		// the sender holds a connection, the second connection is the last one allowed.
+		second, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+		require.NoError(t, err)
+		defer second.Conn.CloseNow() //nolint:errcheck
+		_, err = conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+		assert.ErrorIs(t, err, conn.ErrRejected)
+	})
+
+	t.Run("auth ban", func(t *testing.T) {
+		evict := func(auth string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/admin/evict-idle?olderThan=1h", addr), nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", auth)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp
+		}
+		assert.Equal(t, http.StatusOK, evict("Bearer "+token).StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, evict("Bearer wrong-token").StatusCode)
+		resp := evict("Bearer " + token)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "banned clients should be rejected despite a valid token")
+		assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	})
+}
@@ -0,0 +1,78 @@
+// acme.go specifies the automatic provisioning of the TLS certificates of the server via ACME, e.g. Let's Encrypt,
+// such that the server is served over TLS without certificates managed by the operator or a reverse proxy.
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DEFAULT_ACME_CHALLENGE_ADDR is the address HTTP-01 challenges are answered on, ACME servers only validate
+// challenges on port 80.
+const DEFAULT_ACME_CHALLENGE_ADDR = ":80"
+
+// NewACMEManager constructs a manager provisioning certificates for the provided domains from Let's Encrypt,
+// cached in cacheDir such that certificates are not requested again across restarts. The email, if not empty, is
+// registered with the ACME account to be notified about problems with the certificates.
+func NewACMEManager(domains []string, cacheDir, email string) (*autocert.Manager, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("provisioning certificates requires at least one domain")
+	}
+	if cacheDir == "" {
+		return nil, errors.New("provisioning certificates requires a cache directory")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}, nil
+}
+
+// serveACMEChallenges answers the HTTP-01 challenges of the ACME manager on the challenge address until the
+// provided context is done, redirecting other plain HTTP requests to HTTPS. Certificates are also provisioned
+// over TLS-ALPN-01 on the listener of the server, if the ACME server reaches it on port 443.
+func (s *Server) serveACMEChallenges(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.acmeAddr)
+	if err != nil {
+		return fmt.Errorf("serving ACME challenges: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           s.acme.HTTPHandler(nil),
+		ReadHeaderTimeout: DEFAULT_READ_HEADER_TIMEOUT,
+		ErrorLog:          s.httpServer.ErrorLog,
+	}
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("serving ACME challenges", zap.Error(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctxShutdown) //nolint:errcheck
+	}()
+	s.mu.Lock()
+	s.acmeListener = l
+	s.mu.Unlock()
+	return nil
+}
+
+// ACMEChallengeAddr returns the address HTTP-01 challenges are answered on, or nil if certificates are not
+// provisioned via ACME or the server is not yet serving.
+func (s *Server) ACMEChallengeAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.acmeListener == nil {
+		return nil
+	}
+	return s.acmeListener.Addr()
+}
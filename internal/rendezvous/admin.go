@@ -0,0 +1,242 @@
+// admin.go specifies the admin dashboard and API of the server, such that operators can watch the live state of the
+// server and close stuck mailboxes. Both are only served to operators presenting an auth token of the server.
+package rendezvous
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ADMIN_REFRESH_INTERVAL is the interval the admin dashboard is refreshed at.
+const ADMIN_REFRESH_INTERVAL = 5 * time.Second
+
+// adminStats counts the connections and relayed bytes of the server reported to operators, whether or not metrics
+// are served.
+type adminStats struct {
+	started     time.Time
+	connections atomic.Int64 // open sender and receiver connections
+	relayed     atomic.Int64 // bytes relayed to peers
+
+	mu         sync.Mutex
+	sampled    time.Time
+	sampledAt  int64 // bytes relayed at the previous sample
+	throughput int64 // bytes relayed per second between the previous samples
+}
+
+func newAdminStats() *adminStats {
+	now := time.Now()
+	return &adminStats{started: now, sampled: now}
+}
+
+// Connected counts an open connection, returning a function counting it closed.
+func (a *adminStats) Connected() func() {
+	a.connections.Add(1)
+	return func() { a.connections.Add(-1) }
+}
+
+// Relayed counts n bytes relayed to a peer.
+func (a *adminStats) Relayed(n int) {
+	a.relayed.Add(int64(n))
+}
+
+// Throughput returns the bytes relayed per second, sampled at most every LOAD_SAMPLE_INTERVAL.
+func (a *adminStats) Throughput() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if elapsed := now.Sub(a.sampled); elapsed >= LOAD_SAMPLE_INTERVAL {
+		relayed := a.relayed.Load()
+		a.throughput = int64(float64(relayed-a.sampledAt) / elapsed.Seconds())
+		a.sampled, a.sampledAt = now, relayed
+	}
+	return a.throughput
+}
+
+// Status returns the live state of the server.
+func (s *Server) Status() rendezvous.Status {
+	mailboxes := map[string]int{}
+	for _, state := range []MailboxState{MailboxWaiting, MailboxHandshake, MailboxRelaying} {
+		mailboxes[state.String()] = 0
+	}
+	for _, m := range s.Mailboxes() {
+		mailboxes[m.State]++
+	}
+	return rendezvous.Status{
+		Version:        s.version.String(),
+		UptimeSeconds:  int64(time.Since(s.admin.started).Seconds()),
+		Mailboxes:      mailboxes,
+		Connections:    s.admin.connections.Load(),
+		BytesRelayed:   s.admin.relayed.Load(),
+		BytesPerSecond: s.admin.Throughput(),
+	}
+}
+
+// Mailboxes returns the allocated mailboxes of the server, read live rather than from a snapshot such that closed
+// mailboxes are no longer listed.
+func (s *Server) Mailboxes() []rendezvous.Mailbox {
+	mailboxes := []rendezvous.Mailbox{}
+	s.mailboxes.Range(func(_, v any) bool {
+		m := v.(*Mailbox)
+		mailboxes = append(mailboxes, rendezvous.Mailbox{
+			ID:              m.id,
+			State:           m.State().String(),
+			Created:         m.created,
+			IdleMS:          m.Idle().Milliseconds(),
+			BytesToSender:   m.toSender.Load(),
+			BytesToReceiver: m.toReceiver.Load(),
+		})
+		return true
+	})
+	return mailboxes
+}
+
+// CloseMailbox closes the connections of the mailboxes of the id with a MAILBOX_CLOSED reason. Relaying mailboxes
+// are evicted, closing both peers, other mailboxes are deallocated and their senders closed. Returns the number of
+// closed mailboxes.
+func (s *Server) CloseMailbox(id int) int {
+	return s.closeMailboxes(rendezvous.MAILBOX_CLOSED, func(m *Mailbox) bool { return m.id == id })
+}
+
+// handleStatus returns a handler responding with the live state of the server.
+func (s *Server) handleStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, s.Status())
+	}
+}
+
+// handleMailboxes returns a handler responding with the allocated mailboxes of the server.
+func (s *Server) handleMailboxes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, s.Mailboxes())
+	}
+}
+
+// handleCloseMailbox returns a handler that closes the mailboxes of the id in the path, responding with the number
+// of closed mailboxes, or 404 Not Found if no mailbox of the id is allocated.
+func (s *Server) handleCloseMailbox() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "id must be a number", http.StatusBadRequest)
+			return
+		}
+		closed := s.CloseMailbox(id)
+		if closed == 0 {
+			http.Error(w, "no mailbox of the id", http.StatusNotFound)
+			return
+		}
+		logger.Info("closed mailboxes", zap.Int("id", id), zap.Int("closed", closed))
+		writeJSON(w, r, rendezvous.MailboxClose{Closed: closed})
+	}
+}
+
+// writeJSON responds with v marshaled as JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	logger, err := logger.FromContext(r.Context())
+	if err != nil {
+		return
+	}
+	response, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Error("failed to marshal response", zap.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response) //nolint:errcheck
+}
+
+// fallbackAdminPage is served when the admin dashboard template could not be loaded.
+var fallbackAdminPage = template.Must(template.New("fallback").Parse(
+	`<!DOCTYPE html><html><head><title>Portal relay admin</title></head><body><h1>Portal relay {{.Status.Version}}</h1>` +
+		`<p>Up {{.Uptime}}, {{.Status.Connections}} connections, {{len .Mailboxes}} mailboxes, {{.Throughput}}</p></body></html>`,
+))
+
+// adminPage is the data the admin dashboard template is executed with, formatted for display.
+type adminPage struct {
+	Status         rendezvous.Status
+	Mailboxes      []adminMailbox
+	Uptime         string
+	Throughput     string
+	Relayed        string
+	RefreshSeconds int
+}
+
+// adminMailbox is a mailbox listed on the admin dashboard.
+type adminMailbox struct {
+	ID      int
+	State   string
+	Created string
+	Idle    string
+	Relayed string
+}
+
+// handleAdminPage returns a handler serving the admin dashboard, refreshed every ADMIN_REFRESH_INTERVAL.
+func (s *Server) handleAdminPage() http.HandlerFunc {
+	templatePath := "relay/admin.html"
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		status := s.Status()
+		page := adminPage{
+			Status:         status,
+			Uptime:         (time.Duration(status.UptimeSeconds) * time.Second).String(),
+			Throughput:     formatBytes(status.BytesPerSecond) + "/s",
+			Relayed:        formatBytes(status.BytesRelayed),
+			RefreshSeconds: int(ADMIN_REFRESH_INTERVAL.Seconds()),
+		}
+		mailboxes := s.Mailboxes()
+		sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].Created.Before(mailboxes[j].Created) })
+		for _, m := range mailboxes {
+			page.Mailboxes = append(page.Mailboxes, adminMailbox{
+				ID:      m.ID,
+				State:   m.State,
+				Created: m.Created.UTC().Format(time.RFC3339),
+				Idle:    (time.Duration(m.IdleMS) * time.Millisecond).Round(time.Second).String(),
+				Relayed: formatBytes(m.BytesToReceiver + m.BytesToSender),
+			})
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl, ok := s.templates[templatePath]
+		if !ok {
+			logger.Sugar().Warnf("failed to find template at path '%s', serving fallback page", templatePath)
+			tmpl = fallbackAdminPage
+		}
+		if err := tmpl.Execute(w, page); err != nil {
+			logger.Error("failed to execute admin page template", zap.Error(err))
+		}
+	}
+}
+
+// formatBytes formats the byte count in SI units, e.g. 1.5 MB.
+func formatBytes(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+}
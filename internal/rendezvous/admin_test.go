@@ -0,0 +1,141 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestAdmin(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	const token = "admin-token"
+	s := NewServer(0, token, semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	request := func(method, path string, auth func(*http.Request)) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s%s", addr, path), nil)
+		require.NoError(t, err)
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	basic := func(req *http.Request) { req.SetBasicAuth("operator", token) }
+
+	// a relaying transfer and a sender waiting for its receiver.
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, _ := sender.SecureConnection(ctx, rc, pass)
+		senderC <- tc
+	}()
+	var relayingReceiver conn.Transfer
+	require.Eventually(t, func() bool {
+		rrc, err := receiver.ConnectRendezvous(addr)
+		if err != nil {
+			return false
+		}
+		relayingReceiver, err = receiver.SecureConnection(ctx, rrc, pass)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	relayingSender := <-senderC
+	require.NoError(t, relayingSender.WriteRaw(ctx, []byte("relayed")))
+	_, err = relayingReceiver.ReadRaw(ctx)
+	require.NoError(t, err)
+	waitingSender, _, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+
+	var mailboxes []rendezvous.Mailbox
+	t.Run("lists mailboxes", func(t *testing.T) {
+		// the mailbox of the waiting sender is allocated once its establish message is read by the server.
+		require.Eventually(t, func() bool {
+			resp := request(http.MethodGet, "/api/mailboxes", bearer)
+			defer resp.Body.Close()
+			mailboxes = nil
+			return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&mailboxes) == nil && len(mailboxes) == 2
+		}, 5*time.Second, 10*time.Millisecond, "listed %v", mailboxes)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		resp := request(http.MethodGet, "/api/status", bearer)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var status rendezvous.Status
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		assert.Equal(t, map[string]int{"waiting": 1, "handshake": 0, "relaying": 1}, status.Mailboxes)
+		assert.Equal(t, int64(3), status.Connections)
+		assert.Positive(t, status.BytesRelayed)
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		resp := request(http.MethodGet, "/admin", nil)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+
+		resp = request(http.MethodGet, "/admin", basic)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		page, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(page), "Connections")
+		assert.Contains(t, string(page), "relaying")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, auth := range []func(*http.Request){nil, basic, func(req *http.Request) { req.Header.Set("Authorization", "Bearer wrong-token") }} {
+			resp := request(http.MethodDelete, fmt.Sprintf("/api/mailboxes/%d", mailboxes[0].ID), auth)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("closes mailboxes", func(t *testing.T) {
+		for _, m := range mailboxes {
+			resp := request(http.MethodDelete, fmt.Sprintf("/api/mailboxes/%d", m.ID), bearer)
+			var closed rendezvous.MailboxClose
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&closed))
+			resp.Body.Close()
+			assert.Equal(t, 1, closed.Closed)
+		}
+		for _, c := range []conn.Conn{relayingSender.Conn, relayingReceiver.Conn, waitingSender.Conn} {
+			_, err := c.Read(ctx)
+			var closeErr websocket.CloseError
+			require.True(t, errors.As(err, &closeErr), err)
+			assert.Equal(t, rendezvous.MAILBOX_CLOSED, closeErr.Reason)
+		}
+
+		resp := request(http.MethodDelete, fmt.Sprintf("/api/mailboxes/%d", mailboxes[0].ID), bearer)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,48 @@
+package rendezvous
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestIPAnonymization(t *testing.T) {
+	s := NewServer(0, "", semver.Version{}, WithMaxMailboxesPerIdentity(1), WithIPAnonymization(true))
+	core, logs := observer.New(zapcore.DebugLevel)
+	var accepted int
+	handler := logger.Middleware(zap.New(core), logger.WithAnonymizedIPs(s.anonymizeIPs))(s.limitMailboxes(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accepted++
+			l, err := logger.FromContext(r.Context())
+			require.NoError(t, err)
+			l.Info("sender accepted")
+		})))
+
+	// the client already holds its only mailbox.
+	require.True(t, s.identities.Acquire("203.0.113.7"))
+	send := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/establish-sender", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.7:4000"))
+	// limits are enforced on the full address, clients sharing the anonymized address are limited separately.
+	assert.Equal(t, http.StatusOK, send("203.0.113.8:4000"))
+	assert.Equal(t, 1, accepted)
+
+	require.Equal(t, 2, logs.Len())
+	for _, entry := range logs.All() {
+		assert.Equal(t, "203.0.113.0", entry.ContextMap()["request_ip"], entry.Message)
+	}
+	assert.Equal(t, 1, logs.FilterField(zap.String("identity", "203.0.113.0")).Len())
+}
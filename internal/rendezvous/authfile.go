@@ -0,0 +1,72 @@
+// authfile.go specifies the writing of the auth tokens of the server to disk, hashed such that the file does not
+// grant access to the admin endpoints by itself. Writes are retried with exponential backoff, such that volumes
+// that become writable shortly after the server starts, e.g. slow-mounting secret volumes of containers, are still
+// written.
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AUTH_FILE_NAME is the file the hashed auth tokens of the server are written to by default.
+const AUTH_FILE_NAME = "srv_auth.txt"
+
+// DEFAULT_AUTH_FILE_ATTEMPTS is the number of times writing the auth file is attempted before giving up.
+const DEFAULT_AUTH_FILE_ATTEMPTS = 5
+
+// DEFAULT_AUTH_FILE_BACKOFF is the time waited before retrying a failed write of the auth file, doubled
+// after every attempt.
+const DEFAULT_AUTH_FILE_BACKOFF = 500 * time.Millisecond
+
+// SaveAuthPassword writes the hashed auth tokens of the server to the auth file as a token list, see FormatTokens,
+// readable only by its owner. The file is replaced atomically, such that a partially written list is never read.
+func (s *Server) SaveAuthPassword() error {
+	f, err := os.CreateTemp(filepath.Dir(s.authFile), "."+filepath.Base(s.authFile)+"-*")
+	if err != nil {
+		return fmt.Errorf("creating auth file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(FormatTokens(s.tokens.List())); err != nil {
+		f.Close()
+		return fmt.Errorf("writing auth file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing auth file: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.authFile); err != nil {
+		return fmt.Errorf("replacing auth file: %w", err)
+	}
+	return nil
+}
+
+// saveAuthFile writes the auth file, retrying failed writes with exponential backoff until the attempts are
+// exhausted or the provided context is done. Serving does not depend on the auth file, failing to write it is
+// logged rather than fatal. Returns whether the auth file was written.
+func (s *Server) saveAuthFile(ctx context.Context) bool {
+	logger := s.logger.With(zap.String("path", s.authFile))
+	backoff := s.authFileBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.SaveAuthPassword()
+		if err == nil {
+			logger.Info("saved auth file", zap.Int("attempt", attempt))
+			return true
+		}
+		if attempt >= s.authFileAttempts {
+			logger.Warn("unable to save auth file, serving without it", zap.Int("attempts", attempt), zap.Error(err))
+			return false
+		}
+		logger.Warn("saving auth file, retrying", zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,91 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAuthFile(t *testing.T) {
+	const token = "auth-token"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("volume mounted after start", func(t *testing.T) {
+		// the directory of the auth file is missing until the volume is mounted.
+		dir := filepath.Join(t.TempDir(), "secrets")
+		path := filepath.Join(dir, "token")
+		s := NewServer(0, token, semver.Version{}, WithAuthFile(path), WithAuthFileRetry(10, 10*time.Millisecond))
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		_, err := os.Stat(path)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.Eventually(t, func() bool {
+			b, err := os.ReadFile(path)
+			return err == nil && string(b) == FormatTokens([]Token{NewToken(DEFAULT_TOKEN_LABEL, token)})
+		}, 5*time.Second, 10*time.Millisecond)
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+		// the temporary file the token was written to is renamed into place.
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing", "token")
+		s := NewServer(0, token, semver.Version{}, WithAuthFile(path), WithAuthFileRetry(3, time.Millisecond))
+		assert.False(t, s.saveAuthFile(ctx))
+
+		// the server serves regardless of the auth file.
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/ping", s.Addr().(*net.TCPAddr).Port))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestWithoutAuthFile(t *testing.T) {
+	const token = "auth-token"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	s := NewServer(0, token, semver.Version{}, WithAuthFile(path), WithoutAuthFile(), WithAuthFileRetry(3, time.Millisecond))
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	// auth is still enforced with the token.
+	evict := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/admin/evict-idle?olderThan=1h", s.Addr().(*net.TCPAddr).Port), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, evict(token))
+	assert.Equal(t, http.StatusUnauthorized, evict("guess"))
+
+	// the auth file, nor its temporary file, is ever created.
+	time.Sleep(50 * time.Millisecond)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
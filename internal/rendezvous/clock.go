@@ -0,0 +1,31 @@
+// clock.go specifies the clock the server tracks expiry and idleness with, such that steps of the wall clock, e.g.
+// by NTP, neither expire codes, resumption state and idle relays early nor keep them alive for longer.
+package rendezvous
+
+import "time"
+
+// Clock is the clock of the server. Expiry and idleness are tracked on the monotonic reading of the clock, the wall
+// clock is only reported to clients, e.g. as the time resumption state expires, and logged.
+type Clock interface {
+	// Now returns the wall clock time.
+	Now() time.Time
+	// Monotonic returns the time elapsed since a fixed point of the clock, unaffected by steps of the wall clock.
+	Monotonic() time.Duration
+}
+
+// systemClock is the clock of the system, its monotonic reading is the monotonic clock of the runtime.
+type systemClock struct {
+	base time.Time
+}
+
+func newSystemClock() systemClock {
+	return systemClock{base: time.Now()}
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c systemClock) Monotonic() time.Duration {
+	return time.Since(c.base)
+}
@@ -0,0 +1,157 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock whose wall clock can be stepped apart from its monotonic reading.
+type fakeClock struct {
+	mu   sync.Mutex
+	wall time.Time
+	mono time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{wall: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall
+}
+
+func (c *fakeClock) Monotonic() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mono
+}
+
+// Advance lets the provided time pass.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall, c.mono = c.wall.Add(d), c.mono+d
+}
+
+// Step steps the wall clock, e.g. as NTP corrects it, without time passing.
+func (c *fakeClock) Step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+}
+
+func TestResumptionsMonotonic(t *testing.T) {
+	clock := newFakeClock()
+	rs := NewResumptions(time.Hour, DEFAULT_RESUMPTION_STORE_SIZE)
+	rs.clock = clock
+	token, expiresIn, err := rs.Store([]byte("state"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, expiresIn)
+
+	for _, step := range []time.Duration{48 * time.Hour, -96 * time.Hour} {
+		clock.Step(step)
+		_, expiresIn, ok := rs.Load(token)
+		require.True(t, ok, "stepping the wall clock by %s should not expire the state", step)
+		assert.Equal(t, time.Hour, expiresIn)
+	}
+
+	clock.Advance(59 * time.Minute)
+	_, expiresIn, ok := rs.Load(token)
+	require.True(t, ok)
+	assert.Equal(t, time.Minute, expiresIn)
+
+	clock.Advance(2 * time.Minute)
+	_, _, ok = rs.Load(token)
+	assert.False(t, ok, "the state should expire once the ttl passed")
+}
+
+func TestMailboxMonotonic(t *testing.T) {
+	clock := newFakeClock()
+	m := newMailbox(1, clock)
+	m.setState(MailboxRelaying)
+
+	clock.Step(-24 * time.Hour)
+	assert.Zero(t, m.Idle(), "stepping the wall clock back should not make the mailbox idle")
+	assert.Zero(t, m.Age())
+	clock.Step(48 * time.Hour)
+	assert.Zero(t, m.Idle(), "stepping the wall clock forward should not make the mailbox idle")
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Minute, m.Idle())
+	assert.Equal(t, time.Minute, m.Age())
+}
+
+func TestMailboxReceiverWait(t *testing.T) {
+	clock := newFakeClock()
+	m := newMailbox(1, clock)
+	clock.Advance(time.Minute)
+	assert.Equal(t, RECEIVER_CONNECT_TIMEOUT, m.receiverWait(), "each wait should be bounded by the timeout unless the code expires early")
+
+	m.expireAfter = 2 * time.Minute
+	clock.Step(time.Hour)
+	assert.Equal(t, time.Minute, m.receiverWait(), "codes expiring early should expire relative to the creation of the mailbox")
+}
+
+func TestResumptionClockSkew(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	// the wall clock of the server is two days behind the clock of the receiver.
+	clock := newFakeClock()
+	clock.wall = time.Now().Add(-48 * time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := NewServer(0, "", semver.Version{}, WithClock(clock), WithResumptions(NewResumptions(time.Hour, DEFAULT_RESUMPTION_STORE_SIZE)))
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	t.Run("expiry is relative", func(t *testing.T) {
+		_, expires, err := receiver.StoreResumption(ctx, &http.Client{}, addr, transfer.Resume{})
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute, "the expiry should be told on the clock of the receiver")
+	})
+
+	t.Run("wall clock steps", func(t *testing.T) {
+		body, err := json.Marshal(rendezvous.Resumption{State: []byte("sealed")})
+		require.NoError(t, err)
+		resp, err := http.Post(fmt.Sprintf("http://%s/resumption", addr), "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		var stored rendezvous.Resumption
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stored))
+		resp.Body.Close()
+		assert.Equal(t, time.Hour, stored.ExpiresIn)
+		assert.True(t, clock.Now().Add(time.Hour).Equal(stored.Expires), "the expiry should be reported on the clock of the server")
+
+		load := func() int {
+			resp, err := http.Get(fmt.Sprintf("http://%s/resumption/%s", addr, stored.Token))
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		clock.Step(48 * time.Hour)
+		assert.Equal(t, http.StatusOK, load(), "stepping the wall clock past the expiry should not expire the state")
+		clock.Advance(time.Hour + time.Second)
+		assert.Equal(t, http.StatusNotFound, load(), "the state should expire once the ttl passed")
+	})
+}
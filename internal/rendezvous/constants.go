@@ -2,4 +2,36 @@ package rendezvous
 
 import "time"
 
+// RECEIVER_CONNECT_TIMEOUT is the time a mailbox waits for a receiver, after which the code of the sender expires.
 const RECEIVER_CONNECT_TIMEOUT time.Duration = 5 * time.Minute
+
+// MAX_HANDSHAKE_RESTARTS caps how many times a mailbox allows the key exchange to restart after the
+// receiver disconnected. Each restart allows another online password guess, hence the limit.
+const MAX_HANDSHAKE_RESTARTS = 3
+
+// DEFAULT_CLOSE_TIMEOUT is the time waited for a peer to acknowledge the close frame, before the connection
+// is forcefully closed.
+const DEFAULT_CLOSE_TIMEOUT = 3 * time.Second
+
+// DEFAULT_IDLE_WARNING is the time before an idle relay is closed at which its peers are warned.
+const DEFAULT_IDLE_WARNING = 30 * time.Second
+
+// MIN_IDLE_CHECK_INTERVAL is the shortest interval relays are checked for idleness at, for short idle timeouts.
+const MIN_IDLE_CHECK_INTERVAL = 10 * time.Millisecond
+
+// DEFAULT_MAX_HEADER_BYTES is the maximum size of the request line and headers of a request,
+// requests with larger headers are rejected with 431 Request Header Fields Too Large.
+const DEFAULT_MAX_HEADER_BYTES = 32 << 10
+
+// DEFAULT_READ_HEADER_TIMEOUT is the time a client has to send the headers of a request.
+const DEFAULT_READ_HEADER_TIMEOUT = 10 * time.Second
+
+// DEFAULT_PEER_FANOUT is the number of peers a receiver presenting a password unknown to the server is relayed to at
+// most, in turn, unless the mailbox is located on a peer.
+const DEFAULT_PEER_FANOUT = 3
+
+// SENDER_RESUME_TIMEOUT is the time a mailbox is kept for a sender that lost its connection while relaying.
+const SENDER_RESUME_TIMEOUT = 30 * time.Second
+
+// number of random bytes of the session token issued to senders.
+const SESSION_TOKEN_BYTES = 16
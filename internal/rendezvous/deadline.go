@@ -0,0 +1,123 @@
+package rendezvous
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// activityConn records the time of the last frame read from or written to the relayed connection.
+type activityConn struct {
+	conn.Conn
+	clock  Clock
+	active atomic.Int64 // monotonic nanoseconds of the last activity
+}
+
+func newActivityConn(c conn.Conn, clock Clock) *activityConn {
+	ac := &activityConn{Conn: c, clock: clock}
+	ac.touch()
+	return ac
+}
+
+func (c *activityConn) touch() {
+	c.active.Store(int64(c.clock.Monotonic()))
+}
+
+// idle returns the time since the last activity on the connection.
+func (c *activityConn) idle() time.Duration {
+	return c.clock.Monotonic() - time.Duration(c.active.Load())
+}
+
+func (c *activityConn) Read(ctx context.Context) ([]byte, error) {
+	b, err := c.Conn.Read(ctx)
+	if err == nil {
+		c.touch()
+	}
+	return b, err
+}
+
+func (c *activityConn) Write(ctx context.Context, b []byte) error {
+	err := c.Conn.Write(ctx, b)
+	if err == nil {
+		c.touch()
+	}
+	return err
+}
+
+func (c *activityConn) ReadFrame(ctx context.Context) (conn.Frame, error) {
+	frame, err := conn.Rendezvous{Conn: c.Conn}.ReadFrame(ctx)
+	if err == nil {
+		c.touch()
+	}
+	return frame, err
+}
+
+func (c *activityConn) WriteFrame(ctx context.Context, frame conn.Frame) error {
+	err := conn.Rendezvous{Conn: c.Conn}.WriteFrame(ctx, frame)
+	if err == nil {
+		c.touch()
+	}
+	return err
+}
+
+// CloseNow closes the connection without performing the close handshake, if supported by the connection.
+func (c *activityConn) CloseNow() error {
+	if f, ok := c.Conn.(interface{ CloseNow() error }); ok {
+		return f.CloseNow()
+	}
+	return c.Conn.Close(conn.CLOSE_FAILED, rendezvous.DEADLINE_EXCEEDED)
+}
+
+// watchDeadline bounds the relayed connection by the connection deadline of the server, returning the connection
+// to relay in its place. The deadline is refreshed whenever a frame is read from or written to the connection,
+// connections without activity for half the deadline are pinged, and refreshed once the peer answers. Connections
+// that neither relay nor answer within the deadline are dead, e.g. peers that stopped reading, and closed without
+// the close handshake. Without a connection deadline the connection is returned as is.
+func (s *Server) watchDeadline(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, logger *zap.Logger) conn.Rendezvous {
+	if s.connDeadline <= 0 {
+		return rc
+	}
+	ac := newActivityConn(rc.Conn, s.clock)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.recoverRelay(rc.Conn, logger)
+		ticker := time.NewTicker(s.connDeadline / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			idle := ac.idle()
+			if idle < s.connDeadline/2 {
+				continue
+			}
+			if p, ok := rc.Conn.(interface{ Ping(context.Context) error }); ok {
+				pingCtx, cancel := context.WithTimeout(ctx, s.connDeadline-idle)
+				err := p.Ping(pingCtx)
+				cancel()
+				if err == nil {
+					ac.touch()
+					continue
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if idle = ac.idle(); idle < s.connDeadline {
+				continue
+			}
+			logger.Warn("connection deadline exceeded, closing dead connection", zap.Duration("conn_deadline", s.connDeadline))
+			ac.CloseNow() //nolint:errcheck
+			return
+		}
+	}()
+	return conn.Rendezvous{Conn: ac}
+}
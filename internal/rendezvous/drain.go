@@ -0,0 +1,123 @@
+// drain.go specifies the draining of in-flight relays on shutdown, such that transfers relayed when the server is
+// asked to stop complete rather than being cut off mid-flight.
+package rendezvous
+
+import (
+	"time"
+
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// DRAIN_LOG_INTERVAL is the interval at which the progress of draining in-flight relays is logged.
+const DRAIN_LOG_INTERVAL = 5 * time.Second
+
+// DEFAULT_HANDOFF_DRAIN_TIMEOUT is the time in-flight relays are awaited for after the server handed off its
+// listener, unless a drain timeout is configured, such that a stuck relay does not keep the old process alive.
+const DEFAULT_HANDOFF_DRAIN_TIMEOUT = time.Hour
+
+// isClosing reports whether the server started draining its in-flight relays.
+func (s *Server) isClosing() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain waits for the in-flight relays to complete once the server stopped accepting connections, logging the
+// progress of draining. Senders waiting for a receiver are closed right away, as no receiver reaches them anymore,
+// and relaying peers are warned that the server is closing. Connections still open after the drain timeout are
+// closed with a SERVER_CLOSING reason.
+func (s *Server) drain() {
+	s.drainDeadline = time.Now().Add(s.drainTimeout)
+	close(s.closing)
+	waiting := s.closeMailboxes(rendezvous.SERVER_CLOSING, func(m *Mailbox) bool { return m.State() == MailboxWaiting })
+
+	drained := make(chan struct{})
+	go func() {
+		s.relays.Wait()
+		close(drained)
+	}()
+	s.logDrain("draining in-flight relays", zap.Int("closed_waiting", waiting), zap.Duration("drain_timeout", s.drainTimeout))
+	ticker := time.NewTicker(DRAIN_LOG_INTERVAL)
+	defer ticker.Stop()
+	timeout := time.NewTimer(s.drainTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-drained:
+			s.logger.Info("drained in-flight relays")
+			return
+		case <-ticker.C:
+			s.logDrain("draining in-flight relays", zap.Duration("remaining", time.Until(s.drainDeadline).Round(time.Second)))
+		case <-timeout.C:
+			s.logDrain("drain timed out, closing in-flight relays")
+			s.closeMailboxes(rendezvous.SERVER_CLOSING, func(*Mailbox) bool { return true })
+			// the closed connections wait at most the close timeout for their peers to acknowledge the close.
+			select {
+			case <-drained:
+			case <-time.After(s.closeTimeout + time.Second):
+				s.logger.Warn("connections still open after closing in-flight relays")
+			}
+			return
+		}
+	}
+}
+
+// awaitRelays waits up to the provided timeout for the in-flight relays to complete, without warning their peers,
+// closing the relays still in flight with a SERVER_CLOSING reason once it passed.
+func (s *Server) awaitRelays(timeout time.Duration) {
+	drained := make(chan struct{})
+	go func() {
+		s.relays.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return
+	case <-time.After(timeout):
+	}
+	s.logDrain("in-flight relays still open after handoff, closing them", zap.Duration("drain_timeout", timeout))
+	s.closeMailboxes(rendezvous.SERVER_CLOSING, func(*Mailbox) bool { return true })
+	select {
+	case <-drained:
+	case <-time.After(s.closeTimeout + time.Second):
+		s.logger.Warn("connections still open after closing in-flight relays")
+	}
+}
+
+// logDrain logs the progress of draining, along with the connections and relaying mailboxes left.
+func (s *Server) logDrain(msg string, fields ...zap.Field) {
+	status := s.Status()
+	s.logger.Info(msg, append(fields,
+		zap.Int64("connections", status.Connections),
+		zap.Int("relaying", status.Mailboxes[MailboxRelaying.String()]),
+		zap.Int64("bytes_relayed", status.BytesRelayed))...)
+}
+
+// closeMailboxes closes the connections of the mailboxes matching the filter with the provided reason. Relaying
+// mailboxes are evicted, closing both peers, other mailboxes are deallocated and their senders closed. Returns the
+// number of closed mailboxes.
+func (s *Server) closeMailboxes(reason string, filter func(*Mailbox) bool) int {
+	var closed int
+	s.mailboxes.Range(func(key, v any) bool {
+		m := v.(*Mailbox)
+		if !filter(m) {
+			return true
+		}
+		if m.State() == MailboxRelaying {
+			if m.evict(reason) {
+				closed++
+			}
+		} else if s.mailboxes.CompareAndDelete(key, m) {
+			closed++
+			if m.closeSender != nil {
+				go m.closeSender(reason)
+			}
+		}
+		return true
+	})
+	return closed
+}
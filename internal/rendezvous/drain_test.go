@@ -0,0 +1,86 @@
+package rendezvous_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestDrain(t *testing.T) {
+	const drainTimeout = time.Second
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithDrainTimeout(drainTimeout), rendezvous.WithCloseTimeout(100*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	serverCtx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
+	runErr := make(chan error, 1)
+	go func() { runErr <- server.Run(serverCtx) }()
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, pass)
+		assert.NoError(t, err)
+		senderC <- tc
+	}()
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+	require.NoError(t, err)
+	stc := <-senderC
+
+	// a sender waiting for a receiver is closed right away, as no receiver reaches it once draining.
+	waitingRC, waitingPass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	waitingErr := make(chan error, 1)
+	go func() {
+		_, err := sender.SecureConnection(ctx, waitingRC, waitingPass)
+		waitingErr <- err
+	}()
+	require.Eventually(t, func() bool { return server.Status().Mailboxes["waiting"] == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	warned := make(chan time.Duration, 1)
+	rtc.OnClosing = func(disconnectIn time.Duration) { warned <- disconnectIn }
+	start := time.Now()
+	shutdown()
+	assert.ErrorContains(t, <-waitingErr, protocol.SERVER_CLOSING)
+
+	// the relay keeps relaying while draining, warning its peers of the pending disconnect.
+	received := make(chan []byte, 1)
+	go func() {
+		b, err := rtc.ReadRaw(ctx)
+		assert.NoError(t, err)
+		received <- b
+	}()
+	select {
+	case disconnectIn := <-warned:
+		assert.Greater(t, disconnectIn, time.Duration(0))
+		assert.LessOrEqual(t, disconnectIn, drainTimeout)
+	case <-ctx.Done():
+		t.Fatal("receiver was not warned of the shutdown")
+	}
+	require.NoError(t, stc.WriteRaw(ctx, []byte("draining")))
+	assert.Equal(t, "draining", string(<-received))
+
+	// relays in flight after the drain timeout are closed.
+	_, err = rtc.ReadRaw(ctx)
+	assert.Equal(t, conn.CLOSE_FAILED, websocket.CloseStatus(err))
+	assert.ErrorContains(t, err, protocol.SERVER_CLOSING)
+	assert.GreaterOrEqual(t, time.Since(start), drainTimeout)
+	assert.NoError(t, <-runErr)
+}
@@ -0,0 +1,256 @@
+// drops.go specifies the spool of drops, payloads sealed by senders and held on disk until a receiver collects
+// them, such that the sender and receiver need not be online at once. The server only holds the sealed payload,
+// the key to it is shared by the sender with the receiver alone. Drops are disabled by default.
+package rendezvous
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_DROP_TTL is the time drops are held for, after which they are deleted uncollected.
+const DEFAULT_DROP_TTL = 24 * time.Hour
+
+// DEFAULT_DROP_SPOOL_SIZE is the maximum number of bytes the drops held by the server take up on disk.
+const DEFAULT_DROP_SPOOL_SIZE = 1 << 30
+
+// number of random bytes of the ids of drops.
+const DROP_ID_BYTES = 16
+
+// prefix of the files drops are uploaded to, renamed into place once complete.
+const dropUploadPrefix = ".upload-"
+
+var errSpoolFull = errors.New("drop spool full")
+
+// drop is a drop held in the spool.
+type drop struct {
+	size    int64
+	expires time.Time
+}
+
+// Drops is a threadsafe spool of drops in a directory, holding at most maxBytes of drops and deleting every drop
+// ttl after it was stored. Drops are held across restarts, their expiry told by the modification time of their file.
+type Drops struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	used    int64 // bytes held or reserved for uploads
+	entries map[string]drop
+}
+
+// NewDrops opens the spool of drops in dir, creating it if missing. Drops left behind that expired, and
+// interrupted uploads, are deleted.
+func NewDrops(dir string, maxBytes int64, ttl time.Duration) (*Drops, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating drop spool: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading drop spool: %w", err)
+	}
+	d := &Drops{dir: dir, maxBytes: maxBytes, ttl: ttl, entries: make(map[string]drop)}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), dropUploadPrefix) {
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !validDropID(e.Name()) || !info.Mode().IsRegular() {
+			continue
+		}
+		d.entries[e.Name()] = drop{size: info.Size(), expires: info.ModTime().Add(ttl)}
+		d.used += info.Size()
+	}
+	d.mu.Lock()
+	d.prune()
+	d.mu.Unlock()
+	return d, nil
+}
+
+// Store stores the drop of size bytes read from r, returning its id and expiry. Fails with errSpoolFull if the
+// spool cannot hold the drop, and if r does not hold exactly size bytes.
+func (d *Drops) Store(r io.Reader, size int64) (string, time.Time, error) {
+	b := make([]byte, DROP_ID_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+
+	// the space is reserved up front, such that concurrent uploads do not overfill the spool.
+	d.mu.Lock()
+	d.prune()
+	if d.used+size > d.maxBytes {
+		d.mu.Unlock()
+		return "", time.Time{}, errSpoolFull
+	}
+	d.used += size
+	d.mu.Unlock()
+	stored := false
+	defer func() {
+		if !stored {
+			d.mu.Lock()
+			d.used -= size
+			d.mu.Unlock()
+		}
+	}()
+
+	f, err := os.CreateTemp(d.dir, dropUploadPrefix+"*")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("creating drop: %w", err)
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, io.LimitReader(r, size+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("writing drop: %w", err)
+	}
+	if n != size {
+		return "", time.Time{}, fmt.Errorf("drop of %d bytes announced as %d bytes", n, size)
+	}
+	if err := os.Rename(f.Name(), filepath.Join(d.dir, id)); err != nil {
+		return "", time.Time{}, fmt.Errorf("storing drop: %w", err)
+	}
+	expires := time.Now().Add(d.ttl)
+	d.mu.Lock()
+	d.entries[id] = drop{size: size, expires: expires}
+	d.mu.Unlock()
+	stored = true
+	return id, expires, nil
+}
+
+// Open opens the unexpired drop of the id, returning its expiry, or false if the id is unknown or expired.
+func (d *Drops) Open(id string) (*os.File, time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[id]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	if time.Now().After(e.expires) {
+		d.delete(id)
+		return nil, time.Time{}, false
+	}
+	f, err := os.Open(filepath.Join(d.dir, id))
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	return f, e.expires, true
+}
+
+// Delete deletes the drop of the id, once collected.
+func (d *Drops) Delete(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delete(id)
+}
+
+// delete deletes the drop of the id, the caller holds the lock. Drops being served are served in full, as their
+// open file outlives its name.
+func (d *Drops) delete(id string) {
+	e, ok := d.entries[id]
+	if !ok {
+		return
+	}
+	delete(d.entries, id)
+	d.used -= e.size
+	os.Remove(filepath.Join(d.dir, id))
+}
+
+// prune deletes the expired drops, the caller holds the lock.
+func (d *Drops) prune() {
+	now := time.Now()
+	for id, e := range d.entries {
+		if now.After(e.expires) {
+			d.delete(id)
+		}
+	}
+}
+
+func validDropID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == DROP_ID_BYTES
+}
+
+// handleStoreDrop stores the sealed payload of the request as a drop, responding with its id and expiry. The size
+// of the payload is announced up front, such that drops the spool cannot hold are rejected before they are sent.
+func (s *Server) handleStoreDrop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		size := r.ContentLength
+		switch {
+		case size <= 0:
+			http.Error(w, "drops require their size as Content-Length", http.StatusLengthRequired)
+			return
+		case size > s.drops.maxBytes:
+			http.Error(w, "drop too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		// drops take longer to upload than the read timeout of the server allows requests.
+		http.NewResponseController(w).SetReadDeadline(time.Time{}) //nolint:errcheck
+		id, expires, err := s.drops.Store(http.MaxBytesReader(w, r.Body, size), size)
+		if errors.Is(err, errSpoolFull) {
+			logger.Warn("rejecting drop", zap.Int64("size", size), zap.Error(err))
+			http.Error(w, "drop spool full, retry later", http.StatusInsufficientStorage)
+			return
+		}
+		if err != nil {
+			logger.Warn("storing drop", zap.Int64("size", size), zap.Error(err))
+			http.Error(w, "unable to store drop", http.StatusBadRequest)
+			return
+		}
+		logger.Info("stored drop", zap.Int64("size", size), zap.Time("expires", expires))
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rendezvous.Drop{ID: id, Size: size, Expires: expires, ExpiresIn: time.Until(expires)}); err != nil {
+			logger.Warn("writing drop", zap.Error(err))
+		}
+	}
+}
+
+// handleDrop serves the sealed payload of the drop, or deletes it once collected.
+func (s *Server) handleDrop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		id := mux.Vars(r)["id"]
+		if r.Method == http.MethodDelete {
+			s.drops.Delete(id)
+			logger.Info("deleted drop")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f, expires, ok := s.drops.Open(id)
+		if !ok {
+			http.Error(w, "unknown or expired drop", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		http.NewResponseController(w).SetWriteDeadline(time.Time{}) //nolint:errcheck
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, f)
+	}
+}
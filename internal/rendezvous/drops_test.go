@@ -0,0 +1,92 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrops(t *testing.T) {
+	t.Run("spool size", func(t *testing.T) {
+		d, err := NewDrops(t.TempDir(), 10, time.Hour)
+		require.NoError(t, err)
+		id, _, err := d.Store(strings.NewReader("sealed"), 6)
+		require.NoError(t, err)
+		_, _, err = d.Store(strings.NewReader("sealed"), 6)
+		assert.ErrorIs(t, err, errSpoolFull)
+		// drops not holding their announced size release their reservation.
+		_, _, err = d.Store(strings.NewReader("sea"), 4)
+		assert.Error(t, err)
+
+		d.Delete(id)
+		_, _, err = d.Store(strings.NewReader("sealed"), 6)
+		assert.NoError(t, err)
+	})
+
+	t.Run("expiry survives restarts", func(t *testing.T) {
+		dir := t.TempDir()
+		d, err := NewDrops(dir, 1<<10, time.Hour)
+		require.NoError(t, err)
+		fresh, _, err := d.Store(strings.NewReader("fresh"), 5)
+		require.NoError(t, err)
+		stale, _, err := d.Store(strings.NewReader("stale"), 5)
+		require.NoError(t, err)
+		past := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, stale), past, past))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, dropUploadPrefix+"1"), []byte("partial"), 0600))
+
+		d, err = NewDrops(dir, 1<<10, time.Hour)
+		require.NoError(t, err)
+		f, _, ok := d.Open(fresh)
+		require.True(t, ok)
+		f.Close()
+		_, _, ok = d.Open(stale)
+		assert.False(t, ok)
+		assert.NoFileExists(t, filepath.Join(dir, stale))
+		assert.NoFileExists(t, filepath.Join(dir, dropUploadPrefix+"1"))
+	})
+
+	t.Run("left and collected", func(t *testing.T) {
+		d, err := NewDrops(t.TempDir(), DEFAULT_DROP_SPOOL_SIZE, DEFAULT_DROP_TTL)
+		require.NoError(t, err)
+		s := NewServer(0, "", semver.Version{}, WithDrops(d))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+		payload := bytes.Repeat([]byte("dropped "), 20000)
+		config := portal.Config{RendezvousAddr: addr}
+		code, expires, err := portal.Drop(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(DEFAULT_DROP_TTL), expires, time.Minute)
+
+		var collected bytes.Buffer
+		require.NoError(t, portal.Collect(ctx, &collected, code, &config))
+		assert.Equal(t, payload, collected.Bytes())
+		// drops are collected once.
+		assert.ErrorIs(t, portal.Collect(ctx, &bytes.Buffer{}, code, &config), receiver.ErrDropNotFound)
+
+		id, _, err := receiver.ParseDropCode(code)
+		require.NoError(t, err)
+		other, _, err := portal.Drop(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+		require.NoError(t, err)
+		otherID, _, err := receiver.ParseDropCode(other)
+		require.NoError(t, err)
+		// the key of one drop does not open another.
+		assert.Error(t, portal.Collect(ctx, &bytes.Buffer{}, otherID+code[len(id):], &config))
+	})
+}
@@ -0,0 +1,93 @@
+// evict.go specifies the on demand eviction of idle mailboxes, such that operators are able to free resources held
+// by stalled transfers without draining the server. Evictions are independent of the idle timeout of the server.
+package rendezvous
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// EvictIdle evicts the relaying mailboxes through which nothing was relayed for longer than the provided duration,
+// closing the connections of both peers. Returns the number of evicted mailboxes.
+func (s *Server) EvictIdle(olderThan time.Duration) int {
+	var evicted int
+	s.mailboxes.Range(func(_, v any) bool {
+		if m := v.(*Mailbox); m.State() == MailboxRelaying && m.Idle() > olderThan && m.evict(rendezvous.EVICTED_IDLE) {
+			evicted++
+		}
+		return true
+	})
+	return evicted
+}
+
+// handleEvictIdle returns a handler that evicts the mailboxes idle for longer than the olderThan query parameter,
+// responding with the number of evicted mailboxes.
+func (s *Server) handleEvictIdle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger, err := logger.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		olderThan, err := time.ParseDuration(r.URL.Query().Get("olderThan"))
+		if err != nil || olderThan < 0 {
+			http.Error(w, "olderThan must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+		evicted := s.EvictIdle(olderThan)
+		logger.Info("evicted idle mailboxes", zap.Duration("older_than", olderThan), zap.Int("evicted", evicted))
+
+		response, err := json.Marshal(rendezvous.Eviction{Evicted: evicted})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("failed to marshal eviction", zap.Error(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response) //nolint:errcheck
+	}
+}
+
+// authorizeAdmin rejects requests that do not present an auth token of the server with 401 Unauthorized, logging
+// the label of the token presented by authorized requests. Browsers present the token as the password of basic
+// auth, only accepted for GET requests such that cross-site forms cannot act with the credentials of the browser.
+func (s *Server) authorizeAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.rejectBanned(w, r) {
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = ""
+			if r.Method == http.MethodGet {
+				token = password
+			}
+		}
+		label, authorized := s.tokens.Authorize(token)
+		logger, err := logger.FromContext(r.Context())
+		if !authorized {
+			if err == nil {
+				logger.Warn("unauthorized admin request")
+			}
+			s.auditAuth(r, false, "unauthorized admin request")
+			s.recordAuth(r, false)
+			if r.Method == http.MethodGet {
+				w.Header().Set("WWW-Authenticate", `Basic realm="portal relay admin", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err == nil {
+			logger.Info("authorized admin request", zap.String("token", label))
+		}
+		s.auditAuth(r, true, "authorized admin request with token "+label)
+		s.recordAuth(r, true)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestEvictIdle(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	const token = "admin-token"
+	s := NewServer(0, token, semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	connect := func() (conn.Transfer, conn.Transfer) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		var rtc conn.Transfer
+		require.Eventually(t, func() bool {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err != nil {
+				return false
+			}
+			rtc, err = receiver.SecureConnection(ctx, rrc, pass)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+		return stc, rtc
+	}
+	evict := func(auth string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/admin/evict-idle?olderThan=200ms", addr), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	idleSender, idleReceiver := connect()
+	activeSender, activeReceiver := connect()
+	// the active relay keeps relaying while the idle relay outlives the eviction threshold.
+	for deadline := time.Now().Add(400 * time.Millisecond); time.Now().Before(deadline); {
+		require.NoError(t, activeSender.WriteRaw(ctx, []byte("active")))
+		_, err := activeReceiver.ReadRaw(ctx)
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, auth := range []string{"", "Bearer wrong-token"} {
+			resp := evict(auth)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("evicts idle mailboxes", func(t *testing.T) {
+		resp := evict("Bearer " + token)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var eviction rendezvous.Eviction
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&eviction))
+		assert.Equal(t, 1, eviction.Evicted)
+
+		for _, tc := range []conn.Transfer{idleSender, idleReceiver} {
+			_, err := tc.ReadRaw(ctx)
+			var closeErr websocket.CloseError
+			require.True(t, errors.As(err, &closeErr), err)
+			assert.Equal(t, rendezvous.EVICTED_IDLE, closeErr.Reason)
+		}
+	})
+
+	t.Run("active relays continue", func(t *testing.T) {
+		require.NoError(t, activeSender.WriteRaw(ctx, []byte("still active")))
+		b, err := activeReceiver.ReadRaw(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("still active"), b)
+	})
+}
@@ -0,0 +1,91 @@
+// fanout.go specifies the further mailboxes of senders sending the same payload to several receivers with one
+// password. Each mailbox of the password relays the transfer to one receiver, receivers are paired with the first
+// mailbox of the password awaiting a receiver.
+package rendezvous
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+)
+
+// JOIN_TIMEOUT is the time a joining mailbox waits for the first mailbox of its password to be established.
+const JOIN_TIMEOUT = 5 * time.Second
+
+// mailboxKey returns the key the n-th mailbox of the password is allocated under, the first mailbox is
+// allocated under the password itself.
+func mailboxKey(p string, n int) string {
+	if n == 0 {
+		return p
+	}
+	return fmt.Sprintf("%s#%d", p, n)
+}
+
+// JoinMailbox allocates a further mailbox for the password, if a mailbox of the password was issued the session
+// token and fewer than rendezvous.MAX_RECEIVERS mailboxes share it. Returns the key the mailbox is allocated under,
+// or the close reason of the sender.
+func (mailboxes *Mailboxes) JoinMailbox(p, token string, m *Mailbox) (string, string) {
+	if _, err := mailboxes.GetSession(p, token); err != nil {
+		return "", rendezvous.UNKNOWN_SESSION
+	}
+	for n := 1; n < rendezvous.MAX_RECEIVERS; n++ {
+		if _, loaded := mailboxes.LoadOrStore(mailboxKey(p, n), m); !loaded {
+			return mailboxKey(p, n), ""
+		}
+	}
+	return "", rendezvous.TOO_MANY_RECEIVERS
+}
+
+// GetSession returns the mailbox of the password issued the session token.
+func (mailboxes *Mailboxes) GetSession(p, token string) (*Mailbox, error) {
+	for n := 0; n < rendezvous.MAX_RECEIVERS; n++ {
+		if v, ok := mailboxes.Load(mailboxKey(p, n)); ok {
+			if m := v.(*Mailbox); subtle.ConstantTimeCompare([]byte(m.token), []byte(token)) == 1 {
+				return m, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no mailbox with password '%s' and session token", p)
+}
+
+// ReserveMailbox reserves the first mailbox of the password awaiting a receiver for a receiver.
+// Returns false if every mailbox of the password has a receiver.
+func (mailboxes *Mailboxes) ReserveMailbox(p string) (*Mailbox, bool) {
+	for n := 0; n < rendezvous.MAX_RECEIVERS; n++ {
+		if v, ok := mailboxes.Load(mailboxKey(p, n)); ok {
+			if m := v.(*Mailbox); m.hasReceiver.CompareAndSwap(false, true) {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// joinMailbox allocates a further mailbox for the password of the session, waiting up to JOIN_TIMEOUT for the
+// first mailbox of the password to be established, as the mailboxes of a sender are established concurrently.
+// Returns the key the mailbox is allocated under, or the close reason of the sender.
+func (s *Server) joinMailbox(ctx context.Context, p, token string, m *Mailbox) (string, string) {
+	timeout := time.NewTimer(JOIN_TIMEOUT)
+	defer timeout.Stop()
+	for {
+		claimed, done := s.waiting.wait(p)
+		key, reason := s.mailboxes.JoinMailbox(p, token, m)
+		if _, err := s.mailboxes.GetMailbox(p); reason != rendezvous.UNKNOWN_SESSION || err == nil {
+			done()
+			return key, reason
+		}
+		select {
+		case <-ctx.Done():
+			done()
+			return "", reason
+		case <-timeout.C:
+			done()
+			return "", reason
+		case <-claimed:
+			done()
+		}
+	}
+}
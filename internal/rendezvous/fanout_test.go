@@ -0,0 +1,109 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestJoinMailbox(t *testing.T) {
+	mailboxes := &Mailboxes{&sync.Map{}}
+	clock := newFakeClock()
+	first := newMailbox(1, clock)
+	first.token = "session"
+	require.True(t, mailboxes.ClaimMailbox("hashed", first))
+
+	_, reason := mailboxes.JoinMailbox("hashed", "forged", newMailbox(2, clock))
+	assert.Equal(t, rendezvous.UNKNOWN_SESSION, reason)
+	for n := 1; n < rendezvous.MAX_RECEIVERS; n++ {
+		key, reason := mailboxes.JoinMailbox("hashed", "session", newMailbox(n+1, clock))
+		require.Empty(t, reason)
+		assert.Equal(t, mailboxKey("hashed", n), key)
+	}
+	_, reason = mailboxes.JoinMailbox("hashed", "session", newMailbox(0, clock))
+	assert.Equal(t, rendezvous.TOO_MANY_RECEIVERS, reason)
+
+	t.Run("receivers reserve the first free mailbox", func(t *testing.T) {
+		for n := 0; n < rendezvous.MAX_RECEIVERS; n++ {
+			m, ok := mailboxes.ReserveMailbox("hashed")
+			require.True(t, ok)
+			assert.Equal(t, n+1, m.id)
+		}
+		_, ok := mailboxes.ReserveMailbox("hashed")
+		assert.False(t, ok)
+	})
+
+	t.Run("further mailboxes outlive the first", func(t *testing.T) {
+		mailboxes.Delete("hashed")
+		m, err := mailboxes.GetMailbox("hashed")
+		require.NoError(t, err)
+		assert.Equal(t, 2, m.id)
+		assert.False(t, mailboxes.ClaimMailbox("hashed", newMailbox(0, clock)), "another sender should not claim the password")
+	})
+}
+
+func TestMultipleReceivers(t *testing.T) {
+	s := NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	t.Run("each receiver receives the payload", func(t *testing.T) {
+		const receivers = 3
+		payload := bytes.Repeat([]byte("portal"), 100000)
+		payloads := make([]io.Reader, receivers)
+		for i := range payloads {
+			payloads[i] = bytes.NewReader(payload)
+		}
+		config := portal.Config{RendezvousAddr: addr}
+		pass, err, errC := portal.SendMany(ctx, payloads, int64(len(payload)), &config)
+		require.NoError(t, err)
+
+		received := make([]bytes.Buffer, receivers)
+		errs := make([]error, receivers)
+		var wg sync.WaitGroup
+		for i := range received {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = portal.Receive(ctx, &received[i], pass, &config)
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, <-errC)
+		for i := range received {
+			require.NoError(t, errs[i])
+			assert.Equal(t, payload, received[i].Bytes())
+		}
+	})
+
+	t.Run("joining requires the session token", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		joined, err := sender.ConnectRendezvousJoining(ctx, addr, pass, "forged", 0)
+		require.NoError(t, err)
+		_, err = joined.ReadMsg(ctx)
+		var closeErr websocket.CloseError
+		require.True(t, errors.As(err, &closeErr), err)
+		assert.Equal(t, rendezvous.UNKNOWN_SESSION, closeErr.Reason)
+		_, err = s.mailboxes.GetSession(password.Hashed(pass), rc.Token)
+		assert.NoError(t, err, "the mailbox of the session should be kept")
+	})
+}
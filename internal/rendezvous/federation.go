@@ -0,0 +1,167 @@
+// federation.go specifies the federation of rendezvous servers, relaying receivers between servers such that
+// peers that can only reach different servers are still able to transfer.
+//
+// Servers are federated by explicit peering: each server is configured with the address of its peers and a
+// token shared with each peer. A receiver presenting a password unknown to its server is relayed to the peers
+// in turn over the /federate-receiver endpoint, authenticated by the token of the peer, until a peer holding the
+// mailbox answers. Receivers are relayed to at most as many peers as the fanout of the server, and tokens are
+// only ever sent to peers over TLS. The federated connection is relayed frame by frame, the key exchange and the encrypted
+// transfer are performed end to end between the sender and the receiver, so peers only learn the hashed
+// password, much like the server of the sender. Receivers are relayed a single hop, federated receivers are
+// never relayed on to the peers of the peer.
+package rendezvous
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+// Peer is a rendezvous server federated with this server, authenticated in both directions by a shared token.
+// The address of the peer is prefixed with wss://, peers are only reached over TLS such that their token is never
+// sent in the clear.
+type Peer struct {
+	Addr  string
+	Token string
+}
+
+// ParsePeer parses a peer specified as wss://addr=token.
+func ParsePeer(s string) (Peer, error) {
+	addr, token, ok := strings.Cut(s, "=")
+	if !ok || addr == "" || token == "" {
+		return Peer{}, fmt.Errorf("invalid peer %q, expected wss://addr=token", s)
+	}
+	peer := Peer{Addr: addr, Token: token}
+	if err := peer.validate(); err != nil {
+		return Peer{}, err
+	}
+	return peer, nil
+}
+
+// validate rejects peers that are not reached over TLS.
+func (p Peer) validate() error {
+	if host, secure := conn.SplitScheme(p.Addr); !secure || host == "" {
+		return fmt.Errorf("invalid peer %q, peers are reached over TLS only, expected a wss:// address", p.Addr)
+	}
+	return nil
+}
+
+// authorizePeers rejects requests that do not present the token of a peer with 401 Unauthorized.
+func (s *Server) authorizePeers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.rejectBanned(w, r) {
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, peer := range s.peers {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(peer.Token)) == 1 {
+				s.auditAuth(r, true, "authorized federated receiver")
+				s.recordAuth(r, true)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if logger, err := logger.FromContext(r.Context()); err == nil {
+			logger.Warn("unauthorized federated receiver")
+		}
+		s.auditAuth(r, false, "unauthorized federated receiver")
+		s.recordAuth(r, false)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// federateReceiver relays the receiver to the first peer holding the mailbox of the establish message, until
+// either the receiver or the peer closes. Returns false if no peer holds the mailbox, receivers presenting a
+// password that expired on a peer are told that the code expired.
+func (s *Server) federateReceiver(ctx context.Context, rc conn.Rendezvous, establish rendezvous.Msg, logger *zap.Logger) bool {
+	var expired bool
+	// receivers only wait for senders on their own server, peers not holding the mailbox answer right away.
+	establish.Payload.Wait = false
+	for _, peer := range s.locatedPeers(establish.Payload.Password) {
+		peerLogger := logger.With(zap.String("peer", peer.Addr))
+		ws, err := conn.Dial(ctx, conn.WebsocketURL(peer.Addr, "/federate-receiver"), conn.WithHeader("Authorization", "Bearer "+peer.Token), conn.WithTLSConfig(s.peerTLSConfig))
+		if err != nil {
+			peerLogger.Warn("dialing peer", zap.Error(err))
+			continue
+		}
+		prc := conn.Rendezvous{Conn: ws}
+		if err := prc.WriteMsg(ctx, establish); err != nil {
+			peerLogger.Warn("establishing federated receiver", zap.Error(err))
+			ws.CloseNow() //nolint:errcheck
+			continue
+		}
+		// peers not holding the mailbox close the connection rather than answering.
+		frame, err := prc.ReadFrame(ctx)
+		if err != nil {
+			var closeErr websocket.CloseError
+			expired = expired || errors.As(err, &closeErr) && closeErr.Reason == rendezvous.CODE_EXPIRED
+			ws.CloseNow() //nolint:errcheck
+			continue
+		}
+		peerLogger.Info("relaying receiver to peer")
+		if err := rc.WriteFrame(ctx, frame); err != nil {
+			peerLogger.Error("relaying to federated receiver", zap.Error(err))
+			ws.CloseNow() //nolint:errcheck
+			return true
+		}
+		s.pipe(ctx, rc, prc, peerLogger)
+		return true
+	}
+	if expired {
+		logger.Warn("mailbox expired on peer")
+		rc.Conn.Close(conn.CLOSE_FAILED, rendezvous.CODE_EXPIRED) //nolint:errcheck
+		return true
+	}
+	return false
+}
+
+// pipe relays frames between the receiver and the peer until either ends, closing both connections with the
+// close frame of the connection that ended. Connections lost without a close frame are closed as failed.
+func (s *Server) pipe(ctx context.Context, receiver, peer conn.Rendezvous, logger *zap.Logger) {
+	ended := make(chan error, 2)
+	relay := func(from, to conn.Rendezvous) {
+		for {
+			frame, err := from.ReadFrame(ctx)
+			if err != nil {
+				ended <- err
+				return
+			}
+			if err := to.WriteFrame(ctx, frame); err != nil {
+				ended <- err
+				return
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); defer s.recoverRelay(receiver.Conn, logger); relay(receiver, peer) }()
+	go func() { defer wg.Done(); defer s.recoverRelay(peer.Conn, logger); relay(peer, receiver) }()
+
+	code, reason := conn.CLOSE_FAILED, rendezvous.FEDERATION_LOST
+	var closeErr websocket.CloseError
+	if err := <-ended; errors.As(err, &closeErr) {
+		code, reason = closeErr.Code, closeErr.Reason
+	}
+	logger.Info("federated relay ended", zap.String("close_code", code.String()), zap.String("close_reason", reason))
+	reason = relayedReason(reason)
+	var closers sync.WaitGroup
+	for _, c := range []conn.Conn{receiver.Conn, peer.Conn} {
+		closers.Add(1)
+		go func(c conn.Conn) {
+			defer closers.Done()
+			conn.CloseTimeout(c, code, reason, s.closeTimeout) //nolint:errcheck
+		}(c)
+	}
+	closers.Wait()
+	wg.Wait()
+}
@@ -0,0 +1,134 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePeer(t *testing.T) {
+	peer, err := rendezvous.ParsePeer("wss://relay.example.com:8080=secret")
+	require.NoError(t, err)
+	assert.Equal(t, rendezvous.Peer{Addr: "wss://relay.example.com:8080", Token: "secret"}, peer)
+
+	// tokens are never sent to peers reached without TLS.
+	for _, invalid := range []string{"wss://relay.example.com", "=secret", "wss://relay.example.com=", "relay.example.com:8080=secret", "ws://relay.example.com=secret", "wss://=secret"} {
+		_, err := rendezvous.ParsePeer(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the servers are only reachable by the sender and the receiver respectively, and peer with each other over TLS.
+	cert, pool := selfSignedCert(t)
+	tlsConfig := &tls.Config{RootCAs: pool}
+	const token = "peering-token"
+	listen := func() (net.Listener, string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		return l, fmt.Sprintf("wss://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	}
+	serve := func(l net.Listener, peer string) {
+		server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithListener(l),
+			rendezvous.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+			rendezvous.WithPeers(rendezvous.Peer{Addr: peer, Token: token}), rendezvous.WithPeerTLS(tlsConfig))
+		go server.Run(ctx) //nolint:errcheck
+	}
+	senderListener, senderAddr := listen()
+	receiverListener, receiverAddr := listen()
+	serve(senderListener, receiverAddr)
+	serve(receiverListener, senderAddr)
+
+	t.Run("transfer", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("federated "), 64*1024)
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: senderAddr, TLSConfig: tlsConfig})
+		require.NoError(t, err)
+
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: receiverAddr, TLSConfig: tlsConfig}))
+		require.NoError(t, <-errC)
+		assert.Equal(t, payload, received.Bytes())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvousExpiring(ctx, senderAddr, 100*time.Millisecond, conn.WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		_, err = sender.SecureConnection(ctx, rc, pass)
+		require.Error(t, err)
+
+		rrc, err := receiver.ConnectRendezvous(receiverAddr, conn.WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		_, err = receiver.SecureConnection(ctx, rrc, pass)
+		assert.ErrorIs(t, err, receiver.ErrCodeExpired)
+	})
+
+	t.Run("unauthorized peer", func(t *testing.T) {
+		for _, header := range []string{"", "Bearer wrong-token"} {
+			_, err := conn.Dial(ctx, conn.WebsocketURL(senderAddr, "/federate-receiver"), conn.WithHeader("Authorization", header), conn.WithTLSConfig(tlsConfig))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprint(http.StatusUnauthorized))
+		}
+	})
+
+	t.Run("unknown password", func(t *testing.T) {
+		rrc, err := receiver.ConnectRendezvous(receiverAddr, conn.WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		_, err = receiver.SecureConnection(ctx, rrc, "1-unknown-federated-password")
+		assert.ErrorIs(t, err, receiver.ErrCodeUnknown)
+	})
+}
+
+func TestPeerFanout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the peers hold no mailbox, counting the receivers relayed to them.
+	var relayed atomic.Int64
+	pool := x509.NewCertPool()
+	var peers []rendezvous.Peer
+	for i := 0; i < 5; i++ {
+		peer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			relayed.Add(1)
+			http.NotFound(w, r)
+		}))
+		t.Cleanup(peer.Close)
+		pool.AddCert(peer.Certificate())
+		peers = append(peers, rendezvous.Peer{Addr: strings.Replace(peer.URL, "https://", "wss://", 1), Token: "peering-token"})
+	}
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithPeers(peers...),
+		rendezvous.WithPeerTLS(&tls.Config{RootCAs: pool}))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	rc, err := receiver.ConnectRendezvous(fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	_, err = receiver.SecureConnection(ctx, rc, "1-unknown-federated-password")
+	assert.ErrorIs(t, err, receiver.ErrCodeUnknown)
+	assert.EqualValues(t, rendezvous.DEFAULT_PEER_FANOUT, relayed.Load())
+
+	t.Run("insecure peer", func(t *testing.T) {
+		server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithPeers(rendezvous.Peer{Addr: "localhost:8080", Token: "peering-token"}))
+		assert.Error(t, server.Run(ctx))
+	})
+}
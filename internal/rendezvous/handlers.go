@@ -2,17 +2,24 @@
 package rendezvous
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"html/template"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/logger"
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
 	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 )
@@ -35,18 +42,49 @@ func (s *Server) handleEstablishSender() http.HandlerFunc {
 		rc := conn.Rendezvous{Conn: c}
 		logger.Info("sender connected")
 
-		id := s.ids.Bind()
+		var mailbox *Mailbox
+		outcome := OUTCOME_FAILED
+		tr := s.traceMailbox(r)
+		tr.Phase(PHASE_REGISTER)
+		defer func() {
+			tr.End(mailbox, outcome)
+			// senders never registering a mailbox did not attempt a transfer.
+			if mailbox != nil {
+				s.outcomes.Record(outcome)
+				s.metrics.Ended(outcome, mailbox.State())
+				s.auditTransfer(r, mailbox, outcome)
+				s.logTransfer(r, mailbox, outcome)
+			}
+		}()
+
+		id, err := s.ids.Bind()
+		if err != nil {
+			logger.Error("binding id", zap.Error(err))
+			c.Close(conn.CLOSE_FAILED, rendezvous.BIND_FAILED) //nolint:errcheck
+			return
+		}
 		logger = logger.With(zap.Int("id", id))
 		logger.Info("bound id")
 		defer func() {
-			s.ids.Delete(id)
+			if err := s.ids.Delete(id); err != nil {
+				logger.Error("freeing id", zap.Error(err))
+				return
+			}
 			logger.Info("freed id")
 		}()
 
+		token, err := newSessionToken()
+		if err != nil {
+			logger.Error("generating session token", zap.Error(err))
+			return
+		}
+		_, endRegistration := s.boundHandshake(ctx, c, logger)
+		defer endRegistration()
 		err = rc.WriteMsg(ctx, rendezvous.Msg{
 			Type: rendezvous.RendezvousToSenderBind,
 			Payload: rendezvous.Payload{
-				ID: id,
+				ID:    id,
+				Token: token,
 			},
 		})
 		if err != nil {
@@ -60,57 +98,156 @@ func (s *Server) handleEstablishSender() http.HandlerFunc {
 			return
 		}
 
-		// Allocate a mailbox for this communication.
-		mailbox := &Mailbox{
-			Sender:   make(chan []byte),
-			Receiver: make(chan []byte),
+		endRegistration()
+
+		// no receiver reaches senders establishing once the server stopped accepting connections.
+		if s.isClosing() {
+			logger.Warn("rejecting sender, server shutting down")
+			c.Close(conn.CLOSE_FAILED, rendezvous.SERVER_CLOSING) //nolint:errcheck
+			return
 		}
-		s.mailboxes.StoreMailbox(msg.Payload.Password, mailbox)
-		password := msg.Payload.Password
 
-		// wait for receiver to connect or connection timeout
-		timeout := time.NewTimer(RECEIVER_CONNECT_TIMEOUT)
-		select {
-		case <-ctx.Done():
-			if ctx.Err() != nil {
-				logger.Error("context error while waiting for receiver", zap.Error(ctx.Err()))
+		// Allocate a mailbox for this communication, or a further mailbox of the password for senders sending
+		// to several receivers.
+		password := msg.Payload.Password
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		claimed := newMailbox(id, s.clock)
+		claimed.token = token
+		claimed.closeSender = func(reason string) {
+			logger.Warn("closing sender of mailbox", zap.String("reason", reason))
+			if err := conn.CloseTimeout(c, conn.CLOSE_FAILED, reason, s.closeTimeout); err != nil {
+				logger.Warn("closing sender connection", zap.Error(err))
 			}
-			logger.Info("closing handler")
-			return
-		case <-timeout.C:
-			logger.Warn("waiting for receiver timed out")
+			cancel()
+		}
+		key := password
+		if msg.Payload.Join != "" {
+			var reason string
+			if key, reason = s.joinMailbox(ctx, password, msg.Payload.Join, claimed); reason != "" {
+				logger.Warn("rejecting join of mailbox", zap.String("reason", reason))
+				c.Close(conn.CLOSE_FAILED, reason) //nolint:errcheck
+				return
+			}
+			logger.Info("joined mailbox of sender")
+		} else if !s.mailboxes.ClaimMailbox(password, claimed) {
+			logger.Warn("password held by another sender")
+			c.Close(conn.CLOSE_FAILED, rendezvous.CODE_IN_USE) //nolint:errcheck
 			return
-		case <-mailbox.Sender:
-			break
 		}
+		mailbox = claimed
+		s.waiting.claim(password)
+		if s.locator != nil && key == password {
+			if err := s.locator.Register(password); err != nil {
+				logger.Warn("registering mailbox in locator", zap.Error(err))
+			}
+			defer func() {
+				if err := s.locator.Deregister(password); err != nil {
+					logger.Warn("deregistering mailbox from locator", zap.Error(err))
+				}
+			}()
+		}
+		// senders may expire their code before the receiver connect timeout.
+		if msg.Payload.ExpireAfter > 0 && msg.Payload.ExpireAfter < RECEIVER_CONNECT_TIMEOUT {
+			mailbox.expireAfter = msg.Payload.ExpireAfter
+		}
+		defer func() {
+			logger.Info("deallocating mailbox")
+			// reaped mailboxes are deallocated already, their password may be claimed by another sender since.
+			s.mailboxes.CompareAndDelete(key, mailbox)
+		}()
+		tr.Phase(PHASE_PEER_CONNECT)
 
-		err = rc.WriteMsg(ctx, rendezvous.Msg{
-			Type: rendezvous.RendezvousToSenderReady,
-		})
+		// bounds the steps of the key exchange awaiting the sender, once a receiver connected.
+		var handshake context.Context
+		endHandshake := func() {}
+		defer func() { endHandshake() }()
 
-		if err != nil {
-			logger.Error("sending ready message to sender", zap.Error(err))
-			return
-		}
+		// The key exchange is restarted from scratch, keeping the mailbox, if the receiver
+		// disconnects before the exchange is completed.
+		for restarts := 0; ; restarts++ {
+			endHandshake()
+			// wait for receiver to connect or connection timeout
+			timeout := time.NewTimer(mailbox.receiverWait())
+			select {
+			case <-ctx.Done():
+				if ctx.Err() != nil {
+					logger.Error("context error while waiting for receiver", zap.Error(ctx.Err()))
+				}
+				logger.Info("closing handler")
+				return
+			case <-timeout.C:
+				logger.Warn("waiting for receiver timed out")
+				outcome = OUTCOME_RECEIVER_TIMEOUT
+				if mailbox.expireAfter > 0 {
+					s.expire(password)
+					c.Close(conn.CLOSE_FAILED, rendezvous.CODE_EXPIRED) //nolint:errcheck
+				}
+				return
+			case <-mailbox.Sender:
+				timeout.Stop()
+			}
+			handshake, endHandshake = s.boundHandshake(ctx, c, logger)
 
-		msg, err = rc.ReadMsg(ctx, rendezvous.SenderToRendezvousPAKE)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			logger.Error("performing PAKE exchange", zap.Error(err))
-			return
-		}
-		// send PAKE bytes to receiver
-		mailbox.Receiver <- msg.Payload.Bytes
-		// respond with receiver PAKE bytes
-		err = rc.WriteMsg(ctx, rendezvous.Msg{
-			Type: rendezvous.RendezvousToSenderPAKE,
-			Payload: rendezvous.Payload{
-				Bytes: <-mailbox.Sender,
-			},
-		})
-		if err != nil {
-			logger.Error("sending PAKE bytes to sender", zap.Error(err))
-			return
+			err = rc.WriteMsg(ctx, rendezvous.Msg{
+				Type:    rendezvous.RendezvousToSenderReady,
+				Payload: rendezvous.Payload{KDFIterations: s.minKDFIterations},
+			})
+
+			if err != nil {
+				logger.Error("sending ready message to sender", zap.Error(err))
+				return
+			}
+
+			msg, err = rc.ReadMsg(ctx, rendezvous.SenderToRendezvousPAKE)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				logger.Error("performing PAKE exchange", zap.Error(err))
+				return
+			}
+			// send PAKE bytes to receiver
+			select {
+			case mailbox.Receiver <- conn.Frame{Payload: msg.Payload.Bytes}:
+			case <-handshake.Done():
+				return
+			}
+			// waiting for the receiver is bound by the handshake of the receiver, which drops on timeout.
+			endHandshake()
+
+			var receiverPAKE conn.Frame
+			select {
+			case <-ctx.Done():
+				logger.Info("closing handler")
+				return
+			case receiverPAKE = <-mailbox.Sender:
+			case <-mailbox.dropped:
+				if restarts >= MAX_HANDSHAKE_RESTARTS {
+					logger.Warn("receiver disconnected during PAKE exchange, restart limit reached")
+					return
+				}
+				logger.Info("receiver disconnected during PAKE exchange, restarting exchange")
+				if err := rc.WriteMsg(ctx, rendezvous.Msg{Type: rendezvous.RendezvousToSenderRestart}); err != nil {
+					logger.Error("sending restart message to sender", zap.Error(err))
+					return
+				}
+				mailbox.setState(MailboxWaiting)
+				mailbox.hasReceiver.Store(false)
+				continue
+			}
+
+			// respond with receiver PAKE bytes
+			handshake, endHandshake = s.boundHandshake(ctx, c, logger)
+			err = rc.WriteMsg(ctx, rendezvous.Msg{
+				Type: rendezvous.RendezvousToSenderPAKE,
+				Payload: rendezvous.Payload{
+					Bytes: receiverPAKE.Payload,
+				},
+			})
+			if err != nil {
+				logger.Error("sending PAKE bytes to sender", zap.Error(err))
+				return
+			}
+			break
 		}
 
 		msg, err = rc.ReadMsg(ctx, rendezvous.SenderToRendezvousSalt)
@@ -120,30 +257,97 @@ func (s *Server) handleEstablishSender() http.HandlerFunc {
 			return
 		}
 
+		// Reject senders choosing weaker key derivation parameters than advertised.
+		iterations := msg.Payload.KDFIterations
+		if iterations == 0 {
+			iterations = conn.DEFAULT_KDF_ITERATIONS
+		}
+		if iterations < s.minKDFIterations {
+			logger.Warn("rejecting weak key derivation parameters", zap.Int("kdf_iterations", iterations))
+			close(mailbox.Receiver)
+			s.mailboxes.Delete(key)
+			c.Close(conn.CLOSE_FAILED, rendezvous.KDF_ITERATIONS_TOO_LOW) //nolint:errcheck
+			return
+		}
+
 		// Send the salt to the receiver.
-		mailbox.Receiver <- msg.Payload.Salt
-		// Start forwarder and relay
-		forward := make(chan []byte)
-		wg := sync.WaitGroup{}
-		relayCtx, cancel := context.WithCancel(ctx)
+		mailbox.kdfIterations = msg.Payload.KDFIterations
+		select {
+		case mailbox.Receiver <- conn.Frame{Payload: msg.Payload.Salt}:
+		case <-handshake.Done():
+			return
+		}
+		endHandshake()
+		mailbox.setState(MailboxRelaying)
+		tr.Phase(PHASE_RELAY)
+		outcome = OUTCOME_COMPLETED
+		defer s.chargeQuota(ctx, mailbox, identityFromRequest(r), logger)()
+		relayStart := s.clock.Monotonic()
+		defer func() { s.metrics.Relaying(s.clock.Monotonic() - relayStart) }()
+		// done of the sender relayed over, if it resumed the session.
+		var resumedDone chan struct{}
+		for {
+			// Start forwarder and relay
+			forward := make(chan conn.Frame)
+			wg := sync.WaitGroup{}
+			relayCtx, cancel := context.WithCancel(ctx)
 
-		wg.Add(2)
-		go s.forwarder(relayCtx, &wg, rc, forward, logger)
-		s.relay(relayCtx, &wg, rc, forward, mailbox.Sender, mailbox.Receiver, logger)
+			var lost atomic.Bool
+			relayed := s.watchDeadline(relayCtx, &wg, rc, logger)
+			wg.Add(3)
+			go s.forwarder(relayCtx, &wg, relayed, forward, &mailbox.senderClose, &lost, logger)
+			go s.watchIdle(relayCtx, &wg, relayed, mailbox, logger)
+			ended := s.relay(relayCtx, &wg, relayed, forward, mailbox.Sender, mailbox.Receiver, mailbox, &mailbox.toReceiver, &lost, logger)
+			if !ended || !lost.Load() {
+				s.relayClose(c, &mailbox.receiverClose, logger)
+			}
 
-		// We want to make sure that the both forwarder and relay have terminated
-		cancel()
-		wg.Wait()
+			// We want to make sure that the both forwarder and relay have terminated
+			cancel()
+			wg.Wait()
+			// the handler of a resumed sender returns once its connection is no longer relayed over.
+			if resumedDone != nil {
+				close(resumedDone)
+				resumedDone = nil
+			}
+			if !ended || !lost.Load() {
+				break
+			}
 
-		// Deallocate mailbox
-		logger.Info("deallocating mailbox")
-		s.mailboxes.Delete(password)
+			// The connection to the sender was lost, keep the mailbox until the sender resumes.
+			resumed, ok := s.awaitResume(ctx, mailbox, logger)
+			if !ok {
+				outcome = OUTCOME_SENDER_LOST
+				// unblock the receiver relay, forwarding to a sender that is gone.
+				go func() {
+					for frame := range mailbox.Sender {
+						s.inFlight.Release(len(frame.Payload))
+					}
+				}()
+				break
+			}
+			resumedDone = resumed.done
+			c, rc = resumed.conn, conn.Rendezvous{Conn: resumed.conn}
+			if err := rc.WriteMsg(ctx, rendezvous.Msg{Type: rendezvous.RendezvousToSenderResumed}); err != nil {
+				logger.Error("sending resumed message to sender", zap.Error(err))
+				outcome = OUTCOME_FAILED
+				break
+			}
+		}
+		if resumedDone != nil {
+			close(resumedDone)
+		}
+		close(mailbox.Receiver)
+		if outcome == OUTCOME_COMPLETED {
+			outcome = relayOutcome(mailbox)
+		}
 		logger.Info("sender closing")
 	}
 }
 
-// handleEstablishReceiver returns a websocket handler that communicates with the sender.
-func (s *Server) handleEstablishReceiver() http.HandlerFunc {
+// handleEstablishReceiver returns a websocket handler that communicates with the receiver. Receivers presenting
+// a password unknown to the server are relayed to the federated peers of the server, if federate is set.
+func (s *Server) handleEstablishReceiver(federate bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger, err := logger.FromContext(ctx)
@@ -158,6 +362,8 @@ func (s *Server) handleEstablishReceiver() http.HandlerFunc {
 		}
 		rc := conn.Rendezvous{Conn: c}
 		logger.Info("receiver connected")
+		handshake, endHandshake := s.boundHandshake(ctx, c, logger)
+		defer func() { endHandshake() }()
 
 		// Establish receiver.
 		msg, err := rc.ReadMsg(ctx, rendezvous.ReceiverToRendezvousEstablish)
@@ -169,45 +375,113 @@ func (s *Server) handleEstablishReceiver() http.HandlerFunc {
 
 		mailbox, err := s.mailboxes.GetMailbox(msg.Payload.Password)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			logger.Error("failed to get mailbox", zap.Error(err))
+			if _, ok := s.expired.Load(msg.Payload.Password); ok {
+				logger.Warn("mailbox expired")
+				c.Close(conn.CLOSE_FAILED, rendezvous.CODE_EXPIRED) //nolint:errcheck
+				return
+			}
+			if federate && len(s.peers) > 0 {
+				// the handshake is bound by the peer holding the mailbox.
+				endHandshake()
+				if s.federateReceiver(ctx, rc, msg, logger) {
+					return
+				}
+			}
+			if !msg.Payload.Wait {
+				logger.Warn("failed to get mailbox", zap.Error(err))
+				c.Close(conn.CLOSE_FAILED, rendezvous.CODE_UNKNOWN) //nolint:errcheck
+				return
+			}
+			// waiting for the sender is bound by the receiver connect timeout rather than the handshake.
+			endHandshake()
+			var ok bool
+			if mailbox, ok = s.awaitSender(ctx, c, msg.Payload.Password, logger); !ok {
+				return
+			}
+			handshake, endHandshake = s.boundHandshake(ctx, c, logger)
+		}
+		if !s.acquireRelay() {
+			logger.Warn("too many concurrent relays, rejecting receiver", zap.Int("max_relays", s.maxRelays))
+			c.Close(conn.CLOSE_FAILED, rendezvous.TOO_MANY_RELAYS) //nolint:errcheck
 			return
 		}
-		if mailbox.hasReceiver {
+		defer s.releaseRelay()
+		// reserve the first mailbox of the password without a receiver for this receiver to receive.
+		reserved := false
+		if mailbox, reserved = s.mailboxes.ReserveMailbox(msg.Payload.Password); !reserved {
 			w.WriteHeader(http.StatusBadRequest)
 			logger.Warn("mailbox already have a receiver")
 			return
 		}
-		// this receiver was first, reserve this mailbox for it to receive
-		mailbox.hasReceiver = true
-		s.mailboxes.StoreMailbox(msg.Payload.Password, mailbox)
+		mailbox.receiver.Store(identityFromRequest(r))
+		mailbox.setState(MailboxHandshake)
+
+		// signal the sender that the key exchange can be restarted with a new receiver.
+		dropped := func() {
+			select {
+			case mailbox.dropped <- struct{}{}:
+			default:
+			}
+		}
 
 		// notify sender we are connected
-		mailbox.Sender <- []byte{}
+		select {
+		case mailbox.Sender <- conn.Frame{}:
+		case <-handshake.Done():
+			dropped()
+			return
+		}
 		// send back received sender PAKE bytes
+		var senderPAKE conn.Frame
+		select {
+		case senderPAKE = <-mailbox.Receiver:
+		case <-handshake.Done():
+			dropped()
+			return
+		}
 		err = rc.WriteMsg(ctx, rendezvous.Msg{
 			Type: rendezvous.RendezvousToReceiverPAKE,
 			Payload: rendezvous.Payload{
-				Bytes: <-mailbox.Receiver,
+				Bytes: senderPAKE.Payload,
 			},
 		})
 		if err != nil {
+			dropped()
 			logger.Error("sending PAKE bytes to receiver", zap.Error(err))
 			return
 		}
 
 		msg, err = rc.ReadMsg(ctx, rendezvous.ReceiverToRendezvousPAKE)
 		if err != nil {
+			dropped()
 			w.WriteHeader(http.StatusBadRequest)
 			logger.Error("performing PAKE exchange", zap.Error(err))
 			return
 		}
 
-		mailbox.Sender <- msg.Payload.Bytes
+		select {
+		case mailbox.Sender <- conn.Frame{Payload: msg.Payload.Bytes}:
+		case <-handshake.Done():
+			dropped()
+			return
+		}
+		var salt conn.Frame
+		var ok bool
+		select {
+		case salt, ok = <-mailbox.Receiver:
+		case <-handshake.Done():
+			return
+		}
+		if !ok {
+			logger.Warn("handshake of sender rejected")
+			c.Close(conn.CLOSE_FAILED, rendezvous.KDF_ITERATIONS_TOO_LOW) //nolint:errcheck
+			return
+		}
 		err = rc.WriteMsg(ctx, rendezvous.Msg{
 			Type: rendezvous.RendezvousToReceiverSalt,
 			Payload: rendezvous.Payload{
-				Salt: <-mailbox.Receiver,
+				Salt:          salt.Payload,
+				KDFIterations: mailbox.kdfIterations,
 			},
 		})
 		if err != nil {
@@ -215,15 +489,26 @@ func (s *Server) handleEstablishReceiver() http.HandlerFunc {
 			logger.Error("exchanging salt", zap.Error(err))
 			return
 		}
+		endHandshake()
 
 		// Start forwarder and relay
-		forward := make(chan []byte)
+		forward := make(chan conn.Frame)
 		wg := sync.WaitGroup{}
 		subCtx, cancel := context.WithCancel(ctx)
 
-		wg.Add(2)
-		go s.forwarder(subCtx, &wg, rc, forward, logger)
-		s.relay(subCtx, &wg, rc, forward, mailbox.Receiver, mailbox.Sender, logger)
+		relayed := s.watchDeadline(subCtx, &wg, rc, logger)
+		wg.Add(3)
+		go s.forwarder(subCtx, &wg, relayed, forward, &mailbox.receiverClose, nil, logger)
+		go s.watchIdle(subCtx, &wg, relayed, mailbox, logger)
+		s.relay(subCtx, &wg, relayed, forward, mailbox.Receiver, mailbox.Sender, mailbox, &mailbox.toSender, nil, logger)
+		close(mailbox.Sender)
+		// unblock the sender relay, forwarding to a receiver that is gone, until the sender closes.
+		go func() {
+			for frame := range mailbox.Receiver {
+				s.inFlight.Release(len(frame.Payload))
+			}
+		}()
+		s.relayClose(c, &mailbox.senderClose, logger)
 		cancel()
 
 		wg.Wait()
@@ -232,6 +517,57 @@ func (s *Server) handleEstablishReceiver() http.HandlerFunc {
 	}
 }
 
+// handleResumeSender returns a websocket handler that reattaches a sender that lost its connection to its mailbox.
+func (s *Server) handleResumeSender() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger, err := logger.FromContext(ctx)
+		if err != nil {
+			return
+		}
+		c, err := conn.FromContext(ctx)
+		if err != nil {
+			logger.Error("getting Conn from request context", zap.Error(err))
+			return
+		}
+		rc := conn.Rendezvous{Conn: c}
+		logger.Info("sender resuming")
+
+		msg, err := rc.ReadMsg(ctx, rendezvous.SenderToRendezvousResume)
+		if err != nil {
+			logger.Error("resuming sender", zap.Error(err))
+			return
+		}
+		mailbox, err := s.mailboxes.GetSession(msg.Payload.Password, msg.Payload.Token)
+		if err != nil {
+			logger.Warn("rejecting resume with unknown mailbox or session token")
+			s.auditAuth(r, false, "rejected resume with unknown session token")
+			c.Close(conn.CLOSE_FAILED, rendezvous.UNKNOWN_SESSION) //nolint:errcheck
+			return
+		}
+		logger = logger.With(zap.Int("id", mailbox.id))
+
+		// the mailbox may not have noticed that the previous connection was lost yet.
+		done := make(chan struct{})
+		timeout := time.NewTimer(SENDER_RESUME_TIMEOUT)
+		defer timeout.Stop()
+		select {
+		case mailbox.resume <- resumedSender{conn: c, done: done}:
+		case <-timeout.C:
+			logger.Warn("mailbox not awaiting a resumed sender")
+			c.Close(conn.CLOSE_FAILED, rendezvous.SESSION_NOT_RESUMABLE) //nolint:errcheck
+			return
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		logger.Info("resumed sender closing")
+	}
+}
+
 //nolint:errcheck
 func (s *Server) handleVersionCheck() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -252,6 +588,52 @@ func (s *Server) handleVersionCheck() http.HandlerFunc {
 	}
 }
 
+// handleInfo returns a handler that describes the server, including the message of the day.
+func (s *Server) handleInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger, err := logger.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		response, err := json.Marshal(rendezvous.Info{MOTD: s.motd, NATProbePorts: s.NATProbePorts(), Subprotocols: s.subprotocols})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("failed to marshal server info", zap.Error(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+// handleStats returns a handler that reports the state of the server and the outcomes of its recent transfers.
+func (s *Server) handleStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger, err := logger.FromContext(ctx)
+		if err != nil {
+			return
+		}
+
+		response, err := json.Marshal(s.Snapshot().Stats())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("failed to marshal server stats", zap.Error(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+// fallbackLandingPage is served when the landing page template could not be loaded.
+var fallbackLandingPage = template.Must(template.New("fallback").Parse(
+	`<!DOCTYPE html><html><head><title>Portal relay</title></head>` +
+		`<body><h1>Portal relay</h1><p>Version {{.Version}}</p></body></html>`,
+))
+
 func (s *Server) handleLandingPage() http.HandlerFunc {
 	templatePath := "relay/landing.html"
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -263,8 +645,8 @@ func (s *Server) handleLandingPage() http.HandlerFunc {
 		w.Header().Set("Content-Type", "text/html")
 		tmpl, ok := s.templates[templatePath]
 		if !ok {
-			logger.Sugar().Errorf("failed to find template at path '%s'", templatePath)
-			return
+			logger.Sugar().Warnf("failed to find template at path '%s', serving fallback page", templatePath)
+			tmpl = fallbackLandingPage
 		}
 		err = tmpl.Execute(w, struct{ Version string }{s.version.String()})
 		if err != nil {
@@ -282,70 +664,398 @@ func (s *Server) ping() http.HandlerFunc {
 	}
 }
 
+// ---------------------------------------------------- Middleware -----------------------------------------------------
+
+// trackRelays keeps track of in-flight connections, such that they can be drained after a handoff.
+func (s *Server) trackRelays(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.relays.Add(1)
+		defer s.relays.Done()
+		defer s.metrics.Connected()()
+		defer s.admin.Connected()()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitMailboxes rejects senders whose identity already holds the maximum number of mailboxes.
+// The reserved mailbox is released when the sender handler returns and the mailbox is deallocated.
+func (s *Server) limitMailboxes(next http.Handler) http.Handler {
+	if s.identities == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromRequest(r)
+		if !s.identities.Acquire(identity) {
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Warn("identity exceeded mailbox limit", zap.String("identity", s.loggedIP(identity)))
+			}
+			http.Error(w, "too many mailboxes", http.StatusTooManyRequests)
+			return
+		}
+		defer s.identities.Release(identity)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loggedIP returns the IP address, or identity, of a client as it appears in the logs, anonymized if configured.
+// Limits are always enforced on the full IP address.
+func (s *Server) loggedIP(ip string) string {
+	if s.anonymizeIPs {
+		return logger.AnonymizeIP(ip)
+	}
+	return ip
+}
+
+// gzipResponses compresses the responses of clients accepting gzip encoded responses.
+// Websocket upgrades are passed through uncompressed, as the hijacked connection cannot be compressed.
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, gz: gz}, r)
+	})
+}
+
+// gzipResponseWriter compresses the body written to the response.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	// the length of the compressed body is unknown until it is written.
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	return w.gz.Write(b)
+}
+
 // ------------------------------------------------------ Helpers ------------------------------------------------------
 
-// forwarder reads from the connection and forwards the message to the provided channel.
+// acceptsGzip reports whether the Accept-Encoding header of the request accepts gzip, without a quality of 0.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if strings.TrimSpace(name) != "gzip" {
+				continue
+			}
+			key, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+			if strings.TrimSpace(key) != "q" {
+				return true
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+	}
+	return false
+}
+
+// boundHandshake bounds the handshake on the connection by the handshake timeout of the server, tearing down the
+// connection once the timeout expires. The returned context is done once the handshake timed out or ended, and the
+// returned function ends the handshake. Without a handshake timeout the handshake is only bound by the context.
+func (s *Server) boundHandshake(ctx context.Context, c conn.Conn, logger *zap.Logger) (context.Context, func()) {
+	if s.handshakeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	handshake, cancel := context.WithTimeout(ctx, s.handshakeTimeout)
+	go func() {
+		defer s.recoverRelay(c, logger)
+		<-handshake.Done()
+		if !errors.Is(handshake.Err(), context.DeadlineExceeded) {
+			return
+		}
+		logger.Warn("handshake timed out", zap.Duration("handshake_timeout", s.handshakeTimeout))
+		if err := conn.CloseTimeout(c, conn.CLOSE_FAILED, rendezvous.HANDSHAKE_TIMED_OUT, s.closeTimeout); err != nil {
+			logger.Warn("closing timed out connection", zap.Error(err))
+		}
+	}()
+	return handshake, cancel
+}
+
+// expire remembers the password of an expired mailbox for RECEIVER_CONNECT_TIMEOUT, such that receivers
+// presenting it are told that the code expired rather than that it does not exist.
+func (s *Server) expire(password string) {
+	s.expired.Store(password, struct{}{})
+	time.AfterFunc(RECEIVER_CONNECT_TIMEOUT, func() { s.expired.Delete(password) })
+}
+
+// forwarder reads from the connection and forwards the message to the provided channel, preserving its message type.
+// Close frames received from the connection are recorded in closed, to be relayed to the peer, and
+// connections lost without a close frame are recorded in lost, if provided.
 // Transient errors are logged on the provided logger.
-func (s *Server) forwarder(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, forward chan<- []byte, logger *zap.Logger) {
+func (s *Server) forwarder(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, forward chan<- conn.Frame, closed *closeStatus, lost *atomic.Bool, logger *zap.Logger) {
 	forwardLogger := logger.With(zap.String("component", "forwarder"))
 	forwardLogger.Info("starting forwarder")
 	defer wg.Done()
 	defer close(forward)
+	defer s.recoverRelay(rc.Conn, forwardLogger)
 	for {
-		payload, err := rc.ReadRaw(ctx)
+		frame, err := rc.ReadFrame(ctx)
 		switch {
 		case errors.Is(err, io.EOF):
 			forwardLogger.Error("connection forcefully closed", zap.Error(err))
+			if lost != nil {
+				lost.Store(true)
+			}
 			return
 
 		// TODO: Extract closure status out to the Conn implementation
 		//  Would be better to return a custom error, so we are not
 		//  as heavily coupled with the websocket library
 
-		case websocket.CloseStatus(err) == websocket.StatusNormalClosure:
-			forwardLogger.Info("connection closed, closing forwarder")
+		case websocket.CloseStatus(err) != -1:
+			var closeErr websocket.CloseError
+			errors.As(err, &closeErr)
+			closed.Set(closeErr.Code, closeErr.Reason)
+			forwardLogger.Info("connection closed, closing forwarder",
+				zap.String("close_code", closeErr.Code.String()), zap.String("close_reason", closeErr.Reason))
 			return
 		case errors.Is(err, context.Canceled):
 			forwardLogger.Info("context canceled, closing forwarder")
 			return
 		case err != nil:
 			forwardLogger.Error("error reading from connection, closing forwarder", zap.Error(err))
+			if lost != nil {
+				lost.Store(true)
+			}
 			return
 		}
 
-		var msg rendezvous.Msg
-		if err := json.Unmarshal(payload, &msg); err == nil {
-			logger.Info("received unencrypted message, closing forwarder")
+		if msg, ok := controlMsg(frame); ok {
+			logger.Info("received control message, closing forwarder", zap.Int("type", int(msg.Type)))
+			return
+		}
+		// released by the relay of the peer once written to the peer.
+		if err := s.inFlight.Acquire(ctx, len(frame.Payload)); err != nil {
+			forwardLogger.Info("context canceled while waiting for in-flight budget, closing forwarder")
+			return
+		}
+		select {
+		case forward <- frame:
+		case <-ctx.Done():
+			s.inFlight.Release(len(frame.Payload))
+			forwardLogger.Info("context canceled while forwarding, closing forwarder")
+			return
+		}
+	}
+}
+
+// watchIdle closes the connection once no payload was relayed through the mailbox for the idle timeout of the
+// server, warning the peer on the connection the idle warning interval before, or once the mailbox is evicted, its
+// sender exceeds its transfer quota or its transfer exceeds the bytes relayed per transfer. The peer is warned once
+// the server starts draining its relays. Returns once the context is done.
+func (s *Server) watchIdle(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, mailbox *Mailbox, logger *zap.Logger) {
+	defer wg.Done()
+	defer s.recoverRelay(rc.Conn, logger)
+	var tick <-chan time.Time
+	if s.idleTimeout > 0 {
+		ticker := time.NewTicker(max(s.idleTimeout/20, MIN_IDLE_CHECK_INTERVAL))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	relayed := mailbox.toSender.Load() + mailbox.toReceiver.Load()
+	active := time.Now()
+	warned := false
+	closing := s.closing
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closing:
+			closing = nil
+			if err := rc.WriteMsg(ctx, rendezvous.Msg{
+				Type:    rendezvous.RendezvousToPeerClosing,
+				Payload: rendezvous.Payload{DisconnectIn: time.Until(s.drainDeadline)},
+			}); err != nil {
+				logger.Warn("warning peer of shutdown", zap.Error(err))
+			}
+			continue
+		case <-mailbox.evicted:
+			logger.Warn("relay evicted", zap.String("reason", mailbox.evictReason))
+			if err := conn.CloseTimeout(rc.Conn, conn.CLOSE_FAILED, mailbox.evictReason, s.closeTimeout); err != nil {
+				logger.Warn("closing evicted connection", zap.Error(err))
+			}
 			return
+		case <-mailbox.overQuota:
+			if err := conn.CloseTimeout(rc.Conn, conn.CLOSE_FAILED, rendezvous.QUOTA_EXCEEDED, s.closeTimeout); err != nil {
+				logger.Warn("closing connection exceeding transfer quota", zap.Error(err))
+			}
+			return
+		case <-mailbox.overLimit:
+			if err := conn.CloseTimeout(rc.Conn, conn.CLOSE_FAILED, rendezvous.RELAY_LIMIT_EXCEEDED, s.closeTimeout); err != nil {
+				logger.Warn("closing connection exceeding relay limit", zap.Error(err))
+			}
+			return
+		case <-tick:
+		}
+		if n := mailbox.toSender.Load() + mailbox.toReceiver.Load(); n != relayed {
+			relayed, active, warned = n, time.Now(), false
+			continue
 		}
-		forward <- payload
+		remaining := s.idleTimeout - time.Since(active)
+		switch {
+		case remaining <= 0:
+			logger.Warn("relay idle timed out", zap.Duration("idle_timeout", s.idleTimeout))
+			if err := conn.CloseTimeout(rc.Conn, conn.CLOSE_FAILED, rendezvous.TRANSFER_IDLE, s.closeTimeout); err != nil {
+				logger.Warn("closing idle connection", zap.Error(err))
+			}
+			return
+		case !warned && remaining <= s.idleWarning:
+			warned = true
+			if err := rc.WriteMsg(ctx, rendezvous.Msg{
+				Type:    rendezvous.RendezvousToPeerIdle,
+				Payload: rendezvous.Payload{DisconnectIn: remaining},
+			}); err != nil {
+				logger.Warn("warning idle peer", zap.Error(err))
+			}
+		}
+	}
+}
+
+// awaitResume waits for the sender of the mailbox to resume its lost connection.
+func (s *Server) awaitResume(ctx context.Context, mailbox *Mailbox, logger *zap.Logger) (resumedSender, bool) {
+	logger.Info("lost connection to sender, waiting for sender to resume")
+	timeout := time.NewTimer(SENDER_RESUME_TIMEOUT)
+	defer timeout.Stop()
+	select {
+	case resumed := <-mailbox.resume:
+		logger.Info("sender resumed")
+		return resumed, true
+	case <-timeout.C:
+		logger.Warn("waiting for sender to resume timed out")
+		return resumedSender{}, false
+	case <-ctx.Done():
+		return resumedSender{}, false
+	}
+}
+
+// relayClose closes the connection with the close frame received from the peer, if any.
+// Unresponsive connections are forcefully closed after the close timeout of the server.
+func (s *Server) relayClose(c conn.Conn, peer *closeStatus, logger *zap.Logger) {
+	code, reason, ok := peer.Get()
+	if !ok {
+		return
+	}
+	logger.Info("relaying close frame", zap.String("close_code", code.String()), zap.String("close_reason", reason))
+	reason = relayedReason(reason)
+	if err := conn.CloseTimeout(c, code, reason, s.closeTimeout); err != nil {
+		logger.Warn("relaying close frame", zap.Error(err))
 	}
 }
 
-func (s *Server) relay(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, forward, relayIn <-chan []byte, relayOut chan<- []byte, logger *zap.Logger) {
+// controlMsg returns the control message addressed to the server, if the frame is one. Control messages are text
+// messages holding only the control envelope, see rendezvous.Control, other frames are relayed as is.
+func controlMsg(frame conn.Frame) (rendezvous.Msg, bool) {
+	if frame.Type != websocket.MessageText {
+		return rendezvous.Msg{}, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(frame.Payload))
+	dec.DisallowUnknownFields()
+	var control rendezvous.Control
+	if err := dec.Decode(&control); err != nil || dec.More() || control.Control == nil {
+		return rendezvous.Msg{}, false
+	}
+	switch control.Control.Type {
+	case rendezvous.ReceiverToRendezvousClose, rendezvous.SenderToRendezvousClose:
+		return *control.Control, true
+	}
+	return rendezvous.Msg{}, false
+}
+
+// relayedReasons are the close reasons relayed to peers as is.
+var relayedReasons = map[string]bool{
+	rendezvous.TRANSFER_COMPLETED:     true,
+	rendezvous.TRANSFER_CANCELED:      true,
+	rendezvous.TRANSFER_FAILED:        true,
+	rendezvous.CODE_EXPIRED:           true,
+	rendezvous.CODE_UNKNOWN:           true,
+	rendezvous.HANDSHAKE_TIMED_OUT:    true,
+	rendezvous.TRANSFER_IDLE:          true,
+	rendezvous.SERVER_CLOSING:         true,
+	rendezvous.EVICTED_IDLE:           true,
+	rendezvous.MAILBOX_CLOSED:         true,
+	rendezvous.MAILBOX_EXPIRED:        true,
+	rendezvous.QUOTA_EXCEEDED:         true,
+	rendezvous.RELAY_LIMIT_EXCEEDED:   true,
+	rendezvous.KDF_ITERATIONS_TOO_LOW: true,
+	rendezvous.DEADLINE_EXCEEDED:      true,
+	rendezvous.FEDERATION_LOST:        true,
+	rendezvous.PANIC_CLOSE_REASON:     true,
+	transfer.MIGRATED:                 true,
+}
+
+// relayedReason returns the close reason to relay in place of the close reason of the peer. Reasons other than
+// the fixed reasons of the protocol, e.g. error messages of older clients, are relayed as a failed transfer, such
+// that paths, addresses and errors of one peer are not disclosed to the other.
+func relayedReason(reason string) string {
+	if reason == "" || relayedReasons[reason] {
+		return reason
+	}
+	return rendezvous.TRANSFER_FAILED
+}
+
+// relay relays messages between the connection and its peer, counting the bytes relayed to the peer and recording
+// the time of the last relayed payload in the mailbox, cutting the transfer off once it exceeds the bytes relayed
+// per transfer. Returns whether the relay ended as the connection ended, rather than the peer, recording connections
+// lost while writing in lost, if provided. The caller closes relayOut once the connection is no longer
+// relayed, signaling the peer.
+func (s *Server) relay(ctx context.Context, wg *sync.WaitGroup, rc conn.Rendezvous, forward, relayIn <-chan conn.Frame, relayOut chan<- conn.Frame, mailbox *Mailbox, relayed *atomic.Int64, lost *atomic.Bool, logger *zap.Logger) bool {
 	relayLogger := logger.With(zap.String("component", "relay"))
 	relayLogger.Info("starting")
 	defer wg.Done()
-	defer close(relayOut)
 	for {
 		select {
 		case <-ctx.Done():
 			relayLogger.Info("received context done signal")
-			return
+			return false
 		case forwarded, more := <-forward:
 			if !more {
 				relayLogger.Info("forwarding channel closed, closing relay")
-				return
+				return true
 			}
-			relayOut <- forwarded
-		case relayed, more := <-relayIn:
+			if s.exceedsRelayLimit(mailbox, len(forwarded.Payload)) {
+				// dropped rather than relayed, until the connections are closed.
+				s.inFlight.Release(len(forwarded.Payload))
+				if mailbox.exceedRelayLimit() {
+					relayLogger.Warn("transfer exceeded relay limit, cutting off transfer", zap.Int64("max_relay_bytes", s.maxRelayBytes))
+				}
+				continue
+			}
+			select {
+			case relayOut <- forwarded:
+			case <-ctx.Done():
+				s.inFlight.Release(len(forwarded.Payload))
+				relayLogger.Info("received context done signal")
+				return false
+			}
+			relayed.Add(int64(len(forwarded.Payload)))
+			mailbox.active.Store(int64(s.clock.Monotonic()))
+			s.shedder.Relayed(len(forwarded.Payload))
+			s.metrics.Relayed(len(forwarded.Payload))
+			s.admin.Relayed(len(forwarded.Payload))
+		case frame, more := <-relayIn:
 			if !more {
 				relayLogger.Info("relay channel closed, closing relay")
-				return
+				return false
 			}
-			if err := rc.WriteRaw(ctx, relayed); err != nil {
+			err := rc.WriteFrame(ctx, frame)
+			s.inFlight.Release(len(frame.Payload))
+			if err != nil {
 				relayLogger.Error("writing relayed message to connection")
-				return
+				if lost != nil {
+					lost.Store(true)
+				}
+				return true
 			}
 		}
 	}
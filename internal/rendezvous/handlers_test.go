@@ -0,0 +1,661 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/schollz/pake/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestHandshakeRestart(t *testing.T) {
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	oracle := "A frog walks into a bank..."
+	config := portal.Config{RendezvousAddr: addr}
+	in := bytes.NewBufferString(oracle)
+	pass, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+	require.NoError(t, err)
+
+	// receiver disconnects after receiving the PAKE bytes of the sender.
+	ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+	require.NoError(t, err)
+	rc := conn.Rendezvous{Conn: ws}
+	require.NoError(t, rc.WriteMsg(ctx, protocol.Msg{
+		Type:    protocol.ReceiverToRendezvousEstablish,
+		Payload: protocol.Payload{Password: password.Hashed(pass)},
+	}))
+	_, err = rc.ReadMsg(ctx, protocol.RendezvousToReceiverPAKE)
+	require.NoError(t, err)
+	require.NoError(t, ws.Conn.CloseNow())
+
+	// the same password can be used to complete the transfer.
+	out := &bytes.Buffer{}
+	require.NoError(t, portal.Receive(ctx, out, pass, &config))
+	assert.NoError(t, <-errC)
+	assert.Equal(t, oracle, out.String())
+}
+
+func TestKDFNegotiation(t *testing.T) {
+	const min = 1000
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithMinKDFIterations(min))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	t.Run("advertised minimum", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		receiverC := make(chan conn.Transfer, 1)
+		go func() {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err != nil {
+				close(receiverC)
+				return
+			}
+			tc, _ := receiver.SecureConnection(ctx, rrc, pass)
+			receiverC <- tc
+		}()
+		// the default iterations of the sender are raised to the advertised minimum.
+		stc, err := sender.SecureConnection(ctx, rc, pass)
+		require.NoError(t, err)
+		rtc := <-receiverC
+		require.NotNil(t, rtc.Conn)
+		assert.Equal(t, stc.Key(), rtc.Key())
+	})
+
+	t.Run("weak parameters rejected", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		receiverErrC := make(chan error, 1)
+		go func() {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err == nil {
+				_, err = receiver.SecureConnection(ctx, rrc, pass)
+			}
+			receiverErrC <- err
+		}()
+
+		// a sender ignoring the advertised minimum.
+		msg, err := rc.ReadMsg(ctx, protocol.RendezvousToSenderReady)
+		require.NoError(t, err)
+		assert.Equal(t, min, msg.Payload.KDFIterations)
+		p, err := pake.InitCurve([]byte(pass), 0, "p256")
+		require.NoError(t, err)
+		require.NoError(t, rc.WriteMsg(ctx, protocol.Msg{Type: protocol.SenderToRendezvousPAKE, Payload: protocol.Payload{Bytes: p.Bytes()}}))
+		_, err = rc.ReadMsg(ctx, protocol.RendezvousToSenderPAKE)
+		require.NoError(t, err)
+		require.NoError(t, rc.WriteMsg(ctx, protocol.Msg{
+			Type:    protocol.SenderToRendezvousSalt,
+			Payload: protocol.Payload{Salt: []byte("saltsalt"), KDFIterations: conn.DEFAULT_KDF_ITERATIONS},
+		}))
+
+		_, err = rc.ReadMsg(ctx)
+		assert.Equal(t, conn.CLOSE_FAILED, websocket.CloseStatus(err))
+		assert.Error(t, <-receiverErrC)
+	})
+}
+
+func TestCloseCodes(t *testing.T) {
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	config := portal.Config{RendezvousAddr: addr}
+
+	// connect sets up a transfer, returning the sender errors and a secure receiver connection
+	// recording the close frame sent by the receiver.
+	connect := func(t *testing.T) (chan error, conn.Transfer, *recordingConn) {
+		in := bytes.NewBufferString("A frog walks into a bank...")
+		pass, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		rc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rec := &recordingConn{Conn: rc.Conn}
+		rc.Conn = rec
+		tc, err := receiver.SecureConnection(ctx, rc, pass)
+		require.NoError(t, err)
+		return errC, tc, rec
+	}
+
+	t.Run("success", func(t *testing.T) {
+		errC, tc, rec := connect(t)
+		require.NoError(t, receiver.Receive(ctx, tc, &bytes.Buffer{}))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, conn.CLOSE_COMPLETED, rec.code)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		errC, tc, rec := connect(t)
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.ErrorIs(t, receiver.Receive(cancelCtx, tc, &bytes.Buffer{}), context.Canceled)
+		assert.Equal(t, conn.CLOSE_CANCELED, rec.code)
+		// the relay forwards the close frame of the receiver to the sender.
+		assert.Equal(t, conn.CLOSE_CANCELED, websocket.CloseStatus(<-errC))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, tc, rec := connect(t)
+		assert.Error(t, receiver.Receive(ctx, tc, failingWriter{}))
+		assert.Equal(t, conn.CLOSE_FAILED, rec.code)
+		assert.Equal(t, protocol.TRANSFER_FAILED, rec.reason)
+		assert.NotContains(t, rec.reason, "disk full")
+	})
+
+	t.Run("error message of peer", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+
+		// reasons other than the fixed reasons of the protocol are not relayed to the peer.
+		require.NoError(t, stc.Conn.Close(conn.CLOSE_FAILED, "open /home/alice/secret.txt: permission denied"))
+		_, err = rtc.ReadRaw(ctx)
+		var closeErr websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, conn.CLOSE_FAILED, closeErr.Code)
+		assert.Equal(t, protocol.TRANSFER_FAILED, closeErr.Reason)
+	})
+}
+
+func TestCloseTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithCloseTimeout(timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan error, 1)
+	go func() {
+		// the sender stops reading once secured, never acknowledging the close frame of the relay.
+		_, err := sender.SecureConnection(ctx, rc, pass)
+		senderC <- err
+	}()
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	tc, err := receiver.SecureConnection(ctx, rrc, pass)
+	require.NoError(t, err)
+	require.NoError(t, <-senderC)
+	require.Len(t, server.Snapshot().Mailboxes, 1)
+
+	require.NoError(t, conn.CloseWithError(tc.Conn, nil))
+	// the mailbox is deallocated once the unresponsive sender is forcefully closed, well before the
+	// close handshake of the websocket library times out. Snapshots are cached for up to a second.
+	assert.Eventually(t, func() bool {
+		return len(server.Snapshot().Mailboxes) == 0
+	}, rendezvous.SNAPSHOT_MAX_AGE+time.Second, 10*time.Millisecond)
+}
+
+func TestFramePassthrough(t *testing.T) {
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, _ := sender.SecureConnection(ctx, rc, pass)
+		senderC <- tc
+	}()
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+	require.NoError(t, err)
+	stc := <-senderC
+	require.NotNil(t, stc.Conn)
+	senderWS, receiverWS := stc.Conn.(*conn.WS).Conn, rtc.Conn.(*conn.WS).Conn
+
+	fragmented := bytes.Repeat([]byte("fragment "), 16*1024)
+	// payloads of the peers are relayed even if they parse as rendezvous messages, only enveloped control
+	// messages are addressed to the relay.
+	closeMsg, err := json.Marshal(protocol.Msg{Type: protocol.ReceiverToRendezvousClose})
+	require.NoError(t, err)
+	frames := []conn.Frame{
+		{Type: websocket.MessageText, Payload: []byte("text from sender")},
+		{Type: websocket.MessageBinary, Payload: []byte{0x00, 0xff, 0x10}},
+		{Type: websocket.MessageText, Payload: []byte(`{"hello":"world"}`)},
+		{Type: websocket.MessageText, Payload: closeMsg},
+		{Type: websocket.MessageBinary, Payload: closeMsg},
+		{Type: websocket.MessageText, Payload: []byte(`{"control":{"type":10},"data":"relayed"}`)},
+		{Type: websocket.MessageText, Payload: fragmented},
+		{Type: websocket.MessageBinary, Payload: fragmented},
+		{Type: websocket.MessageText, Payload: []byte("text again")},
+	}
+	// write sends the frame, splitting large ones into continuation frames.
+	write := func(c *websocket.Conn, frame conn.Frame) error {
+		w, err := c.Writer(ctx, frame.Type)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 4; i++ {
+			part := frame.Payload[i*len(frame.Payload)/4 : (i+1)*len(frame.Payload)/4]
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	}
+	for _, dir := range []struct {
+		name     string
+		from, to *websocket.Conn
+	}{
+		{"sender to receiver", senderWS, receiverWS},
+		{"receiver to sender", receiverWS, senderWS},
+	} {
+		t.Run(dir.name, func(t *testing.T) {
+			errC := make(chan error, 1)
+			go func() {
+				for _, frame := range frames {
+					if err := write(dir.from, frame); err != nil {
+						errC <- err
+						return
+					}
+				}
+				errC <- nil
+			}()
+			for _, expected := range frames {
+				dir.to.SetReadLimit(conn.MESSAGE_SIZE_LIMIT_BYTES)
+				typ, payload, err := dir.to.Read(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Type, typ)
+				assert.Equal(t, expected.Payload, payload)
+			}
+			require.NoError(t, <-errC)
+		})
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithHandshakeTimeout(timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// assertTimedOut asserts that the connection is torn down with the handshake timeout as reason.
+	assertTimedOut := func(t *testing.T, rc conn.Rendezvous) {
+		t.Helper()
+		start := time.Now()
+		_, err := rc.ReadMsg(ctx)
+		assert.Equal(t, conn.CLOSE_FAILED, websocket.CloseStatus(err))
+		assert.ErrorContains(t, err, "handshake timed out")
+		assert.Less(t, time.Since(start), 5*timeout)
+	}
+	// establishSender registers a sender, returning its connection and password once a receiver connected.
+	establishSender := func(t *testing.T) (conn.Rendezvous, string) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		return rc, pass
+	}
+
+	t.Run("registration", func(t *testing.T) {
+		ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-sender", addr))
+		require.NoError(t, err)
+		rc := conn.Rendezvous{Conn: ws}
+		_, err = rc.ReadMsg(ctx, protocol.RendezvousToSenderBind)
+		require.NoError(t, err)
+		assertTimedOut(t, rc)
+	})
+	t.Run("stalled sender", func(t *testing.T) {
+		rc, pass := establishSender(t)
+		// waiting for the receiver is not bound by the handshake timeout.
+		time.Sleep(2 * timeout)
+		receiverErrC := make(chan error, 1)
+		go func() {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err == nil {
+				_, err = receiver.SecureConnection(ctx, rrc, pass)
+			}
+			receiverErrC <- err
+		}()
+		_, err := rc.ReadMsg(ctx, protocol.RendezvousToSenderReady)
+		require.NoError(t, err)
+		// the sender never sends its PAKE bytes.
+		assertTimedOut(t, rc)
+		assert.Error(t, <-receiverErrC)
+		assert.Eventually(t, func() bool {
+			return len(server.Snapshot().Mailboxes) == 0
+		}, rendezvous.SNAPSHOT_MAX_AGE+time.Second, 10*time.Millisecond)
+	})
+	t.Run("stalled receiver", func(t *testing.T) {
+		rc, pass := establishSender(t)
+		senderC := make(chan error, 1)
+		go func() {
+			_, err := sender.SecureConnection(ctx, rc, pass)
+			senderC <- err
+		}()
+		ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+		require.NoError(t, err)
+		rrc := conn.Rendezvous{Conn: ws}
+		require.NoError(t, rrc.WriteMsg(ctx, protocol.Msg{
+			Type:    protocol.ReceiverToRendezvousEstablish,
+			Payload: protocol.Payload{Password: password.Hashed(pass)},
+		}))
+		_, err = rrc.ReadMsg(ctx, protocol.RendezvousToReceiverPAKE)
+		require.NoError(t, err)
+		// the receiver never sends its PAKE bytes, the sender keeps its mailbox for a new receiver.
+		assertTimedOut(t, rrc)
+
+		// a new receiver completes the key exchange once the mailbox awaits a receiver again.
+		var tc conn.Transfer
+		require.Eventually(t, func() bool {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err != nil {
+				return false
+			}
+			tc, err = receiver.SecureConnection(ctx, rrc, pass)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, <-senderC)
+		require.NoError(t, conn.CloseWithError(tc.Conn, nil))
+	})
+	t.Run("relay outlasting the timeout", func(t *testing.T) {
+		rc, pass := establishSender(t)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+
+		// the relay is not bound by the handshake timeout of either peer.
+		time.Sleep(3 * timeout)
+		require.NoError(t, stc.WriteRaw(ctx, []byte("from sender")))
+		b, err := rtc.ReadRaw(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "from sender", string(b))
+		require.NoError(t, rtc.WriteRaw(ctx, []byte("from receiver")))
+		b, err = stc.ReadRaw(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "from receiver", string(b))
+		require.NoError(t, conn.CloseWithError(stc.Conn, nil))
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const timeout, warning = 600 * time.Millisecond, 300 * time.Millisecond
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithIdleTimeout(timeout, warning))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, pass)
+		assert.NoError(t, err)
+		senderC <- tc
+	}()
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+	require.NoError(t, err)
+	stc := <-senderC
+
+	// relayed payload resets the idle timeout.
+	time.Sleep(warning / 2)
+	require.NoError(t, stc.WriteRaw(ctx, []byte("still here")))
+	b, err := rtc.ReadRaw(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "still here", string(b))
+
+	// awaitIdle reads from the idle connection until it is closed, returning the warnings received before.
+	awaitIdle := func(tc conn.Transfer) chan []time.Duration {
+		warningsC := make(chan []time.Duration, 1)
+		go func() {
+			var warnings []time.Duration
+			tc.OnIdle = func(disconnectIn time.Duration) { warnings = append(warnings, disconnectIn) }
+			start := time.Now()
+			_, err := tc.ReadRaw(ctx)
+			assert.Equal(t, conn.CLOSE_FAILED, websocket.CloseStatus(err))
+			assert.ErrorContains(t, err, "transfer idle")
+			assert.Less(t, time.Since(start), 2*timeout)
+			warningsC <- warnings
+		}()
+		return warningsC
+	}
+	for name, warningsC := range map[string]chan []time.Duration{"sender": awaitIdle(stc), "receiver": awaitIdle(rtc)} {
+		warnings := <-warningsC
+		require.Len(t, warnings, 1, "%s was not warned once before the disconnect", name)
+		assert.Greater(t, warnings[0], time.Duration(0))
+		assert.LessOrEqual(t, warnings[0], warning)
+	}
+}
+
+func TestIdleTimeoutBounds(t *testing.T) {
+	// relay connects a sender and a receiver through a server with the provided idle timeout, returning the error
+	// the receiver reads once the sender idles.
+	relay := func(t *testing.T, timeout time.Duration) error {
+		server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithIdleTimeout(timeout, 0))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		t.Cleanup(cancel)
+		go server.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		go sender.SecureConnection(ctx, rc, pass) //nolint:errcheck
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		readCtx, cancelRead := context.WithTimeout(ctx, time.Second)
+		defer cancelRead()
+		_, err = rtc.ReadRaw(readCtx)
+		return err
+	}
+
+	t.Run("shorter than the check interval", func(t *testing.T) {
+		err := relay(t, time.Nanosecond)
+		assert.Equal(t, conn.CLOSE_FAILED, websocket.CloseStatus(err))
+		assert.ErrorContains(t, err, "transfer idle")
+	})
+
+	t.Run("non-positive", func(t *testing.T) {
+		err := relay(t, -time.Second)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "relays should be unbounded")
+	})
+}
+
+func TestConnDeadline(t *testing.T) {
+	const deadline = 400 * time.Millisecond
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithConnDeadline(deadline))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// connect returns the secured connections of a sender and a receiver relayed by the server.
+	connect := func(t *testing.T) (conn.Transfer, conn.Transfer) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, err := sender.SecureConnection(ctx, rc, pass)
+			assert.NoError(t, err)
+			senderC <- tc
+		}()
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		return <-senderC, rtc
+	}
+	// read reads from the connection, answering pings while waiting.
+	read := func(tc conn.Transfer) chan error {
+		errC := make(chan error, 1)
+		go func() {
+			_, err := tc.ReadRaw(ctx)
+			errC <- err
+		}()
+		return errC
+	}
+
+	t.Run("responsive peers", func(t *testing.T) {
+		stc, rtc := connect(t)
+		receiverC := read(rtc)
+		senderC := read(stc)
+		// idle peers answering pings outlive the deadline.
+		time.Sleep(3 * deadline)
+		require.NoError(t, stc.WriteRaw(ctx, []byte("still here")))
+		assert.NoError(t, <-receiverC)
+		select {
+		case err := <-senderC:
+			t.Fatalf("sender connection closed: %v", err)
+		default:
+		}
+	})
+	t.Run("peer stopped reading", func(t *testing.T) {
+		stc, rtc := connect(t)
+		senderC := read(stc)
+		// the receiver neither reads nor answers pings, its connection is closed once the deadline passed.
+		time.Sleep(3 * deadline)
+		readCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err := rtc.ReadRaw(readCtx)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, context.DeadlineExceeded)
+		// the relay of the sender is torn down along with its dead peer.
+		assert.Error(t, <-senderC)
+	})
+}
+
+func TestExpireAfter(t *testing.T) {
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvousExpiring(ctx, addr, 200*time.Millisecond)
+	require.NoError(t, err)
+	senderC := make(chan error, 1)
+	go func() {
+		_, err := sender.SecureConnection(ctx, rc, pass)
+		senderC <- err
+	}()
+	err = <-senderC
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), protocol.CODE_EXPIRED)
+
+	// the code stops working once expired, receivers are told it expired.
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	_, err = receiver.SecureConnection(ctx, rrc, pass)
+	assert.ErrorIs(t, err, receiver.ErrCodeExpired)
+}
+
+func TestReceiverFirst(t *testing.T) {
+	server := rendezvous.NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	const pass = "7-receiver-connects-first"
+
+	// receivers not waiting fail while no sender holds the password.
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	_, err = receiver.SecureConnection(ctx, rrc, pass)
+	require.Error(t, err)
+
+	oracle := "the receiver was here first"
+	out := &bytes.Buffer{}
+	receiverC := make(chan error, 1)
+	go func() {
+		receiverC <- portal.Receive(ctx, out, pass, &portal.Config{RendezvousAddr: addr, WaitForSender: true})
+	}()
+	require.Never(t, func() bool { return len(receiverC) > 0 }, 300*time.Millisecond, 10*time.Millisecond, "receiver did not wait")
+
+	in := bytes.NewBufferString(oracle)
+	claimed, err, errC := portal.Send(ctx, in, int64(in.Len()), &portal.Config{RendezvousAddr: addr, Password: pass})
+	require.NoError(t, err)
+	assert.Equal(t, pass, claimed)
+
+	// other senders cannot claim a password held by a sender.
+	rc, err := sender.ConnectRendezvousClaiming(ctx, addr, pass, 0)
+	require.NoError(t, err)
+	_, err = sender.SecureConnection(ctx, rc, pass)
+	assert.ErrorIs(t, err, sender.ErrCodeInUse)
+
+	require.NoError(t, <-receiverC)
+	assert.NoError(t, <-errC)
+	assert.Equal(t, oracle, out.String())
+}
+
+// ------------------------------------------------------ Helpers ------------------------------------------------------
+
+// recordingConn records the close frame sent on the connection.
+type recordingConn struct {
+	conn.Conn
+	code   websocket.StatusCode
+	reason string
+}
+
+func (c *recordingConn) Close(code websocket.StatusCode, reason string) error {
+	c.code, c.reason = code, reason
+	return c.Conn.Close(code, reason)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
@@ -0,0 +1,92 @@
+// handoff.go specifies how the listening socket of the server is handed off to a new server process,
+// allowing zero-downtime upgrades. The new process accepts all new connections while in-flight relays
+// are drained by the old process.
+package rendezvous
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// INHERITED_LISTENER_ENV is set on handed off processes to the file descriptor of the inherited listener.
+const INHERITED_LISTENER_ENV = "PORTAL_INHERITED_LISTENER_FD"
+
+// first file descriptor passed using systemd socket activation, or os/exec ExtraFiles.
+const listenFdsStart = 3
+
+// ListenerFromEnv returns the listener inherited from a previous server process, or passed using
+// systemd socket activation (LISTEN_FDS), returning nil if no listener was inherited.
+func ListenerFromEnv() (net.Listener, error) {
+	fd := -1
+	if v := os.Getenv(INHERITED_LISTENER_ENV); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", INHERITED_LISTENER_ENV, err)
+		}
+		fd = n
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n > 0 {
+			fd = listenFdsStart
+		}
+	}
+	if fd < 0 {
+		return nil, nil
+	}
+	// do not leak the inherited listener to processes spawned by this process.
+	os.Unsetenv(INHERITED_LISTENER_ENV)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+
+	f := os.NewFile(uintptr(fd), "inherited-listener")
+	if f == nil {
+		return nil, fmt.Errorf("invalid inherited listener file descriptor %d", fd)
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("importing inherited listener: %w", err)
+	}
+	return l, nil
+}
+
+// Handoff starts a new server process that inherits the listening socket of the server.
+// The process is started using the handoff command, defaulting to re-executing the current
+// binary with the same arguments. After a successful handoff the server should be shutdown,
+// at which point it stops accepting connections and drains its in-flight relays.
+func (s *Server) Handoff() (*os.Process, error) {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("server is not listening on a TCP listener")
+	}
+	f, err := tl.File()
+	if err != nil {
+		return nil, fmt.Errorf("exporting listener: %w", err)
+	}
+	defer f.Close()
+
+	name, args := s.handoffCmd, s.handoffArgs
+	if name == "" {
+		if name, err = os.Executable(); err != nil {
+			return nil, fmt.Errorf("resolving executable: %w", err)
+		}
+		args = os.Args[1:]
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", INHERITED_LISTENER_ENV, listenFdsStart))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting handoff process: %w", err)
+	}
+	s.handedOff.Store(true)
+	s.logger.Sugar().Infof("handed off listener to process %d", cmd.Process.Pid)
+	return cmd.Process, nil
+}
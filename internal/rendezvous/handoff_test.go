@@ -0,0 +1,131 @@
+package rendezvous_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+const handoffChildEnv = "PORTAL_TEST_HANDOFF_CHILD"
+
+// TestHandoffChild is run as the process inheriting the listener in TestHandoff.
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv(handoffChildEnv) == "" {
+		t.Skip("only run as the handoff child process")
+	}
+	l, err := rendezvous.ListenerFromEnv()
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	server := rendezvous.NewServer(0, "", semver.Version{Major: 2}, rendezvous.WithListener(l))
+	assert.NoError(t, server.Run(context.Background()))
+}
+
+func TestHandoff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping handoff integration test...")
+	}
+	t.Setenv(handoffChildEnv, "1")
+	server := rendezvous.NewServer(0, "", semver.Version{Major: 1},
+		rendezvous.WithHandoffCommand(os.Args[0], "-test.run=^TestHandoffChild$"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() { errC <- server.Run(ctx) }()
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	waitForPing(t, addr)
+
+	// an in-flight connection to the old process.
+	ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+	require.NoError(t, err)
+
+	proc, err := server.Handoff()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		proc.Kill() //nolint:errcheck
+		proc.Wait() //nolint:errcheck
+	})
+	cancel()
+
+	// new connections are served by the new process.
+	assert.Eventually(t, func() bool {
+		return versionOf(addr).Major == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// the old process drains the in-flight connection before exiting.
+	select {
+	case err := <-errC:
+		t.Fatalf("server exited with in-flight relays: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, ws.Conn.Close(websocket.StatusNormalClosure, ""))
+	select {
+	case err := <-errC:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not exit after in-flight relays were drained")
+	}
+}
+
+func TestHandoffTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping handoff integration test...")
+	}
+	t.Setenv(handoffChildEnv, "1")
+	const handoffTimeout = 200 * time.Millisecond
+	server := rendezvous.NewServer(0, "", semver.Version{Major: 1}, rendezvous.WithHandoffTimeout(handoffTimeout),
+		rendezvous.WithCloseTimeout(100*time.Millisecond), rendezvous.WithHandoffCommand(os.Args[0], "-test.run=^TestHandoffChild$"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() { errC <- server.Run(ctx) }()
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	waitForPing(t, addr)
+
+	// a stuck connection to the old process, never closed by its peer.
+	ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/establish-receiver", addr))
+	require.NoError(t, err)
+	defer ws.Conn.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+
+	proc, err := server.Handoff()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		proc.Kill() //nolint:errcheck
+		proc.Wait() //nolint:errcheck
+	})
+	start := time.Now()
+	cancel()
+
+	// the old process exits once the handoff timeout passed, rather than waiting for the stuck connection.
+	select {
+	case err := <-errC:
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), handoffTimeout)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not exit after the handoff timeout")
+	}
+}
+
+func versionOf(addr string) semver.Version {
+	var v semver.Version
+	r, err := http.Get(fmt.Sprintf("http://%s/version", addr))
+	if err != nil {
+		return v
+	}
+	defer r.Body.Close()
+	json.NewDecoder(r.Body).Decode(&v) //nolint:errcheck
+	return v
+}
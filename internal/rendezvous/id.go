@@ -2,25 +2,148 @@
 package rendezvous
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 )
 
-// IDs is a threadsafe set of numbers.
+// IDStore allocates the numerical ids bound to senders, such that no two senders hold the same id.
+type IDStore interface {
+	// Bind binds the lowest free id.
+	Bind() (int, error)
+	// Delete frees a bound id.
+	Delete(id int) error
+	// Len returns the number of bound ids.
+	Len() (int, error)
+}
+
+// ------------------------------------------------------ Memory -------------------------------------------------------
+
+// IDs is a threadsafe set of numbers, the default in-memory IDStore.
 type IDs struct{ *sync.Map }
 
 type void struct{} // empty struct complies to 0 bytes
 var member void
 
 // Bind binds an id to connection.
-func (ids *IDs) Bind() int {
+func (ids *IDs) Bind() (int, error) {
 	id := 1
 	for {
-		val, _ := ids.Load(id)
-		if val == nil {
-			break
+		if _, bound := ids.LoadOrStore(id, member); !bound {
+			return id, nil
 		}
 		id++
 	}
-	ids.Store(id, member)
-	return id
+}
+
+// Delete frees the id.
+func (ids *IDs) Delete(id int) error {
+	ids.Map.Delete(id)
+	return nil
+}
+
+// Len returns the number of bound ids.
+func (ids *IDs) Len() (int, error) {
+	n := 0
+	ids.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n, nil
+}
+
+// ----------------------------------------------------- Directory -----------------------------------------------------
+
+// DirIDs is an IDStore persisting the bound ids as files in a directory, such that ids are not reused
+// across restarts and can be shared by servers with access to the same directory. Ids are bound by
+// exclusively creating their file, which is atomic across processes.
+type DirIDs struct {
+	dir string
+}
+
+// NewDirIDs constructs an IDStore persisted in the provided directory, creating it if needed.
+// Ids bound longer than maxAge ago are freed, as they are left behind by servers that stopped
+// without freeing them. A maxAge of 0 never frees ids left behind.
+func NewDirIDs(dir string, maxAge time.Duration) (*DirIDs, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating id store directory: %w", err)
+	}
+	ids := &DirIDs{dir: dir}
+	if maxAge > 0 {
+		if err := ids.freeStale(maxAge); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Bind binds the lowest id without a file in the directory.
+func (ids *DirIDs) Bind() (int, error) {
+	for id := 1; ; id++ {
+		f, err := os.OpenFile(ids.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			return id, f.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return 0, fmt.Errorf("binding id: %w", err)
+		}
+	}
+}
+
+// Delete frees the id, removing its file.
+func (ids *DirIDs) Delete(id int) error {
+	if err := os.Remove(ids.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("freeing id: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of bound ids.
+func (ids *DirIDs) Len() (int, error) {
+	entries, err := ids.entries()
+	return len(entries), err
+}
+
+func (ids *DirIDs) path(id int) string {
+	return filepath.Join(ids.dir, strconv.Itoa(id))
+}
+
+// entries returns the files of the bound ids.
+func (ids *DirIDs) entries() ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(ids.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading id store directory: %w", err)
+	}
+	bound := entries[:0]
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err == nil && e.Type().IsRegular() {
+			bound = append(bound, e)
+		}
+	}
+	return bound, nil
+}
+
+// freeStale frees the ids bound longer than maxAge ago.
+func (ids *DirIDs) freeStale(maxAge time.Duration) error {
+	entries, err := ids.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > maxAge {
+			if err := os.Remove(filepath.Join(ids.dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("freeing stale id: %w", err)
+			}
+		}
+	}
+	return nil
 }
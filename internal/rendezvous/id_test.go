@@ -0,0 +1,118 @@
+package rendezvous_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirIDs(t *testing.T) {
+	t.Run("lowest free id", func(t *testing.T) {
+		ids, err := rendezvous.NewDirIDs(t.TempDir(), 0)
+		require.NoError(t, err)
+		for want := 1; want <= 3; want++ {
+			id, err := ids.Bind()
+			require.NoError(t, err)
+			assert.Equal(t, want, id)
+		}
+		require.NoError(t, ids.Delete(2))
+		id, err := ids.Bind()
+		require.NoError(t, err)
+		assert.Equal(t, 2, id)
+		n, err := ids.Len()
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("survives restart", func(t *testing.T) {
+		dir := t.TempDir()
+		before, err := rendezvous.NewDirIDs(dir, time.Hour)
+		require.NoError(t, err)
+		id, err := before.Bind()
+		require.NoError(t, err)
+
+		after, err := rendezvous.NewDirIDs(dir, time.Hour)
+		require.NoError(t, err)
+		next, err := after.Bind()
+		require.NoError(t, err)
+		assert.NotEqual(t, id, next)
+	})
+
+	t.Run("frees stale ids", func(t *testing.T) {
+		dir := t.TempDir()
+		before, err := rendezvous.NewDirIDs(dir, time.Hour)
+		require.NoError(t, err)
+		id, err := before.Bind()
+		require.NoError(t, err)
+		stale := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "1"), stale, stale))
+
+		after, err := rendezvous.NewDirIDs(dir, time.Hour)
+		require.NoError(t, err)
+		next, err := after.Bind()
+		require.NoError(t, err)
+		assert.Equal(t, id, next)
+	})
+
+	t.Run("unique across stores", func(t *testing.T) {
+		dir := t.TempDir()
+		stores := make([]*rendezvous.DirIDs, 4)
+		for i := range stores {
+			var err error
+			stores[i], err = rendezvous.NewDirIDs(dir, 0)
+			require.NoError(t, err)
+		}
+		var mu sync.Mutex
+		bound := map[int]bool{}
+		var wg sync.WaitGroup
+		for _, ids := range stores {
+			wg.Add(1)
+			go func(ids *rendezvous.DirIDs) {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					id, err := ids.Bind()
+					if !assert.NoError(t, err) {
+						return
+					}
+					mu.Lock()
+					assert.False(t, bound[id], "id %d bound twice", id)
+					bound[id] = true
+					mu.Unlock()
+				}
+			}(ids)
+		}
+		wg.Wait()
+		assert.Len(t, bound, 100)
+	})
+}
+
+func TestIDs(t *testing.T) {
+	ids := &rendezvous.IDs{Map: &sync.Map{}}
+	var mu sync.Mutex
+	bound := map[int]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id, err := ids.Bind()
+				assert.NoError(t, err)
+				mu.Lock()
+				assert.False(t, bound[id], "id %d bound twice", id)
+				bound[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := ids.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 400, n)
+}
@@ -0,0 +1,111 @@
+// identity.go specifies the datastructure used to limit the number of mailboxes held by a single client identity.
+package rendezvous
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Identities is a threadsafe counter of the mailboxes held by each client identity.
+type Identities struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+// NewIdentities constructs an identity counter allowing at most max mailboxes per identity.
+func NewIdentities(max int) *Identities {
+	return &Identities{max: max, counts: make(map[string]int)}
+}
+
+// Acquire reserves a mailbox for the identity, returning false if the identity is at its limit.
+func (ids *Identities) Acquire(identity string) bool {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	if ids.counts[identity] >= ids.max {
+		return false
+	}
+	ids.counts[identity]++
+	return true
+}
+
+// Release frees a mailbox reserved by the identity.
+func (ids *Identities) Release(identity string) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.counts[identity]--
+	if ids.counts[identity] <= 0 {
+		delete(ids.counts, identity)
+	}
+}
+
+// Count returns the number of mailboxes currently held by the identity.
+func (ids *Identities) Count(identity string) int {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	return ids.counts[identity]
+}
+
+// identityFromRequest resolves the identity of the client making the request. Clients presenting a verified
+// certificate are identified by it, other clients by their remote host.
+func identityFromRequest(r *http.Request) string {
+	if identity, ok := certIdentity(r); ok {
+		return identity
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientCertVerified marks the context of connections the client certificate of which was verified by the
+// server, rather than by crypto/tls, see requireClientCerts.
+type clientCertVerified struct{}
+
+// certIdentity returns the identity of the verified client certificate of the request. Returns false if no
+// certificate was verified.
+func certIdentity(r *http.Request) (string, bool) {
+	switch {
+	case r.TLS == nil:
+		return "", false
+	case len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0:
+		return certName(r.TLS.VerifiedChains[0][0])
+	case len(r.TLS.PeerCertificates) > 0 && r.Context().Value(clientCertVerified{}) != nil:
+		return certName(r.TLS.PeerCertificates[0])
+	default:
+		return "", false
+	}
+}
+
+// certName returns the identity of the certificate, its common name or else its first DNS or email subject
+// alternative name. Returns false if the certificate names none.
+func certName(cert *x509.Certificate) (string, bool) {
+	switch {
+	case cert.Subject.CommonName != "":
+		return "cert:" + cert.Subject.CommonName, true
+	case len(cert.DNSNames) > 0:
+		return "cert:" + cert.DNSNames[0], true
+	case len(cert.EmailAddresses) > 0:
+		return "cert:" + cert.EmailAddresses[0], true
+	default:
+		return "", false
+	}
+}
+
+// logClientIdentity adds the identity of verified client certificates to the logger of the request.
+func logClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity, ok := certIdentity(r); ok {
+			if l, err := logger.FromContext(r.Context()); err == nil {
+				r = r.WithContext(logger.WithLogger(r.Context(), l.With(zap.String("client_identity", identity))))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
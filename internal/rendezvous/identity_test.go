@@ -0,0 +1,61 @@
+package rendezvous_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestIdentities(t *testing.T) {
+	ids := rendezvous.NewIdentities(2)
+	assert.True(t, ids.Acquire("a"))
+	assert.True(t, ids.Acquire("a"))
+	assert.False(t, ids.Acquire("a"))
+	assert.True(t, ids.Acquire("b"))
+
+	ids.Release("a")
+	assert.Equal(t, 1, ids.Count("a"))
+	assert.True(t, ids.Acquire("a"))
+}
+
+func TestMaxMailboxesPerIdentity(t *testing.T) {
+	port := freePort(t)
+	server := rendezvous.NewServer(port, "", semver.Version{}, rendezvous.WithMaxMailboxesPerIdentity(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitForPing(t, addr)
+	url := fmt.Sprintf("ws://%s/establish-sender", addr)
+
+	first, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	second, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	defer second.Close(websocket.StatusNormalClosure, "")
+
+	_, resp, err := websocket.Dial(ctx, url, nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// tearing down a mailbox frees up room for the identity.
+	first.Close(websocket.StatusNormalClosure, "")
+	assert.Eventually(t, func() bool {
+		c, _, err := websocket.Dial(ctx, url, nil)
+		if err != nil {
+			return false
+		}
+		c.Close(websocket.StatusNormalClosure, "")
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,59 @@
+// inflight.go specifies the global budget of relayed bytes held in memory by the server across all mailboxes.
+package rendezvous
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// inFlight bounds the sum of the bytes read from a connection and not yet written to its peer, across all
+// mailboxes. Reading from a connection is paused while the budget is exhausted. A nil budget is unbounded.
+type inFlight struct {
+	max   int64
+	sem   *semaphore.Weighted
+	bytes atomic.Int64
+}
+
+// newInFlight constructs a budget of max bytes in flight.
+func newInFlight(max int64) *inFlight {
+	return &inFlight{max: max, sem: semaphore.NewWeighted(max)}
+}
+
+// weight returns the bytes reserved for a frame of n bytes, frames larger than the budget reserve the whole budget.
+func (f *inFlight) weight(n int) int64 {
+	if int64(n) > f.max {
+		return f.max
+	}
+	return int64(n)
+}
+
+// Acquire reserves the bytes of a frame of n bytes, blocking until the budget allows them or the context is done.
+func (f *inFlight) Acquire(ctx context.Context, n int) error {
+	if f == nil {
+		return nil
+	}
+	if err := f.sem.Acquire(ctx, f.weight(n)); err != nil {
+		return err
+	}
+	f.bytes.Add(int64(n))
+	return nil
+}
+
+// Release releases the bytes of a frame of n bytes, once written to the peer or dropped.
+func (f *inFlight) Release(n int) {
+	if f == nil {
+		return
+	}
+	f.bytes.Add(-int64(n))
+	f.sem.Release(f.weight(n))
+}
+
+// Bytes returns the number of bytes in flight.
+func (f *inFlight) Bytes() int64 {
+	if f == nil {
+		return 0
+	}
+	return f.bytes.Load()
+}
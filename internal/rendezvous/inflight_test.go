@@ -0,0 +1,168 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInFlight(t *testing.T) {
+	f := newInFlight(100)
+	ctx := context.Background()
+	require.NoError(t, f.Acquire(ctx, 60))
+	// frames larger than the budget reserve the whole budget.
+	blocked, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, f.Acquire(blocked, 500), context.DeadlineExceeded)
+	assert.Equal(t, int64(60), f.Bytes())
+	f.Release(60)
+	require.NoError(t, f.Acquire(ctx, 500))
+	f.Release(500)
+	assert.Zero(t, f.Bytes())
+
+	var unbounded *inFlight
+	assert.NoError(t, unbounded.Acquire(ctx, 500))
+	unbounded.Release(500)
+}
+
+func TestMaxInFlightBytes(t *testing.T) {
+	const (
+		budget    = 256 * 1024
+		transfers = 16
+		chunks    = 32
+		chunkSize = 64 * 1024
+	)
+	s := NewServer(0, "", semver.Version{}, WithMaxInFlightBytes(budget))
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	errs := make(chan error, 2*transfers)
+	for i := 0; i < transfers; i++ {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		// the receiver may connect before the mailbox of the sender is allocated.
+		var rtc conn.Transfer
+		require.Eventually(t, func() bool {
+			rrc, err := receiver.ConnectRendezvous(addr)
+			if err != nil {
+				return false
+			}
+			rtc, err = receiver.SecureConnection(ctx, rrc, pass)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+
+		go func() {
+			chunk := bytes.Repeat([]byte{'x'}, chunkSize)
+			for j := 0; j < chunks; j++ {
+				if err := stc.WriteRaw(ctx, chunk); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+		go func() {
+			var received int
+			for received < chunks*chunkSize {
+				b, err := rtc.ReadRaw(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				received += len(b)
+			}
+			errs <- nil
+		}()
+	}
+
+	// the bytes in flight are sampled until every transfer completed.
+	var peak int64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if n := s.inFlight.Bytes(); n > peak {
+				peak = n
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	for i := 0; i < 2*transfers; i++ {
+		require.NoError(t, <-errs)
+	}
+	close(done)
+	<-sampled
+	assert.Positive(t, peak)
+	assert.LessOrEqual(t, peak, int64(budget))
+	// the budget is released once the frames are written.
+	assert.Eventually(t, func() bool { return s.inFlight.Bytes() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestInFlightAborted(t *testing.T) {
+	const chunkSize = 64 * 1024
+	// the budget is never exhausted, such that the forwarders hold frames while the relays are blocked.
+	s := NewServer(0, "", semver.Version{}, WithMaxInFlightBytes(1<<30))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, _ := sender.SecureConnection(ctx, rc, pass)
+		senderC <- tc
+	}()
+	var rtc conn.Transfer
+	require.Eventually(t, func() bool {
+		rrc, err := receiver.ConnectRendezvous(addr)
+		if err != nil {
+			return false
+		}
+		rtc, err = receiver.SecureConnection(ctx, rrc, pass)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	stc := <-senderC
+	require.NotNil(t, stc.Conn)
+
+	// the receiver does not read, such that the frames of the sender pile up in the relay until it aborts, once
+	// the socket buffers of the receiver are full. The sender writes until the relay tears down its connection.
+	chunk := bytes.Repeat([]byte{'x'}, chunkSize)
+	writing := make(chan struct{})
+	go func() {
+		defer close(writing)
+		for stc.WriteRaw(ctx, chunk) == nil {
+		}
+	}()
+	require.Eventually(t, func() bool { return s.inFlight.Bytes() > 0 }, 10*time.Second, time.Millisecond)
+	require.NoError(t, rtc.Conn.Close(conn.CLOSE_CANCELED, "aborted"))
+	<-writing
+	assert.Eventually(t, func() bool { return s.inFlight.Bytes() == 0 }, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,29 @@
+// limits.go specifies the limits of relayed transfers, bounding the bytes relayed through each mailbox and the
+// number of transfers the server relays concurrently, such that a public server is not exhausted by a few peers.
+package rendezvous
+
+// acquireRelay counts a receiver towards the concurrent relays of the server. Returns false if the server relays
+// its maximum of transfers already, the receiver is not counted then.
+func (s *Server) acquireRelay() bool {
+	if s.maxRelays <= 0 {
+		return true
+	}
+	if s.activeRelays.Add(1) > int64(s.maxRelays) {
+		s.activeRelays.Add(-1)
+		return false
+	}
+	return true
+}
+
+// releaseRelay releases a receiver counted towards the concurrent relays of the server.
+func (s *Server) releaseRelay() {
+	if s.maxRelays > 0 {
+		s.activeRelays.Add(-1)
+	}
+}
+
+// exceedsRelayLimit returns whether relaying n more bytes through the mailbox exceeds the bytes the server relays
+// per transfer, in both directions.
+func (s *Server) exceedsRelayLimit(mailbox *Mailbox, n int) bool {
+	return s.maxRelayBytes > 0 && mailbox.toSender.Load()+mailbox.toReceiver.Load()+int64(n) > s.maxRelayBytes
+}
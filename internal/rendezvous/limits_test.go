@@ -0,0 +1,102 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestMaxRelayBytes(t *testing.T) {
+	const limit = 1 << 20
+	s := NewServer(0, "", semver.Version{}, WithMaxRelayBytes(limit))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	t.Run("relays transfers within the limit", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("portal"), 1000)
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addr})
+		require.NoError(t, err)
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: addr}))
+		require.NoError(t, <-errC)
+		assert.Equal(t, payload, received.Bytes())
+	})
+
+	t.Run("cuts off transfers exceeding the limit", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+
+		go func() {
+			chunk := make([]byte, 64<<10)
+			for stc.WriteRaw(ctx, chunk) == nil {
+			}
+		}()
+		var received int
+		for {
+			b, err := rtc.ReadRaw(ctx)
+			if err != nil {
+				var closeErr websocket.CloseError
+				require.True(t, errors.As(err, &closeErr), err)
+				assert.Equal(t, rendezvous.RELAY_LIMIT_EXCEEDED, closeErr.Reason)
+				break
+			}
+			received += len(b)
+		}
+		assert.LessOrEqual(t, received, limit)
+	})
+}
+
+func TestMaxRelays(t *testing.T) {
+	s := NewServer(0, "", semver.Version{}, WithMaxRelays(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	connect := func() (conn.Transfer, error) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		go sender.SecureConnection(ctx, rc, pass) //nolint:errcheck
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		return receiver.SecureConnection(ctx, rrc, pass)
+	}
+	relayed, err := connect()
+	require.NoError(t, err)
+	_, err = connect()
+	assert.ErrorIs(t, err, receiver.ErrTooManyRelays)
+
+	relayed.Conn.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+	require.Eventually(t, func() bool { return s.activeRelays.Load() == 0 }, 5*time.Second, 10*time.Millisecond)
+	_, err = connect()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,135 @@
+// locator.go specifies the location of mailboxes across a cluster of federated rendezvous servers, e.g. several
+// servers behind a load balancer. Each server records the hashed passwords of the mailboxes it holds in a locator
+// shared by the cluster, such that receivers presenting a password unknown to their server are relayed straight
+// to the peer holding the mailbox, rather than to each peer in turn.
+package rendezvous
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Locator records the server holding the mailbox of each password, shared by the servers of a cluster.
+type Locator interface {
+	// Register records this server holding the mailbox of the hashed password.
+	Register(p string) error
+	// Deregister removes the record of the hashed password, if held by this server.
+	Deregister(p string) error
+	// Locate returns the address of the server holding the mailbox of the hashed password, empty if unknown.
+	Locate(p string) (string, error)
+}
+
+// ----------------------------------------------------- Directory -----------------------------------------------------
+
+// DirLocator is a Locator recording the server holding each mailbox as a file in a directory shared by the
+// servers of the cluster, e.g. over a network file system. A password claimed on several servers is located on
+// the server that claimed it last.
+type DirLocator struct {
+	dir  string
+	addr string
+}
+
+// NewDirLocator constructs a Locator recorded in the provided directory, creating it if needed. Mailboxes held
+// by this server are located at addr, the address the peers of this server are configured with, e.g.
+// wss://relay-1.example.com.
+func NewDirLocator(dir, addr string) (*DirLocator, error) {
+	if addr == "" {
+		return nil, errors.New("locator requires the address of the server")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating locator directory: %w", err)
+	}
+	return &DirLocator{dir: dir, addr: addr}, nil
+}
+
+// Register records this server holding the mailbox of the hashed password, replacing the record of another
+// server.
+func (l *DirLocator) Register(p string) error {
+	path, err := l.path(p)
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, such that peers never locate a partially written address.
+	tmp, err := os.CreateTemp(l.dir, ".locating-*")
+	if err != nil {
+		return fmt.Errorf("registering mailbox: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.WriteString(l.addr); err != nil {
+		tmp.Close() //nolint:errcheck
+		return fmt.Errorf("registering mailbox: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("registering mailbox: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("registering mailbox: %w", err)
+	}
+	return nil
+}
+
+// Deregister removes the record of the hashed password, unless another server registered it since.
+func (l *DirLocator) Deregister(p string) error {
+	addr, err := l.Locate(p)
+	if err != nil || addr != l.addr {
+		return err
+	}
+	path, _ := l.path(p)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("deregistering mailbox: %w", err)
+	}
+	return nil
+}
+
+// Locate returns the address of the server holding the mailbox of the hashed password, empty if unknown.
+func (l *DirLocator) Locate(p string) (string, error) {
+	path, err := l.path(p)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("locating mailbox: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// path returns the file of the hashed password, rejecting passwords that are not hex encoded such that receivers
+// cannot address files outside of the directory.
+func (l *DirLocator) path(p string) (string, error) {
+	if _, err := hex.DecodeString(p); err != nil || p == "" {
+		return "", fmt.Errorf("invalid hashed password %q", p)
+	}
+	return filepath.Join(l.dir, p), nil
+}
+
+// ------------------------------------------------------- Server ------------------------------------------------------
+
+// locatedPeers returns the peers a receiver presenting the hashed password is relayed to, only the peer located as
+// holding its mailbox if any. Otherwise returns the first peers as configured, up to the fanout of the server.
+func (s *Server) locatedPeers(p string) []Peer {
+	if s.locator != nil {
+		if addr, err := s.locator.Locate(p); err == nil && addr != "" {
+			for _, peer := range s.peers {
+				if peer.Addr == addr {
+					return []Peer{peer}
+				}
+			}
+		}
+	}
+	if s.peerFanout <= 0 {
+		return nil
+	}
+	if len(s.peers) > s.peerFanout {
+		return s.peers[:s.peerFanout]
+	}
+	return s.peers
+}
@@ -0,0 +1,119 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirLocator(t *testing.T) {
+	dir := t.TempDir()
+	first, err := rendezvous.NewDirLocator(dir, "first:8080")
+	require.NoError(t, err)
+	second, err := rendezvous.NewDirLocator(dir, "second:8080")
+	require.NoError(t, err)
+	p := password.Hashed("1-foo-bar-baz")
+
+	addr, err := second.Locate(p)
+	require.NoError(t, err)
+	assert.Empty(t, addr)
+
+	require.NoError(t, first.Register(p))
+	addr, err = second.Locate(p)
+	require.NoError(t, err)
+	assert.Equal(t, "first:8080", addr)
+
+	require.NoError(t, second.Deregister(p))
+	addr, err = first.Locate(p)
+	require.NoError(t, err)
+	assert.Equal(t, "first:8080", addr, "only the server holding the mailbox should deregister it")
+
+	require.NoError(t, first.Deregister(p))
+	addr, err = second.Locate(p)
+	require.NoError(t, err)
+	assert.Empty(t, addr)
+
+	for _, invalid := range []string{"", "../escape", "not-hex"} {
+		_, err := first.Locate(invalid)
+		assert.Error(t, err, invalid)
+	}
+	_, err = rendezvous.NewDirLocator(dir, "")
+	assert.Error(t, err)
+}
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// three servers peered with each other over TLS, sharing ids and the locator of mailboxes.
+	const token = "cluster-token"
+	cert, pool := selfSignedCert(t)
+	tlsConfig := &tls.Config{RootCAs: pool}
+	dir := t.TempDir()
+	listeners := make([]net.Listener, 3)
+	addrs := make([]string, 3)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i], addrs[i] = l, fmt.Sprintf("wss://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	}
+	for i, l := range listeners {
+		ids, err := rendezvous.NewDirIDs(dir+"/ids", 0)
+		require.NoError(t, err)
+		locator, err := rendezvous.NewDirLocator(dir+"/mailboxes", addrs[i])
+		require.NoError(t, err)
+		opts := []rendezvous.Option{rendezvous.WithListener(l), rendezvous.WithIDStore(ids), rendezvous.WithLocator(locator),
+			rendezvous.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), rendezvous.WithPeerTLS(tlsConfig)}
+		for j, addr := range addrs {
+			if j != i {
+				opts = append(opts, rendezvous.WithPeers(rendezvous.Peer{Addr: addr, Token: token}))
+			}
+		}
+		go rendezvous.NewServer(0, "", semver.Version{}, opts...).Run(ctx) //nolint:errcheck
+	}
+	locator, err := rendezvous.NewDirLocator(dir+"/mailboxes", "observer")
+	require.NoError(t, err)
+
+	t.Run("ids are unique across the cluster", func(t *testing.T) {
+		_, first, err := sender.ConnectRendezvous(ctx, addrs[0], conn.WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		_, second, err := sender.ConnectRendezvous(ctx, addrs[1], conn.WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		firstID, _, _ := strings.Cut(first, "-")
+		secondID, _, _ := strings.Cut(second, "-")
+		assert.NotEqual(t, firstID, secondID)
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("clustered "), 64*1024)
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addrs[2], TLSConfig: tlsConfig})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			addr, _ := locator.Locate(password.Hashed(pass))
+			return addr == addrs[2]
+		}, 5*time.Second, 10*time.Millisecond)
+
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: addrs[0], TLSConfig: tlsConfig}))
+		require.NoError(t, <-errC)
+		assert.Equal(t, payload, received.Bytes())
+		require.Eventually(t, func() bool {
+			addr, _ := locator.Locate(password.Hashed(pass))
+			return addr == ""
+		}, 5*time.Second, 10*time.Millisecond, "the mailbox should be deregistered once deallocated")
+	})
+}
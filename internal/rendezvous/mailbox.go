@@ -2,16 +2,194 @@
 package rendezvous
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"nhooyr.io/websocket"
+)
+
+// MailboxState is the state of the connection between the sender and receiver of a mailbox.
+type MailboxState int32
+
+const (
+	MailboxWaiting   MailboxState = iota // waiting for a receiver to connect
+	MailboxHandshake                     // sender and receiver are performing the key exchange
+	MailboxRelaying                      // encrypted messages are relayed between sender and receiver
 )
 
+// MarshalText marshals the state as its name.
+func (s MailboxState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s MailboxState) String() string {
+	switch s {
+	case MailboxWaiting:
+		return "waiting"
+	case MailboxHandshake:
+		return "handshake"
+	case MailboxRelaying:
+		return "relaying"
+	default:
+		return ""
+	}
+}
+
 // Mailbox is a data structure that links together a sender and a receiver client.
 type Mailbox struct {
-	hasReceiver bool
+	id            int
+	clock         Clock
+	created       time.Time     // wall clock time the mailbox was created, as reported
+	born          time.Duration // monotonic time the mailbox was created
+	state         atomic.Int32
+	toSender      atomic.Int64 // relayed bytes sent to the sender
+	toReceiver    atomic.Int64 // relayed bytes sent to the receiver
+	hasReceiver   atomic.Bool
+	receiver      atomic.Value  // identity of the receiver as a string, unset until a receiver connects
+	dropped       chan struct{} // signals that the receiver disconnected during the key exchange
+	kdfIterations int           // key derivation iterations chosen by the sender, set before the salt is sent
+	token         string        // session token of the sender, presented to resume a lost connection
+	expireAfter   time.Duration // time after its creation the code of the mailbox expires, zero if it does not expire early
+	resume        chan resumedSender
+	active        atomic.Int64  // monotonic time in nanoseconds of the last relayed payload, set once relaying
+	evicted       chan struct{} // closed once the mailbox is evicted
+	evictReason   string        // close reason of the connections of the evicted mailbox, set before evicted is closed
+	evictOnce     sync.Once
+	overQuota     chan struct{} // closed once the sender exceeds its transfer quota
+	overQuotaOnce sync.Once
+	overLimit     chan struct{} // closed once the transfer exceeds the bytes relayed per transfer
+	overLimitOnce sync.Once
+	closeSender   func(reason string) // closes the sender connection of the mailbox, e.g. once it is reaped as stale
+
+	senderClose   closeStatus // close frame received from the sender
+	receiverClose closeStatus // close frame received from the receiver
+
+	Receiver chan conn.Frame // messages to Receiver
+	Sender   chan conn.Frame // messages to Sender
+}
+
+// newMailbox allocates a mailbox for the sender bound to the provided id, tracking its age and idleness on clock.
+func newMailbox(id int, clock Clock) *Mailbox {
+	return &Mailbox{
+		id:        id,
+		clock:     clock,
+		created:   clock.Now(),
+		born:      clock.Monotonic(),
+		Sender:    make(chan conn.Frame),
+		Receiver:  make(chan conn.Frame),
+		dropped:   make(chan struct{}, 1),
+		resume:    make(chan resumedSender),
+		evicted:   make(chan struct{}),
+		overQuota: make(chan struct{}),
+		overLimit: make(chan struct{}),
+	}
+}
+
+// resumedSender is a connection of a sender resuming its lost connection to the mailbox.
+// The connection is released by closing done once the mailbox is done relaying over it.
+type resumedSender struct {
+	conn conn.Conn
+	done chan struct{}
+}
+
+// newSessionToken generates a random session token.
+func newSessionToken() (string, error) {
+	b := make([]byte, SESSION_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// State returns the current state of the mailbox.
+func (m *Mailbox) State() MailboxState {
+	return MailboxState(m.state.Load())
+}
+
+func (m *Mailbox) setState(state MailboxState) {
+	if state == MailboxRelaying {
+		m.active.Store(int64(m.clock.Monotonic()))
+	}
+	m.state.Store(int32(state))
+}
+
+// Age returns the time since the mailbox was created.
+func (m *Mailbox) Age() time.Duration {
+	return m.clock.Monotonic() - m.born
+}
+
+// receiverWait returns the time the mailbox waits for a receiver to connect. Codes expiring early expire the time
+// they were requested to after the creation of the mailbox, such that the clock of the sender does not matter.
+// Otherwise each wait is bounded by RECEIVER_CONNECT_TIMEOUT, also as the key exchange restarts.
+func (m *Mailbox) receiverWait() time.Duration {
+	if m.expireAfter > 0 {
+		return m.expireAfter - m.Age()
+	}
+	return RECEIVER_CONNECT_TIMEOUT
+}
+
+// Idle returns the time since a payload was last relayed through the mailbox, zero if the mailbox is not relaying.
+func (m *Mailbox) Idle() time.Duration {
+	if m.State() != MailboxRelaying {
+		return 0
+	}
+	return m.clock.Monotonic() - time.Duration(m.active.Load())
+}
+
+// evict signals the connections of the relaying mailbox to close with the provided reason. Returns false if the
+// mailbox was already evicted.
+func (m *Mailbox) evict(reason string) bool {
+	evicted := false
+	m.evictOnce.Do(func() {
+		m.evictReason = reason
+		close(m.evicted)
+		evicted = true
+	})
+	return evicted
+}
 
-	Receiver chan []byte // messages to Receiver
-	Sender   chan []byte // messages to Sender
+// exceedQuota signals the connections of the mailbox to close as the sender exceeded its transfer quota.
+func (m *Mailbox) exceedQuota() {
+	m.overQuotaOnce.Do(func() { close(m.overQuota) })
+}
+
+// exceedRelayLimit signals the connections of the mailbox to close as the transfer exceeded the bytes relayed per
+// transfer. Returns false if the connections were signaled already.
+func (m *Mailbox) exceedRelayLimit() bool {
+	exceeded := false
+	m.overLimitOnce.Do(func() {
+		close(m.overLimit)
+		exceeded = true
+	})
+	return exceeded
+}
+
+// closeStatus is the close frame received from a client, relayed to its peer.
+type closeStatus struct {
+	mu     sync.Mutex
+	code   websocket.StatusCode
+	reason string
+	set    bool
+}
+
+// Set records the received close frame.
+func (c *closeStatus) Set(code websocket.StatusCode, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.code, c.reason, c.set = code, reason, true
+}
+
+// Get returns the received close frame, and whether a close frame was received.
+func (c *closeStatus) Get() (websocket.StatusCode, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.code, c.reason, c.set
 }
 
 type Mailboxes struct{ *sync.Map }
@@ -21,13 +199,25 @@ func (mailboxes *Mailboxes) StoreMailbox(p string, m *Mailbox) {
 	mailboxes.Store(p, m)
 }
 
-// GetMailbox returns the desired mailbox.
+// ClaimMailbox allocates a mailbox, unless a mailbox is allocated for the password. Returns whether the
+// mailbox was allocated.
+func (mailboxes *Mailboxes) ClaimMailbox(p string, m *Mailbox) bool {
+	// further mailboxes of the password may outlive its first mailbox.
+	if _, err := mailboxes.GetMailbox(p); err == nil {
+		return false
+	}
+	_, loaded := mailboxes.LoadOrStore(p, m)
+	return !loaded
+}
+
+// GetMailbox returns the first allocated mailbox of the password.
 func (mailboxes *Mailboxes) GetMailbox(p string) (*Mailbox, error) {
-	mailbox, ok := mailboxes.Load(p)
-	if !ok {
-		return nil, fmt.Errorf("no mailbox with password '%s'", p)
+	for n := 0; n < rendezvous.MAX_RECEIVERS; n++ {
+		if mailbox, ok := mailboxes.Load(mailboxKey(p, n)); ok {
+			return mailbox.(*Mailbox), nil
+		}
 	}
-	return mailbox.(*Mailbox), nil
+	return nil, fmt.Errorf("no mailbox with password '%s'", p)
 }
 
 // DeleteMailbox deallocates a mailbox.
@@ -0,0 +1,188 @@
+// metrics.go specifies the Prometheus metrics of the server, served on a listener of their own with WithMetrics
+// such that operators can scrape the health of the server without exposing it on the public listener.
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DEFAULT_METRICS_ADDR is the address the metrics are served on by default.
+const DEFAULT_METRICS_ADDR = ":9090"
+
+// METRICS_CONTENT_TYPE is the content type of the Prometheus text exposition format.
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// transferDurationBuckets are the upper bounds in seconds of the buckets of the transfer duration histogram.
+var transferDurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600}
+
+// metrics counts the events of the server exposed as Prometheus metrics, the gauges are read from snapshots of
+// the server when scraped. All methods are no-ops on nil metrics, such that metrics are free when disabled.
+type metrics struct {
+	addr string
+
+	connections       atomic.Int64 // open sender and receiver connections
+	relayed           atomic.Int64 // bytes relayed to peers
+	handshakeFailures atomic.Int64
+
+	mu        sync.Mutex
+	transfers map[string]int64 // ended transfers by outcome
+	durations []int64          // relayed transfers by duration bucket, the last bucket is unbounded
+	sum       float64          // sum of the durations of relayed transfers in seconds
+}
+
+func newMetrics(addr string) *metrics {
+	return &metrics{
+		addr:      addr,
+		transfers: make(map[string]int64),
+		durations: make([]int64, len(transferDurationBuckets)+1),
+	}
+}
+
+// Connected counts an open connection, returning a function counting it closed.
+func (m *metrics) Connected() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.connections.Add(1)
+	return func() { m.connections.Add(-1) }
+}
+
+// Relayed counts n bytes relayed to a peer.
+func (m *metrics) Relayed(n int) {
+	if m == nil {
+		return
+	}
+	m.relayed.Add(int64(n))
+}
+
+// Ended counts the outcome of a transfer, counting transfers ended during the key exchange as handshake failures.
+func (m *metrics) Ended(outcome string, state MailboxState) {
+	if m == nil {
+		return
+	}
+	if state == MailboxHandshake {
+		m.handshakeFailures.Add(1)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transfers[outcome]++
+}
+
+// Relaying observes the duration of a relayed transfer.
+func (m *metrics) Relaying(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := d.Seconds()
+	m.durations[sort.SearchFloat64s(transferDurationBuckets, seconds)]++
+	m.sum += seconds
+}
+
+// serveMetrics serves the metrics on the metrics address until the provided context is done.
+func (s *Server) serveMetrics(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.metrics.addr)
+	if err != nil {
+		return fmt.Errorf("serving metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics())
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: DEFAULT_READ_HEADER_TIMEOUT,
+		ErrorLog:          s.httpServer.ErrorLog,
+	}
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("serving metrics", zap.Error(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctxShutdown) //nolint:errcheck
+	}()
+	s.mu.Lock()
+	s.metricsListener = l
+	s.mu.Unlock()
+	return nil
+}
+
+// MetricsAddr returns the address the metrics are served on, or nil if metrics are disabled or the server is
+// not yet serving.
+func (s *Server) MetricsAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metricsListener == nil {
+		return nil
+	}
+	return s.metricsListener.Addr()
+}
+
+func (s *Server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		s.writeMetrics(w)
+	}
+}
+
+// writeMetrics writes the metrics of the server to w in the Prometheus text exposition format.
+func (s *Server) writeMetrics(w io.Writer) {
+	snap := s.Snapshot()
+	m := s.metrics
+
+	writeHeader(w, "portal_mailboxes", "gauge", "Allocated mailboxes by state.")
+	for _, state := range []MailboxState{MailboxWaiting, MailboxHandshake, MailboxRelaying} {
+		fmt.Fprintf(w, "portal_mailboxes{state=%q} %d\n", state, snap.Count(state))
+	}
+	writeHeader(w, "portal_ids", "gauge", "Allocated mailbox ids.")
+	fmt.Fprintf(w, "portal_ids %d\n", snap.IDs)
+	writeHeader(w, "portal_connections", "gauge", "Open sender and receiver websocket connections.")
+	fmt.Fprintf(w, "portal_connections %d\n", m.connections.Load())
+	writeHeader(w, "portal_in_flight_bytes", "gauge", "Relayed bytes held in memory, only tracked if bounded.")
+	fmt.Fprintf(w, "portal_in_flight_bytes %d\n", snap.InFlightBytes)
+	writeHeader(w, "portal_relayed_bytes_total", "counter", "Bytes relayed between senders and receivers.")
+	fmt.Fprintf(w, "portal_relayed_bytes_total %d\n", m.relayed.Load())
+	writeHeader(w, "portal_handshake_failures_total", "counter", "Transfers failed during the key exchange.")
+	fmt.Fprintf(w, "portal_handshake_failures_total %d\n", m.handshakeFailures.Load())
+	writeHeader(w, "portal_transfer_success_rate", "gauge", "Ratio of completed transfers to the transfers that completed, timed out or failed within the success rate window.")
+	fmt.Fprintf(w, "portal_transfer_success_rate %s\n", strconv.FormatFloat(snap.Transfers.SuccessRate, 'g', -1, 64))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeHeader(w, "portal_transfers_total", "counter", "Ended transfers by outcome.")
+	for _, outcome := range []string{OUTCOME_COMPLETED, OUTCOME_CANCELED, OUTCOME_FAILED, OUTCOME_RECEIVER_TIMEOUT, OUTCOME_SENDER_LOST} {
+		fmt.Fprintf(w, "portal_transfers_total{outcome=%q} %d\n", outcome, m.transfers[outcome])
+	}
+	writeHeader(w, "portal_transfer_duration_seconds", "histogram", "Durations of relayed transfers.")
+	var count int64
+	for i, n := range m.durations {
+		count += n
+		le := "+Inf"
+		if i < len(transferDurationBuckets) {
+			le = strconv.FormatFloat(transferDurationBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "portal_transfer_duration_seconds_bucket{le=%q} %d\n", le, count)
+	}
+	fmt.Fprintf(w, "portal_transfer_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "portal_transfer_duration_seconds_count %d\n", count)
+}
+
+// writeHeader writes the help and type of a metric.
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
@@ -0,0 +1,126 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(0, "", semver.Version{}, WithMetrics("127.0.0.1:0"))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+	require.NotNil(t, s.MetricsAddr())
+
+	scrape := func() string {
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", s.MetricsAddr()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, METRICS_CONTENT_TYPE, resp.Header.Get("Content-Type"))
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("not served on the relay", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("completed transfer", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("portal"), 1000)
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addr})
+		require.NoError(t, err)
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: addr}))
+		require.NoError(t, <-errC)
+
+		require.Eventually(t, func() bool {
+			return strings.Contains(scrape(), `portal_transfers_total{outcome="completed"} 1`)
+		}, 5*time.Second, 10*time.Millisecond)
+		metrics := scrape()
+		assert.Contains(t, metrics, "# TYPE portal_relayed_bytes_total counter\n")
+		assert.NotContains(t, metrics, "portal_relayed_bytes_total 0\n")
+		assert.Contains(t, metrics, `portal_transfer_duration_seconds_bucket{le="+Inf"} 1`)
+		assert.Contains(t, metrics, "portal_transfer_duration_seconds_count 1\n")
+		assert.Contains(t, metrics, "portal_handshake_failures_total 0\n")
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		_, _, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		// snapshots are reused for SNAPSHOT_MAX_AGE.
+		require.Eventually(t, func() bool {
+			metrics := scrape()
+			return strings.Contains(metrics, `portal_mailboxes{state="waiting"} 1`) &&
+				strings.Contains(metrics, "portal_ids 1\n") &&
+				strings.Contains(metrics, "portal_connections 1\n")
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("success rate", func(t *testing.T) {
+		assert.Contains(t, scrape(), "# TYPE portal_transfer_success_rate gauge\nportal_transfer_success_rate 1\n")
+	})
+
+	t.Run("handshake failure", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		go receiver.SecureConnection(ctx, rrc, pass) //nolint:errcheck
+		// the sender disconnects during the key exchange.
+		_, err = rc.ReadMsg(ctx)
+		require.NoError(t, err)
+		rc.Conn.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+		require.Eventually(t, func() bool {
+			return strings.Contains(scrape(), "portal_handshake_failures_total 1\n")
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestMetricsSuccessRate(t *testing.T) {
+	s := NewServer(0, "", semver.Version{}, WithMetrics("127.0.0.1:0"), WithOutcomeWindow(time.Minute))
+	now := time.Unix(1_700_000_000, 0)
+	s.outcomes.now = func() time.Time { return now }
+	metrics := func() string {
+		// drop the cached snapshot such that it is computed at the fake time.
+		s.lastSnapshot = Snapshot{}
+		var b strings.Builder
+		s.writeMetrics(&b)
+		return b.String()
+	}
+	assert.Contains(t, metrics(), "portal_transfer_success_rate 1\n", "no ended transfer should count as success")
+
+	for i := 0; i < 3; i++ {
+		s.outcomes.Record(OUTCOME_COMPLETED)
+	}
+	s.outcomes.Record(OUTCOME_CANCELED)
+	now = now.Add(30 * time.Second)
+	s.outcomes.Record(OUTCOME_FAILED)
+	assert.Contains(t, metrics(), "portal_transfer_success_rate 0.75\n")
+
+	// the completed transfers leave the window before the failed one.
+	now = now.Add(45 * time.Second)
+	assert.Contains(t, metrics(), "portal_transfer_success_rate 0\n")
+	assert.Contains(t, metrics(), `portal_transfers_total{outcome="completed"} 0`, "counters are not recorded through the window")
+}
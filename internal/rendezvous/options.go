@@ -0,0 +1,494 @@
+// options.go specifies the options that can be used to configure the rendezvous server.
+package rendezvous
+
+import (
+	"crypto/tls"
+	"html/template"
+	"net"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Option configures the rendezvous server.
+type Option func(*Server)
+
+// WithLogSampling samples repetitive log entries of the server after the initial entries
+// each second, logging only every thereafter-th entry. Errors are never sampled.
+func WithLogSampling(initial, thereafter int) Option {
+	return func(s *Server) {
+		s.logOpts = append(s.logOpts, logger.WithSampling(initial, thereafter))
+	}
+}
+
+// WithLogLevel only logs the entries of the server at or above the provided level, e.g. for relays embedded in
+// clients.
+func WithLogLevel(level zapcore.Level) Option {
+	return func(s *Server) {
+		s.logOpts = append(s.logOpts, logger.WithLevel(level))
+	}
+}
+
+// WithMaxMailboxesPerIdentity limits the number of concurrent mailboxes a single client identity
+// can hold. Senders registering beyond the limit are rejected with 429 Too Many Requests.
+func WithMaxMailboxesPerIdentity(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.identities = NewIdentities(n)
+		}
+	}
+}
+
+// WithMaxInFlightBytes bounds the sum of the relayed bytes held in memory across all mailboxes, i.e. read from
+// a connection and not yet written to its peer. Reading from connections is paused while the budget is exhausted.
+func WithMaxInFlightBytes(n int64) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.inFlight = newInFlight(n)
+		}
+	}
+}
+
+// WithLoadShedding rejects new senders with 503 Service Unavailable while the load of the server exceeds any
+// of the thresholds, telling them to retry after the provided time. Defaults to DEFAULT_SHED_RETRY_AFTER if zero.
+// Existing transfers are unaffected, and senders are accepted again once the load drops below the thresholds.
+func WithLoadShedding(thresholds LoadThresholds, retryAfter time.Duration) Option {
+	return func(s *Server) {
+		if thresholds == (LoadThresholds{}) {
+			return
+		}
+		if retryAfter <= 0 {
+			retryAfter = DEFAULT_SHED_RETRY_AFTER
+		}
+		s.shedder = newShedder(thresholds, retryAfter)
+	}
+}
+
+// WithIPAnonymization anonymizes the IP addresses of clients before they are logged, see logger.AnonymizeIP.
+// Per-client limits are still enforced on the full IP addresses.
+func WithIPAnonymization(enabled bool) Option {
+	return func(s *Server) {
+		s.anonymizeIPs = enabled
+	}
+}
+
+// WithResumptions stores the resumption state of receivers in the provided store, such that they resume
+// interrupted transfers from another machine over /resumption. Disabled by default.
+func WithResumptions(rs *Resumptions) Option {
+	return func(s *Server) {
+		s.resumptions = rs
+	}
+}
+
+// WithOutcomeWindow sets the sliding window the success rate of transfers is computed over.
+// Defaults to DEFAULT_OUTCOME_WINDOW.
+func WithOutcomeWindow(window time.Duration) Option {
+	return func(s *Server) {
+		if window > 0 {
+			s.outcomes = newOutcomeWindow(window)
+		}
+	}
+}
+
+// WithMinKDFIterations advertises the minimum number of key derivation iterations accepted by the server,
+// rejecting the handshake of senders choosing weaker parameters.
+func WithMinKDFIterations(n int) Option {
+	return func(s *Server) {
+		s.minKDFIterations = n
+	}
+}
+
+// WithMOTD sets a message of the day served to clients on the /info endpoint, e.g. to announce maintenance windows.
+// The message is stripped of control characters and truncated to rendezvous.MAX_MOTD_LENGTH characters.
+func WithMOTD(message string) Option {
+	return func(s *Server) {
+		s.motd = protocol.SanitizeMOTD(message)
+	}
+}
+
+// WithPeers federates the server with the provided peers, which must be served over TLS, see Peer. Receivers
+// presenting a password unknown to the server are relayed to the peer holding the mailbox, and peers presenting
+// their token may relay their receivers to the mailboxes of this server.
+func WithPeers(peers ...Peer) Option {
+	return func(s *Server) {
+		s.peers = append(s.peers, peers...)
+	}
+}
+
+// WithPeerTLS secures the connections to the peers of the server with the provided config, e.g. to verify peers
+// against custom root CAs, or to present a client certificate to peers requiring one. Peers are verified against
+// the system roots by default.
+func WithPeerTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.peerTLSConfig = config
+	}
+}
+
+// WithPeerFanout relays receivers presenting a password unknown to the server to at most n peers, in the order
+// they were configured, rather than DEFAULT_PEER_FANOUT. Receivers whose mailbox is located on a peer are only
+// relayed to that peer, see WithLocator, and no other receiver is relayed if n is not positive.
+func WithPeerFanout(n int) Option {
+	return func(s *Server) {
+		s.peerFanout = n
+	}
+}
+
+// WithSyslog tees the audit events of the server, transfer summaries and authorization decisions, to the syslog
+// server at addr over the provided network, e.g. "udp" or "tcp", as RFC 5424 messages. Events are buffered while
+// the syslog server is unreachable and dropped with a warning once the buffer is full, never blocking transfers.
+func WithSyslog(network, addr string) Option {
+	return func(s *Server) {
+		s.audit = newSyslogSink(network, addr)
+	}
+}
+
+// WithTransferLog appends a summary of each transfer to the provided transfer log. Operators presenting the auth
+// token of the server query the most recent transfers over /api/transfers. Disabled by default.
+func WithTransferLog(l *TransferLog) Option {
+	return func(s *Server) {
+		s.transferLog = l
+	}
+}
+
+// WithDrops holds drops, payloads sealed by senders, in the provided spool until receivers collect them over
+// /drops. Disabled by default.
+func WithDrops(d *Drops) Option {
+	return func(s *Server) {
+		s.drops = d
+	}
+}
+
+// WithAuthTokens authorizes operators presenting any of the provided tokens, in addition to the auth token the
+// server is created with. The tokens are reloaded from their source over /admin/reload-tokens.
+func WithAuthTokens(t *Tokens) Option {
+	return func(s *Server) {
+		s.tokens = t
+	}
+}
+
+// WithAuthFile writes the hashed auth tokens of the server to the provided path, rather than AUTH_FILE_NAME in the
+// working directory.
+func WithAuthFile(path string) Option {
+	return func(s *Server) {
+		s.authFile = path
+	}
+}
+
+// WithoutAuthFile never writes the hashed auth tokens of the server to disk, e.g. where policy disallows writing
+// secrets to the filesystem. Auth tokens are still required from operators.
+func WithoutAuthFile() Option {
+	return func(s *Server) {
+		s.noAuthFile = true
+	}
+}
+
+// WithAuthFileRetry attempts writing the auth file up to the provided number of times, waiting the provided
+// backoff before the first retry and doubling it after every retry. Defaults to DEFAULT_AUTH_FILE_ATTEMPTS
+// and DEFAULT_AUTH_FILE_BACKOFF.
+func WithAuthFileRetry(attempts int, backoff time.Duration) Option {
+	return func(s *Server) {
+		s.authFileAttempts = attempts
+		s.authFileBackoff = backoff
+	}
+}
+
+// WithCloseTimeout sets the time waited for a peer to acknowledge the close frame when tearing down
+// a connection, before it is forcefully closed. Defaults to DEFAULT_CLOSE_TIMEOUT.
+func WithCloseTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.closeTimeout = d
+	}
+}
+
+// WithHandshakeTimeout bounds the time a peer has to complete the handshake, i.e. registering a sender or
+// completing the key exchange once the receiver connected, after which its connection is closed and the mailbox
+// torn down. Waiting for a receiver to connect is bound by RECEIVER_CONNECT_TIMEOUT instead. Unbounded by default.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.handshakeTimeout = d
+	}
+}
+
+// WithIdleTimeout closes relays through which no payload was relayed for the provided timeout, warning both
+// peers the provided interval before, such that they can report the pending disconnect. Unbounded by default,
+// non-positive timeouts are ignored.
+func WithIdleTimeout(timeout, warning time.Duration) Option {
+	return func(s *Server) {
+		if timeout <= 0 {
+			return
+		}
+		s.idleTimeout = timeout
+		s.idleWarning = warning
+	}
+}
+
+// WithDrainTimeout drains the in-flight relays for up to the provided timeout on shutdown rather than cutting them
+// off, warning relaying peers that the server is closing. Senders waiting for a receiver are closed right away, and
+// relays in flight after the timeout are closed. Relays are not drained by default, unless the server handed off its
+// listener, in which case they are awaited for up to the timeout of WithHandoffTimeout.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.drainTimeout = timeout
+	}
+}
+
+// WithHandoffTimeout awaits the in-flight relays for up to the provided timeout once the server handed off its
+// listener, closing the relays still in flight afterwards. Defaults to DEFAULT_HANDOFF_DRAIN_TIMEOUT, ignored if
+// relays are drained with WithDrainTimeout.
+func WithHandoffTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.handoffTimeout = timeout
+		}
+	}
+}
+
+// WithConnDeadline closes relayed connections on which no frame was read or written for the provided deadline, and
+// whose peer did not answer a ping within it, detecting dead peers and peers that stopped reading at the connection
+// level rather than waiting for the idle timeout. The deadline is refreshed by any activity on the connection,
+// peers pausing without reading from their connection for longer than the deadline are closed too. Disabled by default.
+func WithConnDeadline(d time.Duration) Option {
+	return func(s *Server) {
+		s.connDeadline = d
+	}
+}
+
+// WithMailboxTTL reaps mailboxes that were not relaying within the provided ttl of their creation, closing the
+// connection of their sender. Relaying mailboxes are bounded by WithIdleTimeout instead.
+// Defaults to DEFAULT_MAILBOX_TTL, a ttl of 0 never reaps mailboxes.
+func WithMailboxTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.mailboxTTL = ttl
+	}
+}
+
+// WithClock tracks expiry and idleness on the provided clock, e.g. a fake clock in tests. Defaults to the clock of
+// the system.
+func WithClock(clock Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// WithTransferQuota bounds the bytes relayed in both directions for the senders of each client identity within
+// windows of the provided duration, aligned to the zero time, e.g. 24h windows reset at midnight UTC. Senders of
+// an identity exceeding its quota are rejected until the window resets, and transfers exceeding it are cut off.
+func WithTransferQuota(bytes int64, window time.Duration) Option {
+	return func(s *Server) {
+		if bytes > 0 && window > 0 {
+			s.quota = &quota{limit: bytes, window: window}
+		}
+	}
+}
+
+// WithRegistrationRate limits the rate at which a single client identity registers mailboxes to perMinute
+// mailboxes per minute, rejecting further senders with 429 Too Many Requests until the rate allows.
+func WithRegistrationRate(perMinute int) Option {
+	return func(s *Server) {
+		s.registrations = newRegistrations(perMinute)
+	}
+}
+
+// WithMaxConnectionsPerIdentity limits the number of concurrent websocket connections a single client identity
+// holds, as sender, receiver or resuming sender, rejecting further connections with 429 Too Many Requests.
+func WithMaxConnectionsPerIdentity(n int) Option {
+	return func(s *Server) {
+		s.connections = NewIdentities(n)
+	}
+}
+
+// WithAuthBan bans clients presenting invalid tokens to the admin and federation endpoints for base, doubling the
+// ban with every further failure up to max. Banned clients are rejected with 429 Too Many Requests, even when
+// presenting a valid token. Disabled by default.
+func WithAuthBan(base, max time.Duration) Option {
+	return func(s *Server) {
+		s.authBans = newAuthBans(base, max)
+	}
+}
+
+// WithMaxRelayBytes bounds the bytes relayed in both directions through the mailbox of each transfer. Transfers
+// exceeding the limit are cut off, closing both connections with rendezvous.RELAY_LIMIT_EXCEEDED.
+func WithMaxRelayBytes(n int64) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxRelayBytes = n
+		}
+	}
+}
+
+// WithMaxRelays bounds the number of transfers relayed concurrently, counted from the moment their receiver
+// connects. Receivers connecting beyond the limit are closed with rendezvous.TOO_MANY_RELAYS.
+func WithMaxRelays(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxRelays = n
+		}
+	}
+}
+
+// WithQuotaStore stores the usage of the transfer quota in the provided store, e.g. to persist it across restarts.
+// Defaults to an in-memory store.
+func WithQuotaStore(store QuotaStore) Option {
+	return func(s *Server) {
+		s.quotaStore = store
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request line and headers of requests, guarding against clients
+// exhausting memory with oversized headers. Defaults to DEFAULT_MAX_HEADER_BYTES.
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Server) {
+		s.httpServer.MaxHeaderBytes = n
+	}
+}
+
+// WithReadHeaderTimeout bounds the time a client has to send the headers of a request, separately from the
+// time it has to send the body, guarding against clients holding connections by sending headers slowly.
+// Defaults to DEFAULT_READ_HEADER_TIMEOUT.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.httpServer.ReadHeaderTimeout = d
+	}
+}
+
+// WithIDStore stores the ids bound to senders in the provided store, e.g. to persist them across restarts
+// or share them between servers. Defaults to an in-memory store.
+func WithIDStore(store IDStore) Option {
+	return func(s *Server) {
+		s.ids = store
+	}
+}
+
+// WithLocator records the mailboxes held by the server in the provided locator shared by a cluster of federated
+// servers, such that receivers are relayed straight to the peer holding their mailbox. Disabled by default.
+func WithLocator(l Locator) Option {
+	return func(s *Server) {
+		s.locator = l
+	}
+}
+
+// WithTracing traces the lifecycle of each mailbox using the provided tracer provider, continuing the
+// trace context propagated by senders. Tracing is disabled by default.
+func WithTracing(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracer = tp.Tracer(TRACER_NAME)
+	}
+}
+
+// WithTLS serves the server over TLS using the certificates of the provided config, negotiating HTTP/2
+// with clients that support it. Websocket connections are always served over HTTP/1.1.
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithACME serves the server over TLS with certificates provisioned by the ACME manager, e.g. constructed with
+// NewACMEManager, replacing the TLS config of WithTLS. HTTP-01 challenges are answered on challengeAddr, defaults to
+// DEFAULT_ACME_CHALLENGE_ADDR, which redirects other plain HTTP requests to HTTPS.
+func WithACME(manager *autocert.Manager, challengeAddr string) Option {
+	return func(s *Server) {
+		if challengeAddr == "" {
+			challengeAddr = DEFAULT_ACME_CHALLENGE_ADDR
+		}
+		s.acme, s.acmeAddr = manager, challengeAddr
+	}
+}
+
+// WithTLSMinVersion pins the minimum TLS version accepted from clients, tls.VersionTLS12 or tls.VersionTLS13 to
+// only accept TLS 1.3. Defaults to DEFAULT_TLS_MIN_VERSION. Requires WithTLS, the version is validated when the
+// server is run.
+func WithTLSMinVersion(version uint16) Option {
+	return func(s *Server) {
+		s.tlsMinVersion = version
+	}
+}
+
+// WithTLSCipherSuites pins the TLS 1.2 cipher suites accepted from clients to the named suites of crypto/tls,
+// e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Requires WithTLS, the suites are validated when the server is run,
+// unknown and insecure suites are refused.
+func WithTLSCipherSuites(names ...string) Option {
+	return func(s *Server) {
+		s.tlsCipherSuites = names
+	}
+}
+
+// WithClientCA requires clients to present a certificate signed by one of the PEM encoded certificate authorities
+// in caFile (mutual TLS). Clients are identified by the common name of their verified certificate, in logs and
+// when limiting mailboxes. Requires WithTLS, the certificate authorities are loaded when the server is run.
+func WithClientCA(caFile string) Option {
+	return func(s *Server) {
+		s.clientCAFile = caFile
+	}
+}
+
+// WithH2C serves HTTP/2 without TLS (h2c) alongside HTTP/1.1, for deployments terminating TLS at a proxy
+// that speaks HTTP/2 to the server. Ignored when serving over TLS.
+func WithH2C() Option {
+	return func(s *Server) {
+		s.h2c = true
+	}
+}
+
+// WithNATProbe answers NAT probes on the provided UDP ports, advertised on the /info endpoint, such that clients
+// can classify their NAT behavior. At least two ports are needed to tell cone from symmetric NATs. Disabled by default.
+func WithNATProbe(ports ...int) Option {
+	return func(s *Server) {
+		s.natProbePorts = ports
+	}
+}
+
+// WithSubprotocols sets the WebSocket subprotocols negotiated with clients, in order of preference, advertised on
+// the /info endpoint. Defaults to rendezvous.SUBPROTOCOLS, clients requesting no subprotocol are always accepted.
+func WithSubprotocols(subprotocols ...string) Option {
+	return func(s *Server) {
+		s.subprotocols = subprotocols
+	}
+}
+
+// WithTemplateLoader overrides how the server loads the templates of its web pages.
+func WithTemplateLoader(loader func() (map[string]*template.Template, error)) Option {
+	return func(s *Server) {
+		s.templateLoader = loader
+	}
+}
+
+// WithListenAddr listens on the provided host:port address, replacing the port of NewServer, e.g. [::]:8080 to only
+// listen on IPv6 or 127.0.0.1:8080 to only accept local clients. Servers listen on every IPv4 and IPv6 address by
+// default.
+func WithListenAddr(addr string) Option {
+	return func(s *Server) {
+		s.httpServer.Addr = addr
+	}
+}
+
+// WithListener serves the server on the provided listener, e.g. one inherited from a previous server process.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.listener = l
+	}
+}
+
+// WithHandoffCommand sets the command started when handing off the listener, defaults to re-executing
+// the current binary with the same arguments.
+func WithHandoffCommand(name string, args ...string) Option {
+	return func(s *Server) {
+		s.handoffCmd = name
+		s.handoffArgs = args
+	}
+}
+
+// WithMetrics serves Prometheus metrics of the server on /metrics of a listener on addr of its own, defaults to
+// DEFAULT_METRICS_ADDR.
+func WithMetrics(addr string) Option {
+	return func(s *Server) {
+		if addr == "" {
+			addr = DEFAULT_METRICS_ADDR
+		}
+		s.metrics = newMetrics(addr)
+	}
+}
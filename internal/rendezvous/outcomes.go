@@ -0,0 +1,107 @@
+// outcomes.go specifies the rolling success rate of the transfers of the server, counting the outcomes of the
+// mailboxes over a sliding window such that operators notice when a deploy or network change degraded reliability.
+package rendezvous
+
+import (
+	"sync"
+	"time"
+)
+
+// DEFAULT_OUTCOME_WINDOW is the sliding window the success rate of transfers is computed over.
+const DEFAULT_OUTCOME_WINDOW = time.Hour
+
+// OUTCOME_WINDOW_BUCKETS is the number of buckets the window is split into, outcomes leave the window one bucket
+// at a time.
+const OUTCOME_WINDOW_BUCKETS = 60
+
+// TransferStats are the outcomes of the transfers that ended within the window.
+type TransferStats struct {
+	WindowSeconds int64 `json:"window_seconds"`
+	Completed     int   `json:"completed"`
+	Canceled      int   `json:"canceled"`
+	TimedOut      int   `json:"timed_out"`
+	Failed        int   `json:"failed"`
+	// SuccessRate is the ratio of completed transfers to the transfers that completed, timed out or failed.
+	// Transfers canceled by a peer are left out, and the rate is 1 if no transfer ended within the window.
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// add counts the outcome of a transfer.
+func (t *TransferStats) add(outcome string) {
+	switch outcome {
+	case OUTCOME_COMPLETED:
+		t.Completed++
+	case OUTCOME_CANCELED:
+		t.Canceled++
+	case OUTCOME_RECEIVER_TIMEOUT, OUTCOME_SENDER_LOST:
+		t.TimedOut++
+	default:
+		t.Failed++
+	}
+}
+
+// outcomeBucket counts the outcomes of the transfers that ended within a slice of the window.
+type outcomeBucket struct {
+	start time.Time
+	stats TransferStats
+}
+
+// outcomeWindow counts the outcomes of transfers over a sliding window. Safe for concurrent use.
+type outcomeWindow struct {
+	window time.Duration
+	width  time.Duration // duration of a bucket
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets []outcomeBucket
+}
+
+// newOutcomeWindow constructs an outcome window over the provided duration.
+func newOutcomeWindow(window time.Duration) *outcomeWindow {
+	width := window / OUTCOME_WINDOW_BUCKETS
+	if width <= 0 {
+		width = 1
+	}
+	return &outcomeWindow{
+		window:  window,
+		width:   width,
+		now:     time.Now,
+		buckets: make([]outcomeBucket, OUTCOME_WINDOW_BUCKETS),
+	}
+}
+
+// Record records the outcome of a transfer that ended now.
+func (w *outcomeWindow) Record(outcome string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	start := now.Truncate(w.width)
+	b := &w.buckets[int(now.UnixNano()/int64(w.width))%len(w.buckets)]
+	// buckets are reused once the window moved past them.
+	if !b.start.Equal(start) {
+		*b = outcomeBucket{start: start}
+	}
+	b.stats.add(outcome)
+}
+
+// Stats returns the outcomes of the transfers that ended within the window.
+func (w *outcomeWindow) Stats() TransferStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := TransferStats{WindowSeconds: int64(w.window / time.Second)}
+	oldest := w.now().Add(-w.window)
+	for _, b := range w.buckets {
+		if b.start.IsZero() || !b.start.After(oldest) {
+			continue
+		}
+		stats.Completed += b.stats.Completed
+		stats.Canceled += b.stats.Canceled
+		stats.TimedOut += b.stats.TimedOut
+		stats.Failed += b.stats.Failed
+	}
+	stats.SuccessRate = 1
+	if ended := stats.Completed + stats.TimedOut + stats.Failed; ended > 0 {
+		stats.SuccessRate = float64(stats.Completed) / float64(ended)
+	}
+	return stats
+}
@@ -0,0 +1,112 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutcomeWindow(t *testing.T) {
+	w := newOutcomeWindow(time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	w.now = func() time.Time { return now }
+	assert.Equal(t, TransferStats{WindowSeconds: 60, SuccessRate: 1}, w.Stats())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Record(OUTCOME_COMPLETED)
+		}()
+	}
+	wg.Wait()
+	w.Record(OUTCOME_CANCELED)
+	w.Record(OUTCOME_RECEIVER_TIMEOUT)
+	now = now.Add(30 * time.Second)
+	w.Record(OUTCOME_SENDER_LOST)
+	w.Record(OUTCOME_FAILED)
+	assert.Equal(t, TransferStats{WindowSeconds: 60, Completed: 8, Canceled: 1, TimedOut: 2, Failed: 1, SuccessRate: 8.0 / 11}, w.Stats())
+
+	// outcomes leave the window once it moved past them.
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, TransferStats{WindowSeconds: 60, TimedOut: 1, Failed: 1, SuccessRate: 0}, w.Stats())
+	now = now.Add(time.Hour)
+	w.Record(OUTCOME_COMPLETED)
+	assert.Equal(t, TransferStats{WindowSeconds: 60, Completed: 1, SuccessRate: 1}, w.Stats())
+}
+
+func TestSuccessRate(t *testing.T) {
+	s := NewServer(0, "", semver.Version{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	const completed = 3
+	for i := 0; i < completed; i++ {
+		payload := []byte(fmt.Sprintf("transfer %d", i))
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addr})
+		require.NoError(t, err)
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: addr}))
+		require.NoError(t, <-errC)
+	}
+
+	// the code of the sender expires before a receiver connects.
+	rc, pass, err := sender.ConnectRendezvousExpiring(ctx, addr, 50*time.Millisecond)
+	require.NoError(t, err)
+	_, err = sender.SecureConnection(ctx, rc, pass)
+	require.Error(t, err)
+
+	// the sender fails the transfer once relaying.
+	rc, pass, err = sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, _ := sender.SecureConnection(ctx, rc, pass)
+		senderC <- tc
+	}()
+	var rtc conn.Transfer
+	require.Eventually(t, func() bool {
+		rrc, err := receiver.ConnectRendezvous(addr)
+		if err != nil {
+			return false
+		}
+		rtc, err = receiver.SecureConnection(ctx, rrc, pass)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	stc := <-senderC
+	require.NotNil(t, stc.Conn)
+	require.NoError(t, stc.Conn.Close(conn.CLOSE_FAILED, "transfer failed"))
+	_, err = rtc.ReadRaw(ctx)
+	require.Error(t, err)
+
+	want := TransferStats{WindowSeconds: int64(DEFAULT_OUTCOME_WINDOW / time.Second), Completed: completed, TimedOut: 1, Failed: 1, SuccessRate: 0.6}
+	var stats Stats
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://%s/stats", addr))
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		stats = Stats{}
+		return json.NewDecoder(resp.Body).Decode(&stats) == nil && stats.Transfers == want
+	}, 5*time.Second, 50*time.Millisecond, "reported %+v", stats.Transfers)
+	assert.Equal(t, 0, stats.Mailboxes[MailboxRelaying.String()])
+}
@@ -0,0 +1,264 @@
+// quota.go specifies the transfer quota of the server, bounding the bytes each client identity may relay within a
+// window, such that a single tenant of a shared server cannot exhaust its bandwidth.
+package rendezvous
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// QUOTA_CHARGE_INTERVAL is the interval at which the bytes relayed by a transfer are charged to the quota of its
+// identity, such that transfers exceeding the quota are cut off within the interval.
+const QUOTA_CHARGE_INTERVAL = time.Second
+
+// QuotaStore persists the bytes relayed by each identity within the current window of the transfer quota.
+// Windows are identified by their start, usage recorded for an earlier window is reset.
+type QuotaStore interface {
+	// Usage returns the bytes relayed by the identity within the window starting at start.
+	Usage(identity string, start time.Time) (int64, error)
+	// Add adds n bytes relayed by the identity within the window starting at start, returning its usage.
+	Add(identity string, start time.Time, n int64) (int64, error)
+}
+
+// quotaUsage is the usage of an identity within a window.
+type quotaUsage struct {
+	Start time.Time `json:"start"`
+	Bytes int64     `json:"bytes"`
+}
+
+// of returns the bytes of the usage within the window starting at start.
+func (u quotaUsage) of(start time.Time) int64 {
+	if !u.Start.Equal(start) {
+		return 0
+	}
+	return u.Bytes
+}
+
+// ------------------------------------------------------ Memory -------------------------------------------------------
+
+// Quotas is a threadsafe map of the usage of each identity, the default in-memory QuotaStore.
+type Quotas struct {
+	mu    sync.Mutex
+	usage map[string]quotaUsage
+}
+
+// NewQuotas constructs an in-memory QuotaStore.
+func NewQuotas() *Quotas {
+	return &Quotas{usage: make(map[string]quotaUsage)}
+}
+
+// Usage returns the bytes relayed by the identity within the window starting at start.
+func (q *Quotas) Usage(identity string, start time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage[identity].of(start), nil
+}
+
+// Add adds n bytes relayed by the identity within the window starting at start, returning its usage.
+func (q *Quotas) Add(identity string, start time.Time, n int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := quotaUsage{Start: start, Bytes: q.usage[identity].of(start) + n}
+	q.usage[identity] = usage
+	return usage.Bytes, nil
+}
+
+// ----------------------------------------------------- Directory -----------------------------------------------------
+
+// DirQuotas is a QuotaStore persisting the usage of each identity as a file in a directory, such that quotas are
+// enforced across restarts. Files are replaced atomically, the store is not shared by servers.
+type DirQuotas struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewDirQuotas constructs a QuotaStore persisted in the provided directory, creating it if needed.
+func NewDirQuotas(dir string) (*DirQuotas, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating quota store directory: %w", err)
+	}
+	return &DirQuotas{dir: dir}, nil
+}
+
+// Usage returns the bytes relayed by the identity within the window starting at start.
+func (q *DirQuotas) Usage(identity string, start time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, err := q.read(identity)
+	return usage.of(start), err
+}
+
+// Add adds n bytes relayed by the identity within the window starting at start, returning its usage.
+func (q *DirQuotas) Add(identity string, start time.Time, n int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, err := q.read(identity)
+	if err != nil {
+		return 0, err
+	}
+	usage = quotaUsage{Start: start, Bytes: usage.of(start) + n}
+	b, err := json.Marshal(usage)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(q.dir, ".quota-*")
+	if err != nil {
+		return 0, fmt.Errorf("storing quota usage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("storing quota usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("storing quota usage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path(identity)); err != nil {
+		return 0, fmt.Errorf("storing quota usage: %w", err)
+	}
+	return usage.Bytes, nil
+}
+
+// path returns the file of the identity, named by its digest as identities are not valid filenames.
+func (q *DirQuotas) path(identity string) string {
+	sum := sha256.Sum256([]byte(identity))
+	return filepath.Join(q.dir, hex.EncodeToString(sum[:16]))
+}
+
+// read reads the usage of the identity, identities without a file have no usage.
+func (q *DirQuotas) read(identity string) (quotaUsage, error) {
+	var usage quotaUsage
+	b, err := os.ReadFile(q.path(identity))
+	if errors.Is(err, fs.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, fmt.Errorf("reading quota usage: %w", err)
+	}
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return usage, fmt.Errorf("reading quota usage: %w", err)
+	}
+	return usage, nil
+}
+
+// ------------------------------------------------------ Quota --------------------------------------------------------
+
+// quota enforces the transfer quota of the server. A nil quota is never exceeded.
+type quota struct {
+	limit  int64
+	window time.Duration
+	store  QuotaStore
+}
+
+// windowStart returns the start of the window holding t, windows are aligned to multiples of the window since the
+// zero time, such that daily windows reset at midnight UTC.
+func (q *quota) windowStart(t time.Time) time.Time {
+	return t.UTC().Truncate(q.window)
+}
+
+// exceeded returns whether the identity exhausted its quota, and the time the quota resets.
+func (q *quota) exceeded(identity string) (bool, time.Time, error) {
+	start := q.windowStart(time.Now())
+	usage, err := q.store.Usage(identity, start)
+	return usage >= q.limit, start.Add(q.window), err
+}
+
+// charge charges n bytes relayed by the identity to its quota, returning whether the quota is exceeded.
+func (q *quota) charge(identity string, n int64) (bool, error) {
+	usage, err := q.store.Add(identity, q.windowStart(time.Now()), n)
+	return usage > q.limit, err
+}
+
+// enforceQuota rejects senders whose identity exhausted its transfer quota with 429 Too Many Requests, and a
+// Retry-After header telling when the quota resets.
+func (s *Server) enforceQuota(next http.Handler) http.Handler {
+	if s.quota == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromRequest(r)
+		exceeded, resets, err := s.quota.exceeded(identity)
+		if err != nil {
+			// quotas are not enforced while the store fails, rather than rejecting every sender.
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Error("reading transfer quota", zap.Error(err))
+			}
+		}
+		if exceeded {
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Warn("identity exceeded transfer quota", zap.String("identity", s.loggedIP(identity)), zap.Time("resets", resets))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(resets).Seconds()))))
+			http.Error(w, fmt.Sprintf("%s, resets at %s", rendezvous.QUOTA_EXCEEDED, resets.Format(time.RFC3339)), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// chargeQuota charges the bytes relayed through the mailbox in both directions to the quota of the identity of its
+// sender every QUOTA_CHARGE_INTERVAL, cutting the transfer off once the quota is exceeded. Returns a function
+// stopping the charging, charging the bytes relayed since the last charge.
+func (s *Server) chargeQuota(ctx context.Context, mailbox *Mailbox, identity string, logger *zap.Logger) func() {
+	if s.quota == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.chargeRelayed(ctx, mailbox, identity, logger)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// chargeRelayed charges the bytes relayed through the mailbox until the context is done.
+func (s *Server) chargeRelayed(ctx context.Context, mailbox *Mailbox, identity string, logger *zap.Logger) {
+	var charged int64
+	charge := func() bool {
+		relayed := mailbox.toSender.Load() + mailbox.toReceiver.Load()
+		if relayed == charged {
+			return false
+		}
+		exceeded, err := s.quota.charge(identity, relayed-charged)
+		if err != nil {
+			logger.Error("charging transfer quota", zap.Error(err))
+			return false
+		}
+		charged = relayed
+		return exceeded
+	}
+	ticker := time.NewTicker(QUOTA_CHARGE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			charge()
+			return
+		case <-ticker.C:
+		}
+		if charge() {
+			logger.Warn("identity exceeded transfer quota, cutting off transfer", zap.String("identity", s.loggedIP(identity)))
+			mailbox.exceedQuota()
+		}
+	}
+}
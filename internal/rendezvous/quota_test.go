@@ -0,0 +1,151 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestDirQuotas(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+
+	before, err := NewDirQuotas(dir)
+	require.NoError(t, err)
+	for _, identity := range []string{"127.0.0.1", "CN=tenant/a"} {
+		usage, err := before.Add(identity, start, 100)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), usage)
+	}
+	usage, err := before.Add("127.0.0.1", start, 50)
+	require.NoError(t, err)
+	assert.Equal(t, int64(150), usage)
+
+	t.Run("survives restart", func(t *testing.T) {
+		after, err := NewDirQuotas(dir)
+		require.NoError(t, err)
+		usage, err := after.Usage("127.0.0.1", start)
+		require.NoError(t, err)
+		assert.Equal(t, int64(150), usage)
+		usage, err = after.Usage("CN=tenant/a", start)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), usage)
+	})
+
+	t.Run("resets in the next window", func(t *testing.T) {
+		next := start.Add(24 * time.Hour)
+		usage, err := before.Usage("127.0.0.1", next)
+		require.NoError(t, err)
+		assert.Zero(t, usage)
+		usage, err = before.Add("127.0.0.1", next, 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), usage)
+	})
+}
+
+func TestTransferQuota(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	const (
+		limit  = 1 << 20
+		window = time.Hour
+	)
+	dir := t.TempDir()
+	serve := func(ctx context.Context) string {
+		quotas, err := NewDirQuotas(dir)
+		require.NoError(t, err)
+		s := NewServer(0, "", semver.Version{}, WithTransferQuota(limit, window), WithQuotaStore(quotas))
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		return fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	}
+	requireRejected := func(t *testing.T, addr string) {
+		_, _, err := sender.ConnectRendezvous(context.Background(), addr)
+		require.ErrorIs(t, err, conn.ErrRejected)
+		assert.ErrorContains(t, err, rendezvous.QUOTA_EXCEEDED)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	addr := serve(ctx)
+
+	t.Run("cuts off transfers exceeding the quota", func(t *testing.T) {
+		rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+		require.NoError(t, err)
+		senderC := make(chan conn.Transfer, 1)
+		go func() {
+			tc, _ := sender.SecureConnection(ctx, rc, pass)
+			senderC <- tc
+		}()
+		rrc, err := receiver.ConnectRendezvous(addr)
+		require.NoError(t, err)
+		rtc, err := receiver.SecureConnection(ctx, rrc, pass)
+		require.NoError(t, err)
+		stc := <-senderC
+		require.NotNil(t, stc.Conn)
+
+		go func() {
+			chunk := make([]byte, 64<<10)
+			for stc.WriteRaw(ctx, chunk) == nil {
+			}
+		}()
+		var received int
+		for {
+			b, err := rtc.ReadRaw(ctx)
+			if err != nil {
+				var closeErr websocket.CloseError
+				require.True(t, errors.As(err, &closeErr), err)
+				assert.Equal(t, rendezvous.QUOTA_EXCEEDED, closeErr.Reason)
+				break
+			}
+			received += len(b)
+		}
+		assert.Greater(t, received, limit)
+	})
+
+	t.Run("rejects senders exceeding the quota", func(t *testing.T) {
+		requireRejected(t, addr)
+	})
+
+	t.Run("rejects across restarts", func(t *testing.T) {
+		cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		requireRejected(t, serve(ctx))
+	})
+
+	t.Run("resets at the window boundary", func(t *testing.T) {
+		// the usage is moved to the previous window, as if the window passed.
+		quotas, err := NewDirQuotas(dir)
+		require.NoError(t, err)
+		start := time.Now().UTC().Truncate(window)
+		usage, err := quotas.Usage("127.0.0.1", start)
+		require.NoError(t, err)
+		require.Greater(t, usage, int64(limit))
+		_, err = quotas.Add("127.0.0.1", start.Add(-window), usage)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		rc, _, err := sender.ConnectRendezvous(ctx, serve(ctx))
+		require.NoError(t, err)
+		rc.Conn.Close(websocket.StatusNormalClosure, "") //nolint:errcheck
+	})
+}
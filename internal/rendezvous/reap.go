@@ -0,0 +1,61 @@
+// reap.go specifies the reaping of stale mailboxes, such that mailboxes of senders that were abandoned without
+// their connection ending, e.g. stuck behind a receiver that never completes the key exchange, do not hold their
+// id and their password forever. Idle relays are closed by the idle timeout and connection deadline instead.
+package rendezvous
+
+import (
+	"context"
+	"time"
+
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_MAILBOX_TTL is the time after which mailboxes that do not start relaying are reaped by default.
+const DEFAULT_MAILBOX_TTL = 30 * time.Minute
+
+// stale returns whether the mailbox was not relaying within the ttl of its creation. Relaying mailboxes are never
+// stale, such that relays are only bounded by the idle timeout of the server, unbounded by default.
+func (m *Mailbox) stale(ttl time.Duration) bool {
+	return m.Age() > ttl && m.State() != MailboxRelaying
+}
+
+// reapMailboxes reaps the stale mailboxes every tenth of the mailbox ttl of the server, until the context is done.
+func (s *Server) reapMailboxes(ctx context.Context) {
+	ticker := time.NewTicker(s.mailboxTTL / 10)
+	defer ticker.Stop()
+	var total int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if reaped := s.reapStale(); reaped > 0 {
+			total += reaped
+			s.logger.Info("reaped stale mailboxes", zap.Int("reaped", reaped), zap.Int("total", total))
+		}
+	}
+}
+
+// reapStale closes the sender connections of the mailboxes stale for the mailbox ttl of the server and deallocates
+// them, their ids are freed as their handlers return. Returns the number of reaped mailboxes.
+func (s *Server) reapStale() int {
+	var reaped int
+	s.mailboxes.Range(func(key, v any) bool {
+		m := v.(*Mailbox)
+		if !m.stale(s.mailboxTTL) {
+			return true
+		}
+		if s.mailboxes.CompareAndDelete(key, m) {
+			reaped++
+			s.logger.Warn("reaping stale mailbox",
+				zap.Int("id", m.id), zap.Stringer("state", m.State()), zap.Duration("age", m.Age()))
+			if m.closeSender != nil {
+				go m.closeSender(rendezvous.MAILBOX_EXPIRED)
+			}
+		}
+		return true
+	})
+	return reaped
+}
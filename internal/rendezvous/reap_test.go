@@ -0,0 +1,69 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/password"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestMailboxStale(t *testing.T) {
+	clock := newFakeClock()
+	m := newMailbox(1, clock)
+	clock.Advance(time.Hour)
+	assert.False(t, m.stale(time.Hour))
+	clock.Advance(time.Second)
+	assert.True(t, m.stale(time.Hour), "mailboxes not relaying within the ttl should be stale")
+
+	m.setState(MailboxRelaying)
+	clock.Advance(time.Hour)
+	assert.False(t, m.stale(time.Hour), "idle relays should be left to the idle timeout")
+}
+
+func TestReapStale(t *testing.T) {
+	clock := newFakeClock()
+	s := NewServer(0, "", semver.Version{}, WithClock(clock), WithMailboxTTL(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	// the mailbox is kept by a receiver that never completes the key exchange, as handshakes are unbounded.
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	require.NoError(t, rrc.WriteMsg(ctx, rendezvous.Msg{
+		Type:    rendezvous.ReceiverToRendezvousEstablish,
+		Payload: rendezvous.Payload{Password: password.Hashed(pass)},
+	}))
+	_, err = rc.ReadMsg(ctx, rendezvous.RendezvousToSenderReady)
+	require.NoError(t, err)
+
+	assert.Zero(t, s.reapStale(), "fresh mailboxes should not be reaped")
+	clock.Advance(time.Hour + time.Second)
+	assert.Equal(t, 1, s.reapStale())
+
+	_, err = rc.ReadMsg(ctx)
+	var closeErr websocket.CloseError
+	require.True(t, errors.As(err, &closeErr), err)
+	assert.Equal(t, rendezvous.MAILBOX_EXPIRED, closeErr.Reason)
+	_, err = s.mailboxes.GetMailbox(password.Hashed(pass))
+	assert.Error(t, err, "the mailbox should be deallocated")
+	require.Eventually(t, func() bool {
+		n, err := s.ids.Len()
+		return err == nil && n == 0
+	}, 5*time.Second, 10*time.Millisecond, "the id should be freed")
+}
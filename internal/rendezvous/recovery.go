@@ -0,0 +1,52 @@
+// recovery.go specifies the recovery of panics on the server, such that a bug triggered by one connection only
+// tears down that connection, rather than crashing the server along with every other transfer.
+package rendezvous
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/logger"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// recoverPanics recovers panics of the handlers, logging them with their stack trace and responding with
+// 500 Internal Server Error. Websocket connections are closed by conn.Middleware instead, as no response
+// can be written once the connection is upgraded.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			panicLogger := s.logger
+			if l, err := logger.FromContext(r.Context()); err == nil {
+				panicLogger = l
+			}
+			panicLogger.Error("recovered panic in handler", zap.Any("panic", v), zap.Stack("stack_trace"))
+			if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				http.Error(w, protocol.PANIC_CLOSE_REASON, http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverRelay recovers a panic of a goroutine relaying the connection, logging it with its stack trace and
+// closing the connection, such that only the mailbox of the connection is torn down. Must be deferred.
+func (s *Server) recoverRelay(c conn.Conn, logger *zap.Logger) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	logger.Error("recovered panic in relay", zap.Any("panic", v), zap.Stack("stack_trace"))
+	if err := conn.CloseTimeout(c, conn.CLOSE_FAILED, protocol.PANIC_CLOSE_REASON, s.closeTimeout); err != nil {
+		logger.Warn("closing connection after panic", zap.Error(err))
+	}
+}
@@ -0,0 +1,89 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+// closedConn records the close frame it was closed with.
+type closedConn struct {
+	code   websocket.StatusCode
+	reason string
+	closed chan struct{}
+}
+
+func (c *closedConn) Read(ctx context.Context) ([]byte, error)  { return nil, io.EOF }
+func (c *closedConn) Write(ctx context.Context, b []byte) error { return nil }
+func (c *closedConn) Close(code websocket.StatusCode, reason string) error {
+	c.code, c.reason = code, reason
+	close(c.closed)
+	return nil
+}
+
+func TestRecovery(t *testing.T) {
+	s := NewServer(0, "", semver.Version{})
+	s.router.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) { panic("handler bug") })
+	s.router.Handle("/panic-ws", conn.Middleware(s.closeTimeout)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("websocket handler bug")
+	})))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	t.Run("handler", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/panic", addr))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+
+	t.Run("websocket handler", func(t *testing.T) {
+		ws, err := conn.Dial(ctx, fmt.Sprintf("ws://%s/panic-ws", addr))
+		require.NoError(t, err)
+		_, err = ws.Read(ctx)
+		var closeErr websocket.CloseError
+		require.True(t, errors.As(err, &closeErr), err)
+		assert.Equal(t, conn.CLOSE_FAILED, closeErr.Code)
+		assert.Equal(t, protocol.PANIC_CLOSE_REASON, closeErr.Reason)
+	})
+
+	t.Run("relay", func(t *testing.T) {
+		c := &closedConn{closed: make(chan struct{})}
+		go func() {
+			defer s.recoverRelay(c, zap.NewNop())
+			panic("relay bug")
+		}()
+		select {
+		case <-c.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection of the panicked relay was not closed")
+		}
+		assert.Equal(t, conn.CLOSE_FAILED, c.code)
+		assert.Equal(t, protocol.PANIC_CLOSE_REASON, c.reason)
+	})
+
+	t.Run("server survives", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/ping", addr))
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(b))
+	})
+}
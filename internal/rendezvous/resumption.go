@@ -0,0 +1,219 @@
+// resumption.go specifies the resumption state stored by the server on behalf of receivers, such that a transfer
+// interrupted on one machine can be resumed from another by presenting the issued token.
+package rendezvous
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_RESUMPTION_TTL is the time resumption state is kept for, after which its token is no longer accepted.
+const DEFAULT_RESUMPTION_TTL = 24 * time.Hour
+
+// MAX_RESUMPTIONS is the maximum number of unexpired resumption states the server holds.
+const MAX_RESUMPTIONS = 10_000
+
+// DEFAULT_RESUMPTION_STORE_SIZE is the maximum number of bytes of unexpired resumption state the server holds.
+const DEFAULT_RESUMPTION_STORE_SIZE = 64 << 20
+
+// RESUMPTION_SWEEP_INTERVAL is the interval at which expired resumption state is deleted.
+const RESUMPTION_SWEEP_INTERVAL = time.Minute
+
+// number of random bytes of the tokens issued for resumption state.
+const RESUMPTION_TOKEN_BYTES = 16
+
+var errResumptionsFull = errors.New("too many resumption states")
+
+// resumption is the state stored for a token, opaque to the server.
+type resumption struct {
+	state   []byte
+	expires time.Duration // monotonic time the state expires at
+}
+
+// Resumptions is a threadsafe store of at most MAX_RESUMPTIONS resumption states and maxBytes of state, keyed by
+// token and expiring after a ttl on the monotonic clock, such that steps of the wall clock do not change the time
+// the state is kept for.
+type Resumptions struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int64
+	used     int64 // bytes of the stored state
+	clock    Clock
+	entries  map[string]resumption
+}
+
+// NewResumptions constructs a store keeping up to maxBytes of resumption state for ttl.
+func NewResumptions(ttl time.Duration, maxBytes int64) *Resumptions {
+	return &Resumptions{ttl: ttl, maxBytes: maxBytes, clock: newSystemClock(), entries: make(map[string]resumption)}
+}
+
+// full returns whether the store cannot hold n more bytes of state, the caller holds the lock.
+func (rs *Resumptions) full(n int) bool {
+	return len(rs.entries) >= MAX_RESUMPTIONS || rs.used+int64(n) > rs.maxBytes
+}
+
+// Store stores the state, returning the issued token and the time left until the state expires.
+func (rs *Resumptions) Store(state []byte) (string, time.Duration, error) {
+	b := make([]byte, RESUMPTION_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, err
+	}
+	token := hex.EncodeToString(b)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.full(len(state)) {
+		rs.prune()
+		if rs.full(len(state)) {
+			return "", 0, errResumptionsFull
+		}
+	}
+	rs.entries[token] = resumption{state: state, expires: rs.clock.Monotonic() + rs.ttl}
+	rs.used += int64(len(state))
+	return token, rs.ttl, nil
+}
+
+// Load returns the unexpired state of the token and the time left until it expires, or false if the token is
+// unknown or expired.
+func (rs *Resumptions) Load(token string) ([]byte, time.Duration, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.entries[token]
+	if !ok {
+		return nil, 0, false
+	}
+	now := rs.clock.Monotonic()
+	if now > r.expires {
+		rs.delete(token)
+		return nil, 0, false
+	}
+	return r.state, r.expires - now, true
+}
+
+// Delete deletes the state of the token, once the transfer it resumes completed.
+func (rs *Resumptions) Delete(token string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.delete(token)
+}
+
+// delete deletes the state of the token, the caller holds the lock.
+func (rs *Resumptions) delete(token string) {
+	if r, ok := rs.entries[token]; ok {
+		rs.used -= int64(len(r.state))
+		delete(rs.entries, token)
+	}
+}
+
+// prune deletes the expired state, returning the number of deleted states. The caller holds the lock.
+func (rs *Resumptions) prune() int {
+	var pruned int
+	now := rs.clock.Monotonic()
+	for token, r := range rs.entries {
+		if now > r.expires {
+			rs.delete(token)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// sweep deletes the expired state, returning the number of deleted states.
+func (rs *Resumptions) sweep() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.prune()
+}
+
+// sweepResumptions deletes the expired resumption state every RESUMPTION_SWEEP_INTERVAL, such that the state of
+// tokens never presented again does not linger until the store fills up. Returns once the context is done.
+func (s *Server) sweepResumptions(ctx context.Context) {
+	ticker := time.NewTicker(RESUMPTION_SWEEP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if swept := s.resumptions.sweep(); swept > 0 {
+			s.logger.Info("deleted expired resumption state", zap.Int("deleted", swept))
+		}
+	}
+}
+
+// handleStoreResumption stores the resumption state of the request, responding with the issued token.
+func (s *Server) handleStoreResumption() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		var req rendezvous.Resumption
+		// the state is base64 encoded in the request, hence the margin.
+		body := http.MaxBytesReader(w, r.Body, 2*rendezvous.MAX_RESUMPTION_STATE_BYTES)
+		if err := json.NewDecoder(body).Decode(&req); err != nil || len(req.State) == 0 {
+			http.Error(w, "invalid resumption state", http.StatusBadRequest)
+			return
+		}
+		if len(req.State) > rendezvous.MAX_RESUMPTION_STATE_BYTES {
+			http.Error(w, "resumption state too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		token, expiresIn, err := s.resumptions.Store(req.State)
+		if errors.Is(err, errResumptionsFull) {
+			logger.Warn("rejecting resumption state", zap.Error(err))
+			http.Error(w, "too many resumption states", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			logger.Error("issuing resumption token", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		expires := s.clock.Now().Add(expiresIn)
+		logger.Info("stored resumption state", zap.Time("expires", expires))
+		writeResumption(w, rendezvous.Resumption{Token: token, Expires: expires, ExpiresIn: expiresIn}, logger)
+	}
+}
+
+// handleResumption serves the resumption state of the token, or deletes it.
+func (s *Server) handleResumption() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		token := mux.Vars(r)["token"]
+		if r.Method == http.MethodDelete {
+			s.resumptions.Delete(token)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		state, expiresIn, ok := s.resumptions.Load(token)
+		if !ok {
+			http.Error(w, "unknown or expired resumption token", http.StatusNotFound)
+			return
+		}
+		writeResumption(w, rendezvous.Resumption{State: state, Expires: s.clock.Now().Add(expiresIn), ExpiresIn: expiresIn}, logger)
+	}
+}
+
+// writeResumption writes the resumption as the JSON response.
+func writeResumption(w http.ResponseWriter, resumption rendezvous.Resumption, logger *zap.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resumption); err != nil {
+		logger.Warn("writing resumption", zap.Error(err))
+	}
+}
@@ -0,0 +1,77 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumptions(t *testing.T) {
+	t.Run("store size", func(t *testing.T) {
+		rs := NewResumptions(time.Hour, 10)
+		token, _, err := rs.Store([]byte("123456"))
+		require.NoError(t, err)
+		_, _, err = rs.Store([]byte("12345"))
+		assert.ErrorIs(t, err, errResumptionsFull)
+		// deleted state frees its bytes.
+		rs.Delete(token)
+		_, _, err = rs.Store([]byte("12345"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		clock := newFakeClock()
+		rs := NewResumptions(time.Hour, DEFAULT_RESUMPTION_STORE_SIZE)
+		rs.clock = clock
+		_, _, err := rs.Store([]byte("expiring"))
+		require.NoError(t, err)
+		clock.Advance(30 * time.Minute)
+		kept, _, err := rs.Store([]byte("kept"))
+		require.NoError(t, err)
+		assert.Zero(t, rs.sweep())
+
+		clock.Advance(31 * time.Minute)
+		assert.Equal(t, 1, rs.sweep(), "expired state should be deleted without its token being presented")
+		assert.Len(t, rs.entries, 1)
+		assert.Equal(t, int64(len("kept")), rs.used)
+		_, _, ok := rs.Load(kept)
+		assert.True(t, ok)
+	})
+
+	// serve starts a server with the options, returning its address.
+	serve := func(t *testing.T, opts ...Option) string {
+		s := NewServer(0, "", semver.Version{}, opts...)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		t.Cleanup(cancel)
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		return fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+	}
+	store := func(t *testing.T, addr string) int {
+		body, err := json.Marshal(rendezvous.Resumption{State: []byte("state")})
+		require.NoError(t, err)
+		resp, err := http.Post(fmt.Sprintf("http://%s/resumption", addr), "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, store(t, serve(t)))
+	})
+	t.Run("registration rate", func(t *testing.T) {
+		addr := serve(t, WithResumptions(NewResumptions(time.Hour, DEFAULT_RESUMPTION_STORE_SIZE)), WithRegistrationRate(1))
+		assert.Equal(t, http.StatusOK, store(t, addr))
+		assert.Equal(t, http.StatusTooManyRequests, store(t, addr))
+	})
+}
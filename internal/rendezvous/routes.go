@@ -1,18 +1,57 @@
 package rendezvous
 
 import (
+	"net/http"
+
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/logger"
 )
 
 func (s *Server) routes() {
-	s.router.Use(logger.Middleware(s.logger))
+	s.router.Use(logger.Middleware(s.logger, logger.WithAnonymizedIPs(s.anonymizeIPs)), s.recoverPanics, logClientIdentity)
 	s.router.HandleFunc("/", s.handleLandingPage())
 	s.router.HandleFunc("/ping", s.ping())
-	s.router.HandleFunc("/version", s.handleVersionCheck())
+	// the websocket endpoints are not routed through the compressing middleware.
+	s.router.Handle("/version", gzipResponses(s.handleVersionCheck()))
+	s.router.Handle("/info", gzipResponses(s.handleInfo()))
+	s.router.Handle("/stats", gzipResponses(s.handleStats()))
+	if s.resumptions != nil {
+		// resumption state is stored at the registration rate of senders, as it holds memory of the server.
+		s.router.Handle("/resumption", s.limitRegistrations(s.handleStoreResumption())).Methods(http.MethodPost)
+		s.router.Handle("/resumption/{token}", s.limitRegistrations(s.handleResumption())).Methods(http.MethodGet, http.MethodDelete)
+	}
+	if s.drops != nil {
+		// drops are registered at the registration rate of senders, as they hold resources of the server.
+		s.router.Handle("/drops", s.limitRegistrations(s.handleStoreDrop())).Methods(http.MethodPost)
+		s.router.HandleFunc("/drops/{id}", s.handleDrop()).Methods(http.MethodGet, http.MethodHead, http.MethodDelete)
+	}
+
+	// admin endpoints are only served to operators presenting an auth token of the server.
+	if s.authEnabled() {
+		s.router.Handle("/admin", s.authorizeAdmin(s.handleAdminPage())).Methods(http.MethodGet)
+		s.router.Handle("/api/status", s.authorizeAdmin(s.handleStatus())).Methods(http.MethodGet)
+		s.router.Handle("/api/mailboxes", s.authorizeAdmin(gzipResponses(s.handleMailboxes()))).Methods(http.MethodGet)
+		s.router.Handle("/api/mailboxes/{id}", s.authorizeAdmin(s.handleCloseMailbox())).Methods(http.MethodDelete)
+		s.router.Handle("/admin/evict-idle", s.authorizeAdmin(s.handleEvictIdle())).Methods(http.MethodPost)
+		if s.tokens.source != nil {
+			s.router.Handle("/admin/reload-tokens", s.authorizeAdmin(s.handleReloadTokens())).Methods(http.MethodPost)
+		}
+		if s.transferLog != nil {
+			s.router.Handle("/api/transfers", s.authorizeAdmin(gzipResponses(s.handleTransfers()))).Methods(http.MethodGet)
+		}
+	}
+
+	// load is shed and the connection, registration and mailbox limits are enforced before the connection is
+	// upgraded, to be able to respond with a status code. Only new senders are shed, receivers and resuming senders join existing transfers.
+	s.router.Handle("/establish-sender", s.trackRelays(s.limitConnections(s.limitRegistrations(s.shedLoad(s.enforceQuota(s.limitMailboxes(conn.Middleware(s.closeTimeout, s.subprotocols...)(s.handleEstablishSender()))))))))
+
+	// federated receivers are authenticated before the connection is upgraded, and never relayed on.
+	if len(s.peers) > 0 {
+		s.router.Handle("/federate-receiver", s.trackRelays(s.authorizePeers(conn.Middleware(s.closeTimeout, s.subprotocols...)(s.handleEstablishReceiver(false)))))
+	}
 
 	portal := s.router.PathPrefix("").Subrouter()
-	portal.Use(conn.Middleware())
-	portal.HandleFunc("/establish-sender", s.handleEstablishSender())
-	portal.HandleFunc("/establish-receiver", s.handleEstablishReceiver())
+	portal.Use(s.trackRelays, s.limitConnections, conn.Middleware(s.closeTimeout, s.subprotocols...))
+	portal.HandleFunc("/establish-receiver", s.handleEstablishReceiver(true))
+	portal.HandleFunc("/resume-sender", s.handleResumeSender())
 }
@@ -2,125 +2,455 @@ package rendezvous
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/internal/nat"
 	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
 	"github.com/SpatiumPortae/portal/templates"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Server is contains the necessary data to run the rendezvous server.
 type Server struct {
-	httpServer *http.Server
-	router     *mux.Router
-	mailboxes  *Mailboxes
-	ids        *IDs
-	signal     chan os.Signal
-	logger     *zap.Logger
-	templates  map[string]*template.Template
-	version    *semver.Version
-	authToken  string
+	httpServer    *http.Server
+	router        *mux.Router
+	mailboxes     *Mailboxes
+	expired       sync.Map // hashed passwords of expired mailboxes
+	waiting       waitingReceivers
+	ids           IDStore
+	identities    *Identities
+	connections   *Identities    // nil if the websocket connections per identity are unbounded
+	registrations *registrations // nil if the rate of registrations is unbounded
+	authBans      *authBans      // nil if clients presenting invalid tokens are not banned
+	inFlight      *inFlight      // nil if the bytes in flight are unbounded
+	shedder       *shedder       // nil if load is not shed
+	metrics       *metrics       // nil if metrics are not served
+	admin         *adminStats
+	resumptions   *Resumptions // nil if resumption state is not stored
+	outcomes      *outcomeWindow
+	audit         *syslogSink  // nil if audit events are not sent to syslog
+	transferLog   *TransferLog // nil if transfers are not logged
+	drops         *Drops       // nil if drops are disabled
+	logger        *zap.Logger
+	templates     map[string]*template.Template
+	version       *semver.Version
+	tokens        *Tokens
+	authFile      string
+	logOpts       []logger.Option
+
+	minKDFIterations int // minimum key derivation iterations accepted from senders
+	motd             string
+	closeTimeout     time.Duration
+	drainTimeout     time.Duration // zero if in-flight relays are not drained on shutdown, unless handed off
+	handoffTimeout   time.Duration // time in-flight relays are awaited after a handoff, unless drained
+	drainDeadline    time.Time     // time in-flight relays are closed, set before closing is closed
+	closing          chan struct{} // closed once the server starts draining its in-flight relays
+	handshakeTimeout time.Duration // zero if the handshake is not bound
+	idleTimeout      time.Duration // zero if idle relays are not closed
+	idleWarning      time.Duration // time before the idle timeout peers are warned, zero to not warn
+	connDeadline     time.Duration // zero if relayed connections are not bound by a deadline
+	mailboxTTL       time.Duration // zero if stale mailboxes are not reaped
+	tracer           trace.Tracer  // nil if tracing is disabled
+	clock            Clock
+	quota            *quota            // nil if transfers are not bound by a quota
+	quotaStore       QuotaStore        // store of the transfer quota, in-memory if nil
+	tlsConfig        *tls.Config       // nil if served without TLS
+	acme             *autocert.Manager // nil if certificates are not provisioned via ACME
+	acmeAddr         string            // address HTTP-01 challenges are answered on
+	tlsMinVersion    uint16            // minimum TLS version accepted, DEFAULT_TLS_MIN_VERSION if zero
+	tlsCipherSuites  []string          // names of the TLS 1.2 cipher suites accepted, the defaults of crypto/tls if nil
+	clientCAFile     string            // client certificates are not required if empty
+	h2c              bool              // serve HTTP/2 without TLS
+	anonymizeIPs     bool              // log the IP addresses of clients anonymized
+	natProbePorts    []int             // UDP ports answering NAT probes, nil if disabled
+	peers            []Peer            // federated rendezvous servers, nil if not federated
+	peerTLSConfig    *tls.Config       // nil if peers are verified against the system roots
+	peerFanout       int               // peers a receiver with an unknown password is relayed to at most
+	locator          Locator           // nil if mailboxes are not located across a cluster
+	maxRelayBytes    int64             // zero if the bytes relayed per transfer are unbounded
+	maxRelays        int               // zero if the concurrent relays are unbounded
+	activeRelays     atomic.Int64      // receivers counted towards maxRelays
+	subprotocols     []string          // websocket subprotocols negotiated with clients, in order of preference
+	authFileAttempts int               // attempts at writing the auth file before giving up
+	authFileBackoff  time.Duration     // time waited before the first retry of writing the auth file
+	noAuthFile       bool              // never write the auth token to disk
+
+	mu              sync.Mutex
+	listener        net.Listener
+	acmeListener    net.Listener
+	metricsListener net.Listener
+	natProbers      []net.PacketConn
+
+	snapshotMu   sync.Mutex
+	lastSnapshot Snapshot
+
+	relays      sync.WaitGroup // in-flight sender and receiver connections
+	handedOff   atomic.Bool
+	handoffCmd  string
+	handoffArgs []string
+
+	templateLoader func() (map[string]*template.Template, error)
 }
 
 // NewServer constructs a new Server struct and setups the routes.
-func NewServer(port int, authToken string, version semver.Version) *Server {
+func NewServer(port int, authToken string, version semver.Version, opts ...Option) *Server {
 	router := &mux.Router{}
-	lgr := logger.New()
-	stdLoggerWrapper, err := zap.NewStdLogAt(lgr, zap.ErrorLevel)
-	if err != nil {
-		panic(err)
+	s := &Server{
+		httpServer: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: DEFAULT_READ_HEADER_TIMEOUT,
+			WriteTimeout:      30 * time.Second,
+			MaxHeaderBytes:    DEFAULT_MAX_HEADER_BYTES,
+			Handler:           router,
+		},
+		router:         router,
+		mailboxes:      &Mailboxes{&sync.Map{}},
+		ids:            &IDs{&sync.Map{}},
+		version:        &version,
+		authFile:       AUTH_FILE_NAME,
+		templateLoader: templates.NewTemplates,
+		closeTimeout:   DEFAULT_CLOSE_TIMEOUT,
+		handoffTimeout: DEFAULT_HANDOFF_DRAIN_TIMEOUT,
+		clock:          newSystemClock(),
+		mailboxTTL:     DEFAULT_MAILBOX_TTL,
+		outcomes:       newOutcomeWindow(DEFAULT_OUTCOME_WINDOW),
+		admin:          newAdminStats(),
+		closing:        make(chan struct{}),
+		subprotocols:   rendezvous.SUBPROTOCOLS,
+		peerFanout:     DEFAULT_PEER_FANOUT,
+
+		authFileAttempts: DEFAULT_AUTH_FILE_ATTEMPTS,
+		authFileBackoff:  DEFAULT_AUTH_FILE_BACKOFF,
 	}
-	tmpls, err := templates.NewTemplates()
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.resumptions != nil {
+		s.resumptions.clock = s.clock
+	}
+	if s.tokens == nil {
+		s.tokens = &Tokens{}
+	}
+	if authToken != "" {
+		s.tokens.fixed = append(s.tokens.fixed, NewToken(DEFAULT_TOKEN_LABEL, authToken))
+	}
+	if s.acme != nil {
+		s.tlsConfig = s.acme.TLSConfig()
+	}
+	if s.quota != nil {
+		s.quota.store = s.quotaStore
+		if s.quota.store == nil {
+			s.quota.store = NewQuotas()
+		}
+	}
+
+	s.logger = logger.New(s.logOpts...)
+	stdLoggerWrapper, err := zap.NewStdLogAt(s.logger, zap.ErrorLevel)
 	if err != nil {
 		panic(err)
 	}
-	s := &Server{
-		httpServer: &http.Server{
-			Addr:         fmt.Sprintf(":%d", port),
-			ReadTimeout:  30 * time.Second,
-			WriteTimeout: 30 * time.Second,
-			Handler:      router,
-			ErrorLog:     stdLoggerWrapper,
-		},
-		router:    router,
-		mailboxes: &Mailboxes{&sync.Map{}},
-		ids:       &IDs{&sync.Map{}},
-		logger:    lgr,
-		templates: tmpls,
-		version:   &version,
-		authToken: authToken,
+	s.httpServer.ErrorLog = stdLoggerWrapper
+
+	// websocket upgrades are HTTP/1.1 only, clients fall back to HTTP/1.1 for the relay endpoints.
+	if s.tlsConfig != nil {
+		s.httpServer.TLSConfig = s.tlsConfig.Clone()
+		if err := http2.ConfigureServer(s.httpServer, nil); err != nil {
+			s.logger.Warn("configuring HTTP/2, serving HTTP/1.1 only", zap.Error(err))
+		}
+	} else if s.h2c {
+		s.httpServer.Handler = h2c.NewHandler(router, &http2.Server{})
+	}
+
+	// the web UI is non-essential to transfers, fallback pages are served if templates fail to load.
+	if s.templates, err = s.templateLoader(); err != nil {
+		s.logger.Warn("loading templates, serving fallback pages", zap.Error(err))
 	}
 	s.routes()
 	return s
 }
 
-// Start runs the rendezvous server.
+// Start runs the rendezvous server until an interrupt or termination signal is received.
 func (s *Server) Start() {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
+	// hand off the listener to a new server process on SIGHUP, draining in-flight relays.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 	go func() {
-		<-s.signal
-		s.logger.Info("portal rendezvous server is shutting down")
-		cancel()
+		select {
+		case <-ctx.Done():
+		case <-hup:
+			if _, err := s.Handoff(); err != nil {
+				s.logger.Error("handing off listener", zap.Error(err))
+				return
+			}
+			cancel()
+		}
 	}()
 
-	if err := serve(s, ctx); err != nil {
+	if err := s.Run(ctx); err != nil {
 		s.logger.Error("serving portal rendezvous server", zap.Error(err), zap.Stack("stack_trace"))
 	}
 }
 
-// serve is a helper function providing graceful shutdown of the server.
-func serve(s *Server, ctx context.Context) (err error) {
-	go func() {
-		if err = s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			s.logger.Fatal("serving portal", zap.Error(err), zap.Stack("stack_trace"))
+// Run serves the rendezvous server until the provided context is cancelled, after which
+// the server is gracefully shutdown. Returns an error if the server could not be served or
+// shutdown cleanly.
+func (s *Server) Run(ctx context.Context) error {
+	logMsg := "serving rendezvous server"
+	if s.authEnabled() {
+		if s.noAuthFile {
+			s.logger.Info("auth enabled, not saving auth file")
+		} else {
+			go s.saveAuthFile(ctx)
 		}
-	}()
+		logMsg = "serving rendezvous server with auth token"
+	}
+
+	if s.audit != nil {
+		go s.audit.run(ctx, s.logger)
+	}
+
+	if s.mailboxTTL > 0 {
+		go s.reapMailboxes(ctx)
+	}
+
+	if s.resumptions != nil {
+		go s.sweepResumptions(ctx)
+	}
+
+	if s.httpServer.TLSConfig != nil {
+		if err := s.pinTLS(); err != nil {
+			return err
+		}
+	} else if s.tlsMinVersion != 0 || s.tlsCipherSuites != nil {
+		return errors.New("pinning TLS versions and cipher suites: server is not served over TLS")
+	}
+
+	if s.clientCAFile != "" {
+		if err := s.requireClientCerts(); err != nil {
+			return err
+		}
+	}
 
-	logMsg := fmt.Sprint("serving rendezvous server")
-	if s.authToken != "" {
-		s.SaveAuthPassword()
-		logMsg = fmt.Sprint("serving rendezvous server with auth token")
+	for _, peer := range s.peers {
+		if err := peer.validate(); err != nil {
+			return err
+		}
+	}
+
+	l := s.listener
+	if l == nil {
+		var err error
+		if l, err = net.Listen("tcp", s.httpServer.Addr); err != nil {
+			return fmt.Errorf("serving portal: %w", err)
+		}
+	}
+	// NAT probes are answered before the server is reachable, such that its info advertises them.
+	if err := s.serveNATProbes(ctx); err != nil {
+		l.Close()
+		return err
 	}
+	if s.acme != nil {
+		if err := s.serveACMEChallenges(ctx); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	if s.metrics != nil {
+		if err := s.serveMetrics(ctx); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	errC := make(chan error, 1)
+	go func() {
+		if s.httpServer.TLSConfig != nil {
+			// the certificates are provided by the TLS config.
+			errC <- s.httpServer.ServeTLS(l, "", "")
+			return
+		}
+		errC <- s.httpServer.Serve(l)
+	}()
 
 	s.logger.
 		With(zap.String("version", s.version.String())).
-		With(zap.String("address", s.httpServer.Addr)).
+		With(zap.String("address", l.Addr().String())).
+		With(zap.Bool("tls", s.tlsConfig != nil)).
+		With(zap.Bool("acme", s.acme != nil)).
+		With(zap.Bool("metrics", s.metrics != nil)).
+		With(zap.Bool("http2", s.tlsConfig != nil || s.h2c)).
 		Info(logMsg)
-	<-ctx.Done()
+
+	select {
+	case err := <-errC:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serving portal: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		s.logger.Info("portal rendezvous server is shutting down")
+	}
 
 	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer func() {
-		cancel()
-	}()
+	defer cancel()
 
-	if err = s.httpServer.Shutdown(ctxShutdown); err != nil {
-		s.logger.Fatal("shutting down rendezvous server", zap.Error(err))
+	if err := s.httpServer.Shutdown(ctxShutdown); err != nil {
+		return fmt.Errorf("shutting down rendezvous server: %w", err)
 	}
-
-	if errors.Is(err, http.ErrServerClosed) {
-		err = nil
+	switch {
+	case s.drainTimeout > 0:
+		s.drain()
+	case s.handedOff.Load():
+		s.logger.Info("draining in-flight relays after handoff", zap.Duration("drain_timeout", s.handoffTimeout))
+		s.awaitRelays(s.handoffTimeout)
 	}
 	s.logger.Info("Portal Rendezvous Server shutdown successfully")
-	return err
+	return nil
 }
 
-func (s *Server) SaveAuthPassword() {
-	f, err := os.Create("srv_auth.txt")
-	if err != nil {
-		s.logger.Fatal("cannot make auth file", zap.Error(err))
+// requireClientCerts requires clients to present a certificate signed by the certificate authorities of the server.
+func (s *Server) requireClientCerts() error {
+	if s.httpServer.TLSConfig == nil {
+		return errors.New("requiring client certificates: server is not served over TLS")
 	}
-	defer f.Close()
-	_, err = f.WriteString(s.authToken)
+	b, err := os.ReadFile(s.clientCAFile)
 	if err != nil {
-		s.logger.Fatal("cannot write auth file", zap.Error(err))
+		return fmt.Errorf("reading client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no PEM encoded certificates in client CA file %s", s.clientCAFile)
+	}
+	// crypto/tls only requests the certificate and the server verifies it, such that rejections are audited too.
+	config := s.httpServer.TLSConfig
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequestClientCert
+	// sessions are not resumed, such that the certificate of every connection is verified.
+	config.SessionTicketsDisabled = true
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		client := "unknown"
+		if hello.Conn != nil {
+			client = hello.Conn.RemoteAddr().String()
+			if host, _, err := net.SplitHostPort(client); err == nil {
+				client = host
+			}
+		}
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			identity, err := verifyClientCert(pool, rawCerts)
+			s.auditClientCert(s.loggedIP(client), identity, err)
+			return err
+		}
+		return c, nil
+	}
+	s.httpServer.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, clientCertVerified{}, true)
+	}
+	return nil
+}
+
+// verifyClientCert verifies the presented client certificate chain against the pool, as crypto/tls does for
+// clients required to present a verified certificate. Returns the identity claimed by the leaf certificate, also
+// when it is rejected.
+func verifyClientCert(pool *x509.CertPool, rawCerts [][]byte) (string, error) {
+	if len(rawCerts) == 0 {
+		return "", errors.New("no client certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return "", fmt.Errorf("parsing client certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	identity, _ := certName(certs[0])
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return identity, fmt.Errorf("verifying client certificate: %w", err)
+	}
+	return identity, nil
+}
+
+// serveNATProbes answers NAT probes on the configured UDP ports until the provided context is done.
+func (s *Server) serveNATProbes(ctx context.Context) error {
+	probers := make([]net.PacketConn, 0, len(s.natProbePorts))
+	for _, port := range s.natProbePorts {
+		pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			for _, pc := range probers {
+				pc.Close()
+			}
+			return fmt.Errorf("serving NAT probes: %w", err)
+		}
+		probers = append(probers, pc)
+	}
+	for _, pc := range probers {
+		go func(pc net.PacketConn) {
+			if err := nat.Reflect(ctx, pc); err != nil {
+				s.logger.Warn("answering NAT probes", zap.Error(err))
+			}
+		}(pc)
+	}
+	s.mu.Lock()
+	s.natProbers = probers
+	s.mu.Unlock()
+	return nil
+}
+
+// NATProbePorts returns the UDP ports answering NAT probes, empty until the server is serving.
+func (s *Server) NATProbePorts() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports := make([]int, 0, len(s.natProbers))
+	for _, pc := range s.natProbers {
+		ports = append(ports, pc.LocalAddr().(*net.UDPAddr).Port)
+	}
+	return ports
+}
+
+// Addr returns the address the server is listening on, or nil if the server is not yet listening.
+// Useful to discover the actual port when the server is configured with port 0.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
 	}
+	return s.listener.Addr()
 }
@@ -0,0 +1,632 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/nat"
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	protocol "github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("cancel context", func(t *testing.T) {
+		port := freePort(t)
+		server := rendezvous.NewServer(port, "", semver.Version{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errC := make(chan error, 1)
+		go func() { errC <- server.Run(ctx) }()
+
+		waitForPing(t, fmt.Sprintf("localhost:%d", port))
+		cancel()
+
+		select {
+		case err := <-errC:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not shutdown after context was cancelled")
+		}
+	})
+
+	t.Run("ephemeral port", func(t *testing.T) {
+		server := rendezvous.NewServer(0, "", semver.Version{})
+		assert.Nil(t, server.Addr())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go server.Run(ctx) //nolint:errcheck
+
+		require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		port := server.Addr().(*net.TCPAddr).Port
+		assert.NotZero(t, port)
+		waitForPing(t, fmt.Sprintf("localhost:%d", port))
+	})
+
+	t.Run("listen address", func(t *testing.T) {
+		for _, addr := range []string{"127.0.0.1:0", "[::1]:0"} {
+			server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithListenAddr(addr))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go server.Run(ctx) //nolint:errcheck
+
+			require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+			host, _, err := net.SplitHostPort(addr)
+			require.NoError(t, err)
+			assert.Equal(t, host, server.Addr().(*net.TCPAddr).IP.String())
+			waitForPing(t, server.Addr().String())
+		}
+	})
+
+	t.Run("port in use", func(t *testing.T) {
+		l, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		server := rendezvous.NewServer(l.Addr().(*net.TCPAddr).Port, "", semver.Version{})
+		assert.Error(t, server.Run(context.Background()))
+	})
+}
+
+func TestNATProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithNATProbe(0, 0))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/info", server.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var info protocol.Info
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	require.Len(t, info.NATProbePorts, 2)
+
+	var reflectors []string
+	for _, port := range info.NATProbePorts {
+		reflectors = append(reflectors, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	result, err := nat.Probe(ctx, reflectors, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, nat.Open, result.Type)
+	assert.Len(t, result.Mapped, 2)
+}
+
+func TestTemplateLoadFailure(t *testing.T) {
+	port := freePort(t)
+	server := rendezvous.NewServer(port, "", semver.Version{}, rendezvous.WithTemplateLoader(
+		func() (map[string]*template.Template, error) {
+			return nil, errors.New("template load failure")
+		},
+	))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitForPing(t, addr)
+
+	t.Run("fallback landing page", func(t *testing.T) {
+		r, err := http.Get(fmt.Sprintf("http://%s/", addr))
+		require.NoError(t, err)
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, r.StatusCode)
+		assert.Contains(t, string(body), "Portal relay")
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{RendezvousAddr: addr}
+		in := bytes.NewBufferString(oracle)
+		out := &bytes.Buffer{}
+
+		password, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		require.NoError(t, portal.Receive(ctx, out, password, &config))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+	})
+}
+
+func TestHTTP2(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// serve runs a server with the provided options, returning its address.
+	serve := func(t *testing.T, opts ...rendezvous.Option) string {
+		server := rendezvous.NewServer(0, "", semver.Version{}, opts...)
+		go server.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		return fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+	}
+	// protoOf returns the protocol of the response to a request to the info endpoint.
+	protoOf := func(t *testing.T, client *http.Client, url string) string {
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			var err error
+			resp, err = client.Get(url)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.Proto
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		cert, pool := selfSignedCert(t)
+		addr := serve(t, rendezvous.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		}}
+		assert.Equal(t, "HTTP/2.0", protoOf(t, client, fmt.Sprintf("https://%s/info", addr)))
+
+		// the websocket relay stays on HTTP/1.1 over TLS.
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{RendezvousAddr: "wss://" + addr, TLSConfig: &tls.Config{RootCAs: pool}}
+		in := bytes.NewBufferString(oracle)
+		pass, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		require.NoError(t, portal.Receive(ctx, out, pass, &config))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+	})
+	t.Run("h2c", func(t *testing.T) {
+		addr := serve(t, rendezvous.WithH2C())
+		client := &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+		assert.Equal(t, "HTTP/2.0", protoOf(t, client, fmt.Sprintf("http://%s/info", addr)))
+		assert.Equal(t, "HTTP/1.1", protoOf(t, http.DefaultClient, fmt.Sprintf("http://%s/info", addr)))
+
+		// the websocket relay stays on HTTP/1.1.
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{RendezvousAddr: addr}
+		in := bytes.NewBufferString(oracle)
+		pass, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		require.NoError(t, portal.Receive(ctx, out, pass, &config))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+	})
+}
+
+func TestClientCA(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ca := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "portal test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600))
+	clientTmpl := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "build-agent-1"},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+	trusted := issueCert(t, clientTmpl(), &ca)
+	untrusted := issueCert(t, clientTmpl(), nil)
+
+	serverCert, pool := selfSignedCert(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	syslog, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer syslog.Close()
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithTLS(tlsConfig), rendezvous.WithClientCA(caFile),
+		rendezvous.WithSyslog("udp", syslog.LocalAddr().String()))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	url := fmt.Sprintf("https://localhost:%d/ping", server.Addr().(*net.TCPAddr).Port)
+
+	// audited reads the next audit event sent to syslog.
+	audited := func(t *testing.T) string {
+		require.NoError(t, syslog.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 4096)
+		n, _, err := syslog.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	// ping requests the ping endpoint presenting the provided client certificate, even if it is not issued by the
+	// certificate authorities of the server.
+	ping := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if len(certs) == 0 {
+					return &tls.Certificate{}, nil
+				}
+				return &certs[0], nil
+			}},
+		}}
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+	t.Run("trusted client certificate", func(t *testing.T) {
+		assert.NoError(t, ping(trusted))
+		event := audited(t)
+		assert.Contains(t, event, `result="success" client="127.0.0.1" identity="cert:build-agent-1"`)
+		assert.Contains(t, event, "verified client certificate")
+	})
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		assert.Error(t, ping(untrusted))
+		event := audited(t)
+		assert.Contains(t, event, `result="failure" client="127.0.0.1" identity="cert:build-agent-1"`)
+		assert.Contains(t, event, "rejected client certificate")
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		assert.Error(t, ping())
+		assert.Contains(t, audited(t), "rejected client certificate: no client certificate presented")
+	})
+	t.Run("transfer", func(t *testing.T) {
+		oracle := "A frog walks into a bank..."
+		config := portal.Config{
+			RendezvousAddr: fmt.Sprintf("wss://localhost:%d", server.Addr().(*net.TCPAddr).Port),
+			TLSConfig:      &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{trusted}},
+		}
+		in := bytes.NewBufferString(oracle)
+		pass, err, errC := portal.Send(ctx, in, int64(in.Len()), &config)
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		require.NoError(t, portal.Receive(ctx, out, pass, &config))
+		assert.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+
+		config.TLSConfig = &tls.Config{RootCAs: pool}
+		_, err, _ = portal.Send(ctx, bytes.NewBufferString(oracle), int64(len(oracle)), &config)
+		assert.Error(t, err)
+	})
+	t.Run("without TLS", func(t *testing.T) {
+		server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithClientCA(caFile))
+		assert.Error(t, server.Run(ctx))
+	})
+	t.Run("invalid CA file", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0600))
+		server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithTLS(tlsConfig), rendezvous.WithClientCA(invalid))
+		assert.Error(t, server.Run(ctx))
+	})
+}
+
+func TestTLSPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cert, pool := selfSignedCert(t)
+	// serve runs a server over TLS with the provided options, returning the URL of its ping endpoint.
+	serve := func(t *testing.T, opts ...rendezvous.Option) string {
+		opts = append([]rendezvous.Option{rendezvous.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}})}, opts...)
+		server := rendezvous.NewServer(0, "", semver.Version{}, opts...)
+		go server.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		return fmt.Sprintf("https://localhost:%d/ping", server.Addr().(*net.TCPAddr).Port)
+	}
+	// ping requests the ping endpoint with a client limited to the provided config.
+	ping := func(url string, config *tls.Config) error {
+		config.RootCAs = pool
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	t.Run("default minimum version", func(t *testing.T) {
+		url := serve(t)
+		assert.NoError(t, ping(url, &tls.Config{MaxVersion: tls.VersionTLS12}))
+		assert.Error(t, ping(url, &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}))
+	})
+	t.Run("TLS 1.3 only", func(t *testing.T) {
+		url := serve(t, rendezvous.WithTLSMinVersion(tls.VersionTLS13))
+		assert.NoError(t, ping(url, &tls.Config{MinVersion: tls.VersionTLS13}))
+		assert.Error(t, ping(url, &tls.Config{MaxVersion: tls.VersionTLS12}))
+	})
+	t.Run("pinned cipher suites", func(t *testing.T) {
+		url := serve(t, rendezvous.WithTLSCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"))
+		assert.NoError(t, ping(url, &tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		}))
+		assert.Error(t, ping(url, &tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		}))
+	})
+	t.Run("invalid policies", func(t *testing.T) {
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+		for _, opts := range [][]rendezvous.Option{
+			{rendezvous.WithTLS(tlsConfig), rendezvous.WithTLSMinVersion(tls.VersionTLS11)},
+			{rendezvous.WithTLS(tlsConfig), rendezvous.WithTLSCipherSuites("TLS_FROG_WITH_AES_128_GCM_SHA256")},
+			{rendezvous.WithTLS(tlsConfig), rendezvous.WithTLSCipherSuites("TLS_RSA_WITH_RC4_128_SHA")},
+			{rendezvous.WithTLS(tlsConfig), rendezvous.WithTLSMinVersion(tls.VersionTLS13), rendezvous.WithTLSCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")},
+			{rendezvous.WithTLSMinVersion(tls.VersionTLS13)},
+		} {
+			server := rendezvous.NewServer(0, "", semver.Version{}, opts...)
+			assert.Error(t, server.Run(ctx))
+		}
+	})
+}
+
+func TestACME(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const domain = "relay.example"
+	// the certificate is cached as provisioned, such that the ACME server is never contacted.
+	cacheDir := t.TempDir()
+	cert := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: domain},
+		DNSNames:    []string{domain},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	var cached bytes.Buffer
+	require.NoError(t, pem.Encode(&cached, &pem.Block{Type: "EC PRIVATE KEY", Bytes: key}))
+	require.NoError(t, pem.Encode(&cached, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, domain), cached.Bytes(), 0600))
+
+	manager, err := rendezvous.NewACMEManager([]string{domain}, cacheDir, "")
+	require.NoError(t, err)
+	manager.RenewBefore = time.Minute
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithACME(manager, "127.0.0.1:0"))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil && server.ACMEChallengeAddr() != nil }, 5*time.Second, 10*time.Millisecond)
+	port := server.Addr().(*net.TCPAddr).Port
+	challengeAddr := server.ACMEChallengeAddr().String()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	// get requests the path from the relay over TLS, presenting the server name.
+	get := func(serverName, path string) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: serverName},
+			ForceAttemptHTTP2: true,
+		}}
+		return client.Get(fmt.Sprintf("https://localhost:%d%s", port, path))
+	}
+
+	t.Run("serves the provisioned certificate", func(t *testing.T) {
+		resp, err := get(domain, "/ping")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+	})
+
+	t.Run("refuses other domains", func(t *testing.T) {
+		_, err := get("other.example", "/ping")
+		assert.Error(t, err)
+	})
+
+	t.Run("answers challenges over HTTP", func(t *testing.T) {
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/ping", challengeAddr), nil)
+		require.NoError(t, err)
+		req.Host = domain
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "https://"+domain+"/ping", resp.Header.Get("Location"))
+
+		// no challenge is pending for the token.
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/.well-known/acme-challenge/token", challengeAddr), nil)
+		require.NoError(t, err)
+		req.Host = domain
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := rendezvous.NewACMEManager(nil, cacheDir, "")
+		assert.Error(t, err)
+		_, err = rendezvous.NewACMEManager([]string{domain}, "", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := rendezvous.ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, suites)
+
+	// the suites of TLS 1.3 are silently ignored by crypto/tls.
+	_, err = rendezvous.ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256"})
+	assert.ErrorContains(t, err, "TLS 1.3")
+
+	_, err = rendezvous.ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.ErrorContains(t, err, "insecure")
+	_, err = rendezvous.ParseCipherSuites([]string{"frog"})
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestHeaderLimits(t *testing.T) {
+	const maxHeaderBytes, readHeaderTimeout = 4 << 10, 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := rendezvous.NewServer(0, "", semver.Version{},
+		rendezvous.WithMaxHeaderBytes(maxHeaderBytes), rendezvous.WithReadHeaderTimeout(readHeaderTimeout))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	t.Run("oversized headers", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/ping", addr), nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Padding", strings.Repeat("a", 4*maxHeaderBytes))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	})
+	t.Run("slow headers", func(t *testing.T) {
+		c, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer c.Close()
+		_, err = c.Write([]byte("GET /ping HTTP/1.1\r\nHost: localhost\r\n"))
+		require.NoError(t, err)
+		// the server closes the connection before the headers are completed.
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(10*readHeaderTimeout)))
+		_, err = c.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("websocket upgrade", func(t *testing.T) {
+		_, _, err := sender.ConnectRendezvous(ctx, addr)
+		assert.NoError(t, err)
+	})
+}
+
+func TestGzip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const motd = "Welcome to the relay, transfers are kept for at most an hour."
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithMOTD(motd))
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	url := fmt.Sprintf("http://localhost:%d/info", server.Addr().(*net.TCPAddr).Port)
+	// transparent decompression is disabled, such that the encoding of the response is observed.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{name: "gzip accepted", acceptEncoding: "deflate, gzip;q=0.8", gzipped: true},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0"},
+		{name: "no header"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			require.NoError(t, err)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			body := io.Reader(resp.Body)
+			if tc.gzipped {
+				require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+				gz, err := gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+				defer gz.Close()
+				body = gz
+			} else {
+				require.Empty(t, resp.Header.Get("Content-Encoding"))
+			}
+			var info protocol.Info
+			require.NoError(t, json.NewDecoder(body).Decode(&info))
+			assert.Equal(t, motd, info.MOTD)
+		})
+	}
+}
+
+// ------------------------------------------------------ Helpers ------------------------------------------------------
+
+// selfSignedCert returns a self-signed certificate for localhost, and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	cert := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	return cert, pool
+}
+
+// issueCert issues a certificate from the template, signed by the issuer or self-signed if the issuer is nil.
+func issueCert(t *testing.T, tmpl *x509.Certificate, issuer *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, any(key)
+	if issuer != nil {
+		parent, signer = issuer.Leaf, issuer.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func waitForPing(t *testing.T, addr string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		r, err := http.Get(fmt.Sprintf("http://%s/ping", addr))
+		if err != nil {
+			return false
+		}
+		r.Body.Close()
+		return r.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,143 @@
+// shedding.go specifies the adaptive load shedding of the server, rejecting new transfers while the server is
+// overloaded such that the transfers already relayed are not degraded.
+package rendezvous
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_SHED_RETRY_AFTER is the time shed senders are told to wait before registering again.
+const DEFAULT_SHED_RETRY_AFTER = 30 * time.Second
+
+// LOAD_SAMPLE_INTERVAL is the duration a load sample is reused for, such that registrations do not read the
+// memory statistics of the runtime on every request.
+const LOAD_SAMPLE_INTERVAL = time.Second
+
+// LoadThresholds are the thresholds above which the server sheds new transfers. Zero thresholds are not enforced.
+type LoadThresholds struct {
+	Goroutines int    // number of goroutines
+	HeapBytes  uint64 // bytes of allocated heap objects
+	Throughput int64  // relayed bytes per second across all mailboxes
+}
+
+// Load is a sample of the load of the server.
+type Load struct {
+	Goroutines int
+	HeapBytes  uint64
+	Throughput int64
+}
+
+// exceeds returns a description of the first threshold exceeded by the load, empty if none is exceeded.
+func (l Load) exceeds(t LoadThresholds) string {
+	switch {
+	case t.Goroutines > 0 && l.Goroutines > t.Goroutines:
+		return fmt.Sprintf("goroutines %d > %d", l.Goroutines, t.Goroutines)
+	case t.HeapBytes > 0 && l.HeapBytes > t.HeapBytes:
+		return fmt.Sprintf("heap bytes %d > %d", l.HeapBytes, t.HeapBytes)
+	case t.Throughput > 0 && l.Throughput > t.Throughput:
+		return fmt.Sprintf("throughput %d > %d bytes/s", l.Throughput, t.Throughput)
+	default:
+		return ""
+	}
+}
+
+// shedder samples the load of the server, shedding new transfers while a threshold is exceeded.
+// A nil shedder never sheds.
+type shedder struct {
+	thresholds LoadThresholds
+	retryAfter time.Duration
+	interval   time.Duration
+	read       func(elapsed time.Duration) Load // reads the load since the previous sample
+
+	relayed atomic.Int64 // bytes relayed since the previous sample
+
+	mu      sync.Mutex
+	sampled time.Time
+	load    Load
+}
+
+// newShedder constructs a shedder enforcing the thresholds, telling shed senders to retry after the provided time.
+func newShedder(thresholds LoadThresholds, retryAfter time.Duration) *shedder {
+	sh := &shedder{thresholds: thresholds, retryAfter: retryAfter, interval: LOAD_SAMPLE_INTERVAL}
+	sh.read = sh.readRuntime
+	return sh
+}
+
+// readRuntime reads the load from the runtime and the bytes relayed since the previous sample.
+func (sh *shedder) readRuntime(elapsed time.Duration) Load {
+	load := Load{Goroutines: runtime.NumGoroutine()}
+	if sh.thresholds.HeapBytes > 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		load.HeapBytes = stats.HeapAlloc
+	}
+	relayed := sh.relayed.Swap(0)
+	if elapsed > 0 {
+		load.Throughput = int64(float64(relayed) / elapsed.Seconds())
+	}
+	return load
+}
+
+// Relayed records n bytes relayed to a peer.
+func (sh *shedder) Relayed(n int) {
+	if sh == nil {
+		return
+	}
+	sh.relayed.Add(int64(n))
+}
+
+// Load returns the load of the server, sampled at most every interval.
+func (sh *shedder) Load() Load {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := time.Now()
+	// the first sample yields no throughput, the bytes relayed up until then are counted over no time.
+	if sh.sampled.IsZero() || now.Sub(sh.sampled) >= sh.interval {
+		var elapsed time.Duration
+		if !sh.sampled.IsZero() {
+			elapsed = now.Sub(sh.sampled)
+		}
+		sh.load = sh.read(elapsed)
+		sh.sampled = now
+	}
+	return sh.load
+}
+
+// Overloaded returns a description of the threshold exceeded by the load of the server, empty if the server is
+// not overloaded.
+func (sh *shedder) Overloaded() string {
+	if sh == nil {
+		return ""
+	}
+	return sh.Load().exceeds(sh.thresholds)
+}
+
+// shedLoad rejects senders with 503 Service Unavailable and a Retry-After header while the server is overloaded.
+// Sheds are re-evaluated for every sender, such that the server recovers as soon as the load drops.
+func (s *Server) shedLoad(next http.Handler) http.Handler {
+	if s.shedder == nil {
+		return next
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(s.shedder.retryAfter.Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := s.shedder.Overloaded(); reason != "" {
+			if logger, err := logger.FromContext(r.Context()); err == nil {
+				logger.Warn("shedding sender, server overloaded", zap.String("reason", reason))
+			}
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
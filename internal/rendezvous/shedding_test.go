@@ -0,0 +1,82 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadShedding(t *testing.T) {
+	const maxGoroutines = 1000
+	s := NewServer(0, "", semver.Version{}, WithLoadShedding(LoadThresholds{Goroutines: maxGoroutines}, 5*time.Second))
+	// the load is simulated, and read on every registration.
+	var goroutines atomic.Int64
+	s.shedder.interval = 0
+	s.shedder.read = func(time.Duration) Load { return Load{Goroutines: int(goroutines.Load())} }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// a relay is established before the server is overloaded.
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr)
+	require.NoError(t, err)
+	senderC := make(chan conn.Transfer, 1)
+	go func() {
+		tc, _ := sender.SecureConnection(ctx, rc, pass)
+		senderC <- tc
+	}()
+	var rtc conn.Transfer
+	require.Eventually(t, func() bool {
+		rrc, err := receiver.ConnectRendezvous(addr)
+		if err != nil {
+			return false
+		}
+		rtc, err = receiver.SecureConnection(ctx, rrc, pass)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	stc := <-senderC
+	require.NotNil(t, stc.Conn)
+
+	goroutines.Store(maxGoroutines + 1)
+
+	t.Run("new senders are shed", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/establish-sender", addr))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+
+		_, _, err = sender.ConnectRendezvous(ctx, addr)
+		assert.Error(t, err)
+	})
+
+	t.Run("existing relays continue", func(t *testing.T) {
+		for i := 0; i < 8; i++ {
+			payload := []byte(fmt.Sprintf("chunk %d", i))
+			require.NoError(t, stc.WriteRaw(ctx, payload))
+			b, err := rtc.ReadRaw(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, payload, b)
+		}
+	})
+
+	t.Run("recovers once load drops", func(t *testing.T) {
+		goroutines.Store(maxGoroutines / 2)
+		_, _, err := sender.ConnectRendezvous(ctx, addr)
+		assert.NoError(t, err)
+	})
+}
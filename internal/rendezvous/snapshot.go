@@ -0,0 +1,112 @@
+// snapshot.go specifies the point-in-time views of the server state, used to report metrics and stats.
+package rendezvous
+
+import (
+	"sort"
+	"time"
+)
+
+// SNAPSHOT_MAX_AGE is the duration a snapshot is reused for, before the live state is read again.
+const SNAPSHOT_MAX_AGE = time.Second
+
+// Snapshot is a point-in-time view of the state of the server.
+type Snapshot struct {
+	Time      time.Time         `json:"time"`
+	IDs       int               `json:"ids"`
+	Mailboxes []MailboxSnapshot `json:"mailboxes"`
+	// InFlightBytes is the number of relayed bytes held in memory, read from a connection and not yet written to its peer.
+	// Only tracked if the bytes in flight are bounded.
+	InFlightBytes int64 `json:"in_flight_bytes"`
+	// Transfers are the outcomes of the transfers that ended within the outcome window of the server.
+	Transfers TransferStats `json:"transfers"`
+}
+
+// Stats are the aggregated state of the server reported on the stats endpoint. Mailboxes are only counted, such
+// that their ids, which are part of the codes of senders, are not disclosed.
+type Stats struct {
+	Time          time.Time      `json:"time"`
+	IDs           int            `json:"ids"`
+	Mailboxes     map[string]int `json:"mailboxes"` // number of mailboxes by state
+	BytesRelayed  int64          `json:"bytes_relayed"`
+	InFlightBytes int64          `json:"in_flight_bytes"`
+	Transfers     TransferStats  `json:"transfers"`
+}
+
+// MailboxSnapshot is a point-in-time view of the state of a mailbox.
+type MailboxSnapshot struct {
+	ID              int          `json:"id"`
+	State           MailboxState `json:"state"`
+	Created         time.Time    `json:"created"`
+	BytesToSender   int64        `json:"bytes_to_sender"`
+	BytesToReceiver int64        `json:"bytes_to_receiver"`
+}
+
+// Count returns the number of mailboxes in the provided state.
+func (s Snapshot) Count(state MailboxState) int {
+	n := 0
+	for _, m := range s.Mailboxes {
+		if m.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// BytesRelayed returns the total number of bytes relayed by the mailboxes of the snapshot.
+func (s Snapshot) BytesRelayed() int64 {
+	var n int64
+	for _, m := range s.Mailboxes {
+		n += m.BytesToSender + m.BytesToReceiver
+	}
+	return n
+}
+
+// Stats aggregates the snapshot into the stats reported on the stats endpoint.
+func (s Snapshot) Stats() Stats {
+	mailboxes := make(map[string]int, 3)
+	for _, state := range []MailboxState{MailboxWaiting, MailboxHandshake, MailboxRelaying} {
+		mailboxes[state.String()] = s.Count(state)
+	}
+	return Stats{
+		Time:          s.Time,
+		IDs:           s.IDs,
+		Mailboxes:     mailboxes,
+		BytesRelayed:  s.BytesRelayed(),
+		InFlightBytes: s.InFlightBytes,
+		Transfers:     s.Transfers,
+	}
+}
+
+// Snapshot returns a point-in-time view of the state of the server. Snapshots are reused for
+// SNAPSHOT_MAX_AGE, such that frequent readers do not iterate the live state on every read.
+// The returned snapshot is owned by the caller.
+func (s *Server) Snapshot() Snapshot {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if s.lastSnapshot.Time.IsZero() || time.Since(s.lastSnapshot.Time) >= SNAPSHOT_MAX_AGE {
+		s.lastSnapshot = s.snapshot()
+	}
+	snap := s.lastSnapshot
+	snap.Mailboxes = append([]MailboxSnapshot(nil), snap.Mailboxes...)
+	return snap
+}
+
+// snapshot reads the live state of the server into a snapshot.
+func (s *Server) snapshot() Snapshot {
+	snap := Snapshot{Time: time.Now(), InFlightBytes: s.inFlight.Bytes(), Transfers: s.outcomes.Stats()}
+	// the number of ids is left out of the snapshot if the id store cannot be read.
+	snap.IDs, _ = s.ids.Len()
+	s.mailboxes.Range(func(_, v any) bool {
+		m := v.(*Mailbox)
+		snap.Mailboxes = append(snap.Mailboxes, MailboxSnapshot{
+			ID:              m.id,
+			State:           m.State(),
+			Created:         m.created,
+			BytesToSender:   m.toSender.Load(),
+			BytesToReceiver: m.toReceiver.Load(),
+		})
+		return true
+	})
+	sort.Slice(snap.Mailboxes, func(i, j int) bool { return snap.Mailboxes[i].ID < snap.Mailboxes[j].ID })
+	return snap
+}
@@ -0,0 +1,118 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("counts", func(t *testing.T) {
+		s := NewServer(0, "", semver.Version{})
+		waiting, relaying := newMailbox(1, newSystemClock()), newMailbox(2, newSystemClock())
+		relaying.setState(MailboxRelaying)
+		relaying.toSender.Add(10)
+		relaying.toReceiver.Add(32)
+		s.mailboxes.StoreMailbox("a", waiting)
+		s.mailboxes.StoreMailbox("b", relaying)
+		s.ids.Bind()
+		s.ids.Bind()
+
+		snap := s.Snapshot()
+		assert.Equal(t, 2, snap.IDs)
+		assert.Equal(t, 1, snap.Count(MailboxWaiting))
+		assert.Equal(t, 1, snap.Count(MailboxRelaying))
+		assert.Equal(t, int64(42), snap.BytesRelayed())
+		assert.Equal(t, []int{1, 2}, []int{snap.Mailboxes[0].ID, snap.Mailboxes[1].ID})
+
+		// snapshots are reused, and owned by the caller.
+		snap.Mailboxes[0].ID = 100
+		s.mailboxes.DeleteMailbox("a")
+		assert.Equal(t, 1, s.Snapshot().Mailboxes[0].ID)
+	})
+
+	t.Run("concurrent registration", func(t *testing.T) {
+		s := NewServer(0, "", semver.Version{})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					id, err := s.ids.Bind()
+					assert.NoError(t, err)
+					password := fmt.Sprintf("%d-%d", i, j)
+					m := newMailbox(id, newSystemClock())
+					s.mailboxes.StoreMailbox(password, m)
+					m.setState(MailboxRelaying)
+					m.toReceiver.Add(1)
+					s.mailboxes.DeleteMailbox(password)
+					s.ids.Delete(id)
+				}
+			}(i)
+		}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					snap := s.snapshot()
+					assert.LessOrEqual(t, len(snap.Mailboxes), 8)
+					s.Snapshot()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Empty(t, s.snapshot().Mailboxes)
+	})
+}
+
+func TestRelayCountsRelayedBytes(t *testing.T) {
+	s := NewServer(0, "", semver.Version{})
+	written := make(chan []byte, 1)
+	rc := conn.Rendezvous{Conn: writtenConn(written)}
+	forward, relayIn, relayOut := make(chan conn.Frame), make(chan conn.Frame), make(chan conn.Frame, 1)
+	var relayed atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ended := make(chan bool)
+	go func() {
+		ended <- s.relay(context.Background(), &wg, rc, forward, relayIn, relayOut, newMailbox(1, newSystemClock()), &relayed, nil, zap.NewNop())
+	}()
+
+	forward <- conn.Frame{Payload: []byte("to the peer")}
+	assert.Equal(t, "to the peer", string((<-relayOut).Payload))
+	relayIn <- conn.Frame{Payload: []byte("from the peer, not counted")}
+	assert.Equal(t, "from the peer, not counted", string(<-written))
+	// only the bytes relayed to the peer are counted, by the relay of the peer for the other direction.
+	assert.Equal(t, int64(len("to the peer")), relayed.Load())
+
+	close(relayIn)
+	assert.False(t, <-ended)
+	wg.Wait()
+}
+
+// writtenConn sends the messages written to the connection on the channel.
+type writtenConn chan<- []byte
+
+func (c writtenConn) Read(ctx context.Context) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c writtenConn) Write(_ context.Context, b []byte) error {
+	c <- b
+	return nil
+}
+
+func (c writtenConn) Close(websocket.StatusCode, string) error {
+	return nil
+}
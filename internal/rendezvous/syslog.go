@@ -0,0 +1,250 @@
+// syslog.go specifies the audit events of the server, transfer summaries and authorization decisions, teed to a
+// syslog server as RFC 5424 messages for environments requiring centralized audit logs.
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// SYSLOG_BUFFER_SIZE is the number of audit events buffered while the syslog server is unreachable,
+	// events emitted while the buffer is full are dropped.
+	SYSLOG_BUFFER_SIZE = 1024
+	// SYSLOG_TIMEOUT bounds connecting to, and writing an event to, the syslog server.
+	SYSLOG_TIMEOUT = 5 * time.Second
+	// SYSLOG_INITIAL_BACKOFF is the time waited before reconnecting to an unreachable syslog server, doubled
+	// after every failed attempt up to SYSLOG_MAX_BACKOFF.
+	SYSLOG_INITIAL_BACKOFF = 500 * time.Millisecond
+	SYSLOG_MAX_BACKOFF     = 30 * time.Second
+)
+
+// Message ids of the audit events.
+const (
+	AUDIT_TRANSFER = "transfer"
+	AUDIT_AUTH     = "auth"
+)
+
+const (
+	syslogFacility = 13 // log audit
+	syslogWarning  = 4
+	syslogNotice   = 5
+	syslogAppName  = "portal"
+	// syslogSDID is the id of the structured data of the events, under the enterprise number reserved for
+	// documentation as portal has none registered.
+	syslogSDID = "portal@32473"
+)
+
+// auditEvent is an event written to the audit log.
+type auditEvent struct {
+	time     time.Time
+	severity int
+	msgID    string
+	msg      string
+	params   []auditParam
+}
+
+// auditParam is a parameter of the structured data of an audit event.
+type auditParam struct {
+	name, value string
+}
+
+// syslogSink writes audit events to a syslog server. Events are buffered and written by run, such that an
+// unreachable syslog server never blocks the server. Emitting is safe for concurrent use, and a no-op on nil.
+type syslogSink struct {
+	network  string
+	addr     string
+	hostname string
+	pid      int
+	events   chan auditEvent
+	dropped  atomic.Int64
+}
+
+// newSyslogSink constructs a sink writing to the syslog server at addr over the provided network.
+func newSyslogSink(network, addr string) *syslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{
+		network:  network,
+		addr:     addr,
+		hostname: hostname,
+		pid:      os.Getpid(),
+		events:   make(chan auditEvent, SYSLOG_BUFFER_SIZE),
+	}
+}
+
+// Emit buffers the event to be written to the syslog server, dropping it if the buffer is full.
+func (a *syslogSink) Emit(e auditEvent) {
+	if a == nil {
+		return
+	}
+	select {
+	case a.events <- e:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// run writes the buffered events to the syslog server until the context is cancelled. Events failing to be
+// written are retried once reconnected, waiting an exponential backoff between attempts at connecting.
+func (a *syslogSink) run(ctx context.Context, logger *zap.Logger) {
+	var c net.Conn
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	backoff := SYSLOG_INITIAL_BACKOFF
+	for {
+		var e auditEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e = <-a.events:
+		}
+		msg := a.frame(a.format(e))
+		for {
+			a.reportDropped(logger)
+			if c == nil {
+				var err error
+				if c, err = net.DialTimeout(a.network, a.addr, SYSLOG_TIMEOUT); err != nil {
+					logger.Warn("connecting to syslog server", zap.Error(err), zap.Duration("retry_in", backoff))
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(backoff):
+					}
+					if backoff *= 2; backoff > SYSLOG_MAX_BACKOFF {
+						backoff = SYSLOG_MAX_BACKOFF
+					}
+					continue
+				}
+				backoff = SYSLOG_INITIAL_BACKOFF
+			}
+			c.SetWriteDeadline(time.Now().Add(SYSLOG_TIMEOUT)) //nolint:errcheck
+			if _, err := c.Write(msg); err != nil {
+				logger.Warn("writing to syslog server", zap.Error(err))
+				c.Close()
+				c = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// reportDropped warns of the events dropped since the last report.
+func (a *syslogSink) reportDropped(logger *zap.Logger) {
+	if n := a.dropped.Swap(0); n > 0 {
+		logger.Warn("dropped audit events, syslog buffer full", zap.Int64("dropped", n))
+	}
+}
+
+// format formats the event as an RFC 5424 message.
+func (a *syslogSink) format(e auditEvent) string {
+	sd := "-"
+	if len(e.params) > 0 {
+		var b strings.Builder
+		b.WriteString("[" + syslogSDID)
+		for _, p := range e.params {
+			fmt.Fprintf(&b, " %s=\"%s\"", p.name, sdEscaper.Replace(p.value))
+		}
+		b.WriteString("]")
+		sd = b.String()
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		syslogFacility*8+e.severity, e.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		a.hostname, syslogAppName, a.pid, e.msgID, sd, e.msg)
+}
+
+// sdEscaper escapes the characters of structured data parameter values, see RFC 5424 section 6.3.3.
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// frame frames the message for the network of the sink. Messages are sent as a datagram each over datagram
+// networks, and prefixed by their length over stream networks, see RFC 6587 section 3.4.1.
+func (a *syslogSink) frame(msg string) []byte {
+	if strings.HasPrefix(a.network, "udp") || a.network == "unixgram" {
+		return []byte(msg)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+// auditTransfer emits the summary of the transfer of the mailbox once it ended.
+func (s *Server) auditTransfer(r *http.Request, mailbox *Mailbox, outcome string) {
+	if s.audit == nil {
+		return
+	}
+	severity := syslogNotice
+	if outcome != OUTCOME_COMPLETED {
+		severity = syslogWarning
+	}
+	s.audit.Emit(auditEvent{
+		time:     time.Now(),
+		severity: severity,
+		msgID:    AUDIT_TRANSFER,
+		msg:      fmt.Sprintf("transfer %s", outcome),
+		params: []auditParam{
+			{"id", fmt.Sprint(mailbox.id)},
+			{"outcome", outcome},
+			{"sender", s.loggedIP(identityFromRequest(r))},
+			{"bytes_to_receiver", fmt.Sprint(mailbox.toReceiver.Load())},
+			{"bytes_to_sender", fmt.Sprint(mailbox.toSender.Load())},
+			{"duration_ms", fmt.Sprint(mailbox.Age().Milliseconds())},
+		},
+	})
+}
+
+// auditClientCert emits the verification of the client certificate presented by the client in the TLS handshake,
+// claiming the identity.
+func (s *Server) auditClientCert(client, identity string, err error) {
+	if s.audit == nil {
+		return
+	}
+	severity, result, msg := syslogNotice, "success", "verified client certificate"
+	if err != nil {
+		severity, result, msg = syslogWarning, "failure", "rejected client certificate: "+err.Error()
+	}
+	params := []auditParam{{"result", result}, {"client", client}}
+	if identity != "" {
+		params = append(params, auditParam{"identity", identity})
+	}
+	s.audit.Emit(auditEvent{
+		time:     time.Now(),
+		severity: severity,
+		msgID:    AUDIT_AUTH,
+		msg:      msg,
+		params:   params,
+	})
+}
+
+// auditAuth emits the authorization decision of the request, e.g. "unauthorized admin request".
+func (s *Server) auditAuth(r *http.Request, authorized bool, msg string) {
+	if s.audit == nil {
+		return
+	}
+	severity, result := syslogNotice, "success"
+	if !authorized {
+		severity, result = syslogWarning, "failure"
+	}
+	s.audit.Emit(auditEvent{
+		time:     time.Now(),
+		severity: severity,
+		msgID:    AUDIT_AUTH,
+		msg:      msg,
+		params: []auditParam{
+			{"result", result},
+			{"endpoint", r.URL.Path},
+			{"client", s.loggedIP(identityFromRequest(r))},
+		},
+	})
+}
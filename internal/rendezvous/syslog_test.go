@@ -0,0 +1,129 @@
+package rendezvous
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syslogStub is a syslog server receiving octet-counted messages over TCP.
+func syslogStub(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	msgs := make(chan string, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					prefix, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSpace(prefix))
+					if err != nil {
+						return
+					}
+					msg := make([]byte, n)
+					if _, err := io.ReadFull(r, msg); err != nil {
+						return
+					}
+					msgs <- string(msg)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestSyslog(t *testing.T) {
+	syslogAddr, msgs := syslogStub(t)
+	s := NewServer(0, "secret", semver.Version{}, WithSyslog("tcp", syslogAddr), WithAuthFile(t.TempDir()+"/auth"))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	payload := []byte("A frog walks into a bank...")
+	pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addr})
+	require.NoError(t, err)
+	require.NoError(t, portal.Receive(ctx, io.Discard, pass, &portal.Config{RendezvousAddr: addr}))
+	require.NoError(t, <-errC)
+
+	msg := <-msgs
+	assert.Regexp(t, `^<109>1 \S+ \S+ portal \d+ transfer \[portal@32473 id="\d+" outcome="completed" sender="127.0.0.1" bytes_to_receiver="\d+" bytes_to_sender="\d+" duration_ms="\d+"\] transfer completed$`, msg)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/admin/evict-idle", addr), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer guess")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	msg = <-msgs
+	assert.Regexp(t, `^<108>1 \S+ \S+ portal \d+ auth \[portal@32473 result="failure" endpoint="/admin/evict-idle" client="127.0.0.1"\] unauthorized admin request$`, msg)
+}
+
+func TestSyslogUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	syslogAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	s := NewServer(0, "", semver.Version{}, WithSyslog("tcp", syslogAddr))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// transfers complete while the syslog server is unreachable, also once the buffer is full.
+	for i := 0; i < 2*SYSLOG_BUFFER_SIZE; i++ {
+		s.audit.Emit(auditEvent{time: time.Now(), severity: syslogNotice, msgID: AUDIT_TRANSFER, msg: "filler"})
+	}
+	payload := []byte("A frog walks into a bank...")
+	pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &portal.Config{RendezvousAddr: addr})
+	require.NoError(t, err)
+	var received bytes.Buffer
+	require.NoError(t, portal.Receive(ctx, &received, pass, &portal.Config{RendezvousAddr: addr}))
+	require.NoError(t, <-errC)
+	assert.Equal(t, payload, received.Bytes())
+}
+
+func TestSyslogFormat(t *testing.T) {
+	sink := newSyslogSink("udp", "localhost:514")
+	sink.hostname, sink.pid = "relay", 42
+	msg := sink.format(auditEvent{
+		time:     time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		severity: syslogWarning,
+		msgID:    AUDIT_AUTH,
+		msg:      "unauthorized admin request",
+		params:   []auditParam{{"client", `a"b\c]d`}},
+	})
+	assert.Equal(t, `<108>1 2024-01-02T03:04:05.000006Z relay portal 42 auth [portal@32473 client="a\"b\\c\]d"] unauthorized admin request`, msg)
+	assert.Equal(t, msg, string(sink.frame(msg)))
+
+	sink.network = "tcp"
+	assert.Equal(t, fmt.Sprintf("%d %s", len(msg), msg), string(sink.frame(msg)))
+}
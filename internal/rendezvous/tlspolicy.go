@@ -0,0 +1,81 @@
+// tlspolicy.go specifies the TLS versions and cipher suites accepted by servers served over TLS.
+package rendezvous
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// DEFAULT_TLS_MIN_VERSION is the minimum TLS version accepted from clients unless pinned with WithTLSMinVersion.
+const DEFAULT_TLS_MIN_VERSION = tls.VersionTLS12
+
+// tlsVersions are the TLS versions that can be pinned as the minimum version, by name.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses the name of a TLS version that can be pinned as the minimum version, 1.2 or 1.3.
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported minimum TLS version '%s', must be 1.2 or 1.3", name)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses the names of the cipher suites, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, into
+// their IDs. Suites unknown to crypto/tls, suites with known security issues and the suites of TLS 1.3, which
+// crypto/tls does not let be configured, are refused.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(tls.CipherSuites(), func(c *tls.CipherSuite) bool { return c.Name == name })
+		if i < 0 {
+			if slices.IndexFunc(tls.InsecureCipherSuites(), func(c *tls.CipherSuite) bool { return c.Name == name }) >= 0 {
+				return nil, fmt.Errorf("insecure cipher suite '%s'", name)
+			}
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		suite := tls.CipherSuites()[i]
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("cipher suite '%s' of TLS 1.3 is not configurable, only TLS 1.2 suites can be pinned", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// pinTLS restricts the TLS versions and cipher suites accepted from clients to the pinned ones.
+// Cipher suites are only pinned for TLS 1.2, the suites of TLS 1.3 are not configurable.
+func (s *Server) pinTLS() error {
+	config := s.httpServer.TLSConfig
+	if s.tlsMinVersion != 0 {
+		if !slices.Contains([]uint16{tls.VersionTLS12, tls.VersionTLS13}, s.tlsMinVersion) {
+			return fmt.Errorf("pinning TLS version: unsupported minimum TLS version %s", tls.VersionName(s.tlsMinVersion))
+		}
+		config.MinVersion = s.tlsMinVersion
+	} else if config.MinVersion < DEFAULT_TLS_MIN_VERSION {
+		config.MinVersion = DEFAULT_TLS_MIN_VERSION
+	}
+	if s.tlsCipherSuites == nil {
+		return nil
+	}
+	if config.MinVersion == tls.VersionTLS13 {
+		return fmt.Errorf("pinning cipher suites: the cipher suites of TLS 1.3 are not configurable")
+	}
+	suites, err := ParseCipherSuites(s.tlsCipherSuites)
+	if err != nil {
+		return fmt.Errorf("pinning cipher suites: %w", err)
+	}
+	config.CipherSuites = suites
+	// HTTP/2 requires an AES-128-GCM suite of TLS 1.2, clients fall back to HTTP/1.1 without it.
+	if !slices.Contains(suites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) && !slices.Contains(suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		config.NextProtos = slices.DeleteFunc(config.NextProtos, func(proto string) bool { return proto == "h2" })
+		s.logger.Warn("pinned cipher suites exclude the suites required by HTTP/2, serving HTTP/1.1 only", zap.Strings("cipher_suites", s.tlsCipherSuites))
+	}
+	return nil
+}
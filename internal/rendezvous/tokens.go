@@ -0,0 +1,215 @@
+// tokens.go specifies the auth tokens of the server, such that every operator presents a token of their own to the
+// admin endpoints, identified by its label in the logs. Tokens are rotated without restarting the server by
+// reloading their source, and only their hashes are held in memory or written to disk.
+package rendezvous
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_TOKEN_LABEL labels the auth token the server is created with.
+const DEFAULT_TOKEN_LABEL = "default"
+
+// TOKEN_HASH_PREFIX prefixes the hex encoded sha256 hashes of tokens in token lists.
+const TOKEN_HASH_PREFIX = "sha256:"
+
+// AUTH_TOKENS_ENV is the environment variable operators provide the token list of the server in, see TokensFromEnv.
+const AUTH_TOKENS_ENV = "PORTAL_AUTH_TOKENS"
+
+// Token is a labeled auth token, of which only the hash is kept.
+type Token struct {
+	Label string
+	Hash  [sha256.Size]byte
+}
+
+// NewToken returns the token labeled label with the provided secret.
+func NewToken(label, secret string) Token {
+	return Token{Label: label, Hash: sha256.Sum256([]byte(secret))}
+}
+
+// ParseTokens parses a token list of label=token entries, separated by newlines or commas. Tokens prefixed with
+// TOKEN_HASH_PREFIX are hashes of the token rather than the token itself. Blank entries, and lines starting with #,
+// are ignored. Labels are unique.
+func ParseTokens(list string) ([]Token, error) {
+	var tokens []Token
+	labels := map[string]bool{}
+	for _, line := range strings.Split(list, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			label, secret, ok := strings.Cut(entry, "=")
+			label, secret = strings.TrimSpace(label), strings.TrimSpace(secret)
+			if !ok || label == "" || secret == "" {
+				return nil, fmt.Errorf("invalid token entry, expected label=token")
+			}
+			if labels[label] {
+				return nil, fmt.Errorf("duplicate token label %q", label)
+			}
+			labels[label] = true
+			hash, hashed := strings.CutPrefix(secret, TOKEN_HASH_PREFIX)
+			if !hashed {
+				tokens = append(tokens, NewToken(label, secret))
+				continue
+			}
+			b, err := hex.DecodeString(hash)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid hash of token %q, expected %s followed by %d hex digits", label, TOKEN_HASH_PREFIX, 2*sha256.Size)
+			}
+			token := Token{Label: label}
+			copy(token.Hash[:], b)
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// FormatTokens formats the tokens as a token list of their hashes, one per line, parsed by ParseTokens.
+func FormatTokens(tokens []Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		fmt.Fprintf(&b, "%s=%s%s\n", t.Label, TOKEN_HASH_PREFIX, hex.EncodeToString(t.Hash[:]))
+	}
+	return b.String()
+}
+
+// TokenSource loads the token list of the server, e.g. from a file updated by operators.
+type TokenSource func() ([]Token, error)
+
+// TokensFromFile loads the token list from the file at the provided path, see ParseTokens.
+func TokensFromFile(path string) TokenSource {
+	return func() ([]Token, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading token file: %w", err)
+		}
+		tokens, err := ParseTokens(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("parsing token file %q: %w", path, err)
+		}
+		return tokens, nil
+	}
+}
+
+// TokensFromEnv loads the token list from the environment variable of the provided name, see ParseTokens.
+func TokensFromEnv(name string) TokenSource {
+	return func() ([]Token, error) {
+		tokens, err := ParseTokens(os.Getenv(name))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		return tokens, nil
+	}
+}
+
+// Tokens are the auth tokens of the server: the fixed token it was created with, if any, and the tokens loaded
+// from its source. Safe for concurrent use.
+type Tokens struct {
+	source TokenSource // nil if no tokens are loaded
+
+	mu     sync.RWMutex
+	fixed  []Token
+	loaded []Token
+}
+
+// NewTokens returns the tokens loaded from the provided source, failing if none could be loaded.
+func NewTokens(source TokenSource) (*Tokens, error) {
+	t := &Tokens{source: source}
+	if _, err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload replaces the loaded tokens with the tokens of the source, returning the number of tokens. The previous
+// tokens are kept if the source fails or holds no tokens, such that operators are never locked out.
+func (t *Tokens) Reload() (int, error) {
+	if t.source == nil {
+		return 0, fmt.Errorf("no token source to reload from")
+	}
+	tokens, err := t.source()
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("token source holds no tokens")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loaded = tokens
+	return len(t.fixed) + len(t.loaded), nil
+}
+
+// List returns the tokens.
+func (t *Tokens) List() []Token {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append(append([]Token(nil), t.fixed...), t.loaded...)
+}
+
+// Authorize returns the label of the token matching the presented secret, and false if none matches. Every token
+// is compared in constant time, such that the time taken does not reveal which tokens are nearly matched.
+func (t *Tokens) Authorize(secret string) (string, bool) {
+	hash := sha256.Sum256([]byte(secret))
+	var label string
+	var authorized bool
+	for _, token := range t.List() {
+		if subtle.ConstantTimeCompare(hash[:], token.Hash[:]) == 1 && !authorized {
+			label, authorized = token.Label, true
+		}
+	}
+	return label, authorized
+}
+
+// authEnabled returns whether the server requires auth tokens, and serves the admin endpoints.
+func (s *Server) authEnabled() bool {
+	return s.tokens.source != nil || len(s.tokens.List()) > 0
+}
+
+// handleReloadTokens returns a handler that reloads the tokens of the server from their source, rewriting the
+// auth file, and responding with the number of tokens.
+func (s *Server) handleReloadTokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		n, err := s.tokens.Reload()
+		if err != nil {
+			logger.Warn("failed to reload tokens, keeping the previous tokens", zap.Error(err))
+			http.Error(w, fmt.Sprintf("reloading tokens: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+		logger.Info("reloaded tokens", zap.Int("tokens", n))
+		if !s.noAuthFile {
+			if err := s.SaveAuthPassword(); err != nil {
+				logger.Warn("unable to save auth file with reloaded tokens", zap.Error(err))
+			}
+		}
+
+		response, err := json.Marshal(rendezvous.TokenReload{Tokens: n})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("failed to marshal token reload", zap.Error(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response) //nolint:errcheck
+	}
+}
@@ -0,0 +1,109 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens("# operators\nalice=alice-token, bob = bob-token\n\n" + FormatTokens([]Token{NewToken("carol", "carol-token")}))
+	require.NoError(t, err)
+	assert.Equal(t, []Token{NewToken("alice", "alice-token"), NewToken("bob", "bob-token"), NewToken("carol", "carol-token")}, tokens)
+
+	for _, invalid := range []string{"alice", "=token", "alice=", "alice=a,alice=b", "alice=sha256:beef"} {
+		_, err := ParseTokens(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("alice=alice-token"), 0600))
+	tokens, err := NewTokens(TokensFromFile(path))
+	require.NoError(t, err)
+	label, ok := tokens.Authorize("alice-token")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", label)
+	_, ok = tokens.Authorize("bob-token")
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(path, []byte("bob=bob-token"), 0600))
+	n, err := tokens.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, ok = tokens.Authorize("alice-token")
+	assert.False(t, ok, "rotated tokens should be revoked")
+	_, ok = tokens.Authorize("bob-token")
+	assert.True(t, ok)
+
+	require.NoError(t, os.WriteFile(path, []byte("# nobody"), 0600))
+	_, err = tokens.Reload()
+	assert.Error(t, err)
+	_, ok = tokens.Authorize("bob-token")
+	assert.True(t, ok, "failed reloads should keep the previous tokens")
+
+	t.Setenv(AUTH_TOKENS_ENV, "carol=carol-token")
+	tokens, err = NewTokens(TokensFromEnv(AUTH_TOKENS_ENV))
+	require.NoError(t, err)
+	label, ok = tokens.Authorize("carol-token")
+	assert.True(t, ok)
+	assert.Equal(t, "carol", label)
+}
+
+func TestReloadTokens(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens")
+	authFile := filepath.Join(dir, "auth")
+	require.NoError(t, os.WriteFile(path, []byte("alice=alice-token"), 0600))
+	tokens, err := NewTokens(TokensFromFile(path))
+	require.NoError(t, err)
+	const token = "admin-token"
+	s := NewServer(0, token, semver.Version{}, WithAuthTokens(tokens), WithAuthFile(authFile))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	post := func(endpoint, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s%s", addr, endpoint), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, post("/admin/evict-idle?olderThan=1h", "alice-token").StatusCode)
+	require.Eventually(t, func() bool {
+		b, err := os.ReadFile(authFile)
+		return err == nil && string(b) == FormatTokens(s.tokens.List())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("bob=bob-token"), 0600))
+	resp := post("/admin/reload-tokens", "alice-token")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reload rendezvous.TokenReload
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reload))
+	assert.Equal(t, 2, reload.Tokens, "the token of the server should be kept alongside the reloaded tokens")
+
+	assert.Equal(t, http.StatusUnauthorized, post("/admin/evict-idle?olderThan=1h", "alice-token").StatusCode)
+	assert.Equal(t, http.StatusOK, post("/admin/evict-idle?olderThan=1h", "bob-token").StatusCode)
+	assert.Equal(t, http.StatusOK, post("/admin/evict-idle?olderThan=1h", token).StatusCode)
+	b, err := os.ReadFile(authFile)
+	require.NoError(t, err)
+	assert.Equal(t, FormatTokens([]Token{NewToken(DEFAULT_TOKEN_LABEL, token), NewToken("bob", "bob-token")}), string(b))
+	assert.NotContains(t, string(b), "bob-token", "tokens should be written hashed")
+}
@@ -0,0 +1,103 @@
+// tracing.go specifies the OpenTelemetry tracing of mailboxes, enabled with WithTracing.
+package rendezvous
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME is the name of the tracer of the rendezvous server.
+const TRACER_NAME = "github.com/SpatiumPortae/portal/internal/rendezvous"
+
+// Phases of a mailbox, traced as child spans of the mailbox span.
+const (
+	PHASE_REGISTER     = "register"
+	PHASE_PEER_CONNECT = "peer-connect"
+	PHASE_RELAY        = "relay"
+)
+
+// Outcomes of a mailbox, recorded on the mailbox span.
+const (
+	OUTCOME_COMPLETED        = "completed"
+	OUTCOME_CANCELED         = "canceled"
+	OUTCOME_FAILED           = "failed"
+	OUTCOME_RECEIVER_TIMEOUT = "receiver-timeout"
+	OUTCOME_SENDER_LOST      = "sender-lost"
+)
+
+// relayOutcome returns the outcome of a mailbox whose relay ended, from the close frames relayed between
+// its peers. Relays ending without a close frame signaling otherwise are completed.
+func relayOutcome(mailbox *Mailbox) string {
+	for _, status := range []*closeStatus{&mailbox.senderClose, &mailbox.receiverClose} {
+		code, _, ok := status.Get()
+		switch {
+		case !ok, code == conn.CLOSE_COMPLETED:
+		case code == conn.CLOSE_CANCELED:
+			return OUTCOME_CANCELED
+		default:
+			return OUTCOME_FAILED
+		}
+	}
+	return OUTCOME_COMPLETED
+}
+
+// mailboxTrace traces the lifecycle of a mailbox as a span, with a child span per phase.
+// All methods are no-ops on a nil trace, such that tracing is free when disabled.
+type mailboxTrace struct {
+	tracer trace.Tracer
+	ctx    context.Context
+	span   trace.Span
+	phase  trace.Span
+}
+
+// traceMailbox starts the trace of the mailbox of the sender request, continuing the trace context
+// propagated by the sender in the handshake headers. Returns nil if tracing is disabled.
+func (s *Server) traceMailbox(r *http.Request) *mailboxTrace {
+	if s.tracer == nil {
+		return nil
+	}
+	ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, "mailbox", trace.WithSpanKind(trace.SpanKindServer))
+	return &mailboxTrace{tracer: s.tracer, ctx: ctx, span: span}
+}
+
+// Phase ends the current phase of the mailbox, starting the provided phase.
+func (t *mailboxTrace) Phase(name string) {
+	if t == nil {
+		return
+	}
+	if t.phase != nil {
+		t.phase.End()
+	}
+	_, t.phase = t.tracer.Start(t.ctx, name)
+}
+
+// End ends the trace of the mailbox, recording its outcome and the bytes relayed.
+// The mailbox is nil if the sender never registered one.
+func (t *mailboxTrace) End(mailbox *Mailbox, outcome string) {
+	if t == nil {
+		return
+	}
+	if t.phase != nil {
+		t.phase.End()
+	}
+	t.span.SetAttributes(attribute.String("portal.outcome", outcome))
+	if mailbox != nil {
+		t.span.SetAttributes(
+			attribute.Int("portal.mailbox.id", mailbox.id),
+			attribute.Int64("portal.bytes_to_sender", mailbox.toSender.Load()),
+			attribute.Int64("portal.bytes_to_receiver", mailbox.toReceiver.Load()),
+		)
+	}
+	if outcome != OUTCOME_COMPLETED {
+		t.span.SetStatus(codes.Error, outcome)
+	}
+	t.span.End()
+}
@@ -0,0 +1,79 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/internal/receiver"
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/internal/sender"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	server := rendezvous.NewServer(0, "", semver.Version{}, rendezvous.WithTracing(tp))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go server.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	addr := fmt.Sprintf("localhost:%d", server.Addr().(*net.TCPAddr).Port)
+
+	// the sender propagates the trace context of its own span.
+	clientCtx, clientSpan := sdktrace.NewTracerProvider().Tracer("client").Start(ctx, "send")
+	defer clientSpan.End()
+
+	payload := []byte("A frog walks into a bank...")
+	rc, pass, err := sender.ConnectRendezvous(ctx, addr, conn.WithTraceContext(clientCtx))
+	require.NoError(t, err)
+	sent := make(chan error, 1)
+	go func() {
+		tc, err := sender.SecureConnection(ctx, rc, pass)
+		if err != nil {
+			sent <- err
+			return
+		}
+		sent <- sender.Transfer(ctx, tc, bytes.NewReader(payload), int64(len(payload)))
+	}()
+	rrc, err := receiver.ConnectRendezvous(addr)
+	require.NoError(t, err)
+	tc, err := receiver.SecureConnection(ctx, rrc, pass)
+	require.NoError(t, err)
+	require.NoError(t, receiver.Receive(ctx, tc, &bytes.Buffer{}))
+	require.NoError(t, <-sent)
+
+	// the mailbox span ends once the sender handler returns.
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 4 }, 5*time.Second, 10*time.Millisecond)
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	mailbox, ok := spans["mailbox"]
+	require.True(t, ok, "mailbox span should be recorded")
+	assert.Equal(t, clientSpan.SpanContext().TraceID(), mailbox.SpanContext.TraceID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), mailbox.Parent.SpanID())
+	for _, phase := range []string{rendezvous.PHASE_REGISTER, rendezvous.PHASE_PEER_CONNECT, rendezvous.PHASE_RELAY} {
+		span, ok := spans[phase]
+		require.True(t, ok, "%s span should be recorded", phase)
+		assert.Equal(t, mailbox.SpanContext.SpanID(), span.Parent.SpanID(), phase)
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range mailbox.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, rendezvous.OUTCOME_COMPLETED, attrs["portal.outcome"].AsString())
+	assert.Greater(t, attrs["portal.bytes_to_receiver"].AsInt64(), int64(len(payload)))
+	assert.Greater(t, attrs["portal.bytes_to_sender"].AsInt64(), int64(0))
+}
@@ -0,0 +1,228 @@
+// transferlog.go specifies the transfer log of the server, an append-only audit trail of the transfers relayed by
+// the server as line-delimited JSON, rotated once it exceeds its maximum size. The most recent transfers are kept
+// in memory to be queried by operators over /api/transfers.
+package rendezvous
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/logger"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+const (
+	// DEFAULT_TRANSFER_LOG_MAX_SIZE is the size in bytes after which the transfer log is rotated.
+	DEFAULT_TRANSFER_LOG_MAX_SIZE = 100 << 20
+	// TRANSFER_LOG_BACKUPS is the number of rotated transfer logs kept, as path.1 (the most recent) to path.N.
+	TRANSFER_LOG_BACKUPS = 5
+	// TRANSFER_LOG_RECENT is the number of the most recent transfers kept in memory to be queried.
+	TRANSFER_LOG_RECENT = 1000
+	// DEFAULT_TRANSFERS_LIMIT is the number of transfers responded with by /api/transfers without a limit.
+	DEFAULT_TRANSFERS_LIMIT = 100
+)
+
+// TransferLog appends the transfers of the server to a file as line-delimited JSON. Safe for concurrent use.
+type TransferLog struct {
+	path    string
+	maxSize int64
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	recent []rendezvous.Transfer // the most recent transfers, oldest first
+}
+
+// NewTransferLog opens the transfer log at path, appending to it if it exists. The log is rotated once it exceeds
+// maxSize bytes, a maxSize of 0 never rotates it.
+func NewTransferLog(path string, maxSize int64) (*TransferLog, error) {
+	l := &TransferLog{path: path, maxSize: maxSize}
+	if err := l.loadRecent(); err != nil {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends the transfer to the log, rotating the log first if the transfer would exceed its maximum size.
+func (l *TransferLog) Record(t rendezvous.Transfer) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("marshalling transfer: %w", err)
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remember(t)
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing transfer log: %w", err)
+	}
+	return nil
+}
+
+// Recent returns at most limit of the most recent transfers that ended after since, oldest first.
+func (l *TransferLog) Recent(limit int, since time.Time) []rendezvous.Transfer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	transfers := []rendezvous.Transfer{}
+	for i := len(l.recent) - 1; i >= 0 && len(transfers) < limit; i-- {
+		if !l.recent[i].Time.After(since) {
+			break
+		}
+		transfers = append(transfers, l.recent[i])
+	}
+	for i, j := 0, len(transfers)-1; i < j; i, j = i+1, j-1 {
+		transfers[i], transfers[j] = transfers[j], transfers[i]
+	}
+	return transfers
+}
+
+// Close closes the transfer log.
+func (l *TransferLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+func (l *TransferLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening transfer log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening transfer log: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the log to path.1, shifting the rotated logs up to TRANSFER_LOG_BACKUPS, and opens a new log.
+func (l *TransferLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("rotating transfer log: %w", err)
+	}
+	for i := TRANSFER_LOG_BACKUPS - 1; i >= 0; i-- {
+		from := l.path
+		if i > 0 {
+			from = l.path + "." + strconv.Itoa(i)
+		}
+		if err := os.Rename(from, l.path+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("rotating transfer log: %w", err)
+		}
+	}
+	return l.open()
+}
+
+// remember keeps the transfer in memory, forgetting the oldest transfer once TRANSFER_LOG_RECENT are kept.
+func (l *TransferLog) remember(t rendezvous.Transfer) {
+	if len(l.recent) == TRANSFER_LOG_RECENT {
+		copy(l.recent, l.recent[1:])
+		l.recent = l.recent[:len(l.recent)-1]
+	}
+	l.recent = append(l.recent, t)
+}
+
+// loadRecent reads the most recent transfers of an existing log, such that they can be queried across restarts.
+// Lines that are not transfers, e.g. a line cut off by a crash, are skipped.
+func (l *TransferLog) loadRecent() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading transfer log: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var t rendezvous.Transfer
+		if err := json.Unmarshal(scanner.Bytes(), &t); err == nil {
+			l.remember(t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading transfer log: %w", err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------- Server ------------------------------------------------------
+
+// logTransfer appends the summary of the transfer of the mailbox to the transfer log once it ended.
+func (s *Server) logTransfer(r *http.Request, mailbox *Mailbox, outcome string) {
+	if s.transferLog == nil {
+		return
+	}
+	t := rendezvous.Transfer{
+		Time:            time.Now(),
+		ID:              mailbox.id,
+		Sender:          s.loggedIP(identityFromRequest(r)),
+		State:           mailbox.State().String(),
+		Outcome:         outcome,
+		BytesToReceiver: mailbox.toReceiver.Load(),
+		BytesToSender:   mailbox.toSender.Load(),
+		DurationMS:      mailbox.Age().Milliseconds(),
+	}
+	if receiver, ok := mailbox.receiver.Load().(string); ok {
+		t.Receiver = s.loggedIP(receiver)
+	}
+	if err := s.transferLog.Record(t); err != nil {
+		s.logger.Warn("logging transfer", zap.Error(err))
+	}
+}
+
+// handleTransfers returns a handler responding with the most recent transfers of the transfer log, at most the
+// limit query parameter (DEFAULT_TRANSFERS_LIMIT by default) of the transfers that ended after the since query
+// parameter, an RFC 3339 timestamp.
+func (s *Server) handleTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger, err := logger.FromContext(r.Context())
+		if err != nil {
+			return
+		}
+		limit := DEFAULT_TRANSFERS_LIMIT
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		response, err := json.Marshal(s.transferLog.Recent(limit, since))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("failed to marshal transfers", zap.Error(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response) //nolint:errcheck
+	}
+}
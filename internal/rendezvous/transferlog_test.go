@@ -0,0 +1,128 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferLog(t *testing.T) {
+	t.Run("rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "transfers.log")
+		l, err := NewTransferLog(path, 1)
+		require.NoError(t, err)
+		for id := 1; id <= TRANSFER_LOG_BACKUPS+2; id++ {
+			require.NoError(t, l.Record(rendezvous.Transfer{ID: id, Time: time.Now()}))
+		}
+		require.NoError(t, l.Close())
+
+		read := func(path string) rendezvous.Transfer {
+			b, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var transfer rendezvous.Transfer
+			require.NoError(t, json.Unmarshal(b, &transfer))
+			return transfer
+		}
+		assert.Equal(t, TRANSFER_LOG_BACKUPS+2, read(path).ID)
+		assert.Equal(t, TRANSFER_LOG_BACKUPS+1, read(path+".1").ID)
+		assert.Equal(t, 2, read(fmt.Sprintf("%s.%d", path, TRANSFER_LOG_BACKUPS)).ID)
+		assert.NoFileExists(t, fmt.Sprintf("%s.%d", path, TRANSFER_LOG_BACKUPS+1))
+	})
+
+	t.Run("recent transfers survive restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "transfers.log")
+		l, err := NewTransferLog(path, 0)
+		require.NoError(t, err)
+		start := time.Now()
+		for id := 1; id <= 3; id++ {
+			require.NoError(t, l.Record(rendezvous.Transfer{ID: id, Time: start.Add(time.Duration(id) * time.Second)}))
+		}
+		require.NoError(t, l.Close())
+
+		l, err = NewTransferLog(path, 0)
+		require.NoError(t, err)
+		defer l.Close()
+		recent := l.Recent(2, time.Time{})
+		require.Len(t, recent, 2)
+		assert.Equal(t, 2, recent[0].ID)
+		assert.Equal(t, 3, recent[1].ID)
+		recent = l.Recent(DEFAULT_TRANSFERS_LIMIT, start.Add(2*time.Second))
+		require.Len(t, recent, 1)
+		assert.Equal(t, 3, recent[0].ID)
+	})
+
+	t.Run("queried by operators", func(t *testing.T) {
+		// the server saves its auth token to the working directory.
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(t.TempDir()))
+		t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+		l, err := NewTransferLog("transfers.log", DEFAULT_TRANSFER_LOG_MAX_SIZE)
+		require.NoError(t, err)
+		defer l.Close()
+		const token = "admin-token"
+		s := NewServer(0, token, semver.Version{}, WithTransferLog(l))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		go s.Run(ctx) //nolint:errcheck
+		require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+		addr := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+		payload := bytes.Repeat([]byte("audited "), 1000)
+		config := portal.Config{RendezvousAddr: addr}
+		pass, err, errC := portal.Send(ctx, bytes.NewReader(payload), int64(len(payload)), &config)
+		require.NoError(t, err)
+		var received bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, &received, pass, &config))
+		require.NoError(t, <-errC)
+
+		query := func(auth string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/transfers?limit=10", addr), nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", auth)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			return resp
+		}
+		resp := query("Bearer wrong-token")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		var transfers []rendezvous.Transfer
+		require.Eventually(t, func() bool {
+			resp := query("Bearer " + token)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&transfers))
+			return len(transfers) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		transfer := transfers[0]
+		assert.Equal(t, rendezvous.Transfer{
+			Time:            transfer.Time,
+			ID:              transfer.ID,
+			Sender:          "127.0.0.1",
+			Receiver:        "127.0.0.1",
+			State:           MailboxRelaying.String(),
+			Outcome:         OUTCOME_COMPLETED,
+			BytesToReceiver: transfer.BytesToReceiver,
+			BytesToSender:   transfer.BytesToSender,
+			DurationMS:      transfer.DurationMS,
+		}, transfer)
+		// peers on the same host transfer directly, only the key exchange is relayed.
+		assert.Positive(t, transfer.BytesToReceiver)
+	})
+}
@@ -0,0 +1,90 @@
+// waiting.go specifies the receivers waiting for a sender to claim their password, such that receivers knowing
+// the code before the sender registered can connect first, making the order of senders and receivers irrelevant.
+package rendezvous
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+	"go.uber.org/zap"
+)
+
+// waitingReceivers tracks the passwords receivers are waiting for a sender to claim. Safe for concurrent use.
+type waitingReceivers struct {
+	mu        sync.Mutex
+	passwords map[string]*waitingPassword
+}
+
+// waitingPassword is a password awaited by receivers, claimed is closed once a sender claims it.
+type waitingPassword struct {
+	claimed   chan struct{}
+	receivers int
+}
+
+// wait registers a receiver waiting for the password, returning a channel closed once a sender claims the
+// password. The receiver is unregistered by calling the returned function.
+func (w *waitingReceivers) wait(password string) (<-chan struct{}, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.passwords == nil {
+		w.passwords = make(map[string]*waitingPassword)
+	}
+	p, ok := w.passwords[password]
+	if !ok {
+		p = &waitingPassword{claimed: make(chan struct{})}
+		w.passwords[password] = p
+	}
+	p.receivers++
+	return p.claimed, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		p.receivers--
+		if p.receivers == 0 && w.passwords[password] == p {
+			delete(w.passwords, password)
+		}
+	}
+}
+
+// claim signals the receivers waiting for the password that a sender claimed it.
+func (w *waitingReceivers) claim(password string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p, ok := w.passwords[password]; ok {
+		close(p.claimed)
+		delete(w.passwords, password)
+	}
+}
+
+// awaitSender holds the receiver until a sender claims the password, returning the mailbox of the sender.
+// Receivers waiting longer than RECEIVER_CONNECT_TIMEOUT are told that the code expired.
+func (s *Server) awaitSender(ctx context.Context, c conn.Conn, password string, logger *zap.Logger) (*Mailbox, bool) {
+	claimed, done := s.waiting.wait(password)
+	defer done()
+	// the password may have been claimed since the mailbox was looked up.
+	if mailbox, err := s.mailboxes.GetMailbox(password); err == nil {
+		return mailbox, true
+	}
+	logger.Info("waiting for sender")
+	timeout := time.NewTimer(RECEIVER_CONNECT_TIMEOUT)
+	defer timeout.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case <-timeout.C:
+		logger.Warn("waiting for sender timed out")
+		c.Close(conn.CLOSE_FAILED, rendezvous.CODE_EXPIRED) //nolint:errcheck
+		return nil, false
+	case <-claimed:
+	}
+	mailbox, err := s.mailboxes.GetMailbox(password)
+	if err != nil {
+		logger.Warn("mailbox of claiming sender deallocated")
+		c.Close(conn.CLOSE_FAILED, rendezvous.CODE_EXPIRED) //nolint:errcheck
+		return nil, false
+	}
+	logger.Info("sender claimed password")
+	return mailbox, true
+}
@@ -9,12 +9,17 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
 )
 
 const pattern = `^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)$`
 
 var ErrParse = errors.New("could not parse provided string into semantic version")
 
+// ErrIncompatible is returned when the version of portal is incompatible with the version of the rendezvous server.
+var ErrIncompatible = errors.New("incompatible")
+
 type Comparison int
 
 const (
@@ -85,12 +90,22 @@ func (sv Version) Compare(oracle Version) Comparison {
 	}
 }
 
-func GetRendezvousVersion(ctx context.Context, addr string) (Version, error) {
-	r, err := http.Get(fmt.Sprintf("http://%s/version", addr))
+// GetRendezvousVersion fetches the version of the rendezvous server at the provided address
+// using the provided HTTP client.
+func GetRendezvousVersion(ctx context.Context, client *http.Client, addr string) (Version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conn.HTTPURL(addr, "/version"), nil)
+	if err != nil {
+		return Version{}, fmt.Errorf("creating version request: %w", err)
+	}
+	r, err := client.Do(req)
 	if err != nil {
 		return Version{}, fmt.Errorf("fetching the latest version from relay: %w", err)
 	}
 	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return Version{}, fmt.Errorf("fetching the latest version from relay: unexpected status %s", r.Status)
+	}
+	// unknown fields are ignored, such that clients stay compatible with relays announcing more about their version.
 	var version Version
 	if err := json.NewDecoder(r.Body).Decode(&version); err != nil {
 		return Version{}, fmt.Errorf("decoding version response from relay: %w", err)
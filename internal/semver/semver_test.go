@@ -1,6 +1,10 @@
 package semver_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/SpatiumPortae/portal/internal/semver"
@@ -91,3 +95,42 @@ func TestCompare(t *testing.T) {
 		assert.Equal(t, semver.CompareEqual, sv.Compare(oracle))
 	})
 }
+
+func TestGetRendezvousVersion(t *testing.T) {
+	serve := func(t *testing.T, status int, body string) string {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body)) //nolint:errcheck
+		}))
+		t.Cleanup(srv.Close)
+		return strings.TrimPrefix(srv.URL, "http://")
+	}
+	ctx := context.Background()
+
+	t.Run("valid", func(t *testing.T) {
+		ver, err := semver.GetRendezvousVersion(ctx, http.DefaultClient, serve(t, http.StatusOK, `{"major":1,"minor":2,"patch":3}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.2.3", ver.String())
+	})
+	t.Run("garbage", func(t *testing.T) {
+		_, err := semver.GetRendezvousVersion(ctx, http.DefaultClient, serve(t, http.StatusOK, "<html>hello</html>"))
+		assert.Error(t, err)
+	})
+	t.Run("unknown fields", func(t *testing.T) {
+		ver, err := semver.GetRendezvousVersion(ctx, http.DefaultClient, serve(t, http.StatusOK, `{"major":1,"minor":2,"patch":3,"capabilities":["drops"]}`))
+		assert.NoError(t, err, "fields added by newer relays are ignored")
+		assert.Equal(t, "v1.2.3", ver.String())
+	})
+	t.Run("not found", func(t *testing.T) {
+		_, err := semver.GetRendezvousVersion(ctx, http.DefaultClient, serve(t, http.StatusNotFound, `{}`))
+		assert.Error(t, err)
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		srv.Close()
+		_, err := semver.GetRendezvousVersion(ctx, http.DefaultClient, addr)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,56 @@
+package sender
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/rendezvous"
+)
+
+// Drop seals the payload of payloadSize bytes with a random key and uploads it to the rendezvous server as a drop,
+// returning the code to collect it with along with the time it expires. The code carries the key, such that the
+// rendezvous server never learns the contents of the payload.
+func Drop(ctx context.Context, client *http.Client, addr string, payload io.Reader, payloadSize int64) (string, time.Time, error) {
+	key := make([]byte, conn.SEAL_KEY_BYTES)
+	if _, err := rand.Read(key); err != nil {
+		return "", time.Time{}, err
+	}
+	sealed, w := io.Pipe()
+	go func() {
+		w.CloseWithError(conn.Seal(w, payload, key))
+	}()
+	defer sealed.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, conn.HTTPURL(addr, "/drops"), sealed)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.ContentLength = conn.SealedSize(payloadSize)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("uploading drop: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", time.Time{}, fmt.Errorf("rendezvous server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var drop rendezvous.Drop
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&drop); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding drop: %w", err)
+	}
+	// the expiry is told on the clock of the sender, like that of resumption state.
+	expires := drop.Expires
+	if drop.ExpiresIn > 0 {
+		expires = time.Now().Add(drop.ExpiresIn)
+	}
+	return drop.ID + "." + base64.RawURLEncoding.EncodeToString(key), expires, nil
+}
@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"context"
 	crypto_rand "crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
+	"github.com/SpatiumPortae/portal/internal/checksum"
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/internal/password"
 	"github.com/SpatiumPortae/portal/protocol/rendezvous"
@@ -18,47 +21,191 @@ import (
 const MAX_CHUNK_BYTES = 1e6
 const MAX_SEND_CHUNKS = 2e8
 
+// RESUME_ATTEMPTS is the number of times a lost connection to the rendezvous server is resumed during a relayed transfer.
+const RESUME_ATTEMPTS = 3
+
+// RESUME_DELAY is the time waited before resuming a lost connection to the rendezvous server.
+const RESUME_DELAY = 500 * time.Millisecond
+
 // ConnectRendezvous creates a connection with the rendezvous server and acquires a password associated with the connection
-func ConnectRendezvous(ctx context.Context, addr string) (conn.Rendezvous, string, error) {
-	ws, _, err := websocket.Dial(context.Background(), fmt.Sprintf("ws://%s/establish-sender", addr), nil)
+func ConnectRendezvous(ctx context.Context, addr string, opts ...conn.DialOption) (conn.Rendezvous, string, error) {
+	return ConnectRendezvousExpiring(ctx, addr, 0, opts...)
+}
+
+// ConnectRendezvousExpiring is ConnectRendezvous with a password that expires after the provided duration unless
+// a receiver connected, bound by the receiver connect timeout of the rendezvous server. Zero does not expire early.
+func ConnectRendezvousExpiring(ctx context.Context, addr string, expireAfter time.Duration, opts ...conn.DialOption) (conn.Rendezvous, string, error) {
+	return ConnectRendezvousGenerating(ctx, addr, expireAfter, password.Generator{}, opts...)
+}
+
+// ConnectRendezvousGenerating is ConnectRendezvousExpiring with a password generated by the provided generator.
+func ConnectRendezvousGenerating(ctx context.Context, addr string, expireAfter time.Duration, gen password.Generator, opts ...conn.DialOption) (conn.Rendezvous, string, error) {
+	if err := gen.Validate(); err != nil {
+		return conn.Rendezvous{}, "", fmt.Errorf("invalid password generator: %w", err)
+	}
+	return connectRendezvous(ctx, addr, "", "", expireAfter, gen, opts...)
+}
+
+// ConnectRendezvousClaiming is ConnectRendezvousExpiring with a password chosen by the sender rather than acquired
+// from the rendezvous server, such that receivers knowing the password can connect before the sender. Returns
+// a ErrCodeInUse from the handshake if another sender holds the password.
+func ConnectRendezvousClaiming(ctx context.Context, addr, pass string, expireAfter time.Duration, opts ...conn.DialOption) (conn.Rendezvous, error) {
+	if !password.IsValid(pass) {
+		return conn.Rendezvous{}, errors.New("invalid password format")
+	}
+	rc, _, err := connectRendezvous(ctx, addr, pass, "", expireAfter, password.Generator{}, opts...)
+	return rc, err
+}
+
+// ConnectRendezvousJoining establishes a further mailbox for the password of the session of the sender the
+// token was issued to, see conn.Rendezvous.Token, such that several receivers receive the same payload with the
+// password. Each connection is secured and transferred over by the sender like a connection of its own.
+func ConnectRendezvousJoining(ctx context.Context, addr, pass, token string, expireAfter time.Duration, opts ...conn.DialOption) (conn.Rendezvous, error) {
+	if token == "" {
+		return conn.Rendezvous{}, errors.New("joining a mailbox requires a session token")
+	}
+	rc, _, err := connectRendezvous(ctx, addr, pass, token, expireAfter, password.Generator{}, opts...)
+	return rc, err
+}
+
+// connectRendezvous connects to the rendezvous server, claiming the provided password or, if empty, a password
+// generated by gen for the id bound by the rendezvous server. The mailbox of the session the join token was issued
+// to is joined, if provided.
+func connectRendezvous(ctx context.Context, addr, pass, join string, expireAfter time.Duration, gen password.Generator, opts ...conn.DialOption) (conn.Rendezvous, string, error) {
+	ws, err := conn.Dial(context.Background(), conn.WebsocketURL(addr, "/establish-sender"), opts...)
 	if err != nil {
 		return conn.Rendezvous{}, "", err
 	}
 
-	rc := conn.Rendezvous{Conn: &conn.WS{Conn: ws}}
+	rc := conn.Rendezvous{Conn: ws}
 
 	msg, err := rc.ReadMsg(ctx, rendezvous.RendezvousToSenderBind)
 	if err != nil {
 		return conn.Rendezvous{}, "", err
 	}
-	pass, err := password.Generate(msg.Payload.ID)
-	if err != nil {
-		return conn.Rendezvous{}, "", err
+	if pass == "" {
+		if pass, err = gen.Generate(msg.Payload.ID); err != nil {
+			return conn.Rendezvous{}, "", err
+		}
 	}
 
 	if err := rc.WriteMsg(ctx, rendezvous.Msg{
 		Type: rendezvous.SenderToRendezvousEstablish,
 		Payload: rendezvous.Payload{
-			Password: password.Hashed(pass),
+			Password:    password.Hashed(pass),
+			ExpireAfter: expireAfter,
+			Join:        join,
 		},
 	}); err != nil {
 		return conn.Rendezvous{}, "", err
 	}
+	// rendezvous servers that predate resumption issue no session token.
+	if token := msg.Payload.Token; token != "" {
+		rc.Token = token
+		rc.Redial = redialRendezvous(addr, password.Hashed(pass), token, opts...)
+	}
 	return rc, string(pass), nil
 }
 
+// redialRendezvous returns a function that resumes a lost connection to the rendezvous server,
+// re-presenting the mailbox and session token of the sender.
+func redialRendezvous(addr, hashed, token string, opts ...conn.DialOption) func(context.Context) (conn.Conn, error) {
+	return func(ctx context.Context) (conn.Conn, error) {
+		ws, err := conn.Dial(ctx, conn.WebsocketURL(addr, "/resume-sender"), opts...)
+		if err != nil {
+			return nil, err
+		}
+		rc := conn.Rendezvous{Conn: ws}
+		if err := rc.WriteMsg(ctx, rendezvous.Msg{
+			Type: rendezvous.SenderToRendezvousResume,
+			Payload: rendezvous.Payload{
+				Password: hashed,
+				Token:    token,
+			},
+		}); err != nil {
+			ws.Close(conn.CloseStatus(err)) //nolint:errcheck
+			return nil, err
+		}
+		if _, err := rc.ReadMsg(ctx, rendezvous.RendezvousToSenderResumed); err != nil {
+			ws.Close(conn.CloseStatus(err)) //nolint:errcheck
+			return nil, err
+		}
+		return ws, nil
+	}
+}
+
+// ErrUnsupportedCodec is returned when the receiver cannot decompress the codec of the payload.
+var ErrUnsupportedCodec = errors.New("receiver does not support the compression codec")
+
+// ErrUnsupportedFormat is returned when the receiver cannot unpack a packaging format of the payload.
+var ErrUnsupportedFormat = errors.New("receiver does not support the packaging format")
+
+// ErrCodeInUse is returned when claiming a password another sender holds.
+var ErrCodeInUse = errors.New(rendezvous.CODE_IN_USE)
+
+// ErrReceiverRejected is returned when the sender rejects the connected receiver.
+var ErrReceiverRejected = errors.New("receiver rejected by sender")
+
+// errHandshakeRestarted is returned when the rendezvous server restarts the key exchange.
+var errHandshakeRestarted = errors.New("receiver disconnected during the key exchange")
+
+// HandshakeOption configures the cryptographic handshake performed by SecureConnection.
+type HandshakeOption func(*handshakeOptions)
+
+type handshakeOptions struct {
+	kdfIterations int
+}
+
+// WithKDFIterations derives the cryptographic key using the provided number of iterations, raised to the
+// minimum advertised by the rendezvous server. Defaults to conn.DEFAULT_KDF_ITERATIONS.
+func WithKDFIterations(iterations int) HandshakeOption {
+	return func(o *handshakeOptions) {
+		o.kdfIterations = iterations
+	}
+}
+
 // SecureConnection does the cryptographic handshake in order to resolve a secure channel to do file transfer over.
-func SecureConnection(ctx context.Context, rc conn.Rendezvous, password string) (conn.Transfer, error) {
+// If the receiver disconnects during the handshake, the key exchange is restarted from scratch with a new receiver.
+func SecureConnection(ctx context.Context, rc conn.Rendezvous, password string, opts ...HandshakeOption) (conn.Transfer, error) {
+	o := handshakeOptions{kdfIterations: conn.DEFAULT_KDF_ITERATIONS}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := conn.ValidateKDFIterations(o.kdfIterations); err != nil {
+		return conn.Transfer{}, err
+	}
+	for {
+		tc, err := secureConnection(ctx, rc, password, o)
+		if errors.Is(err, errHandshakeRestarted) {
+			continue
+		}
+		return tc, err
+	}
+}
+
+func secureConnection(ctx context.Context, rc conn.Rendezvous, password string, opts handshakeOptions) (conn.Transfer, error) {
 	p, err := pake.InitCurve([]byte(password), 0, "p256")
 	if err != nil {
 		return conn.Transfer{}, err
 	}
 
 	// Wait for for the receiver to be ready.
-	_, err = rc.ReadMsg(ctx, rendezvous.RendezvousToSenderReady)
+	msg, err := rc.ReadMsg(ctx, rendezvous.RendezvousToSenderReady)
 	if err != nil {
+		var closeErr websocket.CloseError
+		if errors.As(err, &closeErr) && closeErr.Reason == rendezvous.CODE_IN_USE {
+			return conn.Transfer{}, ErrCodeInUse
+		}
 		return conn.Transfer{}, err
 	}
+	// Negotiate the key derivation parameters, using at least the minimum accepted by the rendezvous server.
+	iterations := opts.kdfIterations
+	if msg.Payload.KDFIterations > iterations {
+		iterations = msg.Payload.KDFIterations
+	}
+	if err := conn.ValidateKDFIterations(iterations); err != nil {
+		return conn.Transfer{}, fmt.Errorf("negotiating key derivation parameters: %w", err)
+	}
 
 	// Start the key exchange.
 	err = rc.WriteMsg(ctx, rendezvous.Msg{
@@ -71,10 +218,13 @@ func SecureConnection(ctx context.Context, rc conn.Rendezvous, password string)
 		return conn.Transfer{}, err
 	}
 
-	msg, err := rc.ReadMsg(ctx)
+	msg, err = rc.ReadMsg(ctx, rendezvous.RendezvousToSenderPAKE, rendezvous.RendezvousToSenderRestart)
 	if err != nil {
 		return conn.Transfer{}, err
 	}
+	if msg.Type == rendezvous.RendezvousToSenderRestart {
+		return conn.Transfer{}, errHandshakeRestarted
+	}
 
 	if err := p.Update(msg.Payload.Bytes); err != nil {
 		return conn.Transfer{}, err
@@ -94,59 +244,457 @@ func SecureConnection(ctx context.Context, rc conn.Rendezvous, password string)
 	err = rc.WriteMsg(ctx, rendezvous.Msg{
 		Type: rendezvous.SenderToRendezvousSalt,
 		Payload: rendezvous.Payload{
-			Salt: salt,
+			Salt:          salt,
+			KDFIterations: iterations,
 		},
 	})
 	if err != nil {
 		return conn.Transfer{}, err
 	}
 
-	return conn.TransferFromSession(rc.Conn, session, salt), nil
+	tc := conn.TransferFromSession(rc.Conn, session, salt, iterations)
+	tc.Redial = rc.Redial
+	return tc, nil
+}
+
+// ConfirmReceiver asks for approval of the receiver of the secured connection, identified by the fingerprint
+// of the connection, before any of the payload is sent. A receiver that is not approved is disconnected,
+// returning a ErrReceiverRejected.
+func ConfirmReceiver(tc conn.Transfer, confirm func(fingerprint string) (bool, error)) error {
+	approved, err := confirm(tc.Fingerprint())
+	if err == nil && !approved {
+		err = ErrReceiverRejected
+	}
+	if err != nil {
+		conn.CloseWithError(tc.Conn, err) //nolint:errcheck
+		return err
+	}
+	return nil
+}
+
+// ResumeFunc repacks the payload to resume a transfer interrupted in a previous session, returning the
+// repacked payload and its size.
+type ResumeFunc func(resume transfer.Resume) (io.Reader, int64, error)
+
+// Selection lists the files of the payload receivers can select from, and repacks the payload with only the
+// files selected by the receiver, returning the repacked payload and its size.
+type Selection struct {
+	Manifest []transfer.ManifestFile
+	Repack   func(selected []string) (io.Reader, int64, error)
+}
+
+// Compression repacks the payload with the codec negotiated with the receiver, returning the repacked payload and
+// its size. RawSize is the size of the files of the payload before compression, zero if unknown. A nil Repack
+// announces the compression of the payload without negotiating the codec.
+type Compression struct {
+	RawSize int64
+	Repack  func(codec string) (io.Reader, int64, error)
+}
+
+// TransferOption configures the file transfer performed by Transfer.
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	codec       string
+	compression *Compression
+	formats     []string
+	streams     Streams
+	resume      ResumeFunc
+	transferID  string
+	checksum    string
+	selection   *Selection
+	chunkSize   int64
+	transport   string
+	msgs        []chan interface{}
+}
+
+// WithCodec sends a payload compressed with the provided codec. Transfers to receivers that cannot decompress the
+// codec fail with a ErrUnsupportedCodec before the payload is sent.
+func WithCodec(codec string) TransferOption {
+	return func(o *transferOptions) {
+		o.codec = codec
+	}
+}
+
+// WithCompression sends the payload repacked by compression with the first of transfer.AUTO_CODECS the receiver can
+// decompress if it differs from the codec of WithCodec. The codec and the raw size of the payload are announced to
+// the receiver, and reported as transfer.Compression on the messages of the transfer.
+func WithCompression(compression *Compression) TransferOption {
+	return func(o *transferOptions) {
+		o.compression = compression
+	}
+}
+
+// WithFormats sends a payload holding objects packed in the provided packaging formats, see transfer.Formats.
+// Transfers to receivers that cannot unpack one of the formats fail with a ErrUnsupportedFormat before the payload
+// is sent.
+func WithFormats(formats ...string) TransferOption {
+	return func(o *transferOptions) {
+		o.formats = formats
+	}
+}
+
+// WithStreams splits a relayed payload over up to streams.Count parallel streams if the receiver accepts them. Only
+// payloads implementing io.ReaderAt are split, and payloads split over parallel streams are not resumed if a
+// connection is lost.
+func WithStreams(streams Streams) TransferOption {
+	return func(o *transferOptions) {
+		o.streams = streams
+	}
+}
+
+// WithResume sends the payload repacked with resume instead if the receiver asks to resume a transfer interrupted
+// in a previous session.
+func WithResume(resume ResumeFunc) TransferOption {
+	return func(o *transferOptions) {
+		o.resume = resume
+	}
+}
+
+// WithTransferID announces the id of the payload to the receiver, identifying it across sessions. Receivers
+// resuming a transfer of another id are sent the payload in full, see transfer.Resume.
+func WithTransferID(id string) TransferOption {
+	return func(o *transferOptions) {
+		o.transferID = id
+	}
+}
+
+// WithChecksum proves the integrity of the payload to the receiver with a checksum of the provided algorithm, one of
+// transfer.Checksums, defaults to transfer.CHECKSUM_SHA256. Receivers that cannot verify the algorithm are sent a
+// SHA-256 checksum instead, and receivers predating checksums no checksum.
+func WithChecksum(algorithm string) TransferOption {
+	return func(o *transferOptions) {
+		o.checksum = algorithm
+	}
+}
+
+// WithSelection sends the manifest of the selection to receivers asking to select the files they accept, and the
+// payload repacked with the selected files. Without a selection receivers requiring one fail the transfer.
+func WithSelection(selection *Selection) TransferOption {
+	return func(o *transferOptions) {
+		o.selection = selection
+	}
+}
+
+// WithChunkSize sends the payload in chunks of the provided size rather than the size proposed by the receiver,
+// clamped to the negotiable bounds. A chunk size of transfer.CHUNK_SIZE_ADAPTIVE adapts the chunks to the throughput
+// of the link, reporting the chunk size as transfer.ChunkStats on the messages of the transfer, and a chunk size of 0
+// negotiates the chunk size with the receiver. Payloads split over parallel streams or sequenced are sent in chunks
+// of a fixed size.
+func WithChunkSize(size int64) TransferOption {
+	return func(o *transferOptions) {
+		o.chunkSize = size
+	}
+}
+
+// WithTransport accepts direct connections of the receiver over the provided transport, one of transfer.Transports,
+// rather than transfer.TRANSPORT_AUTO. Transfers with receivers that cannot connect over it are relayed.
+func WithTransport(transport string) TransferOption {
+	return func(o *transferOptions) {
+		o.transport = transport
+	}
+}
+
+// WithMessages communicates information about the transfer on msgs while running, e.g. the bytes sent so far.
+func WithMessages(msgs chan interface{}) TransferOption {
+	return func(o *transferOptions) {
+		o.msgs = []chan interface{}{msgs}
+	}
 }
 
 // Transfer performs the file transfer, either directly or using the Rendezvous server as a relay.
-func Transfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) error {
-	return doTransfer(ctx, tc, payload, payloadSize, msgs...)
+// The connection is closed with a close code describing how the transfer ended.
+func Transfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, opts ...TransferOption) error {
+	options := transferOptions{checksum: transfer.CHECKSUM_SHA256, transport: transfer.TRANSPORT_AUTO}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	err := transfer.ValidateChecksum(options.checksum)
+	if err == nil {
+		err = transfer.ValidateTransport(options.transport)
+	}
+	if err == nil {
+		// the connection is replaced if it is resumed during the transfer.
+		err = doTransfer(ctx, &tc, payload, payloadSize, options)
+	}
+	conn.CloseWithError(tc.Conn, err) //nolint:errcheck
+	return err
+}
+
+// resumePayload returns the payload repacked with resume if the receiver handshake asks to resume a transfer of the
+// payload interrupted in a previous session, and the payload as is otherwise. Transfers recorded without an id, by
+// receivers or senders predating transfer ids, are resumed by the digests of the kept files alone.
+func resumePayload(handshake transfer.Msg, payload io.Reader, payloadSize int64, resume ResumeFunc, transferID string) (io.Reader, int64, error) {
+	if resume == nil || handshake.Payload.Resume == nil {
+		return payload, payloadSize, nil
+	}
+	if id := handshake.Payload.Resume.ID; id != "" && transferID != "" && id != transferID {
+		return payload, payloadSize, nil
+	}
+	resumed, size, err := resume(*handshake.Payload.Resume)
+	if err != nil {
+		return nil, 0, fmt.Errorf("resuming transfer: %w", err)
+	}
+	return resumed, size, nil
+}
+
+// selectPayload sends the manifest of the selection to receivers asking to select the files they accept, returning
+// the payload repacked with the files selected by the receiver, or the payload as is if every file is selected.
+func selectPayload(ctx context.Context, tc conn.Transfer, handshake transfer.Msg, payload io.Reader, payloadSize int64, selection *Selection) (io.Reader, int64, error) {
+	if selection == nil || !handshake.Payload.Select {
+		return payload, payloadSize, nil
+	}
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.SenderManifest,
+		Payload: transfer.Payload{Manifest: selection.Manifest},
+	}); err != nil {
+		return nil, 0, err
+	}
+	msg, err := tc.ReadMsg(ctx, transfer.ReceiverSelection)
+	if err != nil {
+		return nil, 0, err
+	}
+	listed := make(map[string]bool, len(selection.Manifest))
+	for _, f := range selection.Manifest {
+		listed[f.Name] = true
+	}
+	selected := make(map[string]bool, len(msg.Payload.Selected))
+	for _, name := range msg.Payload.Selected {
+		if !listed[name] {
+			return nil, 0, fmt.Errorf("receiver selected %q, which is not in the manifest", name)
+		}
+		selected[name] = true
+	}
+	switch {
+	case len(selected) == 0:
+		return nil, 0, errors.New("receiver selected no files")
+	case len(selected) == len(listed):
+		return payload, payloadSize, nil
+	}
+	repacked, size, err := selection.Repack(msg.Payload.Selected)
+	if err != nil {
+		return nil, 0, fmt.Errorf("repacking selected files: %w", err)
+	}
+	return repacked, size, nil
+}
+
+// compressPayload returns the payload repacked with the codec negotiated with a receiver advertising the provided
+// codecs along with the codec, or the payload as is if already compressed with it.
+func compressPayload(advertised []string, payload io.Reader, payloadSize int64, codec string, compression *Compression) (io.Reader, int64, string, error) {
+	if compression == nil || compression.Repack == nil {
+		return payload, payloadSize, codec, nil
+	}
+	negotiated, err := transfer.NegotiateCodec(transfer.AUTO_CODECS, advertised)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("%w: %w", ErrUnsupportedCodec, err)
+	}
+	if negotiated != codec {
+		repacked, size, err := compression.Repack(negotiated)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("repacking payload with %s: %w", negotiated, err)
+		}
+		payload, payloadSize, codec = repacked, size, negotiated
+	}
+	return payload, payloadSize, codec, nil
+}
+
+// announceCompression returns the raw size of the payload announced to the receiver, reporting the compression of
+// the payload on msgs. The raw size is unknown once the payload was repacked for a resumed or selective transfer.
+func announceCompression(compression *Compression, codec string, repacked bool, msgs ...chan interface{}) int64 {
+	if compression == nil {
+		return 0
+	}
+	var rawSize int64
+	if !repacked {
+		rawSize = compression.RawSize
+	}
+	if len(msgs) > 0 {
+		msgs[0] <- transfer.Compression{Codec: codec, RawSize: rawSize}
+	}
+	return rawSize
+}
+
+// checkCodec checks that a receiver advertising the provided codecs can decompress the payload codec.
+func checkCodec(advertised []string, codec string) error {
+	if codec == "" || transfer.SupportsCodec(advertised, codec) {
+		return nil
+	}
+	return fmt.Errorf("%w '%s'", ErrUnsupportedCodec, codec)
+}
+
+// checkFormats returns a ErrUnsupportedFormat if the receiver advertising the provided formats cannot unpack
+// one of the formats of the payload.
+func checkFormats(advertised []string, formats []string) error {
+	for _, format := range formats {
+		if !transfer.SupportsFormat(advertised, format) {
+			return fmt.Errorf("%w '%s'", ErrUnsupportedFormat, format)
+		}
+	}
+	return nil
 }
 
 // transferSequence is a helper method that actually performs the transfer sequence.
-func transferSequence(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) error {
-	_, err := tc.ReadMsg(ctx, transfer.ReceiverRequestPayload)
+// If the connection is lost while sending a seekable payload over a resumable connection,
+// the connection is resumed and the payload is sent from the offset received by the receiver.
+// Payloads sent over a single stream migrate to the first direct connection received on migrations, if any.
+func transferSequence(ctx context.Context, tc *conn.Transfer, payload io.Reader, payloadSize int64, streams Streams, algorithm string, configuredChunkSize int64, migrations <-chan conn.Conn, msgs ...chan interface{}) error {
+	msg, err := tc.ReadMsg(ctx, transfer.ReceiverRequestPayload)
 	if err != nil {
 		return err
 	}
+	return sendRequested(ctx, tc, msg, payload, payloadSize, streams, algorithm, configuredChunkSize, migrations, msgs...)
+}
 
+// sendRequested performs the transfer sequence once the receiver requested the payload with msg, see
+// transferSequence.
+func sendRequested(ctx context.Context, tc *conn.Transfer, msg transfer.Msg, payload io.Reader, payloadSize int64, streams Streams, algorithm string, configuredChunkSize int64, migrations <-chan conn.Conn, msgs ...chan interface{}) error {
 	if len(msgs) > 0 {
 		msgs[0] <- transfer.ReceiverRequestPayload
 	}
 
-	if err := transferPayload(ctx, tc, payload, payloadSize, msgs...); err != nil {
+	chunks := negotiateChunkSize(configuredChunkSize, msg.Payload, payloadSize)
+	chunkSize := chunks.Size()
+	n := transfer.NegotiateStreams(streams.Count, msg.Payload.MaxStreams, payloadSize)
+	ra, ok := payload.(io.ReaderAt)
+	var err error
+	switch window := transfer.NegotiateWindow(msg.Payload.Window, chunkSize); {
+	case ok && n > 1:
+		err = sendStreams(ctx, *tc, ra, payloadSize, n, chunkSize, streams, algorithm, msgs...)
+	case window > 0:
+		err = sendSequenced(ctx, *tc, payload, chunkSize, window, algorithm, msgs...)
+	default:
+		err = sendResumable(ctx, tc, payload, chunks, algorithm, migrations, msgs...)
+	}
+	if err != nil {
 		return err
 	}
 
-	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderPayloadSent}); err != nil {
+	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderClosing}); err != nil {
 		return err
 	}
 
-	_, err = tc.ReadMsg(ctx, transfer.ReceiverPayloadAck)
+	return nil
+}
+
+// sendResumable sends the payload until it is acknowledged by the receiver, resuming the connection
+// and the payload from the offset received by the receiver if the connection is lost. The payload is
+// sent along with its checksum, if a checksum algorithm was negotiated.
+func sendResumable(ctx context.Context, tc *conn.Transfer, payload io.Reader, chunks chunkSizer, algorithm string, migrations <-chan conn.Conn, msgs ...chan interface{}) error {
+	digest, err := checksum.NewDigest(algorithm)
 	if err != nil {
 		return err
 	}
+	err = sendPayload(ctx, tc, payload, resumePoint{}, chunks, digest, migrations, msgs...)
+	for attempt := 0; err != nil; attempt++ {
+		seeker, seekable := payload.(io.Seeker)
+		if attempt == RESUME_ATTEMPTS || tc.Redial == nil || !seekable || ctx.Err() != nil {
+			return err
+		}
+		var from resumePoint
+		if from, err = resumeTransfer(ctx, tc, seeker); err == nil {
+			err = sendPayload(ctx, tc, payload, from, chunks, digest, migrations, msgs...)
+		}
+	}
+	reportChecksum(digest, msgs...)
+	return nil
+}
 
-	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderClosing}); err != nil {
+// reportChecksum reports the checksum of the payload sent on the messages of the transfer, if a checksum
+// algorithm was negotiated.
+func reportChecksum(digest *checksum.Digest, msgs ...chan interface{}) {
+	if digest != nil && len(msgs) > 0 {
+		msgs[0] <- digest.Result()
+	}
+}
+
+// resumePoint is the point of the transfer acknowledged by the receiver when resuming.
+type resumePoint struct {
+	offset int64 // number of payload bytes received
+	acked  bool  // whether the complete payload has been acknowledged
+}
+
+// sendPayload sends the payload from the provided resume point, until it is acknowledged by the receiver.
+func sendPayload(ctx context.Context, tc *conn.Transfer, payload io.Reader, from resumePoint, chunks chunkSizer, digest *checksum.Digest, migrations <-chan conn.Conn, msgs ...chan interface{}) error {
+	if from.acked {
+		return nil
+	}
+	if err := transferPayload(ctx, tc, payload, chunks, from.offset, digest, migrations, msgs...); err != nil {
 		return err
 	}
 
-	return nil
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.SenderPayloadSent,
+		Payload: transfer.Payload{Digest: digest.Sum()},
+	}); err != nil {
+		return err
+	}
+
+	_, err := tc.ReadMsg(ctx, transfer.ReceiverPayloadAck)
+	return err
 }
 
-// transferPayload sends the files in chunks to the sender.
-func transferPayload(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) error {
-	bufReader := bufio.NewReader(payload)
-	buffer := make([]byte, chunkSize(payloadSize))
-	bytesSent := 0
+// resumeTransfer resumes the lost connection to the rendezvous server, and seeks the payload
+// to the offset received by the receiver.
+func resumeTransfer(ctx context.Context, tc *conn.Transfer, payload io.Seeker) (resumePoint, error) {
+	select {
+	case <-ctx.Done():
+		return resumePoint{}, ctx.Err()
+	case <-time.After(RESUME_DELAY):
+	}
+	c, err := tc.Redial(ctx)
+	if err != nil {
+		return resumePoint{}, fmt.Errorf("resuming connection to rendezvous server: %w", err)
+	}
+	tc.Conn = c
+
+	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderResume}); err != nil {
+		return resumePoint{}, err
+	}
 	for {
-		n, err := bufReader.Read(buffer)
+		msg, err := tc.ReadMsg(ctx)
+		if err != nil {
+			return resumePoint{}, err
+		}
+		switch msg.Type {
+		case transfer.ReceiverResumeOffset:
+			from := resumePoint{offset: msg.Payload.Offset, acked: msg.Payload.PayloadAcked}
+			if _, err := payload.Seek(from.offset, io.SeekStart); err != nil {
+				return resumePoint{}, fmt.Errorf("seeking payload to resume offset: %w", err)
+			}
+			return from, nil
+		// acknowledgements relayed while the connection was lost are superseded by the resume offset.
+		case transfer.ReceiverPayloadAck:
+		default:
+			return resumePoint{}, transfer.Error{Expected: []transfer.MsgType{transfer.ReceiverResumeOffset}, Got: msg.Type}
+		}
+	}
+}
+
+// transferPayload sends the files in chunks sized by chunks to the sender, starting at the provided offset,
+// digesting the chunks sent. The transfer migrates to a direct connection received on migrations between chunks.
+func transferPayload(ctx context.Context, tc *conn.Transfer, payload io.Reader, chunks chunkSizer, offset int64, digest *checksum.Digest, migrations <-chan conn.Conn, msgs ...chan interface{}) error {
+	adaptive, _ := chunks.(*transfer.AdaptiveChunkSize)
+	maxChunkSize := chunks.Size()
+	if adaptive != nil {
+		maxChunkSize = transfer.MAX_CHUNK_BYTES
+	}
+	bufReader := bufio.NewReaderSize(payload, int(maxChunkSize))
+	buffer := make([]byte, maxChunkSize)
+	bytesSent := int(offset)
+	for {
+		start := time.Now()
+		select {
+		case direct := <-migrations:
+			if err := migrate(ctx, tc, direct, int64(bytesSent)); err != nil {
+				return err
+			}
+			migrations = nil
+			if len(msgs) > 0 {
+				msgs[0] <- transfer.Direct
+			}
+		default:
+		}
+		n, err := bufReader.Read(buffer[:chunks.Size()])
 		bytesSent += n
 		if err == io.EOF {
 			break
@@ -154,19 +702,82 @@ func transferPayload(ctx context.Context, tc conn.Transfer, payload io.Reader, p
 		if err != nil {
 			return err
 		}
+		digest.Write(buffer[:n], int64(bytesSent-n))
 		err = tc.WriteRaw(ctx, buffer[:n])
 		if err != nil {
 			return err
 		}
+		// chunks are sized to the time taken to read and send them, such that slow sources shrink them too.
+		chunks.Observe(int64(n), time.Since(start))
 
 		if len(msgs) > 0 {
 			msgs[0] <- bytesSent
+			if adaptive != nil {
+				msgs[0] <- adaptive.Stats()
+			}
 		}
 
 	}
 	return nil
 }
 
+// migrate migrates the relayed transfer to the direct connection after the provided number of payload bytes sent,
+// confirming the offset with the receiver over the direct connection. The relayed connection is closed once the
+// receiver confirmed the offset, migrated transfers are no longer resumed.
+func migrate(ctx context.Context, tc *conn.Transfer, direct conn.Conn, sent int64) error {
+	// the receiver reads the relayed payload up until the migrate message before switching connections.
+	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderMigrate}); err != nil {
+		return err
+	}
+	relay := tc.Conn
+	tc.Conn, tc.Redial = direct, nil
+	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderResume}); err != nil {
+		return err
+	}
+	msg, err := tc.ReadMsg(ctx, transfer.ReceiverResumeOffset)
+	if err != nil {
+		return err
+	}
+	if msg.Payload.Offset != sent {
+		return fmt.Errorf("migrating to direct connection: receiver is at offset %d, sent %d bytes", msg.Payload.Offset, sent)
+	}
+	go relay.Close(conn.CLOSE_COMPLETED, transfer.MIGRATED) //nolint:errcheck
+	return nil
+}
+
+// chunkSizer sizes the chunks of the payload, observing the time taken to send each chunk.
+type chunkSizer interface {
+	Size() int64
+	Observe(n int64, elapsed time.Duration)
+}
+
+// fixedChunkSize sizes every chunk alike.
+type fixedChunkSize int64
+
+func (s fixedChunkSize) Size() int64                { return int64(s) }
+func (fixedChunkSize) Observe(int64, time.Duration) {}
+
+// negotiateChunkSize returns the chunk sizer of the transfer. A chunk size configured by the sender takes precedence
+// over the chunk size proposed by the receiver, both clamped to the negotiable bounds. Chunks adapt to the link if
+// either asks for adaptive chunks, starting out at the chunk size for the round trip time measured by the receiver.
+// If neither provides a chunk size the default chunk size for the payload size is used.
+func negotiateChunkSize(configured int64, proposal transfer.Payload, payloadSize int64) chunkSizer {
+	switch {
+	case configured > 0:
+		return fixedChunkSize(transfer.ClampChunkSize(configured))
+	case configured == transfer.CHUNK_SIZE_ADAPTIVE || proposal.ChunkSize == transfer.CHUNK_SIZE_ADAPTIVE:
+		initial := transfer.ChunkSizeForRTT(proposal.RTT)
+		if initial == 0 {
+			initial = chunkSize(payloadSize)
+		}
+		return transfer.NewAdaptiveChunkSize(initial, proposal.RTT)
+	case proposal.ChunkSize > 0:
+		return fixedChunkSize(transfer.ClampChunkSize(proposal.ChunkSize))
+	default:
+		return fixedChunkSize(chunkSize(payloadSize))
+	}
+}
+
 // chunkSize returns an appropriate chunk size for the payload size.
 func chunkSize(payloadSize int64) int64 {
 	// clamp amount of chunks to be at most MAX_SEND_CHUNKS if it exceeds
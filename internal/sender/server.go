@@ -1,3 +1,5 @@
+//go:build !js
+
 // server.go defines the sender webserver for the Portal file transfer
 package sender
 
@@ -5,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +16,7 @@ import (
 	"time"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
 	"nhooyr.io/websocket"
 )
 
@@ -20,14 +24,25 @@ import (
 type server struct {
 	server *http.Server
 	router *http.ServeMux
+	quic   *conn.QUICListener // nil unless direct QUIC connections are accepted, see ListenQUIC
+
+	key []byte
+	// send sends the payload over the direct connection of the receiver requesting it.
+	send func(ctx context.Context, tc *conn.Transfer, request transfer.Msg) error
 
-	Err      error
-	shutdown chan os.Signal
-	once     sync.Once
+	Err        error
+	migrations chan conn.Conn // direct connections of receivers migrating a relayed transfer
+	shutdown   chan os.Signal
+	done       chan struct{} // closed once the server is shut down
+	once       sync.Once
+
+	mu      sync.Mutex
+	pending int  // direct connections yet to request the payload
+	claimed bool // whether a direct connection requested the payload, or every connection failed before
 }
 
 // newServer creates a new server running on the provided port.
-func newServer(port int, key []byte, payload io.Reader, payloadSize int64, msgs ...chan interface{}) *server {
+func newServer(port int, key []byte, payload io.Reader, payloadSize int64, checksum string, chunkSize int64, msgs ...chan interface{}) *server {
 	router := &http.ServeMux{}
 	s := &server{
 		router: router,
@@ -37,25 +52,49 @@ func newServer(port int, key []byte, payload io.Reader, payloadSize int64, msgs
 			WriteTimeout: 30 * time.Second,
 			Handler:      router,
 		},
+		key: key,
+		send: func(ctx context.Context, tc *conn.Transfer, request transfer.Msg) error {
+			return sendRequested(ctx, tc, request, payload, payloadSize, Streams{}, checksum, chunkSize, nil, msgs...)
+		},
 	}
 	s.shutdown = make(chan os.Signal)
+	s.migrations = make(chan conn.Conn)
+	s.done = make(chan struct{})
 	signal.Notify(s.shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	// setup routes
-	router.HandleFunc("/portal", s.handleTransfer(key, payload, payloadSize, msgs...))
+	router.HandleFunc("/portal", s.handleTransfer())
+	router.HandleFunc("/migrate", s.handleMigration())
 	return s
 }
 
+// ListenQUIC accepts direct QUIC connections besides websocket connections once started, returning the port they
+// are accepted on.
+func (s *server) ListenQUIC() (int, error) {
+	ln, err := conn.ListenQUIC(":0", conn.QUIC_TRANSFER_PROTOCOL, conn.QUIC_MIGRATE_PROTOCOL)
+	if err != nil {
+		return 0, err
+	}
+	s.quic = ln
+	return ln.Addr().(*net.UDPAddr).Port, nil
+}
+
 // Start starts the server and sets up graceful shutdown.
 func (s *server) Start() error {
 	idleConnsClosed := make(chan struct{})
 	var shutdownErr error
 	go func() {
 		<-s.shutdown
+		if s.quic != nil {
+			s.quic.Close()
+		}
 		if err := s.server.Shutdown(context.Background()); err != nil {
 			shutdownErr = err
 		}
 		close(idleConnsClosed)
 	}()
+	if s.quic != nil {
+		go s.serveQUIC()
+	}
 	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -67,26 +106,104 @@ func (s *server) Start() error {
 // shutdown signal will ever be generated.
 func (s *server) Shutdown() {
 	s.once.Do(func() {
+		close(s.done)
 		s.shutdown <- syscall.SIGTERM
 	})
 }
 
-// handleTransfer returns a HTTP handler that performs the transfer sequence.
-// Will shutdown the server on termination.
-func (s *server) handleTransfer(key []byte, payload io.Reader, payloadSize int64, msgs ...chan interface{}) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			s.Shutdown()
+// serveQUIC accepts direct QUIC connections until the server is shut down, routing them by their ALPN protocol.
+func (s *server) serveQUIC() {
+	for {
+		qc, err := s.quic.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), s.server.ReadTimeout)
+			c, err := conn.AcceptQUIC(ctx, qc)
+			cancel()
+			if err != nil {
+				return
+			}
+			switch c.Protocol() {
+			case conn.QUIC_TRANSFER_PROTOCOL:
+				s.transfer(c)
+			case conn.QUIC_MIGRATE_PROTOCOL:
+				s.migrate(context.Background(), c)
+			}
 		}()
+	}
+}
+
+// handleTransfer returns a HTTP handler that performs the transfer sequence, see transfer.
+func (s *server) handleTransfer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		ws, err := websocket.Accept(w, r, nil)
 		if err != nil {
-			s.Err = err
 			return
 		}
-		tc := conn.TransferFromKey(&conn.WS{Conn: ws}, key)
-		if err != transferSequence(context.Background(), tc, payload, payloadSize, msgs...) {
-			s.Err = err
+		s.transfer(&conn.WS{Conn: ws})
+	}
+}
+
+// handleMigration returns a HTTP handler that hands the direct connection of a receiver migrating a relayed
+// transfer to the transfer, see migrate.
+func (s *server) handleMigration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
 			return
 		}
+		s.migrate(r.Context(), &conn.WS{Conn: ws})
+	}
+}
+
+// transfer performs the transfer sequence over the direct connection c, shutting down the server once done.
+// Connections closed before requesting the payload, e.g. the candidates of the receiver that lost the race to
+// connect, only fail the transfer if no other connection is left to request it.
+func (s *server) transfer(c conn.Conn) {
+	s.mu.Lock()
+	if s.claimed {
+		s.mu.Unlock()
+		c.Close(conn.CLOSE_CANCELED, "payload already requested") //nolint:errcheck
+		return
+	}
+	s.pending++
+	s.mu.Unlock()
+
+	tc := conn.TransferFromKey(c, s.key)
+	request, err := tc.ReadMsg(context.Background(), transfer.ReceiverRequestPayload)
+	s.mu.Lock()
+	s.pending--
+	won := err == nil && !s.claimed
+	abandoned := err != nil && !s.claimed && s.pending == 0
+	s.claimed = s.claimed || won || abandoned
+	s.mu.Unlock()
+	switch {
+	case won:
+		s.Err = s.send(context.Background(), &tc, request)
+	case abandoned:
+		s.Err = err
+	case err == nil:
+		c.Close(conn.CLOSE_CANCELED, "payload already requested") //nolint:errcheck
+		return
+	default:
+		return
+	}
+	s.Shutdown()
+}
+
+// migrate hands the direct connection c of a receiver migrating a relayed transfer to the transfer. Only receivers
+// proving the session key by the migrate message are handed over.
+func (s *server) migrate(ctx context.Context, c conn.Conn) {
+	tc := conn.TransferFromKey(c, s.key)
+	if _, err := tc.ReadMsg(ctx, transfer.ReceiverMigrate); err != nil {
+		c.Close(conn.CLOSE_FAILED, "migration not authenticated") //nolint:errcheck
+		return
+	}
+	select {
+	case s.migrations <- tc.Conn:
+	case <-s.done:
+		c.Close(conn.CLOSE_CANCELED, "transfer ended") //nolint:errcheck
 	}
 }
@@ -0,0 +1,136 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// Streams configures the parallel streams a payload is split over, each relayed through its own mailbox
+// on the rendezvous server at Addr. A Count of at most 1 transfers the payload over a single stream.
+type Streams struct {
+	Addr  string
+	Count int
+	Opts  []conn.DialOption
+}
+
+// sendStreams sends the payload split over n parallel streams, until it is acknowledged by the receiver.
+// The passwords of the streams are sent to the receiver over the transfer connection.
+func sendStreams(ctx context.Context, tc conn.Transfer, payload io.ReaderAt, payloadSize int64, n int, chunkSize int64, streams Streams, algorithm string, msgs ...chan interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := transfer.SplitStreams(payloadSize, n)
+	conns := make([]conn.Rendezvous, 0, n)
+	for i := range ranges {
+		rc, password, err := ConnectRendezvous(ctx, streams.Addr, streams.Opts...)
+		if err != nil {
+			for _, rc := range conns {
+				conn.CloseWithError(rc.Conn, err) //nolint:errcheck
+			}
+			return fmt.Errorf("connecting stream to rendezvous server: %w", err)
+		}
+		ranges[i].Password = password
+		conns = append(conns, rc)
+	}
+
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.SenderStreams,
+		Payload: transfer.Payload{Streams: ranges},
+	}); err != nil {
+		for _, rc := range conns {
+			conn.CloseWithError(rc.Conn, err) //nolint:errcheck
+		}
+		return err
+	}
+
+	p := &progress{msgs: msgs}
+	errs := make(chan error, n)
+	for i := range ranges {
+		go func(rc conn.Rendezvous, s transfer.Stream) {
+			errs <- sendStream(ctx, rc, payload, s, chunkSize, algorithm, p)
+		}(conns[i], ranges[i])
+	}
+	var err error
+	for range ranges {
+		if streamErr := <-errs; streamErr != nil && err == nil {
+			err = fmt.Errorf("sending stream: %w", streamErr)
+			cancel()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderPayloadSent}); err != nil {
+		return err
+	}
+	_, err = tc.ReadMsg(ctx, transfer.ReceiverPayloadAck)
+	return err
+}
+
+// sendStream secures the connection of the stream and sends its range of the payload in chunks of the
+// provided size, until it is acknowledged by the receiver. The range is followed by its checksum, if a
+// checksum algorithm was negotiated. The connection is closed once the stream ends.
+func sendStream(ctx context.Context, rc conn.Rendezvous, payload io.ReaderAt, s transfer.Stream, chunkSize int64, algorithm string, p *progress) (err error) {
+	defer func() { conn.CloseWithError(rc.Conn, err) }() //nolint:errcheck
+	tc, err := SecureConnection(ctx, rc, s.Password)
+	if err != nil {
+		return err
+	}
+	digest, err := checksum.NewDigest(algorithm)
+	if err != nil {
+		return err
+	}
+	var sent int64
+	section := io.NewSectionReader(payload, s.Offset, s.Length)
+	buffer := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(section, buffer)
+		if n > 0 {
+			digest.Write(buffer[:n], sent)
+			if err := tc.WriteRaw(ctx, buffer[:n]); err != nil {
+				return err
+			}
+			sent += int64(n)
+			p.add(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if digest != nil {
+		if err := tc.WriteMsg(ctx, transfer.Msg{
+			Type:    transfer.SenderPayloadSent,
+			Payload: transfer.Payload{Digest: digest.Sum()},
+		}); err != nil {
+			return err
+		}
+	}
+	_, err = tc.ReadMsg(ctx, transfer.ReceiverPayloadAck)
+	return err
+}
+
+// progress reports the total number of payload bytes sent over all streams.
+type progress struct {
+	mu   sync.Mutex
+	sent int
+	msgs []chan interface{}
+}
+
+func (p *progress) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent += n
+	if len(p.msgs) > 0 {
+		p.msgs[0] <- p.sent
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"strconv"
 
 	"github.com/SpatiumPortae/portal/internal/conn"
 	"github.com/SpatiumPortae/portal/protocol/transfer"
@@ -15,16 +16,43 @@ import (
 
 // doTransfer performs the file transfer, either directly or using the Rendezvous server as a relay.
 // This version is built for other platforms other than js (wasm)
-func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) error {
-	_, err := tc.ReadMsg(ctx, transfer.ReceiverHandshake)
+func doTransfer(ctx context.Context, tc *conn.Transfer, payload io.Reader, payloadSize int64, opts transferOptions) error {
+	msgs := opts.msgs
+	handshake, err := tc.ReadMsg(ctx, transfer.ReceiverHandshake)
 	if err != nil {
 		return err
 	}
+	if payload, payloadSize, opts.codec, err = compressPayload(handshake.Payload.Codecs, payload, payloadSize, opts.codec, opts.compression); err != nil {
+		return err
+	}
+	if err := checkCodec(handshake.Payload.Codecs, opts.codec); err != nil {
+		return err
+	}
+	if err := checkFormats(handshake.Payload.Formats, opts.formats); err != nil {
+		return err
+	}
+	packedSize := payloadSize
+	if payload, payloadSize, err = resumePayload(handshake, payload, payloadSize, opts.resume, opts.transferID); err != nil {
+		return err
+	}
+	if payload, payloadSize, err = selectPayload(ctx, *tc, handshake, payload, payloadSize, opts.selection); err != nil {
+		return err
+	}
+	checksum := transfer.NegotiateChecksum(opts.checksum, handshake.Payload.Checksums)
+	rawSize := announceCompression(opts.compression, opts.codec, payloadSize != packedSize, msgs...)
 	port, err := getOpenPort()
 	if err != nil {
 		return err
 	}
-	server := newServer(port, tc.Key(), payload, payloadSize, msgs...)
+	server := newServer(port, tc.Key(), payload, payloadSize, checksum, opts.chunkSize, msgs...)
+	transports := transfer.NegotiateTransports(opts.transport, handshake.Payload.Transports)
+	var quicPort int
+	if transfer.SupportsTransport(transports, transfer.TRANSPORT_QUIC) {
+		// transfers fall back to the other transports unless QUIC is forced.
+		if quicPort, err = server.ListenQUIC(); err != nil && opts.transport == transfer.TRANSPORT_QUIC {
+			return fmt.Errorf("listening for direct QUIC connections: %w", err)
+		}
+	}
 	serverDone := make(chan struct{})
 	// Start server for direct transfers.
 	go func() {
@@ -35,18 +63,29 @@ func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloa
 	}()
 	defer server.Shutdown()
 
-	ip, err := getLocalIP()
+	ips, err := getLocalIPs()
 	if err != nil {
 		return err
 	}
+	handshakePayload := transfer.Payload{
+		PayloadSize: payloadSize,
+		Codec:       opts.codec,
+		RawSize:     rawSize,
+		Checksum:    checksum,
+		TransferID:  opts.transferID,
+	}
+	// receivers connect over the negotiated transports, falling back to the relay if none is negotiated.
+	if transfer.SupportsTransport(transports, transfer.TRANSPORT_WEBSOCKET) {
+		handshakePayload.Candidates, handshakePayload.IP = directCandidates(ips, port)
+		handshakePayload.Port = port
+	}
+	if quicPort != 0 {
+		handshakePayload.QUICCandidates, _ = directCandidates(ips, quicPort)
+	}
 
 	if err := tc.WriteMsg(ctx, transfer.Msg{
-		Type: transfer.SenderHandshake,
-		Payload: transfer.Payload{
-			IP:          ip,
-			Port:        port,
-			PayloadSize: payloadSize,
-		},
+		Type:    transfer.SenderHandshake,
+		Payload: handshakePayload,
 	}); err != nil {
 		return err
 	}
@@ -79,7 +118,8 @@ func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloa
 			return err
 		}
 
-		return transferSequence(ctx, tc, payload, payloadSize, msgs...)
+		// the transfer migrates to a direct connection once the receiver manages to connect directly.
+		return transferSequence(ctx, tc, payload, payloadSize, opts.streams, checksum, opts.chunkSize, server.migrations, msgs...)
 
 	default:
 		return transfer.Error{
@@ -90,20 +130,44 @@ func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloa
 	}
 }
 
-func getLocalIP() (net.IP, error) {
+// getLocalIPs returns the addresses of the interfaces of the host, IPv6 addresses first as preferred by receivers
+// racing them. Loopback addresses and link-local addresses, which cannot be dialed without a zone, are left out.
+func getLocalIPs() ([]net.IP, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, err
 	}
 
+	var v6, v4 []net.IP
 	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP, nil
-			}
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP)
+		} else {
+			v6 = append(v6, ipnet.IP)
+		}
+	}
+	if len(v6)+len(v4) == 0 {
+		return nil, fmt.Errorf("unable to resolve local IP")
+	}
+	return append(v6, v4...), nil
+}
+
+// directCandidates returns the addresses of the port on the provided IPs announced to the receiver, and the first
+// IPv4 address announced to receivers predating candidates, nil on IPv6-only hosts.
+func directCandidates(ips []net.IP, port int) ([]string, net.IP) {
+	var legacy net.IP
+	candidates := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if legacy == nil && ip.To4() != nil {
+			legacy = ip
 		}
+		candidates = append(candidates, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
 	}
-	return nil, fmt.Errorf("unable to resolve local IP")
+	return candidates, legacy
 }
 
 func getOpenPort() (int, error) {
@@ -13,11 +13,30 @@ import (
 
 // doTransfer performs the file transfer directly, no relay. This function is only built for the
 // js platform (wasm)
-func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloadSize int64, msgs ...chan interface{}) error {
-	_, err := tc.ReadMsg(ctx, transfer.ReceiverHandshake)
+func doTransfer(ctx context.Context, tc *conn.Transfer, payload io.Reader, payloadSize int64, opts transferOptions) error {
+	msgs := opts.msgs
+	handshake, err := tc.ReadMsg(ctx, transfer.ReceiverHandshake)
 	if err != nil {
 		return err
 	}
+	if payload, payloadSize, opts.codec, err = compressPayload(handshake.Payload.Codecs, payload, payloadSize, opts.codec, opts.compression); err != nil {
+		return err
+	}
+	if err := checkCodec(handshake.Payload.Codecs, opts.codec); err != nil {
+		return err
+	}
+	if err := checkFormats(handshake.Payload.Formats, opts.formats); err != nil {
+		return err
+	}
+	packedSize := payloadSize
+	if payload, payloadSize, err = resumePayload(handshake, payload, payloadSize, opts.resume, opts.transferID); err != nil {
+		return err
+	}
+	if payload, payloadSize, err = selectPayload(ctx, *tc, handshake, payload, payloadSize, opts.selection); err != nil {
+		return err
+	}
+	checksum := transfer.NegotiateChecksum(opts.checksum, handshake.Payload.Checksums)
+	rawSize := announceCompression(opts.compression, opts.codec, payloadSize != packedSize, msgs...)
 
 	if err := tc.WriteMsg(ctx, transfer.Msg{
 		Type: transfer.SenderHandshake,
@@ -25,6 +44,10 @@ func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloa
 			IP:          net.IP{},
 			Port:        80,
 			PayloadSize: payloadSize,
+			Codec:       opts.codec,
+			RawSize:     rawSize,
+			Checksum:    checksum,
+			TransferID:  opts.transferID,
 		},
 	}); err != nil {
 		return err
@@ -41,7 +64,7 @@ func doTransfer(ctx context.Context, tc conn.Transfer, payload io.Reader, payloa
 		if err := tc.WriteMsg(ctx, transfer.Msg{Type: transfer.SenderRelayAck}); err != nil {
 			return err
 		}
-		return transferSequence(ctx, tc, payload, payloadSize)
+		return transferSequence(ctx, tc, payload, payloadSize, opts.streams, checksum, opts.chunkSize, nil)
 
 	default:
 		return transfer.Error{
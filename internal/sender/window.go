@@ -0,0 +1,173 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/checksum"
+	"github.com/SpatiumPortae/portal/internal/conn"
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+)
+
+// RETRANSMIT_TIMEOUT is the time the sender waits for the receiver to acknowledge a sequenced chunk, before
+// retransmitting the oldest unacknowledged chunk.
+const RETRANSMIT_TIMEOUT = 2 * time.Second
+
+// sendSequenced sends the payload in sequenced chunks of the provided size, keeping up to window chunks
+// unacknowledged by the receiver. Chunks the receiver is missing are retransmitted once NAKed, and the oldest
+// unacknowledged chunk once no chunk is acknowledged within RETRANSMIT_TIMEOUT. The payload is sent along with
+// its checksum, if a checksum algorithm was negotiated, until it is acknowledged by the receiver.
+// Sequenced transfers are neither resumed nor migrated.
+func sendSequenced(ctx context.Context, tc conn.Transfer, payload io.Reader, chunkSize int64, window int, algorithm string, msgs ...chan interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	digest, err := checksum.NewDigest(algorithm)
+	if err != nil {
+		return err
+	}
+	if err := tc.WriteMsg(ctx, transfer.Msg{
+		Type:    transfer.SenderSequenced,
+		Payload: transfer.Payload{Window: window},
+	}); err != nil {
+		return err
+	}
+
+	// the replies of the receiver are read while sending, until it acknowledges the payload.
+	replies := make(chan transfer.Msg)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := tc.ReadMsg(ctx)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case replies <- msg:
+			case <-ctx.Done():
+				return
+			}
+			if msg.Type == transfer.ReceiverPayloadAck {
+				return
+			}
+		}
+	}()
+
+	w := &sendWindow{tc: tc, held: make(map[uint64][]byte, window)}
+	bufReader := bufio.NewReaderSize(payload, int(chunkSize))
+	var (
+		bytesSent int
+		eof       bool
+	)
+	for !w.done {
+		// chunks are sent while the window has room, handling the replies received in between.
+		if !eof && w.next < w.acked+uint64(window) {
+			select {
+			case msg := <-replies:
+				if err := w.handle(ctx, msg, eof); err != nil {
+					return err
+				}
+				continue
+			case err := <-readErr:
+				return err
+			default:
+			}
+			chunk := make([]byte, chunkSize)
+			n, err := bufReader.Read(chunk)
+			if err == io.EOF {
+				eof = true
+				if err := tc.WriteMsg(ctx, transfer.Msg{
+					Type:    transfer.SenderPayloadSent,
+					Payload: transfer.Payload{Digest: digest.Sum(), Sequence: w.next},
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			digest.Write(chunk[:n], int64(bytesSent))
+			if err := w.send(ctx, chunk[:n]); err != nil {
+				return err
+			}
+			bytesSent += n
+			if len(msgs) > 0 {
+				msgs[0] <- bytesSent
+			}
+			continue
+		}
+
+		select {
+		case msg := <-replies:
+			if err := w.handle(ctx, msg, eof); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(RETRANSMIT_TIMEOUT):
+			if err := w.retransmit(ctx, w.acked); err != nil {
+				return err
+			}
+		}
+	}
+	reportChecksum(digest, msgs...)
+	return nil
+}
+
+// sendWindow holds the sequenced chunks sent but not yet acknowledged by the receiver.
+type sendWindow struct {
+	tc    conn.Transfer
+	held  map[uint64][]byte
+	next  uint64 // sequence number of the next chunk sent
+	acked uint64 // number of chunks acknowledged in order
+	done  bool   // whether the receiver acknowledged the payload
+}
+
+// send sends the chunk with the next sequence number, holding it until acknowledged.
+func (w *sendWindow) send(ctx context.Context, chunk []byte) error {
+	w.held[w.next] = chunk
+	w.next++
+	return w.tc.WriteRaw(ctx, transfer.EncodeChunk(w.next-1, chunk))
+}
+
+// retransmit sends the held chunk again, chunks no longer held have been acknowledged.
+func (w *sendWindow) retransmit(ctx context.Context, seq uint64) error {
+	chunk, ok := w.held[seq]
+	if !ok {
+		return nil
+	}
+	return w.tc.WriteRaw(ctx, transfer.EncodeChunk(seq, chunk))
+}
+
+// handle handles the reply of the receiver, releasing acknowledged chunks and retransmitting NAKed chunks.
+func (w *sendWindow) handle(ctx context.Context, msg transfer.Msg, eof bool) error {
+	switch msg.Type {
+	case transfer.ReceiverChunkAck:
+		if msg.Payload.Sequence > w.next {
+			return fmt.Errorf("receiver acknowledged %d chunks, sent %d", msg.Payload.Sequence, w.next)
+		}
+		for ; w.acked < msg.Payload.Sequence; w.acked++ {
+			delete(w.held, w.acked)
+		}
+	case transfer.ReceiverChunkNak:
+		for _, seq := range msg.Payload.Missing {
+			if err := w.retransmit(ctx, seq); err != nil {
+				return err
+			}
+		}
+	case transfer.ReceiverPayloadAck:
+		if !eof {
+			return transfer.Error{Expected: []transfer.MsgType{transfer.ReceiverChunkAck, transfer.ReceiverChunkNak}, Got: msg.Type}
+		}
+		w.done = true
+	default:
+		return transfer.Error{Expected: []transfer.MsgType{transfer.ReceiverChunkAck, transfer.ReceiverChunkNak, transfer.ReceiverPayloadAck}, Got: msg.Type}
+	}
+	return nil
+}
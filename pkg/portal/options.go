@@ -0,0 +1,116 @@
+// options.go specifies the options that can be used to configure sends and receives.
+
+package portal
+
+import (
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+)
+
+// Option configures a send or a receive.
+type Option func(*options)
+
+// ProgressFunc is called with the number of bytes of the payload transferred so far and the size of the payload
+// as the transfer progresses.
+type ProgressFunc func(bytes, total int64)
+
+type options struct {
+	config portal.Config
+	size   int64
+}
+
+// WithRelay sets the address of the relay server, or a comma-separated list of addresses tried in order until a
+// connection succeeds. The sender and the receiver must use the same relay. Defaults to DEFAULT_RELAY.
+func WithRelay(addr string) Option {
+	return func(o *options) {
+		o.config.RendezvousAddr = addr
+	}
+}
+
+// WithDNSServer sets the DNS server the address of the relay server is resolved with.
+func WithDNSServer(addr string) Option {
+	return func(o *options) {
+		o.config.DNSServer = addr
+	}
+}
+
+// WithProgress reports the progress of the transfer to fn.
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *options) {
+		o.config.OnProgress = fn
+	}
+}
+
+// WithFingerprint calls fn with the fingerprint of the connection once it is secured, the sender and the
+// receiver of a transfer observe the same fingerprint.
+func WithFingerprint(fn func(fingerprint string)) Option {
+	return func(o *options) {
+		o.config.OnFingerprint = fn
+	}
+}
+
+// WithSize sets the size of the payload of a send in bytes, required for payloads whose size cannot be
+// determined from the reader.
+func WithSize(n int64) Option {
+	return func(o *options) {
+		o.size = n
+	}
+}
+
+// WithPassword claims the provided password for a send rather than generating one, such that receivers knowing
+// it can connect before the sender with WithWaitForSender.
+func WithPassword(password Password) Option {
+	return func(o *options) {
+		o.config.Password = string(password)
+	}
+}
+
+// WithWaitForSender holds a receive on the relay until a sender claims the password, rather than failing if no
+// sender holds it yet.
+func WithWaitForSender() Option {
+	return func(o *options) {
+		o.config.WaitForSender = true
+	}
+}
+
+// WithExpireAfter expires the password of a send after the provided time unless a receiver connected, bound by
+// the timeout of the relay server.
+func WithExpireAfter(d time.Duration) Option {
+	return func(o *options) {
+		o.config.ExpireAfter = d
+	}
+}
+
+// WithStreams splits relayed payloads over at most n parallel streams. Receives only accept parallel streams
+// into writers implementing io.WriterAt.
+func WithStreams(n int) Option {
+	return func(o *options) {
+		o.config.Streams = n
+	}
+}
+
+// WithChecksum sets the checksum algorithm proving the integrity of the payload of a send, one of
+// transfer.Checksums. Defaults to transfer.CHECKSUM_SHA256.
+func WithChecksum(algorithm string) Option {
+	return func(o *options) {
+		o.config.Checksum = algorithm
+	}
+}
+
+// WithRateLimit sends or receives the payload at most at the provided rate in bytes per second, shared by the
+// parallel streams of the transfer.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(o *options) {
+		o.config.RateLimit = bytesPerSecond
+	}
+}
+
+// newOptions applies the provided options.
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,75 @@
+// Package portal sends payloads to and receives payloads from other portal clients, e.g. the portal CLI, through a
+// portal relay server. Payloads are end-to-end encrypted with a key derived from the password of the transfer.
+package portal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/SpatiumPortae/portal/internal/portal"
+)
+
+// DEFAULT_RELAY is the address of the public relay server.
+const DEFAULT_RELAY = "portal.spatiumportae.com"
+
+// ErrUnknownSize is returned by Send if the size of the payload cannot be determined from the reader, and is not
+// provided by WithSize.
+var ErrUnknownSize = errors.New("payload size unknown, provide it with WithSize")
+
+// Password is the password of a transfer, the receiver presents the password of the sender to receive the payload.
+type Password string
+
+// Transfer is a send waiting for its receiver.
+type Transfer struct {
+	// Password is the password the receiver presents to receive the payload.
+	Password Password
+	errC     <-chan error
+}
+
+// Wait blocks until the payload is received or the transfer failed.
+func (t *Transfer) Wait() error {
+	return <-t.errC
+}
+
+// Send connects to the relay server and returns once a password is registered, the payload is sent
+// asynchronously once a receiver presents the password, see Transfer.Wait.
+func Send(ctx context.Context, payload io.Reader, opts ...Option) (*Transfer, error) {
+	o := newOptions(opts)
+	size := o.size
+	if size == 0 {
+		var ok bool
+		if size, ok = sizeOf(payload); !ok {
+			return nil, ErrUnknownSize
+		}
+	}
+	password, err, errC := portal.Send(ctx, payload, size, &o.config)
+	if err != nil {
+		return nil, err
+	}
+	return &Transfer{Password: Password(password), errC: errC}, nil
+}
+
+// Receive receives the payload of the sender holding the provided password, writing it to dst.
+func Receive(ctx context.Context, password Password, dst io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	return portal.Receive(ctx, dst, string(password), &o.config)
+}
+
+// sizeOf returns the size of the payload of the reader, if it can be determined without reading it.
+func sizeOf(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case *os.File:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		return fi.Size(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package portal_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/internal/rendezvous"
+	"github.com/SpatiumPortae/portal/internal/semver"
+	"github.com/SpatiumPortae/portal/pkg/portal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendReceive(t *testing.T) {
+	// the server saves its auth token to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := rendezvous.NewServer(0, "", semver.Version{})
+	go s.Run(ctx) //nolint:errcheck
+	require.Eventually(t, func() bool { return s.Addr() != nil }, 5*time.Second, 10*time.Millisecond)
+	relay := portal.WithRelay(fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port))
+	oracle := strings.Repeat("A frog walks into a bank...", 10000)
+
+	t.Run("reports progress", func(t *testing.T) {
+		type progress struct{ bytes, total int64 }
+		var (
+			mu                   sync.Mutex
+			sent, received       []progress
+			senderFP, receiverFP string
+		)
+		track := func(updates *[]progress) portal.ProgressFunc {
+			return func(bytes, total int64) {
+				mu.Lock()
+				defer mu.Unlock()
+				*updates = append(*updates, progress{bytes, total})
+			}
+		}
+
+		tr, err := portal.Send(ctx, strings.NewReader(oracle), relay, portal.WithProgress(track(&sent)),
+			portal.WithFingerprint(func(fp string) { senderFP = fp }))
+		require.NoError(t, err)
+		require.NotEmpty(t, tr.Password)
+		var out bytes.Buffer
+		require.NoError(t, portal.Receive(ctx, tr.Password, &out, relay, portal.WithProgress(track(&received)),
+			portal.WithFingerprint(func(fp string) { receiverFP = fp })))
+		require.NoError(t, tr.Wait())
+		assert.Equal(t, oracle, out.String())
+		assert.Equal(t, senderFP, receiverFP)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, updates := range [][]progress{sent, received} {
+			require.NotEmpty(t, updates)
+			last := updates[len(updates)-1]
+			assert.Equal(t, progress{int64(len(oracle)), int64(len(oracle))}, last)
+		}
+	})
+
+	t.Run("claimed password", func(t *testing.T) {
+		password := portal.Password("7-claimed-portal-password")
+		errC := make(chan error, 1)
+		var out bytes.Buffer
+		go func() { errC <- portal.Receive(ctx, password, &out, relay, portal.WithWaitForSender()) }()
+		in := io.LimitReader(strings.NewReader(oracle), int64(len(oracle)))
+		tr, err := portal.Send(ctx, in, relay, portal.WithPassword(password), portal.WithSize(int64(len(oracle))))
+		require.NoError(t, err)
+		assert.Equal(t, password, tr.Password)
+		require.NoError(t, tr.Wait())
+		require.NoError(t, <-errC)
+		assert.Equal(t, oracle, out.String())
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		_, err := portal.Send(ctx, io.LimitReader(strings.NewReader(oracle), 10), relay)
+		assert.ErrorIs(t, err, portal.ErrUnknownSize)
+	})
+}
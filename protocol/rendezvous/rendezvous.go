@@ -3,6 +3,8 @@ package rendezvous
 import (
 	"fmt"
 	"strings"
+	"time"
+	"unicode"
 )
 
 type MsgType int
@@ -21,18 +23,237 @@ const (
 	// From this point there is a safe channel established
 	ReceiverToRendezvousClose // Receiver can connect directly to sender, close receiver connection -> close sender connection
 	SenderToRendezvousClose   // Transit sequence is completed, close sender connection -> close receiver connection
+	RendezvousToSenderRestart // Receiver disconnected during the key exchange, sender restarts the key exchange and waits for a new receiver
+	SenderToRendezvousResume  // Sender lost its connection while relaying, re-presents its mailbox and session token
+	RendezvousToSenderResumed // Rendezvous reattached the sender to its mailbox, relaying continues
+	RendezvousToPeerIdle      // Rendezvous warns a peer, unencrypted while relaying, that the idle relay is about to be closed
+	RendezvousToPeerClosing   // Rendezvous warns a peer, unencrypted while relaying, that the server is shutting down and closes the relay once drained
 )
 
+// WebSocket subprotocols negotiated with clients during the upgrade. Clients not requesting a subprotocol speak
+// the native binary protocol.
+const (
+	SUBPROTOCOL_BINARY = "portal.v1"      // native protocol, encrypted payloads are sent as binary messages
+	SUBPROTOCOL_JSON   = "portal.v1+json" // every message is sent as JSON text, encrypted payloads as base64 strings
+)
+
+// SUBPROTOCOLS are the subprotocols supported by the rendezvous server, in order of preference.
+var SUBPROTOCOLS = []string{SUBPROTOCOL_BINARY, SUBPROTOCOL_JSON}
+
+// CODE_EXPIRED is the close reason of connections to a mailbox whose code expired.
+const CODE_EXPIRED = "code expired"
+
+// CODE_UNKNOWN is the close reason of receivers presenting a code no sender holds.
+const CODE_UNKNOWN = "no sender holds the code"
+
+// HANDSHAKE_TIMED_OUT is the close reason of connections that did not complete the key exchange in time.
+const HANDSHAKE_TIMED_OUT = "handshake timed out"
+
+// TRANSFER_IDLE is the close reason of relayed transfers closed for being idle.
+const TRANSFER_IDLE = "transfer idle"
+
+// SERVER_CLOSING is the close reason of connections closed as the rendezvous server shuts down, i.e. senders
+// waiting for a receiver and relayed transfers that did not complete within the drain timeout of the server.
+const SERVER_CLOSING = "relay server shutting down"
+
+// CODE_IN_USE is the close reason of senders claiming a code another sender holds.
+const CODE_IN_USE = "code in use"
+
+// MAX_RECEIVERS is the maximum number of receivers a sender sends the same payload to with one password.
+const MAX_RECEIVERS = 16
+
+// TOO_MANY_RECEIVERS is the close reason of senders joining a mailbox shared by MAX_RECEIVERS mailboxes already.
+const TOO_MANY_RECEIVERS = "too many receivers"
+
+// UNKNOWN_SESSION is the close reason of senders presenting a session token no mailbox of the password was issued.
+const UNKNOWN_SESSION = "unknown session"
+
+// EVICTED_IDLE is the close reason of connections to a mailbox evicted by an operator as idle.
+const EVICTED_IDLE = "evicted idle by relay operator"
+
+// MAILBOX_CLOSED is the close reason of connections to a mailbox closed by an operator.
+const MAILBOX_CLOSED = "mailbox closed by relay operator"
+
+// MAILBOX_EXPIRED is the close reason of senders whose mailbox made no progress for the mailbox ttl of the server.
+const MAILBOX_EXPIRED = "mailbox expired"
+
+// QUOTA_EXCEEDED is the close reason of connections cut off as the sender exceeded its transfer quota, and the
+// error of senders rejected for it.
+const QUOTA_EXCEEDED = "transfer quota exceeded"
+
+// RELAY_LIMIT_EXCEEDED is the close reason of connections cut off as their transfer exceeded the bytes the
+// rendezvous server relays per transfer.
+const RELAY_LIMIT_EXCEEDED = "transfer exceeds relay size limit"
+
+// TOO_MANY_RELAYS is the close reason of receivers rejected as the rendezvous server relays its maximum of
+// concurrent transfers.
+const TOO_MANY_RELAYS = "too many concurrent relays"
+
+// Close reasons of clients ending a transfer, see conn.CloseStatus. Failures are described by a fixed reason, the
+// error itself is kept by the failing client rather than sent to its peer.
+const (
+	TRANSFER_COMPLETED = "transfer completed"
+	TRANSFER_CANCELED  = "transfer canceled"
+	TRANSFER_FAILED    = "transfer failed"
+)
+
+// BIND_FAILED is the close reason of senders the rendezvous server could not bind an id for.
+const BIND_FAILED = "binding id failed"
+
+// KDF_ITERATIONS_TOO_LOW is the close reason of senders deriving the session key with fewer iterations than the
+// minimum of the rendezvous server, and of their receivers.
+const KDF_ITERATIONS_TOO_LOW = "key derivation iterations below minimum"
+
+// SESSION_NOT_RESUMABLE is the close reason of senders resuming a session whose mailbox does not await them.
+const SESSION_NOT_RESUMABLE = "session not resumable"
+
+// DEADLINE_EXCEEDED is the close reason of relayed connections exceeding the connection deadline of the server.
+const DEADLINE_EXCEEDED = "connection deadline exceeded"
+
+// FEDERATION_LOST is the close reason of receivers relayed to a federated peer that lost the connection to it.
+const FEDERATION_LOST = "federated connection lost"
+
+// PANIC_CLOSE_REASON is the close reason of connections torn down by a recovered panic of the rendezvous server.
+const PANIC_CLOSE_REASON = "internal server error"
+
+// Eviction is the response of the rendezvous server to the eviction of idle mailboxes.
+type Eviction struct {
+	Evicted int `json:"evicted"`
+}
+
+// MailboxClose is the response of the rendezvous server to closing the mailboxes of an id.
+type MailboxClose struct {
+	Closed int `json:"closed"`
+}
+
+// Status is the live state of the rendezvous server reported to its operators.
+type Status struct {
+	Version        string         `json:"version"`
+	UptimeSeconds  int64          `json:"uptime_seconds"`
+	Mailboxes      map[string]int `json:"mailboxes"`        // number of mailboxes by state
+	Connections    int64          `json:"connections"`      // open sender and receiver connections
+	BytesRelayed   int64          `json:"bytes_relayed"`    // bytes relayed since the server started
+	BytesPerSecond int64          `json:"bytes_per_second"` // bytes relayed per second, sampled at most every second
+}
+
+// Mailbox is a mailbox of the rendezvous server listed to its operators. The id is bound to the sender and part of
+// its code, mailboxes of senders sending to several receivers share their id.
+type Mailbox struct {
+	ID              int       `json:"id"`
+	State           string    `json:"state"`
+	Created         time.Time `json:"created"`
+	IdleMS          int64     `json:"idle_ms"` // time since a payload was last relayed, zero unless relaying
+	BytesToSender   int64     `json:"bytes_to_sender"`
+	BytesToReceiver int64     `json:"bytes_to_receiver"`
+}
+
+// TokenReload is the response of the rendezvous server to reloading its auth tokens.
+type TokenReload struct {
+	Tokens int `json:"tokens"`
+}
+
+// Transfer is an entry of the transfer log of the rendezvous server, summarizing a transfer once it ended.
+type Transfer struct {
+	Time            time.Time `json:"time"` // time the transfer ended
+	ID              int       `json:"id"`   // id bound to the sender
+	Sender          string    `json:"sender"`
+	Receiver        string    `json:"receiver,omitempty"` // empty if no receiver connected
+	State           string    `json:"state"`              // state of the mailbox once the transfer ended
+	Outcome         string    `json:"outcome"`
+	BytesToReceiver int64     `json:"bytes_to_receiver"`
+	BytesToSender   int64     `json:"bytes_to_sender"`
+	DurationMS      int64     `json:"duration_ms"`
+}
+
 type Msg struct {
 	Type    MsgType `json:"type"`
 	Payload Payload `json:"payload,omitempty"`
 }
 
+// Control is the envelope of messages addressed to the rendezvous server while relaying, sent as text messages.
+// Relayed messages of the peers, whether or not they are JSON, are never read as control messages.
+type Control struct {
+	Control *Msg `json:"control"`
+}
+
 type Payload struct {
 	ID       int    `json:"id,omitempty"`
 	Password string `json:"password,omitempty"`
 	Bytes    []byte `json:"pake_bytes,omitempty"`
 	Salt     []byte `json:"salt,omitempty"`
+	// KDFIterations is the minimum number of key derivation iterations accepted by the rendezvous
+	// server when announcing readiness, and the number of iterations chosen by the sender with the salt.
+	KDFIterations int `json:"kdf_iterations,omitempty"`
+	// Token is the session token issued to the sender when binding, presented to resume a lost connection.
+	Token string `json:"token,omitempty"`
+	// DisconnectIn is the time until an idle relay is closed, unless payload is relayed, or until the relay is
+	// closed by the rendezvous server shutting down, unless the transfer completes.
+	DisconnectIn time.Duration `json:"disconnect_in,omitempty"`
+	// ExpireAfter is the time after which the code of the sender is invalidated when establishing,
+	// unless a receiver connected. Bound by the receiver connect timeout of the rendezvous server.
+	ExpireAfter time.Duration `json:"expire_after,omitempty"`
+	// Wait asks the rendezvous server to hold a receiver presenting a password no sender holds yet, until a
+	// sender claims the password. Bound by the receiver connect timeout of the rendezvous server.
+	Wait bool `json:"wait,omitempty"`
+	// Join is the session token of a mailbox of the sender, presented when establishing a further mailbox sharing
+	// its password such that the payload is sent to several receivers. At most MAX_RECEIVERS share a password.
+	Join string `json:"join,omitempty"`
+}
+
+// MAX_MOTD_LENGTH is the maximum number of characters of a message of the day.
+const MAX_MOTD_LENGTH = 280
+
+// Info describes the rendezvous server, served on the /info endpoint.
+type Info struct {
+	// MOTD is a message of the day from the operator of the rendezvous server.
+	MOTD string `json:"motd,omitempty"`
+	// NATProbePorts are the UDP ports on which the rendezvous server answers NAT probes.
+	NATProbePorts []int `json:"nat_probe_ports,omitempty"`
+	// Subprotocols are the WebSocket subprotocols the rendezvous server negotiates, in order of preference.
+	Subprotocols []string `json:"subprotocols,omitempty"`
+}
+
+// MAX_RESUMPTION_STATE_BYTES is the maximum size of the resumption state stored by the rendezvous server.
+const MAX_RESUMPTION_STATE_BYTES = 64 << 10
+
+// Resumption is the resumption state a receiver stores on the /resumption endpoint, such that an interrupted
+// transfer can be resumed by presenting the issued token from any machine. The state is sealed by the receiver,
+// the rendezvous server only holds it until it expires. ExpiresIn is the time left until the state expires, relative
+// such that clients with a skewed clock tell the expiry on their own clock, Expires is the expiry on the clock of the
+// rendezvous server.
+type Resumption struct {
+	Token     string        `json:"token,omitempty"`
+	State     []byte        `json:"state,omitempty"`
+	Expires   time.Time     `json:"expires,omitempty"`
+	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+}
+
+// Drop is a sealed payload held by the rendezvous server until a receiver collects it, such that the sender and
+// receiver need not be online at once. The payload is sealed by the sender with a key the rendezvous server never
+// learns. Expires and ExpiresIn tell the expiry of the drop like those of a Resumption.
+type Drop struct {
+	ID        string        `json:"id"`
+	Size      int64         `json:"size"`
+	Expires   time.Time     `json:"expires,omitempty"`
+	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+}
+
+// SanitizeMOTD strips control characters, apart from newlines, from the message of the day and truncates it to
+// MAX_MOTD_LENGTH characters, such that a rendezvous server cannot inject terminal escape sequences.
+func SanitizeMOTD(motd string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.TrimSpace(motd) {
+		if n == MAX_MOTD_LENGTH {
+			break
+		}
+		if unicode.IsControl(r) && r != '\n' {
+			continue
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return strings.TrimSpace(b.String())
 }
 
 type Error struct {
@@ -75,6 +296,16 @@ func (t MsgType) Name() string {
 		return "ReceiverToRendezvousClose"
 	case SenderToRendezvousClose:
 		return "SenderToRendezvousClose"
+	case RendezvousToSenderRestart:
+		return "RendezvousToSenderRestart"
+	case SenderToRendezvousResume:
+		return "SenderToRendezvousResume"
+	case RendezvousToSenderResumed:
+		return "RendezvousToSenderResumed"
+	case RendezvousToPeerIdle:
+		return "RendezvousToPeerIdle"
+	case RendezvousToPeerClosing:
+		return "RendezvousToPeerClosing"
 	default:
 		return ""
 	}
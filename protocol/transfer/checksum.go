@@ -0,0 +1,41 @@
+// checksum.go specifies the checksum algorithms proving the integrity of the payload that can be negotiated.
+package transfer
+
+import "fmt"
+
+// Checksum algorithms of the payload.
+const (
+	CHECKSUM_SHA256 = "sha256"
+	CHECKSUM_BLAKE3 = "blake3"
+	CHECKSUM_XXHASH = "xxhash"
+)
+
+// Checksums are the checksum algorithms this client can verify, advertised by receivers during the handshake.
+var Checksums = []string{CHECKSUM_SHA256, CHECKSUM_BLAKE3, CHECKSUM_XXHASH}
+
+// ValidateChecksum checks that the provided algorithm is a known checksum algorithm.
+func ValidateChecksum(algorithm string) error {
+	for _, c := range Checksums {
+		if c == algorithm {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown checksum algorithm '%s', must be one of %v", algorithm, Checksums)
+}
+
+// NegotiateChecksum returns the checksum algorithm of the payload, the proposed algorithm if the receiver
+// advertises it and CHECKSUM_SHA256 otherwise. Receivers that predate checksums advertise no algorithms,
+// and are sent no checksum.
+func NegotiateChecksum(proposed string, advertised []string) string {
+	var sha256 bool
+	for _, c := range advertised {
+		if c == proposed {
+			return proposed
+		}
+		sha256 = sha256 || c == CHECKSUM_SHA256
+	}
+	if sha256 {
+		return CHECKSUM_SHA256
+	}
+	return ""
+}
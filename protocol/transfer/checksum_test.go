@@ -0,0 +1,22 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateChecksum(t *testing.T) {
+	for _, algorithm := range transfer.Checksums {
+		assert.Equal(t, algorithm, transfer.NegotiateChecksum(algorithm, transfer.Checksums))
+	}
+	assert.Equal(t, transfer.CHECKSUM_SHA256, transfer.NegotiateChecksum(transfer.CHECKSUM_BLAKE3, []string{transfer.CHECKSUM_SHA256}))
+	// legacy receivers are sent no checksum.
+	assert.Empty(t, transfer.NegotiateChecksum(transfer.CHECKSUM_SHA256, nil))
+}
+
+func TestValidateChecksum(t *testing.T) {
+	assert.NoError(t, transfer.ValidateChecksum(transfer.CHECKSUM_XXHASH))
+	assert.Error(t, transfer.ValidateChecksum("md5"))
+}
@@ -0,0 +1,111 @@
+// chunksize.go specifies how the chunk size of the payload transfer is negotiated.
+package transfer
+
+import "time"
+
+const (
+	// Bounds of the chunk size that can be negotiated for the payload transfer.
+	MIN_CHUNK_BYTES = 256e3
+	MAX_CHUNK_BYTES = 8e6
+
+	// Assumed throughput used to estimate the bandwidth-delay product of the link.
+	ASSUMED_BYTES_PER_SECOND = 12.5e6
+
+	// Round trip times above this threshold are considered unreliable measurements.
+	MAX_RELIABLE_RTT = 5 * time.Second
+)
+
+// CHUNK_SIZE_ADAPTIVE is the chunk size asking the sender to adapt the chunk size to the link during the transfer.
+// Senders predating adaptive chunks fall back to their default chunk size.
+const CHUNK_SIZE_ADAPTIVE = -1
+
+// TARGET_CHUNK_DURATION is the time adaptive chunks are sized to take to send, or the round trip time of the link
+// if longer, such that progress is reported steadily on slow links and the overhead per chunk is amortized on fast
+// links.
+const TARGET_CHUNK_DURATION = 250 * time.Millisecond
+
+// weight of the latest measurement in the smoothed throughput of adaptive chunks.
+const throughputSmoothing = 0.25
+
+// ChunkSizeForRTT proposes a chunk size based on the measured round trip time of the link,
+// such that high latency links use larger chunks. Returns 0 if the measurement is unreliable,
+// in which case the sender falls back to its default chunk size.
+func ChunkSizeForRTT(rtt time.Duration) int64 {
+	if rtt <= 0 || rtt > MAX_RELIABLE_RTT {
+		return 0
+	}
+	return ClampChunkSize(int64(rtt.Seconds() * ASSUMED_BYTES_PER_SECOND))
+}
+
+// ChunkStats reports the chunk size of a transfer adapting its chunks, and the throughput it was adapted to.
+type ChunkStats struct {
+	Size           int64
+	BytesPerSecond float64
+}
+
+// AdaptiveChunkSize sizes the chunks of a transfer to the throughput measured while they are sent. Chunks grow or
+// shrink by at most a factor of two per chunk, such that a single stalled chunk does not collapse the chunk size.
+type AdaptiveChunkSize struct {
+	size       int64
+	rtt        time.Duration
+	throughput float64 // smoothed bytes per second
+}
+
+// NewAdaptiveChunkSize returns an adaptive chunk size starting out at the initial size, sized to cover at least the
+// provided round trip time of the link. An rtt of 0 is not taken into account.
+func NewAdaptiveChunkSize(initial int64, rtt time.Duration) *AdaptiveChunkSize {
+	if rtt > MAX_RELIABLE_RTT {
+		rtt = 0
+	}
+	return &AdaptiveChunkSize{size: ClampChunkSize(initial), rtt: rtt}
+}
+
+// Size returns the size of the next chunk.
+func (a *AdaptiveChunkSize) Size() int64 {
+	return a.size
+}
+
+// Stats returns the current chunk size and throughput.
+func (a *AdaptiveChunkSize) Stats() ChunkStats {
+	return ChunkStats{Size: a.size, BytesPerSecond: a.throughput}
+}
+
+// Observe records that a chunk of n bytes took elapsed to send, resizing the next chunk to the smoothed throughput.
+func (a *AdaptiveChunkSize) Observe(n int64, elapsed time.Duration) {
+	if n <= 0 {
+		return
+	}
+	if elapsed < time.Microsecond {
+		elapsed = time.Microsecond
+	}
+	rate := float64(n) / elapsed.Seconds()
+	if a.throughput == 0 {
+		a.throughput = rate
+	} else {
+		a.throughput += throughputSmoothing * (rate - a.throughput)
+	}
+	target := TARGET_CHUNK_DURATION
+	if a.rtt > target {
+		target = a.rtt
+	}
+	size := int64(a.throughput * target.Seconds())
+	switch {
+	case size > 2*a.size:
+		size = 2 * a.size
+	case size < a.size/2:
+		size = a.size / 2
+	}
+	a.size = ClampChunkSize(size)
+}
+
+// ClampChunkSize clamps the provided chunk size to the negotiable bounds.
+func ClampChunkSize(size int64) int64 {
+	switch {
+	case size < MIN_CHUNK_BYTES:
+		return MIN_CHUNK_BYTES
+	case size > MAX_CHUNK_BYTES:
+		return MAX_CHUNK_BYTES
+	default:
+		return size
+	}
+}
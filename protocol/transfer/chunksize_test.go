@@ -0,0 +1,75 @@
+package transfer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkSizeForRTT(t *testing.T) {
+	t.Run("unreliable", func(t *testing.T) {
+		assert.Zero(t, transfer.ChunkSizeForRTT(0))
+		assert.Zero(t, transfer.ChunkSizeForRTT(-time.Millisecond))
+		assert.Zero(t, transfer.ChunkSizeForRTT(transfer.MAX_RELIABLE_RTT+time.Second))
+	})
+	t.Run("within bounds", func(t *testing.T) {
+		var prev int64
+		for _, rtt := range []time.Duration{
+			100 * time.Microsecond,
+			time.Millisecond,
+			20 * time.Millisecond,
+			80 * time.Millisecond,
+			250 * time.Millisecond,
+			time.Second,
+			transfer.MAX_RELIABLE_RTT,
+		} {
+			size := transfer.ChunkSizeForRTT(rtt)
+			assert.GreaterOrEqual(t, size, int64(transfer.MIN_CHUNK_BYTES), rtt)
+			assert.LessOrEqual(t, size, int64(transfer.MAX_CHUNK_BYTES), rtt)
+			assert.GreaterOrEqual(t, size, prev, "chunk size should grow with rtt")
+			prev = size
+		}
+	})
+	t.Run("floor and ceiling", func(t *testing.T) {
+		assert.Equal(t, int64(transfer.MIN_CHUNK_BYTES), transfer.ChunkSizeForRTT(time.Millisecond))
+		assert.Equal(t, int64(transfer.MAX_CHUNK_BYTES), transfer.ChunkSizeForRTT(2*time.Second))
+	})
+}
+
+func TestAdaptiveChunkSize(t *testing.T) {
+	t.Run("grows on fast links", func(t *testing.T) {
+		chunks := transfer.NewAdaptiveChunkSize(transfer.MIN_CHUNK_BYTES, 0)
+		prev := chunks.Size()
+		for i := 0; i < 10; i++ {
+			// 1 GB/s
+			chunks.Observe(chunks.Size(), time.Duration(chunks.Size())*time.Nanosecond)
+			assert.LessOrEqual(t, chunks.Size(), 2*prev, "chunks should at most double")
+			prev = chunks.Size()
+		}
+		assert.Equal(t, int64(transfer.MAX_CHUNK_BYTES), chunks.Size())
+		assert.InDelta(t, 1e9, chunks.Stats().BytesPerSecond, 1e6)
+	})
+	t.Run("shrinks on slow links", func(t *testing.T) {
+		chunks := transfer.NewAdaptiveChunkSize(transfer.MAX_CHUNK_BYTES, 0)
+		for i := 0; i < 10; i++ {
+			// 100 kB/s
+			chunks.Observe(chunks.Size(), time.Duration(chunks.Size())*10*time.Microsecond)
+		}
+		assert.Equal(t, int64(transfer.MIN_CHUNK_BYTES), chunks.Size())
+	})
+	t.Run("covers the round trip time", func(t *testing.T) {
+		chunks := transfer.NewAdaptiveChunkSize(transfer.MIN_CHUNK_BYTES, time.Second)
+		for i := 0; i < 10; i++ {
+			// 2 MB/s, sent in chunks taking a round trip rather than TARGET_CHUNK_DURATION.
+			chunks.Observe(chunks.Size(), time.Duration(chunks.Size())*500*time.Nanosecond)
+		}
+		assert.InDelta(t, 2e6, chunks.Size(), 1e4)
+	})
+	t.Run("stalled chunk", func(t *testing.T) {
+		chunks := transfer.NewAdaptiveChunkSize(4e6, 0)
+		chunks.Observe(4e6, time.Minute)
+		assert.Equal(t, int64(2e6), chunks.Size(), "chunks should at most halve")
+	})
+}
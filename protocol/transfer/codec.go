@@ -0,0 +1,70 @@
+// codec.go specifies the compression codecs of the payload that can be negotiated.
+package transfer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Compression codecs of the payload.
+const (
+	CODEC_GZIP   = "gzip"
+	CODEC_ZSTD   = "zstd"
+	CODEC_BROTLI = "brotli"
+	// CODEC_NONE sends the archive uncompressed, such that its size is known before it is packed.
+	CODEC_NONE = "none"
+	// CODEC_AUTO negotiates the codec with the receiver, see NegotiateCodec.
+	CODEC_AUTO = "auto"
+)
+
+// Codecs are the compression codecs this client can decompress, advertised by receivers during the handshake.
+var Codecs = []string{CODEC_GZIP, CODEC_ZSTD, CODEC_BROTLI, CODEC_NONE}
+
+// AUTO_CODECS are the codecs negotiated by CODEC_AUTO, in order of preference. Brotli is left out as it compresses
+// too slowly to keep up with fast links.
+var AUTO_CODECS = []string{CODEC_ZSTD, CODEC_GZIP}
+
+// ErrNoCommonCodec is returned when none of the codecs of the sender are advertised by the receiver.
+var ErrNoCommonCodec = errors.New("no compression codec supported by both peers")
+
+// Compression describes the compression of the payload announced by the sender, with the size of the files before
+// compression in RawSize, zero if unknown.
+type Compression struct {
+	Codec   string
+	RawSize int64
+}
+
+// ValidateCodec checks that the provided codec is a known compression codec.
+func ValidateCodec(codec string) error {
+	for _, c := range Codecs {
+		if c == codec {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown compression codec '%s', must be one of %v", codec, Codecs)
+}
+
+// SupportsCodec reports whether a receiver advertising the provided codecs can decompress the codec.
+// Receivers that predate codec negotiation advertise no codecs, and only decompress gzip.
+func SupportsCodec(advertised []string, codec string) bool {
+	if len(advertised) == 0 {
+		return codec == CODEC_GZIP
+	}
+	for _, c := range advertised {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateCodec returns the first of the supported codecs, in order of preference, that a receiver advertising the
+// provided codecs can decompress, or a ErrNoCommonCodec if there is none.
+func NegotiateCodec(supported, advertised []string) (string, error) {
+	for _, codec := range supported {
+		if SupportsCodec(advertised, codec) {
+			return codec, nil
+		}
+	}
+	return "", fmt.Errorf("%w, sender supports %v, receiver supports %v", ErrNoCommonCodec, supported, advertised)
+}
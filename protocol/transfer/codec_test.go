@@ -0,0 +1,57 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportsCodec(t *testing.T) {
+	t.Run("negotiated", func(t *testing.T) {
+		for _, codec := range transfer.Codecs {
+			assert.True(t, transfer.SupportsCodec(transfer.Codecs, codec), codec)
+		}
+		assert.False(t, transfer.SupportsCodec([]string{transfer.CODEC_GZIP, transfer.CODEC_ZSTD}, transfer.CODEC_BROTLI))
+		assert.False(t, transfer.SupportsCodec([]string{transfer.CODEC_ZSTD}, transfer.CODEC_GZIP))
+	})
+	t.Run("legacy receiver", func(t *testing.T) {
+		assert.True(t, transfer.SupportsCodec(nil, transfer.CODEC_GZIP))
+		assert.False(t, transfer.SupportsCodec(nil, transfer.CODEC_ZSTD))
+		assert.False(t, transfer.SupportsCodec(nil, transfer.CODEC_NONE))
+	})
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	t.Run("preferred", func(t *testing.T) {
+		codec, err := transfer.NegotiateCodec(transfer.AUTO_CODECS, transfer.Codecs)
+		assert.NoError(t, err)
+		assert.Equal(t, transfer.CODEC_ZSTD, codec)
+	})
+	t.Run("intersection", func(t *testing.T) {
+		codec, err := transfer.NegotiateCodec(transfer.AUTO_CODECS, []string{transfer.CODEC_BROTLI, transfer.CODEC_GZIP})
+		assert.NoError(t, err)
+		assert.Equal(t, transfer.CODEC_GZIP, codec)
+
+		codec, err = transfer.NegotiateCodec([]string{transfer.CODEC_BROTLI, transfer.CODEC_ZSTD}, []string{transfer.CODEC_ZSTD, transfer.CODEC_BROTLI})
+		assert.NoError(t, err)
+		assert.Equal(t, transfer.CODEC_BROTLI, codec, "the preference of the sender wins")
+	})
+	t.Run("legacy receiver", func(t *testing.T) {
+		codec, err := transfer.NegotiateCodec(transfer.AUTO_CODECS, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, transfer.CODEC_GZIP, codec)
+	})
+	t.Run("no common codec", func(t *testing.T) {
+		_, err := transfer.NegotiateCodec(transfer.AUTO_CODECS, []string{transfer.CODEC_BROTLI, transfer.CODEC_NONE})
+		assert.ErrorIs(t, err, transfer.ErrNoCommonCodec)
+
+		_, err = transfer.NegotiateCodec([]string{transfer.CODEC_ZSTD}, nil)
+		assert.ErrorIs(t, err, transfer.ErrNoCommonCodec)
+	})
+}
+
+func TestValidateCodec(t *testing.T) {
+	assert.NoError(t, transfer.ValidateCodec(transfer.CODEC_BROTLI))
+	assert.Error(t, transfer.ValidateCodec("lz4"))
+}
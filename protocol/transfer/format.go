@@ -0,0 +1,24 @@
+// format.go specifies the packaging formats of the objects of the payload, besides tar, that can be negotiated.
+package transfer
+
+// Packaging formats of the objects of the payload.
+const (
+	FORMAT_ZIP = "zip" // directories packed as zip files
+	// FORMAT_RAW sends a raw byte stream of unknown size, e.g. piped to the sender, rather than an archive.
+	FORMAT_RAW = "raw"
+)
+
+// Formats are the packaging formats this client can unpack, advertised by receivers during the handshake. Only
+// receivers writing the payload as is, rather than unpacking it, advertise FORMAT_RAW besides them.
+var Formats = []string{FORMAT_ZIP}
+
+// SupportsFormat reports whether a receiver advertising the provided formats can unpack the format.
+// Receivers that predate format negotiation advertise no formats.
+func SupportsFormat(advertised []string, format string) bool {
+	for _, f := range advertised {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportsFormat(t *testing.T) {
+	assert.True(t, transfer.SupportsFormat(transfer.Formats, transfer.FORMAT_ZIP))
+	// raw streams cannot be unpacked.
+	assert.False(t, transfer.SupportsFormat(transfer.Formats, transfer.FORMAT_RAW))
+	// legacy receivers only unpack tar archives.
+	assert.False(t, transfer.SupportsFormat(nil, transfer.FORMAT_ZIP))
+	assert.False(t, transfer.SupportsFormat(nil, transfer.FORMAT_RAW))
+}
@@ -0,0 +1,24 @@
+// resume.go specifies how a transfer interrupted in a previous session is resumed at the file level.
+package transfer
+
+// Resume describes the files a receiver kept from a transfer interrupted in a previous session, sent in the
+// receiver handshake such that the sender only sends the rest. Files are identified by their name in the
+// archive, and the sender packs files in a deterministic order, so the partial file resumes where it stopped.
+type Resume struct {
+	// ID is the transfer id announced by the sender of the interrupted transfer, see Payload.TransferID. Senders
+	// announcing another id send their payload in full, and receivers start over.
+	ID string `json:"id,omitempty"`
+	// Completed are the files received in full.
+	Completed []ResumedFile `json:"completed,omitempty"`
+	// Partial is the file that was being received when the transfer was interrupted, its Size is the
+	// offset the file resumes from.
+	Partial *ResumedFile `json:"partial,omitempty"`
+}
+
+// ResumedFile is a file kept by the receiver, the sender only skips files whose contents match.
+type ResumedFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded SHA-256 digest of the first Size bytes of the file.
+	SHA256 string `json:"sha256"`
+}
@@ -0,0 +1,9 @@
+// select.go specifies how receivers select the files of the payload they accept.
+package transfer
+
+// ManifestFile is a regular file of the payload, listed in the manifest the sender transmits to receivers
+// selecting the files they accept. Files are identified by their name in the archive.
+type ManifestFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
@@ -0,0 +1,64 @@
+// streams.go specifies how a payload is split over parallel streams.
+package transfer
+
+const (
+	// Maximum number of parallel streams a payload can be transferred over.
+	MAX_STREAMS = 8
+
+	// Minimum number of payload bytes carried by each parallel stream, smaller payloads use fewer streams.
+	MIN_STREAM_BYTES = 1 << 20
+)
+
+// Stream is a range of the payload transferred over a separate connection, secured with its own password.
+type Stream struct {
+	Password string `json:"password"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+}
+
+// NegotiateStreams returns the number of parallel streams used to transfer a payload of the provided size,
+// at most the number of streams proposed by either peer and MAX_STREAMS. A result of 1 means the payload
+// is transferred over the transfer connection itself.
+func NegotiateStreams(proposed, accepted int, payloadSize int64) int {
+	n := proposed
+	if accepted < n {
+		n = accepted
+	}
+	if n > MAX_STREAMS {
+		n = MAX_STREAMS
+	}
+	if max := payloadSize / MIN_STREAM_BYTES; int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// SplitStreams splits a payload of the provided size into n contiguous streams, the last stream
+// carrying the remainder. The passwords of the streams are left to the caller.
+func SplitStreams(payloadSize int64, n int) []Stream {
+	streams := make([]Stream, n)
+	length := payloadSize / int64(n)
+	for i := range streams {
+		streams[i] = Stream{Offset: int64(i) * length, Length: length}
+	}
+	streams[n-1].Length = payloadSize - streams[n-1].Offset
+	return streams
+}
+
+// ValidateStreams checks that the streams cover a payload of the provided size exactly once, in order.
+func ValidateStreams(streams []Stream, payloadSize int64) bool {
+	if len(streams) == 0 || len(streams) > MAX_STREAMS {
+		return false
+	}
+	var offset int64
+	for _, s := range streams {
+		if s.Offset != offset || s.Length <= 0 {
+			return false
+		}
+		offset += s.Length
+	}
+	return offset == payloadSize
+}
@@ -0,0 +1,30 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateStreams(t *testing.T) {
+	const size = 100 * transfer.MIN_STREAM_BYTES
+	assert.Equal(t, 1, transfer.NegotiateStreams(0, 4, size), "receivers that predate streams accept none")
+	assert.Equal(t, 3, transfer.NegotiateStreams(4, 3, size))
+	assert.Equal(t, 3, transfer.NegotiateStreams(3, 4, size))
+	assert.Equal(t, transfer.MAX_STREAMS, transfer.NegotiateStreams(64, 64, size))
+	assert.Equal(t, 2, transfer.NegotiateStreams(8, 8, 2*transfer.MIN_STREAM_BYTES+1))
+	assert.Equal(t, 1, transfer.NegotiateStreams(8, 8, transfer.MIN_STREAM_BYTES/2))
+}
+
+func TestSplitStreams(t *testing.T) {
+	for _, size := range []int64{3 * transfer.MIN_STREAM_BYTES, 3*transfer.MIN_STREAM_BYTES + 2} {
+		streams := transfer.SplitStreams(size, 3)
+		assert.Len(t, streams, 3)
+		assert.True(t, transfer.ValidateStreams(streams, size), size)
+	}
+	assert.False(t, transfer.ValidateStreams(nil, 0))
+	assert.False(t, transfer.ValidateStreams([]transfer.Stream{{Offset: 0, Length: 10}}, 20), "short of the payload")
+	assert.False(t, transfer.ValidateStreams([]transfer.Stream{{Offset: 0, Length: 10}, {Offset: 5, Length: 15}}, 20), "overlapping")
+	assert.False(t, transfer.ValidateStreams([]transfer.Stream{{Offset: 0, Length: 20}, {Offset: 20, Length: 0}}, 20), "empty")
+}
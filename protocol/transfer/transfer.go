@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 // MsgType specifies the message type for the messages in the transfer protocol.
@@ -23,8 +24,21 @@ const (
 	ReceiverPayloadAck         // Receiver ACKs that is has received the payload
 	SenderClosing              // Sender announces that it is closing the connection
 	ReceiverClosingAck         // Receiver ACKs the closing of the connection
+	SenderResume               // Sender resumed its lost connection to the rendezvous server, and asks where to resume the payload from
+	ReceiverResumeOffset       // Receiver announces the number of payload bytes it has received
+	SenderStreams              // Sender announces the parallel streams the payload is transferred over
+	ReceiverMigrate            // Receiver connected directly to the sender while relaying, and asks to migrate the transfer to the direct connection
+	SenderMigrate              // Sender migrates the transfer, no more payload is relayed after this message
+	SenderManifest             // Sender lists the files of the payload to a receiver selecting the files it accepts
+	ReceiverSelection          // Receiver announces the files of the manifest it accepts
+	SenderSequenced            // Sender announces that the chunks of the payload are sequenced, see EncodeChunk
+	ReceiverChunkAck           // Receiver acknowledges the sequenced chunks received in order
+	ReceiverChunkNak           // Receiver asks for the sequenced chunks it is missing to be retransmitted
 )
 
+// MIGRATED is the close reason of relayed connections left after the transfer migrated to a direct connection.
+const MIGRATED = "transfer migrated to a direct connection"
+
 type Type int
 
 const (
@@ -40,9 +54,58 @@ type Msg struct {
 }
 
 type Payload struct {
-	IP          net.IP `json:"ip,omitempty"`
-	Port        int    `json:"port,omitempty"`
-	PayloadSize int64  `json:"payload_size,omitempty"`
+	// IP and Port are the IPv4 address and the port the sender accepts direct connections on, and Candidates every
+	// address, host:port, it accepts them on, IPv6 addresses first. Senders predating candidates only announce IP.
+	IP         net.IP   `json:"ip,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+	// Transports are the transports of direct connections the receiver accepts, and QUICCandidates the addresses
+	// the sender accepts direct QUIC connections on, websocket connections being accepted on Candidates.
+	Transports     []string `json:"transports,omitempty"`
+	QUICCandidates []string `json:"quic_candidates,omitempty"`
+	// PayloadSize is the size of the payload in bytes, zero if unknown, e.g. for raw streams.
+	PayloadSize int64 `json:"payload_size,omitempty"`
+	// Codec is the compression codec of the payload announced by the sender, and RawSize the size of its files
+	// before compression, both unset if unknown.
+	Codec   string `json:"codec,omitempty"`
+	RawSize int64  `json:"raw_size,omitempty"`
+	// ChunkSize is the chunk size proposed by the receiver, CHUNK_SIZE_ADAPTIVE to ask the sender to adapt it to the
+	// link, and RTT the round trip time of the link measured by the receiver during the handshake.
+	ChunkSize int64         `json:"chunk_size,omitempty"`
+	RTT       time.Duration `json:"rtt,omitempty"`
+	// Codecs are the compression codecs the receiver can decompress.
+	Codecs []string `json:"codecs,omitempty"`
+	// Formats are the packaging formats, besides tar, the receiver can unpack.
+	Formats []string `json:"formats,omitempty"`
+	// Checksums are the checksum algorithms the receiver can verify, Checksum the algorithm negotiated by
+	// the sender, and Digest the hex encoded checksum of the payload sent.
+	Checksums []string `json:"checksums,omitempty"`
+	Checksum  string   `json:"checksum,omitempty"`
+	Digest    string   `json:"digest,omitempty"`
+	// Offset is the number of payload bytes received, and PayloadAcked whether the receiver
+	// has already acknowledged the complete payload, when resuming.
+	Offset       int64 `json:"offset,omitempty"`
+	PayloadAcked bool  `json:"payload_acked,omitempty"`
+	// MaxStreams is the number of parallel streams the receiver accepts, and Streams
+	// the streams the sender transfers the payload over.
+	MaxStreams int      `json:"max_streams,omitempty"`
+	Streams    []Stream `json:"streams,omitempty"`
+	// Resume describes the files the receiver kept from a transfer interrupted in a previous session, and
+	// TransferID identifies the payload of the sender across sessions, such that only transfers of the same
+	// payload are resumed.
+	Resume     *Resume `json:"resume,omitempty"`
+	TransferID string  `json:"transfer_id,omitempty"`
+	// Select is whether the receiver asks for the manifest of the payload to select the files it accepts,
+	// Manifest the files of the payload, and Selected the names of the files the receiver accepts.
+	Select   bool           `json:"select,omitempty"`
+	Manifest []ManifestFile `json:"manifest,omitempty"`
+	Selected []string       `json:"selected,omitempty"`
+	// Window is the number of sequenced chunks the receiver holds out of order, and the sender keeps
+	// unacknowledged. Sequence is the number of chunks received in order, or sent, and Missing the sequence
+	// numbers of the chunks to retransmit.
+	Window   int      `json:"window,omitempty"`
+	Sequence uint64   `json:"sequence,omitempty"`
+	Missing  []uint64 `json:"missing,omitempty"`
 }
 
 func (t Msg) Bytes() []byte {
@@ -85,6 +148,26 @@ func (t MsgType) Name() string {
 		return "SenderClosing"
 	case ReceiverClosingAck:
 		return "ReceiverClosingAck"
+	case SenderResume:
+		return "SenderResume"
+	case ReceiverResumeOffset:
+		return "ReceiverResumeOffset"
+	case SenderStreams:
+		return "SenderStreams"
+	case ReceiverMigrate:
+		return "ReceiverMigrate"
+	case SenderMigrate:
+		return "SenderMigrate"
+	case SenderManifest:
+		return "SenderManifest"
+	case ReceiverSelection:
+		return "ReceiverSelection"
+	case SenderSequenced:
+		return "SenderSequenced"
+	case ReceiverChunkAck:
+		return "ReceiverChunkAck"
+	case ReceiverChunkNak:
+		return "ReceiverChunkNak"
 	default:
 		return ""
 	}
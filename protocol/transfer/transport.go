@@ -0,0 +1,58 @@
+// transport.go specifies the transports of direct connections to the sender that can be negotiated.
+package transfer
+
+import "fmt"
+
+// Transports of direct connections to the sender.
+const (
+	TRANSPORT_QUIC      = "quic"
+	TRANSPORT_WEBSOCKET = "websocket"
+	// TRANSPORT_AUTO connects over QUIC, falling back to websocket if QUIC cannot connect, e.g. as UDP is blocked.
+	TRANSPORT_AUTO = "auto"
+)
+
+// Transports are the transports of direct connections this client can dial, in order of preference.
+var Transports = []string{TRANSPORT_QUIC, TRANSPORT_WEBSOCKET}
+
+// ValidateTransport checks that the provided transport is TRANSPORT_AUTO or one of Transports.
+func ValidateTransport(transport string) error {
+	if transport == TRANSPORT_AUTO || SupportsTransport(Transports, transport) {
+		return nil
+	}
+	return fmt.Errorf("unknown transport '%s', must be one of %v", transport, append([]string{TRANSPORT_AUTO}, Transports...))
+}
+
+// AcceptedTransports returns the transports of direct connections used with the provided transport, advertised by
+// receivers during the handshake: every one of Transports for TRANSPORT_AUTO, and the transport alone otherwise.
+func AcceptedTransports(transport string) []string {
+	if transport == TRANSPORT_AUTO {
+		return Transports
+	}
+	return []string{transport}
+}
+
+// NegotiateTransports returns the transports the sender accepts direct connections over with the provided
+// transport, those of AcceptedTransports the receiver advertises. Receivers that predate transport negotiation
+// advertise no transports, and are only connected over websocket.
+func NegotiateTransports(transport string, advertised []string) []string {
+	if len(advertised) == 0 {
+		advertised = []string{TRANSPORT_WEBSOCKET}
+	}
+	var negotiated []string
+	for _, t := range AcceptedTransports(transport) {
+		if SupportsTransport(advertised, t) {
+			negotiated = append(negotiated, t)
+		}
+	}
+	return negotiated
+}
+
+// SupportsTransport reports whether the provided transports hold the transport.
+func SupportsTransport(transports []string, transport string) bool {
+	for _, t := range transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateTransports(t *testing.T) {
+	assert.Equal(t, transfer.Transports, transfer.NegotiateTransports(transfer.TRANSPORT_AUTO, transfer.Transports))
+	assert.Equal(t, []string{transfer.TRANSPORT_QUIC}, transfer.NegotiateTransports(transfer.TRANSPORT_QUIC, transfer.Transports))
+	assert.Equal(t, []string{transfer.TRANSPORT_WEBSOCKET}, transfer.NegotiateTransports(transfer.TRANSPORT_AUTO, []string{transfer.TRANSPORT_WEBSOCKET}))
+	// legacy receivers are only connected over websocket.
+	assert.Equal(t, []string{transfer.TRANSPORT_WEBSOCKET}, transfer.NegotiateTransports(transfer.TRANSPORT_AUTO, nil))
+	assert.Empty(t, transfer.NegotiateTransports(transfer.TRANSPORT_QUIC, nil))
+}
+
+func TestValidateTransport(t *testing.T) {
+	assert.NoError(t, transfer.ValidateTransport(transfer.TRANSPORT_AUTO))
+	assert.NoError(t, transfer.ValidateTransport(transfer.TRANSPORT_QUIC))
+	assert.Error(t, transfer.ValidateTransport("tcp"))
+	assert.Error(t, transfer.ValidateTransport(""))
+}
@@ -0,0 +1,56 @@
+// window.go specifies the sequencing of the chunks of relayed payloads, such that chunks lost by lossy relays are
+// retransmitted selectively rather than resuming the transfer.
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// MAX_WINDOW is the maximum number of sequenced chunks held out of order by the receiver.
+	MAX_WINDOW = 1024
+
+	// MAX_WINDOW_BYTES bounds the bytes of the chunks held by either peer, the window is narrowed for large chunks.
+	MAX_WINDOW_BYTES = 64 << 20
+
+	// SEQUENCE_BYTES is the size of the sequence number prefixing the sequenced chunks.
+	SEQUENCE_BYTES = 8
+)
+
+// ErrMalformedChunk is returned when decoding a sequenced chunk without a sequence number.
+var ErrMalformedChunk = errors.New("sequenced chunk without a sequence number")
+
+// NegotiateWindow returns the window used to sequence the chunks of the provided size, the window proposed by
+// the receiver capped to MAX_WINDOW and MAX_WINDOW_BYTES. A result of 0 means the chunks are not sequenced.
+func NegotiateWindow(proposed int, chunkSize int64) int {
+	if proposed <= 0 {
+		return 0
+	}
+	n := proposed
+	if n > MAX_WINDOW {
+		n = MAX_WINDOW
+	}
+	if chunkSize > 0 && int64(n) > MAX_WINDOW_BYTES/chunkSize {
+		n = int(MAX_WINDOW_BYTES / chunkSize)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// EncodeChunk prefixes the chunk with its sequence number.
+func EncodeChunk(seq uint64, chunk []byte) []byte {
+	b := make([]byte, SEQUENCE_BYTES, SEQUENCE_BYTES+len(chunk))
+	binary.BigEndian.PutUint64(b, seq)
+	return append(b, chunk...)
+}
+
+// DecodeChunk splits the sequenced chunk into its sequence number and the chunk.
+func DecodeChunk(b []byte) (uint64, []byte, error) {
+	if len(b) < SEQUENCE_BYTES {
+		return 0, nil, ErrMalformedChunk
+	}
+	return binary.BigEndian.Uint64(b), b[SEQUENCE_BYTES:], nil
+}
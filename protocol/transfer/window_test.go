@@ -0,0 +1,29 @@
+package transfer_test
+
+import (
+	"testing"
+
+	"github.com/SpatiumPortae/portal/protocol/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateWindow(t *testing.T) {
+	assert.Zero(t, transfer.NegotiateWindow(0, transfer.MIN_CHUNK_BYTES))
+	assert.Zero(t, transfer.NegotiateWindow(-1, transfer.MIN_CHUNK_BYTES))
+	assert.Equal(t, 64, transfer.NegotiateWindow(64, transfer.MIN_CHUNK_BYTES))
+	assert.Equal(t, transfer.MAX_WINDOW, transfer.NegotiateWindow(transfer.MAX_WINDOW+1, 1024))
+	// large chunks narrow the window to bound the bytes held.
+	assert.Equal(t, int(transfer.MAX_WINDOW_BYTES/int64(transfer.MAX_CHUNK_BYTES)), transfer.NegotiateWindow(64, transfer.MAX_CHUNK_BYTES))
+	assert.Equal(t, 1, transfer.NegotiateWindow(64, 2*transfer.MAX_WINDOW_BYTES))
+}
+
+func TestChunkEncoding(t *testing.T) {
+	seq, chunk, err := transfer.DecodeChunk(transfer.EncodeChunk(1<<40+7, []byte("frog")))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1<<40+7), seq)
+	assert.Equal(t, []byte("frog"), chunk)
+
+	_, _, err = transfer.DecodeChunk([]byte{0, 1})
+	assert.ErrorIs(t, err, transfer.ErrMalformedChunk)
+}